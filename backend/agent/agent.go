@@ -13,6 +13,7 @@ import (
 	"runtime"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/shirou/gopsutil/host"
@@ -20,6 +21,7 @@ import (
 	"github.com/shirou/gopsutil/mem"
 	log "github.com/sirupsen/logrus"
 	"github.com/urfave/cli/v2"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/security/advancedtls"
 
@@ -32,10 +34,18 @@ type StorkAgent struct {
 	Settings   *cli.Context
 	AppMonitor AppMonitor
 
-	HTTPClient     *HTTPClient // to communicate with Kea Control Agent and named statistics-channel
-	server         *grpc.Server
-	logTailer      *logTailer
-	keaInterceptor *keaInterceptor
+	HTTPClient      *HTTPClient      // to communicate with Kea Control Agent and named statistics-channel
+	KeaSocketClient *KeaSocketClient // to communicate with Kea daemons over control sockets
+	server          *grpc.Server
+	logTailer       *logTailer
+	keaInterceptor  *keaInterceptor
+	certRenewer     *certRenewer
+	revokedCerts    *revokedCerts
+	tunnelCancel    context.CancelFunc // stops the tunnel to the server
+	tunnelDone      chan struct{}      // closed when the tunnel is stopped
+
+	// Formats served by the named statistics-channels.
+	namedStatsFormats namedStatsFormats
 
 	agentapi.UnimplementedAgentServer
 }
@@ -44,12 +54,20 @@ type StorkAgent struct {
 func NewStorkAgent(settings *cli.Context, appMonitor AppMonitor) *StorkAgent {
 	logTailer := newLogTailer()
 
+	revokedCerts, err := loadRevokedCerts()
+	if err != nil {
+		log.Warnf("Failed to load revoked certificates: %+v", err)
+	}
+
 	sa := &StorkAgent{
-		Settings:       settings,
-		AppMonitor:     appMonitor,
-		HTTPClient:     NewHTTPClient(settings.Bool("skip-tls-cert-verification")),
-		logTailer:      logTailer,
-		keaInterceptor: newKeaInterceptor(),
+		Settings:        settings,
+		AppMonitor:      appMonitor,
+		HTTPClient:      NewHTTPClient(settings.Bool("skip-tls-cert-verification")),
+		KeaSocketClient: NewKeaSocketClient(),
+		logTailer:       logTailer,
+		keaInterceptor:  newKeaInterceptor(),
+		certRenewer:     newCertRenewer(settings.Duration("cert-renewal-before")),
+		revokedCerts:    revokedCerts,
 	}
 
 	registerKeaInterceptFns(sa)
@@ -102,8 +120,9 @@ func getIdentityCertificatesForServer(info *tls.ClientHelloInfo) ([]*tls.Certifi
 	return []*tls.Certificate{&certificate}, nil
 }
 
-// Prepare gRPC server with configured TLS.
-func newGRPCServerWithTLS() (*grpc.Server, error) {
+// Prepare gRPC server with configured TLS. The clients presenting the
+// revoked certificates are refused.
+func newGRPCServerWithTLS(revokedCerts *revokedCerts) (*grpc.Server, error) {
 	// Prepare structure for advanced TLS. It defines hook functions
 	// that dynamically load key and cert from files just before establishing
 	// connection. Thanks to this if these files changed in meantime then
@@ -123,19 +142,26 @@ func newGRPCServerWithTLS() (*grpc.Server, error) {
 		RequireClientCert: true,
 		// check cert and if it matches host IP
 		VType: advancedtls.CertAndHostVerification,
+		// refuse the certs revoked by the stork server
+		VerifyPeer: revokedCerts.verifyPeer,
 	}
 	creds, err := advancedtls.NewServerCreds(options)
 	if err != nil {
 		return nil, errors.Wrapf(err, "cannot create server credentials for TLS")
 	}
 
-	srv := grpc.NewServer(grpc.Creds(creds))
+	// The interceptors continue the traces started by the server.
+	srv := grpc.NewServer(
+		grpc.Creds(creds),
+		grpc.UnaryInterceptor(otelgrpc.UnaryServerInterceptor()),
+		grpc.StreamInterceptor(otelgrpc.StreamServerInterceptor()),
+	)
 	return srv, nil
 }
 
 // Setup the agent as gRPC server endpoint.
 func (sa *StorkAgent) Setup() error {
-	server, err := newGRPCServerWithTLS()
+	server, err := newGRPCServerWithTLS(sa.revokedCerts)
 	if err != nil {
 		return err
 	}
@@ -198,6 +224,21 @@ func (sa *StorkAgent) GetState(ctx context.Context, in *agentapi.GetStateReq) (*
 		Error:                "",
 	}
 
+	// Report the certificate expiration time and request its renewal
+	// when it is about to expire or when the server requests it.
+	certNotAfter, csrPEM, err := sa.certRenewer.checkCert(time.Now(), in.RenewCert)
+	if err != nil {
+		log.Warnf("Failed to check agent certificate: %+v", err)
+	} else {
+		state.CertNotAfter = certNotAfter.Unix()
+		state.CertRenewalCSR = string(csrPEM)
+	}
+
+	// Refuse the connections using the certificates revoked by the server.
+	if err = sa.revokedCerts.merge(in.RevokedCertSerialNumbers); err != nil {
+		log.Warnf("Failed to update revoked certificates: %+v", err)
+	}
+
 	return &state, nil
 }
 
@@ -264,8 +305,9 @@ func (sa *StorkAgent) ForwardToNamedStats(ctx context.Context, in *agentapi.Forw
 		Status: &agentapi.Status{},
 	}
 
-	// Try to forward the command to named daemon.
-	namedRsp, err := sa.HTTPClient.Call(reqURL, bytes.NewBuffer([]byte(req.Request)))
+	// Try to forward the command to named daemon. If the JSON statistics
+	// are unavailable the XML statistics are converted to JSON.
+	body, err := sa.namedStatsFormats.getNamedStats(ctx, sa.HTTPClient, reqURL, []byte(req.Request))
 	if err != nil {
 		log.WithFields(log.Fields{
 			"URL": reqURL,
@@ -276,19 +318,6 @@ func (sa *StorkAgent) ForwardToNamedStats(ctx context.Context, in *agentapi.Forw
 		return response, nil
 	}
 
-	// Read the response body.
-	body, err := io.ReadAll(namedRsp.Body)
-	namedRsp.Body.Close()
-	if err != nil {
-		log.WithFields(log.Fields{
-			"URL": reqURL,
-		}).Errorf("Failed to read the body of the named response: %+v", err)
-		rsp.Status.Code = agentapi.Status_ERROR
-		rsp.Status.Message = fmt.Sprintf("Failed to read the body of the named response: %s", err.Error())
-		response.NamedStatsResponse = rsp
-		return response, nil
-	}
-
 	// Everything looks good, so include the body in the response.
 	rsp.Response = string(body)
 	rsp.Status.Code = agentapi.Status_OK
@@ -322,7 +351,7 @@ func (sa *StorkAgent) ForwardToKeaOverHTTP(ctx context.Context, in *agentapi.For
 			Status: &agentapi.Status{},
 		}
 		// Try to forward the command to Kea Control Agent.
-		keaRsp, err := sa.HTTPClient.Call(reqURL, bytes.NewBuffer([]byte(req.Request)))
+		keaRsp, err := sa.HTTPClient.Call(ctx, reqURL, bytes.NewBuffer([]byte(req.Request)))
 		if err != nil {
 			log.WithFields(log.Fields{
 				"URL": reqURL,
@@ -352,9 +381,7 @@ func (sa *StorkAgent) ForwardToKeaOverHTTP(ctx context.Context, in *agentapi.For
 		go sa.keaInterceptor.asyncHandle(sa, req, body)
 
 		// gzip json response received from Kea
-		var gzippedBuf bytes.Buffer
-		zw := gzip.NewWriter(&gzippedBuf)
-		_, err = zw.Write(body)
+		compressed, err := compressKeaResponse(body)
 		if err != nil {
 			log.WithFields(log.Fields{
 				"URL": reqURL,
@@ -362,26 +389,88 @@ func (sa *StorkAgent) ForwardToKeaOverHTTP(ctx context.Context, in *agentapi.For
 			rsp.Status.Code = agentapi.Status_ERROR
 			rsp.Status.Message = fmt.Sprintf("Failed to compress the Kea response: %s", err.Error())
 			response.KeaResponses = append(response.KeaResponses, rsp)
-			if err2 := zw.Close(); err2 != nil {
-				log.Errorf("error while closing gzip writer: %s", err2)
-			}
 			continue
 		}
-		if err := zw.Close(); err != nil {
+
+		// Everything looks good, so include the gzipped body in the response.
+		rsp.Response = compressed
+		rsp.Status.Code = agentapi.Status_OK
+		response.KeaResponses = append(response.KeaResponses, rsp)
+	}
+
+	return response, nil
+}
+
+// Compresses the response received from Kea with gzip.
+func compressKeaResponse(body []byte) ([]byte, error) {
+	var gzippedBuf bytes.Buffer
+	zw := gzip.NewWriter(&gzippedBuf)
+	_, err := zw.Write(body)
+	if err != nil {
+		if err2 := zw.Close(); err2 != nil {
+			log.Errorf("error while closing gzip writer: %s", err2)
+		}
+		return nil, errors.Wrap(err, "problem with compressing the response")
+	}
+	if err := zw.Close(); err != nil {
+		return nil, errors.Wrap(err, "problem with finishing the response compression")
+	}
+	if len(body) > 0 {
+		log.Printf("Compressing response from %d B to %d B, ratio %d%%", len(body), gzippedBuf.Len(), 100*gzippedBuf.Len()/len(body))
+	}
+	return gzippedBuf.Bytes(), nil
+}
+
+// Forwards one or more commands to the Kea daemon over its control socket.
+// The socket must belong to one of the detected Kea apps. Otherwise, the
+// commands are rejected to prevent the agent from connecting to arbitrary
+// sockets.
+func (sa *StorkAgent) ForwardToKeaOverSocket(ctx context.Context, in *agentapi.ForwardToKeaOverSocketReq) (*agentapi.ForwardToKeaOverSocketRsp, error) {
+	// prepare base response
+	response := &agentapi.ForwardToKeaOverSocketRsp{
+		Status: &agentapi.Status{
+			Code: agentapi.Status_OK, // all ok
+		},
+	}
+
+	socketPath := in.GetSocketPath()
+	if socketPath == "" || sa.AppMonitor.GetApp(AppTypeKea, AccessPointControlSocket, socketPath, 0) == nil {
+		response.Status.Code = agentapi.Status_ERROR
+		response.Status.Message = fmt.Sprintf("Unknown Kea control socket %s", socketPath)
+		return response, nil
+	}
+
+	// forward requests to kea one by one
+	for _, req := range in.GetKeaRequests() {
+		rsp := &agentapi.KeaResponse{
+			Status: &agentapi.Status{},
+		}
+		body, err := sa.KeaSocketClient.Call(socketPath, []byte(req.Request))
+		if err != nil {
 			log.WithFields(log.Fields{
-				"URL": reqURL,
-			}).Errorf("Failed to finish compressing the Kea response: %+v", err)
+				"socket": socketPath,
+			}).Errorf("Failed to forward commands to Kea: %+v", err)
 			rsp.Status.Code = agentapi.Status_ERROR
-			rsp.Status.Message = fmt.Sprintf("Failed to finish compressing the Kea response: %s", err.Error())
+			rsp.Status.Message = fmt.Sprintf("Failed to forward commands to Kea: %s", err.Error())
 			response.KeaResponses = append(response.KeaResponses, rsp)
 			continue
 		}
-		if len(body) > 0 {
-			log.Printf("Compressing response from %d B to %d B, ratio %d%%", len(body), gzippedBuf.Len(), 100*gzippedBuf.Len()/len(body))
+
+		// Push Kea response for async processing.
+		go sa.keaInterceptor.asyncHandle(sa, req, body)
+
+		compressed, err := compressKeaResponse(body)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"socket": socketPath,
+			}).Errorf("Failed to compress the Kea response: %+v", err)
+			rsp.Status.Code = agentapi.Status_ERROR
+			rsp.Status.Message = fmt.Sprintf("Failed to compress the Kea response: %s", err.Error())
+			response.KeaResponses = append(response.KeaResponses, rsp)
+			continue
 		}
 
-		// Everything looks good, so include the gzipped body in the response.
-		rsp.Response = gzippedBuf.Bytes()
+		rsp.Response = compressed
 		rsp.Status.Code = agentapi.Status_OK
 		response.KeaResponses = append(response.KeaResponses, rsp)
 	}
@@ -408,6 +497,95 @@ func (sa *StorkAgent) TailTextFile(ctx context.Context, in *agentapi.TailTextFil
 	return response, nil
 }
 
+// Follows the specified file, typically a log file, and streams the lines
+// appended to it until the stream is cancelled by the server. If the file
+// cannot be followed, the error status is sent and the stream is closed.
+func (sa *StorkAgent) FollowTextFile(in *agentapi.FollowTextFileReq, stream agentapi.Agent_FollowTextFileServer) error {
+	err := sa.logTailer.follow(stream.Context(), in.Path, in.Offset, func(lines []string) error {
+		return stream.Send(&agentapi.FollowTextFileRsp{
+			Status: &agentapi.Status{
+				Code: agentapi.Status_OK,
+			},
+			Lines: lines,
+		})
+	})
+	if err != nil {
+		log.WithFields(log.Fields{
+			"file": in.Path,
+		}).Warnf("Failed to follow the file: %+v", err)
+		return stream.Send(&agentapi.FollowTextFileRsp{
+			Status: &agentapi.Status{
+				Code:    agentapi.Status_ERROR,
+				Message: fmt.Sprintf("%s", err),
+			},
+		})
+	}
+	return nil
+}
+
+// Searches the specified file, typically a log file, for the lines matching
+// the criteria and streams the matches in batches. The last response holds
+// the total number of matches. If the file cannot be searched, the error
+// status is sent and the stream is closed.
+func (sa *StorkAgent) SearchTextFile(in *agentapi.SearchTextFileReq, stream agentapi.Agent_SearchTextFileServer) error {
+	sendError := func(err error) error {
+		log.WithFields(log.Fields{
+			"file": in.Path,
+		}).Warnf("Failed to search the file: %+v", err)
+		return stream.Send(&agentapi.SearchTextFileRsp{
+			Status: &agentapi.Status{
+				Code:    agentapi.Status_ERROR,
+				Message: fmt.Sprintf("%s", err),
+			},
+			Done: true,
+		})
+	}
+
+	pattern, err := compileLogSearchPattern(in.Pattern)
+	if err != nil {
+		return sendError(err)
+	}
+	criteria := &logSearchCriteria{
+		Pattern:      pattern,
+		ContextLines: int(in.ContextLines),
+		MaxMatches:   in.MaxMatches,
+	}
+	if in.Since != 0 {
+		criteria.Since = time.Unix(in.Since, 0)
+	}
+	if in.Until != 0 {
+		criteria.Until = time.Unix(in.Until, 0)
+	}
+
+	count, err := sa.logTailer.search(stream.Context(), in.Path, criteria, func(matches []*logMatch) error {
+		rsp := &agentapi.SearchTextFileRsp{
+			Status: &agentapi.Status{
+				Code: agentapi.Status_OK,
+			},
+		}
+		for _, m := range matches {
+			rsp.Matches = append(rsp.Matches, &agentapi.TextFileMatch{
+				LineNumber: m.LineNumber,
+				Line:       m.Line,
+				Before:     m.Before,
+				After:      m.After,
+			})
+		}
+		return stream.Send(rsp)
+	})
+	if err != nil {
+		return sendError(err)
+	}
+
+	return stream.Send(&agentapi.SearchTextFileRsp{
+		Status: &agentapi.Status{
+			Code: agentapi.Status_OK,
+		},
+		MatchCount: count,
+		Done:       true,
+	})
+}
+
 func (sa *StorkAgent) Serve() {
 	// Install gRPC API handlers.
 	agentapi.RegisterAgentServer(sa.server, sa)
@@ -430,6 +608,10 @@ func (sa *StorkAgent) Serve() {
 
 func (sa *StorkAgent) Shutdown() {
 	log.Infof("stopping StorkAgent")
+	if sa.tunnelCancel != nil {
+		sa.tunnelCancel()
+		<-sa.tunnelDone
+	}
 	if sa.server != nil {
 		sa.server.GracefulStop()
 	}
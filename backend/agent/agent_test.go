@@ -70,6 +70,8 @@ func setupAgentTest() (*StorkAgent, context.Context) {
 		HTTPClient:     httpClient,
 		logTailer:      newLogTailer(),
 		keaInterceptor: newKeaInterceptor(),
+		certRenewer:    newCertRenewer(0),
+		revokedCerts:   newRevokedCerts(),
 	}
 	sa.Setup()
 	ctx := context.Background()
@@ -320,6 +322,57 @@ func TestForwardToKeaOverHTTPNoKea(t *testing.T) {
 	require.Len(t, rsp.KeaResponses[0].Response, 0)
 }
 
+// Test successful forwarding commands to the Kea daemon over its
+// control socket.
+func TestForwardToKeaOverSocketSuccess(t *testing.T) {
+	sa, ctx := setupAgentTest()
+	sa.KeaSocketClient = NewKeaSocketClient()
+
+	socketPath := path.Join(t.TempDir(), "kea4-ctrl-socket")
+	_, stop := startFakeKeaSocketServer(t, socketPath, `{ "result": 0 }`)
+	defer stop()
+
+	fam, _ := sa.AppMonitor.(*FakeAppMonitor)
+	fam.Apps = append(fam.Apps, &KeaApp{
+		BaseApp: BaseApp{
+			Type:         AppTypeKea,
+			AccessPoints: makeAccessPoint(AccessPointControlSocket, socketPath, "", 0, false),
+		},
+		SocketClient: sa.KeaSocketClient,
+	})
+
+	req := &agentapi.ForwardToKeaOverSocketReq{
+		SocketPath:  socketPath,
+		KeaRequests: []*agentapi.KeaRequest{{Request: "{ \"command\": \"list-commands\"}"}},
+	}
+
+	rsp, err := sa.ForwardToKeaOverSocket(ctx, req)
+	require.NotNil(t, rsp)
+	require.NoError(t, err)
+	require.Equal(t, agentapi.Status_OK, rsp.Status.Code)
+	require.Len(t, rsp.KeaResponses, 1)
+	require.Equal(t, agentapi.Status_OK, rsp.KeaResponses[0].Status.Code)
+	require.JSONEq(t, "[{\"result\":0}]", doGunzip(rsp.KeaResponses[0].Response))
+}
+
+// Test that the commands are not forwarded to the control socket which
+// does not belong to any detected Kea app.
+func TestForwardToKeaOverSocketUnknownSocket(t *testing.T) {
+	sa, ctx := setupAgentTest()
+	sa.KeaSocketClient = NewKeaSocketClient()
+
+	req := &agentapi.ForwardToKeaOverSocketReq{
+		SocketPath:  "/tmp/kea4-ctrl-socket",
+		KeaRequests: []*agentapi.KeaRequest{{Request: "{ \"command\": \"list-commands\"}"}},
+	}
+
+	rsp, err := sa.ForwardToKeaOverSocket(ctx, req)
+	require.NotNil(t, rsp)
+	require.NoError(t, err)
+	require.Equal(t, agentapi.Status_ERROR, rsp.Status.Code)
+	require.Empty(t, rsp.KeaResponses)
+}
+
 // Test successful forwarding stats request to named.
 func TestForwardToNamedStatsSuccess(t *testing.T) {
 	sa, ctx := setupAgentTest()
@@ -403,6 +456,38 @@ func TestForwardToNamedStatsHTTPEmptyBody(t *testing.T) {
 	require.Len(t, rsp.NamedStatsResponse.Response, 0)
 }
 
+// Test forwarding statistics request to named which serves the XML
+// statistics only. The statistics should be converted to JSON.
+func TestForwardToNamedStatsXML(t *testing.T) {
+	sa, ctx := setupAgentTest()
+
+	defer gock.Off()
+	gock.New("http://localhost:45634/").
+		Post("/json/v1").
+		Reply(404).
+		BodyString("Not Found")
+	gock.New("http://localhost:45634/").
+		Post("/xml/v3").
+		Reply(200).
+		BodyString(`<statistics version="3.11"><views><view name="_default"/></views></statistics>`)
+
+	req := &agentapi.ForwardToNamedStatsReq{
+		Url:               "http://localhost:45634/json/v1",
+		NamedStatsRequest: &agentapi.NamedStatsRequest{Request: "{}"},
+	}
+
+	rsp, err := sa.ForwardToNamedStats(ctx, req)
+	require.NotNil(t, rsp)
+	require.NoError(t, err)
+	require.NotNil(t, rsp.NamedStatsResponse)
+	require.EqualValues(t, agentapi.Status_OK, rsp.NamedStatsResponse.Status.Code)
+	require.JSONEq(t, `{
+		"views": {"_default": {"resolver": {}, "zones": []}},
+		"taskmgr": {},
+		"traffic": {}
+	}`, rsp.NamedStatsResponse.Response)
+}
+
 // Test forwarding statistics request when named is unavailable.
 func TestForwardToNamedStatsNoNamed(t *testing.T) {
 	sa, ctx := setupAgentTest()
@@ -791,7 +876,7 @@ func TestGetIdentityCertificatesForServer(t *testing.T) {
 
 // Check if newGRPCServerWithTLS can create gRPC server.
 func TestNewGRPCServerWithTLS(t *testing.T) {
-	srv, err := newGRPCServerWithTLS()
+	srv, err := newGRPCServerWithTLS(newRevokedCerts())
 	require.NoError(t, err)
 	require.NotNil(t, srv)
 }
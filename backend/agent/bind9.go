@@ -1,7 +1,12 @@
 package agent
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
+	"io"
+	"net/http"
 	"os"
 	"os/exec"
 	"path"
@@ -9,10 +14,12 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 
+	bind9ctrl "isc.org/stork/appctrl/bind9"
 	storkutil "isc.org/stork/util"
 )
 
@@ -425,10 +432,16 @@ func detectBind9App(match []string, cwd string, cmdr storkutil.Commander) App {
 		log.Warnf("cannot parse BIND 9 statistics-channels clause")
 	}
 
-	// rndc is the command to interface with BIND 9.
+	// rndc is the command to interface with BIND 9. The error output of
+	// rndc is included in the returned error, so the server can tell the
+	// connection failures from the failed commands.
 	rndc := func(command []string) ([]byte, error) {
 		cmd := exec.Command(command[0], command[1:]...) //nolint:gosec
-		return cmd.Output()
+		output, err := cmd.Output()
+		if exitErr, ok := err.(*exec.ExitError); ok && len(exitErr.Stderr) > 0 {
+			err = errors.Wrap(err, strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return output, err
 	}
 
 	// determine rndc details
@@ -455,3 +468,119 @@ func detectBind9App(match []string, cwd string, cmdr storkutil.Commander) App {
 func (ba *Bind9App) sendCommand(command []string) (output []byte, err error) {
 	return ba.RndcClient.SendCommand(command)
 }
+
+// Statistics channels of named which serve only the XML statistics,
+// indexed by the URL of the JSON statistics. The statistics are fetched
+// from the XML channel directly once it is detected, so the unavailable
+// JSON channel isn't queried each time. The zero value is ready to use.
+type namedStatsFormats struct {
+	mutex   sync.Mutex
+	xmlOnly map[string]bool
+}
+
+// Checks if the statistics channel has been detected to serve only the
+// XML statistics.
+func (f *namedStatsFormats) isXMLOnly(url string) bool {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.xmlOnly[url]
+}
+
+// Records whether the statistics channel serves only the XML statistics.
+func (f *namedStatsFormats) setXMLOnly(url string, xmlOnly bool) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if xmlOnly {
+		if f.xmlOnly == nil {
+			f.xmlOnly = make(map[string]bool)
+		}
+		f.xmlOnly[url] = true
+	} else {
+		delete(f.xmlOnly, url)
+	}
+}
+
+// Sends the request to the URL of the named statistics-channel and returns
+// the response body. The statistics are fetched from the JSON channel. If
+// it is unavailable, e.g. because named has been built without JSON support,
+// the statistics are fetched from the XML channel and converted to the
+// format of the JSON statistics. The XML channel is used directly for the
+// next requests to this URL until it fails. If both channels fail, the
+// original response from the JSON channel is returned.
+func (f *namedStatsFormats) getNamedStats(ctx context.Context, client *HTTPClient, url string, request []byte) ([]byte, error) {
+	if !strings.Contains(url, bind9ctrl.StatsJSONPath) {
+		body, _, err := callNamedStats(ctx, client, url, request)
+		return body, err
+	}
+	xmlURL := strings.Replace(url, bind9ctrl.StatsJSONPath, bind9ctrl.StatsXMLPath, 1)
+
+	if f.isXMLOnly(url) {
+		jsonBody, err := getNamedXMLStats(ctx, client, xmlURL, request)
+		if err == nil {
+			return jsonBody, nil
+		}
+		// The format may have changed, e.g. named has been upgraded.
+		f.setXMLOnly(url, false)
+	}
+
+	body, status, err := callNamedStats(ctx, client, url, request)
+	if err != nil {
+		return nil, err
+	}
+	if status == http.StatusOK && isJSONObject(body) {
+		return body, nil
+	}
+
+	jsonBody, err := getNamedXMLStats(ctx, client, xmlURL, request)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"URL": xmlURL,
+		}).Debugf("Failed to get statistics from the XML statistics-channel: %v", err)
+		return body, nil
+	}
+	f.setXMLOnly(url, true)
+	return jsonBody, nil
+}
+
+// Fetches the statistics from the XML statistics-channel and converts
+// them to the format of the JSON statistics.
+func getNamedXMLStats(ctx context.Context, client *HTTPClient, xmlURL string, request []byte) ([]byte, error) {
+	xmlBody, status, err := callNamedStats(ctx, client, xmlURL, request)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		return nil, errors.Errorf("XML statistics-channel returned status %d", status)
+	}
+	jsonBody, err := bind9ctrl.ConvertXMLStatsToJSON(xmlBody)
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to convert statistics from the XML statistics-channel")
+	}
+	return jsonBody, nil
+}
+
+// Sends the request to named statistics-channel and returns the response
+// body and the HTTP status code.
+func callNamedStats(ctx context.Context, client *HTTPClient, url string, request []byte) ([]byte, int, error) {
+	rsp, err := client.Call(ctx, url, bytes.NewBuffer(request))
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rsp.Body.Close()
+	body, err := io.ReadAll(rsp.Body)
+	if err != nil {
+		return nil, 0, errors.Wrapf(err, "failed to read the body of the named response")
+	}
+	return body, rsp.StatusCode, nil
+}
+
+// Checks if the body is a JSON object. Only the first token is decoded
+// because the statistics may be large and they are parsed later anyway.
+func isJSONObject(body []byte) bool {
+	token, err := json.NewDecoder(bytes.NewReader(body)).Token()
+	if err != nil {
+		return false
+	}
+	delim, ok := token.(json.Delim)
+	return ok && delim == '{'
+}
@@ -1,9 +1,11 @@
 package agent
 
 import (
+	"context"
 	"testing"
 
 	"github.com/stretchr/testify/require"
+	"gopkg.in/h2non/gock.v1"
 )
 
 // Test the function which extracts the list of log files from the Bind9
@@ -21,3 +23,142 @@ func TestGetPotentialNamedConfLocations(t *testing.T) {
 	paths := getPotentialNamedConfLocations()
 	require.Greater(t, len(paths), 1)
 }
+
+// Test that the statistics are returned as is when named serves the
+// JSON statistics.
+func TestGetNamedStatsJSON(t *testing.T) {
+	defer gock.Off()
+	gock.New("http://localhost:45634/").
+		Post("/json/v1").
+		Reply(200).
+		BodyString(`{"views": {}}`)
+
+	client := NewHTTPClient(true)
+	gock.InterceptClient(client.client)
+
+	var formats namedStatsFormats
+	body, err := formats.getNamedStats(context.Background(), client, "http://localhost:45634/json/v1", []byte("{}"))
+	require.NoError(t, err)
+	require.JSONEq(t, `{"views": {}}`, string(body))
+	require.True(t, gock.IsDone())
+}
+
+// Test that the XML statistics are fetched and converted to JSON when
+// named doesn't serve the JSON statistics.
+func TestGetNamedStatsXMLFallback(t *testing.T) {
+	defer gock.Off()
+	gock.New("http://localhost:45634/").
+		Post("/json/v1").
+		Reply(404).
+		BodyString("<html><body>Not Found</body></html>")
+	gock.New("http://localhost:45634/").
+		Post("/xml/v3").
+		Reply(200).
+		BodyString(`<statistics version="3.11">
+		    <server>
+		        <boot-time>2021-07-01T10:00:00.000Z</boot-time>
+		        <counters type="qtype"><counter name="A">5</counter></counters>
+		    </server>
+		</statistics>`)
+
+	client := NewHTTPClient(true)
+	gock.InterceptClient(client.client)
+
+	var formats namedStatsFormats
+	body, err := formats.getNamedStats(context.Background(), client, "http://localhost:45634/json/v1", []byte("{}"))
+	require.NoError(t, err)
+	require.JSONEq(t, `{
+		"boot-time": "2021-07-01T10:00:00.000Z",
+		"qtypes": {"A": 5},
+		"views": {},
+		"taskmgr": {},
+		"traffic": {}
+	}`, string(body))
+	require.True(t, gock.IsDone())
+}
+
+// Test that the response of the JSON statistics-channel is returned when
+// the XML statistics are unavailable too.
+func TestGetNamedStatsNoXMLFallback(t *testing.T) {
+	defer gock.Off()
+	gock.New("http://localhost:45634/").
+		Post("/json/v1").
+		Reply(404).
+		BodyString("Not Found")
+	gock.New("http://localhost:45634/").
+		Post("/xml/v3").
+		Reply(404).
+		BodyString("Not Found")
+
+	client := NewHTTPClient(true)
+	gock.InterceptClient(client.client)
+
+	var formats namedStatsFormats
+	body, err := formats.getNamedStats(context.Background(), client, "http://localhost:45634/json/v1", []byte("{}"))
+	require.NoError(t, err)
+	require.Equal(t, "Not Found", string(body))
+}
+
+// Test that the XML statistics-channel is queried directly once it is
+// detected that named doesn't serve the JSON statistics.
+func TestGetNamedStatsXMLRemembered(t *testing.T) {
+	defer gock.Off()
+	gock.New("http://localhost:45634/").
+		Post("/json/v1").
+		Reply(404).
+		BodyString("Not Found")
+	gock.New("http://localhost:45634/").
+		Post("/xml/v3").
+		Times(2).
+		Reply(200).
+		BodyString(`<statistics version="3.11"><server></server></statistics>`)
+
+	client := NewHTTPClient(true)
+	gock.InterceptClient(client.client)
+
+	var formats namedStatsFormats
+	_, err := formats.getNamedStats(context.Background(), client, "http://localhost:45634/json/v1", []byte("{}"))
+	require.NoError(t, err)
+	require.True(t, formats.isXMLOnly("http://localhost:45634/json/v1"))
+
+	// The JSON statistics-channel must not be queried again.
+	_, err = formats.getNamedStats(context.Background(), client, "http://localhost:45634/json/v1", []byte("{}"))
+	require.NoError(t, err)
+	require.True(t, gock.IsDone())
+}
+
+// Test that the JSON statistics-channel is queried again when the XML
+// statistics-channel remembered for the URL fails.
+func TestGetNamedStatsXMLForgotten(t *testing.T) {
+	defer gock.Off()
+	gock.New("http://localhost:45634/").
+		Post("/xml/v3").
+		Reply(404).
+		BodyString("Not Found")
+	gock.New("http://localhost:45634/").
+		Post("/json/v1").
+		Reply(200).
+		BodyString(`{"views": {}}`)
+
+	client := NewHTTPClient(true)
+	gock.InterceptClient(client.client)
+
+	var formats namedStatsFormats
+	formats.setXMLOnly("http://localhost:45634/json/v1", true)
+
+	body, err := formats.getNamedStats(context.Background(), client, "http://localhost:45634/json/v1", []byte("{}"))
+	require.NoError(t, err)
+	require.JSONEq(t, `{"views": {}}`, string(body))
+	require.False(t, formats.isXMLOnly("http://localhost:45634/json/v1"))
+	require.True(t, gock.IsDone())
+}
+
+// Test that the JSON object is recognized by its first token.
+func TestIsJSONObject(t *testing.T) {
+	require.True(t, isJSONObject([]byte(`{"views": {}}`)))
+	require.True(t, isJSONObject([]byte(" \n\t{")))
+	require.False(t, isJSONObject([]byte(`["views"]`)))
+	require.False(t, isJSONObject([]byte(`"views"`)))
+	require.False(t, isJSONObject([]byte("<html></html>")))
+	require.False(t, isJSONObject([]byte("")))
+}
@@ -12,6 +12,9 @@ import (
 
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+
+	"isc.org/stork/tracing"
 )
 
 // CredentialsFile path to a file holding credentials used in basic authentication of the agent in Kea.
@@ -80,14 +83,26 @@ func NewHTTPClient(skipTLSVerification bool) *HTTPClient {
 	return client
 }
 
-func (c *HTTPClient) Call(url string, payload *bytes.Buffer) (*http.Response, error) {
-	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, url, payload)
+// Sends the POST request with the payload to the specified URL. The
+// request is traced in its own span and the trace context is sent in
+// the HTTP headers.
+func (c *HTTPClient) Call(ctx context.Context, url string, payload *bytes.Buffer) (rsp *http.Response, err error) {
+	ctx, span := tracing.StartSpan(ctx, "HTTPClient.Call", attribute.String("http.url", url))
+	defer func() {
+		if rsp != nil {
+			span.SetAttributes(attribute.Int("http.status_code", rsp.StatusCode))
+		}
+		tracing.EndSpan(span, err)
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, payload)
 	if err != nil {
 		err = errors.Wrapf(err, "problem with creating POST request to %s", url)
 
 		return nil, err
 	}
 	req.Header.Add("Content-Type", "application/json")
+	tracing.InjectHTTPHeaders(ctx, req.Header)
 
 	if basicAuth, ok := c.credentials.GetBasicAuthByURL(url); ok {
 		secret := fmt.Sprintf("%s:%s", basicAuth.User, basicAuth.Password)
@@ -96,7 +111,7 @@ func (c *HTTPClient) Call(url string, payload *bytes.Buffer) (*http.Response, er
 		req.Header.Add("Authorization", headerContent)
 	}
 
-	rsp, err := c.client.Do(req)
+	rsp, err = c.client.Do(req)
 	if err != nil {
 		err = errors.Wrapf(err, "problem with sending POST to %s", url)
 	}
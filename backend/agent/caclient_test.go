@@ -2,6 +2,7 @@ package agent
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
 	"fmt"
 	"net/http"
@@ -12,6 +13,11 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"isc.org/stork/tracing"
 	storkutil "isc.org/stork/util"
 )
 
@@ -127,7 +133,7 @@ func TestAddAuthorizationHeaderWhenBasicAuthCredentialsExist(t *testing.T) {
 	client := NewHTTPClient(true)
 	require.NotNil(t, client.credentials)
 
-	res, err := client.Call(ts.URL, bytes.NewBuffer([]byte{}))
+	res, err := client.Call(context.Background(), ts.URL, bytes.NewBuffer([]byte{}))
 	require.NoError(t, err)
 	defer res.Body.Close()
 }
@@ -148,7 +154,37 @@ func TestAddAuthorizationHeaderWhenBasicAuthCredentialsNonExist(t *testing.T) {
 	client := NewHTTPClient(true)
 	require.NotNil(t, client.credentials)
 
-	res, err := client.Call(ts.URL, bytes.NewBuffer([]byte{}))
+	res, err := client.Call(context.Background(), ts.URL, bytes.NewBuffer([]byte{}))
+	require.NoError(t, err)
+	defer res.Body.Close()
+}
+
+// Test that the trace context is sent to Kea in the HTTP headers and the
+// call is traced in a child span.
+func TestCallPropagatesTraceContext(t *testing.T) {
+	_, err := tracing.Setup("stork-test", tracing.Settings{})
+	require.NoError(t, err)
+	exporter := tracetest.NewInMemoryExporter()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	defer otel.SetTracerProvider(sdktrace.NewTracerProvider())
+
+	var traceparent string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+	}))
+	defer ts.Close()
+
+	client := NewHTTPClient(true)
+	ctx, span := tracing.StartSpan(context.Background(), "caller")
+	res, err := client.Call(ctx, ts.URL, bytes.NewBuffer([]byte{}))
 	require.NoError(t, err)
 	defer res.Body.Close()
+	span.End()
+
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 2)
+	require.Equal(t, "HTTPClient.Call", spans[0].Name)
+	require.Equal(t, span.SpanContext().SpanID(), spans[0].Parent.SpanID())
+	require.Contains(t, traceparent, spans[0].SpanContext.TraceID().String())
+	require.Contains(t, traceparent, spans[0].SpanContext.SpanID().String())
 }
@@ -0,0 +1,199 @@
+package agent
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	agentapi "isc.org/stork/api"
+	"isc.org/stork/pki"
+)
+
+// Default time before the expiration of the agent certificate when the
+// agent starts requesting its renewal.
+const defaultCertRenewalBefore = 30 * 24 * time.Hour
+
+// Monitors the validity of the agent certificate. When the certificate
+// is about to expire it generates a CSR for the current agent key and
+// the names and addresses from the current certificate. The CSR is sent
+// to the server in the machine state and the server responds with the
+// renewed certificate using the RenewCertificate call. The renewal does
+// not require re-registration because the server authenticates the
+// agent with its current certificate.
+type certRenewer struct {
+	// Time before the certificate expiration when the renewal starts.
+	renewBefore time.Duration
+	mutex       sync.Mutex
+	// CSR generated for the certificate being renewed. It is cached
+	// to send the same CSR until the server responds.
+	csrPEM []byte
+	// Fingerprint of the certificate for which the CSR was generated.
+	certFingerprint [sha256.Size]byte
+}
+
+// Creates the certificate renewer. If the renewBefore is not positive,
+// the default value is used.
+func newCertRenewer(renewBefore time.Duration) *certRenewer {
+	if renewBefore <= 0 {
+		renewBefore = defaultCertRenewalBefore
+	}
+	return &certRenewer{
+		renewBefore: renewBefore,
+	}
+}
+
+// Reads the current agent certificate.
+func readAgentCert() (*x509.Certificate, error) {
+	certPEM, err := os.ReadFile(CertPEMFile)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not load cert PEM file: %s", CertPEMFile)
+	}
+	return pki.ParseCert(certPEM)
+}
+
+// Checks the validity of the current agent certificate. It returns the
+// expiration time of the certificate and the CSR if the certificate
+// should be renewed or the renewal is forced by the server. The CSR is
+// nil otherwise.
+func (cr *certRenewer) checkCert(now time.Time, force bool) (time.Time, []byte, error) {
+	cert, err := readAgentCert()
+	if err != nil {
+		return time.Time{}, nil, err
+	}
+	if !force && now.Add(cr.renewBefore).Before(cert.NotAfter) {
+		return cert.NotAfter, nil, nil
+	}
+
+	cr.mutex.Lock()
+	defer cr.mutex.Unlock()
+
+	fingerprint := sha256.Sum256(cert.Raw)
+	if cr.csrPEM != nil && cr.certFingerprint == fingerprint {
+		return cert.NotAfter, cr.csrPEM, nil
+	}
+
+	if force {
+		log.Info("server requested agent certificate renewal")
+	} else {
+		log.WithFields(log.Fields{
+			"expires": cert.NotAfter.UTC().Format(time.RFC3339),
+		}).Warn("agent certificate is about to expire; requesting its renewal")
+	}
+
+	keyPEM, err := os.ReadFile(KeyPEMFile)
+	if err != nil {
+		return cert.NotAfter, nil, errors.Wrapf(err, "could not load key PEM file: %s", KeyPEMFile)
+	}
+	csrPEM, _, err := pki.GenCSRUsingKey("agent", cert.DNSNames, cert.IPAddresses, keyPEM)
+	if err != nil {
+		return cert.NotAfter, nil, err
+	}
+	cr.csrPEM = csrPEM
+	cr.certFingerprint = fingerprint
+	return cert.NotAfter, csrPEM, nil
+}
+
+// Verifies the renewed certificate and the CA certificates and replaces
+// the current agent certificate and the trusted CA certificates with
+// them. Any of them may be empty, in which case the current one is used.
+// The certificate must be signed by one of the CAs and it must be issued
+// for the current agent key.
+func (cr *certRenewer) installCert(certPEM, caPEM []byte) error {
+	if len(certPEM) == 0 && len(caPEM) == 0 {
+		return errors.New("neither agent certificate nor CA certificate specified")
+	}
+
+	newCert := len(certPEM) > 0
+	if !newCert {
+		var err error
+		certPEM, err = os.ReadFile(CertPEMFile)
+		if err != nil {
+			return errors.Wrapf(err, "could not load cert PEM file: %s", CertPEMFile)
+		}
+	}
+	cert, err := pki.ParseCert(certPEM)
+	if err != nil {
+		return err
+	}
+
+	newCA := len(caPEM) > 0
+	if !newCA {
+		caPEM, err = os.ReadFile(RootCAFile)
+		if err != nil {
+			return errors.Wrapf(err, "could not read CA certificate: %s", RootCAFile)
+		}
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(caPEM) {
+		return errors.New("could not parse CA certificates")
+	}
+	_, err = cert.Verify(x509.VerifyOptions{
+		Roots:     roots,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		return errors.Wrapf(err, "agent certificate is not signed by the server CA")
+	}
+
+	keyPEM, err := os.ReadFile(KeyPEMFile)
+	if err != nil {
+		return errors.Wrapf(err, "could not load key PEM file: %s", KeyPEMFile)
+	}
+	key, err := pki.ParsePrivateKey(keyPEM)
+	if err != nil {
+		return err
+	}
+	publicKey, ok := cert.PublicKey.(*ecdsa.PublicKey)
+	if !ok || !publicKey.Equal(&key.PublicKey) {
+		return errors.New("renewed certificate does not match the agent key")
+	}
+
+	cr.mutex.Lock()
+	defer cr.mutex.Unlock()
+
+	if newCert {
+		if err = writeAgentFile(CertPEMFile, certPEM); err != nil {
+			return errors.Wrapf(err, "could not store renewed certificate: %s", CertPEMFile)
+		}
+		cr.csrPEM = nil
+		cr.certFingerprint = [sha256.Size]byte{}
+
+		log.WithFields(log.Fields{
+			"expires": cert.NotAfter.UTC().Format(time.RFC3339),
+		}).Info("agent certificate renewed")
+	}
+	if newCA {
+		if err = writeAgentFile(RootCAFile, caPEM); err != nil {
+			return errors.Wrapf(err, "could not store CA certificates: %s", RootCAFile)
+		}
+		log.Info("trusted CA certificates replaced")
+	}
+	return nil
+}
+
+// Replaces the agent certificate with the renewed certificate sent by
+// the server in response to the CSR returned in the machine state. The
+// server may also replace the trusted CA certificates during the CA
+// rotation. The new certificates are used for the subsequent connections.
+func (sa *StorkAgent) RenewCertificate(ctx context.Context, in *agentapi.RenewCertificateReq) (*agentapi.RenewCertificateRsp, error) {
+	response := &agentapi.RenewCertificateRsp{
+		Status: &agentapi.Status{
+			Code: agentapi.Status_OK, // all ok
+		},
+	}
+
+	if err := sa.certRenewer.installCert([]byte(in.AgentCert), []byte(in.ServerCACert)); err != nil {
+		log.Errorf("Failed to renew agent certificate: %+v", err)
+		response.Status.Code = agentapi.Status_ERROR
+		response.Status.Message = fmt.Sprintf("Failed to renew agent certificate: %s", err)
+	}
+	return response, nil
+}
@@ -0,0 +1,216 @@
+package agent
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"net"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	agentapi "isc.org/stork/api"
+	"isc.org/stork/pki"
+)
+
+// Prepares the agent key, the agent certificate signed by the CA and the
+// CA certificate in the temporary directory. It returns the CA key and
+// certificate in PEM format and the function restoring the original
+// paths to the files.
+func setupCertRenewalTest(t *testing.T) ([]byte, []byte, func()) {
+	tmpDir, err := os.MkdirTemp("", "certrenewal")
+	require.NoError(t, err)
+
+	restorePaths := RememberPaths()
+	KeyPEMFile = path.Join(tmpDir, "key.pem")
+	CertPEMFile = path.Join(tmpDir, "cert.pem")
+	RootCAFile = path.Join(tmpDir, "ca.pem")
+
+	_, rootKeyPEM, _, rootCertPEM, err := pki.GenCAKeyCert(1)
+	require.NoError(t, err)
+	keyPEM, csrPEM, _, err := pki.GenKeyAndCSR("agent", []string{"agent.example.org"}, []net.IP{net.ParseIP("192.0.2.1")})
+	require.NoError(t, err)
+	certPEM, _, paramsErr, innerErr := pki.SignCert(csrPEM, 2, rootCertPEM, rootKeyPEM)
+	require.NoError(t, paramsErr)
+	require.NoError(t, innerErr)
+
+	require.NoError(t, writeAgentFile(KeyPEMFile, keyPEM))
+	require.NoError(t, writeAgentFile(CertPEMFile, certPEM))
+	require.NoError(t, writeAgentFile(RootCAFile, rootCertPEM))
+
+	return rootKeyPEM, rootCertPEM, func() {
+		restorePaths()
+		os.RemoveAll(tmpDir)
+	}
+}
+
+// Test that the CSR is not generated when the certificate is not about
+// to expire.
+func TestCheckCertNotExpiring(t *testing.T) {
+	_, _, teardown := setupCertRenewalTest(t)
+	defer teardown()
+
+	cr := newCertRenewer(0)
+	notAfter, csrPEM, err := cr.checkCert(time.Now(), false)
+	require.NoError(t, err)
+	require.Nil(t, csrPEM)
+	require.True(t, notAfter.After(time.Now().AddDate(pki.CertValidityYears-1, 0, 0)))
+}
+
+// Test that the CSR for the current key and the names from the current
+// certificate is generated when the certificate is about to expire and
+// that the same CSR is returned until the certificate is renewed.
+func TestCheckCertExpiring(t *testing.T) {
+	_, _, teardown := setupCertRenewalTest(t)
+	defer teardown()
+
+	cr := newCertRenewer(0)
+	now := time.Now().AddDate(pki.CertValidityYears, 0, -1)
+	_, csrPEM, err := cr.checkCert(now, false)
+	require.NoError(t, err)
+	require.NotNil(t, csrPEM)
+
+	block, _ := pem.Decode(csrPEM)
+	require.NotNil(t, block)
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	require.NoError(t, err)
+	require.Equal(t, []string{"agent.example.org"}, csr.DNSNames)
+	require.Len(t, csr.IPAddresses, 1)
+	require.Equal(t, "192.0.2.1", csr.IPAddresses[0].String())
+
+	_, csrPEM2, err := cr.checkCert(now, false)
+	require.NoError(t, err)
+	require.Equal(t, csrPEM, csrPEM2)
+}
+
+// Test that the certificate signed by the server CA for the CSR returned
+// by the agent replaces the current certificate.
+func TestRenewCertificate(t *testing.T) {
+	rootKeyPEM, rootCertPEM, teardown := setupCertRenewalTest(t)
+	defer teardown()
+
+	sa, ctx := setupAgentTest()
+	now := time.Now().AddDate(pki.CertValidityYears, 0, -1)
+	_, csrPEM, err := sa.certRenewer.checkCert(now, false)
+	require.NoError(t, err)
+
+	certPEM, _, paramsErr, innerErr := pki.SignCert(csrPEM, 3, rootCertPEM, rootKeyPEM)
+	require.NoError(t, paramsErr)
+	require.NoError(t, innerErr)
+
+	rsp, err := sa.RenewCertificate(ctx, &agentapi.RenewCertificateReq{
+		AgentCert: string(certPEM),
+	})
+	require.NoError(t, err)
+	require.Equal(t, agentapi.Status_OK, rsp.Status.Code)
+
+	storedPEM, err := os.ReadFile(CertPEMFile)
+	require.NoError(t, err)
+	require.Equal(t, certPEM, storedPEM)
+	require.Nil(t, sa.certRenewer.csrPEM)
+}
+
+// Test that the certificate which is not signed by the server CA or
+// which does not match the agent key is rejected.
+func TestRenewCertificateInvalid(t *testing.T) {
+	rootKeyPEM, rootCertPEM, teardown := setupCertRenewalTest(t)
+	defer teardown()
+
+	sa, _ := setupAgentTest()
+	ctx := context.Background()
+	origPEM, err := os.ReadFile(CertPEMFile)
+	require.NoError(t, err)
+
+	// Certificate signed by another CA.
+	_, otherKeyPEM, _, otherCertPEM, err := pki.GenCAKeyCert(10)
+	require.NoError(t, err)
+	_, csrPEM, err := sa.certRenewer.checkCert(time.Now().AddDate(pki.CertValidityYears, 0, -1), false)
+	require.NoError(t, err)
+	certPEM, _, _, _ := pki.SignCert(csrPEM, 11, otherCertPEM, otherKeyPEM)
+	rsp, err := sa.RenewCertificate(ctx, &agentapi.RenewCertificateReq{
+		AgentCert: string(certPEM),
+	})
+	require.NoError(t, err)
+	require.Equal(t, agentapi.Status_ERROR, rsp.Status.Code)
+
+	// Certificate for another key.
+	_, otherCSRPEM, _, err := pki.GenKeyAndCSR("agent", []string{"agent.example.org"}, nil)
+	require.NoError(t, err)
+	certPEM, _, _, _ = pki.SignCert(otherCSRPEM, 12, rootCertPEM, rootKeyPEM)
+	rsp, err = sa.RenewCertificate(ctx, &agentapi.RenewCertificateReq{
+		AgentCert: string(certPEM),
+	})
+	require.NoError(t, err)
+	require.Equal(t, agentapi.Status_ERROR, rsp.Status.Code)
+	require.Contains(t, rsp.Status.Message, "does not match")
+
+	// The original certificate is preserved.
+	storedPEM, err := os.ReadFile(CertPEMFile)
+	require.NoError(t, err)
+	require.Equal(t, origPEM, storedPEM)
+}
+
+// Test that the CSR is generated when the server requests it even if the
+// certificate is not about to expire.
+func TestCheckCertForced(t *testing.T) {
+	_, _, teardown := setupCertRenewalTest(t)
+	defer teardown()
+
+	cr := newCertRenewer(0)
+	_, csrPEM, err := cr.checkCert(time.Now(), true)
+	require.NoError(t, err)
+	require.NotNil(t, csrPEM)
+}
+
+// Test that the agent certificate signed by the new CA and the bundle of
+// the trusted CA certificates are installed during the CA rotation and
+// that the old CA can be retired afterwards.
+func TestRenewCertificateCARotation(t *testing.T) {
+	_, rootCertPEM, teardown := setupCertRenewalTest(t)
+	defer teardown()
+
+	sa, ctx := setupAgentTest()
+	_, nextKeyPEM, _, nextCertPEM, err := pki.GenCAKeyCert(20)
+	require.NoError(t, err)
+	bundle := append(append([]byte{}, rootCertPEM...), nextCertPEM...)
+
+	// The certificate signed by the new CA is accepted along with the
+	// bundle of the current and the new CA.
+	_, csrPEM, err := sa.certRenewer.checkCert(time.Now(), true)
+	require.NoError(t, err)
+	certPEM, _, paramsErr, innerErr := pki.SignCert(csrPEM, 21, nextCertPEM, nextKeyPEM)
+	require.NoError(t, paramsErr)
+	require.NoError(t, innerErr)
+	rsp, err := sa.RenewCertificate(ctx, &agentapi.RenewCertificateReq{
+		AgentCert:    string(certPEM),
+		ServerCACert: string(bundle),
+	})
+	require.NoError(t, err)
+	require.Equal(t, agentapi.Status_OK, rsp.Status.Code)
+	storedPEM, err := os.ReadFile(RootCAFile)
+	require.NoError(t, err)
+	require.Equal(t, bundle, storedPEM)
+
+	// The old CA is retired.
+	rsp, err = sa.RenewCertificate(ctx, &agentapi.RenewCertificateReq{
+		ServerCACert: string(nextCertPEM),
+	})
+	require.NoError(t, err)
+	require.Equal(t, agentapi.Status_OK, rsp.Status.Code)
+	storedPEM, err = os.ReadFile(RootCAFile)
+	require.NoError(t, err)
+	require.Equal(t, nextCertPEM, storedPEM)
+
+	// The CA which didn't sign the agent certificate is rejected.
+	rsp, err = sa.RenewCertificate(ctx, &agentapi.RenewCertificateReq{
+		ServerCACert: string(rootCertPEM),
+	})
+	require.NoError(t, err)
+	require.Equal(t, agentapi.Status_ERROR, rsp.Status.Code)
+	storedPEM, err = os.ReadFile(RootCAFile)
+	require.NoError(t, err)
+	require.Equal(t, nextCertPEM, storedPEM)
+}
@@ -2,6 +2,7 @@ package agent
 
 import (
 	"bytes"
+	"context"
 	"io"
 	"path"
 	"strings"
@@ -17,7 +18,15 @@ import (
 // It holds common and Kea specifc runtime information.
 type KeaApp struct {
 	BaseApp
-	HTTPClient *HTTPClient // to communicate with Kea Control Agent
+	HTTPClient   *HTTPClient      // to communicate with Kea Control Agent
+	SocketClient *KeaSocketClient // to communicate with Kea daemons over control sockets
+	// Paths to the control sockets of the daemons behind the Kea Control
+	// Agent. The daemons using these sockets are controlled via the
+	// Kea Control Agent rather than directly.
+	ControlSocketPaths []string
+	// Name of the daemon controlled directly over its control socket,
+	// i.e. dhcp4, dhcp6 or d2. It is empty for the Kea Control Agent.
+	DaemonName string
 }
 
 // Get base information about Kea app.
@@ -25,31 +34,56 @@ func (ka *KeaApp) GetBaseApp() *BaseApp {
 	return &ka.BaseApp
 }
 
-// Sends a command to Kea and returns a response.
-func (ka *KeaApp) sendCommand(command *keactrl.Command, responses interface{}) error {
+// Sends a request to Kea and returns the response body and the target
+// the request has been sent to. The request is sent to the Kea Control
+// Agent or, if the app has the control socket access point, directly to
+// the Kea daemon over the control socket.
+func (ka *KeaApp) sendRequest(request []byte) (body []byte, target string, err error) {
 	ap := &ka.BaseApp.AccessPoints[0]
-	caURL := storkutil.HostWithPortURL(ap.Address, ap.Port, ap.UseSecureProtocol)
 
-	// Get the textual representation of the command.
-	request := command.Marshal()
+	if ap.Type == AccessPointControlSocket {
+		// Send the request directly to the Kea daemon.
+		target = ap.Address
+		body, err = ka.SocketClient.Call(target, request)
+		if err != nil {
+			return nil, target, errors.WithMessagef(err, "failed to send command to Kea: %s", target)
+		}
+		return body, target, nil
+	}
+
+	target = storkutil.HostWithPortURL(ap.Address, ap.Port, ap.UseSecureProtocol)
 
-	// Send the command to the Kea server.
-	response, err := ka.HTTPClient.Call(caURL, bytes.NewBuffer([]byte(request)))
+	// Send the request to the Kea server.
+	response, err := ka.HTTPClient.Call(context.Background(), target, bytes.NewBuffer(request))
 	if err != nil {
-		return errors.WithMessagef(err, "failed to send command to Kea: %s", caURL)
+		return nil, target, errors.WithMessagef(err, "failed to send command to Kea: %s", target)
 	}
 
 	// Read the response.
-	body, err := io.ReadAll(response.Body)
+	body, err = io.ReadAll(response.Body)
 	response.Body.Close()
 	if err != nil {
-		return errors.WithMessagef(err, "failed to read Kea response body received from %s", caURL)
+		return nil, target, errors.WithMessagef(err, "failed to read Kea response body received from %s", target)
+	}
+	return body, target, nil
+}
+
+// Sends a command to Kea and returns a response. The command is sent
+// to the Kea Control Agent or, if the app has the control socket access
+// point, directly to the Kea daemon over the control socket.
+func (ka *KeaApp) sendCommand(command *keactrl.Command, responses interface{}) error {
+	// Get the textual representation of the command.
+	request := command.Marshal()
+
+	body, target, err := ka.sendRequest([]byte(request))
+	if err != nil {
+		return err
 	}
 
 	// Parse the response.
 	err = keactrl.UnmarshalResponseList(command, body, responses)
 	if err != nil {
-		return errors.WithMessagef(err, "failed to parse Kea response body received from %s", caURL)
+		return errors.WithMessagef(err, "failed to parse Kea response body received from %s", target)
 	}
 	return nil
 }
@@ -150,9 +184,11 @@ func (ka *KeaApp) DetectAllowedLogs() ([]string, error) {
 	sockets := config.GetControlSockets()
 	daemonNames := sockets.ConfiguredDaemonNames()
 
-	// Apparently, it isn't configured to forward commands to the daemons behind it.
+	// Apparently, it isn't configured to forward commands to the daemons behind
+	// it or the command has been sent directly to the daemon over the control
+	// socket.
 	if len(daemonNames) == 0 {
-		return nil, nil
+		return paths, nil
 	}
 
 	// Prepare config-get command to be sent to the daemons behind CA.
@@ -186,16 +222,25 @@ func (ka *KeaApp) DetectAllowedLogs() ([]string, error) {
 	return paths, nil
 }
 
-func getCtrlTargetFromKeaConfig(path string) (address string, port int64, useSecureProtocol bool) {
+// Reads and parses the Kea configuration file. The included files are
+// read as well.
+func readKeaConfig(path string) (*keaconfig.Map, error) {
 	text, err := storkutil.ReadFileWithIncludes(path)
 	if err != nil {
-		log.Warnf("cannot read Kea config file: %+v", err)
-		return
+		return nil, errors.WithMessage(err, "cannot read Kea config file")
 	}
 
 	config, err := keaconfig.NewFromJSON(text)
 	if err != nil {
-		log.Warnf("cannot parse Kea Control Agent config file: %+v", err)
+		return nil, errors.WithMessage(err, "cannot parse Kea config file")
+	}
+	return config, nil
+}
+
+func getCtrlTargetFromKeaConfig(path string) (address string, port int64, useSecureProtocol bool) {
+	config, err := readKeaConfig(path)
+	if err != nil {
+		log.Warnf("%+v", err)
 		return
 	}
 
@@ -214,17 +259,40 @@ func getCtrlTargetFromKeaConfig(path string) (address string, port int64, useSec
 	return
 }
 
+// Returns the paths to the UNIX domain sockets used by the Kea Control
+// Agent to forward commands to the daemons behind it. The relative paths
+// are joined with the current working directory of the Kea Control Agent.
+func getCtrlSocketPathsFromKeaConfig(path, cwd string) (paths []string) {
+	config, err := readKeaConfig(path)
+	if err != nil {
+		return
+	}
+	sockets := config.GetControlSockets()
+	for _, socket := range []*keaconfig.ControlSocket{sockets.Dhcp4, sockets.Dhcp6, sockets.D2, sockets.NetConf} {
+		if socket != nil && socket.SocketType == "unix" && socket.SocketName != "" {
+			paths = append(paths, getKeaAbsolutePath(socket.SocketName, cwd))
+		}
+	}
+	return
+}
+
+// Returns an absolute path. If the path is relative, it is joined
+// with the current working directory of the Kea process.
+func getKeaAbsolutePath(filePath, cwd string) string {
+	if !strings.HasPrefix(filePath, "/") {
+		return path.Join(cwd, filePath)
+	}
+	return filePath
+}
+
 func detectKeaApp(match []string, cwd string, httpClient *HTTPClient) App {
 	if len(match) < 3 {
 		log.Warnf("problem with parsing Kea cmdline: %s", match[0])
 		return nil
 	}
-	keaConfPath := match[2]
 
 	// if path to config is not absolute then join it with CWD of kea
-	if !strings.HasPrefix(keaConfPath, "/") {
-		keaConfPath = path.Join(cwd, keaConfPath)
-	}
+	keaConfPath := getKeaAbsolutePath(match[2], cwd)
 
 	address, port, useSecureProtocol := getCtrlTargetFromKeaConfig(keaConfPath)
 	if address == "" || port == 0 {
@@ -243,7 +311,68 @@ func detectKeaApp(match []string, cwd string, httpClient *HTTPClient) App {
 			Type:         AppTypeKea,
 			AccessPoints: accessPoints,
 		},
-		HTTPClient: httpClient,
+		HTTPClient:         httpClient,
+		ControlSocketPaths: getCtrlSocketPathsFromKeaConfig(keaConfPath, cwd),
+	}
+
+	return keaApp
+}
+
+// Returns the name of the Kea daemon used in the service parameter of
+// the commands, e.g. dhcp4, for the given process name, e.g. kea-dhcp4.
+func getKeaDaemonName(procName string) string {
+	if procName == keaDDNSProcName {
+		return "d2"
+	}
+	return strings.TrimPrefix(procName, "kea-")
+}
+
+// Detects the Kea DHCP or DDNS daemon which is controlled directly over
+// its control socket rather than via the Kea Control Agent. The match
+// holds the command line of the daemon process. The third element is
+// the path to the configuration file. The caSocketPaths holds the paths
+// to the control sockets used by the running Kea Control Agents. If the
+// daemon's control socket is among them, the daemon is controlled via
+// the Kea Control Agent and nil is returned. Each such daemon is returned
+// as a separate app having the control socket access point.
+func detectKeaDaemonApp(match []string, cwd string, caSocketPaths []string, socketClient *KeaSocketClient) App {
+	if len(match) < 4 {
+		log.Warnf("problem with parsing Kea daemon cmdline: %s", match[0])
+		return nil
+	}
+
+	keaConfPath := getKeaAbsolutePath(match[3], cwd)
+	config, err := readKeaConfig(keaConfPath)
+	if err != nil {
+		log.Warnf("%+v", err)
+		return nil
+	}
+
+	socket := config.GetControlSocket()
+	if socket == nil || socket.SocketType != "unix" || socket.SocketName == "" {
+		log.Debugf("no control socket configured for %s", match[2])
+		return nil
+	}
+	socketPath := getKeaAbsolutePath(socket.SocketName, cwd)
+
+	for _, caSocketPath := range caSocketPaths {
+		if caSocketPath == socketPath {
+			return nil
+		}
+	}
+
+	keaApp := &KeaApp{
+		BaseApp: BaseApp{
+			Type: AppTypeKea,
+			AccessPoints: []AccessPoint{
+				{
+					Type:    AccessPointControlSocket,
+					Address: socketPath,
+				},
+			},
+		},
+		SocketClient: socketClient,
+		DaemonName:   getKeaDaemonName(match[2]),
 	}
 
 	return keaApp
@@ -0,0 +1,72 @@
+package agent
+
+import (
+	"encoding/json"
+	"net"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Default timeout for the communication with the Kea daemons over
+// the control sockets.
+const defaultKeaSocketTimeout = 10 * time.Second
+
+// Client communicating with the Kea daemons over their UNIX domain
+// control sockets. It is used when the Kea Control Agent is not
+// running.
+type KeaSocketClient struct {
+	timeout time.Duration
+}
+
+// Create a client to contact with the Kea daemons over the control sockets.
+func NewKeaSocketClient() *KeaSocketClient {
+	return &KeaSocketClient{
+		timeout: defaultKeaSocketTimeout,
+	}
+}
+
+// Sends the command to the Kea daemon over the control socket and returns
+// the response. The service parameter is removed from the command because
+// it is only interpreted by the Kea Control Agent. The daemon returns a
+// single response rather than the list of responses returned by the Kea
+// Control Agent. Therefore, the response is wrapped in a list, so it can
+// be parsed in the same way as the responses received via the Kea Control
+// Agent.
+func (c *KeaSocketClient) Call(socketPath string, payload []byte) ([]byte, error) {
+	var command map[string]interface{}
+	if err := json.Unmarshal(payload, &command); err != nil {
+		return nil, errors.Wrapf(err, "problem with parsing the command sent to %s", socketPath)
+	}
+	delete(command, "service")
+	request, err := json.Marshal(command)
+	if err != nil {
+		return nil, errors.Wrapf(err, "problem with serializing the command sent to %s", socketPath)
+	}
+
+	conn, err := net.DialTimeout("unix", socketPath, c.timeout)
+	if err != nil {
+		return nil, errors.Wrapf(err, "problem with connecting to %s", socketPath)
+	}
+	defer conn.Close()
+
+	if err = conn.SetDeadline(time.Now().Add(c.timeout)); err != nil {
+		return nil, errors.Wrapf(err, "problem with setting the deadline for %s", socketPath)
+	}
+	if _, err = conn.Write(request); err != nil {
+		return nil, errors.Wrapf(err, "problem with sending the command to %s", socketPath)
+	}
+
+	// Kea may keep the connection open after sending the response, so the
+	// response is read until the complete JSON value has been received.
+	var response json.RawMessage
+	if err = json.NewDecoder(conn).Decode(&response); err != nil {
+		return nil, errors.Wrapf(err, "problem with reading the response from %s", socketPath)
+	}
+
+	body := make([]byte, 0, len(response)+2)
+	body = append(body, '[')
+	body = append(body, response...)
+	body = append(body, ']')
+	return body, nil
+}
@@ -0,0 +1,76 @@
+package agent
+
+import (
+	"encoding/json"
+	"net"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Starts a fake Kea daemon listening on the UNIX domain socket. It
+// responds with the specified response to each received command.
+// The received commands are sent over the returned channel. The
+// returned function stops the listener.
+func startFakeKeaSocketServer(t *testing.T, socketPath, response string) (chan map[string]interface{}, func()) {
+	listener, err := net.Listen("unix", socketPath)
+	require.NoError(t, err)
+
+	commands := make(chan map[string]interface{}, 10)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			var command map[string]interface{}
+			if err = json.NewDecoder(conn).Decode(&command); err == nil {
+				commands <- command
+				_, _ = conn.Write([]byte(response))
+			}
+			conn.Close()
+		}
+	}()
+
+	return commands, func() {
+		listener.Close()
+	}
+}
+
+// Test that the command is sent to the Kea daemon over the control socket
+// and that the response is wrapped in a list.
+func TestKeaSocketClientCall(t *testing.T) {
+	socketPath := path.Join(t.TempDir(), "kea4-ctrl-socket")
+	commands, stop := startFakeKeaSocketServer(t, socketPath, `{ "result": 0, "text": "1.9.6" }`)
+	defer stop()
+
+	client := NewKeaSocketClient()
+	body, err := client.Call(socketPath, []byte(`{ "command": "version-get", "service": [ "dhcp4" ] }`))
+	require.NoError(t, err)
+	require.JSONEq(t, `[ { "result": 0, "text": "1.9.6" } ]`, string(body))
+
+	// The service parameter is only understood by the Kea Control Agent,
+	// so it must not be sent to the daemon.
+	command := <-commands
+	require.Equal(t, "version-get", command["command"])
+	require.NotContains(t, command, "service")
+}
+
+// Test that an error is returned when the command is malformed or the
+// control socket does not exist.
+func TestKeaSocketClientCallError(t *testing.T) {
+	socketPath := path.Join(t.TempDir(), "kea4-ctrl-socket")
+	client := NewKeaSocketClient()
+
+	body, err := client.Call(socketPath, []byte(`{ "command": "version-get" }`))
+	require.Error(t, err)
+	require.Nil(t, body)
+
+	_, stop := startFakeKeaSocketServer(t, socketPath, `{ "result": 0 }`)
+	defer stop()
+
+	body, err = client.Call(socketPath, []byte(`{ "command": `))
+	require.Error(t, err)
+	require.Nil(t, body)
+}
@@ -0,0 +1,205 @@
+package agent
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Layouts of the timestamps at the beginning of the log lines. The first
+// one is used by Kea, the second one by BIND 9.
+var logTimestampLayouts = []string{ // nolint:gochecknoglobals
+	"2006-01-02 15:04:05.000",
+	"02-Jan-2006 15:04:05.000",
+}
+
+// Maximum length of the log line processed by the search.
+const maxLogSearchLineLength = 1024 * 1024
+
+// Criteria of the log file search.
+type logSearchCriteria struct {
+	// Regular expression to be matched against the lines. An empty
+	// pattern matches all lines.
+	Pattern *regexp.Regexp
+	// Lines logged before this time are not matched. Zero value
+	// means no lower bound.
+	Since time.Time
+	// Lines logged after this time are not matched. Zero value
+	// means no upper bound.
+	Until time.Time
+	// Number of lines returned before and after each matching line.
+	ContextLines int
+	// Maximum number of matches returned. Zero means no limit.
+	MaxMatches int64
+}
+
+// Single line matching the search criteria along with the context lines.
+type logMatch struct {
+	LineNumber int64
+	Line       string
+	Before     []string
+	After      []string
+}
+
+// Parses the timestamp at the beginning of the log line. The second
+// returned value is false if the line does not begin with a timestamp.
+func parseLogTimestamp(line string) (time.Time, bool) {
+	for _, layout := range logTimestampLayouts {
+		if len(line) < len(layout) {
+			continue
+		}
+		if ts, err := time.ParseInLocation(layout, line[:len(layout)], time.Local); err == nil {
+			return ts, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// Checks if the line logged at the specified time matches the criteria.
+// The known argument indicates whether the time at which the line was
+// logged is known. The line logged at the unknown time does not match
+// the criteria specifying the time range.
+func (c *logSearchCriteria) matches(line string, ts time.Time, known bool) bool {
+	if !c.Since.IsZero() || !c.Until.IsZero() {
+		if !known {
+			return false
+		}
+		if !c.Since.IsZero() && ts.Before(c.Since) {
+			return false
+		}
+		if !c.Until.IsZero() && ts.After(c.Until) {
+			return false
+		}
+	}
+	return c.Pattern == nil || c.Pattern.MatchString(line)
+}
+
+// Searches the specified log file for the lines matching the criteria.
+// The send function is called for each batch of matches. The lines which
+// do not begin with a timestamp, e.g. the continuation of the multi-line
+// log messages, are assumed to be logged at the time of the preceding
+// line. It returns the total number of matches. The search stops when
+// the maximum number of matches is reached or the context is cancelled.
+// It returns an error when the file is not allowed or it cannot be read.
+func (lt *logTailer) search(ctx context.Context, path string, criteria *logSearchCriteria, send func(matches []*logMatch) error) (int64, error) {
+	// Check if it is allowed to search this file.
+	if !lt.allowed(path) {
+		return 0, errors.Errorf("Access forbidden to the %s", path)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, errors.WithMessagef(err, "Failed to open file for searching: %s", path)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	const batchSize = 100
+
+	var (
+		count    int64
+		batch    []*logMatch
+		pending  []*logMatch // matches still waiting for the lines after them
+		previous []string    // lines preceding the current line
+		lineNo   int64
+		ts       time.Time
+		tsKnown  bool
+	)
+
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		err := send(batch)
+		batch = nil
+		return err
+	}
+
+	s := bufio.NewScanner(f)
+	s.Buffer(make([]byte, 0, 64*1024), maxLogSearchLineLength)
+	for s.Scan() {
+		if err = ctx.Err(); err != nil {
+			return count, nil
+		}
+
+		line := s.Text()
+		lineNo++
+		if lineTS, ok := parseLogTimestamp(line); ok {
+			ts, tsKnown = lineTS, true
+		}
+
+		// Append the line to the context of the pending matches and
+		// move the complete matches to the batch.
+		var stillPending []*logMatch
+		for _, m := range pending {
+			m.After = append(m.After, line)
+			if len(m.After) >= criteria.ContextLines {
+				batch = append(batch, m)
+			} else {
+				stillPending = append(stillPending, m)
+			}
+		}
+		pending = stillPending
+
+		if (criteria.MaxMatches == 0 || count < criteria.MaxMatches) && criteria.matches(line, ts, tsKnown) {
+			count++
+			m := &logMatch{
+				LineNumber: lineNo,
+				Line:       line,
+				Before:     append([]string{}, previous...),
+			}
+			if criteria.ContextLines > 0 {
+				pending = append(pending, m)
+			} else {
+				batch = append(batch, m)
+			}
+		}
+
+		if criteria.ContextLines > 0 {
+			previous = append(previous, line)
+			if len(previous) > criteria.ContextLines {
+				previous = previous[1:]
+			}
+		}
+
+		if len(batch) >= batchSize {
+			if err = flush(); err != nil {
+				return count, err
+			}
+		}
+
+		// All matches found and their context lines collected.
+		if criteria.MaxMatches > 0 && count >= criteria.MaxMatches && len(pending) == 0 {
+			break
+		}
+	}
+	if err = s.Err(); err != nil {
+		return count, errors.WithMessagef(err, "Failed to read the searched file: %s", path)
+	}
+
+	// The matches at the end of the file have fewer lines after them.
+	batch = append(batch, pending...)
+	if err = flush(); err != nil {
+		return count, err
+	}
+	return count, nil
+}
+
+// Compiles the pattern of the log search. The empty pattern is allowed
+// and matches all lines.
+func compileLogSearchPattern(pattern string) (*regexp.Regexp, error) {
+	if strings.TrimSpace(pattern) == "" {
+		return nil, nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Invalid search pattern: %s", pattern)
+	}
+	return re, nil
+}
@@ -0,0 +1,146 @@
+package agent
+
+import (
+	"context"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Log file contents used in the search tests.
+const testSearchedLog = `2021-12-20 10:00:00.000 INFO  [kea-dhcp4.dhcp4/1] DHCP4_STARTED
+2021-12-20 10:01:00.000 INFO  [kea-dhcp4.leases/1] DHCP4_LEASE_ALLOC [hwtype=1 01:02:03:04:05:06], cid=[no info], tid=0x1: lease 192.0.2.1 has been allocated
+2021-12-20 10:02:00.000 DEBUG [kea-dhcp4.packets/1] DHCP4_PACKET_RECEIVED
+continuation of the multi-line message
+2021-12-20 10:03:00.000 INFO  [kea-dhcp4.leases/1] DHCP4_LEASE_ALLOC [hwtype=1 01:02:03:04:05:06], cid=[no info], tid=0x2: lease 192.0.2.1 has been allocated
+2021-12-20 10:04:00.000 INFO  [kea-dhcp4.dhcp4/1] DHCP4_SHUTDOWN
+`
+
+// Creates the log file for the search tests and the log tailer allowing
+// to search it.
+func setupLogSearchTest(t *testing.T) (*logTailer, string) {
+	filename := path.Join(t.TempDir(), "kea-dhcp4.log")
+	err := os.WriteFile(filename, []byte(testSearchedLog), 0o600)
+	require.NoError(t, err)
+
+	lt := newLogTailer()
+	lt.allow(filename)
+	return lt, filename
+}
+
+// Runs the search and returns all matches.
+func runLogSearch(t *testing.T, lt *logTailer, filename string, criteria *logSearchCriteria) (int64, []*logMatch) {
+	var matches []*logMatch
+	count, err := lt.search(context.Background(), filename, criteria, func(m []*logMatch) error {
+		matches = append(matches, m...)
+		return nil
+	})
+	require.NoError(t, err)
+	return count, matches
+}
+
+// Test that the timestamps used by Kea and BIND 9 are recognized.
+func TestParseLogTimestamp(t *testing.T) {
+	ts, ok := parseLogTimestamp("2021-12-20 10:01:02.345 INFO  [kea-dhcp4.dhcp4/1] DHCP4_STARTED")
+	require.True(t, ok)
+	require.Equal(t, time.Date(2021, 12, 20, 10, 1, 2, 345000000, time.Local), ts)
+
+	ts, ok = parseLogTimestamp("20-Dec-2021 10:01:02.345 general: info: running")
+	require.True(t, ok)
+	require.Equal(t, time.Date(2021, 12, 20, 10, 1, 2, 345000000, time.Local), ts)
+
+	_, ok = parseLogTimestamp("continuation of the multi-line message")
+	require.False(t, ok)
+}
+
+// Test that the lines matching the pattern are returned with the
+// context lines.
+func TestSearchPattern(t *testing.T) {
+	lt, filename := setupLogSearchTest(t)
+
+	pattern, err := compileLogSearchPattern(`01:02:03:04:05:06`)
+	require.NoError(t, err)
+
+	count, matches := runLogSearch(t, lt, filename, &logSearchCriteria{
+		Pattern:      pattern,
+		ContextLines: 1,
+	})
+	require.EqualValues(t, 2, count)
+	require.Len(t, matches, 2)
+
+	require.EqualValues(t, 2, matches[0].LineNumber)
+	require.Contains(t, matches[0].Line, "tid=0x1")
+	require.Len(t, matches[0].Before, 1)
+	require.Contains(t, matches[0].Before[0], "DHCP4_STARTED")
+	require.Len(t, matches[0].After, 1)
+	require.Contains(t, matches[0].After[0], "DHCP4_PACKET_RECEIVED")
+
+	require.EqualValues(t, 5, matches[1].LineNumber)
+	require.Contains(t, matches[1].Line, "tid=0x2")
+	require.Equal(t, []string{"continuation of the multi-line message"}, matches[1].Before)
+	require.Len(t, matches[1].After, 1)
+	require.Contains(t, matches[1].After[0], "DHCP4_SHUTDOWN")
+}
+
+// Test that the lines logged within the time range are returned. The
+// lines without the timestamps inherit the time of the preceding line.
+func TestSearchTimeRange(t *testing.T) {
+	lt, filename := setupLogSearchTest(t)
+
+	count, matches := runLogSearch(t, lt, filename, &logSearchCriteria{
+		Since: time.Date(2021, 12, 20, 10, 2, 0, 0, time.Local),
+		Until: time.Date(2021, 12, 20, 10, 3, 0, 0, time.Local),
+	})
+	require.EqualValues(t, 3, count)
+	require.Len(t, matches, 3)
+	require.EqualValues(t, 3, matches[0].LineNumber)
+	require.EqualValues(t, 4, matches[1].LineNumber)
+	require.EqualValues(t, 5, matches[2].LineNumber)
+	require.Empty(t, matches[0].Before)
+	require.Empty(t, matches[0].After)
+}
+
+// Test that the search stops when the maximum number of matches is reached.
+func TestSearchMaxMatches(t *testing.T) {
+	lt, filename := setupLogSearchTest(t)
+
+	pattern, err := compileLogSearchPattern(`DHCP4_`)
+	require.NoError(t, err)
+
+	count, matches := runLogSearch(t, lt, filename, &logSearchCriteria{
+		Pattern:      pattern,
+		ContextLines: 2,
+		MaxMatches:   2,
+	})
+	require.EqualValues(t, 2, count)
+	require.Len(t, matches, 2)
+	require.EqualValues(t, 1, matches[0].LineNumber)
+	require.EqualValues(t, 2, matches[1].LineNumber)
+	// The context lines after the last match should be collected.
+	require.Len(t, matches[1].After, 2)
+}
+
+// Test that searching the file which is not allowed or does not exist
+// results in an error and that the invalid pattern is rejected.
+func TestSearchError(t *testing.T) {
+	lt := newLogTailer()
+	filename := path.Join(t.TempDir(), "kea-dhcp4.log")
+	send := func(m []*logMatch) error { return nil }
+
+	_, err := lt.search(context.Background(), filename, &logSearchCriteria{}, send)
+	require.Error(t, err)
+
+	lt.allow(filename)
+	_, err = lt.search(context.Background(), filename, &logSearchCriteria{}, send)
+	require.Error(t, err)
+
+	_, err = compileLogSearchPattern(`[abc`)
+	require.Error(t, err)
+
+	pattern, err := compileLogSearchPattern(` `)
+	require.NoError(t, err)
+	require.Nil(t, pattern)
+}
@@ -2,26 +2,36 @@ package agent
 
 import (
 	"bufio"
+	"context"
+	stderrors "errors"
 	"io"
 	"os"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/pkg/errors"
 )
 
+// Default interval between the checks whether new lines have been
+// appended to the followed file.
+const defaultFollowInterval = 500 * time.Millisecond
+
 // Log tailer provides means for viewing log files. It maintains the list of
 // unique files which can be viewed. If the file is not on the list of the allowed
 // files, an error is returned upon an attempt to view it.
 type logTailer struct {
-	allowedPaths map[string]bool
-	mutex        *sync.Mutex
+	allowedPaths   map[string]bool
+	mutex          *sync.Mutex
+	followInterval time.Duration
 }
 
 // Creates new instance of the log tailer.
 func newLogTailer() *logTailer {
 	lt := &logTailer{
-		allowedPaths: make(map[string]bool),
-		mutex:        new(sync.Mutex),
+		allowedPaths:   make(map[string]bool),
+		mutex:          new(sync.Mutex),
+		followInterval: defaultFollowInterval,
 	}
 	return lt
 }
@@ -87,3 +97,129 @@ func (lt *logTailer) tail(path string, offset int64) (lines []string, err error)
 	}
 	return lines, err
 }
+
+// Reads complete lines available in the reader. The incomplete line at the
+// end of the file is accumulated in the partial buffer and returned when
+// its remaining part is appended. It returns the number of bytes read.
+func readAppendedLines(reader *bufio.Reader, partial *string) (lines []string, read int64, err error) {
+	for {
+		var line string
+		line, err = reader.ReadString('\n')
+		read += int64(len(line))
+		if err != nil {
+			if stderrors.Is(err, io.EOF) {
+				*partial += line
+				err = nil
+			}
+			return lines, read, err
+		}
+		lines = append(lines, strings.TrimRight(*partial+line, "\r\n"))
+		*partial = ""
+	}
+}
+
+// Follows the specified log file. The lines located within the offset from
+// the end of the file are sent first. Next, the lines appended to the file
+// are sent as they appear. The send function is called for each batch of
+// lines. When the file is rotated, i.e. the path points to a new file, the
+// remaining lines of the old file are sent and the new file is followed from
+// its beginning. When the file is truncated, it is followed from its new
+// beginning. The function returns when the context is cancelled or the send
+// function returns an error. It returns an error when the offset is negative,
+// the file is not allowed or it cannot be read.
+func (lt *logTailer) follow(ctx context.Context, path string, offset int64, send func(lines []string) error) error {
+	if offset < 0 {
+		return errors.Errorf("Invalid negative offset %d for following the %s", offset, path)
+	}
+
+	// Check if it is allowed to follow this file.
+	if !lt.allowed(path) {
+		return errors.Errorf("Access forbidden to the %s", path)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return errors.WithMessagef(err, "Failed to open file for following: %s", path)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	stat, err := f.Stat()
+	if err != nil {
+		return errors.WithMessagef(err, "Failed to stat the file opened for following: %s", path)
+	}
+
+	// Can't go beyond the file size.
+	if offset > stat.Size() {
+		offset = stat.Size()
+	}
+	position, err := f.Seek(-offset, io.SeekEnd)
+	if err != nil {
+		return errors.WithMessagef(err, "Failed to seek in the file opened for following: %s", path)
+	}
+
+	reader := bufio.NewReader(f)
+	partial := ""
+
+	// Reads the lines appended to the currently followed file and sends them.
+	sendAppendedLines := func() error {
+		lines, read, err := readAppendedLines(reader, &partial)
+		position += read
+		if err != nil {
+			return errors.WithMessagef(err, "Failed to read the followed file: %s", path)
+		}
+		if len(lines) > 0 {
+			return send(lines)
+		}
+		return nil
+	}
+
+	ticker := time.NewTicker(lt.followInterval)
+	defer ticker.Stop()
+
+	for {
+		if err = sendAppendedLines(); err != nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		// The file may be temporarily missing during the rotation.
+		newStat, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+
+		switch {
+		case !os.SameFile(stat, newStat):
+			// The file has been rotated. Send the lines appended to the
+			// old file before the rotation and switch to the new file.
+			if err = sendAppendedLines(); err != nil {
+				return err
+			}
+			newFile, err := os.Open(path)
+			if err != nil {
+				continue
+			}
+			_ = f.Close()
+			f = newFile
+			stat = newStat
+			reader.Reset(f)
+			partial = ""
+			position = 0
+		case newStat.Size() < position:
+			// The file has been truncated.
+			if _, err = f.Seek(0, io.SeekStart); err != nil {
+				return errors.WithMessagef(err, "Failed to seek in the truncated file: %s", path)
+			}
+			reader.Reset(f)
+			partial = ""
+			position = 0
+		}
+	}
+}
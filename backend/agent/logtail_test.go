@@ -1,9 +1,11 @@
 package agent
 
 import (
+	"context"
 	"fmt"
 	"math/rand"
 	"os"
+	"path"
 	"testing"
 	"time"
 
@@ -64,3 +66,100 @@ func TestTailNotExistingFile(t *testing.T) {
 	_, err := lt.tail("non-existing-file", 100)
 	require.Error(t, err)
 }
+
+// Collects the lines sent by the log tailer while following a file.
+type followedLines struct {
+	lines chan string
+}
+
+// Creates the collector and returns it along with the send function
+// to be passed to the follow function.
+func newFollowedLines() (*followedLines, func(lines []string) error) {
+	fl := &followedLines{
+		lines: make(chan string, 100),
+	}
+	return fl, func(lines []string) error {
+		for _, line := range lines {
+			fl.lines <- line
+		}
+		return nil
+	}
+}
+
+// Waits for the next followed line.
+func (fl *followedLines) next(t *testing.T) string {
+	select {
+	case line := <-fl.lines:
+		return line
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "timeout waiting for the followed line")
+	}
+	return ""
+}
+
+// Test that the lines appended to the followed file are sent, also
+// after the file rotation and truncation.
+func TestFollow(t *testing.T) {
+	filename := path.Join(t.TempDir(), "kea-dhcp4.log")
+	err := os.WriteFile(filename, []byte("line 1\nline 2\n"), 0o600)
+	require.NoError(t, err)
+
+	lt := newLogTailer()
+	lt.followInterval = 10 * time.Millisecond
+	lt.allow(filename)
+
+	fl, send := newFollowedLines()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		// The offset covers the last line only.
+		done <- lt.follow(ctx, filename, 7, send)
+	}()
+	require.Equal(t, "line 2", fl.next(t))
+
+	// Append lines. The incomplete line should be sent when it is completed.
+	f, err := os.OpenFile(filename, os.O_APPEND|os.O_WRONLY, 0o600)
+	require.NoError(t, err)
+	fmt.Fprint(f, "line 3\nline ")
+	require.Equal(t, "line 3", fl.next(t))
+	fmt.Fprint(f, "4\n")
+	require.Equal(t, "line 4", fl.next(t))
+
+	// Rotate the file. The line appended before the rotation should be sent
+	// and the new file should be followed from its beginning.
+	fmt.Fprint(f, "line 5\n")
+	f.Close()
+	require.NoError(t, os.Rename(filename, filename+".1"))
+	require.NoError(t, os.WriteFile(filename, []byte("line 6\n"), 0o600))
+	require.Equal(t, "line 5", fl.next(t))
+	require.Equal(t, "line 6", fl.next(t))
+
+	// Truncate the file and write a shorter contents.
+	require.NoError(t, os.Truncate(filename, 0))
+	time.Sleep(50 * time.Millisecond)
+	f, err = os.OpenFile(filename, os.O_APPEND|os.O_WRONLY, 0o600)
+	require.NoError(t, err)
+	fmt.Fprint(f, "7\n")
+	f.Close()
+	require.Equal(t, "7", fl.next(t))
+
+	cancel()
+	require.NoError(t, <-done)
+}
+
+// Test that following a file which is not allowed or does not exist
+// or following with a negative offset results in an error.
+func TestFollowError(t *testing.T) {
+	filename := path.Join(t.TempDir(), "kea-dhcp4.log")
+	lt := newLogTailer()
+	_, send := newFollowedLines()
+
+	require.Error(t, lt.follow(context.Background(), filename, 0, send))
+
+	lt.allow(filename)
+	require.Error(t, lt.follow(context.Background(), filename, 0, send))
+
+	err := os.WriteFile(filename, []byte("first line\n"), 0o600)
+	require.NoError(t, err)
+	require.Error(t, lt.follow(context.Background(), filename, -1, send))
+}
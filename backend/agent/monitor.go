@@ -24,10 +24,14 @@ type AccessPoint struct {
 	Key               string
 }
 
-// Currently supported types are: "control" and "statistics".
+// Currently supported types are: "control", "control-socket" and "statistics".
+// The "control-socket" access point is used to communicate with the Kea
+// daemons over their UNIX domain control sockets. Its address is the path
+// to the socket and the port is zero.
 const (
-	AccessPointControl    = "control"
-	AccessPointStatistics = "statistics"
+	AccessPointControl       = "control"
+	AccessPointControlSocket = "control-socket"
+	AccessPointStatistics    = "statistics"
 )
 
 // Base application information. This structure is embedded
@@ -70,8 +74,11 @@ type appMonitor struct {
 
 // Names of apps that are being detected.
 const (
-	keaProcName   = "kea-ctrl-agent"
-	namedProcName = "named"
+	keaProcName       = "kea-ctrl-agent"
+	keaDHCPv4ProcName = "kea-dhcp4"
+	keaDHCPv6ProcName = "kea-dhcp6"
+	keaDDNSProcName   = "kea-dhcp-ddns"
+	namedProcName     = "named"
 )
 
 // Creates an AppMonitor instance. It used to start it as well, but this is now done
@@ -170,7 +177,10 @@ func printNewOrUpdatedApps(newApps []App, oldApps []App) {
 		for _, app := range newUpdatedApps {
 			var acPts []string
 			for _, acPt := range app.GetBaseApp().AccessPoints {
-				url := storkutil.HostWithPortURL(acPt.Address, acPt.Port, acPt.UseSecureProtocol)
+				url := acPt.Address
+				if acPt.Type != AccessPointControlSocket {
+					url = storkutil.HostWithPortURL(acPt.Address, acPt.Port, acPt.UseSecureProtocol)
+				}
 				s := fmt.Sprintf("%s: %s", acPt.Type, url)
 				acPts = append(acPts, s)
 			}
@@ -185,19 +195,35 @@ func (sm *appMonitor) detectApps(storkAgent *StorkAgent) {
 	// substring. Such found processes are being processed further and all other
 	// Kea daemons are discovered and queried for their versions, etc.
 	keaPtrn := regexp.MustCompile(`(.*?)kea-ctrl-agent\s+.*-c\s+(\S+)`)
+	// Kea DHCP and DDNS daemons are detected to control them directly over
+	// their control sockets when they are not controlled via the Kea Control
+	// Agent. This allows for monitoring the Kea servers on the hosts which
+	// do not run the Kea Control Agent.
+	keaDaemonPtrn := regexp.MustCompile(`(.*?)(kea-dhcp4|kea-dhcp6|kea-dhcp-ddns)\s+.*-c\s+(\S+)`)
 	// BIND 9 app is being detecting by browsing list of processes in the system
 	// where cmdline of the process contains given pattern with named substring.
 	bind9Ptrn := regexp.MustCompile(`(.*?)named\s+(.*)`)
 
 	var apps []App
 
+	// The Kea daemons are processed after all Kea Control Agents have been
+	// detected, to find out which of them are controlled via the Kea Control
+	// Agents.
+	type keaDaemonProc struct {
+		match []string
+		cwd   string
+		pid   int32
+	}
+	var keaDaemonProcs []keaDaemonProc
+
 	procs, _ := process.Processes()
 	for _, p := range procs {
 		procName, _ := p.Name()
 		cmdline := ""
 		cwd := ""
 		var err error
-		if procName == keaProcName || procName == namedProcName {
+		isKeaDaemon := procName == keaDHCPv4ProcName || procName == keaDHCPv6ProcName || procName == keaDDNSProcName
+		if procName == keaProcName || procName == namedProcName || isKeaDaemon {
 			cmdline, err = p.Cmdline()
 			if err != nil {
 				log.Warnf("cannot get process command line: %+v", err)
@@ -223,6 +249,14 @@ func (sm *appMonitor) detectApps(storkAgent *StorkAgent) {
 			continue
 		}
 
+		if isKeaDaemon {
+			m := keaDaemonPtrn.FindStringSubmatch(cmdline)
+			if m != nil {
+				keaDaemonProcs = append(keaDaemonProcs, keaDaemonProc{match: m, cwd: cwd, pid: p.Pid})
+			}
+			continue
+		}
+
 		if procName == namedProcName {
 			// detect bind9
 			m := bind9Ptrn.FindStringSubmatch(cmdline)
@@ -238,6 +272,21 @@ func (sm *appMonitor) detectApps(storkAgent *StorkAgent) {
 		}
 	}
 
+	// Detect the Kea daemons which are not controlled via the Kea Control Agents.
+	var caSocketPaths []string
+	for _, app := range apps {
+		if keaApp, ok := app.(*KeaApp); ok {
+			caSocketPaths = append(caSocketPaths, keaApp.ControlSocketPaths...)
+		}
+	}
+	for _, proc := range keaDaemonProcs {
+		keaApp := detectKeaDaemonApp(proc.match, proc.cwd, caSocketPaths, storkAgent.KeaSocketClient)
+		if keaApp != nil {
+			keaApp.GetBaseApp().Pid = proc.pid
+			apps = append(apps, keaApp)
+		}
+	}
+
 	// check changes in apps and print them
 	printNewOrUpdatedApps(apps, sm.apps)
 
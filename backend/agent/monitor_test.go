@@ -410,6 +410,45 @@ func TestDetectKeaApp(t *testing.T) {
 	checkApp(app)
 }
 
+// Test that the Kea daemon not controlled via the Kea Control Agent is
+// detected as an app having the control socket access point.
+func TestDetectKeaDaemonApp(t *testing.T) {
+	dir := t.TempDir()
+	configPath := path.Join(dir, "kea-dhcp4.conf")
+	err := os.WriteFile(configPath, []byte(`{
+		"Dhcp4": {
+			"control-socket": {
+				"socket-type": "unix",
+				"socket-name": "kea4-ctrl-socket"
+			}
+		}
+	}`), 0o600)
+	require.NoError(t, err)
+
+	socketClient := NewKeaSocketClient()
+
+	// The relative socket path is joined with the daemon's CWD.
+	app := detectKeaDaemonApp([]string{"", "", "kea-dhcp4", "kea-dhcp4.conf"}, dir, nil, socketClient)
+	require.NotNil(t, app)
+	require.Equal(t, AppTypeKea, app.GetBaseApp().Type)
+	require.Len(t, app.GetBaseApp().AccessPoints, 1)
+	ctrlPoint := app.GetBaseApp().AccessPoints[0]
+	require.Equal(t, AccessPointControlSocket, ctrlPoint.Type)
+	require.Equal(t, path.Join(dir, "kea4-ctrl-socket"), ctrlPoint.Address)
+	require.Zero(t, ctrlPoint.Port)
+	require.Equal(t, "dhcp4", app.(*KeaApp).DaemonName)
+
+	// The daemon is controlled via the Kea Control Agent.
+	app = detectKeaDaemonApp([]string{"", "", "kea-dhcp4", configPath}, dir, []string{path.Join(dir, "kea4-ctrl-socket")}, socketClient)
+	require.Nil(t, app)
+
+	// The daemon has no control socket.
+	err = os.WriteFile(configPath, []byte(`{ "Dhcp4": { } }`), 0o600)
+	require.NoError(t, err)
+	app = detectKeaDaemonApp([]string{"", "", "kea-dhcp4", configPath}, dir, nil, socketClient)
+	require.Nil(t, app)
+}
+
 func TestGetAccessPoint(t *testing.T) {
 	bind9App := &Bind9App{
 		BaseApp: BaseApp{
@@ -1,15 +1,14 @@
 package agent
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"math"
 	"net"
 	"net/http"
+	"path"
 	"sort"
 	"strconv"
 	"strings"
@@ -23,6 +22,7 @@ import (
 	"github.com/urfave/cli/v2"
 
 	"isc.org/stork"
+	bind9ctrl "isc.org/stork/appctrl/bind9"
 	storkutil "isc.org/stork/util"
 )
 
@@ -40,6 +40,75 @@ type PromBind9ViewStats struct {
 	ResolverCachestats map[string]float64
 	ResolverQtypes     map[string]float64
 	ResolverStats      map[string]float64
+	Zones              map[string]PromBind9ZoneStats
+}
+
+// Statistics of a single zone. They are returned by BIND 9 when the
+// zone-statistics are enabled for the zone.
+type PromBind9ZoneStats struct {
+	Serial      float64
+	LoadTime    time.Time
+	RefreshTime time.Time
+	ExpireTime  time.Time
+	// Zone counters, e.g. QrySuccess or XfrSuccess.
+	Rcodes map[string]float64
+	// Signing and refreshing operations per DNSSEC key ID.
+	DNSSECSign    map[string]float64
+	DNSSECRefresh map[string]float64
+}
+
+// Filter limiting the zones whose stats are exported. The zones are
+// matched using the shell patterns, e.g. *.example.org. The empty include
+// list includes all zones. The exclude list takes precedence over the
+// include list.
+type bind9ZoneFilter struct {
+	include []string
+	exclude []string
+}
+
+// Creates the zone filter from the include and exclude lists specified
+// in the agent settings.
+func newBind9ZoneFilter(settings *cli.Context) *bind9ZoneFilter {
+	filter := &bind9ZoneFilter{}
+	for _, pattern := range settings.StringSlice("prometheus-bind9-exporter-zones-include") {
+		if pattern = normalizeZoneName(pattern); pattern != "" {
+			filter.include = append(filter.include, pattern)
+		}
+	}
+	for _, pattern := range settings.StringSlice("prometheus-bind9-exporter-zones-exclude") {
+		if pattern = normalizeZoneName(pattern); pattern != "" {
+			filter.exclude = append(filter.exclude, pattern)
+		}
+	}
+	return filter
+}
+
+// Converts the zone name to lower case and removes the trailing dot.
+func normalizeZoneName(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name != "." {
+		name = strings.TrimSuffix(name, ".")
+	}
+	return name
+}
+
+// Checks if the name matches any of the patterns.
+func matchesAnyZonePattern(name string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if matched, err := path.Match(pattern, name); err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
+
+// Checks if the stats of the zone should be exported.
+func (f *bind9ZoneFilter) isZoneIncluded(name string) bool {
+	name = normalizeZoneName(name)
+	if matchesAnyZonePattern(name, f.exclude) {
+		return false
+	}
+	return len(f.include) == 0 || matchesAnyZonePattern(name, f.include)
 }
 
 // Statistics to be exported.
@@ -65,6 +134,9 @@ type PromBind9Exporter struct {
 	HTTPClient *HTTPClient
 	HTTPServer *http.Server
 
+	// Formats served by the named statistics-channels.
+	namedStatsFormats namedStatsFormats
+
 	up               int
 	procID           int32
 	procExporter     prometheus.Collector
@@ -72,6 +144,11 @@ type PromBind9Exporter struct {
 	serverStatsDesc  map[string]*prometheus.Desc
 	trafficStatsDesc map[string]*prometheus.Desc
 	viewStatsDesc    map[string]*prometheus.Desc
+	zoneStatsDesc    map[string]*prometheus.Desc
+
+	// Filter of the zones whose stats are exported. It is nil when
+	// the per-zone stats are disabled.
+	zoneFilter *bind9ZoneFilter
 
 	stats PromBind9ExporterStats
 }
@@ -362,9 +439,61 @@ func NewPromBind9Exporter(settings *cli.Context, appMonitor AppMonitor) *PromBin
 		"Number of successful zone transfers.",
 		nil, nil)
 
+	// zone stats
+	zoneStatsDesc := make(map[string]*prometheus.Desc)
+	zoneLabels := []string{"view", "zone"}
+
+	// zone_serial
+	zoneStatsDesc["serial"] = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "zone", "serial"),
+		"Serial number of the zone.",
+		zoneLabels, nil)
+	// zone_load_time_seconds
+	zoneStatsDesc["loaded"] = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "zone", "load_time_seconds"),
+		"Time of the last load of the zone since unix epoch in seconds.",
+		zoneLabels, nil)
+	// zone_refresh_time_seconds
+	zoneStatsDesc["refresh"] = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "zone", "refresh_time_seconds"),
+		"Time of the next refresh of the secondary zone since unix epoch in seconds.",
+		zoneLabels, nil)
+	// zone_expire_time_seconds
+	zoneStatsDesc["expires"] = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "zone", "expire_time_seconds"),
+		"Expiration time of the secondary zone since unix epoch in seconds.",
+		zoneLabels, nil)
+	// zone_queries_total
+	zoneStatsDesc["queries"] = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "zone", "queries_total"),
+		"Number of queries for the zone by result.",
+		[]string{"view", "zone", "result"}, nil)
+	// zone_transfers_total
+	zoneStatsDesc["transfers"] = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "zone", "transfers_total"),
+		"Number of zone transfers by result.",
+		[]string{"view", "zone", "result"}, nil)
+	// zone_dnssec_sign_operations_total
+	zoneStatsDesc["dnssec-sign"] = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "zone", "dnssec_sign_operations_total"),
+		"Number of DNSSEC signing operations by key ID.",
+		[]string{"view", "zone", "key"}, nil)
+	// zone_dnssec_refresh_operations_total
+	zoneStatsDesc["dnssec-refresh"] = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "zone", "dnssec_refresh_operations_total"),
+		"Number of DNSSEC signature refresh operations by key ID.",
+		[]string{"view", "zone", "key"}, nil)
+
 	pbe.serverStatsDesc = serverStatsDesc
 	pbe.trafficStatsDesc = trafficStatsDesc
 	pbe.viewStatsDesc = viewStatsDesc
+	pbe.zoneStatsDesc = zoneStatsDesc
+
+	// Collecting per zone stats is disabled by default because of the
+	// potentially large number of zones.
+	if settings.Bool("prometheus-bind9-exporter-per-zone-stats") {
+		pbe.zoneFilter = newBind9ZoneFilter(settings)
+	}
 
 	incomingQueries := make(map[string]float64)
 	views := make(map[string]PromBind9ViewStats)
@@ -392,6 +521,9 @@ func (pbe *PromBind9Exporter) Describe(ch chan<- *prometheus.Desc) {
 	for _, m := range pbe.viewStatsDesc {
 		ch <- m
 	}
+	for _, m := range pbe.zoneStatsDesc {
+		ch <- m
+	}
 }
 
 // collectTime collects time stats.
@@ -524,6 +656,62 @@ func (pbe *PromBind9Exporter) trafficSizesHistogram(stats map[string]float64) (c
 	return count, sum, buckets, nil
 }
 
+// collectZoneStats delivers the stats of a single zone.
+func (pbe *PromBind9Exporter) collectZoneStats(view, zone string, zoneStats PromBind9ZoneStats, ch chan<- prometheus.Metric) {
+	// zone_serial
+	ch <- prometheus.MustNewConstMetric(
+		pbe.zoneStatsDesc["serial"],
+		prometheus.GaugeValue, zoneStats.Serial, view, zone)
+
+	// zone_load_time_seconds
+	// zone_refresh_time_seconds
+	// zone_expire_time_seconds
+	times := map[string]time.Time{
+		"loaded":  zoneStats.LoadTime,
+		"refresh": zoneStats.RefreshTime,
+		"expires": zoneStats.ExpireTime,
+	}
+	for key, timeStat := range times {
+		if !timeStat.IsZero() {
+			ch <- prometheus.MustNewConstMetric(
+				pbe.zoneStatsDesc[key],
+				prometheus.GaugeValue, float64(timeStat.Unix()), view, zone)
+		}
+	}
+
+	// zone_queries_total
+	// zone_transfers_total
+	transferResults := map[string]string{
+		"XfrSuccess": "success",
+		"XfrFail":    "failure",
+		"XfrRej":     "rejected",
+	}
+	for statName, statValue := range zoneStats.Rcodes {
+		if result, ok := transferResults[statName]; ok {
+			ch <- prometheus.MustNewConstMetric(
+				pbe.zoneStatsDesc["transfers"],
+				prometheus.CounterValue, statValue, view, zone, result)
+		} else if strings.HasPrefix(statName, "Qry") {
+			ch <- prometheus.MustNewConstMetric(
+				pbe.zoneStatsDesc["queries"],
+				prometheus.CounterValue, statValue, view, zone, strings.TrimPrefix(statName, "Qry"))
+		}
+	}
+
+	// zone_dnssec_sign_operations_total
+	for key, statValue := range zoneStats.DNSSECSign {
+		ch <- prometheus.MustNewConstMetric(
+			pbe.zoneStatsDesc["dnssec-sign"],
+			prometheus.CounterValue, statValue, view, zone, key)
+	}
+	// zone_dnssec_refresh_operations_total
+	for key, statValue := range zoneStats.DNSSECRefresh {
+		ch <- prometheus.MustNewConstMetric(
+			pbe.zoneStatsDesc["dnssec-refresh"],
+			prometheus.CounterValue, statValue, view, zone, key)
+	}
+}
+
 // collectResolverStat fetches a specific resolver view statistic.
 func (pbe *PromBind9Exporter) collectResolverStat(statName, view string, viewStat PromBind9ViewStats, ch chan<- prometheus.Metric) {
 	statValue, ok := viewStat.ResolverStats[statName]
@@ -790,6 +978,11 @@ func (pbe *PromBind9Exporter) Collect(ch chan<- prometheus.Metric) {
 		// resolver_dnssec_validation_success_total
 		valSuccess := []string{"ValOk", "ValNegOk"}
 		pbe.collectResolverLabelStat("ValSuccess", view, viewStats, ch, valSuccess)
+
+		// Zone metrics.
+		for zone, zoneStats := range viewStats.Zones {
+			pbe.collectZoneStats(view, zone, zoneStats, ch)
+		}
 	}
 }
 
@@ -926,6 +1119,11 @@ func (pbe *PromBind9Exporter) scrapeViewStats(viewName string, viewStatsIfc inte
 		return
 	}
 
+	// Parse zones.
+	if pbe.zoneFilter != nil {
+		pbe.scrapeZoneStats(viewName, viewStats)
+	}
+
 	// Parse resolver.
 	resolverIfc, ok := viewStats["resolver"]
 	if !ok {
@@ -1065,6 +1263,84 @@ func (pbe *PromBind9Exporter) scrapeViewStats(viewName string, viewStatsIfc inte
 	}
 }
 
+// scrapeFloatMap is an utility to get the numeric values from a map.
+func scrapeFloatMap(statIfc interface{}) map[string]float64 {
+	storageMap := make(map[string]float64)
+	stats, ok := statIfc.(map[string]interface{})
+	if !ok {
+		return storageMap
+	}
+	for statName, statValueIfc := range stats {
+		if statValue, ok := statValueIfc.(float64); ok {
+			storageMap[statName] = statValue
+		}
+	}
+	return storageMap
+}
+
+// scrapeZoneTime is an utility to get a time of the zone from a map.
+// It returns zero time if the time is not available.
+func scrapeZoneTime(zoneMap map[string]interface{}, statName string) time.Time {
+	timeStr, ok := zoneMap[statName].(string)
+	if !ok {
+		return time.Time{}
+	}
+	timeVal, err := time.Parse(time.RFC3339, timeStr)
+	if err != nil {
+		log.Errorf("problem with parsing time %s: %+v", timeStr, err)
+		return time.Time{}
+	}
+	return timeVal
+}
+
+// scrapeZoneStats stores the stats of the zones in the view. The zones
+// excluded by the filter are skipped.
+func (pbe *PromBind9Exporter) scrapeZoneStats(viewName string, viewStats map[string]interface{}) {
+	zones := make(map[string]PromBind9ZoneStats)
+	defer func() {
+		view := pbe.stats.Views[viewName]
+		view.Zones = zones
+		pbe.stats.Views[viewName] = view
+	}()
+
+	zonesIfc, ok := viewStats["zones"]
+	if !ok {
+		log.Infof("no 'zones' in viewStats: %+v", viewStats)
+		return
+	}
+	zoneList, ok := zonesIfc.([]interface{})
+	if !ok {
+		log.Errorf("problem with casting zonesIfc: %+v", zonesIfc)
+		return
+	}
+
+	for _, zoneIfc := range zoneList {
+		zoneMap, ok := zoneIfc.(map[string]interface{})
+		if !ok {
+			log.Errorf("problem with casting zoneIfc: %+v", zoneIfc)
+			continue
+		}
+		name, ok := zoneMap["name"].(string)
+		if !ok || !pbe.zoneFilter.isZoneIncluded(name) {
+			continue
+		}
+
+		serial, ok := zoneMap["serial"].(float64)
+		if !ok {
+			serial = -1
+		}
+		zones[name] = PromBind9ZoneStats{
+			Serial:        serial,
+			LoadTime:      scrapeZoneTime(zoneMap, "loaded"),
+			RefreshTime:   scrapeZoneTime(zoneMap, "refresh"),
+			ExpireTime:    scrapeZoneTime(zoneMap, "expires"),
+			Rcodes:        scrapeFloatMap(zoneMap["rcodes"]),
+			DNSSECSign:    scrapeFloatMap(zoneMap["dnssec-sign"]),
+			DNSSECRefresh: scrapeFloatMap(zoneMap["dnssec-refresh"]),
+		}
+	}
+}
+
 // setDaemonStats stores the stat values from a daemon in the proper prometheus object.
 func (pbe *PromBind9Exporter) setDaemonStats(rspIfc interface{}) (ret error) {
 	rsp, ok := rspIfc.(map[string]interface{})
@@ -1182,21 +1458,14 @@ func (pbe *PromBind9Exporter) collectStats() (bind9Pid int32, lastErr error) {
 			continue
 		}
 		address := storkutil.HostWithPortURL(sap.Address, sap.Port, sap.UseSecureProtocol)
-		path := "json/v1"
+		path := bind9ctrl.StatsJSONPath
 		url := fmt.Sprintf("%s%s", address, path)
-		httpRsp, err := pbe.HTTPClient.Call(url, bytes.NewBuffer([]byte(request)))
+		body, err := pbe.namedStatsFormats.getNamedStats(context.Background(), pbe.HTTPClient, url, []byte(request))
 		if err != nil {
 			lastErr = err
 			log.Errorf("problem with getting stats from BIND 9: %+v", err)
 			continue
 		}
-		body, err := io.ReadAll(httpRsp.Body)
-		httpRsp.Body.Close()
-		if err != nil {
-			lastErr = err
-			log.Errorf("problem with reading stats response from BIND 9: %+v", err)
-			continue
-		}
 
 		// parse response
 		var rspIfc interface{}
@@ -1228,12 +1497,14 @@ func (pbe *PromBind9Exporter) initViewStats(viewName string) {
 		resolverCachestats := make(map[string]float64)
 		resolverQtypes := make(map[string]float64)
 		resolverStats := make(map[string]float64)
+		zones := make(map[string]PromBind9ZoneStats)
 
 		pbe.stats.Views[viewName] = PromBind9ViewStats{
 			ResolverCache:      resolverCache,
 			ResolverCachestats: resolverCachestats,
 			ResolverQtypes:     resolverQtypes,
 			ResolverStats:      resolverStats,
+			Zones:              zones,
 		}
 	}
 }
@@ -1,11 +1,13 @@
 package agent
 
 import (
+	"encoding/json"
 	"flag"
 	"math"
 	"testing"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	log "github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/require"
 	"github.com/urfave/cli/v2"
@@ -57,6 +59,9 @@ func TestNewPromBind9ExporterBasic(t *testing.T) {
 	require.NotNil(t, pbe.HTTPServer)
 	require.Len(t, pbe.serverStatsDesc, 19)
 	require.Len(t, pbe.viewStatsDesc, 18)
+	require.Len(t, pbe.zoneStatsDesc, 8)
+	// per zone stats are disabled by default
+	require.Nil(t, pbe.zoneFilter)
 }
 
 // Check starting PromBind9Exporter and collecting stats.
@@ -381,3 +386,94 @@ func TestPromBind9ExporterStart(t *testing.T) {
 	// zone_transfer_success_total
 	require.EqualValues(t, 22.0, pbe.stats.NsStats["XfrSuccess"])
 }
+
+// Test that the zones are included and excluded using the patterns.
+func TestBind9ZoneFilter(t *testing.T) {
+	flags := flag.NewFlagSet("test", 0)
+	flags.Var(cli.NewStringSlice("*.example.org", "example.com."), "prometheus-bind9-exporter-zones-include", "usage")
+	flags.Var(cli.NewStringSlice("internal.example.org"), "prometheus-bind9-exporter-zones-exclude", "usage")
+	settings := cli.NewContext(nil, flags, nil)
+
+	filter := newBind9ZoneFilter(settings)
+
+	require.True(t, filter.isZoneIncluded("www.example.org"))
+	require.True(t, filter.isZoneIncluded("WWW.Example.org."))
+	require.True(t, filter.isZoneIncluded("example.com"))
+	require.False(t, filter.isZoneIncluded("example.org"))
+	require.False(t, filter.isZoneIncluded("internal.example.org"))
+	require.False(t, filter.isZoneIncluded("example.net"))
+
+	// empty include list includes all zones
+	filter = newBind9ZoneFilter(cli.NewContext(nil, flag.NewFlagSet("", 0), nil))
+	require.True(t, filter.isZoneIncluded("example.net"))
+}
+
+// Test that the per zone stats are parsed and exported.
+func TestPromBind9ExporterZoneStats(t *testing.T) {
+	fam := &PromFakeBind9AppMonitor{}
+	flags := flag.NewFlagSet("test", 0)
+	flags.Bool("prometheus-bind9-exporter-per-zone-stats", false, "usage")
+	flags.Var(cli.NewStringSlice("example.net"), "prometheus-bind9-exporter-zones-exclude", "usage")
+	settings := cli.NewContext(nil, flags, nil)
+	settings.Set("prometheus-bind9-exporter-per-zone-stats", "true")
+	pbe := NewPromBind9Exporter(settings, fam)
+	defer pbe.Shutdown()
+	require.NotNil(t, pbe.zoneFilter)
+
+	var viewStats interface{}
+	err := json.Unmarshal([]byte(`{
+		"zones": [
+			{
+				"name": "example.com",
+				"class": "IN",
+				"serial": 2021101401,
+				"type": "secondary",
+				"loaded": "2021-10-14T10:00:00.000Z",
+				"expires": "2021-10-28T10:00:00.000Z",
+				"refresh": "2021-10-14T11:00:00.000Z",
+				"rcodes": {
+					"QrySuccess": 120,
+					"QryNXDOMAIN": 7,
+					"QryAuthAns": 127,
+					"XfrSuccess": 3,
+					"XfrFail": 1
+				},
+				"dnssec-sign": {
+					"12345": 40
+				},
+				"dnssec-refresh": {
+					"12345": 4
+				}
+			},
+			{
+				"name": "example.net",
+				"class": "IN",
+				"serial": 1
+			}
+		]
+	}`), &viewStats)
+	require.NoError(t, err)
+
+	// Act
+	pbe.scrapeViewStats("_default", viewStats)
+
+	// Assert
+	zones := pbe.stats.Views["_default"].Zones
+	require.Len(t, zones, 1)
+	zone := zones["example.com"]
+	require.EqualValues(t, 2021101401, zone.Serial)
+	expect, _ := time.Parse(time.RFC3339, "2021-10-14T11:00:00.000Z")
+	require.Equal(t, expect, zone.RefreshTime)
+	expect, _ = time.Parse(time.RFC3339, "2021-10-28T10:00:00.000Z")
+	require.Equal(t, expect, zone.ExpireTime)
+	require.EqualValues(t, 120, zone.Rcodes["QrySuccess"])
+	require.EqualValues(t, 1, zone.Rcodes["XfrFail"])
+	require.EqualValues(t, 40, zone.DNSSECSign["12345"])
+	require.EqualValues(t, 4, zone.DNSSECRefresh["12345"])
+
+	// serial, 3 times, 3 query results, 2 transfer results and 2 DNSSEC counters
+	ch := make(chan prometheus.Metric, 100)
+	pbe.collectZoneStats("_default", "example.com", zone, ch)
+	close(ch)
+	require.Len(t, ch, 11)
+}
@@ -98,6 +98,248 @@ func (l *SubnetList) UnmarshalJSON(b []byte) error {
 	return nil
 }
 
+// JSON structures of the subnets and shared networks in Kea `config-get`
+// response. Only the parameters used in the metric labels are parsed.
+type poolConfigJSON struct {
+	Pool        string
+	ClientClass string `json:"client-class"`
+}
+
+type pdPoolConfigJSON struct {
+	Prefix      string
+	PrefixLen   int    `json:"prefix-len"`
+	ClientClass string `json:"client-class"`
+}
+
+type subnetConfigJSON struct {
+	ID          int
+	Subnet      string
+	ClientClass string `json:"client-class"`
+	UserContext *struct {
+		Name string
+	} `json:"user-context"`
+	Pools   []poolConfigJSON
+	PdPools []pdPoolConfigJSON `json:"pd-pools"`
+}
+
+type sharedNetworkConfigJSON struct {
+	Name    string
+	Subnet4 []subnetConfigJSON
+	Subnet6 []subnetConfigJSON
+}
+
+type daemonConfigJSON struct {
+	Subnet4        []subnetConfigJSON
+	Subnet6        []subnetConfigJSON
+	SharedNetworks []sharedNetworkConfigJSON `json:"shared-networks"`
+}
+
+type configGetJSON struct {
+	Result    int
+	Text      *string
+	Arguments *struct {
+		Dhcp4 *daemonConfigJSON
+		Dhcp6 *daemonConfigJSON
+	}
+}
+
+// Subnet details taken from the Kea configuration.
+type subnetConfig struct {
+	Prefix        string
+	SharedNetwork string
+	Name          string
+	ClientClass   string
+	// Address pools in the order of the Kea pool statistics.
+	Pools []poolConfigJSON
+	// Prefix delegation pools in the order of the Kea pd-pool statistics.
+	PdPools []pdPoolConfigJSON
+}
+
+// Parses the Kea `config-get` response to the map of subnet details
+// indexed by subnet ID. It returns nil if the response contains no
+// configuration of the DHCP daemon.
+func parseSubnetConfigs(b []byte) (map[int]*subnetConfig, error) {
+	var responses []configGetJSON
+	err := json.Unmarshal(b, &responses)
+	if err != nil {
+		return nil, pkgerrors.Wrap(err, "problem with parsing configuration from kea")
+	}
+	if len(responses) == 0 {
+		return nil, pkgerrors.New("empty JSON list")
+	}
+	response := responses[0]
+	if response.Result != 0 {
+		reason := "unknown"
+		if response.Text != nil {
+			reason = *response.Text
+		}
+		return nil, pkgerrors.Errorf("problem with content of configuration response from kea: %s", reason)
+	}
+	if response.Arguments == nil {
+		return nil, nil
+	}
+
+	daemonConfig := response.Arguments.Dhcp4
+	if daemonConfig == nil {
+		daemonConfig = response.Arguments.Dhcp6
+	}
+	if daemonConfig == nil {
+		return nil, nil
+	}
+
+	configs := make(map[int]*subnetConfig)
+	addSubnets := func(subnets []subnetConfigJSON, sharedNetwork string) {
+		for _, subnet := range subnets {
+			config := &subnetConfig{
+				Prefix:        subnet.Subnet,
+				SharedNetwork: sharedNetwork,
+				ClientClass:   subnet.ClientClass,
+				Pools:         subnet.Pools,
+				PdPools:       subnet.PdPools,
+			}
+			if subnet.UserContext != nil {
+				config.Name = subnet.UserContext.Name
+			}
+			configs[subnet.ID] = config
+		}
+	}
+	addSubnets(daemonConfig.Subnet4, "")
+	addSubnets(daemonConfig.Subnet6, "")
+	for _, sharedNetwork := range daemonConfig.SharedNetworks {
+		addSubnets(sharedNetwork.Subnet4, sharedNetwork.Name)
+		addSubnets(sharedNetwork.Subnet6, sharedNetwork.Name)
+	}
+	return configs, nil
+}
+
+// Subnet details used in the labels of the per-subnet and per-pool metrics.
+type subnetInfo struct {
+	ID int
+	// Subnet prefix or empty string if it isn't available.
+	Prefix        string
+	SharedNetwork string
+	// Subnet name specified in the user context.
+	Name   string
+	config *subnetConfig
+}
+
+// Returns the subnet prefix if available or subnet ID as string.
+func (info *subnetInfo) getPrefixOrID() string {
+	if info.Prefix != "" {
+		return info.Prefix
+	}
+	return fmt.Sprint(info.ID)
+}
+
+// Returns the labels describing the subnet.
+func (info *subnetInfo) getLabels() prometheus.Labels {
+	return prometheus.Labels{
+		"subnet":         info.getPrefixOrID(),
+		"subnet_id":      fmt.Sprint(info.ID),
+		"shared_network": info.SharedNetwork,
+		"subnet_name":    info.Name,
+	}
+}
+
+// Returns the labels describing the pool of the given type (pool or
+// pd-pool) and index within the subnet. The pool is described by its
+// range or prefix if the subnet configuration is available and by its
+// index otherwise. The client class is the class allowed to use the pool
+// or the subnet.
+func (info *subnetInfo) getPoolLabels(poolType string, poolIndex int) prometheus.Labels {
+	labels := info.getLabels()
+	labels["pool"] = fmt.Sprint(poolIndex)
+	labels["client_class"] = ""
+	if info.config == nil {
+		return labels
+	}
+	labels["client_class"] = info.config.ClientClass
+	switch {
+	case poolType == "pool" && poolIndex < len(info.config.Pools):
+		pool := info.config.Pools[poolIndex]
+		labels["pool"] = strings.ReplaceAll(pool.Pool, " ", "")
+		if pool.ClientClass != "" {
+			labels["client_class"] = pool.ClientClass
+		}
+	case poolType == "pd-pool" && poolIndex < len(info.config.PdPools):
+		pool := info.config.PdPools[poolIndex]
+		labels["pool"] = fmt.Sprintf("%s/%d", pool.Prefix, pool.PrefixLen)
+		if pool.ClientClass != "" {
+			labels["client_class"] = pool.ClientClass
+		}
+	}
+	return labels
+}
+
+// Filter limiting the exported statistics and subnets to reduce the
+// cardinality of the metrics in large networks. The empty allow list
+// allows everything. The deny list takes precedence over the allow list.
+type statsFilter struct {
+	allowedStats   map[string]bool
+	deniedStats    map[string]bool
+	allowedSubnets map[string]bool
+	deniedSubnets  map[string]bool
+}
+
+// Converts the list of values to a set.
+func newStatsFilterSet(values []string) map[string]bool {
+	set := make(map[string]bool)
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if value != "" {
+			set[value] = true
+		}
+	}
+	return set
+}
+
+// Creates the filter from the allow and deny lists specified in the
+// agent settings.
+func newStatsFilter(settings *cli.Context) *statsFilter {
+	return &statsFilter{
+		allowedStats:   newStatsFilterSet(settings.StringSlice("prometheus-kea-exporter-stats-allow")),
+		deniedStats:    newStatsFilterSet(settings.StringSlice("prometheus-kea-exporter-stats-deny")),
+		allowedSubnets: newStatsFilterSet(settings.StringSlice("prometheus-kea-exporter-subnets-allow")),
+		deniedSubnets:  newStatsFilterSet(settings.StringSlice("prometheus-kea-exporter-subnets-deny")),
+	}
+}
+
+// Checks if the statistic with the given name should be exported. The
+// per-subnet and per-pool statistics are identified by the name without
+// the subnet and pool prefix, e.g. assigned-addresses.
+func (f *statsFilter) isStatAllowed(name string) bool {
+	if f.deniedStats[name] {
+		return false
+	}
+	return len(f.allowedStats) == 0 || f.allowedStats[name]
+}
+
+// Checks if the statistics of the subnet should be exported. The subnet
+// is identified by its ID, prefix or shared network name.
+func (f *statsFilter) isSubnetAllowed(info *subnetInfo) bool {
+	keys := []string{fmt.Sprint(info.ID)}
+	if info.Prefix != "" {
+		keys = append(keys, info.Prefix)
+	}
+	if info.SharedNetwork != "" {
+		keys = append(keys, info.SharedNetwork)
+	}
+	for _, key := range keys {
+		if f.deniedSubnets[key] {
+			return false
+		}
+	}
+	if len(f.allowedSubnets) == 0 {
+		return true
+	}
+	for _, key := range keys {
+		if f.allowedSubnets[key] {
+			return true
+		}
+	}
+	return false
+}
+
 // JSON get-all-statistic response returned from Kea CA.
 type GetAllStatisticsResponse struct {
 	Dhcp4 map[string]GetAllStatisticResponseItemValue
@@ -121,7 +363,7 @@ func (r *GetAllStatisticsResponse) UnmarshalJSON(b []byte) error {
 		// In Go you cannot describe the JSON array with mixed-type items.
 		Arguments *map[string][][]interface{}
 	}
-	type ResponseRaw = []ResponseRawItem
+	type ResponseRaw = []*ResponseRawItem
 
 	// Standard GO unmarshal
 	var obj ResponseRaw
@@ -140,6 +382,10 @@ func (r *GetAllStatisticsResponse) UnmarshalJSON(b []byte) error {
 	// Retrieve values of mixed-type arrays.
 	// Unpack the complex structure to simpler form.
 	for daemonIdx, item := range obj {
+		// The daemon is not controlled by this app.
+		if item == nil {
+			continue
+		}
 		if item.Result != 0 {
 			if item.Text != nil {
 				text := *item.Text
@@ -204,6 +450,50 @@ func (r *GetAllStatisticsResponse) UnmarshalJSON(b []byte) error {
 	return nil
 }
 
+// JSON status of the local server in the HA relationship returned by
+// Kea in response to the status-get command.
+type statusGetHALocalJSON struct {
+	Role       string
+	State      string
+	ServerName string `json:"server-name"`
+}
+
+// JSON status of the partner in the HA relationship returned by Kea in
+// response to the status-get command. Age is the number of seconds since
+// the last successful heartbeat.
+type statusGetHARemoteJSON struct {
+	Age                      int64
+	InTouch                  bool   `json:"in-touch"`
+	LastState                string `json:"last-state"`
+	CommunicationInterrupted *bool  `json:"communication-interrupted"`
+	UnackedClients           int64  `json:"unacked-clients"`
+	ServerName               string `json:"server-name"`
+}
+
+type statusGetHAServersJSON struct {
+	Local  statusGetHALocalJSON
+	Remote statusGetHARemoteJSON
+}
+
+type statusGetHARelationshipJSON struct {
+	HAMode    string                 `json:"ha-mode"`
+	HAServers statusGetHAServersJSON `json:"ha-servers"`
+}
+
+// JSON arguments of the status-get response. Kea versions earlier than
+// 1.7.8 return the status of the single relationship in ha-servers. The
+// later versions return the list of relationships in high-availability.
+type statusGetJSONArguments struct {
+	HAServers *statusGetHAServersJSON       `json:"ha-servers"`
+	HA        []statusGetHARelationshipJSON `json:"high-availability"`
+}
+
+type statusGetJSON struct {
+	Result    int
+	Text      *string
+	Arguments *statusGetJSONArguments
+}
+
 // Stats descriptor that holds reference to prometheus stats
 // and its 'operation' label.
 type statDescr struct {
@@ -219,6 +509,9 @@ type subnetNameLookup interface {
 	// Returns the subnet name based on the subnet ID and IP family.
 	// If the name isn't available returns default name.
 	getNameOrDefault(subnetID int) string
+	// Returns the subnet details used in the metric labels. The details
+	// unavailable in Kea are left empty.
+	getSubnetInfo(subnetID int) *subnetInfo
 	// Sets the IP family to use during lookup (4 or 6).
 	setFamily(int8)
 }
@@ -228,6 +521,65 @@ type keaCommandSender interface {
 	sendCommandToKeaCA(ctrl *AccessPoint, request string) ([]byte, error)
 }
 
+// Sends the requests directly to the Kea daemon controlled over its
+// control socket. The daemon returns only its own response, so the
+// response is placed in the list at the position of the daemon among
+// the services listed in the request and the remaining positions are
+// null. Thus, the response is parsed in the same way as the response
+// returned by the Kea CA.
+type keaDaemonCommandSender struct {
+	app *KeaApp
+}
+
+// Sends the request to the Kea daemon over its control socket. The access
+// point is ignored. The request is not sent if the daemon is not among
+// the services listed in the request.
+func (s *keaDaemonCommandSender) sendCommandToKeaCA(ctrl *AccessPoint, request string) ([]byte, error) {
+	var command struct {
+		Service []string
+	}
+	if err := json.Unmarshal([]byte(request), &command); err != nil {
+		return nil, pkgerrors.Wrap(err, "problem with parsing the command sent to kea")
+	}
+
+	responses := make([]json.RawMessage, len(command.Service))
+	for i, service := range command.Service {
+		responses[i] = json.RawMessage("null")
+		if service != s.app.DaemonName {
+			continue
+		}
+		body, _, err := s.app.sendRequest([]byte(request))
+		if err != nil {
+			return nil, err
+		}
+		var response []json.RawMessage
+		if err = json.Unmarshal(body, &response); err != nil || len(response) != 1 {
+			return nil, pkgerrors.Errorf("invalid response received from kea over %s", s.app.AccessPoints[0].Address)
+		}
+		responses[i] = response[0]
+	}
+	return json.Marshal(responses)
+}
+
+// Maximum age of the cached subnet details when Kea doesn't return the
+// configuration hash, i.e. when it doesn't support the config-hash-get
+// command.
+const subnetConfigCacheMaxAge = 10 * time.Minute
+
+// Subnet details from the configuration of a Kea daemon cached between
+// the stats collections.
+type cachedSubnetConfigs struct {
+	configs   map[int]*subnetConfig
+	hash      string
+	fetchedAt time.Time
+}
+
+// Cache of the subnet details indexed by the access point and the family.
+// The configuration is fetched again only when its hash returned by Kea
+// changes or when the cache is older than subnetConfigCacheMaxAge if the
+// hash is unavailable.
+type subnetConfigCache map[string]*cachedSubnetConfigs
+
 // Subnet name lookup that fetches the subnet names only if necessary.
 // The subnet names are fetched on the first call to getName() for an IP family.
 // The results are cached; no more requests are made until IP family change.
@@ -241,12 +593,19 @@ type lazySubnetNameLookup struct {
 	cached bool
 	// Family to use during lookups.
 	family int8
+	// Cached subnet details from the configuration of current family.
+	cachedConfigs map[int]*subnetConfig
+	// Indicates that the configuration was fetched for current family.
+	configsCached bool
+	// Subnet details cached between the lookups. The configuration is
+	// fetched for each lookup if it is nil.
+	configCache subnetConfigCache
 }
 
-// Constructs the lazySubnetNameLookup instance. It accepts the Kea CA request sender
-// and specific access point.
-func newLazySubnetNameLookup(sender keaCommandSender, ap *AccessPoint) subnetNameLookup {
-	return &lazySubnetNameLookup{sender, ap, nil, false, 4}
+// Constructs the lazySubnetNameLookup instance. It accepts the Kea CA request sender,
+// specific access point and the optional cache of the subnet details.
+func newLazySubnetNameLookup(sender keaCommandSender, ap *AccessPoint, configCache subnetConfigCache) subnetNameLookup {
+	return &lazySubnetNameLookup{sender, ap, nil, false, 4, nil, false, configCache}
 }
 
 // Fetches the names from Kea CA and stores the response in a cache.
@@ -310,10 +669,102 @@ func (l *lazySubnetNameLookup) getNameOrDefault(subnetID int) string {
 	return fmt.Sprint(subnetID)
 }
 
+// Fetches the hash of the current configuration from Kea CA. It returns
+// an empty string if the hash is unavailable, e.g. because Kea doesn't
+// support the config-hash-get command.
+func (l *lazySubnetNameLookup) fetchConfigHash() string {
+	request := fmt.Sprintf(`{
+			"command":"config-hash-get",
+			"service":["dhcp%d"]
+		}`, l.family)
+
+	response, err := l.sender.sendCommandToKeaCA(l.accessPoint, request)
+	if err != nil {
+		return ""
+	}
+	var responses []struct {
+		Result    int
+		Arguments *struct {
+			Hash string
+		}
+	}
+	if err = json.Unmarshal(response, &responses); err != nil || len(responses) == 0 ||
+		responses[0].Result != 0 || responses[0].Arguments == nil {
+		return ""
+	}
+	return responses[0].Arguments.Hash
+}
+
+// Fetches the configuration from Kea CA and stores the subnet details in
+// a cache. If any error occurs then the cache is set to nil. Returns the
+// fetched subnet details. The configuration is not fetched if the subnet
+// details cached between the lookups are up to date.
+func (l *lazySubnetNameLookup) fetchAndCacheConfigs() map[int]*subnetConfig {
+	key := fmt.Sprintf("%s:%d/%d", l.accessPoint.Address, l.accessPoint.Port, l.family)
+	var hash string
+	if l.configCache != nil {
+		hash = l.fetchConfigHash()
+		if cached, ok := l.configCache[key]; ok && cached.hash == hash &&
+			(hash != "" || time.Since(cached.fetchedAt) < subnetConfigCacheMaxAge) {
+			l.cachedConfigs = cached.configs
+			l.configsCached = true
+			return cached.configs
+		}
+	}
+
+	request := fmt.Sprintf(`{
+			"command":"config-get",
+			"service":["dhcp%d"]
+		}`, l.family)
+
+	response, err := l.sender.sendCommandToKeaCA(l.accessPoint, request)
+	var configs map[int]*subnetConfig
+	if err == nil {
+		configs, err = parseSubnetConfigs(response)
+		if err != nil {
+			log.Errorf("problem with parsing DHCPv%d subnets configuration from kea: %+v", l.family, err)
+		}
+	}
+	if err == nil && l.configCache != nil {
+		l.configCache[key] = &cachedSubnetConfigs{
+			configs:   configs,
+			hash:      hash,
+			fetchedAt: time.Now(),
+		}
+	}
+
+	l.cachedConfigs = configs
+	l.configsCached = true
+	return configs
+}
+
+// Returns the subnet details for specific subnet ID and current family.
+// The subnet prefix is taken from the subnet list if available or from
+// the configuration otherwise.
+func (l *lazySubnetNameLookup) getSubnetInfo(subnetID int) *subnetInfo {
+	configs := l.cachedConfigs
+	if !l.configsCached {
+		configs = l.fetchAndCacheConfigs()
+	}
+
+	info := &subnetInfo{ID: subnetID}
+	if config, ok := configs[subnetID]; ok {
+		info.Prefix = config.Prefix
+		info.SharedNetwork = config.SharedNetwork
+		info.Name = config.Name
+		info.config = config
+	}
+	if name, ok := l.getName(subnetID); ok {
+		info.Prefix = name
+	}
+	return info
+}
+
 // Sets the family used during name lookups.
 func (l *lazySubnetNameLookup) setFamily(family int8) {
 	l.family = family
 	l.cached = false
+	l.configsCached = false
 }
 
 // Main structure for Prometheus Kea Exporter. It holds its settings,
@@ -335,6 +786,19 @@ type PromKeaExporter struct {
 	PktStatsMap  map[string]statDescr
 	Adr4StatsMap map[string]*prometheus.GaugeVec
 	Adr6StatsMap map[string]*prometheus.GaugeVec
+	// Per-pool stats indexed by the Kea statistic name. They are nil
+	// if the per-pool stats are disabled.
+	Pool4StatsMap   map[string]*prometheus.GaugeVec
+	Pool6StatsMap   map[string]*prometheus.GaugeVec
+	PdPool6StatsMap map[string]*prometheus.GaugeVec
+	HAStatsMap      map[string]*prometheus.GaugeVec
+
+	// Allow and deny lists limiting the exported stats.
+	Filter *statsFilter
+
+	// Subnet details cached between the stats collections. It is only
+	// accessed by the stats collector.
+	subnetConfigs subnetConfigCache
 }
 
 // Create new Prometheus Kea Exporter.
@@ -348,6 +812,8 @@ func NewPromKeaExporter(settings *cli.Context, appMonitor AppMonitor) *PromKeaEx
 		Registry:      prometheus.NewRegistry(),
 		Adr4StatsMap:  nil,
 		Adr6StatsMap:  nil,
+		Filter:        newStatsFilter(settings),
+		subnetConfigs: make(subnetConfigCache),
 	}
 
 	factory := promauto.With(pke.Registry)
@@ -437,9 +903,45 @@ func NewPromKeaExporter(settings *cli.Context, appMonitor AppMonitor) *PromKeaEx
 
 	pke.PktStatsMap = pktStatsMap
 
+	// High Availability status of the relationships returned by status-get.
+	haStatsMap := make(map[string]*prometheus.GaugeVec)
+	haStatsMap["state"] = factory.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: AppTypeKea,
+		Subsystem: "ha",
+		Name:      "state",
+		Help:      "HA state of the server, set to 1 for the current state",
+	}, []string{"control_address", "daemon", "relationship", "state"})
+	haStatsMap["partner-state"] = factory.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: AppTypeKea,
+		Subsystem: "ha",
+		Name:      "partner_state",
+		Help:      "Last known HA state of the partner, set to 1 for the current state",
+	}, []string{"control_address", "daemon", "relationship", "state"})
+	haStatsMap["unacked-clients"] = factory.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: AppTypeKea,
+		Subsystem: "ha",
+		Name:      "unacked_clients",
+		Help:      "Clients unacknowledged by the partner while the communication is interrupted",
+	}, []string{"control_address", "daemon", "relationship"})
+	haStatsMap["communication-interrupted"] = factory.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: AppTypeKea,
+		Subsystem: "ha",
+		Name:      "communication_interrupted",
+		Help:      "Set to 1 when the communication with the partner is interrupted",
+	}, []string{"control_address", "daemon", "relationship"})
+	haStatsMap["heartbeat-age"] = factory.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: AppTypeKea,
+		Subsystem: "ha",
+		Name:      "heartbeat_age_seconds",
+		Help:      "Seconds since the last successful heartbeat with the partner",
+	}, []string{"control_address", "daemon", "relationship"})
+	pke.HAStatsMap = haStatsMap
+
 	// Collecting per subnet stats is enabled by default. It can be explicitly disabled.
 	enablePerSubnetStatsFlag := "prometheus-kea-exporter-per-subnet-stats"
 	if !settings.IsSet(enablePerSubnetStatsFlag) || settings.Bool(enablePerSubnetStatsFlag) {
+		subnetLabels := []string{"subnet", "subnet_id", "shared_network", "subnet_name"}
+
 		// addresses dhcp4
 		adr4StatsMap := make(map[string]*prometheus.GaugeVec)
 		adr4StatsMap["assigned-addresses"] = factory.NewGaugeVec(prometheus.GaugeOpts{
@@ -447,37 +949,37 @@ func NewPromKeaExporter(settings *cli.Context, appMonitor AppMonitor) *PromKeaEx
 			Subsystem: "dhcp4",
 			Name:      "addresses_assigned_total",
 			Help:      "Assigned addresses",
-		}, []string{"subnet"})
+		}, subnetLabels)
 		adr4StatsMap["declined-addresses"] = factory.NewGaugeVec(prometheus.GaugeOpts{
 			Namespace: AppTypeKea,
 			Subsystem: "dhcp4",
 			Name:      "addresses_declined_total",
 			Help:      "Declined counts",
-		}, []string{"subnet"})
+		}, subnetLabels)
 		adr4StatsMap["reclaimed-declined-addresses"] = factory.NewGaugeVec(prometheus.GaugeOpts{
 			Namespace: AppTypeKea,
 			Subsystem: "dhcp4",
 			Name:      "addresses_declined_reclaimed_total",
 			Help:      "Declined addresses that were reclaimed",
-		}, []string{"subnet"})
+		}, subnetLabels)
 		adr4StatsMap["reclaimed-leases"] = factory.NewGaugeVec(prometheus.GaugeOpts{
 			Namespace: AppTypeKea,
 			Subsystem: "dhcp4",
 			Name:      "addresses_reclaimed_total",
 			Help:      "Expired addresses that were reclaimed",
-		}, []string{"subnet"})
+		}, subnetLabels)
 		adr4StatsMap["total-addresses"] = factory.NewGaugeVec(prometheus.GaugeOpts{
 			Namespace: AppTypeKea,
 			Subsystem: "dhcp4",
 			Name:      "addresses_total",
 			Help:      "Size of subnet address pool",
-		}, []string{"subnet"})
+		}, subnetLabels)
 		adr4StatsMap["cumulative-assigned-addresses"] = factory.NewGaugeVec(prometheus.GaugeOpts{
 			Namespace: AppTypeKea,
 			Subsystem: "dhcp4",
 			Name:      "cumulative_addresses_assigned_total",
 			Help:      "Cumulative number of assigned addresses since server startup",
-		}, []string{"subnet"})
+		}, subnetLabels)
 
 		// addresses dhcp6
 		adr6StatsMap := make(map[string]*prometheus.GaugeVec)
@@ -486,58 +988,173 @@ func NewPromKeaExporter(settings *cli.Context, appMonitor AppMonitor) *PromKeaEx
 			Subsystem: "dhcp6",
 			Name:      "na_total",
 			Help:      "'Size of non-temporary address pool",
-		}, []string{"subnet"})
+		}, subnetLabels)
 		adr6StatsMap["assigned-nas"] = factory.NewGaugeVec(prometheus.GaugeOpts{
 			Namespace: AppTypeKea,
 			Subsystem: "dhcp6",
 			Name:      "na_assigned_total",
 			Help:      "Assigned non-temporary addresses (IA_NA)",
-		}, []string{"subnet"})
+		}, subnetLabels)
 		adr6StatsMap["total-pds"] = factory.NewGaugeVec(prometheus.GaugeOpts{
 			Namespace: AppTypeKea,
 			Subsystem: "dhcp6",
 			Name:      "pd_total",
 			Help:      "Size of prefix delegation pool",
-		}, []string{"subnet"})
+		}, subnetLabels)
 		adr6StatsMap["assigned-pds"] = factory.NewGaugeVec(prometheus.GaugeOpts{
 			Namespace: AppTypeKea,
 			Subsystem: "dhcp6",
 			Name:      "pd_assigned_total",
 			Help:      "Assigned prefix delegations (IA_PD)",
-		}, []string{"subnet"})
+		}, subnetLabels)
 		adr6StatsMap["reclaimed-leases"] = factory.NewGaugeVec(prometheus.GaugeOpts{
 			Namespace: AppTypeKea,
 			Subsystem: "dhcp6",
 			Name:      "addresses_reclaimed_total",
 			Help:      "Expired addresses that were reclaimed",
-		}, []string{"subnet"})
+		}, subnetLabels)
 		adr6StatsMap["declined-addresses"] = factory.NewGaugeVec(prometheus.GaugeOpts{
 			Namespace: AppTypeKea,
 			Subsystem: "dhcp6",
 			Name:      "addresses_declined_total",
 			Help:      "Declined counts",
-		}, []string{"subnet"})
+		}, subnetLabels)
 		adr6StatsMap["reclaimed-declined-addresses"] = factory.NewGaugeVec(prometheus.GaugeOpts{
 			Namespace: AppTypeKea,
 			Subsystem: "dhcp6",
 			Name:      "addresses_declined_reclaimed_total",
 			Help:      "Declined addresses that were reclaimed",
-		}, []string{"subnet"})
+		}, subnetLabels)
 		adr6StatsMap["cumulative-assigned-nas"] = factory.NewGaugeVec(prometheus.GaugeOpts{
 			Namespace: AppTypeKea,
 			Subsystem: "dhcp6",
 			Name:      "cumulative_nas_assigned_total",
 			Help:      "Cumulative number of assigned NA addresses since server startup",
-		}, []string{"subnet"})
+		}, subnetLabels)
 		adr6StatsMap["cumulative-assigned-pds"] = factory.NewGaugeVec(prometheus.GaugeOpts{
 			Namespace: AppTypeKea,
 			Subsystem: "dhcp6",
 			Name:      "cumulative_pds_assigned_total",
 			Help:      "Cumulative number of assigned PD prefixes since server startup",
-		}, []string{"subnet"})
+		}, subnetLabels)
 
 		pke.Adr4StatsMap = adr4StatsMap
 		pke.Adr6StatsMap = adr6StatsMap
+
+		// Collecting per pool stats is enabled by default. It can be explicitly disabled.
+		enablePerPoolStatsFlag := "prometheus-kea-exporter-per-pool-stats"
+		if !settings.IsSet(enablePerPoolStatsFlag) || settings.Bool(enablePerPoolStatsFlag) {
+			poolLabels := append([]string{"pool", "client_class"}, subnetLabels...)
+
+			// address pools dhcp4
+			pool4StatsMap := make(map[string]*prometheus.GaugeVec)
+			pool4StatsMap["assigned-addresses"] = factory.NewGaugeVec(prometheus.GaugeOpts{
+				Namespace: AppTypeKea,
+				Subsystem: "dhcp4",
+				Name:      "pool_addresses_assigned_total",
+				Help:      "Assigned addresses in the pool",
+			}, poolLabels)
+			pool4StatsMap["declined-addresses"] = factory.NewGaugeVec(prometheus.GaugeOpts{
+				Namespace: AppTypeKea,
+				Subsystem: "dhcp4",
+				Name:      "pool_addresses_declined_total",
+				Help:      "Declined addresses in the pool",
+			}, poolLabels)
+			pool4StatsMap["reclaimed-declined-addresses"] = factory.NewGaugeVec(prometheus.GaugeOpts{
+				Namespace: AppTypeKea,
+				Subsystem: "dhcp4",
+				Name:      "pool_addresses_declined_reclaimed_total",
+				Help:      "Declined addresses in the pool that were reclaimed",
+			}, poolLabels)
+			pool4StatsMap["reclaimed-leases"] = factory.NewGaugeVec(prometheus.GaugeOpts{
+				Namespace: AppTypeKea,
+				Subsystem: "dhcp4",
+				Name:      "pool_addresses_reclaimed_total",
+				Help:      "Expired addresses in the pool that were reclaimed",
+			}, poolLabels)
+			pool4StatsMap["total-addresses"] = factory.NewGaugeVec(prometheus.GaugeOpts{
+				Namespace: AppTypeKea,
+				Subsystem: "dhcp4",
+				Name:      "pool_addresses_total",
+				Help:      "Size of the address pool",
+			}, poolLabels)
+			pool4StatsMap["cumulative-assigned-addresses"] = factory.NewGaugeVec(prometheus.GaugeOpts{
+				Namespace: AppTypeKea,
+				Subsystem: "dhcp4",
+				Name:      "pool_cumulative_addresses_assigned_total",
+				Help:      "Cumulative number of addresses assigned from the pool since server startup",
+			}, poolLabels)
+
+			// address pools dhcp6
+			pool6StatsMap := make(map[string]*prometheus.GaugeVec)
+			pool6StatsMap["total-nas"] = factory.NewGaugeVec(prometheus.GaugeOpts{
+				Namespace: AppTypeKea,
+				Subsystem: "dhcp6",
+				Name:      "pool_na_total",
+				Help:      "Size of the non-temporary address pool",
+			}, poolLabels)
+			pool6StatsMap["assigned-nas"] = factory.NewGaugeVec(prometheus.GaugeOpts{
+				Namespace: AppTypeKea,
+				Subsystem: "dhcp6",
+				Name:      "pool_na_assigned_total",
+				Help:      "Assigned non-temporary addresses (IA_NA) in the pool",
+			}, poolLabels)
+			pool6StatsMap["declined-addresses"] = factory.NewGaugeVec(prometheus.GaugeOpts{
+				Namespace: AppTypeKea,
+				Subsystem: "dhcp6",
+				Name:      "pool_addresses_declined_total",
+				Help:      "Declined addresses in the pool",
+			}, poolLabels)
+			pool6StatsMap["reclaimed-declined-addresses"] = factory.NewGaugeVec(prometheus.GaugeOpts{
+				Namespace: AppTypeKea,
+				Subsystem: "dhcp6",
+				Name:      "pool_addresses_declined_reclaimed_total",
+				Help:      "Declined addresses in the pool that were reclaimed",
+			}, poolLabels)
+			pool6StatsMap["reclaimed-leases"] = factory.NewGaugeVec(prometheus.GaugeOpts{
+				Namespace: AppTypeKea,
+				Subsystem: "dhcp6",
+				Name:      "pool_addresses_reclaimed_total",
+				Help:      "Expired addresses in the pool that were reclaimed",
+			}, poolLabels)
+			pool6StatsMap["cumulative-assigned-nas"] = factory.NewGaugeVec(prometheus.GaugeOpts{
+				Namespace: AppTypeKea,
+				Subsystem: "dhcp6",
+				Name:      "pool_cumulative_nas_assigned_total",
+				Help:      "Cumulative number of NA addresses assigned from the pool since server startup",
+			}, poolLabels)
+
+			// prefix delegation pools dhcp6
+			pdPool6StatsMap := make(map[string]*prometheus.GaugeVec)
+			pdPool6StatsMap["total-pds"] = factory.NewGaugeVec(prometheus.GaugeOpts{
+				Namespace: AppTypeKea,
+				Subsystem: "dhcp6",
+				Name:      "pool_pd_total",
+				Help:      "Size of the prefix delegation pool",
+			}, poolLabels)
+			pdPool6StatsMap["assigned-pds"] = factory.NewGaugeVec(prometheus.GaugeOpts{
+				Namespace: AppTypeKea,
+				Subsystem: "dhcp6",
+				Name:      "pool_pd_assigned_total",
+				Help:      "Assigned prefix delegations (IA_PD) in the pool",
+			}, poolLabels)
+			pdPool6StatsMap["reclaimed-leases"] = factory.NewGaugeVec(prometheus.GaugeOpts{
+				Namespace: AppTypeKea,
+				Subsystem: "dhcp6",
+				Name:      "pool_pd_reclaimed_total",
+				Help:      "Expired prefix delegations in the pool that were reclaimed",
+			}, poolLabels)
+			pdPool6StatsMap["cumulative-assigned-pds"] = factory.NewGaugeVec(prometheus.GaugeOpts{
+				Namespace: AppTypeKea,
+				Subsystem: "dhcp6",
+				Name:      "pool_cumulative_pds_assigned_total",
+				Help:      "Cumulative number of PD prefixes assigned from the pool since server startup",
+			}, poolLabels)
+
+			pke.Pool4StatsMap = pool4StatsMap
+			pke.Pool6StatsMap = pool6StatsMap
+			pke.PdPool6StatsMap = pdPool6StatsMap
+		}
 	}
 
 	// prepare http handler
@@ -613,6 +1230,14 @@ func (pke *PromKeaExporter) Shutdown() {
 	for _, stat := range pke.Adr6StatsMap {
 		pke.Registry.Unregister(stat)
 	}
+	for _, statsMap := range []map[string]*prometheus.GaugeVec{pke.Pool4StatsMap, pke.Pool6StatsMap, pke.PdPool6StatsMap} {
+		for _, stat := range statsMap {
+			pke.Registry.Unregister(stat)
+		}
+	}
+	for _, stat := range pke.HAStatsMap {
+		pke.Registry.Unregister(stat)
+	}
 
 	log.Printf("Stopped Prometheus Kea Exporter")
 }
@@ -635,8 +1260,29 @@ func (pke *PromKeaExporter) statsCollectorLoop() {
 	}
 }
 
+// Regular expressions matching the names of the per-subnet and per-pool
+// statistics, e.g. subnet[1].assigned-addresses and
+// subnet[1].pd-pool[0].assigned-pds.
+var (
+	subnetStatNameRegexp = regexp.MustCompile(`^subnet\[(\d+)\]\.(.+)$`)         // nolint:gochecknoglobals
+	poolStatNameRegexp   = regexp.MustCompile(`^(pool|pd-pool)\[(\d+)\]\.(.+)$`) // nolint:gochecknoglobals
+)
+
 // setDaemonStats stores the stat values from a daemon in the proper prometheus object.
-func (pke *PromKeaExporter) setDaemonStats(dhcpStatMap *map[string]*prometheus.GaugeVec, response map[string]GetAllStatisticResponseItemValue, ignoredStats map[string]bool, nameLookup subnetNameLookup) {
+// The family (4 or 6) selects the per-subnet and per-pool prometheus objects.
+func (pke *PromKeaExporter) setDaemonStats(family int8, response map[string]GetAllStatisticResponseItemValue, ignoredStats map[string]bool, nameLookup subnetNameLookup) {
+	subnetStatsMap := pke.Adr4StatsMap
+	poolStatsMap := pke.Pool4StatsMap
+	var pdPoolStatsMap map[string]*prometheus.GaugeVec
+	if family == 6 {
+		subnetStatsMap = pke.Adr6StatsMap
+		poolStatsMap = pke.Pool6StatsMap
+		pdPoolStatsMap = pke.PdPool6StatsMap
+	}
+
+	// subnet details are looked up once per subnet
+	subnetInfos := make(map[int]*subnetInfo)
+
 	for statName, statEntry := range response {
 		// skip ignored stats
 		if ignoredStats[statName] {
@@ -647,29 +1293,73 @@ func (pke *PromKeaExporter) setDaemonStats(dhcpStatMap *map[string]*prometheus.G
 		switch {
 		case strings.HasPrefix(statName, "pkt"):
 			// if this is pkt stat
+			if !pke.Filter.isStatAllowed(statName) {
+				continue
+			}
 			statDescr, ok := pke.PktStatsMap[statName]
 			if ok {
 				statDescr.Stat.With(prometheus.Labels{"operation": statDescr.Operation}).Set(statEntry.Value)
 			} else {
 				log.Printf("encountered unsupported stat: %s", statName)
 			}
-		case dhcpStatMap != nil && strings.HasPrefix(statName, "subnet["):
-			// if this is address per subnet stat
-			re := regexp.MustCompile(`subnet\[(\d+)\]\.(.+)`)
-			matches := re.FindStringSubmatch(statName)
-			subnetIDRaw := matches[1]
+		case subnetStatsMap != nil && strings.HasPrefix(statName, "subnet["):
+			// if this is address per subnet or per pool stat
+			matches := subnetStatNameRegexp.FindStringSubmatch(statName)
+			if matches == nil {
+				log.Printf("encountered unsupported stat: %s", statName)
+				continue
+			}
+			subnetID, err := strconv.Atoi(matches[1])
+			if err != nil {
+				log.Printf("encountered unsupported stat: %s", statName)
+				continue
+			}
 			metricName := matches[2]
 
-			subnetID, err := strconv.Atoi(subnetIDRaw)
-			subnetName := subnetIDRaw
-			if err == nil {
-				subnetName = nameLookup.getNameOrDefault(subnetID)
+			// stats of the pool within the subnet
+			poolType := ""
+			poolIndex := 0
+			if poolMatches := poolStatNameRegexp.FindStringSubmatch(metricName); poolMatches != nil {
+				poolType = poolMatches[1]
+				poolIndex, _ = strconv.Atoi(poolMatches[2])
+				metricName = poolMatches[3]
+			}
+
+			if !pke.Filter.isStatAllowed(metricName) {
+				continue
+			}
+
+			info, ok := subnetInfos[subnetID]
+			if !ok {
+				info = nameLookup.getSubnetInfo(subnetID)
+				subnetInfos[subnetID] = info
+			}
+			if !pke.Filter.isSubnetAllowed(info) {
+				continue
+			}
+
+			var statsMap map[string]*prometheus.GaugeVec
+			var labels prometheus.Labels
+			switch poolType {
+			case "":
+				statsMap = subnetStatsMap
+				labels = info.getLabels()
+			case "pool":
+				statsMap = poolStatsMap
+				labels = info.getPoolLabels(poolType, poolIndex)
+			default:
+				statsMap = pdPoolStatsMap
+				labels = info.getPoolLabels(poolType, poolIndex)
+			}
+			// per pool stats are disabled
+			if statsMap == nil {
+				continue
 			}
 
-			if stat, ok := (*dhcpStatMap)[metricName]; ok {
-				stat.With(prometheus.Labels{"subnet": subnetName}).Set(statEntry.Value)
+			if stat, ok := statsMap[metricName]; ok {
+				stat.With(labels).Set(statEntry.Value)
 			} else {
-				log.Printf("encountered unsupported stat: %s", metricName)
+				log.Printf("encountered unsupported stat: %s", statName)
 			}
 		default:
 			log.Printf("encountered unsupported stat: %s", statName)
@@ -696,6 +1386,11 @@ func (pke *PromKeaExporter) collectStats() error {
              "arguments": {}
         }`
 
+	// HA status of all relationships. It is exported after fetching it
+	// from all apps, so the metrics don't disappear during the collection.
+	var haStatuses []haRelationshipStatus
+	haComplete := true
+
 	// go through all kea apps discovered by monitor and query them for stats
 	apps := pke.AppMonitor.GetApps()
 	for _, app := range apps {
@@ -704,16 +1399,36 @@ func (pke *PromKeaExporter) collectStats() error {
 			continue
 		}
 
-		// get stats from kea
-		ctrl, err := getAccessPoint(app, AccessPointControl)
+		// get stats from kea, either via Kea CA or directly from the daemon
+		// controlled over the control socket
+		var (
+			sender keaCommandSender = pke
+			ctrl   *AccessPoint
+			err    error
+		)
+		if keaApp, ok := app.(*KeaApp); ok && keaApp.DaemonName != "" {
+			sender = &keaDaemonCommandSender{keaApp}
+			ctrl = &keaApp.AccessPoints[0]
+		} else {
+			ctrl, err = getAccessPoint(app, AccessPointControl)
+			if err != nil {
+				lastErr = err
+				haComplete = false
+				log.Errorf("problem with getting stats from kea, bad Kea access control point: %+v", err)
+				continue
+			}
+		}
+
+		// Fetching HA status
+		statuses, err := fetchHAStatus(sender, ctrl)
 		if err != nil {
-			lastErr = err
-			log.Errorf("problem with getting stats from kea, bad Kea access control point: %+v", err)
-			continue
+			haComplete = false
+			log.Errorf("problem with fetching HA status from kea: %+v", err)
 		}
+		haStatuses = append(haStatuses, statuses...)
 
 		// Fetching statistics
-		responseData, err := pke.sendCommandToKeaCA(ctrl, requestData)
+		responseData, err := sender.sendCommandToKeaCA(ctrl, requestData)
 		if err != nil {
 			log.Errorf("problem with fetching stats from kea: %+v", err)
 			continue
@@ -729,7 +1444,7 @@ func (pke *PromKeaExporter) collectStats() error {
 		}
 
 		// Prepare subnet name lookup
-		subnetNameLookup := newLazySubnetNameLookup(pke, ctrl)
+		subnetNameLookup := newLazySubnetNameLookup(sender, ctrl, pke.subnetConfigs)
 
 		// Go though responses from daemons (it can have none or some responses from dhcp4/dhcp6)
 		// and store collected stats in Prometheus structures.
@@ -737,20 +1452,144 @@ func (pke *PromKeaExporter) collectStats() error {
 		// required commands.
 		if response.Dhcp4 != nil {
 			subnetNameLookup.setFamily(4)
-			pke.setDaemonStats(&pke.Adr4StatsMap, response.Dhcp4, ignoredStats, subnetNameLookup)
+			pke.setDaemonStats(4, response.Dhcp4, ignoredStats, subnetNameLookup)
 		}
 		if response.Dhcp6 != nil {
 			subnetNameLookup.setFamily(6)
-			pke.setDaemonStats(&pke.Adr6StatsMap, response.Dhcp6, ignoredStats, subnetNameLookup)
+			pke.setDaemonStats(6, response.Dhcp6, ignoredStats, subnetNameLookup)
 		}
 	}
+
+	pke.setHAStats(haStatuses, haComplete)
+
 	return lastErr
 }
 
+// Status of a single HA relationship of a Kea daemon. The control address
+// identifies the Kea app the daemon belongs to, so the relationships of
+// the daemons of different apps running on the same machine are told
+// apart.
+type haRelationshipStatus struct {
+	controlAddress string
+	daemon         string
+	relationship   string
+	status         statusGetHAServersJSON
+}
+
+// Returns the address of the access point used to control the Kea app.
+// It is the host and port of the Kea CA or the path to the control socket
+// of the daemon.
+func getControlAddress(ctrl *AccessPoint) string {
+	if ctrl.Type == AccessPointControlSocket {
+		return ctrl.Address
+	}
+	return net.JoinHostPort(ctrl.Address, strconv.FormatInt(ctrl.Port, 10))
+}
+
+// Fetch the status of the HA relationships from the Kea daemons behind
+// the given control access point using the given sender. The relationship
+// is identified by the partner's name if Kea returns it or by its position
+// in the list otherwise.
+func fetchHAStatus(sender keaCommandSender, ctrl *AccessPoint) ([]haRelationshipStatus, error) {
+	requestData := `{
+             "command":"status-get",
+             "service":["dhcp4", "dhcp6"],
+             "arguments": {}
+        }`
+
+	responseData, err := sender.sendCommandToKeaCA(ctrl, requestData)
+	if err != nil {
+		return nil, err
+	}
+
+	var response []statusGetJSON
+	err = json.Unmarshal(responseData, &response)
+	if err != nil {
+		return nil, pkgerrors.Wrap(err, "failed to parse status-get response from Kea")
+	}
+
+	var statuses []haRelationshipStatus
+
+	for daemonIdx, item := range response {
+		// the daemon is not running or HA is not configured
+		if item.Result != 0 || item.Arguments == nil {
+			continue
+		}
+
+		// daemon 0 is dhcp4, 1 is dhcp6
+		daemon := "dhcp4"
+		if daemonIdx == 1 {
+			daemon = "dhcp6"
+		}
+
+		relationships := item.Arguments.HA
+		if len(relationships) == 0 && item.Arguments.HAServers != nil {
+			relationships = []statusGetHARelationshipJSON{{HAServers: *item.Arguments.HAServers}}
+		}
+
+		for i, relationship := range relationships {
+			name := relationship.HAServers.Remote.ServerName
+			if name == "" {
+				name = fmt.Sprint(i + 1)
+			}
+			statuses = append(statuses, haRelationshipStatus{
+				controlAddress: getControlAddress(ctrl),
+				daemon:         daemon,
+				relationship:   name,
+				status:         relationship.HAServers,
+			})
+		}
+	}
+	return statuses, nil
+}
+
+// Stores the status of the HA relationships in the prometheus objects.
+// If the status has been fetched from all apps, the metrics of the
+// relationships which no longer exist and of the states which are no
+// longer current are removed. Otherwise, the metrics are only updated,
+// so the status of the apps which failed to respond is not lost.
+func (pke *PromKeaExporter) setHAStats(statuses []haRelationshipStatus, complete bool) {
+	if complete {
+		for _, stat := range pke.HAStatsMap {
+			stat.Reset()
+		}
+	}
+	for i := range statuses {
+		pke.setHARelationshipStats(statuses[i].controlAddress, statuses[i].daemon, statuses[i].relationship, &statuses[i].status)
+	}
+}
+
+// Stores the status of a single HA relationship in the prometheus objects.
+func (pke *PromKeaExporter) setHARelationshipStats(controlAddress, daemon, relationship string, status *statusGetHAServersJSON) {
+	labels := prometheus.Labels{"control_address": controlAddress, "daemon": daemon, "relationship": relationship}
+
+	if status.Local.State != "" {
+		pke.HAStatsMap["state"].With(prometheus.Labels{
+			"control_address": controlAddress, "daemon": daemon, "relationship": relationship, "state": status.Local.State,
+		}).Set(1)
+	}
+	if status.Remote.LastState != "" {
+		pke.HAStatsMap["partner-state"].With(prometheus.Labels{
+			"control_address": controlAddress, "daemon": daemon, "relationship": relationship, "state": status.Remote.LastState,
+		}).Set(1)
+	}
+	pke.HAStatsMap["unacked-clients"].With(labels).Set(float64(status.Remote.UnackedClients))
+	pke.HAStatsMap["heartbeat-age"].With(labels).Set(float64(status.Remote.Age))
+
+	// communication-interrupted is not returned by Kea versions earlier than 1.9.0
+	if status.Remote.CommunicationInterrupted != nil {
+		interrupted := 0.0
+		if *status.Remote.CommunicationInterrupted {
+			interrupted = 1.0
+		}
+		pke.HAStatsMap["communication-interrupted"].With(labels).Set(interrupted)
+	}
+}
+
 // Send any command to Kea CA and returns body content.
 func (pke *PromKeaExporter) sendCommandToKeaCA(ctrl *AccessPoint, request string) ([]byte, error) {
 	caURL := storkutil.HostWithPortURL(ctrl.Address, ctrl.Port, ctrl.UseSecureProtocol)
-	httpRsp, err := pke.HTTPClient.Call(caURL, bytes.NewBuffer([]byte(request)))
+	httpRsp, err := pke.HTTPClient.Call(context.Background(), caURL, bytes.NewBuffer([]byte(request)))
 	if err != nil {
 		return nil, pkgerrors.Wrap(err, "problem with getting stats from kea")
 	}
@@ -3,6 +3,7 @@ package agent
 import (
 	"encoding/json"
 	"flag"
+	"path"
 	"testing"
 	"time"
 
@@ -55,6 +56,10 @@ func TestNewPromKeaExporterBasic(t *testing.T) {
 	require.Len(t, pke.PktStatsMap, 31)
 	require.Len(t, pke.Adr4StatsMap, 6)
 	require.Len(t, pke.Adr6StatsMap, 9)
+	require.Len(t, pke.Pool4StatsMap, 6)
+	require.Len(t, pke.Pool6StatsMap, 6)
+	require.Len(t, pke.PdPool6StatsMap, 4)
+	require.Len(t, pke.HAStatsMap, 5)
 }
 
 // Check starting PromKeaExporter and collecting stats.
@@ -89,7 +94,9 @@ func TestPromKeaExporterStart(t *testing.T) {
 	time.Sleep(1500 * time.Millisecond)
 
 	// check if assigned-addresses is 13
-	metric, _ := pke.Adr4StatsMap["assigned-addresses"].GetMetricWith(prometheus.Labels{"subnet": "7"})
+	metric, _ := pke.Adr4StatsMap["assigned-addresses"].GetMetricWith(prometheus.Labels{
+		"subnet": "7", "subnet_id": "7", "shared_network": "", "subnet_name": "",
+	})
 	require.Equal(t, 13.0, testutil.ToFloat64(metric))
 
 	// check if pkt4-nak-received is 19
@@ -273,7 +280,9 @@ func TestSubnetPrefixInPrometheusMetrics(t *testing.T) {
 	// Act
 	// wait 1.5 seconds that collecting is invoked at least once
 	time.Sleep(1500 * time.Millisecond)
-	metric, _ := pke.Adr4StatsMap["assigned-addresses"].GetMetricWith(prometheus.Labels{"subnet": "10.0.0.0/8"})
+	metric, _ := pke.Adr4StatsMap["assigned-addresses"].GetMetricWith(prometheus.Labels{
+		"subnet": "10.0.0.0/8", "subnet_id": "7", "shared_network": "", "subnet_name": "",
+	})
 
 	// Assert
 	require.Equal(t, 13.0, testutil.ToFloat64(metric))
@@ -324,7 +333,7 @@ func TestNewLazySubnetNameLookup(t *testing.T) {
 	accessPoint := &AccessPoint{Address: "foo"}
 
 	// Act
-	lookup := newLazySubnetNameLookup(sender, accessPoint)
+	lookup := newLazySubnetNameLookup(sender, accessPoint, nil)
 
 	// Assert
 	require.NotNil(t, lookup)
@@ -336,7 +345,7 @@ func TestLazySubnetNameLookupFetchesNames(t *testing.T) {
 	// Arrange
 	sender := newFakeKeaCASender()
 	accessPoint := &AccessPoint{Address: "foo"}
-	lookup := newLazySubnetNameLookup(sender, accessPoint)
+	lookup := newLazySubnetNameLookup(sender, accessPoint, nil)
 
 	// Act
 	name1, ok1 := lookup.getName(1)
@@ -363,7 +372,7 @@ func TestLazySubnetNameLookupFetchesOnlyOnce(t *testing.T) {
 	// Arrange
 	sender := newFakeKeaCASender()
 	accessPoint := &AccessPoint{Address: "foo"}
-	lookup := newLazySubnetNameLookup(sender, accessPoint)
+	lookup := newLazySubnetNameLookup(sender, accessPoint, nil)
 
 	// Act
 	_, _ = lookup.getName(1)
@@ -383,7 +392,7 @@ func TestLazySubnetNameLookupFetchesOnlyOnceEvenIfError(t *testing.T) {
 	sender.payload = nil
 	sender.err = errors.New("baz")
 	accessPoint := &AccessPoint{Address: "foo"}
-	lookup := newLazySubnetNameLookup(sender, accessPoint)
+	lookup := newLazySubnetNameLookup(sender, accessPoint, nil)
 
 	for _, subnetID := range []int{1, 1, 1, 42, 100} {
 		// Act
@@ -399,7 +408,7 @@ func TestLazySubnetNameLookupFetchesAgainWhenFamilyChanged(t *testing.T) {
 	// Arrange
 	sender := newFakeKeaCASender()
 	accessPoint := &AccessPoint{Address: "foo"}
-	lookup := newLazySubnetNameLookup(sender, accessPoint)
+	lookup := newLazySubnetNameLookup(sender, accessPoint, nil)
 
 	// Act
 	_, _ = lookup.getName(1)
@@ -448,8 +457,524 @@ func TestDisablePerSubnetStatsCollecting(t *testing.T) {
 
 	// Assert
 	require.Nil(t, pke.Adr4StatsMap)
+	require.Nil(t, pke.Pool4StatsMap)
 
 	// check if pkt4-nak-received is 19
 	metric, _ := pke.PktStatsMap["pkt4-nak-received"].Stat.GetMetricWith(prometheus.Labels{"operation": "nak"})
 	require.Equal(t, 19.0, testutil.ToFloat64(metric))
 }
+
+// Test that the HA status of the relationships returned by status-get
+// is exported.
+func TestPromKeaExporterHAStats(t *testing.T) {
+	// Arrange
+	defer gock.Off()
+	gock.New("http://0.1.2.3:1234/").
+		Post("/").
+		JSON(map[string]interface{}{
+			"command":   "status-get",
+			"service":   []string{"dhcp4", "dhcp6"},
+			"arguments": map[string]interface{}{},
+		}).
+		Reply(200).
+		BodyString(`[
+			{
+				"result": 0,
+				"arguments": {
+					"pid": 1234,
+					"high-availability": [
+						{
+							"ha-mode": "load-balancing",
+							"ha-servers": {
+								"local": {
+									"role": "primary",
+									"state": "partner-down",
+									"server-name": "server1"
+								},
+								"remote": {
+									"age": 17,
+									"in-touch": false,
+									"role": "secondary",
+									"last-state": "unavailable",
+									"communication-interrupted": true,
+									"unacked-clients": 3,
+									"server-name": "server2"
+								}
+							}
+						},
+						{
+							"ha-mode": "hot-standby",
+							"ha-servers": {
+								"local": {
+									"role": "primary",
+									"state": "hot-standby"
+								},
+								"remote": {
+									"age": 2,
+									"in-touch": true,
+									"role": "standby",
+									"last-state": "hot-standby",
+									"communication-interrupted": false
+								}
+							}
+						}
+					]
+				}
+			},
+			{
+				"result": 1,
+				"text": "unable to forward command to the dhcp6 service: No such file or directory. The server is likely to be offline"
+			}
+		]`)
+
+	fam := &PromFakeAppMonitor{}
+	settings := cli.NewContext(nil, flag.NewFlagSet("", 0), nil)
+	pke := NewPromKeaExporter(settings, fam)
+	defer pke.Shutdown()
+	gock.InterceptClient(pke.HTTPClient.client)
+
+	// Act
+	statuses, err := fetchHAStatus(pke, &AccessPoint{Address: "0.1.2.3", Port: 1234})
+	pke.setHAStats(statuses, true)
+
+	// Assert
+	require.NoError(t, err)
+
+	metric, _ := pke.HAStatsMap["state"].GetMetricWith(prometheus.Labels{"control_address": "0.1.2.3:1234", "daemon": "dhcp4", "relationship": "server2", "state": "partner-down"})
+	require.Equal(t, 1.0, testutil.ToFloat64(metric))
+	metric, _ = pke.HAStatsMap["partner-state"].GetMetricWith(prometheus.Labels{"control_address": "0.1.2.3:1234", "daemon": "dhcp4", "relationship": "server2", "state": "unavailable"})
+	require.Equal(t, 1.0, testutil.ToFloat64(metric))
+	metric, _ = pke.HAStatsMap["unacked-clients"].GetMetricWith(prometheus.Labels{"control_address": "0.1.2.3:1234", "daemon": "dhcp4", "relationship": "server2"})
+	require.Equal(t, 3.0, testutil.ToFloat64(metric))
+	metric, _ = pke.HAStatsMap["communication-interrupted"].GetMetricWith(prometheus.Labels{"control_address": "0.1.2.3:1234", "daemon": "dhcp4", "relationship": "server2"})
+	require.Equal(t, 1.0, testutil.ToFloat64(metric))
+	metric, _ = pke.HAStatsMap["heartbeat-age"].GetMetricWith(prometheus.Labels{"control_address": "0.1.2.3:1234", "daemon": "dhcp4", "relationship": "server2"})
+	require.Equal(t, 17.0, testutil.ToFloat64(metric))
+
+	// The relationship without the partner's name is identified by its position.
+	metric, _ = pke.HAStatsMap["state"].GetMetricWith(prometheus.Labels{"control_address": "0.1.2.3:1234", "daemon": "dhcp4", "relationship": "2", "state": "hot-standby"})
+	require.Equal(t, 1.0, testutil.ToFloat64(metric))
+	metric, _ = pke.HAStatsMap["communication-interrupted"].GetMetricWith(prometheus.Labels{"control_address": "0.1.2.3:1234", "daemon": "dhcp4", "relationship": "2"})
+	require.Equal(t, 0.0, testutil.ToFloat64(metric))
+	metric, _ = pke.HAStatsMap["heartbeat-age"].GetMetricWith(prometheus.Labels{"control_address": "0.1.2.3:1234", "daemon": "dhcp4", "relationship": "2"})
+	require.Equal(t, 2.0, testutil.ToFloat64(metric))
+
+	require.Equal(t, 2, testutil.CollectAndCount(pke.HAStatsMap["state"]))
+	require.Equal(t, 2, testutil.CollectAndCount(pke.HAStatsMap["heartbeat-age"]))
+
+	// The metrics are kept when the status couldn't be fetched from all
+	// apps and they are removed when the relationships no longer exist.
+	pke.setHAStats(nil, false)
+	require.Equal(t, 2, testutil.CollectAndCount(pke.HAStatsMap["state"]))
+	pke.setHAStats(statuses[:1], true)
+	require.Equal(t, 1, testutil.CollectAndCount(pke.HAStatsMap["state"]))
+	require.Equal(t, 1, testutil.CollectAndCount(pke.HAStatsMap["heartbeat-age"]))
+
+	// The same relationships of the daemons belonging to different apps
+	// on the same machine are exported separately.
+	other := statuses[0]
+	other.controlAddress = "/tmp/kea4-ctrl-socket"
+	pke.setHAStats([]haRelationshipStatus{statuses[0], other}, true)
+	require.Equal(t, 2, testutil.CollectAndCount(pke.HAStatsMap["state"]))
+	require.Equal(t, 2, testutil.CollectAndCount(pke.HAStatsMap["heartbeat-age"]))
+	metric, _ = pke.HAStatsMap["heartbeat-age"].GetMetricWith(prometheus.Labels{"control_address": "/tmp/kea4-ctrl-socket", "daemon": "dhcp4", "relationship": "server2"})
+	require.Equal(t, 17.0, testutil.ToFloat64(metric))
+}
+
+// Test that the HA status returned by the Kea versions earlier than 1.7.8
+// is exported.
+func TestPromKeaExporterHAStatsOldFormat(t *testing.T) {
+	// Arrange
+	defer gock.Off()
+	gock.New("http://0.1.2.3:1234/").
+		Post("/").
+		Reply(200).
+		BodyString(`[
+			{
+				"result": 1,
+				"text": "unable to forward command to the dhcp4 service: No such file or directory. The server is likely to be offline"
+			},
+			{
+				"result": 0,
+				"arguments": {
+					"ha-servers": {
+						"local": {
+							"role": "secondary",
+							"state": "load-balancing"
+						},
+						"remote": {
+							"age": 5,
+							"in-touch": true,
+							"role": "primary",
+							"last-state": "load-balancing"
+						}
+					}
+				}
+			}
+		]`)
+
+	fam := &PromFakeAppMonitor{}
+	settings := cli.NewContext(nil, flag.NewFlagSet("", 0), nil)
+	pke := NewPromKeaExporter(settings, fam)
+	defer pke.Shutdown()
+	gock.InterceptClient(pke.HTTPClient.client)
+
+	// Act
+	statuses, err := fetchHAStatus(pke, &AccessPoint{Address: "0.1.2.3", Port: 1234})
+	pke.setHAStats(statuses, true)
+
+	// Assert
+	require.NoError(t, err)
+	metric, _ := pke.HAStatsMap["state"].GetMetricWith(prometheus.Labels{"control_address": "0.1.2.3:1234", "daemon": "dhcp6", "relationship": "1", "state": "load-balancing"})
+	require.Equal(t, 1.0, testutil.ToFloat64(metric))
+	metric, _ = pke.HAStatsMap["heartbeat-age"].GetMetricWith(prometheus.Labels{"control_address": "0.1.2.3:1234", "daemon": "dhcp6", "relationship": "1"})
+	require.Equal(t, 5.0, testutil.ToFloat64(metric))
+
+	// The communication-interrupted flag is not returned by this Kea version.
+	require.Zero(t, testutil.CollectAndCount(pke.HAStatsMap["communication-interrupted"]))
+}
+
+// Test that the statistics are fetched from the Kea daemon controlled
+// over the control socket and that its response is placed at the position
+// of the daemon among the requested services.
+func TestKeaDaemonCommandSender(t *testing.T) {
+	socketPath := path.Join(t.TempDir(), "kea6-ctrl-socket")
+	commands, stop := startFakeKeaSocketServer(t, socketPath, `{
+		"result": 0,
+		"arguments": {
+			"pkt6-advertise-sent": [ [ 7, "2021-10-14 10:44:18.672378" ] ]
+		}
+	}`)
+	defer stop()
+
+	app := &KeaApp{
+		BaseApp: BaseApp{
+			Type:         AppTypeKea,
+			AccessPoints: makeAccessPoint(AccessPointControlSocket, socketPath, "", 0, false),
+		},
+		SocketClient: NewKeaSocketClient(),
+		DaemonName:   "dhcp6",
+	}
+	sender := &keaDaemonCommandSender{app}
+
+	responseData, err := sender.sendCommandToKeaCA(&app.AccessPoints[0], `{
+		"command": "statistic-get-all",
+		"service": [ "dhcp4", "dhcp6" ],
+		"arguments": {}
+	}`)
+	require.NoError(t, err)
+	command := <-commands
+	require.Equal(t, "statistic-get-all", command["command"])
+
+	var response GetAllStatisticsResponse
+	err = json.Unmarshal(responseData, &response)
+	require.NoError(t, err)
+	require.Nil(t, response.Dhcp4)
+	require.Len(t, response.Dhcp6, 1)
+	require.EqualValues(t, 7, response.Dhcp6["pkt6-advertise-sent"].Value)
+
+	// The command is not sent to the daemon if it is not among the services.
+	responseData, err = sender.sendCommandToKeaCA(&app.AccessPoints[0], `{
+		"command": "subnet4-list",
+		"service": [ "dhcp4" ]
+	}`)
+	require.NoError(t, err)
+	require.JSONEq(t, `[ null ]`, string(responseData))
+	require.Empty(t, commands)
+}
+
+// Kea config-get response used in the per-pool stats tests.
+const promKeaConfigGetResponse = `[{
+	"result": 0,
+	"arguments": {
+		"Dhcp4": {
+			"subnet4": [
+				{
+					"id": 1,
+					"subnet": "192.0.2.0/24",
+					"pools": [
+						{ "pool": "192.0.2.10 - 192.0.2.100" },
+						{ "pool": "192.0.2.150-192.0.2.200", "client-class": "phones" }
+					]
+				}
+			],
+			"shared-networks": [
+				{
+					"name": "office",
+					"subnet4": [
+						{
+							"id": 2,
+							"subnet": "198.51.100.0/24",
+							"client-class": "laptops",
+							"user-context": { "name": "floor-1" },
+							"pools": [ { "pool": "198.51.100.10-198.51.100.20" } ]
+						}
+					]
+				}
+			]
+		}
+	}
+}]`
+
+// Test that the subnet details are parsed from the Kea config-get response.
+func TestParseSubnetConfigs(t *testing.T) {
+	// Act
+	configs, err := parseSubnetConfigs([]byte(promKeaConfigGetResponse))
+
+	// Assert
+	require.NoError(t, err)
+	require.Len(t, configs, 2)
+
+	require.Equal(t, "192.0.2.0/24", configs[1].Prefix)
+	require.Empty(t, configs[1].SharedNetwork)
+	require.Empty(t, configs[1].Name)
+	require.Len(t, configs[1].Pools, 2)
+	require.Equal(t, "phones", configs[1].Pools[1].ClientClass)
+
+	require.Equal(t, "198.51.100.0/24", configs[2].Prefix)
+	require.Equal(t, "office", configs[2].SharedNetwork)
+	require.Equal(t, "floor-1", configs[2].Name)
+	require.Equal(t, "laptops", configs[2].ClientClass)
+}
+
+// Test that the error is returned when Kea can't return the configuration.
+func TestParseSubnetConfigsError(t *testing.T) {
+	configs, err := parseSubnetConfigs([]byte(`[{ "result": 1, "text": "error" }]`))
+	require.Error(t, err)
+	require.Nil(t, configs)
+
+	configs, err = parseSubnetConfigs([]byte(`[]`))
+	require.Error(t, err)
+	require.Nil(t, configs)
+
+	configs, err = parseSubnetConfigs([]byte(`[{ "result": 0, "arguments": {} }]`))
+	require.NoError(t, err)
+	require.Nil(t, configs)
+}
+
+// Fake Kea CA request sender returning the responses to the commands
+// recognized in the requests. It counts the requests with each command.
+type fakeKeaCACommandSender struct {
+	responses map[string]string
+	calls     map[string]int
+}
+
+// Returns the response to the command found in the request or an error.
+func (s *fakeKeaCACommandSender) sendCommandToKeaCA(ctrl *AccessPoint, request string) ([]byte, error) {
+	var parsed struct {
+		Command string
+	}
+	_ = json.Unmarshal([]byte(request), &parsed)
+	s.calls[parsed.Command]++
+	if response, ok := s.responses[parsed.Command]; ok {
+		return []byte(response), nil
+	}
+	return nil, errors.New("unsupported command")
+}
+
+// Test that the configuration is fetched again only when its hash changes.
+func TestSubnetConfigCacheWithHash(t *testing.T) {
+	// Arrange
+	sender := &fakeKeaCACommandSender{
+		responses: map[string]string{
+			"config-hash-get": `[{ "result": 0, "arguments": { "hash": "abc" } }]`,
+			"config-get":      promKeaConfigGetResponse,
+		},
+		calls: make(map[string]int),
+	}
+	accessPoint := &AccessPoint{Address: "foo", Port: 8000}
+	cache := make(subnetConfigCache)
+
+	// Act
+	for i := 0; i < 3; i++ {
+		lookup := newLazySubnetNameLookup(sender, accessPoint, cache)
+		info := lookup.getSubnetInfo(2)
+		require.Equal(t, "office", info.SharedNetwork)
+	}
+
+	// Assert
+	require.Equal(t, 1, sender.calls["config-get"])
+	require.Equal(t, 3, sender.calls["config-hash-get"])
+
+	// The configuration has changed.
+	sender.responses["config-hash-get"] = `[{ "result": 0, "arguments": { "hash": "def" } }]`
+	lookup := newLazySubnetNameLookup(sender, accessPoint, cache)
+	_ = lookup.getSubnetInfo(2)
+	require.Equal(t, 2, sender.calls["config-get"])
+}
+
+// Test that the configuration is fetched again after the maximum cache
+// age when Kea doesn't return the configuration hash.
+func TestSubnetConfigCacheWithoutHash(t *testing.T) {
+	// Arrange
+	sender := &fakeKeaCACommandSender{
+		responses: map[string]string{
+			"config-hash-get": `[{ "result": 2, "text": "'config-hash-get' command not supported." }]`,
+			"config-get":      promKeaConfigGetResponse,
+		},
+		calls: make(map[string]int),
+	}
+	accessPoint := &AccessPoint{Address: "foo", Port: 8000}
+	cache := make(subnetConfigCache)
+
+	// Act
+	lookup := newLazySubnetNameLookup(sender, accessPoint, cache)
+	_ = lookup.getSubnetInfo(1)
+	lookup = newLazySubnetNameLookup(sender, accessPoint, cache)
+	_ = lookup.getSubnetInfo(1)
+
+	// Assert
+	require.Equal(t, 1, sender.calls["config-get"])
+
+	// The cache is too old.
+	for _, cached := range cache {
+		cached.fetchedAt = cached.fetchedAt.Add(-subnetConfigCacheMaxAge)
+	}
+	lookup = newLazySubnetNameLookup(sender, accessPoint, cache)
+	_ = lookup.getSubnetInfo(1)
+	require.Equal(t, 2, sender.calls["config-get"])
+
+	// The family is a part of the cache key.
+	lookup.setFamily(6)
+	_ = lookup.getSubnetInfo(1)
+	require.Equal(t, 3, sender.calls["config-get"])
+}
+
+// Test that the pool labels describe the pool from the configuration or
+// its index if the configuration is unavailable.
+func TestSubnetInfoPoolLabels(t *testing.T) {
+	configs, err := parseSubnetConfigs([]byte(promKeaConfigGetResponse))
+	require.NoError(t, err)
+
+	info := &subnetInfo{ID: 1, Prefix: "192.0.2.0/24", config: configs[1]}
+	labels := info.getPoolLabels("pool", 0)
+	require.Equal(t, "192.0.2.10-192.0.2.100", labels["pool"])
+	require.Empty(t, labels["client_class"])
+	require.Equal(t, "192.0.2.0/24", labels["subnet"])
+	require.Equal(t, "1", labels["subnet_id"])
+
+	labels = info.getPoolLabels("pool", 1)
+	require.Equal(t, "192.0.2.150-192.0.2.200", labels["pool"])
+	require.Equal(t, "phones", labels["client_class"])
+
+	// unknown pool
+	labels = info.getPoolLabels("pool", 2)
+	require.Equal(t, "2", labels["pool"])
+
+	info = &subnetInfo{ID: 3}
+	labels = info.getPoolLabels("pd-pool", 0)
+	require.Equal(t, "0", labels["pool"])
+	require.Equal(t, "3", labels["subnet"])
+	require.Empty(t, labels["shared_network"])
+}
+
+// Test the allow and deny lists of the stats and subnets.
+func TestStatsFilter(t *testing.T) {
+	flags := flag.NewFlagSet("test", 0)
+	flags.Var(cli.NewStringSlice("assigned-addresses", "total-addresses"), "prometheus-kea-exporter-stats-allow", "usage")
+	flags.Var(cli.NewStringSlice("total-addresses"), "prometheus-kea-exporter-stats-deny", "usage")
+	flags.Var(cli.NewStringSlice("office", "1"), "prometheus-kea-exporter-subnets-allow", "usage")
+	flags.Var(cli.NewStringSlice("198.51.100.0/24"), "prometheus-kea-exporter-subnets-deny", "usage")
+	settings := cli.NewContext(nil, flags, nil)
+
+	filter := newStatsFilter(settings)
+
+	require.True(t, filter.isStatAllowed("assigned-addresses"))
+	require.False(t, filter.isStatAllowed("total-addresses"))
+	require.False(t, filter.isStatAllowed("declined-addresses"))
+
+	require.True(t, filter.isSubnetAllowed(&subnetInfo{ID: 1}))
+	require.True(t, filter.isSubnetAllowed(&subnetInfo{ID: 2, SharedNetwork: "office"}))
+	require.False(t, filter.isSubnetAllowed(&subnetInfo{ID: 3, Prefix: "198.51.100.0/24", SharedNetwork: "office"}))
+	require.False(t, filter.isSubnetAllowed(&subnetInfo{ID: 4}))
+
+	// empty lists allow everything
+	filter = newStatsFilter(cli.NewContext(nil, flag.NewFlagSet("", 0), nil))
+	require.True(t, filter.isStatAllowed("declined-addresses"))
+	require.True(t, filter.isSubnetAllowed(&subnetInfo{ID: 4}))
+}
+
+// Test that the per-pool stats are exported with the labels describing
+// the pool and subnet.
+func TestPoolStatsInPrometheusMetrics(t *testing.T) {
+	// Arrange
+	defer gock.Off()
+	gock.New("http://0.1.2.3:1234/").
+		Post("/").
+		JSON(map[string]interface{}{
+			"command":   "statistic-get-all",
+			"service":   []string{"dhcp4", "dhcp6"},
+			"arguments": map[string]interface{}{},
+		}).
+		Reply(200).
+		BodyString(`[{"result":0, "arguments": {
+			"subnet[1].assigned-addresses": [ [ 13, "2019-07-30 10:04:28.386740" ] ],
+			"subnet[1].pool[0].assigned-addresses": [ [ 10, "2019-07-30 10:04:28.386740" ] ],
+			"subnet[1].pool[1].assigned-addresses": [ [ 3, "2019-07-30 10:04:28.386740" ] ],
+			"subnet[1].pool[1].total-addresses": [ [ 51, "2019-07-30 10:04:28.386740" ] ],
+			"subnet[2].pool[0].assigned-addresses": [ [ 7, "2019-07-30 10:04:28.386740" ] ]
+		}}]`)
+	gock.New("http://0.1.2.3:1234/").
+		Post("/").
+		JSON(map[string]interface{}{
+			"command": "config-get",
+			"service": []string{"dhcp4"},
+		}).
+		Reply(200).
+		BodyString(promKeaConfigGetResponse)
+
+	fam := &PromFakeAppMonitor{}
+	flags := flag.NewFlagSet("test", 0)
+	flags.Var(cli.NewStringSlice("total-addresses"), "prometheus-kea-exporter-stats-deny", "usage")
+	settings := cli.NewContext(nil, flags, nil)
+	pke := NewPromKeaExporter(settings, fam)
+	defer pke.Shutdown()
+	gock.InterceptClient(pke.HTTPClient.client)
+
+	// Act
+	_ = pke.collectStats()
+
+	// Assert
+	metric, _ := pke.Adr4StatsMap["assigned-addresses"].GetMetricWith(prometheus.Labels{
+		"subnet": "192.0.2.0/24", "subnet_id": "1", "shared_network": "", "subnet_name": "",
+	})
+	require.Equal(t, 13.0, testutil.ToFloat64(metric))
+
+	metric, _ = pke.Pool4StatsMap["assigned-addresses"].GetMetricWith(prometheus.Labels{
+		"subnet": "192.0.2.0/24", "subnet_id": "1", "shared_network": "", "subnet_name": "",
+		"pool": "192.0.2.10-192.0.2.100", "client_class": "",
+	})
+	require.Equal(t, 10.0, testutil.ToFloat64(metric))
+
+	metric, _ = pke.Pool4StatsMap["assigned-addresses"].GetMetricWith(prometheus.Labels{
+		"subnet": "192.0.2.0/24", "subnet_id": "1", "shared_network": "", "subnet_name": "",
+		"pool": "192.0.2.150-192.0.2.200", "client_class": "phones",
+	})
+	require.Equal(t, 3.0, testutil.ToFloat64(metric))
+
+	metric, _ = pke.Pool4StatsMap["assigned-addresses"].GetMetricWith(prometheus.Labels{
+		"subnet": "198.51.100.0/24", "subnet_id": "2", "shared_network": "office", "subnet_name": "floor-1",
+		"pool": "198.51.100.10-198.51.100.20", "client_class": "laptops",
+	})
+	require.Equal(t, 7.0, testutil.ToFloat64(metric))
+
+	// the denied stat is not exported
+	require.Zero(t, testutil.CollectAndCount(pke.Pool4StatsMap["total-addresses"]))
+}
+
+// Test that is possible to disable per-pool stats collecting.
+func TestDisablePerPoolStatsCollecting(t *testing.T) {
+	fam := &PromFakeAppMonitor{}
+	flags := flag.NewFlagSet("test", 0)
+	flags.Bool("prometheus-kea-exporter-per-pool-stats", true, "usage")
+	settings := cli.NewContext(nil, flags, nil)
+	settings.Set("prometheus-kea-exporter-per-pool-stats", "false")
+
+	pke := NewPromKeaExporter(settings, fam)
+	defer pke.Shutdown()
+
+	require.NotNil(t, pke.Adr4StatsMap)
+	require.Nil(t, pke.Pool4StatsMap)
+	require.Nil(t, pke.Pool6StatsMap)
+	require.Nil(t, pke.PdPool6StatsMap)
+}
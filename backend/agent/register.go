@@ -31,6 +31,8 @@ var (
 	CertPEMFile    = "/var/lib/stork-agent/certs/cert.pem"         // nolint:gochecknoglobals
 	RootCAFile     = "/var/lib/stork-agent/certs/ca.pem"           // nolint:gochecknoglobals
 	AgentTokenFile = "/var/lib/stork-agent/tokens/agent-token.txt" // nolint:gochecknoglobals,gosec
+	// Serial numbers of the certificates revoked by the server.
+	RevokedCertsFile = "/var/lib/stork-agent/certs/revoked.txt" // nolint:gochecknoglobals
 )
 
 // Prompt user for server token. If user hits enter key then empty
@@ -0,0 +1,113 @@
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/x509"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc/security/advancedtls"
+)
+
+// Serial numbers of the agent certificates revoked by the server. The
+// agent refuses the connections using these certificates, e.g. from a
+// deleted machine pretending to be the server. The list is received
+// from the server and stored in the RevokedCertsFile. The server
+// doesn't remove the serial numbers from the list, so the agent only
+// adds the serial numbers received from the server to its list.
+type revokedCerts struct {
+	mutex         sync.RWMutex
+	serialNumbers map[int64]bool
+}
+
+// Creates an empty list of the revoked certificates.
+func newRevokedCerts() *revokedCerts {
+	return &revokedCerts{
+		serialNumbers: make(map[int64]bool),
+	}
+}
+
+// Reads the list of the revoked certificates from the RevokedCertsFile.
+// The list is empty if the file doesn't exist.
+func loadRevokedCerts() (*revokedCerts, error) {
+	rc := newRevokedCerts()
+	content, err := os.ReadFile(RevokedCertsFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return rc, nil
+		}
+		return rc, errors.Wrapf(err, "could not read revoked certificates: %s", RevokedCertsFile)
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		serialNumber, err := strconv.ParseInt(line, 10, 64)
+		if err != nil {
+			return rc, errors.Wrapf(err, "invalid serial number %s in %s", line, RevokedCertsFile)
+		}
+		rc.serialNumbers[serialNumber] = true
+	}
+	return rc, nil
+}
+
+// Adds the serial numbers received from the server to the list. The
+// list is stored in the RevokedCertsFile if it has changed.
+func (rc *revokedCerts) merge(serialNumbers []int64) error {
+	rc.mutex.Lock()
+	defer rc.mutex.Unlock()
+	changed := false
+	for _, serialNumber := range serialNumbers {
+		if !rc.serialNumbers[serialNumber] {
+			rc.serialNumbers[serialNumber] = true
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+
+	sorted := make([]int64, 0, len(rc.serialNumbers))
+	for serialNumber := range rc.serialNumbers {
+		sorted = append(sorted, serialNumber)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i] < sorted[j]
+	})
+	var content strings.Builder
+	for _, serialNumber := range sorted {
+		content.WriteString(strconv.FormatInt(serialNumber, 10))
+		content.WriteString("\n")
+	}
+	if err := writeAgentFile(RevokedCertsFile, []byte(content.String())); err != nil {
+		return errors.Wrapf(err, "could not store revoked certificates: %s", RevokedCertsFile)
+	}
+	log.Infof("updated list of %d revoked certificates", len(sorted))
+	return nil
+}
+
+// Checks if the certificate has been revoked.
+func (rc *revokedCerts) isRevoked(cert *x509.Certificate) bool {
+	rc.mutex.RLock()
+	defer rc.mutex.RUnlock()
+	return cert.SerialNumber != nil && cert.SerialNumber.IsInt64() && rc.serialNumbers[cert.SerialNumber.Int64()]
+}
+
+// Verification hook for advanced TLS. It refuses the peer presenting the
+// revoked certificate.
+func (rc *revokedCerts) verifyPeer(params *advancedtls.VerificationFuncParams) (*advancedtls.VerificationResults, error) {
+	if params.Leaf != nil && rc.isRevoked(params.Leaf) {
+		err := errors.Errorf("certificate with serial number %s has been revoked", params.Leaf.SerialNumber)
+		log.Warnf("%+v", err)
+		return nil, err
+	}
+	return &advancedtls.VerificationResults{}, nil
+}
@@ -0,0 +1,86 @@
+package agent
+
+import (
+	"crypto/x509"
+	"math/big"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/security/advancedtls"
+
+	agentapi "isc.org/stork/api"
+)
+
+// Test that the serial numbers received from the server are stored in
+// the file and loaded from it.
+func TestRevokedCertsMergeAndLoad(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "revocation")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	restorePaths := RememberPaths()
+	defer restorePaths()
+	RevokedCertsFile = path.Join(tmpDir, "revoked.txt")
+
+	// The list is empty when the file doesn't exist.
+	rc, err := loadRevokedCerts()
+	require.NoError(t, err)
+	require.Empty(t, rc.serialNumbers)
+
+	require.NoError(t, rc.merge([]int64{12, 5}))
+	content, err := os.ReadFile(RevokedCertsFile)
+	require.NoError(t, err)
+	require.Equal(t, "5\n12\n", string(content))
+
+	// The serial numbers are never removed from the list.
+	require.NoError(t, rc.merge([]int64{7}))
+
+	rc, err = loadRevokedCerts()
+	require.NoError(t, err)
+	require.Len(t, rc.serialNumbers, 3)
+	require.True(t, rc.isRevoked(&x509.Certificate{SerialNumber: big.NewInt(5)}))
+	require.True(t, rc.isRevoked(&x509.Certificate{SerialNumber: big.NewInt(7)}))
+	require.False(t, rc.isRevoked(&x509.Certificate{SerialNumber: big.NewInt(8)}))
+
+	// Invalid file content.
+	require.NoError(t, os.WriteFile(RevokedCertsFile, []byte("foo\n"), 0600))
+	_, err = loadRevokedCerts()
+	require.Error(t, err)
+}
+
+// Test that the peer presenting the revoked certificate is refused.
+func TestRevokedCertsVerifyPeer(t *testing.T) {
+	rc := newRevokedCerts()
+	rc.serialNumbers[3] = true
+
+	results, err := rc.verifyPeer(&advancedtls.VerificationFuncParams{
+		Leaf: &x509.Certificate{SerialNumber: big.NewInt(2)},
+	})
+	require.NoError(t, err)
+	require.NotNil(t, results)
+
+	results, err = rc.verifyPeer(&advancedtls.VerificationFuncParams{
+		Leaf: &x509.Certificate{SerialNumber: big.NewInt(3)},
+	})
+	require.Error(t, err)
+	require.Nil(t, results)
+}
+
+// Test that the serial numbers of the revoked certificates sent in the
+// GetState request are added to the list.
+func TestGetStateRevokedCerts(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "revocation")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	restorePaths := RememberPaths()
+	defer restorePaths()
+	RevokedCertsFile = path.Join(tmpDir, "revoked.txt")
+
+	sa, ctx := setupAgentTest()
+	_, err = sa.GetState(ctx, &agentapi.GetStateReq{
+		RevokedCertSerialNumbers: []int64{42},
+	})
+	require.NoError(t, err)
+	require.True(t, sa.revokedCerts.isRevoked(&x509.Certificate{SerialNumber: big.NewInt(42)}))
+}
@@ -15,6 +15,7 @@ func RememberPaths() func() {
 	originalRootCAFile := RootCAFile
 	originalAgentTokenFile := AgentTokenFile
 	originalCredentialsFile := CredentialsFile
+	originalRevokedCertsFile := RevokedCertsFile
 
 	return func() {
 		KeyPEMFile = originalKeyPEMFile
@@ -22,6 +23,7 @@ func RememberPaths() func() {
 		RootCAFile = originalRootCAFile
 		AgentTokenFile = originalAgentTokenFile
 		CredentialsFile = originalCredentialsFile
+		RevokedCertsFile = originalRevokedCertsFile
 	}
 }
 
@@ -0,0 +1,317 @@
+package agent
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	pkgerrors "github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/encoding"
+	grpcproto "google.golang.org/grpc/encoding/proto"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/security/advancedtls"
+	"google.golang.org/grpc/status"
+
+	agentapi "isc.org/stork/api"
+	"isc.org/stork/tracing"
+)
+
+// Names of the metadata identifying the agent opening the tunnel. They
+// must match the names expected by the server.
+const (
+	tunnelAgentAddressKey = "stork-agent-address"
+	tunnelAgentPortKey    = "stork-agent-port"
+)
+
+// Time to wait before re-opening the broken tunnel.
+const tunnelReconnectInterval = 10 * time.Second
+
+// Read the latest Stork Agent's cert from file for presenting its identity
+// to the Stork server when the agent opens the tunnel.
+func getIdentityCertificatesForClient(info *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	certificates, err := getIdentityCertificatesForServer(nil)
+	if err != nil {
+		return nil, err
+	}
+	return certificates[0], nil
+}
+
+// Prepare gRPC client credentials used to open the tunnel to the server.
+// The server certificate is verified against the server CA but the server
+// address is not verified because the server may be reached via the
+// address which is not included in its certificate, e.g. the public
+// address of the NAT.
+func newTunnelClientCreds() (grpc.DialOption, error) {
+	options := &advancedtls.ClientOptions{
+		RootOptions: advancedtls.RootCertificateOptions{
+			GetRootCertificates: getRootCertificates,
+		},
+		IdentityOptions: advancedtls.IdentityCertificateOptions{
+			GetIdentityCertificatesForClient: getIdentityCertificatesForClient,
+		},
+		VType: advancedtls.CertVerification,
+	}
+	creds, err := advancedtls.NewClientCreds(options)
+	if err != nil {
+		return nil, pkgerrors.Wrapf(err, "cannot create client credentials for TLS")
+	}
+	return grpc.WithTransportCredentials(creds), nil
+}
+
+// Server side of the stream handled over the tunnel. The request is
+// received in the message initiating the call and the responses are
+// sent over the tunnel.
+type tunnelServerStream struct {
+	ctx      context.Context
+	codec    encoding.Codec
+	payload  []byte
+	received bool
+	send     func(payload []byte) error
+}
+
+// Does nothing because the metadata are not sent over the tunnel. It is
+// a part of the grpc.ServerStream.
+func (s *tunnelServerStream) SetHeader(metadata.MD) error {
+	return nil
+}
+
+// Does nothing because the metadata are not sent over the tunnel. It is
+// a part of the grpc.ServerStream.
+func (s *tunnelServerStream) SendHeader(metadata.MD) error {
+	return nil
+}
+
+// Does nothing because the metadata are not sent over the tunnel. It is
+// a part of the grpc.ServerStream.
+func (s *tunnelServerStream) SetTrailer(metadata.MD) {}
+
+// Returns the context of the call. It is a part of the grpc.ServerStream.
+func (s *tunnelServerStream) Context() context.Context {
+	return s.ctx
+}
+
+// Sends the response over the tunnel. It is a part of the grpc.ServerStream.
+func (s *tunnelServerStream) SendMsg(m interface{}) error {
+	payload, err := s.codec.Marshal(m)
+	if err != nil {
+		return pkgerrors.Wrapf(err, "problem with serializing the response")
+	}
+	return s.send(payload)
+}
+
+// Returns the request received in the message initiating the call. Only
+// one request is received. It is a part of the grpc.ServerStream.
+func (s *tunnelServerStream) RecvMsg(m interface{}) error {
+	if s.received {
+		return io.EOF
+	}
+	s.received = true
+	return s.codec.Unmarshal(s.payload, m)
+}
+
+// Handles the calls received from the server over the tunnel. Each call
+// is handled in a separate goroutine by the same handler which handles
+// the calls received over the regular gRPC connection. The server can
+// cancel the call by sending the message with the end flag set. It
+// returns when the tunnel is broken or the context is cancelled.
+func (sa *StorkAgent) handleTunnel(ctx context.Context, stream agentapi.ServerTunnel_ConnectClient) error {
+	codec := encoding.GetCodec(grpcproto.Name)
+
+	ctx, cancel := context.WithCancel(ctx)
+	var (
+		wg        sync.WaitGroup
+		sendMutex sync.Mutex
+		mutex     sync.Mutex
+		calls     = make(map[int64]context.CancelFunc)
+	)
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+
+	send := func(msg *agentapi.TunnelMessage) error {
+		sendMutex.Lock()
+		defer sendMutex.Unlock()
+		return stream.Send(msg)
+	}
+
+	servicePrefix := "/" + agentapi.Agent_ServiceDesc.ServiceName + "/"
+
+	for {
+		msg, err := stream.Recv()
+		if err != nil {
+			if errors.Is(err, io.EOF) || ctx.Err() != nil {
+				return nil
+			}
+			return pkgerrors.Wrapf(err, "problem with receiving the message over the tunnel")
+		}
+
+		if msg.End {
+			// The server cancels the call.
+			mutex.Lock()
+			if cancelCall, ok := calls[msg.CallID]; ok {
+				cancelCall()
+			}
+			mutex.Unlock()
+			continue
+		}
+
+		callCtx, cancelCall := context.WithCancel(ctx)
+		mutex.Lock()
+		calls[msg.CallID] = cancelCall
+		mutex.Unlock()
+
+		wg.Add(1)
+		go func(msg *agentapi.TunnelMessage) {
+			defer func() {
+				mutex.Lock()
+				delete(calls, msg.CallID)
+				mutex.Unlock()
+				cancelCall()
+				wg.Done()
+			}()
+
+			rsp, err := sa.handleTunnelCall(callCtx, servicePrefix, codec, msg, func(payload []byte) error {
+				return send(&agentapi.TunnelMessage{
+					CallID:  msg.CallID,
+					Payload: payload,
+				})
+			})
+			last := &agentapi.TunnelMessage{
+				CallID:  msg.CallID,
+				Payload: rsp,
+				End:     true,
+			}
+			if err != nil {
+				log.WithFields(log.Fields{
+					"method": msg.Method,
+				}).Warnf("call received over the tunnel failed: %+v", err)
+				// Send the status code, so the server returns the
+				// same error as if the call was not tunneled.
+				st := status.Convert(err)
+				last.Payload = nil
+				last.Error = st.Message()
+				last.ErrorCode = int32(st.Code())
+			}
+			if err = send(last); err != nil {
+				log.Warnf("problem with sending the response over the tunnel: %+v", err)
+			}
+		}(msg)
+	}
+}
+
+// Handles a single call received over the tunnel. The unary call response
+// is returned. The stream responses are sent using the send function. The
+// call continues the trace started by the server.
+func (sa *StorkAgent) handleTunnelCall(ctx context.Context, servicePrefix string, codec encoding.Codec, msg *agentapi.TunnelMessage, send func(payload []byte) error) (rsp []byte, err error) {
+	ctx, span := tracing.StartSpan(tracing.ExtractMap(ctx, msg.Metadata), msg.Method,
+		attribute.String("rpc.system", "grpc"),
+		attribute.Bool("stork.tunnel", true),
+	)
+	defer func() {
+		tracing.EndSpan(span, err)
+	}()
+
+	if !strings.HasPrefix(msg.Method, servicePrefix) {
+		return nil, status.Errorf(codes.Unimplemented, "unknown service in method %s", msg.Method)
+	}
+	name := strings.TrimPrefix(msg.Method, servicePrefix)
+
+	for _, method := range agentapi.Agent_ServiceDesc.Methods {
+		if method.MethodName != name {
+			continue
+		}
+		decode := func(in interface{}) error {
+			return codec.Unmarshal(msg.Payload, in)
+		}
+		out, err := method.Handler(sa, ctx, decode, nil)
+		if err != nil {
+			return nil, err
+		}
+		return codec.Marshal(out)
+	}
+
+	for _, desc := range agentapi.Agent_ServiceDesc.Streams {
+		if desc.StreamName != name {
+			continue
+		}
+		stream := &tunnelServerStream{
+			ctx:     ctx,
+			codec:   codec,
+			payload: msg.Payload,
+			send:    send,
+		}
+		return nil, desc.Handler(sa, stream)
+	}
+
+	return nil, status.Errorf(codes.Unimplemented, "unknown method %s", msg.Method)
+}
+
+// Opens the tunnel to the server and handles the calls received over it
+// until the tunnel is broken or the context is cancelled. The agent
+// identifies itself with the address and the port it was registered with.
+func (sa *StorkAgent) openTunnel(ctx context.Context, serverAddr string) error {
+	creds, err := newTunnelClientCreds()
+	if err != nil {
+		return err
+	}
+	conn, err := grpc.DialContext(ctx, serverAddr, creds)
+	if err != nil {
+		return pkgerrors.Wrapf(err, "problem with dial to server %s", serverAddr)
+	}
+	defer conn.Close()
+
+	md := metadata.Pairs(
+		tunnelAgentAddressKey, sa.Settings.String("host"),
+		tunnelAgentPortKey, strconv.Itoa(sa.Settings.Int("port")),
+	)
+	stream, err := agentapi.NewServerTunnelClient(conn).Connect(metadata.NewOutgoingContext(ctx, md))
+	if err != nil {
+		return pkgerrors.Wrapf(err, "problem with opening the tunnel to server %s", serverAddr)
+	}
+
+	log.WithFields(log.Fields{
+		"server": serverAddr,
+	}).Info("opened the tunnel to the server")
+
+	return sa.handleTunnel(ctx, stream)
+}
+
+// Starts opening the tunnel to the server in background. It is used when
+// the server cannot connect to the agent, e.g. because the agent is
+// behind NAT. The tunnel is re-opened when it breaks.
+func (sa *StorkAgent) StartTunnel(serverAddr string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	sa.tunnelCancel = cancel
+	sa.tunnelDone = make(chan struct{})
+
+	go func() {
+		defer close(sa.tunnelDone)
+		for {
+			err := sa.openTunnel(ctx, serverAddr)
+			if ctx.Err() != nil {
+				return
+			}
+			if err == nil {
+				err = pkgerrors.New("tunnel closed by the server")
+			}
+			log.WithFields(log.Fields{
+				"server": serverAddr,
+			}).Warnf("re-opening the tunnel in %s: %+v", tunnelReconnectInterval, err)
+			select {
+			case <-time.After(tunnelReconnectInterval):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,125 @@
+package agent
+
+import (
+	"context"
+	"io"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/encoding"
+	grpcproto "google.golang.org/grpc/encoding/proto"
+
+	agentapi "isc.org/stork/api"
+)
+
+// Fake tunnel to the server. The messages written to the received
+// channel are received by the agent. The messages sent by the agent
+// are written to the sent channel.
+type fakeServerTunnel struct {
+	grpc.ClientStream
+	sent     chan *agentapi.TunnelMessage
+	received chan *agentapi.TunnelMessage
+}
+
+// Creates the fake tunnel.
+func newFakeServerTunnel() *fakeServerTunnel {
+	return &fakeServerTunnel{
+		sent:     make(chan *agentapi.TunnelMessage, 10),
+		received: make(chan *agentapi.TunnelMessage, 10),
+	}
+}
+
+// Sends the message to the fake server.
+func (s *fakeServerTunnel) Send(msg *agentapi.TunnelMessage) error {
+	s.sent <- msg
+	return nil
+}
+
+// Receives the message from the fake server. It returns io.EOF when
+// the received channel is closed.
+func (s *fakeServerTunnel) Recv() (*agentapi.TunnelMessage, error) {
+	msg, ok := <-s.received
+	if !ok {
+		return nil, io.EOF
+	}
+	return msg, nil
+}
+
+// Test that the calls received over the tunnel are handled and the
+// responses are sent back over the tunnel.
+func TestHandleTunnel(t *testing.T) {
+	sa, ctx := setupAgentTest()
+	codec := encoding.GetCodec(grpcproto.Name)
+
+	filename := path.Join(t.TempDir(), "kea-dhcp4.log")
+	err := os.WriteFile(filename, []byte("lorem ipsum\ndolor sit amet\n"), 0o600)
+	require.NoError(t, err)
+	sa.logTailer.allow(filename)
+
+	tunnel := newFakeServerTunnel()
+	done := make(chan error)
+	go func() {
+		done <- sa.handleTunnel(ctx, tunnel)
+	}()
+
+	// Unary call.
+	payload, err := codec.Marshal(&agentapi.PingReq{})
+	require.NoError(t, err)
+	tunnel.received <- &agentapi.TunnelMessage{
+		CallID:  1,
+		Method:  "/agentapi.Agent/Ping",
+		Payload: payload,
+	}
+	msg := <-tunnel.sent
+	require.EqualValues(t, 1, msg.CallID)
+	require.True(t, msg.End)
+	require.Empty(t, msg.Error)
+	require.NoError(t, codec.Unmarshal(msg.Payload, &agentapi.PingRsp{}))
+
+	// Streaming call.
+	payload, err = codec.Marshal(&agentapi.SearchTextFileReq{
+		Path:    filename,
+		Pattern: "dolor",
+	})
+	require.NoError(t, err)
+	tunnel.received <- &agentapi.TunnelMessage{
+		CallID:  2,
+		Method:  "/agentapi.Agent/SearchTextFile",
+		Payload: payload,
+	}
+	var responses []*agentapi.SearchTextFileRsp
+	for msg = range tunnel.sent {
+		require.EqualValues(t, 2, msg.CallID)
+		require.Empty(t, msg.Error)
+		if msg.End {
+			break
+		}
+		rsp := &agentapi.SearchTextFileRsp{}
+		require.NoError(t, codec.Unmarshal(msg.Payload, rsp))
+		responses = append(responses, rsp)
+	}
+	require.NotEmpty(t, responses)
+	last := responses[len(responses)-1]
+	require.True(t, last.Done)
+	require.EqualValues(t, 1, last.MatchCount)
+	require.Equal(t, "dolor sit amet", responses[0].Matches[0].Line)
+
+	// Unknown method.
+	tunnel.received <- &agentapi.TunnelMessage{
+		CallID: 3,
+		Method: "/agentapi.Agent/Unknown",
+	}
+	msg = <-tunnel.sent
+	require.EqualValues(t, 3, msg.CallID)
+	require.True(t, msg.End)
+	require.Contains(t, msg.Error, "unknown method")
+	require.EqualValues(t, codes.Unimplemented, msg.ErrorCode)
+
+	// The tunnel is closed by the server.
+	close(tunnel.received)
+	require.NoError(t, <-done)
+}
@@ -0,0 +1,447 @@
+package keaconfig
+
+import (
+	"encoding/json"
+
+	"github.com/pkg/errors"
+)
+
+// The structures below comprise a typed model of the Kea DHCPv4 and
+// DHCPv6 server configurations. Each structure holding a configuration
+// map contains the Extra field. It holds the parameters which are not
+// represented by the structure fields, e.g. the parameters introduced
+// in the new Kea versions. They are preserved when the structure is
+// converted back to JSON, so the conversion is lossless. The optional
+// parameters are represented by pointers, so it is possible to tell
+// whether they were specified in the configuration or not.
+
+// Structure holding the lifetimes and timers specified at the global,
+// shared network, subnet or client class level.
+type Lifetimes struct {
+	ValidLifetime        *int64   `json:"valid-lifetime,omitempty"`
+	MinValidLifetime     *int64   `json:"min-valid-lifetime,omitempty"`
+	MaxValidLifetime     *int64   `json:"max-valid-lifetime,omitempty"`
+	PreferredLifetime    *int64   `json:"preferred-lifetime,omitempty"`
+	MinPreferredLifetime *int64   `json:"min-preferred-lifetime,omitempty"`
+	MaxPreferredLifetime *int64   `json:"max-preferred-lifetime,omitempty"`
+	RenewTimer           *int64   `json:"renew-timer,omitempty"`
+	RebindTimer          *int64   `json:"rebind-timer,omitempty"`
+	CalculateTeeTimes    *bool    `json:"calculate-tee-times,omitempty"`
+	T1Percent            *float64 `json:"t1-percent,omitempty"`
+	T2Percent            *float64 `json:"t2-percent,omitempty"`
+}
+
+// Structure holding the DDNS parameters specified at the global, shared
+// network or subnet level.
+type DDNSParameters struct {
+	DDNSSendUpdates           *bool   `json:"ddns-send-updates,omitempty"`
+	DDNSOverrideNoUpdate      *bool   `json:"ddns-override-no-update,omitempty"`
+	DDNSOverrideClientUpdate  *bool   `json:"ddns-override-client-update,omitempty"`
+	DDNSReplaceClientName     *string `json:"ddns-replace-client-name,omitempty"`
+	DDNSGeneratedPrefix       *string `json:"ddns-generated-prefix,omitempty"`
+	DDNSQualifyingSuffix      *string `json:"ddns-qualifying-suffix,omitempty"`
+	DDNSUpdateOnRenew         *bool   `json:"ddns-update-on-renew,omitempty"`
+	DDNSUseConflictResolution *bool   `json:"ddns-use-conflict-resolution,omitempty"`
+	HostnameCharSet           *string `json:"hostname-char-set,omitempty"`
+	HostnameCharReplacement   *string `json:"hostname-char-replacement,omitempty"`
+}
+
+// Structure holding the parameters which can be specified at the global,
+// shared network and subnet level.
+type NetworkParameters struct {
+	Lifetimes
+	DDNSParameters
+	ReservationModes
+	Authoritative        *bool        `json:"authoritative,omitempty"`
+	BootFileName         *string      `json:"boot-file-name,omitempty"`
+	CacheMaxAge          *int64       `json:"cache-max-age,omitempty"`
+	CacheThreshold       *float64     `json:"cache-threshold,omitempty"`
+	ClientClass          *string      `json:"client-class,omitempty"`
+	Interface            *string      `json:"interface,omitempty"`
+	InterfaceID          *string      `json:"interface-id,omitempty"`
+	MatchClientID        *bool        `json:"match-client-id,omitempty"`
+	NextServer           *string      `json:"next-server,omitempty"`
+	OptionData           []OptionData `json:"option-data,omitempty"`
+	RapidCommit          *bool        `json:"rapid-commit,omitempty"`
+	Relay                *Relay       `json:"relay,omitempty"`
+	RequireClientClasses []string     `json:"require-client-classes,omitempty"`
+	ServerHostname       *string      `json:"server-hostname,omitempty"`
+	StoreExtendedInfo    *bool        `json:"store-extended-info,omitempty"`
+}
+
+// Represents a DHCP option specified in the option-data list.
+type OptionData struct {
+	AlwaysSend *bool   `json:"always-send,omitempty"`
+	Code       *int64  `json:"code,omitempty"`
+	CSVFormat  *bool   `json:"csv-format,omitempty"`
+	Data       *string `json:"data,omitempty"`
+	Name       *string `json:"name,omitempty"`
+	NeverSend  *bool   `json:"never-send,omitempty"`
+	Space      *string `json:"space,omitempty"`
+
+	Extra map[string]interface{} `json:"-"`
+}
+
+// Represents a DHCP option definition specified in the option-def list.
+type OptionDef struct {
+	Array       *bool   `json:"array,omitempty"`
+	Code        int64   `json:"code,omitempty"`
+	Encapsulate *string `json:"encapsulate,omitempty"`
+	Name        string  `json:"name,omitempty"`
+	RecordTypes *string `json:"record-types,omitempty"`
+	Space       *string `json:"space,omitempty"`
+	Type        string  `json:"type,omitempty"`
+
+	Extra map[string]interface{} `json:"-"`
+}
+
+// Represents the relay information specified for a shared network
+// or subnet.
+type Relay struct {
+	IPAddress   *string  `json:"ip-address,omitempty"`
+	IPAddresses []string `json:"ip-addresses,omitempty"`
+
+	Extra map[string]interface{} `json:"-"`
+}
+
+// Represents a client class specified in the client-classes list.
+type ClientClass struct {
+	Lifetimes
+	Name           string       `json:"name,omitempty"`
+	Test           *string      `json:"test,omitempty"`
+	OnlyIfRequired *bool        `json:"only-if-required,omitempty"`
+	OptionData     []OptionData `json:"option-data,omitempty"`
+	OptionDef      []OptionDef  `json:"option-def,omitempty"`
+	NextServer     *string      `json:"next-server,omitempty"`
+	ServerHostname *string      `json:"server-hostname,omitempty"`
+	BootFileName   *string      `json:"boot-file-name,omitempty"`
+
+	Extra map[string]interface{} `json:"-"`
+}
+
+// Represents an address pool specified in the pools list.
+type AddressPool struct {
+	Pool                 string       `json:"pool,omitempty"`
+	ClientClass          *string      `json:"client-class,omitempty"`
+	RequireClientClasses []string     `json:"require-client-classes,omitempty"`
+	OptionData           []OptionData `json:"option-data,omitempty"`
+
+	Extra map[string]interface{} `json:"-"`
+}
+
+// Represents a delegated prefix pool specified in the pd-pools list.
+type PrefixPool struct {
+	Prefix               string       `json:"prefix,omitempty"`
+	PrefixLen            int          `json:"prefix-len,omitempty"`
+	DelegatedLen         int          `json:"delegated-len,omitempty"`
+	ExcludedPrefix       *string      `json:"excluded-prefix,omitempty"`
+	ExcludedPrefixLen    *int         `json:"excluded-prefix-len,omitempty"`
+	ClientClass          *string      `json:"client-class,omitempty"`
+	RequireClientClasses []string     `json:"require-client-classes,omitempty"`
+	OptionData           []OptionData `json:"option-data,omitempty"`
+
+	Extra map[string]interface{} `json:"-"`
+}
+
+// Represents a host reservation specified in the reservations list.
+type HostReservation struct {
+	Reservation
+	ClientClasses  []string     `json:"client-classes,omitempty"`
+	OptionData     []OptionData `json:"option-data,omitempty"`
+	NextServer     *string      `json:"next-server,omitempty"`
+	ServerHostname *string      `json:"server-hostname,omitempty"`
+	BootFileName   *string      `json:"boot-file-name,omitempty"`
+
+	Extra map[string]interface{} `json:"-"`
+}
+
+// Represents a subnet specified in the subnet4 or subnet6 list.
+type SubnetConfig struct {
+	NetworkParameters
+	ID           int64             `json:"id,omitempty"`
+	Subnet       string            `json:"subnet,omitempty"`
+	Pools        []AddressPool     `json:"pools,omitempty"`
+	PdPools      []PrefixPool      `json:"pd-pools,omitempty"`
+	Reservations []HostReservation `json:"reservations,omitempty"`
+
+	Extra map[string]interface{} `json:"-"`
+}
+
+// Represents a shared network specified in the shared-networks list.
+type SharedNetworkConfig struct {
+	NetworkParameters
+	Name    string         `json:"name,omitempty"`
+	Subnet4 []SubnetConfig `json:"subnet4,omitempty"`
+	Subnet6 []SubnetConfig `json:"subnet6,omitempty"`
+
+	Extra map[string]interface{} `json:"-"`
+}
+
+// Represents the interfaces-config map.
+type InterfacesConfig struct {
+	Interfaces     []string `json:"interfaces,omitempty"`
+	DHCPSocketType *string  `json:"dhcp-socket-type,omitempty"`
+	ReDetect       *bool    `json:"re-detect,omitempty"`
+
+	Extra map[string]interface{} `json:"-"`
+}
+
+// Represents the contents of the Dhcp4 or Dhcp6 configuration node.
+type DHCPConfig struct {
+	NetworkParameters
+	ClientClasses             []ClientClass         `json:"client-classes,omitempty"`
+	DeclineProbationPeriod    *int64                `json:"decline-probation-period,omitempty"`
+	EchoClientID              *bool                 `json:"echo-client-id,omitempty"`
+	HooksLibraries            []HooksLibrary        `json:"hooks-libraries,omitempty"`
+	HostReservationIdentifier []string              `json:"host-reservation-identifiers,omitempty"`
+	InterfacesConfig          *InterfacesConfig     `json:"interfaces-config,omitempty"`
+	OptionDef                 []OptionDef           `json:"option-def,omitempty"`
+	Reservations              []HostReservation     `json:"reservations,omitempty"`
+	ServerTag                 *string               `json:"server-tag,omitempty"`
+	SharedNetworks            []SharedNetworkConfig `json:"shared-networks,omitempty"`
+	Subnet4                   []SubnetConfig        `json:"subnet4,omitempty"`
+	Subnet6                   []SubnetConfig        `json:"subnet6,omitempty"`
+
+	Extra map[string]interface{} `json:"-"`
+}
+
+// Decodes the Dhcp4 or Dhcp6 configuration node into the typed
+// structures.
+func (c *Map) DecodeDHCPConfig() (*DHCPConfig, error) {
+	rootName, ok := c.GetRootName()
+	if !ok {
+		return nil, errors.New("missing root node")
+	}
+	if rootName != RootNameDHCPv4 && rootName != RootNameDHCPv6 {
+		return nil, errors.Errorf("invalid configuration root node %s", rootName)
+	}
+	data, err := json.Marshal((*c)[rootName])
+	if err != nil {
+		return nil, errors.Wrapf(err, "problem with serializing the %s configuration", rootName)
+	}
+	config := &DHCPConfig{}
+	if err = json.Unmarshal(data, config); err != nil {
+		return nil, errors.Wrapf(err, "problem with parsing the %s configuration", rootName)
+	}
+	return config, nil
+}
+
+// Creates new configuration map from the typed DHCP server configuration.
+// The root name must be Dhcp4 or Dhcp6.
+func NewFromDHCPConfig(rootName string, config *DHCPConfig) (*Map, error) {
+	if rootName != RootNameDHCPv4 && rootName != RootNameDHCPv6 {
+		return nil, errors.Errorf("invalid configuration root node %s", rootName)
+	}
+	data, err := json.Marshal(config)
+	if err != nil {
+		return nil, errors.Wrapf(err, "problem with serializing the %s configuration", rootName)
+	}
+	var root map[string]interface{}
+	if err = json.Unmarshal(data, &root); err != nil {
+		return nil, errors.Wrapf(err, "problem with parsing the %s configuration", rootName)
+	}
+	return &Map{rootName: root}, nil
+}
+
+// Parses the JSON object into the structure pointed to by value and
+// stores the object members not present in the structure in the extra
+// map. The members which would not be serialized back from the structure,
+// e.g. empty lists, are also stored in the extra map, so they can be
+// restored in marshalWithExtra. The value must be a pointer to a type
+// not implementing the json.Unmarshaler to avoid the recursion.
+func unmarshalWithExtra(data []byte, value interface{}, extra *map[string]interface{}) error {
+	if err := json.Unmarshal(data, value); err != nil {
+		return err
+	}
+	var raw map[string]interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	known, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	var knownMembers map[string]json.RawMessage
+	if err = json.Unmarshal(known, &knownMembers); err != nil {
+		return err
+	}
+	*extra = nil
+	for name, member := range raw {
+		if _, ok := knownMembers[name]; ok {
+			continue
+		}
+		if *extra == nil {
+			*extra = make(map[string]interface{})
+		}
+		(*extra)[name] = member
+	}
+	return nil
+}
+
+// Serializes the value to the JSON object and appends the members held
+// in the extra map unless the value contains the members with the same
+// names. The value must not implement the json.Marshaler to avoid the
+// recursion.
+func marshalWithExtra(value interface{}, extra map[string]interface{}) ([]byte, error) {
+	data, err := json.Marshal(value)
+	if err != nil || len(extra) == 0 {
+		return data, err
+	}
+	var members map[string]json.RawMessage
+	if err = json.Unmarshal(data, &members); err != nil {
+		return nil, err
+	}
+	for name, member := range extra {
+		if _, ok := members[name]; ok {
+			continue
+		}
+		if members[name], err = json.Marshal(member); err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(members)
+}
+
+// Implements the json.Unmarshaler interface for the OptionData.
+func (o *OptionData) UnmarshalJSON(data []byte) error {
+	type optionData OptionData
+	return unmarshalWithExtra(data, (*optionData)(o), &o.Extra)
+}
+
+// Implements the json.Marshaler interface for the OptionData.
+func (o OptionData) MarshalJSON() ([]byte, error) {
+	type optionData OptionData
+	return marshalWithExtra(optionData(o), o.Extra)
+}
+
+// Implements the json.Unmarshaler interface for the OptionDef.
+func (d *OptionDef) UnmarshalJSON(data []byte) error {
+	type optionDef OptionDef
+	return unmarshalWithExtra(data, (*optionDef)(d), &d.Extra)
+}
+
+// Implements the json.Marshaler interface for the OptionDef.
+func (d OptionDef) MarshalJSON() ([]byte, error) {
+	type optionDef OptionDef
+	return marshalWithExtra(optionDef(d), d.Extra)
+}
+
+// Implements the json.Unmarshaler interface for the Relay.
+func (r *Relay) UnmarshalJSON(data []byte) error {
+	type relay Relay
+	return unmarshalWithExtra(data, (*relay)(r), &r.Extra)
+}
+
+// Implements the json.Marshaler interface for the Relay.
+func (r Relay) MarshalJSON() ([]byte, error) {
+	type relay Relay
+	return marshalWithExtra(relay(r), r.Extra)
+}
+
+// Implements the json.Unmarshaler interface for the ClientClass.
+func (c *ClientClass) UnmarshalJSON(data []byte) error {
+	type clientClass ClientClass
+	return unmarshalWithExtra(data, (*clientClass)(c), &c.Extra)
+}
+
+// Implements the json.Marshaler interface for the ClientClass.
+func (c ClientClass) MarshalJSON() ([]byte, error) {
+	type clientClass ClientClass
+	return marshalWithExtra(clientClass(c), c.Extra)
+}
+
+// Implements the json.Unmarshaler interface for the AddressPool.
+func (p *AddressPool) UnmarshalJSON(data []byte) error {
+	type addressPool AddressPool
+	return unmarshalWithExtra(data, (*addressPool)(p), &p.Extra)
+}
+
+// Implements the json.Marshaler interface for the AddressPool.
+func (p AddressPool) MarshalJSON() ([]byte, error) {
+	type addressPool AddressPool
+	return marshalWithExtra(addressPool(p), p.Extra)
+}
+
+// Implements the json.Unmarshaler interface for the PrefixPool.
+func (p *PrefixPool) UnmarshalJSON(data []byte) error {
+	type prefixPool PrefixPool
+	return unmarshalWithExtra(data, (*prefixPool)(p), &p.Extra)
+}
+
+// Implements the json.Marshaler interface for the PrefixPool.
+func (p PrefixPool) MarshalJSON() ([]byte, error) {
+	type prefixPool PrefixPool
+	return marshalWithExtra(prefixPool(p), p.Extra)
+}
+
+// Implements the json.Unmarshaler interface for the HostReservation.
+func (r *HostReservation) UnmarshalJSON(data []byte) error {
+	type hostReservation HostReservation
+	return unmarshalWithExtra(data, (*hostReservation)(r), &r.Extra)
+}
+
+// Implements the json.Marshaler interface for the HostReservation.
+func (r HostReservation) MarshalJSON() ([]byte, error) {
+	type hostReservation HostReservation
+	return marshalWithExtra(hostReservation(r), r.Extra)
+}
+
+// Implements the json.Unmarshaler interface for the SubnetConfig.
+func (s *SubnetConfig) UnmarshalJSON(data []byte) error {
+	type subnetConfig SubnetConfig
+	return unmarshalWithExtra(data, (*subnetConfig)(s), &s.Extra)
+}
+
+// Implements the json.Marshaler interface for the SubnetConfig.
+func (s SubnetConfig) MarshalJSON() ([]byte, error) {
+	type subnetConfig SubnetConfig
+	return marshalWithExtra(subnetConfig(s), s.Extra)
+}
+
+// Implements the json.Unmarshaler interface for the SharedNetworkConfig.
+func (n *SharedNetworkConfig) UnmarshalJSON(data []byte) error {
+	type sharedNetworkConfig SharedNetworkConfig
+	return unmarshalWithExtra(data, (*sharedNetworkConfig)(n), &n.Extra)
+}
+
+// Implements the json.Marshaler interface for the SharedNetworkConfig.
+func (n SharedNetworkConfig) MarshalJSON() ([]byte, error) {
+	type sharedNetworkConfig SharedNetworkConfig
+	return marshalWithExtra(sharedNetworkConfig(n), n.Extra)
+}
+
+// Implements the json.Unmarshaler interface for the InterfacesConfig.
+func (i *InterfacesConfig) UnmarshalJSON(data []byte) error {
+	type interfacesConfig InterfacesConfig
+	return unmarshalWithExtra(data, (*interfacesConfig)(i), &i.Extra)
+}
+
+// Implements the json.Marshaler interface for the InterfacesConfig.
+func (i InterfacesConfig) MarshalJSON() ([]byte, error) {
+	type interfacesConfig InterfacesConfig
+	return marshalWithExtra(interfacesConfig(i), i.Extra)
+}
+
+// Implements the json.Unmarshaler interface for the HooksLibrary.
+func (l *HooksLibrary) UnmarshalJSON(data []byte) error {
+	type hooksLibrary HooksLibrary
+	return unmarshalWithExtra(data, (*hooksLibrary)(l), &l.Extra)
+}
+
+// Implements the json.Marshaler interface for the HooksLibrary.
+func (l HooksLibrary) MarshalJSON() ([]byte, error) {
+	type hooksLibrary HooksLibrary
+	return marshalWithExtra(hooksLibrary(l), l.Extra)
+}
+
+// Implements the json.Unmarshaler interface for the DHCPConfig.
+func (c *DHCPConfig) UnmarshalJSON(data []byte) error {
+	type dhcpConfig DHCPConfig
+	return unmarshalWithExtra(data, (*dhcpConfig)(c), &c.Extra)
+}
+
+// Implements the json.Marshaler interface for the DHCPConfig.
+func (c DHCPConfig) MarshalJSON() ([]byte, error) {
+	type dhcpConfig DHCPConfig
+	return marshalWithExtra(dhcpConfig(c), c.Extra)
+}
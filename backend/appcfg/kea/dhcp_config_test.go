@@ -0,0 +1,281 @@
+package keaconfig
+
+import (
+	"encoding/json"
+	"testing"
+
+	require "github.com/stretchr/testify/require"
+)
+
+// Returns a DHCPv4 configuration comprising the parameters represented
+// in the typed structures and some parameters which are not.
+func getTestDHCPv4Config() string {
+	return `{
+        "Dhcp4": {
+            "valid-lifetime": 4000,
+            "renew-timer": 1000,
+            "rebind-timer": 2000,
+            "t1-percent": 0.5,
+            "calculate-tee-times": false,
+            "authoritative": false,
+            "ddns-send-updates": true,
+            "ddns-qualifying-suffix": "example.org",
+            "reservations-out-of-pool": true,
+            "server-tag": "server1",
+            "decline-probation-period": 86400,
+            "host-reservation-identifiers": [ "hw-address", "client-id" ],
+            "interfaces-config": {
+                "interfaces": [ "eth0" ],
+                "dhcp-socket-type": "raw",
+                "service-sockets-max-retries": 5
+            },
+            "lease-database": {
+                "type": "memfile",
+                "persist": true
+            },
+            "hooks-libraries": [
+                {
+                    "library": "/usr/lib/kea/libdhcp_lease_cmds.so",
+                    "parameters": { }
+                }
+            ],
+            "option-data": [
+                {
+                    "name": "domain-name-servers",
+                    "code": 6,
+                    "data": "192.0.2.1, 192.0.2.2",
+                    "always-send": true
+                }
+            ],
+            "client-classes": [
+                {
+                    "name": "foo",
+                    "test": "member('ALL')",
+                    "valid-lifetime": 100,
+                    "option-def": [ ]
+                }
+            ],
+            "reservations": [
+                {
+                    "hw-address": "01:02:03:04:05:06",
+                    "ip-address": "192.0.2.10",
+                    "client-classes": [ "foo" ],
+                    "user-context": { "comment": "global reservation" }
+                }
+            ],
+            "subnet4": [
+                {
+                    "id": 1,
+                    "subnet": "192.0.2.0/24",
+                    "relay": {
+                        "ip-addresses": [ "192.0.2.254" ]
+                    },
+                    "pools": [
+                        {
+                            "pool": "192.0.2.100-192.0.2.200",
+                            "client-class": "foo",
+                            "option-data": [ ]
+                        }
+                    ],
+                    "option-data": [
+                        {
+                            "code": 3,
+                            "data": "192.0.2.1",
+                            "csv-format": false
+                        }
+                    ],
+                    "4o6-subnet": "2001:db8:1::/64",
+                    "reservations": [ ]
+                }
+            ],
+            "shared-networks": [
+                {
+                    "name": "bar",
+                    "interface": "eth0",
+                    "match-client-id": false,
+                    "subnet4": [
+                        {
+                            "id": 2,
+                            "subnet": "192.0.3.0/24",
+                            "next-server": "192.0.3.1",
+                            "ddns-replace-client-name": "never"
+                        }
+                    ]
+                }
+            ],
+            "loggers": [
+                {
+                    "name": "kea-dhcp4",
+                    "severity": "DEBUG",
+                    "debuglevel": 99
+                }
+            ]
+        }
+    }`
+}
+
+// Test that the DHCPv4 configuration is decoded into the typed
+// structures.
+func TestDecodeDHCPConfig(t *testing.T) {
+	cfg, err := NewFromJSON(getTestDHCPv4Config())
+	require.NoError(t, err)
+
+	config, err := cfg.DecodeDHCPConfig()
+	require.NoError(t, err)
+	require.NotNil(t, config)
+
+	// Global parameters.
+	require.NotNil(t, config.ValidLifetime)
+	require.EqualValues(t, 4000, *config.ValidLifetime)
+	require.NotNil(t, config.RenewTimer)
+	require.EqualValues(t, 1000, *config.RenewTimer)
+	require.NotNil(t, config.T1Percent)
+	require.EqualValues(t, 0.5, *config.T1Percent)
+	require.NotNil(t, config.CalculateTeeTimes)
+	require.False(t, *config.CalculateTeeTimes)
+	require.Nil(t, config.PreferredLifetime)
+	require.NotNil(t, config.DDNSSendUpdates)
+	require.True(t, *config.DDNSSendUpdates)
+	require.NotNil(t, config.DDNSQualifyingSuffix)
+	require.Equal(t, "example.org", *config.DDNSQualifyingSuffix)
+	outOfPool, ok := config.IsOutOfPool()
+	require.True(t, ok)
+	require.True(t, outOfPool)
+	require.NotNil(t, config.ServerTag)
+	require.Equal(t, "server1", *config.ServerTag)
+	require.Equal(t, []string{"hw-address", "client-id"}, config.HostReservationIdentifier)
+	require.NotNil(t, config.InterfacesConfig)
+	require.Equal(t, []string{"eth0"}, config.InterfacesConfig.Interfaces)
+	require.EqualValues(t, 5, config.InterfacesConfig.Extra["service-sockets-max-retries"])
+	require.Contains(t, config.Extra, "lease-database")
+	require.Contains(t, config.Extra, "loggers")
+
+	// Hooks libraries.
+	require.Len(t, config.HooksLibraries, 1)
+	require.Equal(t, "/usr/lib/kea/libdhcp_lease_cmds.so", config.HooksLibraries[0].Library)
+
+	// Options.
+	require.Len(t, config.OptionData, 1)
+	require.EqualValues(t, 6, *config.OptionData[0].Code)
+	require.Equal(t, "domain-name-servers", *config.OptionData[0].Name)
+	require.True(t, *config.OptionData[0].AlwaysSend)
+	require.Nil(t, config.OptionData[0].Space)
+
+	// Client classes.
+	require.Len(t, config.ClientClasses, 1)
+	require.Equal(t, "foo", config.ClientClasses[0].Name)
+	require.Equal(t, "member('ALL')", *config.ClientClasses[0].Test)
+	require.EqualValues(t, 100, *config.ClientClasses[0].ValidLifetime)
+
+	// Global reservations.
+	require.Len(t, config.Reservations, 1)
+	require.Equal(t, "01:02:03:04:05:06", config.Reservations[0].HWAddress)
+	require.Equal(t, "192.0.2.10", config.Reservations[0].IPAddress)
+	require.Equal(t, []string{"foo"}, config.Reservations[0].ClientClasses)
+	require.Contains(t, config.Reservations[0].Extra, "user-context")
+
+	// Subnets.
+	require.Len(t, config.Subnet4, 1)
+	subnet := config.Subnet4[0]
+	require.EqualValues(t, 1, subnet.ID)
+	require.Equal(t, "192.0.2.0/24", subnet.Subnet)
+	require.NotNil(t, subnet.Relay)
+	require.Equal(t, []string{"192.0.2.254"}, subnet.Relay.IPAddresses)
+	require.Len(t, subnet.Pools, 1)
+	require.Equal(t, "192.0.2.100-192.0.2.200", subnet.Pools[0].Pool)
+	require.Equal(t, "foo", *subnet.Pools[0].ClientClass)
+	require.Len(t, subnet.OptionData, 1)
+	require.False(t, *subnet.OptionData[0].CSVFormat)
+	require.Equal(t, "2001:db8:1::/64", subnet.Extra["4o6-subnet"])
+	require.Empty(t, subnet.Reservations)
+	require.Empty(t, config.Subnet6)
+
+	// Shared networks.
+	require.Len(t, config.SharedNetworks, 1)
+	network := config.SharedNetworks[0]
+	require.Equal(t, "bar", network.Name)
+	require.Equal(t, "eth0", *network.Interface)
+	require.False(t, *network.MatchClientID)
+	require.Len(t, network.Subnet4, 1)
+	require.EqualValues(t, 2, network.Subnet4[0].ID)
+	require.Equal(t, "192.0.3.1", *network.Subnet4[0].NextServer)
+	require.Equal(t, "never", *network.Subnet4[0].DDNSReplaceClientName)
+}
+
+// Test that the configuration converted to the typed structures and
+// back is equal to the original configuration.
+func TestDHCPConfigRoundTrip(t *testing.T) {
+	cfg, err := NewFromJSON(getTestDHCPv4Config())
+	require.NoError(t, err)
+
+	config, err := cfg.DecodeDHCPConfig()
+	require.NoError(t, err)
+
+	converted, err := NewFromDHCPConfig(RootNameDHCPv4, config)
+	require.NoError(t, err)
+	require.Equal(t, cfg, converted)
+
+	// The same for the JSON serialization.
+	data, err := json.Marshal(config)
+	require.NoError(t, err)
+	var reparsed DHCPConfig
+	err = json.Unmarshal(data, &reparsed)
+	require.NoError(t, err)
+	converted, err = NewFromDHCPConfig(RootNameDHCPv4, &reparsed)
+	require.NoError(t, err)
+	require.Equal(t, cfg, converted)
+}
+
+// Test that the changes in the typed structures are reflected in the
+// converted configuration.
+func TestDHCPConfigModify(t *testing.T) {
+	cfg, err := NewFromJSON(getTestDHCPv4Config())
+	require.NoError(t, err)
+
+	config, err := cfg.DecodeDHCPConfig()
+	require.NoError(t, err)
+
+	validLifetime := int64(5000)
+	config.ValidLifetime = &validLifetime
+	config.RenewTimer = nil
+	config.Subnet4[0].Reservations = append(config.Subnet4[0].Reservations, HostReservation{
+		Reservation: Reservation{
+			HWAddress: "0a:0b:0c:0d:0e:0f",
+			IPAddress: "192.0.2.20",
+		},
+	})
+	config.Subnet4 = append(config.Subnet4, SubnetConfig{
+		ID:     3,
+		Subnet: "192.0.4.0/24",
+	})
+
+	converted, err := NewFromDHCPConfig(RootNameDHCPv4, config)
+	require.NoError(t, err)
+
+	diff := Diff(cfg, converted)
+	require.Len(t, diff.Globals, 2)
+	require.Equal(t, "renew-timer", diff.Globals[0].Name)
+	require.Equal(t, ChangeRemoved, diff.Globals[0].Kind)
+	require.Equal(t, "valid-lifetime", diff.Globals[1].Name)
+	require.Equal(t, ChangeModified, diff.Globals[1].Kind)
+	require.EqualValues(t, 5000, diff.Globals[1].To)
+	require.Equal(t, []string{"192.0.4.0/24"}, diff.SubnetsAdded)
+	require.Len(t, diff.SubnetsModified, 1)
+	require.Len(t, diff.SubnetsModified[0].Reservations, 1)
+	require.Equal(t, "hw-address=0a:0b:0c:0d:0e:0f", diff.SubnetsModified[0].Reservations[0].Key)
+	require.Equal(t, ChangeAdded, diff.SubnetsModified[0].Reservations[0].Kind)
+}
+
+// Test that decoding the configuration fails for the non-DHCP servers.
+func TestDecodeDHCPConfigInvalidRoot(t *testing.T) {
+	cfg, err := NewFromJSON(`{
+        "Control-agent": { }
+    }`)
+	require.NoError(t, err)
+
+	_, err = cfg.DecodeDHCPConfig()
+	require.Error(t, err)
+
+	_, err = NewFromDHCPConfig("Control-agent", &DHCPConfig{})
+	require.Error(t, err)
+}
@@ -0,0 +1,570 @@
+package keaconfig
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/pkg/errors"
+	storkutil "isc.org/stork/util"
+)
+
+// Configuration level at which an option or option definition is specified.
+type OptionScope string
+
+// Supported option scopes.
+const (
+	OptionScopeGlobal        OptionScope = "global"
+	OptionScopeClientClass   OptionScope = "client-class"
+	OptionScopeSharedNetwork OptionScope = "shared-network"
+	OptionScopeSubnet        OptionScope = "subnet"
+	OptionScopePool          OptionScope = "pool"
+	OptionScopeReservation   OptionScope = "reservation"
+)
+
+// Option scopes ordered from the highest to the lowest precedence. When
+// an option is specified at multiple levels, the server sends the option
+// specified at the level with the highest precedence.
+var optionScopePrecedence = []OptionScope{ // nolint:gochecknoglobals
+	OptionScopeReservation,
+	OptionScopePool,
+	OptionScopeSubnet,
+	OptionScopeSharedNetwork,
+	OptionScopeClientClass,
+	OptionScopeGlobal,
+}
+
+// Codes of the standard DHCPv4 options defined in Kea. They are used to
+// match the options specified by name with the options specified by code.
+// The options missing in this table (e.g., defined in the newer Kea
+// versions) are only matched when specified the same way.
+var standardDHCPv4OptionCodes = map[string]int64{ // nolint:gochecknoglobals
+	"subnet-mask":                            1,
+	"time-offset":                            2,
+	"routers":                                3,
+	"time-servers":                           4,
+	"name-servers":                           5,
+	"domain-name-servers":                    6,
+	"log-servers":                            7,
+	"cookie-servers":                         8,
+	"lpr-servers":                            9,
+	"impress-servers":                        10,
+	"resource-location-servers":              11,
+	"host-name":                              12,
+	"boot-size":                              13,
+	"merit-dump":                             14,
+	"domain-name":                            15,
+	"swap-server":                            16,
+	"root-path":                              17,
+	"extensions-path":                        18,
+	"ip-forwarding":                          19,
+	"non-local-source-routing":               20,
+	"policy-filter":                          21,
+	"max-dgram-reassembly":                   22,
+	"default-ip-ttl":                         23,
+	"path-mtu-aging-timeout":                 24,
+	"path-mtu-plateau-table":                 25,
+	"interface-mtu":                          26,
+	"all-subnets-local":                      27,
+	"broadcast-address":                      28,
+	"perform-mask-discovery":                 29,
+	"mask-supplier":                          30,
+	"router-discovery":                       31,
+	"router-solicitation-address":            32,
+	"static-routes":                          33,
+	"trailer-encapsulation":                  34,
+	"arp-cache-timeout":                      35,
+	"ieee802-3-encapsulation":                36,
+	"default-tcp-ttl":                        37,
+	"tcp-keepalive-interval":                 38,
+	"tcp-keepalive-garbage":                  39,
+	"nis-domain":                             40,
+	"nis-servers":                            41,
+	"ntp-servers":                            42,
+	"vendor-encapsulated-options":            43,
+	"netbios-name-servers":                   44,
+	"netbios-dd-server":                      45,
+	"netbios-node-type":                      46,
+	"netbios-scope":                          47,
+	"font-servers":                           48,
+	"x-display-manager":                      49,
+	"dhcp-requested-address":                 50,
+	"dhcp-lease-time":                        51,
+	"dhcp-option-overload":                   52,
+	"dhcp-message-type":                      53,
+	"dhcp-server-identifier":                 54,
+	"dhcp-parameter-request-list":            55,
+	"dhcp-message":                           56,
+	"dhcp-max-message-size":                  57,
+	"dhcp-renewal-time":                      58,
+	"dhcp-rebinding-time":                    59,
+	"vendor-class-identifier":                60,
+	"dhcp-client-identifier":                 61,
+	"nwip-domain-name":                       62,
+	"nwip-suboptions":                        63,
+	"nisplus-domain-name":                    64,
+	"nisplus-servers":                        65,
+	"tftp-server-name":                       66,
+	"boot-file-name":                         67,
+	"mobile-ip-home-agent":                   68,
+	"smtp-server":                            69,
+	"pop-server":                             70,
+	"nntp-server":                            71,
+	"www-server":                             72,
+	"finger-server":                          73,
+	"irc-server":                             74,
+	"streettalk-server":                      75,
+	"streettalk-directory-assistance-server": 76,
+	"user-class":                             77,
+	"slp-directory-agent":                    78,
+	"slp-service-scope":                      79,
+	"fqdn":                                   81,
+	"dhcp-agent-options":                     82,
+	"nds-servers":                            85,
+	"nds-tree-name":                          86,
+	"nds-context":                            87,
+	"bcms-controller-names":                  88,
+	"bcms-controller-address":                89,
+	"authenticate":                           90,
+	"client-last-transaction-time":           91,
+	"associated-ip":                          92,
+	"client-system":                          93,
+	"client-ndi":                             94,
+	"uuid-guid":                              97,
+	"uap-servers":                            98,
+	"geoconf-civic":                          99,
+	"pcode":                                  100,
+	"tcode":                                  101,
+	"v6-only-preferred":                      108,
+	"netinfo-server-address":                 112,
+	"netinfo-server-tag":                     113,
+	"v4-captive-portal":                      114,
+	"auto-config":                            116,
+	"name-service-search":                    117,
+	"subnet-selection":                       118,
+	"domain-search":                          119,
+	"classless-static-route":                 121,
+	"vivco-suboptions":                       124,
+	"vivso-suboptions":                       125,
+	"pana-agent":                             136,
+	"v4-lost":                                137,
+	"capwap-ac-v4":                           138,
+	"sip-ua-cs-domains":                      141,
+	"rdnss-selection":                        146,
+	"v4-portparams":                          159,
+	"option-6rd":                             212,
+	"v4-access-domain":                       213,
+}
+
+// Codes of the standard DHCPv6 options defined in Kea.
+var standardDHCPv6OptionCodes = map[string]int64{ // nolint:gochecknoglobals
+	"clientid":                 1,
+	"serverid":                 2,
+	"ia-na":                    3,
+	"ia-ta":                    4,
+	"iaaddr":                   5,
+	"oro":                      6,
+	"preference":               7,
+	"elapsed-time":             8,
+	"relay-msg":                9,
+	"auth":                     11,
+	"unicast":                  12,
+	"status-code":              13,
+	"rapid-commit":             14,
+	"user-class":               15,
+	"vendor-class":             16,
+	"vendor-opts":              17,
+	"interface-id":             18,
+	"reconf-msg":               19,
+	"reconf-accept":            20,
+	"sip-server-dns":           21,
+	"sip-server-addr":          22,
+	"dns-servers":              23,
+	"domain-search":            24,
+	"ia-pd":                    25,
+	"iaprefix":                 26,
+	"nis-servers":              27,
+	"nisp-servers":             28,
+	"nis-domain-name":          29,
+	"nisp-domain-name":         30,
+	"sntp-servers":             31,
+	"information-refresh-time": 32,
+	"bcmcs-server-dns":         33,
+	"bcmcs-server-addr":        34,
+	"geoconf-civic":            36,
+	"remote-id":                37,
+	"subscriber-id":            38,
+	"client-fqdn":              39,
+	"pana-agent":               40,
+	"new-posix-timezone":       41,
+	"new-tzdb-timezone":        42,
+	"ero":                      43,
+	"lq-query":                 44,
+	"client-data":              45,
+	"clt-time":                 46,
+	"lq-relay-data":            47,
+	"lq-client-link":           48,
+	"v6-lost":                  51,
+	"capwap-ac-v6":             52,
+	"relay-id":                 53,
+	"ntp-server":               56,
+	"v6-access-domain":         57,
+	"bootfile-url":             59,
+	"bootfile-param":           60,
+	"client-arch-type":         61,
+	"nii":                      62,
+	"aftr-name":                64,
+	"erp-local-domain-name":    65,
+	"rsoo":                     66,
+	"pd-exclude":               67,
+	"rdnss-selection":          74,
+	"client-linklayer-addr":    79,
+	"link-address":             80,
+	"solmax-rt":                82,
+	"inf-max-rt":               83,
+	"s46-rule":                 89,
+	"s46-br":                   90,
+	"s46-dmr":                  91,
+	"s46-v4v6bind":             92,
+	"s46-portparams":           93,
+	"s46-cont-mape":            94,
+	"s46-cont-mapt":            95,
+	"s46-cont-lw":              96,
+	"v6-captive-portal":        103,
+	"ipv6-address-andsf":       143,
+}
+
+// An option specified in the configuration along with the information
+// where it is specified. The SharedNetwork, Subnet, SubnetID, Pool
+// and HostIdentifier are set for the options specified at the respective
+// levels and the levels below them. The ClientClass is set for the
+// options specified in the client classes.
+type ScopedOptionData struct {
+	Option         OptionData
+	Scope          OptionScope
+	ClientClass    string
+	SharedNetwork  string
+	Subnet         string
+	SubnetID       int64
+	Pool           string
+	HostIdentifier string
+}
+
+// An option definition specified in the configuration along with the
+// information where it is specified.
+type ScopedOptionDef struct {
+	Definition  OptionDef
+	Scope       OptionScope
+	ClientClass string
+}
+
+// Specifies the client for which the effective options are resolved.
+// The subnet is identified by the prefix or, if the prefix is empty,
+// by the subnet ID. The address is an address or delegated prefix
+// assigned to the client. It is used to find the pool from which it
+// is assigned. The host identifier is used to find the host reservation
+// for the client. It has the form of the identifier type and value,
+// e.g. hw-address=01:02:03:04:05:06. The client classes are the classes
+// the client belongs to in the order of the assignment.
+type EffectiveOptionsQuery struct {
+	Subnet         string
+	SubnetID       int64
+	Address        string
+	HostIdentifier string
+	ClientClasses  []string
+}
+
+// Returns all options specified in the configuration with the information
+// at which levels they are specified.
+func (c *DHCPConfig) GetAllOptionData() (options []ScopedOptionData) {
+	appendOptions := func(scope ScopedOptionData, optionData []OptionData) {
+		for _, option := range optionData {
+			scoped := scope
+			scoped.Option = option
+			options = append(options, scoped)
+		}
+	}
+	appendSubnets := func(sharedNetwork string, subnets []SubnetConfig) {
+		for _, subnet := range subnets {
+			scope := ScopedOptionData{
+				Scope:         OptionScopeSubnet,
+				SharedNetwork: sharedNetwork,
+				Subnet:        subnet.Subnet,
+				SubnetID:      subnet.ID,
+			}
+			appendOptions(scope, subnet.OptionData)
+			for _, pool := range subnet.Pools {
+				poolScope := scope
+				poolScope.Scope = OptionScopePool
+				poolScope.Pool = pool.Pool
+				appendOptions(poolScope, pool.OptionData)
+			}
+			for _, pool := range subnet.PdPools {
+				poolScope := scope
+				poolScope.Scope = OptionScopePool
+				poolScope.Pool = fmt.Sprintf("%s/%d", pool.Prefix, pool.PrefixLen)
+				appendOptions(poolScope, pool.OptionData)
+			}
+			for _, reservation := range subnet.Reservations {
+				reservationScope := scope
+				reservationScope.Scope = OptionScopeReservation
+				reservationScope.HostIdentifier = reservation.GetHostIdentifier()
+				appendOptions(reservationScope, reservation.OptionData)
+			}
+		}
+	}
+
+	appendOptions(ScopedOptionData{Scope: OptionScopeGlobal}, c.OptionData)
+	for _, class := range c.ClientClasses {
+		appendOptions(ScopedOptionData{Scope: OptionScopeClientClass, ClientClass: class.Name}, class.OptionData)
+	}
+	for _, reservation := range c.Reservations {
+		appendOptions(ScopedOptionData{
+			Scope:          OptionScopeReservation,
+			HostIdentifier: reservation.GetHostIdentifier(),
+		}, reservation.OptionData)
+	}
+	for _, network := range c.SharedNetworks {
+		appendOptions(ScopedOptionData{Scope: OptionScopeSharedNetwork, SharedNetwork: network.Name}, network.OptionData)
+		appendSubnets(network.Name, network.Subnet4)
+		appendSubnets(network.Name, network.Subnet6)
+	}
+	appendSubnets("", c.Subnet4)
+	appendSubnets("", c.Subnet6)
+	return options
+}
+
+// Returns all option definitions specified globally and in the client
+// classes.
+func (c *DHCPConfig) GetAllOptionDefs() (defs []ScopedOptionDef) {
+	for _, def := range c.OptionDef {
+		defs = append(defs, ScopedOptionDef{Definition: def, Scope: OptionScopeGlobal})
+	}
+	for _, class := range c.ClientClasses {
+		for _, def := range class.OptionDef {
+			defs = append(defs, ScopedOptionDef{Definition: def, Scope: OptionScopeClientClass, ClientClass: class.Name})
+		}
+	}
+	return defs
+}
+
+// Returns the host identifier of the reservation in the form of the
+// identifier type and value, e.g. hw-address=01:02:03:04:05:06. The
+// identifier value is converted to lower case. An empty string is
+// returned when the reservation has no identifier.
+func (r HostReservation) GetHostIdentifier() string {
+	identifiers := []struct {
+		identifierType string
+		value          string
+	}{
+		{"hw-address", r.HWAddress},
+		{"duid", r.DUID},
+		{"circuit-id", r.CircuitID},
+		{"client-id", r.ClientID},
+		{"flex-id", r.FlexID},
+	}
+	for _, identifier := range identifiers {
+		if identifier.value != "" {
+			return fmt.Sprintf("%s=%s", identifier.identifierType, strings.ToLower(identifier.value))
+		}
+	}
+	return ""
+}
+
+// Returns the option space and code. If the option space is not
+// specified, the default space is returned. If the option code is not
+// specified, it is found by name among the option definitions and the
+// standard options. The returned code is nil if it is not found.
+func getOptionSpaceAndCode(option OptionData, defs []ScopedOptionDef, standardCodes map[string]int64, defaultSpace string) (string, *int64) {
+	space := defaultSpace
+	if option.Space != nil && *option.Space != "" {
+		space = *option.Space
+	}
+	if option.Code != nil || option.Name == nil {
+		return space, option.Code
+	}
+	for _, def := range defs {
+		defSpace := defaultSpace
+		if def.Definition.Space != nil && *def.Definition.Space != "" {
+			defSpace = *def.Definition.Space
+		}
+		if def.Definition.Name == *option.Name && defSpace == space {
+			code := def.Definition.Code
+			return space, &code
+		}
+	}
+	if code, ok := standardCodes[*option.Name]; ok && space == defaultSpace {
+		return space, &code
+	}
+	return space, nil
+}
+
+// Returns the key identifying the option, i.e., the option space and
+// code. If the option code is not specified and cannot be found by name,
+// the option name is used instead of the code.
+func getOptionKey(option OptionData, defs []ScopedOptionDef, standardCodes map[string]int64, defaultSpace string) string {
+	space, code := getOptionSpaceAndCode(option, defs, standardCodes, defaultSpace)
+	switch {
+	case code != nil:
+		return fmt.Sprintf("%s/%d", space, *code)
+	case option.Name != nil:
+		return fmt.Sprintf("%s/%s", space, *option.Name)
+	default:
+		return space + "/"
+	}
+}
+
+// Returns the option space and code for the option specified in the
+// configuration of the server with the specified root name, i.e. Dhcp4
+// or Dhcp6. If the option space is not specified, the top level option
+// space of the server is returned. If the option code is not specified,
+// it is found by name among the option definitions and the standard
+// options. The returned code is nil if it cannot be found.
+func (c *DHCPConfig) GetOptionSpaceAndCode(rootName string, option OptionData) (string, *int64) {
+	if rootName == RootNameDHCPv6 {
+		return getOptionSpaceAndCode(option, c.GetAllOptionDefs(), standardDHCPv6OptionCodes, "dhcp6")
+	}
+	return getOptionSpaceAndCode(option, c.GetAllOptionDefs(), standardDHCPv4OptionCodes, "dhcp4")
+}
+
+// Checks if the address or delegated prefix belongs to the pool. The
+// pool is an address range, an address prefix or a delegated prefix
+// pool in the prefix/length form.
+func isInPool(address *storkutil.ParsedIP, pool string, pdPools []PrefixPool) bool {
+	if address.Prefix {
+		for _, pdPool := range pdPools {
+			if fmt.Sprintf("%s/%d", pdPool.Prefix, pdPool.PrefixLen) == pool {
+				return address.IsInPrefixRange(pdPool.Prefix, pdPool.PrefixLen, pdPool.DelegatedLen)
+			}
+		}
+		return false
+	}
+	lb, ub, err := storkutil.ParseIPRange(pool)
+	if err != nil {
+		return false
+	}
+	return address.IsInRange(lb, ub)
+}
+
+// Finds the subnet specified in the query. It returns the subnet and the
+// name of the shared network it belongs to.
+func (c *DHCPConfig) findSubnet(query EffectiveOptionsQuery) (*SubnetConfig, string) {
+	match := func(subnet *SubnetConfig) bool {
+		if query.Subnet != "" {
+			return normalizePrefix(subnet.Subnet) == normalizePrefix(query.Subnet)
+		}
+		return query.SubnetID != 0 && subnet.ID == query.SubnetID
+	}
+	for i := range c.SharedNetworks {
+		for _, subnets := range [][]SubnetConfig{c.SharedNetworks[i].Subnet4, c.SharedNetworks[i].Subnet6} {
+			for j := range subnets {
+				if match(&subnets[j]) {
+					return &subnets[j], c.SharedNetworks[i].Name
+				}
+			}
+		}
+	}
+	for _, subnets := range [][]SubnetConfig{c.Subnet4, c.Subnet6} {
+		for j := range subnets {
+			if match(&subnets[j]) {
+				return &subnets[j], ""
+			}
+		}
+	}
+	return nil, ""
+}
+
+// Computes the options that the server would send to a client in the
+// specified subnet, belonging to the specified client classes, having
+// the specified address and host identifier. When the option is specified
+// at multiple levels, the option with the highest precedence is returned,
+// i.e., the option from the host reservation, pool, subnet, shared
+// network, client class and finally global option. For the options
+// specified in multiple client classes, the option from the first
+// class the client belongs to is returned. The returned options hold
+// the information where they are specified. The options are ordered
+// by precedence and order in the configuration. It returns an error
+// when the subnet is not found.
+func (c *DHCPConfig) ResolveEffectiveOptions(query EffectiveOptionsQuery) ([]ScopedOptionData, error) {
+	subnet, sharedNetwork := c.findSubnet(query)
+	if subnet == nil {
+		if query.Subnet != "" {
+			return nil, errors.Errorf("subnet %s not found in the configuration", query.Subnet)
+		}
+		return nil, errors.Errorf("subnet with ID %d not found in the configuration", query.SubnetID)
+	}
+
+	defaultSpace := "dhcp4"
+	standardCodes := standardDHCPv4OptionCodes
+	if ip, _, err := net.ParseCIDR(subnet.Subnet); err == nil && ip.To4() == nil {
+		defaultSpace = "dhcp6"
+		standardCodes = standardDHCPv6OptionCodes
+	}
+
+	var address *storkutil.ParsedIP
+	if query.Address != "" {
+		address = storkutil.ParseIP(query.Address)
+		if address == nil {
+			return nil, errors.Errorf("invalid address %s", query.Address)
+		}
+	}
+	hostIdentifier := strings.ToLower(query.HostIdentifier)
+
+	// Group the options applicable to the client by scope.
+	applicable := make(map[OptionScope][]ScopedOptionData)
+	for _, option := range c.GetAllOptionData() {
+		switch option.Scope {
+		case OptionScopeGlobal:
+		case OptionScopeClientClass:
+			continue
+		case OptionScopeSharedNetwork:
+			if sharedNetwork == "" || option.SharedNetwork != sharedNetwork {
+				continue
+			}
+		case OptionScopeSubnet:
+			if option.Subnet != subnet.Subnet {
+				continue
+			}
+		case OptionScopePool:
+			if option.Subnet != subnet.Subnet || address == nil || !isInPool(address, option.Pool, subnet.PdPools) {
+				continue
+			}
+		case OptionScopeReservation:
+			if hostIdentifier == "" || option.HostIdentifier != hostIdentifier ||
+				(option.Subnet != "" && option.Subnet != subnet.Subnet) {
+				continue
+			}
+		}
+		applicable[option.Scope] = append(applicable[option.Scope], option)
+	}
+	for _, className := range query.ClientClasses {
+		for _, class := range c.ClientClasses {
+			if class.Name != className {
+				continue
+			}
+			for _, option := range class.OptionData {
+				applicable[OptionScopeClientClass] = append(applicable[OptionScopeClientClass], ScopedOptionData{
+					Option:      option,
+					Scope:       OptionScopeClientClass,
+					ClientClass: class.Name,
+				})
+			}
+		}
+	}
+
+	// Select the options with the highest precedence.
+	defs := c.GetAllOptionDefs()
+	selected := make(map[string]bool)
+	var effective []ScopedOptionData
+	for _, scope := range optionScopePrecedence {
+		for _, option := range applicable[scope] {
+			key := getOptionKey(option.Option, defs, standardCodes, defaultSpace)
+			if selected[key] {
+				continue
+			}
+			selected[key] = true
+			effective = append(effective, option)
+		}
+	}
+	return effective, nil
+}
@@ -0,0 +1,338 @@
+package keaconfig
+
+import (
+	"testing"
+
+	require "github.com/stretchr/testify/require"
+)
+
+// Returns a DHCPv4 configuration with the options specified at various
+// levels used to test the effective options resolution.
+func getTestDHCPv4ConfigWithOptions(t *testing.T) *DHCPConfig {
+	cfg, err := NewFromJSON(`{
+        "Dhcp4": {
+            "option-def": [
+                {
+                    "name": "foo-option",
+                    "code": 222,
+                    "type": "string"
+                }
+            ],
+            "option-data": [
+                {
+                    "name": "domain-name-servers",
+                    "data": "192.0.2.1"
+                },
+                {
+                    "code": 15,
+                    "data": "example.org"
+                },
+                {
+                    "name": "foo-option",
+                    "data": "global"
+                },
+                {
+                    "code": 42,
+                    "data": "192.0.2.123"
+                }
+            ],
+            "client-classes": [
+                {
+                    "name": "class1",
+                    "option-data": [
+                        {
+                            "code": 42,
+                            "data": "192.0.2.124"
+                        },
+                        {
+                            "code": 66,
+                            "data": "tftp1.example.org"
+                        }
+                    ]
+                },
+                {
+                    "name": "class2",
+                    "option-def": [
+                        {
+                            "name": "bar-option",
+                            "code": 223,
+                            "type": "uint8"
+                        }
+                    ],
+                    "option-data": [
+                        {
+                            "code": 66,
+                            "data": "tftp2.example.org"
+                        }
+                    ]
+                }
+            ],
+            "reservations": [
+                {
+                    "hw-address": "01:02:03:04:05:06",
+                    "option-data": [
+                        {
+                            "code": 6,
+                            "data": "192.0.2.3"
+                        }
+                    ]
+                }
+            ],
+            "shared-networks": [
+                {
+                    "name": "net1",
+                    "option-data": [
+                        {
+                            "code": 15,
+                            "data": "net1.example.org"
+                        }
+                    ],
+                    "subnet4": [
+                        {
+                            "id": 1,
+                            "subnet": "192.0.2.0/24",
+                            "option-data": [
+                                {
+                                    "code": 222,
+                                    "data": "subnet"
+                                }
+                            ],
+                            "pools": [
+                                {
+                                    "pool": "192.0.2.10-192.0.2.20",
+                                    "option-data": [
+                                        {
+                                            "code": 3,
+                                            "data": "192.0.2.254"
+                                        }
+                                    ]
+                                }
+                            ],
+                            "reservations": [
+                                {
+                                    "hw-address": "0A:0B:0C:0D:0E:0F",
+                                    "ip-address": "192.0.2.100",
+                                    "option-data": [
+                                        {
+                                            "name": "domain-name",
+                                            "data": "host.example.org"
+                                        }
+                                    ]
+                                }
+                            ]
+                        }
+                    ]
+                }
+            ],
+            "subnet4": [
+                {
+                    "id": 2,
+                    "subnet": "192.0.3.0/24"
+                }
+            ]
+        }
+    }`)
+	require.NoError(t, err)
+	config, err := cfg.DecodeDHCPConfig()
+	require.NoError(t, err)
+	return config
+}
+
+// Test that all options are returned with the levels they are specified at.
+func TestGetAllOptionData(t *testing.T) {
+	config := getTestDHCPv4ConfigWithOptions(t)
+
+	options := config.GetAllOptionData()
+	require.Len(t, options, 12)
+
+	require.Equal(t, OptionScopeGlobal, options[0].Scope)
+	require.Equal(t, "domain-name-servers", *options[0].Option.Name)
+
+	require.Equal(t, OptionScopeClientClass, options[4].Scope)
+	require.Equal(t, "class1", options[4].ClientClass)
+
+	require.Equal(t, OptionScopeReservation, options[7].Scope)
+	require.Equal(t, "hw-address=01:02:03:04:05:06", options[7].HostIdentifier)
+	require.Empty(t, options[7].Subnet)
+
+	require.Equal(t, OptionScopeSharedNetwork, options[8].Scope)
+	require.Equal(t, "net1", options[8].SharedNetwork)
+
+	require.Equal(t, OptionScopeSubnet, options[9].Scope)
+	require.Equal(t, "net1", options[9].SharedNetwork)
+	require.Equal(t, "192.0.2.0/24", options[9].Subnet)
+	require.EqualValues(t, 1, options[9].SubnetID)
+
+	require.Equal(t, OptionScopePool, options[10].Scope)
+	require.Equal(t, "192.0.2.10-192.0.2.20", options[10].Pool)
+
+	require.Equal(t, OptionScopeReservation, options[11].Scope)
+	require.Equal(t, "hw-address=0a:0b:0c:0d:0e:0f", options[11].HostIdentifier)
+	require.Equal(t, "192.0.2.0/24", options[11].Subnet)
+}
+
+// Test that the option definitions are returned with the levels they
+// are specified at.
+func TestGetAllOptionDefs(t *testing.T) {
+	config := getTestDHCPv4ConfigWithOptions(t)
+
+	defs := config.GetAllOptionDefs()
+	require.Len(t, defs, 2)
+	require.Equal(t, OptionScopeGlobal, defs[0].Scope)
+	require.Equal(t, "foo-option", defs[0].Definition.Name)
+	require.EqualValues(t, 222, defs[0].Definition.Code)
+	require.Equal(t, OptionScopeClientClass, defs[1].Scope)
+	require.Equal(t, "class2", defs[1].ClientClass)
+	require.Equal(t, "uint8", defs[1].Definition.Type)
+}
+
+// Returns the effective option with the specified code or nil.
+func findEffectiveOption(options []ScopedOptionData, code int64, name string) *ScopedOptionData {
+	for i := range options {
+		if (options[i].Option.Code != nil && *options[i].Option.Code == code) ||
+			(options[i].Option.Name != nil && *options[i].Option.Name == name) {
+			return &options[i]
+		}
+	}
+	return nil
+}
+
+// Test that the options are overridden according to the precedence of
+// the levels they are specified at.
+func TestResolveEffectiveOptions(t *testing.T) {
+	config := getTestDHCPv4ConfigWithOptions(t)
+
+	// Client in the subnet without pool, classes and reservation.
+	options, err := config.ResolveEffectiveOptions(EffectiveOptionsQuery{
+		Subnet: "192.0.2.0/24",
+	})
+	require.NoError(t, err)
+	require.Len(t, options, 4)
+
+	// The subnet level option specified by code overrides the global
+	// option specified by name according to the option definition.
+	option := findEffectiveOption(options, 222, "foo-option")
+	require.NotNil(t, option)
+	require.Equal(t, OptionScopeSubnet, option.Scope)
+	require.Equal(t, "subnet", *option.Option.Data)
+
+	option = findEffectiveOption(options, 15, "domain-name")
+	require.NotNil(t, option)
+	require.Equal(t, OptionScopeSharedNetwork, option.Scope)
+
+	option = findEffectiveOption(options, 6, "domain-name-servers")
+	require.NotNil(t, option)
+	require.Equal(t, OptionScopeGlobal, option.Scope)
+
+	option = findEffectiveOption(options, 42, "ntp-servers")
+	require.NotNil(t, option)
+	require.Equal(t, OptionScopeGlobal, option.Scope)
+
+	// Client with an address from the pool, in the classes and with
+	// the reservations.
+	options, err = config.ResolveEffectiveOptions(EffectiveOptionsQuery{
+		SubnetID:       1,
+		Address:        "192.0.2.15",
+		HostIdentifier: "hw-address=0a:0b:0c:0d:0e:0f",
+		ClientClasses:  []string{"class2", "class1"},
+	})
+	require.NoError(t, err)
+	require.Len(t, options, 6)
+
+	// The options are ordered by precedence.
+	require.Equal(t, OptionScopeReservation, options[0].Scope)
+	require.Equal(t, "host.example.org", *options[0].Option.Data)
+	require.Equal(t, OptionScopePool, options[1].Scope)
+	require.EqualValues(t, 3, *options[1].Option.Code)
+
+	// The option from the first class the client belongs to is returned.
+	option = findEffectiveOption(options, 66, "tftp-server-name")
+	require.NotNil(t, option)
+	require.Equal(t, "class2", option.ClientClass)
+	require.Equal(t, "tftp2.example.org", *option.Option.Data)
+
+	option = findEffectiveOption(options, 42, "ntp-servers")
+	require.NotNil(t, option)
+	require.Equal(t, "class1", option.ClientClass)
+
+	// Global reservation.
+	options, err = config.ResolveEffectiveOptions(EffectiveOptionsQuery{
+		Subnet:         "192.0.3.0/24",
+		Address:        "192.0.3.15",
+		HostIdentifier: "hw-address=01:02:03:04:05:06",
+	})
+	require.NoError(t, err)
+	require.Len(t, options, 4)
+	option = findEffectiveOption(options, 6, "domain-name-servers")
+	require.NotNil(t, option)
+	require.Equal(t, OptionScopeReservation, option.Scope)
+	require.Equal(t, "192.0.2.3", *option.Option.Data)
+	option = findEffectiveOption(options, 15, "domain-name")
+	require.NotNil(t, option)
+	require.Equal(t, OptionScopeGlobal, option.Scope)
+}
+
+// Test that an error is returned when the subnet or address is invalid.
+func TestResolveEffectiveOptionsErrors(t *testing.T) {
+	config := getTestDHCPv4ConfigWithOptions(t)
+
+	_, err := config.ResolveEffectiveOptions(EffectiveOptionsQuery{
+		Subnet: "10.0.0.0/8",
+	})
+	require.Error(t, err)
+
+	_, err = config.ResolveEffectiveOptions(EffectiveOptionsQuery{
+		SubnetID: 5,
+	})
+	require.Error(t, err)
+
+	_, err = config.ResolveEffectiveOptions(EffectiveOptionsQuery{
+		SubnetID: 1,
+		Address:  "foo",
+	})
+	require.Error(t, err)
+}
+
+// Test that the option code is found by name when it is not specified.
+func TestGetOptionSpaceAndCode(t *testing.T) {
+	config := getTestDHCPv4ConfigWithOptions(t)
+
+	name := "foo-option"
+	space, code := config.GetOptionSpaceAndCode(RootNameDHCPv4, OptionData{Name: &name})
+	require.Equal(t, "dhcp4", space)
+	require.NotNil(t, code)
+	require.EqualValues(t, 222, *code)
+
+	name = "domain-name-servers"
+	space, code = config.GetOptionSpaceAndCode(RootNameDHCPv4, OptionData{Name: &name})
+	require.Equal(t, "dhcp4", space)
+	require.NotNil(t, code)
+	require.EqualValues(t, 6, *code)
+
+	name = "dns-servers"
+	space, code = config.GetOptionSpaceAndCode(RootNameDHCPv6, OptionData{Name: &name})
+	require.Equal(t, "dhcp6", space)
+	require.NotNil(t, code)
+	require.EqualValues(t, 23, *code)
+
+	// Less common standard options.
+	name = "dhcp-lease-time"
+	_, code = config.GetOptionSpaceAndCode(RootNameDHCPv4, OptionData{Name: &name})
+	require.NotNil(t, code)
+	require.EqualValues(t, 51, *code)
+
+	name = "v4-captive-portal"
+	_, code = config.GetOptionSpaceAndCode(RootNameDHCPv4, OptionData{Name: &name})
+	require.NotNil(t, code)
+	require.EqualValues(t, 114, *code)
+
+	name = "s46-cont-mape"
+	_, code = config.GetOptionSpaceAndCode(RootNameDHCPv6, OptionData{Name: &name})
+	require.NotNil(t, code)
+	require.EqualValues(t, 94, *code)
+
+	customSpace := "custom"
+	space, code = config.GetOptionSpaceAndCode(RootNameDHCPv4, OptionData{Name: &name, Space: &customSpace})
+	require.Equal(t, "custom", space)
+	require.Nil(t, code)
+}
@@ -21,8 +21,10 @@ type Map map[string]interface{}
 
 // Structure representing a configuration of the single hooks library.
 type HooksLibrary struct {
-	Library    string
-	Parameters map[string]interface{}
+	Library    string                 `json:"library"`
+	Parameters map[string]interface{} `json:"parameters,omitempty"`
+
+	Extra map[string]interface{} `json:"-" mapstructure:"-"`
 }
 
 // Structure representing output_options for a logger.
@@ -105,10 +107,10 @@ type Databases struct {
 // decoded modes. The Deprecated field holds the value of the
 // reservation-mode setting that was deprecated since Kea 1.9.x.
 type ReservationModes struct {
-	OutOfPool  *bool   `mapstructure:"reservations-out-of-pool,omitempty"`
-	InSubnet   *bool   `mapstructure:"reservations-in-subnet,omitempty"`
-	Global     *bool   `mapstructure:"reservations-global,omitempty"`
-	Deprecated *string `mapstructure:"reservation-mode,omitempty"`
+	OutOfPool  *bool   `mapstructure:"reservations-out-of-pool,omitempty" json:"reservations-out-of-pool,omitempty"`
+	InSubnet   *bool   `mapstructure:"reservations-in-subnet,omitempty" json:"reservations-in-subnet,omitempty"`
+	Global     *bool   `mapstructure:"reservations-global,omitempty" json:"reservations-global,omitempty"`
+	Deprecated *string `mapstructure:"reservation-mode,omitempty" json:"reservation-mode,omitempty"`
 }
 
 // Creates new instance from the pointer to the map of interfaces.
@@ -287,6 +289,20 @@ func (c *Map) GetControlSockets() (parsedSockets ControlSockets) {
 	return parsedSockets
 }
 
+// Parses the control socket of the Kea DHCP or DDNS daemon. It returns
+// nil if the control socket is not configured.
+func (c *Map) GetControlSocket() *ControlSocket {
+	socketMap, ok := c.GetTopLevelMap("control-socket")
+	if !ok {
+		return nil
+	}
+	socket := &ControlSocket{}
+	if err := mapstructure.Decode(socketMap, socket); err != nil {
+		return nil
+	}
+	return socket
+}
+
 // Returns a list of daemons for which sockets have been configured.
 func (sockets ControlSockets) ConfiguredDaemonNames() (names []string) {
 	s := reflect.ValueOf(&sockets).Elem()
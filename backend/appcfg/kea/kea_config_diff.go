@@ -0,0 +1,404 @@
+package keaconfig
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Type of a change between two configurations.
+type ChangeKind string
+
+// Supported change types.
+const (
+	ChangeAdded    ChangeKind = "added"
+	ChangeRemoved  ChangeKind = "removed"
+	ChangeModified ChangeKind = "modified"
+)
+
+// Describes a change of a single configuration parameter. The From
+// value is nil when the parameter has been added. The To value is
+// nil when the parameter has been removed.
+type ParameterChange struct {
+	Name string      `json:"name"`
+	Kind ChangeKind  `json:"kind"`
+	From interface{} `json:"from,omitempty"`
+	To   interface{} `json:"to,omitempty"`
+}
+
+// Describes a change of a single DHCP option specified in the
+// option-data list. The options are matched by space and code or
+// by space and name when the code is not specified.
+type OptionChange struct {
+	Space string                 `json:"space,omitempty"`
+	Code  int64                  `json:"code,omitempty"`
+	Name  string                 `json:"name,omitempty"`
+	Kind  ChangeKind             `json:"kind"`
+	From  map[string]interface{} `json:"from,omitempty"`
+	To    map[string]interface{} `json:"to,omitempty"`
+}
+
+// Describes a change of a configuration list item identified by a key,
+// e.g. a hooks library identified by the library path, a client class
+// identified by name or a host reservation identified by the host
+// identifier.
+type ItemChange struct {
+	Key  string                 `json:"key"`
+	Kind ChangeKind             `json:"kind"`
+	From map[string]interface{} `json:"from,omitempty"`
+	To   map[string]interface{} `json:"to,omitempty"`
+}
+
+// Describes the changes of a subnet present in both compared
+// configurations. The subnets are matched by prefix.
+type SubnetChange struct {
+	Prefix       string            `json:"prefix"`
+	Parameters   []ParameterChange `json:"parameters,omitempty"`
+	Options      []OptionChange    `json:"options,omitempty"`
+	Reservations []ItemChange      `json:"reservations,omitempty"`
+}
+
+// Structural difference between two Kea configurations. It comprises
+// the changes of the global parameters, global options, hooks libraries,
+// client classes, global reservations and subnets.
+type ConfigDiff struct {
+	Globals         []ParameterChange `json:"globals,omitempty"`
+	Options         []OptionChange    `json:"options,omitempty"`
+	Hooks           []ItemChange      `json:"hooks,omitempty"`
+	ClientClasses   []ItemChange      `json:"clientClasses,omitempty"`
+	Reservations    []ItemChange      `json:"reservations,omitempty"`
+	SubnetsAdded    []string          `json:"subnetsAdded,omitempty"`
+	SubnetsRemoved  []string          `json:"subnetsRemoved,omitempty"`
+	SubnetsModified []SubnetChange    `json:"subnetsModified,omitempty"`
+}
+
+// Configuration parameters which are not compared as global parameters
+// because they are compared structurally.
+var structuralParameters = map[string]bool{ // nolint:gochecknoglobals
+	"client-classes":  true,
+	"hooks-libraries": true,
+	"option-data":     true,
+	"reservations":    true,
+	"shared-networks": true,
+	"subnet4":         true,
+	"subnet6":         true,
+}
+
+// Subnet parameters which are not compared as subnet parameters because
+// they are compared structurally.
+var structuralSubnetParameters = map[string]bool{ // nolint:gochecknoglobals
+	"option-data":  true,
+	"reservations": true,
+}
+
+// Host identifier types which can be used in the host reservations. They
+// are used to match the reservations in the compared configurations.
+var reservationIdentifierTypes = []string{"hw-address", "duid", "circuit-id", "client-id", "flex-id"} // nolint:gochecknoglobals
+
+// Returns true if there are no differences between the configurations.
+func (d *ConfigDiff) IsEmpty() bool {
+	return len(d.Globals) == 0 && len(d.Options) == 0 && len(d.Hooks) == 0 &&
+		len(d.ClientClasses) == 0 && len(d.Reservations) == 0 && len(d.SubnetsAdded) == 0 &&
+		len(d.SubnetsRemoved) == 0 && len(d.SubnetsModified) == 0
+}
+
+// Computes the structural difference between two configurations. The
+// global parameters are compared by name. The subnets, including the
+// subnets belonging to the shared networks, are matched by prefix.
+// The options are matched by space and code (or name). The hooks
+// libraries are matched by library path, the client classes by name
+// and the host reservations by host identifier. Any of the
+// configurations may be nil, in which case it is treated as empty.
+// The configurations may belong to different daemons, e.g. to the
+// servers in a High Availability pair.
+func Diff(from, to *Map) *ConfigDiff {
+	fromRoot := getRootNodeOrEmpty(from)
+	toRoot := getRootNodeOrEmpty(to)
+
+	diff := &ConfigDiff{
+		Globals: diffParameters(fromRoot, toRoot, structuralParameters),
+		Options: diffOptions(fromRoot["option-data"], toRoot["option-data"]),
+		Hooks: diffItems(indexItems(fromRoot["hooks-libraries"], getHooksLibraryKey),
+			indexItems(toRoot["hooks-libraries"], getHooksLibraryKey)),
+		ClientClasses: diffItems(indexItems(fromRoot["client-classes"], getClientClassKey),
+			indexItems(toRoot["client-classes"], getClientClassKey)),
+		Reservations: diffItems(indexItems(fromRoot["reservations"], getReservationKey),
+			indexItems(toRoot["reservations"], getReservationKey)),
+	}
+
+	fromSubnets := getAllSubnets(fromRoot)
+	toSubnets := getAllSubnets(toRoot)
+	for _, prefix := range sortedKeys(fromSubnets) {
+		if _, ok := toSubnets[prefix]; !ok {
+			diff.SubnetsRemoved = append(diff.SubnetsRemoved, prefix)
+		}
+	}
+	for _, prefix := range sortedKeys(toSubnets) {
+		fromSubnet, ok := fromSubnets[prefix]
+		if !ok {
+			diff.SubnetsAdded = append(diff.SubnetsAdded, prefix)
+			continue
+		}
+		toSubnet := toSubnets[prefix]
+		change := SubnetChange{
+			Prefix:     prefix,
+			Parameters: diffParameters(fromSubnet, toSubnet, structuralSubnetParameters),
+			Options:    diffOptions(fromSubnet["option-data"], toSubnet["option-data"]),
+			Reservations: diffItems(indexItems(fromSubnet["reservations"], getReservationKey),
+				indexItems(toSubnet["reservations"], getReservationKey)),
+		}
+		if len(change.Parameters) > 0 || len(change.Options) > 0 || len(change.Reservations) > 0 {
+			diff.SubnetsModified = append(diff.SubnetsModified, change)
+		}
+	}
+	return diff
+}
+
+// Returns the root node of the configuration or an empty map if the
+// configuration is nil or has no root node.
+func getRootNodeOrEmpty(c *Map) map[string]interface{} {
+	if c != nil {
+		if root, ok := c.getRootNode(); ok {
+			return root
+		}
+	}
+	return map[string]interface{}{}
+}
+
+// Returns the subnets specified at the top level of the configuration
+// and within the shared networks, indexed by their normalized prefixes.
+// The subnets belonging to the shared networks are returned with an
+// additional shared-network parameter, so the subnets moved between the
+// shared networks are reported as modified.
+func getAllSubnets(root map[string]interface{}) map[string]map[string]interface{} {
+	subnets := make(map[string]map[string]interface{})
+	collect := func(scope map[string]interface{}, sharedNetwork string) {
+		for _, key := range []string{"subnet4", "subnet6"} {
+			list, ok := scope[key].([]interface{})
+			if !ok {
+				continue
+			}
+			for _, item := range list {
+				subnet, ok := item.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				prefix, ok := subnet["subnet"].(string)
+				if !ok {
+					continue
+				}
+				if sharedNetwork != "" {
+					subnetCopy := make(map[string]interface{}, len(subnet)+1)
+					for name, value := range subnet {
+						subnetCopy[name] = value
+					}
+					subnetCopy["shared-network"] = sharedNetwork
+					subnet = subnetCopy
+				}
+				subnets[normalizePrefix(prefix)] = subnet
+			}
+		}
+	}
+	collect(root, "")
+	if networks, ok := root["shared-networks"].([]interface{}); ok {
+		for _, item := range networks {
+			network, ok := item.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			name, _ := network["name"].(string)
+			collect(network, name)
+		}
+	}
+	return subnets
+}
+
+// Converts the prefix to the canonical form, so the same prefixes
+// specified in different forms are matched. If the prefix cannot be
+// parsed it is returned unchanged.
+func normalizePrefix(prefix string) string {
+	if _, ipNet, err := net.ParseCIDR(prefix); err == nil {
+		return ipNet.String()
+	}
+	return prefix
+}
+
+// Compares the parameters of two configuration scopes, e.g. global
+// scopes or subnets. The parameters having the names specified in the
+// excluded map are skipped. The changes are sorted by parameter name.
+func diffParameters(from, to map[string]interface{}, excluded map[string]bool) (changes []ParameterChange) {
+	for _, name := range sortedKeys(from) {
+		if excluded[name] {
+			continue
+		}
+		toValue, ok := to[name]
+		switch {
+		case !ok:
+			changes = append(changes, ParameterChange{Name: name, Kind: ChangeRemoved, From: from[name]})
+		case !reflect.DeepEqual(from[name], toValue):
+			changes = append(changes, ParameterChange{Name: name, Kind: ChangeModified, From: from[name], To: toValue})
+		}
+	}
+	for _, name := range sortedKeys(to) {
+		if excluded[name] {
+			continue
+		}
+		if _, ok := from[name]; !ok {
+			changes = append(changes, ParameterChange{Name: name, Kind: ChangeAdded, To: to[name]})
+		}
+	}
+	sort.SliceStable(changes, func(i, j int) bool {
+		return changes[i].Name < changes[j].Name
+	})
+	return changes
+}
+
+// Compares two option-data lists. The options are matched by space and
+// code or by space and name if the code is not specified.
+func diffOptions(from, to interface{}) (changes []OptionChange) {
+	fromOptions := indexOptions(from)
+	toOptions := indexOptions(to)
+	for _, key := range sortedKeys(fromOptions) {
+		toOption, ok := toOptions[key]
+		switch {
+		case !ok:
+			changes = append(changes, newOptionChange(ChangeRemoved, fromOptions[key], nil))
+		case !reflect.DeepEqual(fromOptions[key], toOption):
+			changes = append(changes, newOptionChange(ChangeModified, fromOptions[key], toOption))
+		}
+	}
+	for _, key := range sortedKeys(toOptions) {
+		if _, ok := fromOptions[key]; !ok {
+			changes = append(changes, newOptionChange(ChangeAdded, nil, toOptions[key]))
+		}
+	}
+	return changes
+}
+
+// Indexes the options in the option-data list by space and code
+// or name.
+func indexOptions(optionData interface{}) map[string]map[string]interface{} {
+	options := make(map[string]map[string]interface{})
+	list, ok := optionData.([]interface{})
+	if !ok {
+		return options
+	}
+	for _, item := range list {
+		option, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		space, code, name := getOptionIdentity(option)
+		if code != 0 {
+			options[fmt.Sprintf("%s/%d", space, code)] = option
+		} else {
+			options[fmt.Sprintf("%s/%s", space, name)] = option
+		}
+	}
+	return options
+}
+
+// Returns the space, code and name of the option specified in the
+// option-data list.
+func getOptionIdentity(option map[string]interface{}) (space string, code int64, name string) {
+	space, _ = option["space"].(string)
+	name, _ = option["name"].(string)
+	if value, ok := option["code"].(float64); ok {
+		code = int64(value)
+	}
+	return
+}
+
+// Creates an option change from the option before and after the change.
+func newOptionChange(kind ChangeKind, from, to map[string]interface{}) OptionChange {
+	option := from
+	if option == nil {
+		option = to
+	}
+	space, code, name := getOptionIdentity(option)
+	return OptionChange{
+		Space: space,
+		Code:  code,
+		Name:  name,
+		Kind:  kind,
+		From:  from,
+		To:    to,
+	}
+}
+
+// Indexes the items of the configuration list by the keys returned by
+// the specified function. The items for which the function returns an
+// empty key are skipped.
+func indexItems(list interface{}, getKey func(map[string]interface{}) string) map[string]map[string]interface{} {
+	items := make(map[string]map[string]interface{})
+	if list, ok := list.([]interface{}); ok {
+		for _, item := range list {
+			if item, ok := item.(map[string]interface{}); ok {
+				if key := getKey(item); key != "" {
+					items[key] = item
+				}
+			}
+		}
+	}
+	return items
+}
+
+// Compares two sets of the configuration list items indexed by keys.
+// The changes are sorted by key.
+func diffItems(from, to map[string]map[string]interface{}) (changes []ItemChange) {
+	for _, key := range sortedKeys(from) {
+		toItem, ok := to[key]
+		switch {
+		case !ok:
+			changes = append(changes, ItemChange{Key: key, Kind: ChangeRemoved, From: from[key]})
+		case !reflect.DeepEqual(from[key], toItem):
+			changes = append(changes, ItemChange{Key: key, Kind: ChangeModified, From: from[key], To: toItem})
+		}
+	}
+	for _, key := range sortedKeys(to) {
+		if _, ok := from[key]; !ok {
+			changes = append(changes, ItemChange{Key: key, Kind: ChangeAdded, To: to[key]})
+		}
+	}
+	sort.SliceStable(changes, func(i, j int) bool {
+		return changes[i].Key < changes[j].Key
+	})
+	return changes
+}
+
+// Returns the library path of the hooks library.
+func getHooksLibraryKey(library map[string]interface{}) string {
+	path, _ := library["library"].(string)
+	return path
+}
+
+// Returns the name of the client class.
+func getClientClassKey(class map[string]interface{}) string {
+	name, _ := class["name"].(string)
+	return name
+}
+
+// Returns the host identifier of the reservation in the form of
+// identifier type and value, e.g. hw-address=01:02:03:04:05:06.
+// The identifier value is converted to lower case.
+func getReservationKey(reservation map[string]interface{}) string {
+	for _, identifierType := range reservationIdentifierTypes {
+		if value, ok := reservation[identifierType].(string); ok && value != "" {
+			return fmt.Sprintf("%s=%s", identifierType, strings.ToLower(value))
+		}
+	}
+	return ""
+}
+
+// Returns sorted keys of the map having the string keys.
+func sortedKeys(m interface{}) []string {
+	value := reflect.ValueOf(m)
+	keys := make([]string, 0, value.Len())
+	for _, key := range value.MapKeys() {
+		keys = append(keys, key.String())
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,309 @@
+package keaconfig
+
+import (
+	"testing"
+
+	require "github.com/stretchr/testify/require"
+)
+
+// Returns test configurations used to verify the configuration diffs.
+func getTestConfigsForDiff(t *testing.T) (*Map, *Map) {
+	from, err := NewFromJSON(`{
+        "Dhcp4": {
+            "valid-lifetime": 1000,
+            "renew-timer": 900,
+            "option-data": [
+                {
+                    "code": 6,
+                    "data": "192.0.2.1"
+                },
+                {
+                    "name": "domain-name",
+                    "data": "example.org"
+                }
+            ],
+            "subnet4": [
+                {
+                    "id": 1,
+                    "subnet": "192.0.2.0/24"
+                },
+                {
+                    "id": 2,
+                    "subnet": "192.0.3.0/24",
+                    "option-data": [
+                        {
+                            "code": 3,
+                            "data": "192.0.3.1"
+                        }
+                    ]
+                }
+            ],
+            "shared-networks": [
+                {
+                    "name": "foo",
+                    "subnet4": [
+                        {
+                            "id": 3,
+                            "subnet": "10.0.0.0/8"
+                        }
+                    ]
+                }
+            ]
+        }
+    }`)
+	require.NoError(t, err)
+
+	to, err := NewFromJSON(`{
+        "Dhcp4": {
+            "valid-lifetime": 2000,
+            "rebind-timer": 1800,
+            "option-data": [
+                {
+                    "code": 6,
+                    "data": "192.0.2.2"
+                },
+                {
+                    "code": 15,
+                    "data": "example.org"
+                }
+            ],
+            "subnet4": [
+                {
+                    "id": 2,
+                    "subnet": "192.0.3.0/24",
+                    "option-data": [
+                        {
+                            "code": 3,
+                            "data": "192.0.3.254"
+                        }
+                    ]
+                },
+                {
+                    "id": 3,
+                    "subnet": "10.0.0.0/8"
+                },
+                {
+                    "id": 4,
+                    "subnet": "192.0.4.1/24"
+                }
+            ]
+        }
+    }`)
+	require.NoError(t, err)
+
+	return from, to
+}
+
+// Test that the structural difference between two configurations
+// is computed correctly.
+func TestDiff(t *testing.T) {
+	from, to := getTestConfigsForDiff(t)
+
+	diff := Diff(from, to)
+	require.NotNil(t, diff)
+	require.False(t, diff.IsEmpty())
+
+	// Global parameters.
+	require.Len(t, diff.Globals, 3)
+	require.Equal(t, "rebind-timer", diff.Globals[0].Name)
+	require.Equal(t, ChangeAdded, diff.Globals[0].Kind)
+	require.Nil(t, diff.Globals[0].From)
+	require.EqualValues(t, 1800, diff.Globals[0].To)
+	require.Equal(t, "renew-timer", diff.Globals[1].Name)
+	require.Equal(t, ChangeRemoved, diff.Globals[1].Kind)
+	require.EqualValues(t, 900, diff.Globals[1].From)
+	require.Nil(t, diff.Globals[1].To)
+	require.Equal(t, "valid-lifetime", diff.Globals[2].Name)
+	require.Equal(t, ChangeModified, diff.Globals[2].Kind)
+	require.EqualValues(t, 1000, diff.Globals[2].From)
+	require.EqualValues(t, 2000, diff.Globals[2].To)
+
+	// Global options.
+	require.Len(t, diff.Options, 3)
+	require.EqualValues(t, 6, diff.Options[0].Code)
+	require.Equal(t, ChangeModified, diff.Options[0].Kind)
+	require.Equal(t, "192.0.2.1", diff.Options[0].From["data"])
+	require.Equal(t, "192.0.2.2", diff.Options[0].To["data"])
+	require.Equal(t, "domain-name", diff.Options[1].Name)
+	require.Equal(t, ChangeRemoved, diff.Options[1].Kind)
+	require.Nil(t, diff.Options[1].To)
+	require.EqualValues(t, 15, diff.Options[2].Code)
+	require.Equal(t, ChangeAdded, diff.Options[2].Kind)
+	require.Nil(t, diff.Options[2].From)
+
+	// Subnets.
+	require.Equal(t, []string{"192.0.2.0/24"}, diff.SubnetsRemoved)
+	require.Equal(t, []string{"192.0.4.0/24"}, diff.SubnetsAdded)
+	require.Len(t, diff.SubnetsModified, 2)
+
+	require.Equal(t, "10.0.0.0/8", diff.SubnetsModified[0].Prefix)
+	require.Len(t, diff.SubnetsModified[0].Parameters, 1)
+	require.Equal(t, "shared-network", diff.SubnetsModified[0].Parameters[0].Name)
+	require.Equal(t, ChangeRemoved, diff.SubnetsModified[0].Parameters[0].Kind)
+	require.Equal(t, "foo", diff.SubnetsModified[0].Parameters[0].From)
+	require.Empty(t, diff.SubnetsModified[0].Options)
+
+	require.Equal(t, "192.0.3.0/24", diff.SubnetsModified[1].Prefix)
+	require.Empty(t, diff.SubnetsModified[1].Parameters)
+	require.Len(t, diff.SubnetsModified[1].Options, 1)
+	require.EqualValues(t, 3, diff.SubnetsModified[1].Options[0].Code)
+	require.Equal(t, ChangeModified, diff.SubnetsModified[1].Options[0].Kind)
+}
+
+// Test that comparing the same configurations yields an empty diff.
+func TestDiffSameConfigs(t *testing.T) {
+	from, _ := getTestConfigsForDiff(t)
+
+	diff := Diff(from, from)
+	require.NotNil(t, diff)
+	require.True(t, diff.IsEmpty())
+}
+
+// Test that nil configurations are treated as empty configurations.
+func TestDiffNilConfig(t *testing.T) {
+	_, to := getTestConfigsForDiff(t)
+
+	diff := Diff(nil, to)
+	require.NotNil(t, diff)
+	require.Len(t, diff.Globals, 2)
+	require.Len(t, diff.Options, 2)
+	require.Len(t, diff.SubnetsAdded, 3)
+	require.Empty(t, diff.SubnetsRemoved)
+	require.Empty(t, diff.SubnetsModified)
+
+	diff = Diff(nil, nil)
+	require.True(t, diff.IsEmpty())
+}
+
+// Test that the hooks libraries, client classes and host reservations
+// are compared structurally.
+func TestDiffHooksClassesReservations(t *testing.T) {
+	from, err := NewFromJSON(`{
+        "Dhcp4": {
+            "hooks-libraries": [
+                {
+                    "library": "/usr/lib/kea/libdhcp_lease_cmds.so"
+                },
+                {
+                    "library": "/usr/lib/kea/libdhcp_ha.so",
+                    "parameters": {
+                        "high-availability": [{
+                            "this-server-name": "server1"
+                        }]
+                    }
+                }
+            ],
+            "client-classes": [
+                {
+                    "name": "foo",
+                    "test": "member('ALL')"
+                },
+                {
+                    "name": "bar"
+                }
+            ],
+            "reservations": [
+                {
+                    "hw-address": "01:02:03:04:05:06",
+                    "ip-address": "192.0.2.10"
+                }
+            ],
+            "subnet4": [
+                {
+                    "id": 1,
+                    "subnet": "192.0.2.0/24",
+                    "reservations": [
+                        {
+                            "client-id": "AA:BB",
+                            "ip-address": "192.0.2.20"
+                        }
+                    ]
+                }
+            ]
+        }
+    }`)
+	require.NoError(t, err)
+
+	to, err := NewFromJSON(`{
+        "Dhcp4": {
+            "hooks-libraries": [
+                {
+                    "library": "/usr/lib/kea/libdhcp_ha.so",
+                    "parameters": {
+                        "high-availability": [{
+                            "this-server-name": "server2"
+                        }]
+                    }
+                }
+            ],
+            "client-classes": [
+                {
+                    "name": "foo",
+                    "test": "member('ALL')"
+                },
+                {
+                    "name": "baz"
+                }
+            ],
+            "reservations": [
+                {
+                    "hw-address": "01:02:03:04:05:06",
+                    "ip-address": "192.0.2.10"
+                }
+            ],
+            "subnet4": [
+                {
+                    "id": 1,
+                    "subnet": "192.0.2.0/24",
+                    "reservations": [
+                        {
+                            "client-id": "aa:bb",
+                            "ip-address": "192.0.2.30"
+                        },
+                        {
+                            "duid": "01:01:01",
+                            "ip-address": "192.0.2.40"
+                        }
+                    ]
+                }
+            ]
+        }
+    }`)
+	require.NoError(t, err)
+
+	diff := Diff(from, to)
+	require.NotNil(t, diff)
+	require.Empty(t, diff.Globals)
+	require.Empty(t, diff.Options)
+	require.Empty(t, diff.Reservations)
+	require.Empty(t, diff.SubnetsAdded)
+	require.Empty(t, diff.SubnetsRemoved)
+
+	// Hooks libraries.
+	require.Len(t, diff.Hooks, 2)
+	require.Equal(t, "/usr/lib/kea/libdhcp_ha.so", diff.Hooks[0].Key)
+	require.Equal(t, ChangeModified, diff.Hooks[0].Kind)
+	require.NotNil(t, diff.Hooks[0].From)
+	require.NotNil(t, diff.Hooks[0].To)
+	require.Equal(t, "/usr/lib/kea/libdhcp_lease_cmds.so", diff.Hooks[1].Key)
+	require.Equal(t, ChangeRemoved, diff.Hooks[1].Kind)
+
+	// Client classes.
+	require.Len(t, diff.ClientClasses, 2)
+	require.Equal(t, "bar", diff.ClientClasses[0].Key)
+	require.Equal(t, ChangeRemoved, diff.ClientClasses[0].Kind)
+	require.Equal(t, "baz", diff.ClientClasses[1].Key)
+	require.Equal(t, ChangeAdded, diff.ClientClasses[1].Kind)
+
+	// Subnet reservations.
+	require.Len(t, diff.SubnetsModified, 1)
+	require.Empty(t, diff.SubnetsModified[0].Parameters)
+	reservations := diff.SubnetsModified[0].Reservations
+	require.Len(t, reservations, 2)
+	require.Equal(t, "client-id=aa:bb", reservations[0].Key)
+	require.Equal(t, ChangeModified, reservations[0].Kind)
+	require.Equal(t, "192.0.2.20", reservations[0].From["ip-address"])
+	require.Equal(t, "192.0.2.30", reservations[0].To["ip-address"])
+	require.Equal(t, "duid=01:01:01", reservations[1].Key)
+	require.Equal(t, ChangeAdded, reservations[1].Kind)
+}
@@ -416,6 +416,31 @@ func TestGetControlSockets(t *testing.T) {
 	require.Nil(t, sockets.NetConf)
 }
 
+// Verifies that the control socket of a DHCP daemon is parsed correctly.
+func TestGetControlSocket(t *testing.T) {
+	cfg, err := NewFromJSON(`{
+        "Dhcp4": {
+            "control-socket": {
+                "socket-type": "unix",
+                "socket-name": "/path/to/the/unix/socket-v4"
+            }
+        }
+    }`)
+	require.NoError(t, err)
+
+	socket := cfg.GetControlSocket()
+	require.NotNil(t, socket)
+	require.Equal(t, "unix", socket.SocketType)
+	require.Equal(t, "/path/to/the/unix/socket-v4", socket.SocketName)
+
+	// No control socket.
+	cfg, err = NewFromJSON(`{
+        "Dhcp4": { }
+    }`)
+	require.NoError(t, err)
+	require.Nil(t, cfg.GetControlSocket())
+}
+
 // Verifies that the list of daemons for which control sockets are specified
 // is returned correctly.
 func TestConfiguredDaemonNames(t *testing.T) {
@@ -0,0 +1,280 @@
+package bind9ctrl
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// Path of the JSON statistics in the named statistics-channel.
+	StatsJSONPath = "json/v1"
+	// Path of the XML statistics in the named statistics-channel.
+	StatsXMLPath = "xml/v3"
+)
+
+// Mapping of the server counter types in the XML statistics to the
+// names of the corresponding maps in the JSON statistics.
+var serverCounterTypes = map[string]string{ // nolint:gochecknoglobals
+	"opcode":   "opcodes",
+	"rcode":    "rcodes",
+	"qtype":    "qtypes",
+	"nsstat":   "nsstats",
+	"zonestat": "zonestats",
+	"resstat":  "resstats",
+	"sockstat": "sockstats",
+}
+
+// Mapping of the view counter types in the XML statistics to the
+// names of the corresponding maps in the resolver section of the
+// JSON statistics.
+var resolverCounterTypes = map[string]string{ // nolint:gochecknoglobals
+	"resstats":   "stats",
+	"resqtype":   "qtypes",
+	"cachestats": "cachestats",
+	"adbstat":    "adb",
+}
+
+// Mapping of the zone counter types in the XML statistics to the
+// names of the corresponding maps in the JSON statistics.
+var zoneCounterTypes = map[string]string{ // nolint:gochecknoglobals
+	"rcode":          "rcodes",
+	"qtype":          "qtypes",
+	"dnssec-sign":    "dnssec-sign",
+	"dnssec-refresh": "dnssec-refresh",
+}
+
+// Generic XML element of the statistics document. The statistics
+// consist of many differently named elements, so they are decoded
+// into a tree and converted to JSON selectively.
+type xmlNode struct {
+	XMLName xml.Name
+	Attrs   []xml.Attr `xml:",any,attr"`
+	Content string     `xml:",chardata"`
+	Nodes   []xmlNode  `xml:",any"`
+}
+
+// Returns the value of the attribute or an empty string if the
+// attribute doesn't exist.
+func (n *xmlNode) attr(name string) string {
+	for _, attr := range n.Attrs {
+		if attr.Name.Local == name {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+// Returns the first child element with the given name or nil if
+// there is no such element or the node is nil.
+func (n *xmlNode) child(name string) *xmlNode {
+	if n == nil {
+		return nil
+	}
+	for i := range n.Nodes {
+		if n.Nodes[i].XMLName.Local == name {
+			return &n.Nodes[i]
+		}
+	}
+	return nil
+}
+
+// Returns the trimmed text of the child element with the given name.
+func (n *xmlNode) childText(name string) string {
+	if child := n.child(name); child != nil {
+		return strings.TrimSpace(child.Content)
+	}
+	return ""
+}
+
+// Returns the counters from the counters elements indexed by the
+// counters type, e.g. qtype, nsstat.
+func (n *xmlNode) counters() map[string]map[string]interface{} {
+	counters := make(map[string]map[string]interface{})
+	for i := range n.Nodes {
+		if n.Nodes[i].XMLName.Local != "counters" {
+			continue
+		}
+		counterType := n.Nodes[i].attr("type")
+		values, ok := counters[counterType]
+		if !ok {
+			values = make(map[string]interface{})
+			counters[counterType] = values
+		}
+		for _, counter := range n.Nodes[i].Nodes {
+			if counter.XMLName.Local != "counter" {
+				continue
+			}
+			value, err := strconv.ParseFloat(strings.TrimSpace(counter.Content), 64)
+			if err != nil {
+				continue
+			}
+			values[counter.attr("name")] = value
+		}
+	}
+	return counters
+}
+
+// Converts the text to number if possible. Otherwise, the text is
+// returned as is.
+func toNumberIfPossible(text string) interface{} {
+	if value, err := strconv.ParseFloat(text, 64); err == nil {
+		return value
+	}
+	return text
+}
+
+// Converts the view element to the JSON view statistics.
+func convertView(view *xmlNode) map[string]interface{} {
+	resolver := make(map[string]interface{})
+	for counterType, values := range view.counters() {
+		if name, ok := resolverCounterTypes[counterType]; ok {
+			resolver[name] = values
+		}
+	}
+	if cacheNode := view.child("cache"); cacheNode != nil {
+		cache := make(map[string]interface{})
+		for _, rrset := range cacheNode.Nodes {
+			if rrset.XMLName.Local != "rrset" {
+				continue
+			}
+			value, err := strconv.ParseFloat(rrset.childText("counter"), 64)
+			if err != nil {
+				continue
+			}
+			cache[rrset.childText("name")] = value
+		}
+		resolver["cache"] = cache
+	}
+
+	zones := []interface{}{}
+	if zonesNode := view.child("zones"); zonesNode != nil {
+		for i := range zonesNode.Nodes {
+			zoneNode := &zonesNode.Nodes[i]
+			if zoneNode.XMLName.Local != "zone" {
+				continue
+			}
+			zone := map[string]interface{}{
+				"name":  zoneNode.attr("name"),
+				"class": zoneNode.attr("rdataclass"),
+			}
+			if text := zoneNode.childText("type"); text != "" {
+				zone["type"] = text
+			}
+			for _, name := range []string{"serial", "loaded", "expires", "refresh"} {
+				if text := zoneNode.childText(name); text != "" {
+					zone[name] = toNumberIfPossible(text)
+				}
+			}
+			for counterType, values := range zoneNode.counters() {
+				if name, ok := zoneCounterTypes[counterType]; ok {
+					zone[name] = values
+				}
+			}
+			zones = append(zones, zone)
+		}
+	}
+
+	return map[string]interface{}{
+		"resolver": resolver,
+		"zones":    zones,
+	}
+}
+
+// Converts the traffic element to the JSON traffic statistics. The
+// counters of the request and response sizes are stored in the maps
+// named like dns-udp-requests-sizes-received-ipv4.
+func convertTraffic(trafficNode *xmlNode) map[string]interface{} {
+	traffic := make(map[string]interface{})
+	for _, family := range trafficNode.Nodes {
+		for i := range family.Nodes {
+			protocol := &family.Nodes[i]
+			for counterType, values := range protocol.counters() {
+				var direction string
+				switch counterType {
+				case "request-size":
+					direction = "requests-sizes-received"
+				case "response-size":
+					direction = "responses-sizes-sent"
+				default:
+					continue
+				}
+				name := fmt.Sprintf("dns-%s-%s-%s", protocol.XMLName.Local, direction, family.XMLName.Local)
+				traffic[name] = values
+			}
+		}
+	}
+	return traffic
+}
+
+// Converts the statistics returned by the XML statistics-channel
+// of named (xml/v3) to the format returned by the JSON
+// statistics-channel (json/v1). It allows for processing the statistics
+// of the named built without JSON support the same way as the JSON
+// statistics. The statistics not used by Stork, e.g. memory, sockets
+// and tasks statistics, are omitted.
+func ConvertXMLStatsToJSON(xmlStats []byte) ([]byte, error) {
+	var root xmlNode
+	decoder := xml.NewDecoder(bytes.NewReader(xmlStats))
+	if err := decoder.Decode(&root); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse XML statistics from named")
+	}
+	if root.XMLName.Local != "statistics" {
+		return nil, errors.Errorf("unexpected root element %s in XML statistics from named", root.XMLName.Local)
+	}
+
+	stats := make(map[string]interface{})
+
+	if server := root.child("server"); server != nil {
+		for _, name := range []string{"boot-time", "config-time", "current-time", "version"} {
+			if text := server.childText(name); text != "" {
+				stats[name] = text
+			}
+		}
+		for counterType, values := range server.counters() {
+			if name, ok := serverCounterTypes[counterType]; ok {
+				stats[name] = values
+			}
+		}
+	}
+
+	views := make(map[string]interface{})
+	if viewsNode := root.child("views"); viewsNode != nil {
+		for i := range viewsNode.Nodes {
+			if viewsNode.Nodes[i].XMLName.Local != "view" {
+				continue
+			}
+			views[viewsNode.Nodes[i].attr("name")] = convertView(&viewsNode.Nodes[i])
+		}
+	}
+	stats["views"] = views
+
+	taskmgr := make(map[string]interface{})
+	if threadModel := root.child("taskmgr").child("thread-model"); threadModel != nil {
+		for _, node := range threadModel.Nodes {
+			name := node.XMLName.Local
+			if name == "type" {
+				name = "thread-model"
+			}
+			taskmgr[name] = toNumberIfPossible(strings.TrimSpace(node.Content))
+		}
+	}
+	stats["taskmgr"] = taskmgr
+
+	traffic := make(map[string]interface{})
+	if trafficNode := root.child("traffic"); trafficNode != nil {
+		traffic = convertTraffic(trafficNode)
+	}
+	stats["traffic"] = traffic
+
+	jsonStats, err := json.Marshal(stats)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to convert XML statistics from named to JSON")
+	}
+	return jsonStats, nil
+}
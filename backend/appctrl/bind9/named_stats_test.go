@@ -0,0 +1,161 @@
+package bind9ctrl
+
+import (
+	"encoding/json"
+	"testing"
+
+	require "github.com/stretchr/testify/require"
+)
+
+// Sample statistics returned by the XML statistics-channel of named.
+const xmlStats = `<?xml version="1.0" encoding="UTF-8"?>
+<?xml-stylesheet type="text/xsl" href="/bind9.xsl"?>
+<statistics version="3.11">
+  <server>
+    <boot-time>2021-07-01T10:00:00.000Z</boot-time>
+    <config-time>2021-07-01T10:00:01.000Z</config-time>
+    <current-time>2021-07-01T11:00:00.000Z</current-time>
+    <version>9.16.15</version>
+    <counters type="opcode">
+      <counter name="QUERY">100</counter>
+      <counter name="IQUERY">0</counter>
+    </counters>
+    <counters type="qtype">
+      <counter name="A">60</counter>
+      <counter name="AAAA">40</counter>
+    </counters>
+    <counters type="nsstat">
+      <counter name="Requestv4">100</counter>
+      <counter name="QryRecursion">20</counter>
+    </counters>
+    <counters type="memstat">
+      <counter name="Unknown">1</counter>
+    </counters>
+  </server>
+  <views>
+    <view name="_default">
+      <counters type="resqtype">
+        <counter name="A">10</counter>
+      </counters>
+      <counters type="resstats">
+        <counter name="Queryv4">12</counter>
+        <counter name="ValOk">5</counter>
+      </counters>
+      <counters type="cachestats">
+        <counter name="CacheHits">40</counter>
+        <counter name="CacheMisses">10</counter>
+      </counters>
+      <cache name="_default">
+        <rrset>
+          <name>A</name>
+          <counter>7</counter>
+        </rrset>
+        <rrset>
+          <name>!AAAA</name>
+          <counter>2</counter>
+        </rrset>
+      </cache>
+      <zones>
+        <zone name="example.com" rdataclass="IN">
+          <type>primary</type>
+          <serial>2021070101</serial>
+          <loaded>2021-07-01T10:00:01Z</loaded>
+          <counters type="rcode">
+            <counter name="QrySuccess">30</counter>
+          </counters>
+          <counters type="dnssec-sign">
+            <counter name="12345">3</counter>
+          </counters>
+        </zone>
+      </zones>
+    </view>
+  </views>
+  <taskmgr>
+    <thread-model>
+      <type>threaded</type>
+      <worker-threads>4</worker-threads>
+      <tasks-running>1</tasks-running>
+    </thread-model>
+  </taskmgr>
+  <traffic>
+    <ipv4>
+      <udp>
+        <counters type="request-size">
+          <counter name="32-47">90</counter>
+        </counters>
+        <counters type="response-size">
+          <counter name="64-79">85</counter>
+        </counters>
+      </udp>
+    </ipv4>
+  </traffic>
+</statistics>`
+
+// Test that the XML statistics are converted to the same structure
+// as the JSON statistics.
+func TestConvertXMLStatsToJSON(t *testing.T) {
+	jsonStats, err := ConvertXMLStatsToJSON([]byte(xmlStats))
+	require.NoError(t, err)
+
+	expected := `{
+		"boot-time": "2021-07-01T10:00:00.000Z",
+		"config-time": "2021-07-01T10:00:01.000Z",
+		"current-time": "2021-07-01T11:00:00.000Z",
+		"version": "9.16.15",
+		"opcodes": {"QUERY": 100, "IQUERY": 0},
+		"qtypes": {"A": 60, "AAAA": 40},
+		"nsstats": {"Requestv4": 100, "QryRecursion": 20},
+		"views": {
+			"_default": {
+				"resolver": {
+					"stats": {"Queryv4": 12, "ValOk": 5},
+					"qtypes": {"A": 10},
+					"cachestats": {"CacheHits": 40, "CacheMisses": 10},
+					"cache": {"A": 7, "!AAAA": 2}
+				},
+				"zones": [
+					{
+						"name": "example.com",
+						"class": "IN",
+						"type": "primary",
+						"serial": 2021070101,
+						"loaded": "2021-07-01T10:00:01Z",
+						"rcodes": {"QrySuccess": 30},
+						"dnssec-sign": {"12345": 3}
+					}
+				]
+			}
+		},
+		"taskmgr": {
+			"thread-model": "threaded",
+			"worker-threads": 4,
+			"tasks-running": 1
+		},
+		"traffic": {
+			"dns-udp-requests-sizes-received-ipv4": {"32-47": 90},
+			"dns-udp-responses-sizes-sent-ipv4": {"64-79": 85}
+		}
+	}`
+	require.JSONEq(t, expected, string(jsonStats))
+
+	// The result must be parsable like the JSON statistics.
+	var stats map[string]interface{}
+	require.NoError(t, json.Unmarshal(jsonStats, &stats))
+}
+
+// Test that the document without statistics yields empty sections
+// rather than an error.
+func TestConvertXMLStatsToJSONEmpty(t *testing.T) {
+	jsonStats, err := ConvertXMLStatsToJSON([]byte(`<statistics version="3.11"/>`))
+	require.NoError(t, err)
+	require.JSONEq(t, `{"views": {}, "taskmgr": {}, "traffic": {}}`, string(jsonStats))
+}
+
+// Test that an error is returned for the invalid XML statistics.
+func TestConvertXMLStatsToJSONInvalid(t *testing.T) {
+	_, err := ConvertXMLStatsToJSON([]byte(`{"views": {}}`))
+	require.Error(t, err)
+
+	_, err = ConvertXMLStatsToJSON([]byte(`<html><body>Not Found</body></html>`))
+	require.Error(t, err)
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"os"
@@ -8,11 +9,13 @@ import (
 	"os/user"
 	"strconv"
 	"syscall"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/urfave/cli/v2"
 	"isc.org/stork"
 	"isc.org/stork/agent"
+	"isc.org/stork/tracing"
 	storkutil "isc.org/stork/util"
 )
 
@@ -30,6 +33,21 @@ func runAgent(settings *cli.Context) {
 		}
 	}
 
+	// Setup exporting the traces.
+	shutdownTracing, err := tracing.Setup("stork-agent", tracing.Settings{
+		Endpoint:    settings.String("tracing-otlp-endpoint"),
+		Insecure:    settings.Bool("tracing-otlp-insecure"),
+		SampleRatio: settings.Float64("tracing-sample-ratio"),
+	})
+	if err != nil {
+		log.Fatalf("FATAL error: %+v", err)
+	}
+	defer func() {
+		if err := shutdownTracing(context.Background()); err != nil {
+			log.Warnf("problem with flushing the traces: %+v", err)
+		}
+	}()
+
 	// Start app monitor
 	appMonitor := agent.NewAppMonitor()
 
@@ -40,7 +58,7 @@ func runAgent(settings *cli.Context) {
 	promKeaExporter := agent.NewPromKeaExporter(settings, appMonitor)
 	promBind9Exporter := agent.NewPromBind9Exporter(settings, appMonitor)
 
-	err := storkAgent.Setup()
+	err = storkAgent.Setup()
 	if err != nil {
 		log.Fatalf("FATAL error: %+v", err)
 	}
@@ -60,6 +78,10 @@ func runAgent(settings *cli.Context) {
 	// Only start the agent service if it's enabled.
 	if !settings.Bool("listen-prometheus-only") {
 		go storkAgent.Serve()
+		// Open the tunnel if the server can't connect to the agent.
+		if settings.String("server-tunnel-address") != "" {
+			storkAgent.StartTunnel(settings.String("server-tunnel-address"))
+		}
 		defer storkAgent.Shutdown()
 	}
 
@@ -156,6 +178,32 @@ func setupApp() *cli.App {
 				Usage:   "enable or disable collecting per subnet stats from Kea",
 				EnvVars: []string{"STORK_AGENT_PROMETHEUS_KEA_EXPORTER_PER_SUBNET_STATS"},
 			},
+			&cli.BoolFlag{
+				Name:    "prometheus-kea-exporter-per-pool-stats",
+				Value:   true,
+				Usage:   "enable or disable collecting per pool stats from Kea; they are not collected when per subnet stats are disabled",
+				EnvVars: []string{"STORK_AGENT_PROMETHEUS_KEA_EXPORTER_PER_POOL_STATS"},
+			},
+			&cli.StringSliceFlag{
+				Name:    "prometheus-kea-exporter-stats-allow",
+				Usage:   "the comma-separated names of Kea stats to export, e.g. assigned-addresses,pkt4-ack-sent; all stats are exported when not specified",
+				EnvVars: []string{"STORK_AGENT_PROMETHEUS_KEA_EXPORTER_STATS_ALLOW"},
+			},
+			&cli.StringSliceFlag{
+				Name:    "prometheus-kea-exporter-stats-deny",
+				Usage:   "the comma-separated names of Kea stats not to export",
+				EnvVars: []string{"STORK_AGENT_PROMETHEUS_KEA_EXPORTER_STATS_DENY"},
+			},
+			&cli.StringSliceFlag{
+				Name:    "prometheus-kea-exporter-subnets-allow",
+				Usage:   "the comma-separated IDs, prefixes or shared network names of the subnets whose stats are exported; stats of all subnets are exported when not specified",
+				EnvVars: []string{"STORK_AGENT_PROMETHEUS_KEA_EXPORTER_SUBNETS_ALLOW"},
+			},
+			&cli.StringSliceFlag{
+				Name:    "prometheus-kea-exporter-subnets-deny",
+				Usage:   "the comma-separated IDs, prefixes or shared network names of the subnets whose stats are not exported",
+				EnvVars: []string{"STORK_AGENT_PROMETHEUS_KEA_EXPORTER_SUBNETS_DENY"},
+			},
 			// Prometheus Bind 9 exporter settings
 			&cli.StringFlag{
 				Name:    "prometheus-bind9-exporter-address",
@@ -175,6 +223,21 @@ func setupApp() *cli.App {
 				Usage:   "specifies how often the agent collects stats from BIND 9, in seconds",
 				EnvVars: []string{"STORK_AGENT_PROMETHEUS_BIND9_EXPORTER_INTERVAL"},
 			},
+			&cli.BoolFlag{
+				Name:    "prometheus-bind9-exporter-per-zone-stats",
+				Usage:   "enable or disable collecting per zone stats from BIND 9; the zone-statistics must be enabled in BIND 9",
+				EnvVars: []string{"STORK_AGENT_PROMETHEUS_BIND9_EXPORTER_PER_ZONE_STATS"},
+			},
+			&cli.StringSliceFlag{
+				Name:    "prometheus-bind9-exporter-zones-include",
+				Usage:   "the comma-separated names or patterns of the zones whose stats are exported, e.g. *.example.org; stats of all zones are exported when not specified",
+				EnvVars: []string{"STORK_AGENT_PROMETHEUS_BIND9_EXPORTER_ZONES_INCLUDE"},
+			},
+			&cli.StringSliceFlag{
+				Name:    "prometheus-bind9-exporter-zones-exclude",
+				Usage:   "the comma-separated names or patterns of the zones whose stats are not exported",
+				EnvVars: []string{"STORK_AGENT_PROMETHEUS_BIND9_EXPORTER_ZONES_EXCLUDE"},
+			},
 			&cli.BoolFlag{
 				Name:    "skip-tls-cert-verification",
 				Value:   false,
@@ -187,12 +250,44 @@ func setupApp() *cli.App {
 				Usage:   "URL of Stork Server, used in agent token based registration (optional, alternative to server token based registration)",
 				EnvVars: []string{"STORK_AGENT_SERVER_URL"},
 			},
+			&cli.StringFlag{
+				Name:    "server-tunnel-address",
+				Usage:   "the address and port of Stork Server accepting the tunnels from the agents, eg: 10.11.12.13:8081; if specified, the agent opens the tunnel to the server, so the server does not need to connect to the agent, e.g. when the agent is behind NAT",
+				EnvVars: []string{"STORK_AGENT_SERVER_TUNNEL_ADDRESS"},
+			},
+			&cli.DurationFlag{
+				Name:    "cert-renewal-before",
+				Value:   30 * 24 * time.Hour,
+				Usage:   "how long before the expiration of the agent certificate the agent requests its renewal from Stork Server, eg: 720h",
+				EnvVars: []string{"STORK_AGENT_CERT_RENEWAL_BEFORE"},
+			},
+			// Tracing settings
+			&cli.StringFlag{
+				Name:    "tracing-otlp-endpoint",
+				Usage:   "the address of the OTLP collector receiving the traces over gRPC, eg: localhost:4317; the traces are not exported if it is not specified",
+				EnvVars: []string{"STORK_AGENT_TRACING_OTLP_ENDPOINT"},
+			},
+			&cli.BoolFlag{
+				Name:    "tracing-otlp-insecure",
+				Usage:   "disable TLS in the connection to the OTLP collector",
+				EnvVars: []string{"STORK_AGENT_TRACING_OTLP_INSECURE"},
+			},
+			&cli.Float64Flag{
+				Name:    "tracing-sample-ratio",
+				Value:   1,
+				Usage:   "the fraction of the traces started by the agent which are exported, from 0 to 1; the traces started by the server are exported according to the server's decision",
+				EnvVars: []string{"STORK_AGENT_TRACING_SAMPLE_RATIO"},
+			},
 		},
 		Action: func(c *cli.Context) error {
 			if c.String("server-url") != "" && c.String("host") == "0.0.0.0" {
 				log.Errorf("registration in Stork Server cannot be made because agent host address is not provided")
 				log.Fatalf("use --host option or STORK_AGENT_HOST environment variable")
 			}
+			if c.String("server-tunnel-address") != "" && c.String("host") == "0.0.0.0" {
+				log.Errorf("tunnel to Stork Server cannot be opened because agent host address is not provided")
+				log.Fatalf("use --host option or STORK_AGENT_HOST environment variable")
+			}
 
 			runAgent(c)
 			return nil
@@ -5,6 +5,7 @@ import (
 	"net"
 	"os"
 
+	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 	"github.com/urfave/cli/v2"
 
@@ -230,6 +231,45 @@ func runCertImport(settings *cli.Context) error {
 	return certs.ImportSecret(db, settings.String("object"), settings.String("file"))
 }
 
+// Execute cert rotate command. It starts or finishes the root CA
+// rotation, or prints the rotation status if the phase is not specified.
+func runCertRotate(settings *cli.Context) error {
+	db := getDBConn(settings)
+	defer db.Close()
+
+	switch settings.String("phase") {
+	case "start":
+		if err := certs.StartCARotation(db); err != nil {
+			return err
+		}
+	case "finish":
+		if err := certs.FinishCARotation(db, settings.Bool("force")); err != nil {
+			return err
+		}
+	case "":
+	default:
+		return errors.Errorf("unsupported phase %s, it must be 'start' or 'finish'", settings.String("phase"))
+	}
+
+	status, err := certs.GetCARotationStatus(db)
+	if err != nil {
+		return err
+	}
+	log.Infof("CA rotation in progress: %t", status.InProgress)
+	for _, m := range status.Machines {
+		rotationStatus := string(m.CertRotationStatus)
+		if rotationStatus == "" {
+			rotationStatus = "none"
+		}
+		log.WithFields(log.Fields{
+			"machine":    m.Address,
+			"port":       m.AgentPort,
+			"authorized": m.Authorized,
+		}).Infof("agent cert rotation status: %s", rotationStatus)
+	}
+	return nil
+}
+
 // Prepare urfave cli app with all flags and commands defined.
 func setupApp() *cli.App {
 	cli.VersionPrinter = func(c *cli.Context) {
@@ -406,6 +446,19 @@ func setupApp() *cli.App {
 			EnvVars: []string{"STORK_TOOL_CERT_FILE"},
 		})
 
+	certRotateFlags := append(dbFlags,
+		&cli.StringFlag{
+			Name:    "phase",
+			Usage:   "The phase of the root CA rotation, it can be one of 'start' or 'finish'. If not provided then the rotation status is printed.",
+			Aliases: []string{"p"},
+			EnvVars: []string{"STORK_TOOL_CERT_ROTATE_PHASE"},
+		},
+		&cli.BoolFlag{
+			Name:    "force",
+			Usage:   "Finish the rotation even if the certificates of some agents have not been re-issued yet. Such agents must be registered again.",
+			EnvVars: []string{"STORK_TOOL_CERT_ROTATE_FORCE"},
+		})
+
 	app := &cli.App{
 		Name: "Stork Tool",
 		Usage: `A tool for managing Stork Server
@@ -414,7 +467,7 @@ func setupApp() *cli.App {
 
    - Certificates Management - it allows for exporting Stork Server keys, certificates
      and token that are used for securing communication between Stork Server
-     and Stork Agents
+     and Stork Agents, and for rotating the root CA
 
    - Database Creation - it facilitates creating a new database for the Stork Server,
      and a user that can access this database with a generated password
@@ -541,6 +594,19 @@ func setupApp() *cli.App {
 				Category:    "Certificates Management",
 				Action:      runCertImport,
 			},
+			{
+				Name:      "cert-rotate",
+				Usage:     "Rotate the root CA or print the rotation status",
+				UsageText: "stork-tool cert-rotate [options for db connection] [-p start|finish] [--force]",
+				Description: `The root CA is rotated in two phases. The start phase generates the new root CA
+   and server certificate. Restart the server to trust the new CA and wait until the
+   certificates of all agents are re-issued. The finish phase replaces the current root CA
+   and server certificate with the new ones. Restart the server again to use the new
+   server certificate. The agents stop trusting the old CA afterwards.`,
+				Flags:    certRotateFlags,
+				Category: "Certificates Management",
+				Action:   runCertRotate,
+			},
 		},
 	}
 
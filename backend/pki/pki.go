@@ -341,3 +341,20 @@ func SignCert(csrPEM []byte, serialNumber int64, parentCertPEM []byte, parentKey
 	fingerprint = sha256.Sum256(cert.Raw)
 	return pem, fingerprint, nil, nil
 }
+
+// Calculate the SHA-256 fingerprint of the public key in the CSR. The
+// fingerprint doesn't change when the CSR is regenerated using the same
+// key. It is used by the server to recognize the agents which keys have
+// been registered in advance.
+func GetCSRKeyFingerprint(csrPEM []byte) ([sha256.Size]byte, error) {
+	var fingerprint [sha256.Size]byte
+	pemBlock, _ := pem.Decode(csrPEM)
+	if pemBlock == nil {
+		return fingerprint, errors.New("decoding PEM with CSR failed")
+	}
+	csr, err := x509.ParseCertificateRequest(pemBlock.Bytes)
+	if err != nil {
+		return fingerprint, errors.Wrapf(err, "parsing CSR failed")
+	}
+	return sha256.Sum256(csr.RawSubjectPublicKeyInfo), nil
+}
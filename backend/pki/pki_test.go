@@ -274,3 +274,31 @@ func TestSignCert(t *testing.T) {
 	require.EqualValues(t, dnsNames[0], cert.DNSNames[0])
 	require.True(t, ipAddresses[0].Equal(cert.IPAddresses[0]))
 }
+
+// Check that the key fingerprint is the same for all CSRs generated
+// using the same key.
+func TestGetCSRKeyFingerprint(t *testing.T) {
+	ipAddresses := []net.IP{net.ParseIP("192.0.2.1")}
+	privKeyPEM, csrPEM, csrFingerprint, err := GenKeyAndCSR("agent", nil, ipAddresses)
+	require.NoError(t, err)
+
+	fingerprint, err := GetCSRKeyFingerprint(csrPEM)
+	require.NoError(t, err)
+	require.NotEqual(t, csrFingerprint, fingerprint)
+
+	csrPEM2, _, err := GenCSRUsingKey("agent", nil, ipAddresses, privKeyPEM)
+	require.NoError(t, err)
+	fingerprint2, err := GetCSRKeyFingerprint(csrPEM2)
+	require.NoError(t, err)
+	require.Equal(t, fingerprint, fingerprint2)
+
+	// the fingerprint of the key is different
+	_, csrPEM3, _, err := GenKeyAndCSR("agent", nil, ipAddresses)
+	require.NoError(t, err)
+	fingerprint3, err := GetCSRKeyFingerprint(csrPEM3)
+	require.NoError(t, err)
+	require.NotEqual(t, fingerprint, fingerprint3)
+
+	_, err = GetCSRKeyFingerprint([]byte("invalid"))
+	require.Error(t, err)
+}
@@ -6,9 +6,11 @@ import (
 	"crypto/x509"
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/security/advancedtls"
@@ -21,6 +23,16 @@ import (
 
 // Settings specific to communication with Agents.
 type AgentsSettings struct {
+	TunnelHost string `long:"agents-tunnel-host" description:"the IP to listen on for the tunnels opened by the agents which cannot be reached by the server" default:"" env:"STORK_SERVER_AGENTS_TUNNEL_HOST"`
+	TunnelPort int    `long:"agents-tunnel-port" description:"the port to listen on for the tunnels opened by the agents; the tunnels are disabled if it is 0" default:"0" env:"STORK_SERVER_AGENTS_TUNNEL_PORT"`
+
+	MaxCallsPerAgent int           `long:"agents-max-calls-per-agent" description:"the maximum number of concurrent calls to a single agent" default:"4" env:"STORK_SERVER_AGENTS_MAX_CALLS_PER_AGENT"`
+	MaxCalls         int           `long:"agents-max-calls" description:"the maximum number of concurrent calls to all agents" default:"64" env:"STORK_SERVER_AGENTS_MAX_CALLS"`
+	CallTimeout      time.Duration `long:"agents-call-timeout" description:"the maximum duration of a call to an agent" default:"30s" env:"STORK_SERVER_AGENTS_CALL_TIMEOUT"`
+
+	CircuitBreakerThreshold  int           `long:"agents-circuit-breaker-threshold" description:"the number of consecutive failures after which the communication with an agent or a daemon is suspended" default:"3" env:"STORK_SERVER_AGENTS_CIRCUIT_BREAKER_THRESHOLD"`
+	CircuitBreakerMinBackoff time.Duration `long:"agents-circuit-breaker-min-backoff" description:"the time after which the suspended communication is probed for the first time" default:"10s" env:"STORK_SERVER_AGENTS_CIRCUIT_BREAKER_MIN_BACKOFF"`
+	CircuitBreakerMaxBackoff time.Duration `long:"agents-circuit-breaker-max-backoff" description:"the maximum time between probing the suspended communication" default:"10m" env:"STORK_SERVER_AGENTS_CIRCUIT_BREAKER_MAX_BACKOFF"`
 }
 
 // Holds runtime communication statistics with Kea daemons via
@@ -28,13 +40,35 @@ type AgentsSettings struct {
 type AgentKeaCommStats struct {
 	CurrentErrorsCA      int64            // generall errors in communication to/via CA
 	CurrentErrorsDaemons map[string]int64 // errors returned by particular daemon (including CA)
+	CircuitBreakerCA     *CircuitBreaker  // suspends communication to/via CA
+	// Suspend communication with particular daemons (including CA).
+	CircuitBreakersDaemons map[string]*CircuitBreaker
+}
+
+// Creates the communication statistics with Kea daemons.
+func newAgentKeaCommStats() *AgentKeaCommStats {
+	return &AgentKeaCommStats{
+		CurrentErrorsDaemons:   make(map[string]int64),
+		CircuitBreakerCA:       NewCircuitBreaker(),
+		CircuitBreakersDaemons: make(map[string]*CircuitBreaker),
+	}
+}
+
+// Creates the communication statistics with Bind9 daemon.
+func newAgentBind9CommStats() *AgentBind9CommStats {
+	return &AgentBind9CommStats{
+		CircuitBreakerRNDC:  NewCircuitBreaker(),
+		CircuitBreakerStats: NewCircuitBreaker(),
+	}
 }
 
 // Holds runtime communication statistics with Bind9 daemon for
 // a given agent.
 type AgentBind9CommStats struct {
-	CurrentErrorsRNDC  int64
-	CurrentErrorsStats int64
+	CurrentErrorsRNDC   int64
+	CurrentErrorsStats  int64
+	CircuitBreakerRNDC  *CircuitBreaker
+	CircuitBreakerStats *CircuitBreaker
 }
 
 type AppCommStatsKey struct {
@@ -45,9 +79,10 @@ type AppCommStatsKey struct {
 // Holds runtime statistics of communication with a given agent and
 // with the apps behind this agent.
 type AgentStats struct {
-	CurrentErrors int64
-	AppCommStats  map[AppCommStatsKey]interface{}
-	mutex         *sync.Mutex
+	CurrentErrors  int64
+	AppCommStats   map[AppCommStatsKey]interface{}
+	CircuitBreaker *CircuitBreaker
+	mutex          *sync.Mutex
 }
 
 // Runtime information about the agent, e.g. connection, communication
@@ -57,10 +92,20 @@ type Agent struct {
 	Client   agentapi.AgentClient
 	GrpcConn *grpc.ClientConn
 	Stats    AgentStats
+	// Tunnel opened by the agent. If it is set, the client sends the
+	// calls over this tunnel instead of the gRPC connection.
+	tunnel *agentTunnel
+	// Protects the client, the connection and the tunnel which may be
+	// replaced while other calls to the agent are in progress.
+	connMutex sync.Mutex
+	// Certs revoked by the server. The agent presenting the revoked cert
+	// is refused.
+	revokedCerts *revokedCerts
 }
 
 // Prepare TLS credentials with configured certs and verification options.
-func prepareTLSCreds(caCertPEM, serverCertPEM, serverKeyPEM []byte) (credentials.TransportCredentials, error) {
+// The agents presenting the revoked certs are refused.
+func prepareTLSCreds(caCertPEM, serverCertPEM, serverKeyPEM []byte, revokedCerts *revokedCerts) (credentials.TransportCredentials, error) {
 	// Load the certificates from disk
 	certificate, err := tls.X509KeyPair(serverCertPEM, serverKeyPEM)
 	if err != nil {
@@ -89,12 +134,8 @@ func prepareTLSCreds(caCertPEM, serverCertPEM, serverKeyPEM []byte) (credentials
 		},
 		// check cert and if it matches host IP
 		VType: advancedtls.CertAndHostVerification,
-		// additional verification hook function that checks if stork agent is not using old cert
-		// VerifyPeer: func(params *advancedtls.VerificationFuncParams) (*advancedtls.VerificationResults, error) {
-		// 	// TODO: add here check if agent cert is not old (compare it with
-		// 	// CertFingerprint from Machine db record)
-		// 	return &advancedtls.VerificationResults{}, nil
-		// },
+		// additional verification hook function that checks if stork agent is not using revoked cert
+		VerifyPeer: revokedCerts.verifyPeer,
 	}
 	creds, err := advancedtls.NewClientCreds(options)
 	if err != nil {
@@ -112,13 +153,18 @@ func (agent *Agent) MakeGrpcConnection(caCertPEM, serverCertPEM, serverKeyPEM []
 	}
 
 	// Prepare TLS credentials
-	creds, err := prepareTLSCreds(caCertPEM, serverCertPEM, serverKeyPEM)
+	creds, err := prepareTLSCreds(caCertPEM, serverCertPEM, serverKeyPEM, agent.revokedCerts)
 	if err != nil {
 		return errors.WithMessagef(err, "problem with preparing TLS credentials")
 	}
 
-	// Setup new connection
-	grpcConn, err := grpc.Dial(agent.Address, grpc.WithTransportCredentials(creds))
+	// Setup new connection. The interceptors propagate the trace context
+	// to the agent in the gRPC metadata.
+	grpcConn, err := grpc.Dial(agent.Address,
+		grpc.WithTransportCredentials(creds),
+		grpc.WithUnaryInterceptor(otelgrpc.UnaryClientInterceptor()),
+		grpc.WithStreamInterceptor(otelgrpc.StreamClientInterceptor()),
+	)
 	if err != nil {
 		return errors.Wrapf(err, "problem with dial to agent %s", agent.Address)
 	}
@@ -129,17 +175,61 @@ func (agent *Agent) MakeGrpcConnection(caCertPEM, serverCertPEM, serverKeyPEM []
 	return nil
 }
 
+// Returns the client used to communicate with the agent.
+func (agent *Agent) getClient() agentapi.AgentClient {
+	agent.connMutex.Lock()
+	defer agent.connMutex.Unlock()
+	return agent.Client
+}
+
+// Checks if the agent is reached over the tunnel opened by the agent.
+func (agent *Agent) usesTunnel() bool {
+	agent.connMutex.Lock()
+	defer agent.connMutex.Unlock()
+	return agent.tunnel != nil
+}
+
+// Re-establishes the gRPC connection to the agent after a call using the
+// specified client failed. The connection is not re-established if it has
+// been already done by another call in the meantime.
+func (agent *Agent) reconnect(failedClient agentapi.AgentClient, caCertPEM, serverCertPEM, serverKeyPEM []byte) error {
+	agent.connMutex.Lock()
+	defer agent.connMutex.Unlock()
+	if agent.Client != failedClient {
+		return nil
+	}
+	return agent.MakeGrpcConnection(caCertPEM, serverCertPEM, serverKeyPEM)
+}
+
+// Use the tunnel opened by the agent to communicate with it. The gRPC
+// connection to the agent, if any, is closed.
+func (agent *Agent) useTunnel(tunnel *agentTunnel) {
+	if agent.GrpcConn != nil {
+		agent.GrpcConn.Close()
+		agent.GrpcConn = nil
+	}
+	agent.Client = agentapi.NewAgentClient(tunnel)
+	agent.tunnel = tunnel
+}
+
 // Interface for interacting with Agents via gRPC.
 type ConnectedAgents interface {
 	Shutdown()
 	GetConnectedAgent(address string) (*Agent, error)
 	GetConnectedAgentStats(adddress string, port int64) *AgentStats
 	Ping(ctx context.Context, address string, agentPort int64) error
-	GetState(ctx context.Context, address string, agentPort int64) (*State, error)
+	GetState(ctx context.Context, address string, agentPort int64, renewCert bool) (*State, error)
+	RenewCertificate(ctx context.Context, address string, agentPort int64, certPEM, caCertPEM []byte) error
+	GetTLSCerts() (caCertPEM, serverCertPEM []byte)
+	RevokeCerts(certs ...dbmodel.RevokedCert)
 	ForwardRndcCommand(ctx context.Context, dbApp *dbmodel.App, command string) (*RndcOutput, error)
 	ForwardToNamedStats(ctx context.Context, agentAddress string, agentPort int64, statsAddress string, statsPort int64, path string, statsOutput interface{}) error
 	ForwardToKeaOverHTTP(ctx context.Context, dbApp *dbmodel.App, commands []*keactrl.Command, cmdResponses ...interface{}) (*KeaCmdsResult, error)
 	TailTextFile(ctx context.Context, agentAddress string, agentPort int64, path string, offset int64) ([]string, error)
+	FollowTextFile(ctx context.Context, agentAddress string, agentPort int64, path string, offset int64, handler func(lines []string) error) error
+	SearchTextFile(ctx context.Context, agentAddress string, agentPort int64, path string, criteria *TextFileSearchCriteria, handler func(matches []*TextFileMatch) error) (int64, error)
+	GetQueueStats() map[string]AgentQueueStats
+	GetCommErrorStats() map[string][]AgentCommErrorStats
 }
 
 // Agents management map. It tracks Agents currently connected to the Server.
@@ -147,55 +237,94 @@ type connectedAgentsData struct {
 	Settings      *AgentsSettings
 	EventCenter   eventcenter.EventCenter
 	AgentsMap     map[string]*Agent
-	CommLoopReqs  chan *commLoopReq
-	DoneCommLoop  chan bool
+	agentsMutex   *sync.Mutex
 	Wg            *sync.WaitGroup
 	serverCertPEM []byte
 	serverKeyPEM  []byte
 	caCertPEM     []byte
+	// Agent certs revoked by the server.
+	revokedCerts *revokedCerts
+	// Tunnels opened by the agents, indexed by the agent address and port.
+	tunnels      map[string]*agentTunnel
+	tunnelsMutex *sync.Mutex
+	// Queues of the requests to the agents, indexed by the agent address
+	// and port.
+	queues      map[string]*agentQueue
+	queuesMutex *sync.Mutex
+	// Duration after which the unused queue is removed.
+	queueIdleTimeout time.Duration
+	// Limits the number of concurrent calls to all agents.
+	callSlots chan struct{}
+	// Closed when the communication with the agents is stopped.
+	done chan struct{}
 }
 
 // Create new ConnectedAgents objects.
 func NewConnectedAgents(settings *AgentsSettings, eventCenter eventcenter.EventCenter, caCertPEM, serverCertPEM, serverKeyPEM []byte) ConnectedAgents {
 	agents := connectedAgentsData{
-		Settings:      settings,
-		EventCenter:   eventCenter,
-		AgentsMap:     make(map[string]*Agent),
-		CommLoopReqs:  make(chan *commLoopReq),
-		DoneCommLoop:  make(chan bool),
-		Wg:            &sync.WaitGroup{},
-		caCertPEM:     caCertPEM,
-		serverCertPEM: serverCertPEM,
-		serverKeyPEM:  serverKeyPEM,
+		Settings:         settings,
+		EventCenter:      eventCenter,
+		AgentsMap:        make(map[string]*Agent),
+		agentsMutex:      &sync.Mutex{},
+		Wg:               &sync.WaitGroup{},
+		caCertPEM:        caCertPEM,
+		serverCertPEM:    serverCertPEM,
+		serverKeyPEM:     serverKeyPEM,
+		revokedCerts:     newRevokedCerts(),
+		tunnels:          make(map[string]*agentTunnel),
+		tunnelsMutex:     &sync.Mutex{},
+		queues:           make(map[string]*agentQueue),
+		queuesMutex:      &sync.Mutex{},
+		queueIdleTimeout: agentQueueIdleTimeout,
+		callSlots:        make(chan struct{}, settings.maxCalls()),
+		done:             make(chan struct{}),
 	}
 
-	agents.Wg.Add(1)
-	go agents.communicationLoop()
-
 	return &agents
 }
 
 // Shutdown agents in agents map.
 func (agents *connectedAgentsData) Shutdown() {
 	log.Printf("Stopping communication with agents")
+	agents.stopQueues()
+
+	agents.agentsMutex.Lock()
+	defer agents.agentsMutex.Unlock()
 	for _, agent := range agents.AgentsMap {
-		agent.GrpcConn.Close()
+		if agent.GrpcConn != nil {
+			agent.GrpcConn.Close()
+		}
 	}
-
-	close(agents.CommLoopReqs)
-	agents.DoneCommLoop <- true
-	agents.Wg.Wait()
 	log.Printf("Stopped communication with agents")
 }
 
-// Get Agent object by its address.
+// Get Agent object by its address. If the agent has opened the tunnel
+// to the server then the tunnel is used instead of the gRPC connection.
 func (agents *connectedAgentsData) GetConnectedAgent(address string) (*Agent, error) {
+	tunnel := agents.getTunnel(address)
+
+	agents.agentsMutex.Lock()
+	defer agents.agentsMutex.Unlock()
+
 	// Look for agent in Agents map and if found then return it
 	agent, ok := agents.AgentsMap[address]
 	if ok {
 		log.WithFields(log.Fields{
 			"address": address,
 		}).Info("connecting to existing agent")
+		agent.connMutex.Lock()
+		defer agent.connMutex.Unlock()
+		switch {
+		case tunnel != nil && agent.tunnel != tunnel:
+			// The agent opened a new tunnel.
+			agent.useTunnel(tunnel)
+		case tunnel == nil && agent.tunnel != nil:
+			// The tunnel has been closed, so try to connect to the agent.
+			agent.tunnel = nil
+			if err := agent.MakeGrpcConnection(agents.caCertPEM, agents.serverCertPEM, agents.serverKeyPEM); err != nil {
+				return nil, err
+			}
+		}
 		return agent, nil
 	}
 
@@ -203,10 +332,16 @@ func (agents *connectedAgentsData) GetConnectedAgent(address string) (*Agent, er
 	agent = new(Agent)
 	agent.Address = address
 	agent.Stats.AppCommStats = make(map[AppCommStatsKey]interface{})
+	agent.Stats.CircuitBreaker = NewCircuitBreaker()
 	agent.Stats.mutex = new(sync.Mutex)
-	err := agent.MakeGrpcConnection(agents.caCertPEM, agents.serverCertPEM, agents.serverKeyPEM)
-	if err != nil {
-		return nil, err
+	agent.revokedCerts = agents.revokedCerts
+	if tunnel != nil {
+		agent.useTunnel(tunnel)
+	} else {
+		err := agent.MakeGrpcConnection(agents.caCertPEM, agents.serverCertPEM, agents.serverKeyPEM)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	// Store it in Agents map
@@ -218,6 +353,114 @@ func (agents *connectedAgentsData) GetConnectedAgent(address string) (*Agent, er
 	return agent, nil
 }
 
+// Returns the agent with the specified address if it has been already
+// connected.
+func (agents *connectedAgentsData) lookupAgent(address string) (*Agent, bool) {
+	agents.agentsMutex.Lock()
+	defer agents.agentsMutex.Unlock()
+	agent, ok := agents.AgentsMap[address]
+	return agent, ok
+}
+
+// Returns the tunnel opened by the agent with the specified address
+// or nil if the agent has not opened the tunnel.
+func (agents *connectedAgentsData) getTunnel(address string) *agentTunnel {
+	agents.tunnelsMutex.Lock()
+	defer agents.tunnelsMutex.Unlock()
+	return agents.tunnels[address]
+}
+
+// Remembers the tunnel opened by the agent with the specified address.
+// The tunnel previously opened by this agent is closed.
+func (agents *connectedAgentsData) addTunnel(address string, tunnel *agentTunnel) {
+	agents.tunnelsMutex.Lock()
+	defer agents.tunnelsMutex.Unlock()
+	if previous, ok := agents.tunnels[address]; ok {
+		previous.close()
+	}
+	agents.tunnels[address] = tunnel
+}
+
+// Forgets the tunnel opened by the agent with the specified address
+// unless the agent has opened another tunnel in the meantime.
+func (agents *connectedAgentsData) removeTunnel(address string, tunnel *agentTunnel) {
+	agents.tunnelsMutex.Lock()
+	defer agents.tunnelsMutex.Unlock()
+	if agents.tunnels[address] == tunnel {
+		delete(agents.tunnels, address)
+	}
+}
+
+// Number of current (consecutive) errors in communication over a particular
+// channel, i.e. with the agent itself or with the app behind the agent.
+type AgentCommErrorStats struct {
+	// Address and port of the app access point. It is empty for the
+	// errors in communication with the agent.
+	App string
+	// Communication channel: agent, ca, rndc, stats or the name of the
+	// Kea daemon.
+	Channel string
+	Errors  int64
+}
+
+// Returns the current errors in communication with the connected agents
+// and the apps behind them indexed by the agent address and port.
+func (agents *connectedAgentsData) GetCommErrorStats() map[string][]AgentCommErrorStats {
+	agents.agentsMutex.Lock()
+	agentsMap := make(map[string]*Agent, len(agents.AgentsMap))
+	for address, agent := range agents.AgentsMap {
+		agentsMap[address] = agent
+	}
+	agents.agentsMutex.Unlock()
+
+	stats := make(map[string][]AgentCommErrorStats)
+	for address, agent := range agentsMap {
+		stats[address] = agent.Stats.getCommErrorStats()
+	}
+	return stats
+}
+
+// Returns the current errors in communication with the agent and the
+// apps behind it.
+func (stats *AgentStats) getCommErrorStats() []AgentCommErrorStats {
+	stats.mutex.Lock()
+	defer stats.mutex.Unlock()
+
+	errorStats := []AgentCommErrorStats{{
+		Channel: "agent",
+		Errors:  stats.CurrentErrors,
+	}}
+	for key, appStatsIfc := range stats.AppCommStats {
+		app := fmt.Sprintf("%s:%d", key.Address, key.Port)
+		switch appStats := appStatsIfc.(type) {
+		case *AgentKeaCommStats:
+			errorStats = append(errorStats, AgentCommErrorStats{
+				App:     app,
+				Channel: "ca",
+				Errors:  appStats.CurrentErrorsCA,
+			})
+			for daemonName, count := range appStats.CurrentErrorsDaemons {
+				errorStats = append(errorStats, AgentCommErrorStats{
+					App:     app,
+					Channel: daemonName,
+					Errors:  count,
+				})
+			}
+		case *AgentBind9CommStats:
+			errorStats = append(errorStats, AgentCommErrorStats{
+				App:     app,
+				Channel: "rndc",
+				Errors:  appStats.CurrentErrorsRNDC,
+			}, AgentCommErrorStats{
+				App:     app,
+				Channel: "stats",
+				Errors:  appStats.CurrentErrorsStats,
+			})
+		}
+	}
+	return errorStats
+}
+
 // Returns statistics for the connected agent. The statistics include number
 // of errors to communicate with the agent and the number of errors to
 // communicate with the apps behind the agent.
@@ -225,7 +468,7 @@ func (agents *connectedAgentsData) GetConnectedAgentStats(address string, port i
 	if port != 0 {
 		address = fmt.Sprintf("%s:%d", address, port)
 	}
-	if agent, ok := agents.AgentsMap[address]; ok {
+	if agent, ok := agents.lookupAgent(address); ok {
 		return &agent.Stats
 	}
 	return nil
@@ -214,9 +214,42 @@ func TestConnectingToAgent(t *testing.T) {
 	require.EqualValues(t, 1, agent.Stats.CurrentErrors)
 }
 
+// Test that the current errors in communication with the agents and the
+// apps behind them are returned.
+func TestGetCommErrorStats(t *testing.T) {
+	settings := AgentsSettings{}
+	fec := &storktest.FakeEventCenter{}
+	agents := NewConnectedAgents(&settings, fec, CACertPEM, ServerCertPEM, ServerKeyPEM)
+	defer agents.Shutdown()
+
+	agent, err := agents.GetConnectedAgent("127.0.0.1:8080")
+	require.NoError(t, err)
+	agent.Stats.CurrentErrors = 2
+
+	keaCommStats := newAgentKeaCommStats()
+	keaCommStats.CurrentErrorsCA = 3
+	keaCommStats.CurrentErrorsDaemons["dhcp4"] = 4
+	agent.Stats.AppCommStats[AppCommStatsKey{"192.0.2.1", 8000}] = keaCommStats
+
+	bind9CommStats := newAgentBind9CommStats()
+	bind9CommStats.CurrentErrorsRNDC = 5
+	bind9CommStats.CurrentErrorsStats = 6
+	agent.Stats.AppCommStats[AppCommStatsKey{"192.0.2.2", 953}] = bind9CommStats
+
+	stats := agents.GetCommErrorStats()
+	require.Len(t, stats, 1)
+	require.ElementsMatch(t, []AgentCommErrorStats{
+		{Channel: "agent", Errors: 2},
+		{App: "192.0.2.1:8000", Channel: "ca", Errors: 3},
+		{App: "192.0.2.1:8000", Channel: "dhcp4", Errors: 4},
+		{App: "192.0.2.2:953", Channel: "rndc", Errors: 5},
+		{App: "192.0.2.2:953", Channel: "stats", Errors: 6},
+	}, stats["127.0.0.1:8080"])
+}
+
 // Check if credentials for TLS can be prepared using prepareTLSCreds.
 func TestPrepareTLSCreds(t *testing.T) {
-	creds, err := prepareTLSCreds(CACertPEM, ServerCertPEM, ServerKeyPEM)
+	creds, err := prepareTLSCreds(CACertPEM, ServerCertPEM, ServerKeyPEM, newRevokedCerts())
 	require.NoError(t, err)
 	require.NotNil(t, creds)
 }
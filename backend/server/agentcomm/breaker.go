@@ -0,0 +1,172 @@
+package agentcomm
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Default circuit breaker settings. They are used when the settings
+// don't specify them.
+const (
+	defaultCircuitBreakerThreshold  = 3
+	defaultCircuitBreakerMinBackoff = 10 * time.Second
+	defaultCircuitBreakerMaxBackoff = 10 * time.Minute
+)
+
+// State of the circuit breaker.
+type CircuitState string
+
+// The circuit is closed when the communication works and the requests
+// are sent. It is open when the communication failed several times in
+// a row and the requests are not sent until the backoff time elapses.
+// It is half-open when the backoff time elapsed and a single probing
+// request is sent to check if the communication has been restored.
+const (
+	CircuitClosed   CircuitState = "closed"
+	CircuitOpen     CircuitState = "open"
+	CircuitHalfOpen CircuitState = "half-open"
+)
+
+// Returns the number of consecutive failures opening the circuit.
+func (settings *AgentsSettings) circuitBreakerThreshold() int64 {
+	if settings.CircuitBreakerThreshold <= 0 {
+		return defaultCircuitBreakerThreshold
+	}
+	return int64(settings.CircuitBreakerThreshold)
+}
+
+// Returns the time after which the first probing request is sent.
+func (settings *AgentsSettings) circuitBreakerMinBackoff() time.Duration {
+	if settings.CircuitBreakerMinBackoff <= 0 {
+		return defaultCircuitBreakerMinBackoff
+	}
+	return settings.CircuitBreakerMinBackoff
+}
+
+// Returns the maximum time between the probing requests.
+func (settings *AgentsSettings) circuitBreakerMaxBackoff() time.Duration {
+	switch {
+	case settings.CircuitBreakerMaxBackoff <= 0:
+		return defaultCircuitBreakerMaxBackoff
+	case settings.CircuitBreakerMaxBackoff < settings.circuitBreakerMinBackoff():
+		return settings.circuitBreakerMinBackoff()
+	default:
+		return settings.CircuitBreakerMaxBackoff
+	}
+}
+
+// Circuit breaker protecting the agents and the daemons which stopped
+// responding against the requests which would most likely fail. After
+// a number of consecutive failures the circuit opens and the requests
+// are rejected without sending them. When the backoff time elapses a
+// single probing request is sent. If it succeeds the circuit closes.
+// Otherwise, it opens again with the doubled backoff time. The failures
+// of the requests sent before the circuit opened are ignored while it
+// is open. The circuit breaker is not thread safe. It is protected by the mutex of the agent
+// statistics it belongs to.
+type CircuitBreaker struct {
+	State               CircuitState
+	ConsecutiveFailures int64
+	// Time when the circuit was opened after it had been closed.
+	OpenedAt time.Time
+	// Time when the next probing request is sent.
+	RetryAt time.Time
+	backoff time.Duration
+	// Time when the circuit was opened most recently, either after it
+	// had been closed or after the failed probing request.
+	lastOpenedAt time.Time
+}
+
+// Creates the closed circuit breaker.
+func NewCircuitBreaker() *CircuitBreaker {
+	return &CircuitBreaker{
+		State: CircuitClosed,
+	}
+}
+
+// Checks if the request may be sent. When the circuit is open and the
+// backoff time elapsed, the circuit becomes half-open and the request
+// is allowed as the probing request. Other requests are rejected until
+// the result of the probing request is recorded. If the result is not
+// recorded within the minimum backoff time, e.g. because the probing
+// request has been cancelled, another probing request is allowed.
+func (cb *CircuitBreaker) allowRequest(now time.Time, settings *AgentsSettings) bool {
+	switch cb.State {
+	case CircuitOpen, CircuitHalfOpen:
+		if now.Before(cb.RetryAt) {
+			return false
+		}
+		cb.State = CircuitHalfOpen
+		cb.RetryAt = now.Add(settings.circuitBreakerMinBackoff())
+		return true
+	default:
+		return true
+	}
+}
+
+// Records the successful request. It returns true if the circuit has
+// been closed as a result.
+func (cb *CircuitBreaker) recordSuccess() bool {
+	wasClosed := cb.State == CircuitClosed || cb.State == ""
+	cb.State = CircuitClosed
+	cb.ConsecutiveFailures = 0
+	cb.OpenedAt = time.Time{}
+	cb.RetryAt = time.Time{}
+	cb.backoff = 0
+	cb.lastOpenedAt = time.Time{}
+	return !wasClosed
+}
+
+// Records the failed request sent at the specified time. It returns true
+// if the circuit has been opened as a result after it had been closed.
+// When the probing request fails the circuit opens again with the doubled
+// backoff time, but the function returns false. The failure of the request
+// sent before the circuit opened is ignored while the circuit is open, so
+// the requests which were in flight when the circuit opened don't extend
+// the backoff time.
+func (cb *CircuitBreaker) recordFailure(startedAt, now time.Time, settings *AgentsSettings) bool {
+	switch cb.State {
+	case CircuitHalfOpen, CircuitOpen:
+		if startedAt.Before(cb.lastOpenedAt) {
+			return false
+		}
+		cb.ConsecutiveFailures++
+		cb.backoff *= 2
+		if cb.backoff > settings.circuitBreakerMaxBackoff() {
+			cb.backoff = settings.circuitBreakerMaxBackoff()
+		}
+		cb.State = CircuitOpen
+		cb.RetryAt = now.Add(cb.backoff)
+		cb.lastOpenedAt = now
+		return false
+	default:
+		cb.ConsecutiveFailures++
+		if cb.ConsecutiveFailures < settings.circuitBreakerThreshold() {
+			cb.State = CircuitClosed
+			return false
+		}
+		cb.backoff = settings.circuitBreakerMinBackoff()
+		cb.State = CircuitOpen
+		cb.OpenedAt = now
+		cb.RetryAt = now.Add(cb.backoff)
+		cb.lastOpenedAt = now
+		return true
+	}
+}
+
+// Returns the error indicating that the request has not been sent
+// because the circuit is open.
+func (cb *CircuitBreaker) openError(target string) error {
+	return errors.Errorf("communication with %s suspended after repeated failures until %s",
+		target, cb.RetryAt.UTC().Format(time.RFC3339))
+}
+
+// Returns the state of the circuit breaker or the closed state if the
+// circuit breaker doesn't exist.
+func (cb *CircuitBreaker) GetState() CircuitState {
+	if cb == nil || cb.State == "" {
+		return CircuitClosed
+	}
+	return cb.State
+}
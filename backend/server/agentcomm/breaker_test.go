@@ -0,0 +1,140 @@
+package agentcomm
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Test that the default circuit breaker settings are used when they are
+// not specified.
+func TestCircuitBreakerSettingsDefaults(t *testing.T) {
+	settings := &AgentsSettings{}
+	require.EqualValues(t, defaultCircuitBreakerThreshold, settings.circuitBreakerThreshold())
+	require.Equal(t, defaultCircuitBreakerMinBackoff, settings.circuitBreakerMinBackoff())
+	require.Equal(t, defaultCircuitBreakerMaxBackoff, settings.circuitBreakerMaxBackoff())
+
+	// The maximum backoff must not be lower than the minimum backoff.
+	settings = &AgentsSettings{
+		CircuitBreakerMinBackoff: time.Minute,
+		CircuitBreakerMaxBackoff: time.Second,
+	}
+	require.Equal(t, time.Minute, settings.circuitBreakerMaxBackoff())
+}
+
+// Test that the circuit opens after the configured number of consecutive
+// failures and closes after the successful probing request.
+func TestCircuitBreakerOpenAndClose(t *testing.T) {
+	settings := &AgentsSettings{
+		CircuitBreakerThreshold:  2,
+		CircuitBreakerMinBackoff: time.Minute,
+	}
+	cb := NewCircuitBreaker()
+	now := time.Date(2022, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	require.True(t, cb.allowRequest(now, settings))
+	require.False(t, cb.recordFailure(now, now, settings))
+	require.Equal(t, CircuitClosed, cb.GetState())
+
+	// The success resets the failures counter.
+	require.False(t, cb.recordSuccess())
+	require.Zero(t, cb.ConsecutiveFailures)
+
+	require.False(t, cb.recordFailure(now, now, settings))
+	require.True(t, cb.recordFailure(now, now, settings))
+	require.Equal(t, CircuitOpen, cb.GetState())
+	require.Equal(t, now, cb.OpenedAt)
+	require.Equal(t, now.Add(time.Minute), cb.RetryAt)
+
+	// The requests are rejected until the backoff time elapses.
+	require.False(t, cb.allowRequest(now.Add(30*time.Second), settings))
+	require.Contains(t, cb.openError("the agent").Error(), "the agent")
+
+	// Only one probing request is allowed.
+	require.True(t, cb.allowRequest(now.Add(time.Minute), settings))
+	require.Equal(t, CircuitHalfOpen, cb.GetState())
+	require.False(t, cb.allowRequest(now.Add(time.Minute), settings))
+
+	require.True(t, cb.recordSuccess())
+	require.Equal(t, CircuitClosed, cb.GetState())
+	require.True(t, cb.allowRequest(now.Add(time.Minute), settings))
+}
+
+// Test that the backoff time is doubled when the probing request fails
+// and that it doesn't exceed the maximum backoff.
+func TestCircuitBreakerBackoff(t *testing.T) {
+	settings := &AgentsSettings{
+		CircuitBreakerThreshold:  1,
+		CircuitBreakerMinBackoff: time.Minute,
+		CircuitBreakerMaxBackoff: 3 * time.Minute,
+	}
+	cb := NewCircuitBreaker()
+	now := time.Date(2022, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	require.True(t, cb.recordFailure(now, now, settings))
+	require.Equal(t, now.Add(time.Minute), cb.RetryAt)
+
+	now = cb.RetryAt
+	require.True(t, cb.allowRequest(now, settings))
+	require.False(t, cb.recordFailure(now, now, settings))
+	require.Equal(t, CircuitOpen, cb.GetState())
+	require.Equal(t, now.Add(2*time.Minute), cb.RetryAt)
+
+	now = cb.RetryAt
+	require.True(t, cb.allowRequest(now, settings))
+	require.False(t, cb.recordFailure(now, now, settings))
+	require.Equal(t, now.Add(3*time.Minute), cb.RetryAt)
+}
+
+// Test that the failures of the requests sent before the circuit opened
+// don't extend the backoff time while the circuit is open.
+func TestCircuitBreakerInFlightFailures(t *testing.T) {
+	settings := &AgentsSettings{
+		CircuitBreakerThreshold:  1,
+		CircuitBreakerMinBackoff: time.Minute,
+	}
+	cb := NewCircuitBreaker()
+	startedAt := time.Date(2022, 1, 1, 12, 0, 0, 0, time.UTC)
+	now := startedAt.Add(time.Second)
+
+	require.True(t, cb.recordFailure(startedAt, now, settings))
+	require.Equal(t, now.Add(time.Minute), cb.RetryAt)
+
+	// The requests sent together with the one which opened the circuit
+	// fail later.
+	require.False(t, cb.recordFailure(startedAt, now.Add(time.Second), settings))
+	require.False(t, cb.recordFailure(startedAt, now.Add(2*time.Second), settings))
+	require.Equal(t, CircuitOpen, cb.GetState())
+	require.Equal(t, now.Add(time.Minute), cb.RetryAt)
+	require.EqualValues(t, 1, cb.ConsecutiveFailures)
+
+	// The failed probing request doubles the backoff time.
+	now = cb.RetryAt
+	require.True(t, cb.allowRequest(now, settings))
+	require.False(t, cb.recordFailure(now, now.Add(time.Second), settings))
+	require.Equal(t, now.Add(time.Second).Add(2*time.Minute), cb.RetryAt)
+	require.EqualValues(t, 2, cb.ConsecutiveFailures)
+}
+
+// Test that another probing request is allowed when the result of the
+// previous one has not been recorded.
+func TestCircuitBreakerLostProbe(t *testing.T) {
+	settings := &AgentsSettings{
+		CircuitBreakerThreshold:  1,
+		CircuitBreakerMinBackoff: time.Minute,
+	}
+	cb := NewCircuitBreaker()
+	now := time.Date(2022, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	require.True(t, cb.recordFailure(now, now, settings))
+	require.True(t, cb.allowRequest(now.Add(time.Minute), settings))
+	require.False(t, cb.allowRequest(now.Add(90*time.Second), settings))
+	require.True(t, cb.allowRequest(now.Add(2*time.Minute), settings))
+}
+
+// Test that the missing circuit breaker is reported as closed.
+func TestCircuitBreakerNilState(t *testing.T) {
+	var cb *CircuitBreaker
+	require.Equal(t, CircuitClosed, cb.GetState())
+}
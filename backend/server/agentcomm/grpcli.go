@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"compress/gzip"
 	"context"
+	stderrors "errors"
 	"fmt"
 	"io"
 	"net"
@@ -14,10 +15,12 @@ import (
 
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
 
 	agentapi "isc.org/stork/api"
 	keactrl "isc.org/stork/appctrl/kea"
 	dbmodel "isc.org/stork/server/database/model"
+	"isc.org/stork/tracing"
 	storkutil "isc.org/stork/util"
 )
 
@@ -31,10 +34,11 @@ type AccessPoint struct {
 	UseSecureProtocol bool
 }
 
-// Currently supported types are: "control" and "statistics".
+// Currently supported types are: "control", "control-socket" and "statistics".
 const (
-	AccessPointControl    = "control"
-	AccessPointStatistics = "statistics"
+	AccessPointControl       = "control"
+	AccessPointControlSocket = "control-socket"
+	AccessPointStatistics    = "statistics"
 )
 
 type App struct {
@@ -71,6 +75,11 @@ type State struct {
 	LastVisitedAt        time.Time
 	Error                string
 	Apps                 []*App
+	// Expiration time of the agent certificate. It is zero if the agent
+	// doesn't report it.
+	AgentCertNotAfter time.Time
+	// CSR sent by the agent when its certificate is about to expire.
+	AgentCertRenewalCSR []byte
 }
 
 // MakeAccessPoint is an utility to make an array of one access point.
@@ -88,7 +97,7 @@ func (agents *connectedAgentsData) Ping(ctx context.Context, address string, age
 	addrPort := net.JoinHostPort(address, strconv.FormatInt(agentPort, 10))
 
 	// Call agent for version.
-	resp, err := agents.sendAndRecvViaQueue(addrPort, &agentapi.PingReq{})
+	resp, err := agents.sendAndRecvViaQueue(ctx, addrPort, &agentapi.PingReq{})
 	if err != nil {
 		return errors.Wrapf(err, "failed to ping agent %s", addrPort)
 	}
@@ -98,12 +107,17 @@ func (agents *connectedAgentsData) Ping(ctx context.Context, address string, age
 	return nil
 }
 
-// Get version from agent.
-func (agents *connectedAgentsData) GetState(ctx context.Context, address string, agentPort int64) (*State, error) {
+// Get version from agent. If renewCert is true, the agent is requested
+// to return the CSR for the renewal of its certificate. The serial numbers
+// of the revoked certs are sent to the agent.
+func (agents *connectedAgentsData) GetState(ctx context.Context, address string, agentPort int64, renewCert bool) (*State, error) {
 	addrPort := net.JoinHostPort(address, strconv.FormatInt(agentPort, 10))
 
 	// Call agent for version.
-	resp, err := agents.sendAndRecvViaQueue(addrPort, &agentapi.GetStateReq{})
+	resp, err := agents.sendAndRecvViaQueue(ctx, addrPort, &agentapi.GetStateReq{
+		RenewCert:                renewCert,
+		RevokedCertSerialNumbers: agents.revokedCerts.getSerialNumbers(),
+	})
 	if err != nil {
 		return nil, errors.Wrapf(err, "failed to get state from agent %s", addrPort)
 	}
@@ -151,10 +165,101 @@ func (agents *connectedAgentsData) GetState(ctx context.Context, address string,
 		Error:                grpcState.Error,
 		Apps:                 apps,
 	}
+	if grpcState.CertNotAfter != 0 {
+		state.AgentCertNotAfter = time.Unix(grpcState.CertNotAfter, 0).UTC()
+	}
+	if grpcState.CertRenewalCSR != "" {
+		state.AgentCertRenewalCSR = []byte(grpcState.CertRenewalCSR)
+	}
 
 	return &state, nil
 }
 
+// Sends the renewed certificate and the trusted CA certs to the agent.
+// Any of them may be nil. The agent replaces its certificate with the
+// renewed one if it is signed by the server CA and matches the agent key.
+// The trusted CA certs are replaced during the CA rotation.
+func (agents *connectedAgentsData) RenewCertificate(ctx context.Context, address string, agentPort int64, certPEM, caCertPEM []byte) error {
+	addrPort := net.JoinHostPort(address, strconv.FormatInt(agentPort, 10))
+
+	req := &agentapi.RenewCertificateReq{
+		AgentCert:    string(certPEM),
+		ServerCACert: string(caCertPEM),
+	}
+	resp, err := agents.sendAndRecvViaQueue(ctx, addrPort, req)
+	if err != nil {
+		return errors.Wrapf(err, "failed to send renewed certificate to agent %s", addrPort)
+	}
+	response := resp.(*agentapi.RenewCertificateRsp)
+	if response.Status.Code != agentapi.Status_OK {
+		return errors.New(response.Status.Message)
+	}
+	return nil
+}
+
+// Returns the CA certs trusted by the server and the server cert used in
+// the connections to the agents.
+func (agents *connectedAgentsData) GetTLSCerts() ([]byte, []byte) {
+	return agents.caCertPEM, agents.serverCertPEM
+}
+
+// Checks if the request may be sent to BIND9 according to the state of the
+// circuit breaker. The rndc flag selects the circuit breaker of the rndc
+// channel. Otherwise, the circuit breaker of the statistics channel is
+// checked.
+func (agents *connectedAgentsData) checkBind9CircuitBreaker(addrPort string, key AppCommStatsKey, rndc bool) error {
+	agent, ok := agents.lookupAgent(addrPort)
+	if !ok {
+		return nil
+	}
+	agent.Stats.mutex.Lock()
+	defer agent.Stats.mutex.Unlock()
+	bind9CommStats, ok := agent.Stats.AppCommStats[key].(*AgentBind9CommStats)
+	if !ok {
+		return nil
+	}
+	cb, channel := bind9CommStats.CircuitBreakerStats, "statistics channel"
+	if rndc {
+		cb, channel = bind9CommStats.CircuitBreakerRNDC, "rndc"
+	}
+	if !cb.allowRequest(time.Now(), agents.Settings) {
+		return cb.openError(fmt.Sprintf("BIND9 %s at %s via the agent %s", channel, net.JoinHostPort(key.Address, strconv.FormatInt(key.Port, 10)), addrPort))
+	}
+	return nil
+}
+
+// Records the result of the communication with BIND9 started at the
+// specified time in the circuit breaker and raises the events when the
+// communication is suspended and when it is restored.
+func (agents *connectedAgentsData) recordBind9Result(cb *CircuitBreaker, startedAt time.Time, addrPort string, key AppCommStatsKey, channel string, failed bool) {
+	target := fmt.Sprintf("BIND9 %s at %s via the agent %s", channel, net.JoinHostPort(key.Address, strconv.FormatInt(key.Port, 10)), addrPort)
+	if failed {
+		if cb.recordFailure(startedAt, time.Now(), agents.Settings) {
+			log.WithFields(log.Fields{
+				"agent":   addrPort,
+				"retryAt": cb.RetryAt,
+			}).Warnf("communication with %s suspended", target)
+			agents.EventCenter.AddErrorEvent(fmt.Sprintf("communication with %s suspended after repeated failures", target))
+		}
+		return
+	}
+	if cb.recordSuccess() {
+		agents.EventCenter.AddWarningEvent(fmt.Sprintf("suspended communication with %s restored", target))
+	}
+}
+
+// Checks if the error message returned by rndc indicates that rndc failed
+// to communicate with BIND9 rather than that BIND9 failed to execute the
+// command.
+func isRndcConnectionError(message string) bool {
+	for _, text := range []string{"connect failed", "connection to remote host closed", "recv failed", "send failed", "timed out"} {
+		if strings.Contains(message, text) {
+			return true
+		}
+	}
+	return false
+}
+
 type RndcOutput struct {
 	Output string
 	Error  error
@@ -181,10 +286,16 @@ func (agents *connectedAgentsData) ForwardRndcCommand(ctx context.Context, dbApp
 		},
 	}
 
+	// Don't send the command if BIND9 has been failing recently.
+	if err = agents.checkBind9CircuitBreaker(addrPort, AppCommStatsKey{ctrlPoint.Address, ctrlPoint.Port}, true); err != nil {
+		return nil, err
+	}
+
 	// Send the command to the Stork Agent.
-	resp, err := agents.sendAndRecvViaQueue(addrPort, req)
+	startedAt := time.Now()
+	resp, err := agents.sendAndRecvViaQueue(ctx, addrPort, req)
 	if err != nil {
-		if agent, agentExists := agents.AgentsMap[addrPort]; agentExists {
+		if agent, agentExists := agents.lookupAgent(addrPort); agentExists {
 			agent.Stats.mutex.Lock()
 			defer agent.Stats.mutex.Unlock()
 			if agent.Stats.CurrentErrors == 1 {
@@ -217,10 +328,14 @@ func (agents *connectedAgentsData) ForwardRndcCommand(ctx context.Context, dbApp
 		Output: "",
 		Error:  nil,
 	}
+	// Only the failures to communicate with BIND9 are recorded in the
+	// circuit breaker. The failed commands are not.
+	connFailed := false
 	if err == nil {
 		rndcResponse := response.GetRndcResponse()
 		if rndcResponse.Status.Code != agentapi.Status_OK {
 			result.Error = errors.New(response.Status.Message)
+			connFailed = isRndcConnectionError(rndcResponse.Status.Message)
 		} else {
 			result.Output = rndcResponse.Response
 		}
@@ -238,7 +353,7 @@ func (agents *connectedAgentsData) ForwardRndcCommand(ctx context.Context, dbApp
 
 	// Start updating error statistics for this agent and the BIND9 app we've
 	// been communicating with.
-	agent, agentExists := agents.AgentsMap[addrPort]
+	agent, agentExists := agents.lookupAgent(addrPort)
 	if agentExists {
 		// This function may be called by multiple goroutines, so we need to make
 		// sure that the statistics update is safe in terms of concurrent access.
@@ -249,9 +364,10 @@ func (agents *connectedAgentsData) ForwardRndcCommand(ctx context.Context, dbApp
 		// If not, this is first time we communicate with this endpoint.
 		bind9CommStats, bind9CommStatsExist := agent.Stats.AppCommStats[AppCommStatsKey{ctrlPoint.Address, ctrlPoint.Port}].(*AgentBind9CommStats)
 		if !bind9CommStatsExist {
-			bind9CommStats = &AgentBind9CommStats{}
+			bind9CommStats = newAgentBind9CommStats()
 			agent.Stats.AppCommStats[AppCommStatsKey{ctrlPoint.Address, ctrlPoint.Port}] = bind9CommStats
 		}
+		agents.recordBind9Result(bind9CommStats.CircuitBreakerRNDC, startedAt, addrPort, AppCommStatsKey{ctrlPoint.Address, ctrlPoint.Port}, "rndc", connFailed)
 		if err != nil || result.Error != nil {
 			bind9CommStats.CurrentErrorsRNDC++
 			// This is not the first tie the BIND9 RNDC is not responding, so let's
@@ -290,10 +406,16 @@ func (agents *connectedAgentsData) ForwardToNamedStats(ctx context.Context, agen
 		Request: "",
 	}
 
+	// Don't send the request if BIND9 has been failing recently.
+	if err := agents.checkBind9CircuitBreaker(addrPort, AppCommStatsKey{statsAddress, statsPort}, false); err != nil {
+		return err
+	}
+
 	// Send the commands to the Stork Agent.
-	storkRsp, err := agents.sendAndRecvViaQueue(addrPort, storkReq)
+	startedAt := time.Now()
+	storkRsp, err := agents.sendAndRecvViaQueue(ctx, addrPort, storkReq)
 	if err != nil {
-		if agent, agentExists := agents.AgentsMap[addrPort]; agentExists {
+		if agent, agentExists := agents.lookupAgent(addrPort); agentExists {
 			agent.Stats.mutex.Lock()
 			defer agent.Stats.mutex.Unlock()
 			if agent.Stats.CurrentErrors == 1 {
@@ -317,9 +439,13 @@ func (agents *connectedAgentsData) ForwardToNamedStats(ctx context.Context, agen
 	}
 	fdRsp := storkRsp.(*agentapi.ForwardToNamedStatsRsp)
 
+	// Only the failures to communicate with BIND9 are recorded in the
+	// circuit breaker. The invalid responses are not.
 	statsRsp := fdRsp.NamedStatsResponse
+	connFailed := false
 	if statsRsp.Status.Code != agentapi.Status_OK {
 		err = errors.New(statsRsp.Status.Message)
+		connFailed = true
 	}
 	if err == nil {
 		// Try to parse the response from the on-wire format.
@@ -331,7 +457,7 @@ func (agents *connectedAgentsData) ForwardToNamedStats(ctx context.Context, agen
 
 	// Start updating error statistics for this agent and the BIND9 app we've
 	// been communicating with.
-	agent, agentExists := agents.AgentsMap[addrPort]
+	agent, agentExists := agents.lookupAgent(addrPort)
 	if agentExists {
 		// This function may be called by multiple goroutines, so we need to make
 		// sure that the statistics update is safe in terms of concurrent access.
@@ -342,9 +468,10 @@ func (agents *connectedAgentsData) ForwardToNamedStats(ctx context.Context, agen
 		// If not, this is first time we communicate with this endpoint.
 		bind9CommStats, bind9CommStatsExist := agent.Stats.AppCommStats[AppCommStatsKey{statsAddress, statsPort}].(*AgentBind9CommStats)
 		if !bind9CommStatsExist {
-			bind9CommStats = &AgentBind9CommStats{}
+			bind9CommStats = newAgentBind9CommStats()
 			agent.Stats.AppCommStats[AppCommStatsKey{statsAddress, statsPort}] = bind9CommStats
 		}
+		agents.recordBind9Result(bind9CommStats.CircuitBreakerStats, startedAt, addrPort, AppCommStatsKey{statsAddress, statsPort}, "statistics channel", connFailed)
 		if err != nil {
 			bind9CommStats.CurrentErrorsStats++
 			// This is not the first tie the BIND9 stats is not responding, so let's
@@ -366,19 +493,88 @@ func (agents *connectedAgentsData) ForwardToNamedStats(ctx context.Context, agen
 	return err
 }
 
+// Checks if the Kea response indicates that the Kea Control Agent failed
+// to forward the command to the daemon, e.g. because the daemon is not
+// running.
+func isKeaForwardingError(result int64, text string) bool {
+	return result == keactrl.ResponseError && strings.Contains(text, "unable to forward command")
+}
+
 type KeaCmdsResult struct {
 	Error      error
 	CmdsErrors []error
 }
 
+// Checks which commands may be sent to the Kea daemons according to the
+// state of their circuit breakers. It returns an error if the communication
+// with the Kea Control Agent is suspended. Otherwise, it returns a slice
+// with an error for each command which must not be sent because all
+// daemons it is directed to have been failing recently. The slice holds
+// nil values for the commands which may be sent.
+func (agents *connectedAgentsData) checkKeaCircuitBreakers(addrPort, caAddress string, caPort int64, commands []*keactrl.Command) ([]error, error) {
+	skipErrors := make([]error, len(commands))
+
+	agent, ok := agents.lookupAgent(addrPort)
+	if !ok {
+		return skipErrors, nil
+	}
+	agent.Stats.mutex.Lock()
+	defer agent.Stats.mutex.Unlock()
+	keaCommStats, ok := agent.Stats.AppCommStats[AppCommStatsKey{caAddress, caPort}].(*AgentKeaCommStats)
+	if !ok {
+		return skipErrors, nil
+	}
+
+	now := time.Now()
+	if !keaCommStats.CircuitBreakerCA.allowRequest(now, agents.Settings) {
+		return nil, keaCommStats.CircuitBreakerCA.openError(fmt.Sprintf("Kea at %s via the agent %s", caAddress, addrPort))
+	}
+
+	for idx, cmd := range commands {
+		daemons := []string{"ca"}
+		if cmd.Daemons != nil && len(*cmd.Daemons) > 0 {
+			daemons = cmd.Daemons.List()
+		}
+		var cbErr error
+		for _, daemon := range daemons {
+			cb, ok := keaCommStats.CircuitBreakersDaemons[daemon]
+			if !ok || cb.allowRequest(now, agents.Settings) {
+				cbErr = nil
+				break
+			}
+			cbErr = cb.openError(fmt.Sprintf("Kea %s daemon at %s via the agent %s", daemon, caAddress, addrPort))
+		}
+		skipErrors[idx] = cbErr
+	}
+	return skipErrors, nil
+}
+
 // Forwards a Kea command via the Stork Agent and Kea Control Agent and then
 // parses the response. caAddress and caPort are used to construct the URL
-// of the Kea Control Agent to which the command should be sent.
+// of the Kea Control Agent to which the command should be sent. If the app
+// has no control access point but has the control socket access point, the
+// commands are sent by the Stork Agent directly to the Kea daemon over its
+// control socket. The call is traced in its own span.
 func (agents *connectedAgentsData) ForwardToKeaOverHTTP(ctx context.Context, dbApp *dbmodel.App, commands []*keactrl.Command, cmdResponses ...interface{}) (*KeaCmdsResult, error) {
+	var commandNames []string
+	for _, cmd := range commands {
+		commandNames = append(commandNames, cmd.Command)
+	}
+	ctx, span := tracing.StartSpan(ctx, "agentcomm.ForwardToKeaOverHTTP",
+		attribute.Int64("stork.app.id", dbApp.ID),
+		attribute.StringSlice("stork.kea.commands", commandNames),
+	)
+	result, err := agents.forwardToKeaOverHTTP(ctx, dbApp, commands, cmdResponses...)
+	tracing.EndSpan(span, err)
+	return result, err
+}
+
+// Implementation of ForwardToKeaOverHTTP.
+func (agents *connectedAgentsData) forwardToKeaOverHTTP(ctx context.Context, dbApp *dbmodel.App, commands []*keactrl.Command, cmdResponses ...interface{}) (*KeaCmdsResult, error) {
 	agentAddress := dbApp.Machine.Address
 	agentPort := dbApp.Machine.AgentPort
 
-	ctrlPoint, err := dbApp.GetAccessPoint(dbmodel.AccessPointControl)
+	ctrlPoint, err := dbApp.GetControlAccessPoint()
 	if err != nil {
 		return nil, err
 	}
@@ -387,26 +583,56 @@ func (agents *connectedAgentsData) ForwardToKeaOverHTTP(ctx context.Context, dbA
 	caUseSecureProtocol := ctrlPoint.UseSecureProtocol
 
 	addrPort := net.JoinHostPort(agentAddress, strconv.FormatInt(agentPort, 10))
-	caURL := storkutil.HostWithPortURL(caAddress, caPort, caUseSecureProtocol)
 
-	// Prepare the on-wire representation of the commands.
-	fdReq := &agentapi.ForwardToKeaOverHTTPReq{
-		Url: caURL,
+	// Don't send the commands to the daemons which have been failing
+	// recently.
+	skipErrors, err := agents.checkKeaCircuitBreakers(addrPort, caAddress, caPort, commands)
+	if err != nil {
+		return nil, err
 	}
-	for _, cmd := range commands {
-		fdReq.KeaRequests = append(fdReq.KeaRequests, &agentapi.KeaRequest{
+
+	// Prepare the on-wire representation of the commands.
+	var keaRequests []*agentapi.KeaRequest
+	for idx, cmd := range commands {
+		if skipErrors[idx] != nil {
+			continue
+		}
+		keaRequests = append(keaRequests, &agentapi.KeaRequest{
 			Request: cmd.Marshal(),
 		})
 	}
+	if len(commands) > 0 && len(keaRequests) == 0 {
+		// Nothing to send.
+		return &KeaCmdsResult{CmdsErrors: skipErrors}, nil
+	}
+
+	var (
+		caURL string
+		fdReq interface{}
+	)
+	if ctrlPoint.Type == dbmodel.AccessPointControlSocket {
+		caURL = caAddress
+		fdReq = &agentapi.ForwardToKeaOverSocketReq{
+			SocketPath:  caAddress,
+			KeaRequests: keaRequests,
+		}
+	} else {
+		caURL = storkutil.HostWithPortURL(caAddress, caPort, caUseSecureProtocol)
+		fdReq = &agentapi.ForwardToKeaOverHTTPReq{
+			Url:         caURL,
+			KeaRequests: keaRequests,
+		}
+	}
 
 	// Send the commands to the Stork Agent.
-	resp, err := agents.sendAndRecvViaQueue(addrPort, fdReq)
+	startedAt := time.Now()
+	resp, err := agents.sendAndRecvViaQueue(ctx, addrPort, fdReq)
 
 	// This should always return an agent but we make this check to be safe
 	// and not panic if someone has screwed up something in the code.
 	// Concurrent access should be safe assuming that the agent has been
 	// already added to the map by the GetConnectedAgent function.
-	agent, agentExists := agents.AgentsMap[addrPort]
+	agent, agentExists := agents.lookupAgent(addrPort)
 	if !agentExists {
 		err = errors.Errorf("missing agent in agents map: %s", addrPort)
 		return nil, err
@@ -444,7 +670,7 @@ func (agents *connectedAgentsData) ForwardToKeaOverHTTP(ctx context.Context, dbA
 		log.WithFields(log.Fields{
 			"agent": addrPort,
 			"kea":   caURL,
-		}).Warnf("failed to send the following commands: %+v", fdReq.KeaRequests)
+		}).Warnf("failed to send the following commands: %+v", keaRequests)
 		return nil, err
 	}
 
@@ -453,7 +679,22 @@ func (agents *connectedAgentsData) ForwardToKeaOverHTTP(ctx context.Context, dbA
 		agents.EventCenter.AddWarningEvent("communication with stork agent on {machine} resumed", dbApp.Machine)
 	}
 
-	fdRsp := resp.(*agentapi.ForwardToKeaOverHTTPRsp)
+	// The responses to the commands sent over HTTP and over the control
+	// socket have the same contents.
+	var (
+		fdRspStatus  *agentapi.Status
+		keaResponses []*agentapi.KeaResponse
+	)
+	switch fdRsp := resp.(type) {
+	case *agentapi.ForwardToKeaOverHTTPRsp:
+		fdRspStatus = fdRsp.Status
+		keaResponses = fdRsp.GetKeaResponses()
+	case *agentapi.ForwardToKeaOverSocketRsp:
+		fdRspStatus = fdRsp.Status
+		keaResponses = fdRsp.GetKeaResponses()
+	default:
+		return nil, errors.Errorf("unexpected response type %T received from the agent %s", resp, addrPort)
+	}
 
 	// Gather errors in communication via the Kea Control
 	// Agent. It is possible to send multiple commands so there
@@ -461,24 +702,43 @@ func (agents *connectedAgentsData) ForwardToKeaOverHTTP(ctx context.Context, dbA
 	caErrorsCount := int64(0)
 	caErrorStr := ""
 
+	// Only the failures to communicate with the Kea Control Agent and
+	// the daemons are recorded in the circuit breakers. The invalid
+	// responses and the commands failed by Kea are not.
+	caConnFailed := false
+	daemonConnFailed := make(map[string]bool)
+
 	result := &KeaCmdsResult{}
 	result.Error = nil
-	if fdRsp.Status.Code != agentapi.Status_OK {
-		result.Error = errors.New(fdRsp.Status.Message)
+	if fdRspStatus.Code != agentapi.Status_OK {
+		result.Error = errors.New(fdRspStatus.Message)
 		caErrorsCount++
-		caErrorStr += "\n" + fdRsp.Status.Message
+		caErrorStr += "\n" + fdRspStatus.Message
+		caConnFailed = true
 	}
 
 	// Gather errors from daemons (including CA).
 	daemonErrorsCount := make(map[string]int64)
 
-	// Get all responses from the Kea server.
-	for idx, rsp := range fdRsp.GetKeaResponses() {
+	// Get all responses from the Kea server. The responses to the commands
+	// which have not been sent are replaced with the errors.
+	respIdx := 0
+	for idx := range commands {
+		if skipErrors[idx] != nil {
+			result.CmdsErrors = append(result.CmdsErrors, skipErrors[idx])
+			continue
+		}
+		if respIdx >= len(keaResponses) {
+			break
+		}
+		rsp := keaResponses[respIdx]
+		respIdx++
 		cmdResp := cmdResponses[idx]
 		if rsp.Status.Code != agentapi.Status_OK {
 			result.CmdsErrors = append(result.CmdsErrors, errors.New(rsp.Status.Message))
 			caErrorsCount++
 			caErrorStr += "\n" + rsp.Status.Message
+			caConnFailed = true
 			continue
 		}
 
@@ -561,17 +821,22 @@ func (agents *connectedAgentsData) ForwardToKeaOverHTTP(ctx context.Context, dbA
 			} else {
 				daemonErrorsCount[daemonName] = 0
 			}
+			// The CA failed to forward the command to the daemon.
+			if textField := cmdRespItem.FieldByName("Text"); textField.IsValid() &&
+				isKeaForwardingError(resultField.Int(), textField.String()) {
+				daemonConnFailed[daemonName] = true
+			}
 		}
 		result.CmdsErrors = append(result.CmdsErrors, nil)
 	}
 
-	agents.updateErrorStatsAndRaiseEvents(agent, caAddress, caPort, dbApp, caErrorsCount, addrPort, caURL, fdReq, caErrorStr, daemonErrorsCount)
+	agents.updateErrorStatsAndRaiseEvents(agent, caAddress, caPort, dbApp, caErrorsCount, addrPort, caURL, keaRequests, caErrorStr, daemonErrorsCount, startedAt, caConnFailed, daemonConnFailed)
 
 	// Everything was fine, so return no error.
 	return result, nil
 }
 
-func (agents *connectedAgentsData) updateErrorStatsAndRaiseEvents(agent *Agent, caAddress string, caPort int64, dbApp *dbmodel.App, caErrorsCount int64, addrPort, caURL string, fdReq *agentapi.ForwardToKeaOverHTTPReq, caErrorStr string, daemonErrorsCount map[string]int64) {
+func (agents *connectedAgentsData) updateErrorStatsAndRaiseEvents(agent *Agent, caAddress string, caPort int64, dbApp *dbmodel.App, caErrorsCount int64, addrPort, caURL string, keaRequests []*agentapi.KeaRequest, caErrorStr string, daemonErrorsCount map[string]int64, startedAt time.Time, caConnFailed bool, daemonConnFailed map[string]bool) {
 	// Start updating error statistics for this agent and the Kea app we've been
 	// communicating with.
 	var (
@@ -584,8 +849,7 @@ func (agents *connectedAgentsData) updateErrorStatsAndRaiseEvents(agent *Agent,
 	keaCommStats, keaCommStatsExist = agent.Stats.AppCommStats[AppCommStatsKey{caAddress, caPort}].(*AgentKeaCommStats)
 	// Seems that this is the first request to this Kea server.
 	if !keaCommStatsExist {
-		keaCommStats = &AgentKeaCommStats{}
-		keaCommStats.CurrentErrorsDaemons = make(map[string]int64)
+		keaCommStats = newAgentKeaCommStats()
 		agent.Stats.AppCommStats[AppCommStatsKey{caAddress, caPort}] = keaCommStats
 	}
 
@@ -607,7 +871,7 @@ func (agents *connectedAgentsData) updateErrorStatsAndRaiseEvents(agent *Agent,
 			log.WithFields(log.Fields{
 				"agent": addrPort,
 				"kea":   caURL,
-			}).Warnf("communication failed: %+v", fdReq.KeaRequests)
+			}).Warnf("communication failed: %+v", keaRequests)
 			dmn, ok := daemonsMap["ca"]
 			if ok {
 				agents.EventCenter.AddErrorEvent("communication with {daemon} of {app} failed", strings.TrimSpace(caErrorStr), &dmn, dbApp)
@@ -630,6 +894,28 @@ func (agents *connectedAgentsData) updateErrorStatsAndRaiseEvents(agent *Agent,
 	}
 	// log.Printf("errors CA: prev: %d, curr: %d", prevErrorsCA, keaCommStats.CurrentErrorsCA)
 
+	now := time.Now()
+	if caConnFailed {
+		if keaCommStats.CircuitBreakerCA.recordFailure(startedAt, now, agents.Settings) {
+			log.WithFields(log.Fields{
+				"agent":   addrPort,
+				"kea":     caURL,
+				"retryAt": keaCommStats.CircuitBreakerCA.RetryAt,
+			}).Warn("communication with Kea Control Agent suspended")
+			if dmn, ok := daemonsMap["ca"]; ok {
+				agents.EventCenter.AddErrorEvent("communication with {daemon} of {app} suspended after repeated failures", &dmn, dbApp)
+			} else {
+				agents.EventCenter.AddErrorEvent("communication with CA daemon of {app} suspended after repeated failures", dbApp)
+			}
+		}
+	} else if keaCommStats.CircuitBreakerCA.recordSuccess() {
+		if dmn, ok := daemonsMap["ca"]; ok {
+			agents.EventCenter.AddWarningEvent("suspended communication with {daemon} of {app} restored", &dmn, dbApp)
+		} else {
+			agents.EventCenter.AddWarningEvent("suspended communication with CA daemon of {app} restored", dbApp)
+		}
+	}
+
 	// Set the counters for individual daemons.
 	for dmnName, errCnt := range daemonErrorsCount {
 		prevErrors, ok := keaCommStats.CurrentErrorsDaemons[dmnName]
@@ -639,6 +925,29 @@ func (agents *connectedAgentsData) updateErrorStatsAndRaiseEvents(agent *Agent,
 			keaCommStats.CurrentErrorsDaemons[dmnName] += errCnt
 		}
 
+		// Update the circuit breaker of the daemon. It is created when the
+		// daemon responds for the first time.
+		cb, ok := keaCommStats.CircuitBreakersDaemons[dmnName]
+		if !ok {
+			cb = NewCircuitBreaker()
+			keaCommStats.CircuitBreakersDaemons[dmnName] = cb
+		}
+		var cbEvent string
+		if daemonConnFailed[dmnName] {
+			if cb.recordFailure(startedAt, now, agents.Settings) {
+				cbEvent = "communication with {daemon} of {app} suspended after repeated failures"
+			}
+		} else if cb.recordSuccess() {
+			cbEvent = "suspended communication with {daemon} of {app} restored"
+		}
+		if dmn, ok := daemonsMap[dmnName]; ok && cbEvent != "" {
+			if daemonConnFailed[dmnName] {
+				agents.EventCenter.AddErrorEvent(cbEvent, &dmn, dbApp)
+			} else {
+				agents.EventCenter.AddWarningEvent(cbEvent, &dmn, dbApp)
+			}
+		}
+
 		// if communication with given daemon started or stopped failing then generate an event
 		currentErrors := keaCommStats.CurrentErrorsDaemons[dmnName]
 		// log.Printf("errors %s: prev: %d, curr: %d", dmnName, prevErrors, currentErrors)
@@ -670,7 +979,7 @@ func (agents *connectedAgentsData) TailTextFile(ctx context.Context, agentAddres
 	}
 
 	// Send the request via queue.
-	agentResponse, err := agents.sendAndRecvViaQueue(addrPort, req)
+	agentResponse, err := agents.sendAndRecvViaQueue(ctx, addrPort, req)
 	if err != nil {
 		log.WithFields(log.Fields{
 			"agent": addrPort,
@@ -688,3 +997,135 @@ func (agents *connectedAgentsData) TailTextFile(ctx context.Context, agentAddres
 
 	return response.Lines, nil
 }
+
+// Follows the text file on the agent. The lines within the offset from the
+// end of the file are passed to the handler first. Next, the lines appended
+// to the file are passed to the handler as they appear. The function returns
+// when the context is cancelled, the handler returns an error or the agent
+// closes the stream. An error is returned when the agent cannot follow the
+// file.
+func (agents *connectedAgentsData) FollowTextFile(ctx context.Context, agentAddress string, agentPort int64, path string, offset int64, handler func(lines []string) error) error {
+	addrPort := net.JoinHostPort(agentAddress, strconv.FormatInt(agentPort, 10))
+
+	req := &agentapi.FollowTextFileReq{
+		Path:   path,
+		Offset: offset,
+	}
+
+	// Open the stream via queue.
+	agentResponse, err := agents.openStreamViaQueue(ctx, addrPort, req)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"agent": addrPort,
+			"file":  path,
+		}).Warnf("failed to follow text file")
+
+		return errors.Wrapf(err, "failed to follow text file: %s", path)
+	}
+
+	stream := agentResponse.(agentapi.Agent_FollowTextFileClient)
+	for {
+		response, err := stream.Recv()
+		if err != nil {
+			if stderrors.Is(err, io.EOF) || ctx.Err() != nil {
+				return nil
+			}
+			return errors.Wrapf(err, "failed to receive followed text file contents: %s", path)
+		}
+		if response.Status.Code != agentapi.Status_OK {
+			return errors.New(response.Status.Message)
+		}
+		if err = handler(response.Lines); err != nil {
+			return err
+		}
+	}
+}
+
+// Criteria of the text file search performed by the agent.
+type TextFileSearchCriteria struct {
+	// Regular expression matched against the lines. An empty pattern
+	// matches all lines.
+	Pattern string
+	// Lines logged before this time are not matched. Zero value means
+	// no lower bound.
+	Since time.Time
+	// Lines logged after this time are not matched. Zero value means
+	// no upper bound.
+	Until time.Time
+	// Number of lines returned before and after each matching line.
+	ContextLines int64
+	// Maximum number of matches returned. Zero means no limit.
+	MaxMatches int64
+}
+
+// Line of the text file matching the search criteria along with the
+// context lines.
+type TextFileMatch struct {
+	LineNumber int64
+	Line       string
+	Before     []string
+	After      []string
+}
+
+// Searches the text file on the agent for the lines matching the criteria.
+// The matches are passed to the handler in batches as they are received.
+// It returns the total number of matches. An error is returned when the
+// agent cannot search the file or the handler returns an error.
+func (agents *connectedAgentsData) SearchTextFile(ctx context.Context, agentAddress string, agentPort int64, path string, criteria *TextFileSearchCriteria, handler func(matches []*TextFileMatch) error) (int64, error) {
+	addrPort := net.JoinHostPort(agentAddress, strconv.FormatInt(agentPort, 10))
+
+	req := &agentapi.SearchTextFileReq{
+		Path:         path,
+		Pattern:      criteria.Pattern,
+		ContextLines: criteria.ContextLines,
+		MaxMatches:   criteria.MaxMatches,
+	}
+	if !criteria.Since.IsZero() {
+		req.Since = criteria.Since.Unix()
+	}
+	if !criteria.Until.IsZero() {
+		req.Until = criteria.Until.Unix()
+	}
+
+	// Open the stream via queue.
+	agentResponse, err := agents.openStreamViaQueue(ctx, addrPort, req)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"agent": addrPort,
+			"file":  path,
+		}).Warnf("failed to search text file")
+
+		return 0, errors.Wrapf(err, "failed to search text file: %s", path)
+	}
+
+	stream := agentResponse.(agentapi.Agent_SearchTextFileClient)
+	for {
+		response, err := stream.Recv()
+		if err != nil {
+			if stderrors.Is(err, io.EOF) {
+				return 0, errors.Errorf("search of text file %s finished unexpectedly", path)
+			}
+			return 0, errors.Wrapf(err, "failed to receive text file search results: %s", path)
+		}
+		if response.Status.Code != agentapi.Status_OK {
+			return 0, errors.New(response.Status.Message)
+		}
+		if len(response.Matches) > 0 {
+			var matches []*TextFileMatch
+			for _, m := range response.Matches {
+				matches = append(matches, &TextFileMatch{
+					LineNumber: m.LineNumber,
+					Line:       m.Line,
+					Before:     m.Before,
+					After:      m.After,
+				})
+			}
+			if err = handler(matches); err != nil {
+				return 0, err
+			}
+		}
+		if response.Done {
+			return response.MatchCount, nil
+		}
+	}
+}
@@ -4,10 +4,14 @@ import (
 	"bytes"
 	"compress/gzip"
 	"context"
+	"fmt"
+	"io"
 	"testing"
+	"time"
 
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
 	agentapi "isc.org/stork/api"
 	keactrl "isc.org/stork/appctrl/kea"
 	dbmodel "isc.org/stork/server/database/model"
@@ -47,7 +51,7 @@ func setupGrpcliTestCase(t *testing.T) (*MockAgentClient, ConnectedAgents, func(
 	}
 }
 
-//go:generate mockgen -package=agentcomm -destination=api_mock.go isc.org/stork/api AgentClient
+//go:generate mockgen -package=agentcomm -destination=api_mock.go isc.org/stork/api AgentClient,Agent_FollowTextFileClient,Agent_SearchTextFileClient
 
 // Check if Ping works.
 func TestPing(t *testing.T) {
@@ -86,12 +90,58 @@ func TestGetState(t *testing.T) {
 
 	// call get state
 	ctx := context.Background()
-	state, err := agents.GetState(ctx, "127.0.0.1", 8080)
+	state, err := agents.GetState(ctx, "127.0.0.1", 8080, false)
 	require.NoError(t, err)
 	require.Equal(t, expVer, state.AgentVersion)
 	require.Equal(t, AppTypeKea, state.Apps[0].Type)
 }
 
+// Test that the agent certificate expiration time and the renewal CSR
+// are returned in the state.
+func TestGetStateAgentCert(t *testing.T) {
+	mockAgentClient, agents, teardown := setupGrpcliTestCase(t)
+	defer teardown()
+
+	rsp := agentapi.GetStateRsp{
+		CertNotAfter:   1600000000,
+		CertRenewalCSR: "csr",
+	}
+	mockAgentClient.EXPECT().GetState(gomock.Any(), gomock.Any()).
+		Return(&rsp, nil)
+
+	state, err := agents.GetState(context.Background(), "127.0.0.1", 8080, false)
+	require.NoError(t, err)
+	require.EqualValues(t, 1600000000, state.AgentCertNotAfter.Unix())
+	require.Equal(t, []byte("csr"), state.AgentCertRenewalCSR)
+}
+
+// Test that the renewed certificate is sent to the agent and that the
+// error reported by the agent is returned.
+func TestRenewCertificate(t *testing.T) {
+	mockAgentClient, agents, teardown := setupGrpcliTestCase(t)
+	defer teardown()
+
+	mockAgentClient.EXPECT().RenewCertificate(gomock.Any(), gomock.Any()).
+		Return(&agentapi.RenewCertificateRsp{
+			Status: &agentapi.Status{
+				Code: agentapi.Status_OK,
+			},
+		}, nil)
+	err := agents.RenewCertificate(context.Background(), "127.0.0.1", 8080, []byte("cert"), nil)
+	require.NoError(t, err)
+
+	mockAgentClient.EXPECT().RenewCertificate(gomock.Any(), gomock.Any()).
+		Return(&agentapi.RenewCertificateRsp{
+			Status: &agentapi.Status{
+				Code:    agentapi.Status_ERROR,
+				Message: "renewed certificate does not match the agent key",
+			},
+		}, nil)
+	err = agents.RenewCertificate(context.Background(), "127.0.0.1", 8080, []byte("cert"), nil)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "does not match")
+}
+
 // Helper function for gzipping json text to bytes array.
 func doGzip(jsonTxt string) []byte {
 	var gzippedBuf bytes.Buffer
@@ -497,6 +547,62 @@ func TestForwardRndcCommand(t *testing.T) {
 	require.Zero(t, appCommStats.CurrentErrorsRNDC)
 }
 
+// Test that the rndc circuit breaker opens when rndc fails to connect to
+// BIND9 but not when BIND9 fails to execute the command.
+func TestForwardRndcCommandCircuitBreaker(t *testing.T) {
+	mockAgentClient, agents, teardown := setupGrpcliTestCase(t)
+	defer teardown()
+	agents.(*connectedAgentsData).Settings.CircuitBreakerThreshold = 1
+
+	makeRsp := func(message string) *agentapi.ForwardRndcCommandRsp {
+		return &agentapi.ForwardRndcCommandRsp{
+			Status: &agentapi.Status{
+				Code: 0,
+			},
+			RndcResponse: &agentapi.RndcResponse{
+				Status: &agentapi.Status{
+					Code:    agentapi.Status_ERROR,
+					Message: message,
+				},
+			},
+		}
+	}
+
+	ctx := context.Background()
+	dbApp := &dbmodel.App{
+		Machine: &dbmodel.Machine{
+			Address:   "127.0.0.1",
+			AgentPort: 8080,
+		},
+		AccessPoints: []*dbmodel.AccessPoint{{
+			Type:    dbmodel.AccessPointControl,
+			Address: "127.0.0.1",
+			Port:    953,
+			Key:     "",
+		}},
+	}
+
+	// BIND9 fails to execute the command.
+	mockAgentClient.EXPECT().ForwardRndcCommand(gomock.Any(), gomock.Any()).
+		Return(makeRsp("Failed to forward commands to rndc: rndc: 'test' failed: unknown command: exit status 1"), nil)
+	out, err := agents.ForwardRndcCommand(ctx, dbApp, "test")
+	require.NoError(t, err)
+	require.Error(t, out.Error)
+
+	agent, err := agents.GetConnectedAgent("127.0.0.1:8080")
+	require.NoError(t, err)
+	appCommStats, ok := agent.Stats.AppCommStats[AppCommStatsKey{"127.0.0.1", 953}].(*AgentBind9CommStats)
+	require.True(t, ok)
+	require.Equal(t, CircuitClosed, appCommStats.CircuitBreakerRNDC.GetState())
+
+	// rndc fails to connect to BIND9.
+	mockAgentClient.EXPECT().ForwardRndcCommand(gomock.Any(), gomock.Any()).
+		Return(makeRsp("Failed to forward commands to rndc: rndc: connect failed: 127.0.0.1#953: connection refused: exit status 1"), nil)
+	_, err = agents.ForwardRndcCommand(ctx, dbApp, "test")
+	require.Error(t, err)
+	require.Equal(t, CircuitOpen, appCommStats.CircuitBreakerRNDC.GetState())
+}
+
 // Test the gRPC call which fetches the tail of the specified text file.
 func TestTailTextFile(t *testing.T) {
 	mockAgentClient, agents, teardown := setupGrpcliTestCase(t)
@@ -524,6 +630,136 @@ func TestTailTextFile(t *testing.T) {
 	require.Equal(t, "mock agent client", tail[1])
 }
 
+// Test the gRPC call which follows the specified text file.
+func TestFollowTextFile(t *testing.T) {
+	mockAgentClient, agents, teardown := setupGrpcliTestCase(t)
+	defer teardown()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockStream := NewMockAgent_FollowTextFileClient(ctrl)
+
+	gomock.InOrder(
+		mockStream.EXPECT().Recv().Return(&agentapi.FollowTextFileRsp{
+			Status: &agentapi.Status{
+				Code: 0,
+			},
+			Lines: []string{"Text returned by"},
+		}, nil),
+		mockStream.EXPECT().Recv().Return(&agentapi.FollowTextFileRsp{
+			Status: &agentapi.Status{
+				Code: 0,
+			},
+			Lines: []string{"mock agent client"},
+		}, nil),
+		mockStream.EXPECT().Recv().Return(nil, io.EOF),
+	)
+
+	mockAgentClient.EXPECT().FollowTextFile(gomock.Any(), gomock.Any()).
+		Return(mockStream, nil)
+
+	var lines []string
+	ctx := context.Background()
+	err := agents.FollowTextFile(ctx, "127.0.0.1", 8080, "/tmp/log.txt", 2, func(l []string) error {
+		lines = append(lines, l...)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"Text returned by", "mock agent client"}, lines)
+}
+
+// Test that an error status returned while following the text file
+// is reported.
+func TestFollowTextFileError(t *testing.T) {
+	mockAgentClient, agents, teardown := setupGrpcliTestCase(t)
+	defer teardown()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockStream := NewMockAgent_FollowTextFileClient(ctrl)
+
+	mockStream.EXPECT().Recv().Return(&agentapi.FollowTextFileRsp{
+		Status: &agentapi.Status{
+			Code:    agentapi.Status_ERROR,
+			Message: "Access forbidden to the /tmp/log.txt",
+		},
+	}, nil)
+
+	mockAgentClient.EXPECT().FollowTextFile(gomock.Any(), gomock.Any()).
+		Return(mockStream, nil)
+
+	ctx := context.Background()
+	err := agents.FollowTextFile(ctx, "127.0.0.1", 8080, "/tmp/log.txt", 2, func(l []string) error {
+		return nil
+	})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "Access forbidden")
+}
+
+// Test the gRPC call which searches the specified text file.
+func TestSearchTextFile(t *testing.T) {
+	mockAgentClient, agents, teardown := setupGrpcliTestCase(t)
+	defer teardown()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockStream := NewMockAgent_SearchTextFileClient(ctrl)
+
+	gomock.InOrder(
+		mockStream.EXPECT().Recv().Return(&agentapi.SearchTextFileRsp{
+			Status: &agentapi.Status{
+				Code: 0,
+			},
+			Matches: []*agentapi.TextFileMatch{
+				{
+					LineNumber: 3,
+					Line:       "Text returned by",
+					Before:     []string{"mock agent client"},
+				},
+			},
+		}, nil),
+		mockStream.EXPECT().Recv().Return(&agentapi.SearchTextFileRsp{
+			Status: &agentapi.Status{
+				Code: 0,
+			},
+			MatchCount: 1,
+			Done:       true,
+		}, nil),
+	)
+
+	var req *agentapi.SearchTextFileReq
+	mockAgentClient.EXPECT().SearchTextFile(gomock.Any(), gomock.Any()).
+		Do(func(ctx context.Context, in *agentapi.SearchTextFileReq, opts ...grpc.CallOption) {
+			req = in
+		}).
+		Return(mockStream, nil)
+
+	var matches []*TextFileMatch
+	ctx := context.Background()
+	criteria := &TextFileSearchCriteria{
+		Pattern:      "Text",
+		Since:        time.Unix(1000, 0),
+		ContextLines: 1,
+	}
+	count, err := agents.SearchTextFile(ctx, "127.0.0.1", 8080, "/tmp/log.txt", criteria, func(m []*TextFileMatch) error {
+		matches = append(matches, m...)
+		return nil
+	})
+	require.NoError(t, err)
+	require.EqualValues(t, 1, count)
+	require.Len(t, matches, 1)
+	require.EqualValues(t, 3, matches[0].LineNumber)
+	require.Equal(t, "Text returned by", matches[0].Line)
+	require.Equal(t, []string{"mock agent client"}, matches[0].Before)
+
+	require.NotNil(t, req)
+	require.Equal(t, "/tmp/log.txt", req.Path)
+	require.Equal(t, "Text", req.Pattern)
+	require.EqualValues(t, 1000, req.Since)
+	require.Zero(t, req.Until)
+	require.EqualValues(t, 1, req.ContextLines)
+}
+
 // Check MakeAccessPoint.
 func TestMakeAccessPoint(t *testing.T) {
 	aps := MakeAccessPoint(dbmodel.AccessPointControl, "1.2.3.4", "abcd", 124)
@@ -534,3 +770,95 @@ func TestMakeAccessPoint(t *testing.T) {
 	require.EqualValues(t, 124, ap.Port)
 	require.EqualValues(t, "abcd", ap.Key)
 }
+
+// Test that the commands directed to the Kea daemon which has been failing
+// recently are not sent while the commands to other daemons are sent.
+func TestForwardToKeaOverHTTPDaemonCircuitBreaker(t *testing.T) {
+	mockAgentClient, agents, teardown := setupGrpcliTestCase(t)
+	defer teardown()
+	agents.(*connectedAgentsData).Settings.CircuitBreakerThreshold = 1
+
+	dbApp := &dbmodel.App{
+		Machine: &dbmodel.Machine{
+			Address:   "127.0.0.1",
+			AgentPort: 8080,
+		},
+		AccessPoints: []*dbmodel.AccessPoint{{
+			Type:    dbmodel.AccessPointControl,
+			Address: "localhost",
+			Port:    8000,
+			Key:     "",
+		}},
+		Daemons: []*dbmodel.Daemon{
+			{Name: "dhcp4"},
+			{Name: "dhcp6"},
+		},
+	}
+	daemons4, _ := keactrl.NewDaemons("dhcp4")
+	command4, _ := keactrl.NewCommand("test-command", daemons4, nil)
+	daemons6, _ := keactrl.NewDaemons("dhcp6")
+	command6, _ := keactrl.NewCommand("test-command", daemons6, nil)
+
+	makeRsp := func(result int, text string) *agentapi.ForwardToKeaOverHTTPRsp {
+		return &agentapi.ForwardToKeaOverHTTPRsp{
+			Status: &agentapi.Status{
+				Code: 0,
+			},
+			KeaResponses: []*agentapi.KeaResponse{{
+				Status: &agentapi.Status{
+					Code: 0,
+				},
+				Response: doGzip(fmt.Sprintf(`[{"result": %d, "text": "%s"}]`, result, text)),
+			}},
+		}
+	}
+
+	// The DHCPv4 server fails to execute the command. It doesn't open
+	// the circuit because the server responds.
+	mockAgentClient.EXPECT().ForwardToKeaOverHTTP(gomock.Any(), gomock.Any()).
+		Return(makeRsp(1, "operation failed"), nil)
+	actualResponse := keactrl.ResponseList{}
+	_, err := agents.ForwardToKeaOverHTTP(context.Background(), dbApp, []*keactrl.Command{command4}, &actualResponse)
+	require.NoError(t, err)
+
+	agent, err := agents.GetConnectedAgent("127.0.0.1:8080")
+	require.NoError(t, err)
+	appCommStats, ok := agent.Stats.AppCommStats[AppCommStatsKey{"localhost", 8000}].(*AgentKeaCommStats)
+	require.True(t, ok)
+	require.Equal(t, CircuitClosed, appCommStats.CircuitBreakersDaemons["dhcp4"].GetState())
+
+	// The DHCPv4 server is unreachable.
+	mockAgentClient.EXPECT().ForwardToKeaOverHTTP(gomock.Any(), gomock.Any()).
+		Return(makeRsp(1, "unable to forward command to the dhcp4 service: No such file or directory. The server is likely to be offline"), nil)
+	actualResponse = keactrl.ResponseList{}
+	_, err = agents.ForwardToKeaOverHTTP(context.Background(), dbApp, []*keactrl.Command{command4}, &actualResponse)
+	require.NoError(t, err)
+	require.Equal(t, CircuitOpen, appCommStats.CircuitBreakersDaemons["dhcp4"].GetState())
+	require.Equal(t, CircuitClosed, appCommStats.CircuitBreakerCA.GetState())
+
+	// Only the command to the DHCPv6 server is sent.
+	mockAgentClient.EXPECT().ForwardToKeaOverHTTP(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(ctx context.Context, in *agentapi.ForwardToKeaOverHTTPReq, opts ...grpc.CallOption) (*agentapi.ForwardToKeaOverHTTPRsp, error) {
+			require.Len(t, in.KeaRequests, 1)
+			require.Contains(t, string(in.KeaRequests[0].Request), "dhcp6")
+			return makeRsp(0, "operation result"), nil
+		})
+	actualResponse4 := keactrl.ResponseList{}
+	actualResponse6 := keactrl.ResponseList{}
+	cmdsResult, err := agents.ForwardToKeaOverHTTP(context.Background(), dbApp, []*keactrl.Command{command4, command6}, &actualResponse4, &actualResponse6)
+	require.NoError(t, err)
+	require.Len(t, cmdsResult.CmdsErrors, 2)
+	require.Error(t, cmdsResult.CmdsErrors[0])
+	require.Contains(t, cmdsResult.CmdsErrors[0].Error(), "suspended")
+	require.NoError(t, cmdsResult.CmdsErrors[1])
+	require.Empty(t, actualResponse4)
+	require.Len(t, actualResponse6, 1)
+	require.Equal(t, CircuitClosed, appCommStats.CircuitBreakersDaemons["dhcp6"].GetState())
+
+	// No command is sent when all of them are directed to the failing
+	// daemon.
+	cmdsResult, err = agents.ForwardToKeaOverHTTP(context.Background(), dbApp, []*keactrl.Command{command4}, &actualResponse4)
+	require.NoError(t, err)
+	require.Len(t, cmdsResult.CmdsErrors, 1)
+	require.Error(t, cmdsResult.CmdsErrors[0])
+}
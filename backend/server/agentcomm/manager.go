@@ -2,6 +2,9 @@ package agentcomm
 
 import (
 	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
 
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
@@ -9,24 +12,52 @@ import (
 	agentapi "isc.org/stork/api"
 )
 
-// Loop that receives requests to agents, sends to them, receives responses
-// which are passed back to requestor. Requests and responses are passed
-// via channels what guarantees that requests are forwarded to agents one
-// by one.
-func (agents *connectedAgentsData) communicationLoop() {
-	defer agents.Wg.Done()
-	for {
-		select {
-		// wait for requests from parties that want to talk to agents
-		case req := <-agents.CommLoopReqs:
-			if req != nil {
-				agents.handleRequest(req)
-			}
-		// wait for done signal from shutdown function
-		case <-agents.DoneCommLoop:
-			return
-		}
+// Default limits of the concurrent calls and the default call timeout.
+// They are used when the settings don't specify them.
+const (
+	defaultMaxCallsPerAgent = 4
+	defaultMaxCalls         = 64
+	defaultCallTimeout      = 30 * time.Second
+)
+
+// Maximum number of requests buffered in the queue of a single agent.
+// The requestors are blocked when the queue is full.
+const agentQueueCapacity = 100
+
+// Duration after which the unused queue of the requests to an agent is
+// removed along with its workers.
+const agentQueueIdleTimeout = 5 * time.Minute
+
+// Returns the maximum number of concurrent calls to a single agent.
+func (settings *AgentsSettings) maxCallsPerAgent() int {
+	if settings.MaxCallsPerAgent <= 0 {
+		return defaultMaxCallsPerAgent
+	}
+	return settings.MaxCallsPerAgent
+}
+
+// Returns the maximum number of concurrent calls to all agents.
+func (settings *AgentsSettings) maxCalls() int {
+	if settings.MaxCalls <= 0 {
+		return defaultMaxCalls
+	}
+	return settings.MaxCalls
+}
+
+// Returns the maximum duration of a call to an agent.
+func (settings *AgentsSettings) callTimeout() time.Duration {
+	if settings.CallTimeout <= 0 {
+		return defaultCallTimeout
 	}
+	return settings.CallTimeout
+}
+
+// Statistics of the queue of the requests to a single agent.
+type AgentQueueStats struct {
+	// Number of requests waiting to be sent to the agent.
+	Queued int64
+	// Number of requests being sent to the agent.
+	InFlight int64
 }
 
 type channelResp struct {
@@ -38,34 +69,236 @@ type commLoopReq struct {
 	AgentAddr string
 	ReqData   interface{}
 	RespChan  chan *channelResp
+	// Context passed to the gRPC call.
+	Ctx context.Context
+	// Indicates that the call opens a stream. The stream is closed when
+	// the context is cancelled by the requestor, so the call timeout is
+	// not applied to it.
+	Stream bool
+}
+
+// Queue of the requests to a single agent. The requests are handled
+// by a limited number of workers, so a slow agent does not delay the
+// requests to the other agents. The queue is removed when it has not
+// been used for some time, e.g. because the machine has been deleted.
+type agentQueue struct {
+	requests chan *commLoopReq
+	// Number of the requestors waiting for the responses. The queue is
+	// not removed as long as it has users. Protected by the queuesMutex.
+	users int
+	// Accessed atomically.
+	queued   int64
+	inFlight int64
+}
+
+// Returns the queue of the requests to the specified agent and marks it
+// as used. The queue and its workers are created when the first request
+// to the agent is sent. The releaseQueue must be called when the
+// response to the request is received.
+func (agents *connectedAgentsData) acquireQueue(agentAddr string) (*agentQueue, error) {
+	agents.queuesMutex.Lock()
+	defer agents.queuesMutex.Unlock()
+
+	select {
+	case <-agents.done:
+		return nil, errors.New("communication with the agents has been stopped")
+	default:
+	}
+
+	queue, ok := agents.queues[agentAddr]
+	if !ok {
+		queue = &agentQueue{
+			requests: make(chan *commLoopReq, agentQueueCapacity),
+		}
+		agents.queues[agentAddr] = queue
+		for i := 0; i < agents.Settings.maxCallsPerAgent(); i++ {
+			agents.Wg.Add(1)
+			go agents.queueWorker(agentAddr, queue)
+		}
+	}
+	queue.users++
+	return queue, nil
+}
+
+// Marks that the requestor no longer uses the queue. The queue is
+// removed when the communication with the agents has been stopped and
+// the queue is no longer used.
+func (agents *connectedAgentsData) releaseQueue(agentAddr string, queue *agentQueue) {
+	agents.queuesMutex.Lock()
+	defer agents.queuesMutex.Unlock()
+
+	queue.users--
+	select {
+	case <-agents.done:
+		agents.removeQueue(agentAddr, queue)
+	default:
+	}
+}
+
+// Removes the queue if it is not used. The workers of the removed queue
+// stop. It must be called with the queuesMutex locked.
+func (agents *connectedAgentsData) removeQueue(agentAddr string, queue *agentQueue) {
+	if queue.users > 0 || agents.queues[agentAddr] != queue {
+		return
+	}
+	delete(agents.queues, agentAddr)
+	// No requestor can send to the queue because they must acquire it
+	// first.
+	close(queue.requests)
+}
+
+// Receives the requests from the agent queue, sends them to the agent and
+// passes the responses back to the requestors. The number of concurrent
+// calls to all agents is limited by the call slots. The requests received
+// after the communication with the agents has been stopped are rejected.
+// The worker stops when the queue is removed, i.e. when it hasn't been
+// used for the idle timeout or when the communication has been stopped.
+func (agents *connectedAgentsData) queueWorker(agentAddr string, queue *agentQueue) {
+	defer agents.Wg.Done()
+	idleTimer := time.NewTimer(agents.queueIdleTimeout)
+	defer idleTimer.Stop()
+	for {
+		select {
+		case req, ok := <-queue.requests:
+			if !ok {
+				return
+			}
+			if !idleTimer.Stop() {
+				<-idleTimer.C
+			}
+			agents.handleQueuedRequest(queue, req)
+			idleTimer.Reset(agents.queueIdleTimeout)
+		case <-idleTimer.C:
+			agents.queuesMutex.Lock()
+			agents.removeQueue(agentAddr, queue)
+			agents.queuesMutex.Unlock()
+			// The remaining requests are handled until the queue is closed.
+			idleTimer.Reset(agents.queueIdleTimeout)
+		}
+	}
+}
+
+// Sends the request taken from the queue to the agent when a call slot
+// is available. The request is rejected if the communication with the
+// agents has been stopped.
+func (agents *connectedAgentsData) handleQueuedRequest(queue *agentQueue, req *commLoopReq) {
+	stopped := false
+	select {
+	case <-agents.done:
+		stopped = true
+	default:
+		select {
+		case agents.callSlots <- struct{}{}:
+		case <-agents.done:
+			stopped = true
+		}
+	}
+	if stopped {
+		atomic.AddInt64(&queue.queued, -1)
+		req.RespChan <- &channelResp{Err: errors.New("communication with the agents has been stopped")}
+		return
+	}
+	atomic.AddInt64(&queue.queued, -1)
+	atomic.AddInt64(&queue.inFlight, 1)
+	agents.handleRequest(req)
+	atomic.AddInt64(&queue.inFlight, -1)
+	<-agents.callSlots
+}
+
+// Stops the communication with the agents. The requests remaining in
+// the queues are rejected. It waits until the queue workers stop.
+func (agents *connectedAgentsData) stopQueues() {
+	agents.queuesMutex.Lock()
+	close(agents.done)
+	for agentAddr, queue := range agents.queues {
+		// The queues still in use are removed when released.
+		agents.removeQueue(agentAddr, queue)
+	}
+	agents.queuesMutex.Unlock()
+
+	agents.Wg.Wait()
+}
+
+// Returns the statistics of the request queues indexed by the agent
+// address and port.
+func (agents *connectedAgentsData) GetQueueStats() map[string]AgentQueueStats {
+	agents.queuesMutex.Lock()
+	defer agents.queuesMutex.Unlock()
+
+	stats := make(map[string]AgentQueueStats)
+	for agentAddr, queue := range agents.queues {
+		stats[agentAddr] = AgentQueueStats{
+			Queued:   atomic.LoadInt64(&queue.queued),
+			InFlight: atomic.LoadInt64(&queue.inFlight),
+		}
+	}
+	return stats
 }
 
-// Send a request to agent and receive response using channel to communication loop.
-func (agents *connectedAgentsData) sendAndRecvViaQueue(agentAddr string, in interface{}) (interface{}, error) {
-	respChan := make(chan *channelResp)
-	req := &commLoopReq{AgentAddr: agentAddr, ReqData: in, RespChan: respChan}
-	agents.CommLoopReqs <- req
+// Put the request to the agent queue and wait for the response. The
+// request is abandoned if the context is cancelled before the request
+// is taken from the queue.
+func (agents *connectedAgentsData) queueRequest(ctx context.Context, agentAddr string, in interface{}, stream bool) (interface{}, error) {
+	queue, err := agents.acquireQueue(agentAddr)
+	if err != nil {
+		return nil, err
+	}
+	defer agents.releaseQueue(agentAddr, queue)
+
+	respChan := make(chan *channelResp, 1)
+	req := &commLoopReq{AgentAddr: agentAddr, ReqData: in, RespChan: respChan, Ctx: ctx, Stream: stream}
+
+	atomic.AddInt64(&queue.queued, 1)
+	select {
+	case queue.requests <- req:
+	case <-ctx.Done():
+		atomic.AddInt64(&queue.queued, -1)
+		return nil, errors.Wrapf(ctx.Err(), "request to the agent %s abandoned", agentAddr)
+	}
+
+	// The queue is used until the response is received, so the workers
+	// are running and respond even if the communication has been stopped.
 	respErr := <-respChan
 	return respErr.Response, respErr.Err
 }
 
+// Send a request to agent and receive response using the agent queue.
+func (agents *connectedAgentsData) sendAndRecvViaQueue(ctx context.Context, agentAddr string, in interface{}) (interface{}, error) {
+	return agents.queueRequest(ctx, agentAddr, in, false)
+}
+
+// Open a stream to the agent using the agent queue. The stream is
+// returned as a response. It is closed when the specified context is
+// cancelled.
+func (agents *connectedAgentsData) openStreamViaQueue(ctx context.Context, agentAddr string, in interface{}) (interface{}, error) {
+	return agents.queueRequest(ctx, agentAddr, in, true)
+}
+
 // Pass given request directly to an agent.
-func doCall(ctx context.Context, agent *Agent, in interface{}) (interface{}, error) {
+func doCall(ctx context.Context, client agentapi.AgentClient, in interface{}) (interface{}, error) {
 	var response interface{}
 	var err error
 	switch inData := in.(type) {
 	case *agentapi.PingReq:
-		response, err = agent.Client.Ping(ctx, inData)
+		response, err = client.Ping(ctx, inData)
 	case *agentapi.GetStateReq:
-		response, err = agent.Client.GetState(ctx, inData)
+		response, err = client.GetState(ctx, inData)
 	case *agentapi.ForwardRndcCommandReq:
-		response, err = agent.Client.ForwardRndcCommand(ctx, inData)
+		response, err = client.ForwardRndcCommand(ctx, inData)
 	case *agentapi.ForwardToNamedStatsReq:
-		response, err = agent.Client.ForwardToNamedStats(ctx, inData)
+		response, err = client.ForwardToNamedStats(ctx, inData)
 	case *agentapi.ForwardToKeaOverHTTPReq:
-		response, err = agent.Client.ForwardToKeaOverHTTP(ctx, inData)
+		response, err = client.ForwardToKeaOverHTTP(ctx, inData)
+	case *agentapi.ForwardToKeaOverSocketReq:
+		response, err = client.ForwardToKeaOverSocket(ctx, inData)
 	case *agentapi.TailTextFileReq:
-		response, err = agent.Client.TailTextFile(ctx, inData)
+		response, err = client.TailTextFile(ctx, inData)
+	case *agentapi.FollowTextFileReq:
+		response, err = client.FollowTextFile(ctx, inData)
+	case *agentapi.SearchTextFileReq:
+		response, err = client.SearchTextFile(ctx, inData)
+	case *agentapi.RenewCertificateReq:
+		response, err = client.RenewCertificate(ctx, inData)
 	default:
 		err = errors.New("doCall: unsupported request type")
 	}
@@ -73,9 +306,56 @@ func doCall(ctx context.Context, agent *Agent, in interface{}) (interface{}, err
 	return response, err
 }
 
-// Forward request received from channel to given agent and send back response
-// via channel to requestor.
+// Checks if the request may be sent to the agent according to the state
+// of the agent's circuit breaker.
+func (agents *connectedAgentsData) allowAgentRequest(agent *Agent) error {
+	agent.Stats.mutex.Lock()
+	defer agent.Stats.mutex.Unlock()
+	if !agent.Stats.CircuitBreaker.allowRequest(time.Now(), agents.Settings) {
+		return agent.Stats.CircuitBreaker.openError(fmt.Sprintf("the agent %s", agent.Address))
+	}
+	return nil
+}
+
+// Records the result of the request sent to the agent at the specified
+// time in the agent's circuit breaker. It raises the events when the
+// communication is suspended and when it is restored.
+func (agents *connectedAgentsData) recordAgentResult(agent *Agent, startedAt time.Time, err error) {
+	agent.Stats.mutex.Lock()
+	defer agent.Stats.mutex.Unlock()
+	cb := agent.Stats.CircuitBreaker
+	if err == nil {
+		if cb.recordSuccess() {
+			log.WithFields(log.Fields{
+				"agent": agent.Address,
+			}).Info("communication with the agent restored")
+			agents.EventCenter.AddWarningEvent(fmt.Sprintf("communication with stork agent %s restored", agent.Address))
+		}
+		return
+	}
+	if cb.recordFailure(startedAt, time.Now(), agents.Settings) {
+		log.WithFields(log.Fields{
+			"agent":    agent.Address,
+			"failures": cb.ConsecutiveFailures,
+			"retryAt":  cb.RetryAt,
+		}).Warn("communication with the agent suspended")
+		agents.EventCenter.AddErrorEvent(fmt.Sprintf("communication with stork agent %s suspended after %d consecutive failures",
+			agent.Address, cb.ConsecutiveFailures), err.Error())
+	}
+}
+
+// Forward request received from the queue to given agent and send back
+// response via channel to requestor.
 func (agents *connectedAgentsData) handleRequest(req *commLoopReq) {
+	// The requestor may have given up while the request was queued.
+	if err := req.Ctx.Err(); err != nil {
+		req.RespChan <- &channelResp{
+			Response: nil,
+			Err:      errors.Wrapf(err, "request to the agent %s abandoned", req.AgentAddr),
+		}
+		return
+	}
+
 	// get agent and its grpc connection
 	agent, err := agents.GetConnectedAgent(req.AgentAddr)
 	if err != nil {
@@ -83,38 +363,64 @@ func (agents *connectedAgentsData) handleRequest(req *commLoopReq) {
 		return
 	}
 
-	// do call
-	ctx := context.Background()
-	response, err := doCall(ctx, agent, req.ReqData)
-	if err != nil {
-		// GetConnectedAgent remembers the grpc connection so it might
-		// return an already existing connection.  This connection may
-		// be broken so we should retry at least once.
-		err2 := agent.MakeGrpcConnection(agents.caCertPEM, agents.serverCertPEM, agents.serverKeyPEM)
-		if err2 != nil {
-			log.WithFields(log.Fields{
-				"agent": agent.Address,
-			}).Warn(err)
-			req.RespChan <- &channelResp{
-				Response: nil,
-				Err:      errors.WithMessagef(err2, "grpc manager is unable to re-establish connection with the agent %s", agent.Address),
-			}
-			return
-		}
+	// Don't send the request if the agent has been failing recently.
+	if err = agents.allowAgentRequest(agent); err != nil {
+		req.RespChan <- &channelResp{Response: nil, Err: err}
+		return
+	}
 
-		// do call once again
-		response, err2 = doCall(ctx, agent, req.ReqData)
-		if err2 != nil {
-			log.WithFields(log.Fields{
-				"agent": agent.Address,
-			}).Warn(err)
-			req.RespChan <- &channelResp{
-				Response: nil,
-				Err:      errors.WithMessagef(err2, "grpc manager is unable to re-establish connection with the agent %s", agent.Address),
-			}
-			return
-		}
+	startedAt := time.Now()
+	response, err := agents.callAgent(agent, req)
+
+	// The failures caused by the requestor cancelling the request don't
+	// indicate problems with the agent.
+	if err == nil || req.Ctx.Err() == nil {
+		agents.recordAgentResult(agent, startedAt, err)
 	}
 
-	req.RespChan <- &channelResp{Response: response, Err: nil}
+	req.RespChan <- &channelResp{Response: response, Err: err}
+}
+
+// Sends the request to the agent. If the call fails, the connection to
+// the agent is re-established and the call is retried once.
+func (agents *connectedAgentsData) callAgent(agent *Agent, req *commLoopReq) (interface{}, error) {
+	ctx := req.Ctx
+	if !req.Stream {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, agents.Settings.callTimeout())
+		defer cancel()
+	}
+	client := agent.getClient()
+	response, err := doCall(ctx, client, req.ReqData)
+	if err == nil {
+		return response, nil
+	}
+	if agent.usesTunnel() {
+		// The tunnel can only be re-established by the agent.
+		log.WithFields(log.Fields{
+			"agent": agent.Address,
+		}).Warn(err)
+		return nil, errors.WithMessagef(err, "grpc manager is unable to communicate with the agent %s over the tunnel", agent.Address)
+	}
+
+	// GetConnectedAgent remembers the grpc connection so it might
+	// return an already existing connection.  This connection may
+	// be broken so we should retry at least once.
+	err2 := agent.reconnect(client, agents.caCertPEM, agents.serverCertPEM, agents.serverKeyPEM)
+	if err2 != nil {
+		log.WithFields(log.Fields{
+			"agent": agent.Address,
+		}).Warn(err)
+		return nil, errors.WithMessagef(err2, "grpc manager is unable to re-establish connection with the agent %s", agent.Address)
+	}
+
+	// do call once again
+	response, err2 = doCall(ctx, agent.getClient(), req.ReqData)
+	if err2 != nil {
+		log.WithFields(log.Fields{
+			"agent": agent.Address,
+		}).Warn(err)
+		return nil, errors.WithMessagef(err2, "grpc manager is unable to re-establish connection with the agent %s", agent.Address)
+	}
+	return response, nil
 }
@@ -0,0 +1,306 @@
+package agentcomm
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+
+	agentapi "isc.org/stork/api"
+	storktest "isc.org/stork/server/test"
+)
+
+// Setup function for the unit tests of the request queues. It creates
+// the fake agents running at the specified addresses. The returned
+// function performs a test teardown and should be invoked when the
+// unit test finishes.
+func setupManagerTestCase(t *testing.T, settings *AgentsSettings, addrs ...string) ([]*MockAgentClient, *connectedAgentsData, func()) {
+	fec := &storktest.FakeEventCenter{}
+	agents := NewConnectedAgents(settings, fec, CACertPEM, ServerCertPEM, ServerKeyPEM)
+
+	ctrl := gomock.NewController(t)
+	var clients []*MockAgentClient
+	for _, addr := range addrs {
+		agent, err := agents.GetConnectedAgent(addr)
+		require.NoError(t, err)
+		mockAgentClient := NewMockAgentClient(ctrl)
+		agent.Client = mockAgentClient
+		clients = append(clients, mockAgentClient)
+	}
+
+	return clients, agents.(*connectedAgentsData), func() {
+		agents.Shutdown()
+		ctrl.Finish()
+	}
+}
+
+// Test that the default limits are used when they are not specified.
+func TestAgentsSettingsDefaults(t *testing.T) {
+	settings := &AgentsSettings{}
+	require.Equal(t, defaultMaxCallsPerAgent, settings.maxCallsPerAgent())
+	require.Equal(t, defaultMaxCalls, settings.maxCalls())
+	require.Equal(t, defaultCallTimeout, settings.callTimeout())
+
+	settings = &AgentsSettings{
+		MaxCallsPerAgent: 2,
+		MaxCalls:         10,
+		CallTimeout:      time.Second,
+	}
+	require.Equal(t, 2, settings.maxCallsPerAgent())
+	require.Equal(t, 10, settings.maxCalls())
+	require.Equal(t, time.Second, settings.callTimeout())
+}
+
+// Test that the slow agent does not delay the requests to other agents.
+func TestSlowAgentDoesNotBlockOtherAgents(t *testing.T) {
+	clients, agents, teardown := setupManagerTestCase(t, &AgentsSettings{}, "127.0.0.1:8080", "127.0.0.1:8081")
+	defer teardown()
+
+	release := make(chan struct{})
+	clients[0].EXPECT().Ping(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(ctx context.Context, in *agentapi.PingReq, opts ...grpc.CallOption) (*agentapi.PingRsp, error) {
+			<-release
+			return &agentapi.PingRsp{}, nil
+		})
+	clients[1].EXPECT().Ping(gomock.Any(), gomock.Any()).
+		Return(&agentapi.PingRsp{}, nil)
+
+	slowDone := make(chan error)
+	go func() {
+		slowDone <- agents.Ping(context.Background(), "127.0.0.1", 8080)
+	}()
+
+	require.Eventually(t, func() bool {
+		return agents.GetQueueStats()["127.0.0.1:8080"].InFlight == 1
+	}, time.Second, 10*time.Millisecond)
+
+	// The other agent responds while the first one is still busy.
+	err := agents.Ping(context.Background(), "127.0.0.1", 8081)
+	require.NoError(t, err)
+
+	close(release)
+	require.NoError(t, <-slowDone)
+
+	stats := agents.GetQueueStats()
+	require.Len(t, stats, 2)
+	require.Zero(t, stats["127.0.0.1:8080"].InFlight)
+	require.Zero(t, stats["127.0.0.1:8080"].Queued)
+}
+
+// Test that the number of concurrent calls to a single agent is limited
+// and the remaining requests wait in the queue.
+func TestMaxCallsPerAgent(t *testing.T) {
+	settings := &AgentsSettings{MaxCallsPerAgent: 1}
+	clients, agents, teardown := setupManagerTestCase(t, settings, "127.0.0.1:8080")
+	defer teardown()
+
+	release := make(chan struct{})
+	clients[0].EXPECT().Ping(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(ctx context.Context, in *agentapi.PingReq, opts ...grpc.CallOption) (*agentapi.PingRsp, error) {
+			<-release
+			return &agentapi.PingRsp{}, nil
+		}).Times(2)
+
+	done := make(chan error)
+	for i := 0; i < 2; i++ {
+		go func() {
+			done <- agents.Ping(context.Background(), "127.0.0.1", 8080)
+		}()
+	}
+
+	require.Eventually(t, func() bool {
+		stats := agents.GetQueueStats()["127.0.0.1:8080"]
+		return stats.InFlight == 1 && stats.Queued == 1
+	}, time.Second, 10*time.Millisecond)
+
+	close(release)
+	require.NoError(t, <-done)
+	require.NoError(t, <-done)
+}
+
+// Test that the request is abandoned when its context is cancelled
+// while it waits in the queue.
+func TestQueuedRequestCancelled(t *testing.T) {
+	settings := &AgentsSettings{MaxCallsPerAgent: 1}
+	clients, agents, teardown := setupManagerTestCase(t, settings, "127.0.0.1:8080")
+	defer teardown()
+
+	release := make(chan struct{})
+	clients[0].EXPECT().Ping(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(ctx context.Context, in *agentapi.PingReq, opts ...grpc.CallOption) (*agentapi.PingRsp, error) {
+			<-release
+			return &agentapi.PingRsp{}, nil
+		})
+
+	done := make(chan error)
+	go func() {
+		done <- agents.Ping(context.Background(), "127.0.0.1", 8080)
+	}()
+	require.Eventually(t, func() bool {
+		return agents.GetQueueStats()["127.0.0.1:8080"].InFlight == 1
+	}, time.Second, 10*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancelledDone := make(chan error)
+	go func() {
+		cancelledDone <- agents.Ping(ctx, "127.0.0.1", 8080)
+	}()
+	require.Eventually(t, func() bool {
+		return agents.GetQueueStats()["127.0.0.1:8080"].Queued == 1
+	}, time.Second, 10*time.Millisecond)
+
+	cancel()
+	close(release)
+	require.NoError(t, <-done)
+	err := <-cancelledDone
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "abandoned")
+}
+
+// Test that the call to the agent which does not respond is cancelled
+// after the configured timeout.
+func TestCallTimeout(t *testing.T) {
+	settings := &AgentsSettings{CallTimeout: 100 * time.Millisecond}
+	clients, agents, teardown := setupManagerTestCase(t, settings, "127.0.0.1:8080")
+	defer teardown()
+
+	clients[0].EXPECT().Ping(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(ctx context.Context, in *agentapi.PingReq, opts ...grpc.CallOption) (*agentapi.PingRsp, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		})
+
+	start := time.Now()
+	err := agents.Ping(context.Background(), "127.0.0.1", 8080)
+	require.Error(t, err)
+	require.Less(t, time.Since(start), 5*time.Second)
+}
+
+// Test that the requests are rejected after the communication with the
+// agents is stopped.
+func TestRequestAfterShutdown(t *testing.T) {
+	_, agents, teardown := setupManagerTestCase(t, &AgentsSettings{}, "127.0.0.1:8080")
+	teardown()
+
+	err := agents.Ping(context.Background(), "127.0.0.1", 8080)
+	require.Error(t, err)
+}
+
+// Test that the queue which is not used is removed along with its
+// workers and that it is re-created for the next request.
+func TestIdleQueueRemoved(t *testing.T) {
+	clients, agents, teardown := setupManagerTestCase(t, &AgentsSettings{}, "127.0.0.1:8080")
+	defer teardown()
+	agents.queueIdleTimeout = 50 * time.Millisecond
+
+	clients[0].EXPECT().Ping(gomock.Any(), gomock.Any()).
+		Return(&agentapi.PingRsp{}, nil).Times(2)
+
+	require.NoError(t, agents.Ping(context.Background(), "127.0.0.1", 8080))
+	require.Contains(t, agents.GetQueueStats(), "127.0.0.1:8080")
+
+	require.Eventually(t, func() bool {
+		return len(agents.GetQueueStats()) == 0
+	}, time.Second, 10*time.Millisecond)
+
+	require.NoError(t, agents.Ping(context.Background(), "127.0.0.1", 8080))
+}
+
+// Test that the requests pending during the shutdown receive the
+// responses and the shutdown waits for the in-flight requests.
+func TestShutdownWithPendingRequests(t *testing.T) {
+	settings := &AgentsSettings{MaxCallsPerAgent: 1}
+	clients, agents, teardown := setupManagerTestCase(t, settings, "127.0.0.1:8080")
+
+	release := make(chan struct{})
+	clients[0].EXPECT().Ping(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(ctx context.Context, in *agentapi.PingReq, opts ...grpc.CallOption) (*agentapi.PingRsp, error) {
+			<-release
+			return &agentapi.PingRsp{}, nil
+		})
+
+	inFlightDone := make(chan error)
+	go func() {
+		inFlightDone <- agents.Ping(context.Background(), "127.0.0.1", 8080)
+	}()
+	require.Eventually(t, func() bool {
+		return agents.GetQueueStats()["127.0.0.1:8080"].InFlight == 1
+	}, time.Second, 10*time.Millisecond)
+
+	queuedDone := make(chan error)
+	go func() {
+		queuedDone <- agents.Ping(context.Background(), "127.0.0.1", 8080)
+	}()
+	require.Eventually(t, func() bool {
+		return agents.GetQueueStats()["127.0.0.1:8080"].Queued == 1
+	}, time.Second, 10*time.Millisecond)
+
+	shutdownDone := make(chan struct{})
+	go func() {
+		teardown()
+		close(shutdownDone)
+	}()
+	require.Eventually(t, func() bool {
+		select {
+		case <-agents.done:
+			return true
+		default:
+			return false
+		}
+	}, time.Second, 10*time.Millisecond)
+
+	close(release)
+	require.NoError(t, <-inFlightDone)
+	err := <-queuedDone
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "stopped")
+	<-shutdownDone
+	require.Empty(t, agents.GetQueueStats())
+}
+
+// Test that the requests to the agent are not sent after repeated
+// failures and that the communication is restored after the successful
+// probing request.
+func TestAgentCircuitBreaker(t *testing.T) {
+	settings := &AgentsSettings{
+		CircuitBreakerThreshold:  2,
+		CircuitBreakerMinBackoff: 200 * time.Millisecond,
+	}
+	clients, agents, teardown := setupManagerTestCase(t, settings, "127.0.0.1:8080")
+	defer teardown()
+	fec := agents.EventCenter.(*storktest.FakeEventCenter)
+
+	// The failing calls are retried after re-establishing the connection,
+	// so the mock client is called only once per request.
+	agent, err := agents.GetConnectedAgent("127.0.0.1:8080")
+	require.NoError(t, err)
+	for i := 0; i < 2; i++ {
+		clients[0].EXPECT().Ping(gomock.Any(), gomock.Any()).
+			Return(nil, errors.New("unreachable"))
+		err = agents.Ping(context.Background(), "127.0.0.1", 8080)
+		require.Error(t, err)
+		agent.Client = clients[0]
+	}
+	require.Equal(t, CircuitOpen, agent.Stats.CircuitBreaker.GetState())
+	require.Len(t, fec.Events, 1)
+	require.Contains(t, fec.Events[0].Text, "suspended")
+
+	// The request is rejected without calling the agent.
+	err = agents.Ping(context.Background(), "127.0.0.1", 8080)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "suspended")
+
+	// The probing request succeeds after the backoff time.
+	clients[0].EXPECT().Ping(gomock.Any(), gomock.Any()).
+		Return(&agentapi.PingRsp{}, nil)
+	require.Eventually(t, func() bool {
+		return agents.Ping(context.Background(), "127.0.0.1", 8080) == nil
+	}, 2*time.Second, 50*time.Millisecond)
+	require.Equal(t, CircuitClosed, agent.Stats.CircuitBreaker.GetState())
+	require.Len(t, fec.Events, 2)
+	require.Contains(t, fec.Events[1].Text, "restored")
+}
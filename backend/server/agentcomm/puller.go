@@ -1,6 +1,8 @@
 package agentcomm
 
 import (
+	"time"
+
 	"github.com/pkg/errors"
 
 	dbops "isc.org/stork/server/database"
@@ -17,18 +19,25 @@ type PeriodicPuller struct {
 	intervalSettingName string
 	DB                  *dbops.PgDB
 	Agents              ConnectedAgents
+	// Schedule of the pulls from the apps whose intervals differ due
+	// to the overrides.
+	schedule *pullSchedule
 }
 
 // Creates an instance of a new periodic puller. The periodic puller offers a mechanism
 // to periodically trigger an action. This action is supplied as a function instance.
 // This function is executed within a goroutine periodically according to the timer
 // interval available in the database. The intervalSettingName is a name of this
-// setting in the database. The pullerName is used for logging purposes.
+// setting in the database. The pullerName is used for logging purposes. The global
+// interval can be overridden for the particular machines and apps. In this case
+// the function is executed when the earliest of the apps is due for pulling and it
+// should pull the data from the apps returned by SelectAppsToPull.
 func NewPeriodicPuller(db *dbops.PgDB, agents ConnectedAgents, pullerName, intervalSettingName string, pullFunc func() error) (*PeriodicPuller, error) {
+	schedule := newPullSchedule()
 	periodicExecutor, err := storkutil.NewPeriodicExecutor(
 		pullerName, pullFunc,
 		func() (int64, error) {
-			interval, err := dbmodel.GetSettingInt(db, intervalSettingName)
+			interval, err := getExecutorInterval(db, intervalSettingName, schedule)
 			return interval, errors.WithMessagef(err, "problem with getting interval setting %s from db",
 				intervalSettingName)
 		},
@@ -42,7 +51,114 @@ func NewPeriodicPuller(db *dbops.PgDB, agents ConnectedAgents, pullerName, inter
 		intervalSettingName,
 		db,
 		agents,
+		schedule,
 	}
 
 	return periodicPuller, nil
 }
+
+// Returns the intervals of the puller overrides for the machines and the
+// apps, indexed by machine ID and app ID respectively.
+func getOverriddenIntervals(db *dbops.PgDB, intervalSettingName string) (machineIntervals, appIntervals map[int64]int64, err error) {
+	overrides, err := dbmodel.GetPullerIntervalsByPuller(db, intervalSettingName)
+	if err != nil {
+		return nil, nil, err
+	}
+	machineIntervals = make(map[int64]int64)
+	appIntervals = make(map[int64]int64)
+	for _, override := range overrides {
+		if override.AppID != 0 {
+			appIntervals[override.AppID] = override.Interval
+		} else {
+			machineIntervals[override.MachineID] = override.Interval
+		}
+	}
+	return machineIntervals, appIntervals, nil
+}
+
+// Returns the interval at which the puller executes its function. It is
+// the global interval when there are no overrides. Otherwise, it is the
+// time until the earliest scheduled pull, but not longer than the shortest
+// of the intervals, so the new apps are pulled without a long delay. The
+// excluded apps and the disabled global interval are not taken into account.
+func getExecutorInterval(db *dbops.PgDB, intervalSettingName string, schedule *pullSchedule) (int64, error) {
+	interval, err := dbmodel.GetSettingInt(db, intervalSettingName)
+	if err != nil {
+		return 0, err
+	}
+	machineIntervals, appIntervals, err := getOverriddenIntervals(db, intervalSettingName)
+	if err != nil {
+		return 0, err
+	}
+	if len(machineIntervals) == 0 && len(appIntervals) == 0 {
+		return interval, nil
+	}
+	for _, intervals := range []map[int64]int64{machineIntervals, appIntervals} {
+		for _, overridden := range intervals {
+			if overridden > 0 && (interval <= 0 || overridden < interval) {
+				interval = overridden
+			}
+		}
+	}
+	if interval <= 0 {
+		return 0, nil
+	}
+	return schedule.getNextInterval(time.Now(), interval), nil
+}
+
+// Returns the apps from which the data should be pulled now. The
+// interval of the app is taken from the override specified for this
+// app, the override specified for its machine or the global setting,
+// in this order. The app with the interval of 0 is never pulled. All
+// apps are pulled on each execution when there are no overrides.
+// Otherwise, each app is pulled when its own interval elapsed since
+// the last pull from this app.
+func (puller *PeriodicPuller) SelectAppsToPull(apps []dbmodel.App) ([]dbmodel.App, error) {
+	return puller.selectAppsToPull(apps, time.Now())
+}
+
+// Implementation of SelectAppsToPull selecting the apps due for pulling
+// at the specified time.
+func (puller *PeriodicPuller) selectAppsToPull(apps []dbmodel.App, now time.Time) ([]dbmodel.App, error) {
+	globalInterval, err := dbmodel.GetSettingInt(puller.DB, puller.intervalSettingName)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "problem with getting interval setting %s from db",
+			puller.intervalSettingName)
+	}
+	machineIntervals, appIntervals, err := getOverriddenIntervals(puller.DB, puller.intervalSettingName)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(machineIntervals) == 0 && len(appIntervals) == 0 {
+		puller.schedule.clear()
+		if globalInterval <= 0 {
+			return nil, nil
+		}
+		return apps, nil
+	}
+
+	intervals := make(map[int64]int64)
+	for i := range apps {
+		interval := globalInterval
+		if machineInterval, ok := machineIntervals[apps[i].MachineID]; ok {
+			interval = machineInterval
+		}
+		if appInterval, ok := appIntervals[apps[i].ID]; ok {
+			interval = appInterval
+		}
+		if interval > 0 {
+			intervals[apps[i].ID] = interval
+		}
+	}
+
+	due := puller.schedule.selectDue(intervals, now)
+
+	var selected []dbmodel.App
+	for i := range apps {
+		if due[apps[i].ID] {
+			selected = append(selected, apps[i])
+		}
+	}
+	return selected, nil
+}
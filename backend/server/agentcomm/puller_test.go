@@ -55,3 +55,119 @@ func TestReadIntervalFromDatabase(t *testing.T) {
 		return currentInterval == 10
 	}, 5*time.Second, time.Second, "puller didn't update the interval")
 }
+
+// Test that the puller runs at the shortest of the global interval and
+// the overrides and selects the apps according to their intervals.
+func TestSelectAppsToPull(t *testing.T) {
+	// Arrange
+	db, _, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+	_ = dbmodel.InitializeSettings(db)
+	_ = dbmodel.SetSettingInt(db, "kea_hosts_puller_interval", 20)
+
+	var apps []dbmodel.App
+	for i := 0; i < 4; i++ {
+		machine := &dbmodel.Machine{
+			Address:   "localhost",
+			AgentPort: int64(8080 + i),
+		}
+		require.NoError(t, dbmodel.AddMachine(db, machine))
+		var accessPoints []*dbmodel.AccessPoint
+		accessPoints = dbmodel.AppendAccessPoint(accessPoints, dbmodel.AccessPointControl, "localhost", "", 8000, false)
+		app := dbmodel.App{
+			MachineID:    machine.ID,
+			Type:         dbmodel.AppTypeKea,
+			AccessPoints: accessPoints,
+		}
+		_, err := dbmodel.AddApp(db, &app)
+		require.NoError(t, err)
+		apps = append(apps, app)
+	}
+
+	// The first app is pulled every 60 seconds because of its machine,
+	// the second app is excluded and the third app is pulled every 30
+	// seconds. The fourth app uses the global interval.
+	overrides := []dbmodel.PullerInterval{
+		{Puller: "kea_hosts_puller_interval", MachineID: apps[0].MachineID, Interval: 60},
+		{Puller: "kea_hosts_puller_interval", AppID: apps[1].ID, Interval: 0},
+		{Puller: "kea_hosts_puller_interval", AppID: apps[2].ID, Interval: 30},
+		// The override of the other puller is ignored.
+		{Puller: "kea_stats_puller_interval", AppID: apps[3].ID, Interval: 0},
+	}
+	for i := range overrides {
+		require.NoError(t, dbmodel.SetPullerInterval(db, &overrides[i]))
+	}
+
+	puller, err := NewPeriodicPuller(db, nil, "test puller", "kea_hosts_puller_interval",
+		func() error { return nil })
+	require.NoError(t, err)
+	defer puller.Shutdown()
+
+	// Act & Assert
+	require.EqualValues(t, 20, puller.GetInterval())
+
+	// All apps except the excluded one are pulled for the first time.
+	now := time.Now()
+	selected, err := puller.selectAppsToPull(apps, now)
+	require.NoError(t, err)
+	require.Len(t, selected, 3)
+	require.Equal(t, apps[0].ID, selected[0].ID)
+	require.Equal(t, apps[2].ID, selected[1].ID)
+	require.Equal(t, apps[3].ID, selected[2].ID)
+
+	// None of the intervals has elapsed yet. The fourth app is due
+	// first.
+	selected, err = puller.selectAppsToPull(apps, now.Add(10*time.Second))
+	require.NoError(t, err)
+	require.Empty(t, selected)
+	require.EqualValues(t, 10, puller.schedule.getNextInterval(now.Add(10*time.Second), 20))
+
+	// The fourth app uses the global interval.
+	selected, err = puller.selectAppsToPull(apps, now.Add(20*time.Second))
+	require.NoError(t, err)
+	require.Len(t, selected, 1)
+	require.Equal(t, apps[3].ID, selected[0].ID)
+
+	// The third app is pulled every 30 seconds.
+	selected, err = puller.selectAppsToPull(apps, now.Add(30*time.Second))
+	require.NoError(t, err)
+	require.Len(t, selected, 1)
+	require.Equal(t, apps[2].ID, selected[0].ID)
+
+	selected, err = puller.selectAppsToPull(apps, now.Add(40*time.Second))
+	require.NoError(t, err)
+	require.Len(t, selected, 1)
+	require.Equal(t, apps[3].ID, selected[0].ID)
+
+	// All intervals elapsed.
+	selected, err = puller.selectAppsToPull(apps, now.Add(60*time.Second))
+	require.NoError(t, err)
+	require.Len(t, selected, 3)
+}
+
+// Test that the app using the global interval is pulled on each execution
+// when there are no overrides.
+func TestSelectAppsToPullWithoutOverrides(t *testing.T) {
+	db, _, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+	_ = dbmodel.InitializeSettings(db)
+	_ = dbmodel.SetSettingInt(db, "kea_hosts_puller_interval", 20)
+
+	puller, err := NewPeriodicPuller(db, nil, "test puller", "kea_hosts_puller_interval",
+		func() error { return nil })
+	require.NoError(t, err)
+	defer puller.Shutdown()
+
+	apps := []dbmodel.App{{ID: 1}, {ID: 2}}
+	for i := 0; i < 2; i++ {
+		selected, err := puller.SelectAppsToPull(apps)
+		require.NoError(t, err)
+		require.Len(t, selected, 2)
+	}
+
+	// Disabling the puller excludes all apps.
+	_ = dbmodel.SetSettingInt(db, "kea_hosts_puller_interval", 0)
+	selected, err := puller.SelectAppsToPull(apps)
+	require.NoError(t, err)
+	require.Empty(t, selected)
+}
@@ -0,0 +1,144 @@
+package agentcomm
+
+import (
+	"container/heap"
+	"math"
+	"sync"
+	"time"
+)
+
+// Scheduled pull from an app. It holds the interval of the app and the
+// time when the data should be pulled from the app next time.
+type scheduledPull struct {
+	appID    int64
+	interval int64
+	due      time.Time
+	index    int
+}
+
+// Min-heap of the scheduled pulls ordered by the time when they are due.
+// It implements heap.Interface.
+type pullQueue []*scheduledPull
+
+// Returns the number of the scheduled pulls.
+func (q pullQueue) Len() int {
+	return len(q)
+}
+
+// Checks if the i-th pull is due before the j-th pull.
+func (q pullQueue) Less(i, j int) bool {
+	return q[i].due.Before(q[j].due)
+}
+
+// Swaps the i-th and j-th pulls.
+func (q pullQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+// Appends the scheduled pull. It must be called via heap.Push.
+func (q *pullQueue) Push(x interface{}) {
+	pull := x.(*scheduledPull)
+	pull.index = len(*q)
+	*q = append(*q, pull)
+}
+
+// Removes the last pull. It must be called via heap.Pop or heap.Remove.
+func (q *pullQueue) Pop() interface{} {
+	old := *q
+	n := len(old)
+	pull := old[n-1]
+	old[n-1] = nil
+	*q = old[:n-1]
+	return pull
+}
+
+// Schedule of the pulls from the apps having different intervals. Each
+// app is pulled at its own interval counted from the last pull from this
+// app. The puller executes its function when the earliest pull is due.
+type pullSchedule struct {
+	mutex sync.Mutex
+	queue pullQueue
+	pulls map[int64]*scheduledPull
+}
+
+// Creates an empty schedule.
+func newPullSchedule() *pullSchedule {
+	return &pullSchedule{
+		pulls: make(map[int64]*scheduledPull),
+	}
+}
+
+// Selects the apps due for pulling at the specified time and schedules
+// their next pulls. The intervals are indexed by app ID and they include
+// all apps which can be pulled. The app pulled for the first time is
+// selected immediately. The change of the app interval is counted from
+// the last pull from this app. The apps absent in the intervals are
+// removed from the schedule.
+func (s *pullSchedule) selectDue(intervals map[int64]int64, now time.Time) map[int64]bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for appID, pull := range s.pulls {
+		if _, ok := intervals[appID]; !ok {
+			heap.Remove(&s.queue, pull.index)
+			delete(s.pulls, appID)
+		}
+	}
+
+	due := make(map[int64]bool)
+	for appID, interval := range intervals {
+		pull, ok := s.pulls[appID]
+		if !ok {
+			pull = &scheduledPull{
+				appID:    appID,
+				interval: interval,
+				due:      now.Add(time.Duration(interval) * time.Second),
+			}
+			heap.Push(&s.queue, pull)
+			s.pulls[appID] = pull
+			due[appID] = true
+			continue
+		}
+		if pull.interval != interval {
+			pull.due = pull.due.Add(time.Duration(interval-pull.interval) * time.Second)
+			pull.interval = interval
+		}
+		if !pull.due.After(now) {
+			pull.due = now.Add(time.Duration(interval) * time.Second)
+			due[appID] = true
+		}
+		heap.Fix(&s.queue, pull.index)
+	}
+	return due
+}
+
+// Returns the number of seconds from the specified time until the
+// earliest pull is due. It is at least 1 second and at most the maximum
+// interval. The maximum interval is returned when the schedule is empty.
+func (s *pullSchedule) getNextInterval(now time.Time, maxInterval int64) int64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if len(s.queue) == 0 {
+		return maxInterval
+	}
+	interval := int64(math.Ceil(s.queue[0].due.Sub(now).Seconds()))
+	switch {
+	case interval < 1:
+		return 1
+	case interval > maxInterval:
+		return maxInterval
+	default:
+		return interval
+	}
+}
+
+// Removes all pulls from the schedule.
+func (s *pullSchedule) clear() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.queue = nil
+	s.pulls = make(map[int64]*scheduledPull)
+}
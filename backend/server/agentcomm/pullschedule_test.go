@@ -0,0 +1,83 @@
+package agentcomm
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Test that the apps are pulled at their own intervals counted from
+// their last pulls.
+func TestPullScheduleSelectDue(t *testing.T) {
+	schedule := newPullSchedule()
+	now := time.Now()
+	intervals := map[int64]int64{1: 7, 2: 60}
+
+	// The apps are pulled for the first time.
+	due := schedule.selectDue(intervals, now)
+	require.True(t, due[1])
+	require.True(t, due[2])
+	require.EqualValues(t, 7, schedule.getNextInterval(now, 60))
+
+	// The first app is pulled every 7 seconds and the second one every
+	// 60 seconds, regardless of the common divisor of the intervals.
+	for i := 1; i < 9; i++ {
+		at := now.Add(time.Duration(7*i) * time.Second)
+		due = schedule.selectDue(intervals, at)
+		require.Equal(t, map[int64]bool{1: true}, due)
+	}
+	at := now.Add(60 * time.Second)
+	due = schedule.selectDue(intervals, at)
+	require.Equal(t, map[int64]bool{2: true}, due)
+	require.EqualValues(t, 3, schedule.getNextInterval(at, 60))
+}
+
+// Test that the changed interval is counted from the last pull.
+func TestPullScheduleIntervalChanged(t *testing.T) {
+	schedule := newPullSchedule()
+	now := time.Now()
+
+	due := schedule.selectDue(map[int64]int64{1: 60}, now)
+	require.True(t, due[1])
+
+	due = schedule.selectDue(map[int64]int64{1: 10}, now.Add(5*time.Second))
+	require.Empty(t, due)
+	require.EqualValues(t, 5, schedule.getNextInterval(now.Add(5*time.Second), 60))
+
+	due = schedule.selectDue(map[int64]int64{1: 10}, now.Add(10*time.Second))
+	require.True(t, due[1])
+}
+
+// Test that the apps which are no longer pulled are removed from the
+// schedule.
+func TestPullScheduleRemoveApps(t *testing.T) {
+	schedule := newPullSchedule()
+	now := time.Now()
+
+	schedule.selectDue(map[int64]int64{1: 10, 2: 30}, now)
+	require.Len(t, schedule.pulls, 2)
+
+	schedule.selectDue(map[int64]int64{2: 30}, now.Add(time.Second))
+	require.Len(t, schedule.pulls, 1)
+	require.Len(t, schedule.queue, 1)
+	require.EqualValues(t, 29, schedule.getNextInterval(now.Add(time.Second), 60))
+
+	// The app added again is pulled immediately.
+	due := schedule.selectDue(map[int64]int64{1: 10, 2: 30}, now.Add(2*time.Second))
+	require.True(t, due[1])
+
+	schedule.clear()
+	require.Empty(t, schedule.queue)
+	require.EqualValues(t, 60, schedule.getNextInterval(now, 60))
+}
+
+// Test that the next interval is bounded.
+func TestPullScheduleNextIntervalBounds(t *testing.T) {
+	schedule := newPullSchedule()
+	now := time.Now()
+
+	schedule.selectDue(map[int64]int64{1: 120}, now)
+	require.EqualValues(t, 60, schedule.getNextInterval(now, 60))
+	require.EqualValues(t, 1, schedule.getNextInterval(now.Add(200*time.Second), 60))
+}
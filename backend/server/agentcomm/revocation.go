@@ -0,0 +1,139 @@
+package agentcomm
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc/security/advancedtls"
+
+	dbops "isc.org/stork/server/database"
+	dbmodel "isc.org/stork/server/database/model"
+)
+
+// List of the agent certs revoked by the server, e.g. because the machines
+// have been deleted. The certs are identified by the serial numbers. The
+// fingerprints identify the certs whose serial numbers are not known.
+type revokedCerts struct {
+	mutex         sync.RWMutex
+	serialNumbers map[int64]bool
+	fingerprints  map[[sha256.Size]byte]bool
+}
+
+// Creates an empty list of the revoked certs.
+func newRevokedCerts() *revokedCerts {
+	return &revokedCerts{
+		serialNumbers: make(map[int64]bool),
+		fingerprints:  make(map[[sha256.Size]byte]bool),
+	}
+}
+
+// Adds the certs to the list. It returns the certs which haven't been
+// on the list yet.
+func (rc *revokedCerts) add(certs ...dbmodel.RevokedCert) (added []dbmodel.RevokedCert) {
+	rc.mutex.Lock()
+	defer rc.mutex.Unlock()
+	for _, cert := range certs {
+		isNew := false
+		if cert.SerialNumber != 0 && !rc.serialNumbers[cert.SerialNumber] {
+			rc.serialNumbers[cert.SerialNumber] = true
+			isNew = true
+		}
+		if cert.Fingerprint != [sha256.Size]byte{} && !rc.fingerprints[cert.Fingerprint] {
+			rc.fingerprints[cert.Fingerprint] = true
+			isNew = true
+		}
+		if isNew {
+			added = append(added, cert)
+		}
+	}
+	return added
+}
+
+// Checks if the cert has been revoked.
+func (rc *revokedCerts) isRevoked(cert *x509.Certificate) bool {
+	rc.mutex.RLock()
+	defer rc.mutex.RUnlock()
+	if cert.SerialNumber != nil && cert.SerialNumber.IsInt64() && rc.serialNumbers[cert.SerialNumber.Int64()] {
+		return true
+	}
+	return rc.fingerprints[sha256.Sum256(cert.Raw)]
+}
+
+// Returns the sorted serial numbers of the revoked certs. They are sent
+// to the agents, so the agents refuse the connections using these certs.
+func (rc *revokedCerts) getSerialNumbers() []int64 {
+	rc.mutex.RLock()
+	defer rc.mutex.RUnlock()
+	serialNumbers := make([]int64, 0, len(rc.serialNumbers))
+	for serialNumber := range rc.serialNumbers {
+		serialNumbers = append(serialNumbers, serialNumber)
+	}
+	sort.Slice(serialNumbers, func(i, j int) bool {
+		return serialNumbers[i] < serialNumbers[j]
+	})
+	return serialNumbers
+}
+
+// Verification hook for advanced TLS. It refuses the agent presenting
+// the revoked cert. The cert chain has been already verified when it is
+// called. It accepts all certs if the list is nil.
+func (rc *revokedCerts) verifyPeer(params *advancedtls.VerificationFuncParams) (*advancedtls.VerificationResults, error) {
+	if rc != nil && params.Leaf != nil && rc.isRevoked(params.Leaf) {
+		return nil, errors.Errorf("agent cert with serial number %s has been revoked", params.Leaf.SerialNumber)
+	}
+	return &advancedtls.VerificationResults{}, nil
+}
+
+// Revokes the agent certs. The server refuses the connections and the
+// tunnels from the agents presenting these certs. The existing
+// connections and tunnels of the agents are closed. The certs which
+// have been already revoked are ignored, so the connections of the
+// agents re-registered at the same addresses are not closed when the
+// list is reloaded.
+func (agents *connectedAgentsData) RevokeCerts(certs ...dbmodel.RevokedCert) {
+	for _, cert := range agents.revokedCerts.add(certs...) {
+		addrPort := net.JoinHostPort(cert.Address, strconv.FormatInt(cert.AgentPort, 10))
+
+		agents.tunnelsMutex.Lock()
+		if tunnel, ok := agents.tunnels[addrPort]; ok {
+			tunnel.close()
+			delete(agents.tunnels, addrPort)
+		}
+		agents.tunnelsMutex.Unlock()
+
+		agents.agentsMutex.Lock()
+		if agent, ok := agents.AgentsMap[addrPort]; ok {
+			agent.connMutex.Lock()
+			if agent.GrpcConn != nil {
+				agent.GrpcConn.Close()
+				agent.GrpcConn = nil
+			}
+			agent.connMutex.Unlock()
+			delete(agents.AgentsMap, addrPort)
+		}
+		agents.agentsMutex.Unlock()
+
+		log.WithFields(log.Fields{
+			"agent":         addrPort,
+			"serial-number": cert.SerialNumber,
+		}).Debug("revoked agent cert")
+	}
+}
+
+// Reloads the revoked certs from the database. The certs may have been
+// revoked by another Stork Server instance sharing the database, e.g.
+// when the machine has been deleted using that instance.
+func ReloadRevokedCerts(db dbops.DBI, agents ConnectedAgents) error {
+	revokedCerts, err := dbmodel.GetRevokedCerts(db)
+	if err != nil {
+		return err
+	}
+	agents.RevokeCerts(revokedCerts...)
+	return nil
+}
@@ -0,0 +1,123 @@
+package agentcomm
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/security/advancedtls"
+
+	dbmodel "isc.org/stork/server/database/model"
+	dbtest "isc.org/stork/server/database/test"
+)
+
+// Test that the revoked certs are identified by the serial numbers or
+// by the fingerprints.
+func TestRevokedCerts(t *testing.T) {
+	legacyCert := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Raw:          []byte("legacy"),
+	}
+	rc := newRevokedCerts()
+	added := rc.add(dbmodel.RevokedCert{
+		SerialNumber: 7,
+	}, dbmodel.RevokedCert{
+		SerialNumber: 3,
+	}, dbmodel.RevokedCert{
+		Fingerprint: sha256.Sum256(legacyCert.Raw),
+	})
+	require.Len(t, added, 3)
+
+	// Only the certs not revoked yet are returned.
+	added = rc.add(dbmodel.RevokedCert{
+		SerialNumber: 7,
+	}, dbmodel.RevokedCert{
+		SerialNumber: 8,
+	})
+	require.Len(t, added, 1)
+	require.EqualValues(t, 8, added[0].SerialNumber)
+
+	require.True(t, rc.isRevoked(&x509.Certificate{SerialNumber: big.NewInt(7)}))
+	require.True(t, rc.isRevoked(legacyCert))
+	require.False(t, rc.isRevoked(&x509.Certificate{SerialNumber: big.NewInt(5), Raw: []byte("other")}))
+	require.Equal(t, []int64{3, 7, 8}, rc.getSerialNumbers())
+
+	_, err := rc.verifyPeer(&advancedtls.VerificationFuncParams{
+		Leaf: &x509.Certificate{SerialNumber: big.NewInt(3)},
+	})
+	require.Error(t, err)
+	_, err = rc.verifyPeer(&advancedtls.VerificationFuncParams{
+		Leaf: &x509.Certificate{SerialNumber: big.NewInt(4)},
+	})
+	require.NoError(t, err)
+
+	// All certs are accepted when there is no list.
+	var nilList *revokedCerts
+	_, err = nilList.verifyPeer(&advancedtls.VerificationFuncParams{
+		Leaf: &x509.Certificate{SerialNumber: big.NewInt(3)},
+	})
+	require.NoError(t, err)
+}
+
+// Test that the connection to the agent is closed when its cert is revoked.
+func TestRevokeCerts(t *testing.T) {
+	settings := AgentsSettings{}
+	agents := NewConnectedAgents(&settings, nil, CACertPEM, ServerCertPEM, ServerKeyPEM)
+	defer agents.Shutdown()
+
+	agent, err := agents.GetConnectedAgent("127.0.0.1:8080")
+	require.NoError(t, err)
+	require.NotNil(t, agent.GrpcConn)
+
+	agents.RevokeCerts(dbmodel.RevokedCert{
+		SerialNumber: 10,
+		Address:      "127.0.0.1",
+		AgentPort:    8080,
+	})
+
+	require.Nil(t, agent.GrpcConn)
+	_, ok := agents.(*connectedAgentsData).lookupAgent("127.0.0.1:8080")
+	require.False(t, ok)
+	require.Equal(t, []int64{10}, agents.(*connectedAgentsData).revokedCerts.getSerialNumbers())
+
+	// Revoking the same cert again doesn't close the connection of the
+	// agent re-registered at the same address.
+	agent, err = agents.GetConnectedAgent("127.0.0.1:8080")
+	require.NoError(t, err)
+	agents.RevokeCerts(dbmodel.RevokedCert{
+		SerialNumber: 10,
+		Address:      "127.0.0.1",
+		AgentPort:    8080,
+	})
+	require.NotNil(t, agent.GrpcConn)
+	_, ok = agents.(*connectedAgentsData).lookupAgent("127.0.0.1:8080")
+	require.True(t, ok)
+}
+
+// Test that the revoked certs are reloaded from the database.
+func TestReloadRevokedCerts(t *testing.T) {
+	db, _, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	settings := AgentsSettings{}
+	agents := NewConnectedAgents(&settings, nil, CACertPEM, ServerCertPEM, ServerKeyPEM)
+	defer agents.Shutdown()
+
+	err := ReloadRevokedCerts(db, agents)
+	require.NoError(t, err)
+	require.Empty(t, agents.(*connectedAgentsData).revokedCerts.getSerialNumbers())
+
+	// The cert is revoked by another server instance.
+	err = dbmodel.AddRevokedCert(db, &dbmodel.RevokedCert{
+		SerialNumber: 12,
+		Address:      "127.0.0.1",
+		AgentPort:    8080,
+	})
+	require.NoError(t, err)
+
+	err = ReloadRevokedCerts(db, agents)
+	require.NoError(t, err)
+	require.Equal(t, []int64{12}, agents.(*connectedAgentsData).revokedCerts.getSerialNumbers())
+}
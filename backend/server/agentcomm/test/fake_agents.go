@@ -25,8 +25,26 @@ type FakeAgents struct {
 	RecordedStatsURL string
 	mockNamedFunc    func(int, interface{})
 
+	RecordedFollowOffset int64
+	// Matches returned by the fake text file search. A single fixed
+	// match is returned when it is nil.
+	SearchMatches []*agentcomm.TextFileMatch
+
 	MachineState   *agentcomm.State
 	GetStateCalled bool
+
+	RecordedAgentCert []byte
+	RecordedCACert    []byte
+	RecordedRenewCert bool
+	RenewCertErr      error
+	TrustedCACertPEM  []byte
+	ServerCertPEM     []byte
+
+	RecordedRevokedCerts []dbmodel.RevokedCert
+
+	QueueStats map[string]agentcomm.AgentQueueStats
+
+	CommErrorStats map[string][]agentcomm.AgentCommErrorStats
 }
 
 // mockRndcOutput returns some mocked named response.
@@ -92,10 +110,14 @@ func (fa *FakeAgents) GetConnectedAgentStats(address string, port int64) *agentc
 				CurrentErrorsDaemons: map[string]int64{
 					"dhcp4": 5,
 				},
+				CircuitBreakersDaemons: map[string]*agentcomm.CircuitBreaker{
+					"dhcp4": {State: agentcomm.CircuitOpen},
+				},
 			},
 			{Address: "localhost", Port: 4321}: &agentcomm.AgentBind9CommStats{
-				CurrentErrorsRNDC:  2,
-				CurrentErrorsStats: 3,
+				CurrentErrorsRNDC:   2,
+				CurrentErrorsStats:  3,
+				CircuitBreakerStats: &agentcomm.CircuitBreaker{State: agentcomm.CircuitHalfOpen},
 			},
 		},
 	}
@@ -103,8 +125,9 @@ func (fa *FakeAgents) GetConnectedAgentStats(address string, port int64) *agentc
 }
 
 // FakeAgents specific implementation of the GetState.
-func (fa *FakeAgents) GetState(ctx context.Context, address string, agentPort int64) (*agentcomm.State, error) {
+func (fa *FakeAgents) GetState(ctx context.Context, address string, agentPort int64, renewCert bool) (*agentcomm.State, error) {
 	fa.GetStateCalled = true
+	fa.RecordedRenewCert = renewCert
 
 	if fa.MachineState != nil {
 		return fa.MachineState, nil
@@ -117,6 +140,26 @@ func (fa *FakeAgents) GetState(ctx context.Context, address string, agentPort in
 	return &state, nil
 }
 
+// FakeAgents specific implementation of the function sending the renewed
+// certificate to the agent. It records the certificates and returns the
+// error set by the test.
+func (fa *FakeAgents) RenewCertificate(ctx context.Context, address string, agentPort int64, certPEM, caCertPEM []byte) error {
+	fa.RecordedAgentCert = certPEM
+	fa.RecordedCACert = caCertPEM
+	return fa.RenewCertErr
+}
+
+// FakeAgents specific implementation of the function revoking the agent
+// certs. It records the revoked certs.
+func (fa *FakeAgents) RevokeCerts(certs ...dbmodel.RevokedCert) {
+	fa.RecordedRevokedCerts = append(fa.RecordedRevokedCerts, certs...)
+}
+
+// Returns the CA and server certs set by the test.
+func (fa *FakeAgents) GetTLSCerts() ([]byte, []byte) {
+	return fa.TrustedCACertPEM, fa.ServerCertPEM
+}
+
 // Returns last received command by FakeAgents or nil if no command
 // has been received yet.
 func (fa *FakeAgents) GetLastCommand() *keactrl.Command {
@@ -132,12 +175,11 @@ func (fa *FakeAgents) GetLastCommand() *keactrl.Command {
 // response to the command by calling the function specified in the
 // call to NewFakeAgents or NewKeaFakeAgents.
 func (fa *FakeAgents) ForwardToKeaOverHTTP(ctx context.Context, dbApp *dbmodel.App, commands []*keactrl.Command, cmdResponses ...interface{}) (*agentcomm.KeaCmdsResult, error) {
-	ctrlPoint, _ := dbApp.GetAccessPoint(dbmodel.AccessPointControl)
-	caAddress := ctrlPoint.Address
-	caPort := ctrlPoint.Port
-	caUseSecureProtocol := ctrlPoint.UseSecureProtocol
-
-	caURL := storkutil.HostWithPortURL(caAddress, caPort, caUseSecureProtocol)
+	ctrlPoint, _ := dbApp.GetControlAccessPoint()
+	caURL := ctrlPoint.Address
+	if ctrlPoint.Type != dbmodel.AccessPointControlSocket {
+		caURL = storkutil.HostWithPortURL(ctrlPoint.Address, ctrlPoint.Port, ctrlPoint.UseSecureProtocol)
+	}
 
 	fa.RecordedURL = caURL
 	result := &agentcomm.KeaCmdsResult{}
@@ -202,3 +244,54 @@ func (fa *FakeAgents) ForwardRndcCommand(ctx context.Context, dbApp *dbmodel.App
 func (fa *FakeAgents) TailTextFile(ctx context.Context, agentAddress string, agentPort int64, path string, offset int64) ([]string, error) {
 	return []string{"lorem ipsum"}, nil
 }
+
+// FakeAgents specific implementation of the function which follows the
+// text file. It passes the fixed lines to the handler once and returns.
+func (fa *FakeAgents) FollowTextFile(ctx context.Context, agentAddress string, agentPort int64, path string, offset int64, handler func(lines []string) error) error {
+	fa.RecordedFollowOffset = offset
+	return handler([]string{"lorem ipsum", "dolor sit amet"})
+}
+
+// FakeAgents specific implementation of the function which searches the
+// text file. It passes the matches set by the test or a single fixed match
+// to the handler. The number of matches is limited by the criteria.
+func (fa *FakeAgents) SearchTextFile(ctx context.Context, agentAddress string, agentPort int64, path string, criteria *agentcomm.TextFileSearchCriteria, handler func(matches []*agentcomm.TextFileMatch) error) (int64, error) {
+	matches := fa.SearchMatches
+	if matches == nil {
+		matches = []*agentcomm.TextFileMatch{
+			{
+				LineNumber: 2,
+				Line:       "lorem ipsum",
+				Before:     []string{"dolor sit amet"},
+			},
+		}
+	}
+	if criteria.MaxMatches > 0 && int64(len(matches)) > criteria.MaxMatches {
+		matches = matches[:criteria.MaxMatches]
+	}
+	err := handler(matches)
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(matches)), nil
+}
+
+// FakeAgents specific implementation of the function which returns the
+// statistics of the request queues. It returns the statistics set by
+// the test or an empty map.
+func (fa *FakeAgents) GetQueueStats() map[string]agentcomm.AgentQueueStats {
+	if fa.QueueStats == nil {
+		return make(map[string]agentcomm.AgentQueueStats)
+	}
+	return fa.QueueStats
+}
+
+// FakeAgents specific implementation of the function which returns the
+// current errors in communication with the agents. It returns the
+// statistics set by the test or an empty map.
+func (fa *FakeAgents) GetCommErrorStats() map[string][]agentcomm.AgentCommErrorStats {
+	if fa.CommErrorStats == nil {
+		return make(map[string][]agentcomm.AgentCommErrorStats)
+	}
+	return fa.CommErrorStats
+}
@@ -0,0 +1,474 @@
+package agentcomm
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+
+	pkgerrors "github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/encoding"
+	grpcproto "google.golang.org/grpc/encoding/proto"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/security/advancedtls"
+	"google.golang.org/grpc/status"
+
+	agentapi "isc.org/stork/api"
+	"isc.org/stork/tracing"
+)
+
+// Names of the metadata sent by the agent opening the tunnel. They
+// identify the agent the same way as the address and the port used
+// by the server to dial the agent.
+const (
+	TunnelAgentAddressKey = "stork-agent-address"
+	TunnelAgentPortKey    = "stork-agent-port"
+)
+
+// Maximum number of the messages received from the agent and queued
+// for a single call. The call is aborted when the caller doesn't keep
+// up with receiving the messages, so it doesn't hold the messages of
+// the other calls.
+const maxTunnelCallQueueLength = 1000
+
+// Single call made over the tunnel. The messages received from the
+// agent are passed to the caller over the messages channel.
+type tunnelCall struct {
+	id       int64
+	method   string
+	messages chan *agentapi.TunnelMessage
+	// Closed when the caller is no longer interested in the messages or
+	// when the call is aborted.
+	finished chan struct{}
+}
+
+// Tunnel opened by the agent which cannot be reached by the server. It
+// implements the grpc.ClientConnInterface, so the agent client created
+// for the tunnel can be used the same way as the client created for the
+// regular gRPC connection. The calls are multiplexed over the tunnel
+// using the call identifiers.
+type agentTunnel struct {
+	stream agentapi.ServerTunnel_ConnectServer
+	codec  encoding.Codec
+
+	// Protects the stream against the concurrent sends and the closed flag.
+	sendMutex sync.Mutex
+	closed    bool
+	done      chan struct{}
+
+	// Protects the calls map and the last call identifier.
+	callsMutex sync.Mutex
+	calls      map[int64]*tunnelCall
+	lastCallID int64
+}
+
+// Creates the tunnel over the stream opened by the agent.
+func newAgentTunnel(stream agentapi.ServerTunnel_ConnectServer) *agentTunnel {
+	return &agentTunnel{
+		stream: stream,
+		codec:  encoding.GetCodec(grpcproto.Name),
+		done:   make(chan struct{}),
+		calls:  make(map[int64]*tunnelCall),
+	}
+}
+
+// Sends the message to the agent. It returns an error if the tunnel
+// is closed.
+func (t *agentTunnel) send(msg *agentapi.TunnelMessage) error {
+	t.sendMutex.Lock()
+	defer t.sendMutex.Unlock()
+	if t.closed {
+		return pkgerrors.New("tunnel to the agent is closed")
+	}
+	return pkgerrors.Wrapf(t.stream.Send(msg), "problem with sending the message over the tunnel")
+}
+
+// Receives the messages from the agent and passes them to the calls
+// they belong to. It returns when the tunnel is broken, closed by the
+// agent or closed by the server. The tunnel is closed before returning.
+func (t *agentTunnel) receive() error {
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- t.dispatch()
+	}()
+	select {
+	case err := <-errCh:
+		t.close()
+		return err
+	case <-t.done:
+		return nil
+	}
+}
+
+// Receives the messages from the agent until an error occurs.
+func (t *agentTunnel) dispatch() error {
+	for {
+		msg, err := t.stream.Recv()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		t.callsMutex.Lock()
+		call, ok := t.calls[msg.CallID]
+		t.callsMutex.Unlock()
+		if !ok {
+			// The call has been already finished, e.g. cancelled by the caller.
+			continue
+		}
+		select {
+		case call.messages <- msg:
+		default:
+			// The caller is too slow. Abort its call rather than block the
+			// messages of the other calls.
+			log.WithFields(log.Fields{
+				"method": call.method,
+			}).Warnf("aborting call over the tunnel because its responses are not received in time")
+			t.finishCall(call, true)
+		}
+	}
+}
+
+// Closes the tunnel. The pending calls are terminated with an error.
+func (t *agentTunnel) close() {
+	t.sendMutex.Lock()
+	defer t.sendMutex.Unlock()
+	if !t.closed {
+		t.closed = true
+		close(t.done)
+	}
+}
+
+// Starts a new call by sending the request to the agent. The trace
+// context is sent along with the request because the gRPC metadata is
+// not carried by the tunnel.
+func (t *agentTunnel) startCall(ctx context.Context, method string, args interface{}) (*tunnelCall, error) {
+	payload, err := t.codec.Marshal(args)
+	if err != nil {
+		return nil, pkgerrors.Wrapf(err, "problem with serializing the request to %s", method)
+	}
+
+	t.callsMutex.Lock()
+	t.lastCallID++
+	call := &tunnelCall{
+		id:       t.lastCallID,
+		method:   method,
+		messages: make(chan *agentapi.TunnelMessage, maxTunnelCallQueueLength),
+		finished: make(chan struct{}),
+	}
+	t.calls[call.id] = call
+	t.callsMutex.Unlock()
+
+	err = t.send(&agentapi.TunnelMessage{
+		CallID:   call.id,
+		Method:   method,
+		Payload:  payload,
+		Metadata: tracing.InjectMap(ctx),
+	})
+	if err != nil {
+		t.finishCall(call, false)
+		return nil, err
+	}
+	return call, nil
+}
+
+// Waits for the next message of the call. It returns an error when the
+// agent returns an error, the call is aborted, the context is cancelled
+// or the tunnel is closed. The call failed by the agent is finished
+// without notifying the agent because the agent has already finished it.
+func (t *agentTunnel) recvCallMessage(ctx context.Context, call *tunnelCall) (*agentapi.TunnelMessage, error) {
+	select {
+	case msg := <-call.messages:
+		if msg.Error != "" {
+			t.finishCall(call, false)
+			// The agents not sending the status code return unknown errors.
+			code := codes.Code(msg.ErrorCode)
+			if code == codes.OK {
+				code = codes.Unknown
+			}
+			return nil, status.Error(code, msg.Error)
+		}
+		return msg, nil
+	case <-call.finished:
+		return nil, status.Error(codes.ResourceExhausted, "call aborted because its responses were not received in time")
+	case <-ctx.Done():
+		return nil, status.FromContextError(ctx.Err()).Err()
+	case <-t.done:
+		return nil, status.Error(codes.Unavailable, "tunnel to the agent is closed")
+	}
+}
+
+// Stops tracking the call. If the cancel flag is set, the agent is
+// notified that the call is no longer needed. It does nothing if the
+// call has been already finished.
+func (t *agentTunnel) finishCall(call *tunnelCall, cancel bool) {
+	t.callsMutex.Lock()
+	if _, ok := t.calls[call.id]; !ok {
+		t.callsMutex.Unlock()
+		return
+	}
+	delete(t.calls, call.id)
+	close(call.finished)
+	t.callsMutex.Unlock()
+
+	if cancel {
+		_ = t.send(&agentapi.TunnelMessage{
+			CallID: call.id,
+			End:    true,
+		})
+	}
+}
+
+// Makes the unary call over the tunnel. It is a part of the
+// grpc.ClientConnInterface.
+func (t *agentTunnel) Invoke(ctx context.Context, method string, args, reply interface{}, opts ...grpc.CallOption) error {
+	call, err := t.startCall(ctx, method, args)
+	if err != nil {
+		return err
+	}
+	msg, err := t.recvCallMessage(ctx, call)
+	// The agent finishes the unary call after sending the response, so
+	// it only needs to be notified when the response is not received.
+	t.finishCall(call, err != nil)
+	if err != nil {
+		return err
+	}
+	return pkgerrors.Wrapf(t.codec.Unmarshal(msg.Payload, reply), "problem with parsing the response to %s", method)
+}
+
+// Opens the stream over the tunnel. It is a part of the
+// grpc.ClientConnInterface. Only the server-side streaming is
+// supported, i.e. the client sends exactly one request.
+func (t *agentTunnel) NewStream(ctx context.Context, desc *grpc.StreamDesc, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	if desc.ClientStreams {
+		return nil, status.Errorf(codes.Unimplemented, "client-side streaming is not supported over the tunnel: %s", method)
+	}
+	return &tunnelClientStream{
+		tunnel: t,
+		ctx:    ctx,
+		method: method,
+	}, nil
+}
+
+// Client side of the stream opened over the tunnel.
+type tunnelClientStream struct {
+	tunnel *agentTunnel
+	ctx    context.Context
+	method string
+	call   *tunnelCall
+}
+
+// Returns an empty header. It is a part of the grpc.ClientStream.
+func (s *tunnelClientStream) Header() (metadata.MD, error) {
+	return metadata.MD{}, nil
+}
+
+// Returns an empty trailer. It is a part of the grpc.ClientStream.
+func (s *tunnelClientStream) Trailer() metadata.MD {
+	return metadata.MD{}
+}
+
+// Does nothing because the request has been already sent. It is a part
+// of the grpc.ClientStream.
+func (s *tunnelClientStream) CloseSend() error {
+	return nil
+}
+
+// Returns the context of the stream. It is a part of the grpc.ClientStream.
+func (s *tunnelClientStream) Context() context.Context {
+	return s.ctx
+}
+
+// Sends the request starting the call. It is a part of the grpc.ClientStream.
+func (s *tunnelClientStream) SendMsg(m interface{}) error {
+	if s.call != nil {
+		return status.Errorf(codes.Unimplemented, "client-side streaming is not supported over the tunnel: %s", s.method)
+	}
+	call, err := s.tunnel.startCall(s.ctx, s.method, m)
+	if err != nil {
+		return err
+	}
+	s.call = call
+	return nil
+}
+
+// Receives the next response. It returns io.EOF when the agent finishes
+// the call. It is a part of the grpc.ClientStream.
+func (s *tunnelClientStream) RecvMsg(m interface{}) error {
+	if s.call == nil {
+		return status.Errorf(codes.Internal, "no request sent over the tunnel: %s", s.method)
+	}
+	msg, err := s.tunnel.recvCallMessage(s.ctx, s.call)
+	if err != nil {
+		s.tunnel.finishCall(s.call, true)
+		return err
+	}
+	if msg.End {
+		s.tunnel.finishCall(s.call, false)
+		return io.EOF
+	}
+	return pkgerrors.Wrapf(s.tunnel.codec.Unmarshal(msg.Payload, m), "problem with parsing the response to %s", s.method)
+}
+
+// Server accepting the tunnels opened by the agents which cannot be
+// reached by the server, e.g. because they are behind NAT. The agents
+// are authenticated with the certificates issued by the server during
+// the registration.
+type TunnelServer struct {
+	agents   *connectedAgentsData
+	server   *grpc.Server
+	listener net.Listener
+	wg       sync.WaitGroup
+
+	agentapi.UnimplementedServerTunnelServer
+}
+
+// Prepare TLS credentials for the tunnel server. The agents must present
+// the certificates signed by the server CA. The agent address is not
+// verified against its certificate during the handshake because the
+// agent connects from the address translated by NAT. It is verified
+// against the address sent by the agent instead. The agents presenting
+// the revoked certs are refused.
+func prepareTunnelTLSCreds(caCertPEM, serverCertPEM, serverKeyPEM []byte, revokedCerts *revokedCerts) (credentials.TransportCredentials, error) {
+	certificate, err := tls.X509KeyPair(serverCertPEM, serverKeyPEM)
+	if err != nil {
+		return nil, pkgerrors.Wrapf(err, "could not load server key pair")
+	}
+
+	certPool := x509.NewCertPool()
+	if ok := certPool.AppendCertsFromPEM(caCertPEM); !ok {
+		return nil, pkgerrors.New("failed to append ca certs")
+	}
+
+	options := &advancedtls.ServerOptions{
+		RootOptions: advancedtls.RootCertificateOptions{
+			RootCACerts: certPool,
+		},
+		IdentityOptions: advancedtls.IdentityCertificateOptions{
+			Certificates: []tls.Certificate{certificate},
+		},
+		RequireClientCert: true,
+		VType:             advancedtls.CertVerification,
+		VerifyPeer:        revokedCerts.verifyPeer,
+	}
+	creds, err := advancedtls.NewServerCreds(options)
+	if err != nil {
+		return nil, pkgerrors.Wrapf(err, "cannot create server credentials for TLS")
+	}
+	return creds, nil
+}
+
+// Creates the tunnel server and starts listening for the tunnels on
+// the address and port specified in the settings. The agents must be
+// created with NewConnectedAgents.
+func NewTunnelServer(settings *AgentsSettings, agents ConnectedAgents, caCertPEM, serverCertPEM, serverKeyPEM []byte) (*TunnelServer, error) {
+	agentsData, ok := agents.(*connectedAgentsData)
+	if !ok {
+		return nil, pkgerrors.New("tunnels are not supported by this implementation of the connected agents")
+	}
+
+	creds, err := prepareTunnelTLSCreds(caCertPEM, serverCertPEM, serverKeyPEM, agentsData.revokedCerts)
+	if err != nil {
+		return nil, err
+	}
+
+	addrPort := net.JoinHostPort(settings.TunnelHost, strconv.Itoa(settings.TunnelPort))
+	listener, err := net.Listen("tcp", addrPort)
+	if err != nil {
+		return nil, pkgerrors.Wrapf(err, "failed to listen on %s for agent tunnels", addrPort)
+	}
+
+	ts := &TunnelServer{
+		agents:   agentsData,
+		server:   grpc.NewServer(grpc.Creds(creds)),
+		listener: listener,
+	}
+	agentapi.RegisterServerTunnelServer(ts.server, ts)
+	return ts, nil
+}
+
+// Starts serving the tunnels in background.
+func (ts *TunnelServer) Serve() {
+	ts.wg.Add(1)
+	go func() {
+		defer ts.wg.Done()
+		log.Printf("started serving agent tunnels on %s", ts.listener.Addr())
+		if err := ts.server.Serve(ts.listener); err != nil {
+			log.Errorf("failed to serve agent tunnels: %+v", err)
+		}
+	}()
+}
+
+// Closes all tunnels and stops the server.
+func (ts *TunnelServer) Shutdown() {
+	log.Printf("stopping serving agent tunnels")
+	ts.server.Stop()
+	ts.wg.Wait()
+	log.Printf("stopped serving agent tunnels")
+}
+
+// Returns the address and the port of the agent opening the tunnel. The
+// address must match the certificate presented by the agent, so the agent
+// can't impersonate other agents.
+func getTunnelAgentAddrPort(ctx context.Context) (string, error) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok || len(md.Get(TunnelAgentAddressKey)) == 0 || len(md.Get(TunnelAgentPortKey)) == 0 {
+		return "", pkgerrors.New("agent address and port not specified")
+	}
+	address := md.Get(TunnelAgentAddressKey)[0]
+	port, err := strconv.ParseInt(md.Get(TunnelAgentPortKey)[0], 10, 64)
+	if err != nil || port <= 0 || port > 65535 {
+		return "", pkgerrors.Errorf("invalid agent port %s", md.Get(TunnelAgentPortKey)[0])
+	}
+
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return "", pkgerrors.New("no peer information")
+	}
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(tlsInfo.State.PeerCertificates) == 0 {
+		return "", pkgerrors.New("no agent certificate")
+	}
+	if err = tlsInfo.State.PeerCertificates[0].VerifyHostname(address); err != nil {
+		return "", pkgerrors.Wrapf(err, "agent certificate does not match the agent address %s", address)
+	}
+
+	return net.JoinHostPort(address, fmt.Sprint(port)), nil
+}
+
+// Handles the tunnel opened by the agent. The calls to the agent are sent
+// over the tunnel until it is closed by the agent or the server is shut
+// down. It is a part of the ServerTunnel service.
+func (ts *TunnelServer) Connect(stream agentapi.ServerTunnel_ConnectServer) error {
+	addrPort, err := getTunnelAgentAddrPort(stream.Context())
+	if err != nil {
+		log.Warnf("rejected agent tunnel: %+v", err)
+		return status.Error(codes.PermissionDenied, err.Error())
+	}
+
+	tunnel := newAgentTunnel(stream)
+	ts.agents.addTunnel(addrPort, tunnel)
+	defer ts.agents.removeTunnel(addrPort, tunnel)
+
+	log.WithFields(log.Fields{
+		"agent": addrPort,
+	}).Info("agent opened the tunnel")
+
+	err = tunnel.receive()
+
+	log.WithFields(log.Fields{
+		"agent": addrPort,
+	}).Info("agent tunnel closed")
+	return err
+}
@@ -0,0 +1,357 @@
+package agentcomm
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/encoding"
+	grpcproto "google.golang.org/grpc/encoding/proto"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	agentapi "isc.org/stork/api"
+	"isc.org/stork/pki"
+	storktest "isc.org/stork/server/test"
+)
+
+// Fake stream of the tunnel opened by the agent. The messages sent by
+// the server are written to the sent channel. The messages written to
+// the received channel are received by the server.
+type fakeTunnelStream struct {
+	grpc.ServerStream
+	ctx      context.Context
+	sent     chan *agentapi.TunnelMessage
+	received chan *agentapi.TunnelMessage
+}
+
+// Creates the fake tunnel stream.
+func newFakeTunnelStream() *fakeTunnelStream {
+	return &fakeTunnelStream{
+		ctx:      context.Background(),
+		sent:     make(chan *agentapi.TunnelMessage, 10),
+		received: make(chan *agentapi.TunnelMessage, 10),
+	}
+}
+
+// Sends the message to the fake agent.
+func (s *fakeTunnelStream) Send(msg *agentapi.TunnelMessage) error {
+	s.sent <- msg
+	return nil
+}
+
+// Receives the message from the fake agent. It returns io.EOF when
+// the received channel is closed.
+func (s *fakeTunnelStream) Recv() (*agentapi.TunnelMessage, error) {
+	msg, ok := <-s.received
+	if !ok {
+		return nil, io.EOF
+	}
+	return msg, nil
+}
+
+// Returns the stream context.
+func (s *fakeTunnelStream) Context() context.Context {
+	return s.ctx
+}
+
+// Serializes the message sent over the tunnel.
+func marshalTunnelPayload(t *testing.T, m interface{}) []byte {
+	payload, err := encoding.GetCodec(grpcproto.Name).Marshal(m)
+	require.NoError(t, err)
+	return payload
+}
+
+// Test that the unary call is sent over the tunnel and the response
+// is returned to the caller.
+func TestTunnelInvoke(t *testing.T) {
+	stream := newFakeTunnelStream()
+	tunnel := newAgentTunnel(stream)
+	go tunnel.receive()
+	defer close(stream.received)
+
+	go func() {
+		msg := <-stream.sent
+		stream.received <- &agentapi.TunnelMessage{
+			CallID: msg.CallID,
+			Payload: marshalTunnelPayload(t, &agentapi.TailTextFileRsp{
+				Lines: []string{"lorem ipsum"},
+			}),
+			End: true,
+		}
+	}()
+
+	client := agentapi.NewAgentClient(tunnel)
+	rsp, err := client.TailTextFile(context.Background(), &agentapi.TailTextFileReq{
+		Path: "/tmp/kea-dhcp4.log",
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"lorem ipsum"}, rsp.Lines)
+	require.Empty(t, tunnel.calls)
+}
+
+// Test that the error returned by the agent is returned to the caller
+// and that the pending calls fail when the tunnel is closed.
+func TestTunnelInvokeError(t *testing.T) {
+	stream := newFakeTunnelStream()
+	tunnel := newAgentTunnel(stream)
+	go tunnel.receive()
+
+	go func() {
+		msg := <-stream.sent
+		require.Equal(t, "/agentapi.Agent/Ping", msg.Method)
+		stream.received <- &agentapi.TunnelMessage{
+			CallID:    msg.CallID,
+			End:       true,
+			Error:     "unknown method",
+			ErrorCode: int32(codes.Unimplemented),
+		}
+		// Don't respond to the next call and close the tunnel instead.
+		<-stream.sent
+		close(stream.received)
+	}()
+
+	client := agentapi.NewAgentClient(tunnel)
+	_, err := client.Ping(context.Background(), &agentapi.PingReq{})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "unknown method")
+	require.Equal(t, codes.Unimplemented, status.Code(err))
+
+	_, err = client.Ping(context.Background(), &agentapi.PingReq{})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "tunnel to the agent is closed")
+
+	// The tunnel is closed, so the call cannot be sent.
+	_, err = client.Ping(context.Background(), &agentapi.PingReq{})
+	require.Error(t, err)
+}
+
+// Test that the streamed responses are received over the tunnel and
+// that the agent is notified when the caller cancels the stream.
+func TestTunnelStream(t *testing.T) {
+	stream := newFakeTunnelStream()
+	tunnel := newAgentTunnel(stream)
+	go tunnel.receive()
+	defer close(stream.received)
+
+	client := agentapi.NewAgentClient(tunnel)
+
+	// The stream is finished by the agent.
+	go func() {
+		msg := <-stream.sent
+		require.Equal(t, "/agentapi.Agent/FollowTextFile", msg.Method)
+		stream.received <- &agentapi.TunnelMessage{
+			CallID: msg.CallID,
+			Payload: marshalTunnelPayload(t, &agentapi.FollowTextFileRsp{
+				Lines: []string{"lorem ipsum"},
+			}),
+		}
+		stream.received <- &agentapi.TunnelMessage{
+			CallID: msg.CallID,
+			End:    true,
+		}
+	}()
+
+	followStream, err := client.FollowTextFile(context.Background(), &agentapi.FollowTextFileReq{
+		Path: "/tmp/kea-dhcp4.log",
+	})
+	require.NoError(t, err)
+	rsp, err := followStream.Recv()
+	require.NoError(t, err)
+	require.Equal(t, []string{"lorem ipsum"}, rsp.Lines)
+	_, err = followStream.Recv()
+	require.ErrorIs(t, err, io.EOF)
+
+	// The stream is cancelled by the caller.
+	ctx, cancel := context.WithCancel(context.Background())
+	followStream, err = client.FollowTextFile(ctx, &agentapi.FollowTextFileReq{
+		Path: "/tmp/kea-dhcp4.log",
+	})
+	require.NoError(t, err)
+	msg := <-stream.sent
+	cancel()
+	_, err = followStream.Recv()
+	require.Error(t, err)
+
+	cancelMsg := <-stream.sent
+	require.Equal(t, msg.CallID, cancelMsg.CallID)
+	require.True(t, cancelMsg.End)
+	require.Empty(t, tunnel.calls)
+}
+
+// Test that the stream failed by the agent is not cancelled by the server
+// because the agent has already finished it.
+func TestTunnelStreamError(t *testing.T) {
+	stream := newFakeTunnelStream()
+	tunnel := newAgentTunnel(stream)
+	go tunnel.receive()
+	defer close(stream.received)
+
+	client := agentapi.NewAgentClient(tunnel)
+
+	followStream, err := client.FollowTextFile(context.Background(), &agentapi.FollowTextFileReq{
+		Path: "/tmp/kea-dhcp4.log",
+	})
+	require.NoError(t, err)
+	msg := <-stream.sent
+	stream.received <- &agentapi.TunnelMessage{
+		CallID: msg.CallID,
+		End:    true,
+		Error:  "no such file",
+	}
+	_, err = followStream.Recv()
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "no such file")
+	require.Empty(t, tunnel.calls)
+	require.Empty(t, stream.sent)
+}
+
+// Test that the stream whose responses are not received by the caller
+// is aborted and doesn't block the other calls.
+func TestTunnelSlowStream(t *testing.T) {
+	stream := newFakeTunnelStream()
+	tunnel := newAgentTunnel(stream)
+	go tunnel.receive()
+	defer close(stream.received)
+
+	client := agentapi.NewAgentClient(tunnel)
+
+	followStream, err := client.FollowTextFile(context.Background(), &agentapi.FollowTextFileReq{
+		Path: "/tmp/kea-dhcp4.log",
+	})
+	require.NoError(t, err)
+	followMsg := <-stream.sent
+
+	// The agent sends more responses than can be queued for the call.
+	payload := marshalTunnelPayload(t, &agentapi.FollowTextFileRsp{
+		Lines: []string{"lorem ipsum"},
+	})
+	go func() {
+		for i := 0; i <= maxTunnelCallQueueLength; i++ {
+			stream.received <- &agentapi.TunnelMessage{
+				CallID:  followMsg.CallID,
+				Payload: payload,
+			}
+		}
+	}()
+
+	// The agent is notified that the call is aborted.
+	cancelMsg := <-stream.sent
+	require.Equal(t, followMsg.CallID, cancelMsg.CallID)
+	require.True(t, cancelMsg.End)
+
+	// The other calls are still handled.
+	go func() {
+		msg := <-stream.sent
+		stream.received <- &agentapi.TunnelMessage{
+			CallID: msg.CallID,
+			End:    true,
+		}
+	}()
+	_, err = client.Ping(context.Background(), &agentapi.PingReq{})
+	require.NoError(t, err)
+
+	// The caller eventually learns that the call has been aborted.
+	for err == nil {
+		_, err = followStream.Recv()
+	}
+	require.Contains(t, err.Error(), "call aborted")
+}
+
+// Test that the agent which opened the tunnel is reached over this tunnel.
+func TestConnectingToAgentOverTunnel(t *testing.T) {
+	settings := AgentsSettings{}
+	fec := &storktest.FakeEventCenter{}
+	agents := NewConnectedAgents(&settings, fec, CACertPEM, ServerCertPEM, ServerKeyPEM)
+	defer agents.Shutdown()
+	agentsData := agents.(*connectedAgentsData)
+
+	tunnel := newAgentTunnel(newFakeTunnelStream())
+	agentsData.addTunnel("192.0.2.1:8080", tunnel)
+
+	agent, err := agents.GetConnectedAgent("192.0.2.1:8080")
+	require.NoError(t, err)
+	require.Equal(t, tunnel, agent.tunnel)
+	require.Nil(t, agent.GrpcConn)
+
+	// The new tunnel replaces the old one.
+	newTunnel := newAgentTunnel(newFakeTunnelStream())
+	agentsData.addTunnel("192.0.2.1:8080", newTunnel)
+	require.True(t, tunnel.closed)
+
+	// Removing the old tunnel should not affect the new one.
+	agentsData.removeTunnel("192.0.2.1:8080", tunnel)
+	agent, err = agents.GetConnectedAgent("192.0.2.1:8080")
+	require.NoError(t, err)
+	require.Equal(t, newTunnel, agent.tunnel)
+
+	// When the tunnel is removed the server connects to the agent.
+	agentsData.removeTunnel("192.0.2.1:8080", newTunnel)
+	agent, err = agents.GetConnectedAgent("192.0.2.1:8080")
+	require.NoError(t, err)
+	require.Nil(t, agent.tunnel)
+	require.NotNil(t, agent.GrpcConn)
+}
+
+// Test that the agent opening the tunnel is identified by the address
+// and the port sent in the metadata and the address must match the
+// agent certificate.
+func TestGetTunnelAgentAddrPort(t *testing.T) {
+	caKey, _, caCert, _, err := pki.GenCAKeyCert(1)
+	require.NoError(t, err)
+	certPEM, _, err := pki.GenKeyCert("agent", []string{"agent.example.org"}, []net.IP{net.ParseIP("192.0.2.1")}, 2, caCert, caKey)
+	require.NoError(t, err)
+	cert, err := pki.ParseCert(certPEM)
+	require.NoError(t, err)
+
+	makeContext := func(pairs ...string) context.Context {
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(pairs...))
+		return peer.NewContext(ctx, &peer.Peer{
+			AuthInfo: credentials.TLSInfo{
+				State: tls.ConnectionState{
+					PeerCertificates: []*x509.Certificate{cert},
+				},
+			},
+		})
+	}
+
+	addrPort, err := getTunnelAgentAddrPort(makeContext(TunnelAgentAddressKey, "192.0.2.1", TunnelAgentPortKey, "8080"))
+	require.NoError(t, err)
+	require.Equal(t, "192.0.2.1:8080", addrPort)
+
+	addrPort, err = getTunnelAgentAddrPort(makeContext(TunnelAgentAddressKey, "agent.example.org", TunnelAgentPortKey, "8081"))
+	require.NoError(t, err)
+	require.Equal(t, "agent.example.org:8081", addrPort)
+
+	// The address doesn't match the certificate.
+	_, err = getTunnelAgentAddrPort(makeContext(TunnelAgentAddressKey, "192.0.2.2", TunnelAgentPortKey, "8080"))
+	require.Error(t, err)
+
+	// Invalid port.
+	_, err = getTunnelAgentAddrPort(makeContext(TunnelAgentAddressKey, "192.0.2.1", TunnelAgentPortKey, "abc"))
+	require.Error(t, err)
+
+	// Missing port.
+	_, err = getTunnelAgentAddrPort(makeContext(TunnelAgentAddressKey, "192.0.2.1"))
+	require.Error(t, err)
+
+	// Missing certificate.
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(TunnelAgentAddressKey, "192.0.2.1", TunnelAgentPortKey, "8080"))
+	_, err = getTunnelAgentAddrPort(ctx)
+	require.Error(t, err)
+}
+
+// Check if credentials for TLS can be prepared for the tunnel server.
+func TestPrepareTunnelTLSCreds(t *testing.T) {
+	creds, err := prepareTunnelTLSCreds(CACertPEM, ServerCertPEM, ServerKeyPEM, newRevokedCerts())
+	require.NoError(t, err)
+	require.NotNil(t, creds)
+}
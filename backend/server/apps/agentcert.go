@@ -0,0 +1,152 @@
+package apps
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"net"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"isc.org/stork/pki"
+	"isc.org/stork/server/agentcomm"
+	"isc.org/stork/server/certs"
+	dbops "isc.org/stork/server/database"
+	dbmodel "isc.org/stork/server/database/model"
+	"isc.org/stork/server/eventcenter"
+)
+
+// Checks if the CSR sent by the agent is issued for the address of the
+// machine. The agent must not obtain a certificate for another machine.
+func checkAgentCSR(csrPEM []byte, address string) error {
+	block, _ := pem.Decode(csrPEM)
+	if block == nil {
+		return errors.New("decoding PEM with CSR failed")
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return errors.Wrapf(err, "parsing CSR failed")
+	}
+	if ip := net.ParseIP(address); ip != nil {
+		for _, csrIP := range csr.IPAddresses {
+			if csrIP.Equal(ip) {
+				return nil
+			}
+		}
+	} else {
+		for _, name := range csr.DNSNames {
+			if name == address {
+				return nil
+			}
+		}
+	}
+	return errors.Errorf("CSR is not issued for the machine address %s", address)
+}
+
+// Signs the CSR sent by the agent whose certificate is about to expire
+// or must be re-issued during the CA rotation and sends the renewed
+// certificate to the agent. The agent is already authenticated with its
+// current certificate, so it doesn't need to be registered and authorized
+// again. During the CA rotation the certificate is signed by the new CA
+// and the agent is told to trust both the current and the new CA. The
+// fingerprint and the expiration time of the new certificate are stored
+// in the machine.
+func renewAgentCert(ctx context.Context, db *dbops.PgDB, dbMachine *dbmodel.Machine, agents agentcomm.ConnectedAgents, eventCenter eventcenter.EventCenter, csrPEM []byte) error {
+	if err := checkAgentCSR(csrPEM, dbMachine.Address); err != nil {
+		return err
+	}
+
+	rootKeyPEM, rootCertPEM, rotating, err := certs.GetSigningCA(db)
+	if err != nil {
+		return errors.WithMessage(err, "problem with loading server CA key and cert")
+	}
+	var trustedCACertsPEM []byte
+	if rotating {
+		// The server must trust the new CA before the agent presents
+		// the certificate signed by it.
+		runningCACertsPEM, _ := agents.GetTLSCerts()
+		if !bytes.Contains(runningCACertsPEM, bytes.TrimSpace(rootCertPEM)) {
+			return errors.New("server must be restarted to trust the new CA before re-issuing the agent certificate")
+		}
+		trustedCACertsPEM, err = certs.GetTrustedCACerts(db)
+		if err != nil {
+			return errors.WithMessage(err, "problem with loading server CA certs")
+		}
+	}
+
+	certSerialNumber, err := dbmodel.GetNewCertSerialNumber(db)
+	if err != nil {
+		return errors.Wrapf(err, "problem with generating serial number for cert")
+	}
+	certPEM, fingerprint, paramsErr, innerErr := pki.SignCert(csrPEM, certSerialNumber, rootCertPEM, rootKeyPEM)
+	if paramsErr != nil {
+		return errors.WithMessage(paramsErr, "problem with agent CSR")
+	}
+	if innerErr != nil {
+		return errors.WithMessage(innerErr, "problem with signing agent CSR")
+	}
+	cert, err := pki.ParseCert(certPEM)
+	if err != nil {
+		return err
+	}
+
+	if err = agents.RenewCertificate(ctx, dbMachine.Address, dbMachine.AgentPort, certPEM, trustedCACertsPEM); err != nil {
+		return err
+	}
+
+	dbMachine.CertFingerprint = fingerprint
+	dbMachine.CertSerialNumber = certSerialNumber
+	dbMachine.State.AgentCertExpiresAt = cert.NotAfter.UTC()
+	if rotating {
+		dbMachine.CertRotationStatus = dbmodel.CertRotationReissued
+	}
+	if err = dbmodel.UpdateMachine(db, dbMachine); err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"machine": dbMachine.Address,
+		"expires": cert.NotAfter.UTC(),
+	}).Info("renewed agent certificate")
+	if rotating {
+		eventCenter.AddInfoEvent("re-issued certificate of {machine} using new CA", dbMachine)
+	} else {
+		eventCenter.AddInfoEvent("renewed certificate of {machine}", dbMachine)
+	}
+	return nil
+}
+
+// Tells the agent to stop trusting the old CA after the CA rotation has
+// been finished. The server must use the server cert signed by the new
+// CA. Otherwise, the agent would reject the connections from the server.
+func retireAgentOldCA(ctx context.Context, db *dbops.PgDB, dbMachine *dbmodel.Machine, agents agentcomm.ConnectedAgents, eventCenter eventcenter.EventCenter) error {
+	rootCertPEM, err := dbmodel.GetSecret(db, dbmodel.SecretCACert)
+	if err != nil {
+		return err
+	}
+	rootCert, err := pki.ParseCert(rootCertPEM)
+	if err != nil {
+		return err
+	}
+	_, serverCertPEM := agents.GetTLSCerts()
+	serverCert, err := pki.ParseCert(serverCertPEM)
+	if err != nil {
+		return err
+	}
+	if err = serverCert.CheckSignatureFrom(rootCert); err != nil {
+		return errors.New("server must be restarted to use the new server cert before retiring the old CA")
+	}
+
+	if err = agents.RenewCertificate(ctx, dbMachine.Address, dbMachine.AgentPort, nil, rootCertPEM); err != nil {
+		return err
+	}
+
+	dbMachine.CertRotationStatus = dbmodel.CertRotationNone
+	if err = dbmodel.UpdateMachine(db, dbMachine); err != nil {
+		return err
+	}
+	eventCenter.AddInfoEvent("retired old CA on {machine}", dbMachine)
+	return nil
+}
@@ -0,0 +1,225 @@
+package apps
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+
+	"isc.org/stork/pki"
+	"isc.org/stork/server/agentcomm"
+	agentcommtest "isc.org/stork/server/agentcomm/test"
+	"isc.org/stork/server/certs"
+	dbmodel "isc.org/stork/server/database/model"
+	dbtest "isc.org/stork/server/database/test"
+	storktest "isc.org/stork/server/test"
+)
+
+// Test that the CSR must be issued for the machine address.
+func TestCheckAgentCSR(t *testing.T) {
+	_, csrPEM, _, err := pki.GenKeyAndCSR("agent", []string{"agent.example.org"}, []net.IP{net.ParseIP("192.0.2.1")})
+	require.NoError(t, err)
+
+	require.NoError(t, checkAgentCSR(csrPEM, "192.0.2.1"))
+	require.NoError(t, checkAgentCSR(csrPEM, "agent.example.org"))
+	require.Error(t, checkAgentCSR(csrPEM, "192.0.2.2"))
+	require.Error(t, checkAgentCSR(csrPEM, "other.example.org"))
+	require.Error(t, checkAgentCSR([]byte("invalid"), "192.0.2.1"))
+}
+
+// Test that the agent certificate is renewed when the agent sends the
+// CSR in its state.
+func TestStatePullerRenewAgentCert(t *testing.T) {
+	db, _, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	_, _, _, err := certs.SetupServerCerts(db)
+	require.NoError(t, err)
+
+	_, csrPEM, _, err := pki.GenKeyAndCSR("agent", []string{}, []net.IP{net.ParseIP("192.0.2.1")})
+	require.NoError(t, err)
+
+	fa := agentcommtest.NewFakeAgents(nil, nil)
+	fa.MachineState = &agentcomm.State{
+		AgentCertNotAfter:   time.Now().Add(24 * time.Hour).UTC(),
+		AgentCertRenewalCSR: csrPEM,
+	}
+	fec := &storktest.FakeEventCenter{}
+	fd := &storktest.FakeDispatcher{}
+
+	m := &dbmodel.Machine{
+		Address:    "192.0.2.1",
+		AgentPort:  8080,
+		Authorized: true,
+	}
+	err = dbmodel.AddMachine(db, m)
+	require.NoError(t, err)
+
+	errStr := GetMachineAndAppsState(context.Background(), db, m, fa, fec, fd)
+	require.Empty(t, errStr)
+
+	// The renewed certificate has been sent to the agent.
+	require.NotEmpty(t, fa.RecordedAgentCert)
+	cert, err := pki.ParseCert(fa.RecordedAgentCert)
+	require.NoError(t, err)
+
+	m, err = dbmodel.GetMachineByID(db, m.ID)
+	require.NoError(t, err)
+	require.NotZero(t, m.CertFingerprint)
+	require.NotZero(t, m.CertSerialNumber)
+	require.WithinDuration(t, cert.NotAfter, m.State.AgentCertExpiresAt, time.Second)
+	require.False(t, m.IsAgentCertExpiring(time.Now()))
+
+	require.Len(t, fec.Events, 1)
+	require.Contains(t, fec.Events[0].Text, "renewed certificate")
+}
+
+// Test that the machine with the expiring certificate is reported when
+// the certificate cannot be renewed.
+func TestStatePullerRenewAgentCertFailed(t *testing.T) {
+	db, _, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	_, _, _, err := certs.SetupServerCerts(db)
+	require.NoError(t, err)
+
+	_, csrPEM, _, err := pki.GenKeyAndCSR("agent", []string{}, []net.IP{net.ParseIP("192.0.2.1")})
+	require.NoError(t, err)
+
+	fa := agentcommtest.NewFakeAgents(nil, nil)
+	fa.MachineState = &agentcomm.State{
+		AgentCertNotAfter:   time.Now().Add(24 * time.Hour).UTC(),
+		AgentCertRenewalCSR: csrPEM,
+	}
+	fa.RenewCertErr = errors.New("renewed certificate does not match the agent key")
+	fec := &storktest.FakeEventCenter{}
+	fd := &storktest.FakeDispatcher{}
+
+	m := &dbmodel.Machine{
+		Address:    "192.0.2.1",
+		AgentPort:  8080,
+		Authorized: true,
+	}
+	err = dbmodel.AddMachine(db, m)
+	require.NoError(t, err)
+
+	errStr := GetMachineAndAppsState(context.Background(), db, m, fa, fec, fd)
+	require.Empty(t, errStr)
+
+	m, err = dbmodel.GetMachineByID(db, m.ID)
+	require.NoError(t, err)
+	require.Zero(t, m.CertFingerprint)
+	require.True(t, m.IsAgentCertExpiring(time.Now()))
+
+	require.Len(t, fec.Events, 1)
+	require.Contains(t, fec.Events[0].Text, "expires on")
+
+	// The event is raised only once.
+	errStr = GetMachineAndAppsState(context.Background(), db, m, fa, fec, fd)
+	require.Empty(t, errStr)
+	require.Len(t, fec.Events, 1)
+}
+
+// Test that the agent certificate is re-issued using the new CA during the
+// CA rotation and that the old CA is retired after the rotation is finished.
+func TestStatePullerCARotation(t *testing.T) {
+	db, _, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	_, serverCertPEM, _, err := certs.SetupServerCerts(db)
+	require.NoError(t, err)
+
+	_, csrPEM, _, err := pki.GenKeyAndCSR("agent", []string{}, []net.IP{net.ParseIP("192.0.2.1")})
+	require.NoError(t, err)
+
+	m := &dbmodel.Machine{
+		Address:    "192.0.2.1",
+		AgentPort:  8080,
+		Authorized: true,
+	}
+	err = dbmodel.AddMachine(db, m)
+	require.NoError(t, err)
+
+	err = certs.StartCARotation(db)
+	require.NoError(t, err)
+	m, err = dbmodel.GetMachineByID(db, m.ID)
+	require.NoError(t, err)
+
+	fa := agentcommtest.NewFakeAgents(nil, nil)
+	fa.MachineState = &agentcomm.State{
+		AgentCertRenewalCSR: csrPEM,
+	}
+	fec := &storktest.FakeEventCenter{}
+	fd := &storktest.FakeDispatcher{}
+
+	// The server has not been restarted yet and doesn't trust the new CA,
+	// so the agent certificate is not re-issued.
+	rootCertPEM, err := dbmodel.GetSecret(db, dbmodel.SecretCACert)
+	require.NoError(t, err)
+	fa.TrustedCACertPEM = rootCertPEM
+	fa.ServerCertPEM = serverCertPEM
+
+	errStr := GetMachineAndAppsState(context.Background(), db, m, fa, fec, fd)
+	require.Empty(t, errStr)
+	require.True(t, fa.RecordedRenewCert)
+	require.Nil(t, fa.RecordedAgentCert)
+
+	// After the restart the agent certificate is signed by the new CA and
+	// the agent is told to trust both CAs.
+	trustedCACertsPEM, err := certs.GetTrustedCACerts(db)
+	require.NoError(t, err)
+	fa.TrustedCACertPEM = trustedCACertsPEM
+
+	errStr = GetMachineAndAppsState(context.Background(), db, m, fa, fec, fd)
+	require.Empty(t, errStr)
+	require.NotNil(t, fa.RecordedAgentCert)
+	require.Equal(t, trustedCACertsPEM, fa.RecordedCACert)
+
+	_, nextCACertPEM, _, err := certs.GetSigningCA(db)
+	require.NoError(t, err)
+	agentCert, err := pki.ParseCert(fa.RecordedAgentCert)
+	require.NoError(t, err)
+	nextCACert, err := pki.ParseCert(nextCACertPEM)
+	require.NoError(t, err)
+	require.NoError(t, agentCert.CheckSignatureFrom(nextCACert))
+
+	m, err = dbmodel.GetMachineByID(db, m.ID)
+	require.NoError(t, err)
+	require.Equal(t, dbmodel.CertRotationReissued, m.CertRotationStatus)
+	require.Len(t, fec.Events, 1)
+	require.Contains(t, fec.Events[0].Text, "using new CA")
+
+	err = certs.FinishCARotation(db, false)
+	require.NoError(t, err)
+	m, err = dbmodel.GetMachineByID(db, m.ID)
+	require.NoError(t, err)
+
+	// The server has not been restarted yet and still uses the old server
+	// certificate, so the old CA is not retired.
+	fa.MachineState = &agentcomm.State{}
+	fa.RecordedAgentCert = nil
+	fa.RecordedCACert = nil
+
+	errStr = GetMachineAndAppsState(context.Background(), db, m, fa, fec, fd)
+	require.Empty(t, errStr)
+	require.False(t, fa.RecordedRenewCert)
+	require.Nil(t, fa.RecordedCACert)
+
+	// After the restart the agent is told to trust the new CA only.
+	_, fa.ServerCertPEM, _, err = certs.SetupServerCerts(db)
+	require.NoError(t, err)
+
+	errStr = GetMachineAndAppsState(context.Background(), db, m, fa, fec, fd)
+	require.Empty(t, errStr)
+	require.Nil(t, fa.RecordedAgentCert)
+	require.Equal(t, nextCACertPEM, fa.RecordedCACert)
+
+	m, err = dbmodel.GetMachineByID(db, m.ID)
+	require.NoError(t, err)
+	require.Equal(t, dbmodel.CertRotationNone, m.CertRotationStatus)
+	require.Len(t, fec.Events, 2)
+	require.Contains(t, fec.Events[1].Text, "retired old CA")
+}
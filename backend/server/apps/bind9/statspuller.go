@@ -39,11 +39,15 @@ func (statsPuller *StatsPuller) Shutdown() {
 // Pull stats periodically for all BIND 9 apps which Stork is monitoring.
 // The function returns last encountered error.
 func (statsPuller *StatsPuller) pullStats() error {
-	// get list of all bind9 apps from database
+	// get list of bind9 apps from database which are due for pulling stats
 	dbApps, err := dbmodel.GetAppsByType(statsPuller.DB, dbmodel.AppTypeBind9)
 	if err != nil {
 		return err
 	}
+	dbApps, err = statsPuller.SelectAppsToPull(dbApps)
+	if err != nil {
+		return err
+	}
 
 	// get stats from each bind9 app
 	var lastErr error
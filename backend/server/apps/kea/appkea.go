@@ -175,6 +175,57 @@ func getStateFromCA(ctx context.Context, agents agentcomm.ConnectedAgents, dbApp
 	return allDaemons, dhcpDaemons, nil
 }
 
+// Get the name of the Kea daemon controlled directly over its control socket.
+// The name of the already known daemon is returned. Otherwise, the daemon is
+// queried for its configuration and the name is determined from the root
+// node of the configuration. The returned daemon sets are used to fetch the
+// daemon state with the getStateFromDaemons function.
+func getDaemonFromSocket(ctx context.Context, agents agentcomm.ConnectedAgents, dbApp *dbmodel.App) (keactrl.Daemons, keactrl.Daemons, error) {
+	name := ""
+	if len(dbApp.Daemons) == 1 {
+		name = dbApp.Daemons[0].Name
+	} else {
+		cmds := []*keactrl.Command{
+			{
+				Command: "config-get",
+			},
+		}
+		configGetResp := []keactrl.HashedResponse{}
+
+		cmdsResult, err := agents.ForwardToKeaOverHTTP(ctx, dbApp, cmds, &configGetResp)
+		if err != nil {
+			return nil, nil, err
+		}
+		if cmdsResult.Error != nil {
+			return nil, nil, cmdsResult.Error
+		}
+		if cmdsResult.CmdsErrors[0] != nil {
+			return nil, nil, errors.WithMessage(cmdsResult.CmdsErrors[0], "problem with config-get response")
+		}
+		if len(configGetResp) == 0 || configGetResp[0].Arguments == nil || configGetResp[0].Result != 0 {
+			return nil, nil, errors.New("problem with config-get response from Kea daemon: response is empty or contains an error")
+		}
+		rootName, _ := dbmodel.NewKeaConfig(configGetResp[0].Arguments).GetRootName()
+		switch rootName {
+		case "Dhcp4":
+			name = dhcp4
+		case "Dhcp6":
+			name = dhcp6
+		case "DhcpDdns":
+			name = d2
+		default:
+			return nil, nil, errors.Errorf("unsupported Kea daemon configuration %s received over the control socket", rootName)
+		}
+	}
+
+	allDaemons := keactrl.Daemons{name: true}
+	dhcpDaemons := keactrl.Daemons{}
+	if name == dhcp4 || name == dhcp6 {
+		dhcpDaemons[name] = true
+	}
+	return allDaemons, dhcpDaemons, nil
+}
+
 // Get state of Kea application daemons (beside Control Agent) using ForwardToKeaOverHTTP function.
 // The state, that is stored into dbApp, includes: version, config and runtime state of indicated Kea daemons.
 func getStateFromDaemons(ctx context.Context, agents agentcomm.ConnectedAgents, dbApp *dbmodel.App, daemonsMap map[string]*dbmodel.Daemon, allDaemons keactrl.Daemons, dhcpDaemons keactrl.Daemons, daemonsErrors map[string]string) error {
@@ -307,12 +358,26 @@ func GetAppState(ctx context.Context, agents agentcomm.ConnectedAgents, dbApp *d
 	ctx2, cancel := context.WithTimeout(ctx, 2*time.Second)
 	defer cancel()
 
-	// get state from CA
 	daemonsMap := map[string]*dbmodel.Daemon{}
 	daemonsErrors := map[string]string{}
-	allDaemons, dhcpDaemons, err := getStateFromCA(ctx2, agents, dbApp, daemonsMap, daemonsErrors)
-	if err != nil {
-		log.Warnf("problem with getting state from Kea CA: %s", err)
+	var (
+		allDaemons  keactrl.Daemons
+		dhcpDaemons keactrl.Daemons
+		err         error
+	)
+	if dbApp.IsControlledOverSocket() {
+		// The daemon is controlled directly over its control socket, so
+		// there is no CA to get the state from.
+		allDaemons, dhcpDaemons, err = getDaemonFromSocket(ctx2, agents, dbApp)
+		if err != nil {
+			log.Warnf("problem with getting state from Kea daemon over control socket: %s", err)
+		}
+	} else {
+		// get state from CA
+		allDaemons, dhcpDaemons, err = getStateFromCA(ctx2, agents, dbApp, daemonsMap, daemonsErrors)
+		if err != nil {
+			log.Warnf("problem with getting state from Kea CA: %s", err)
+		}
 	}
 
 	// if no problems then now get state from the rest of Kea daemons
@@ -383,8 +448,20 @@ func findChangesAndRaiseEvents(dbApp *dbmodel.App, daemonsMap map[string]*dbmode
 		events     []*dbmodel.Event
 	)
 
-	newCADaemon, ok := daemonsMap["ca"]
-	if !ok || !newCADaemon.Active {
+	// The app is reachable when the Kea Control Agent is active or, if the app
+	// is controlled over the control socket, when any of the daemons is active.
+	reachable := false
+	if dbApp.IsControlledOverSocket() {
+		for _, daemon := range daemonsMap {
+			if daemon.Active {
+				reachable = true
+				break
+			}
+		}
+	} else if newCADaemon, ok := daemonsMap["ca"]; ok {
+		reachable = newCADaemon.Active
+	}
+	if !reachable {
 		// Kea Control Agent was not found in the response or it is inactive.
 		for _, oldDaemon := range dbApp.Daemons {
 			// For all active daemons we need to mark them as inactive and raise events
@@ -684,6 +761,25 @@ func CommitAppIntoDB(db *dbops.PgDB, app *dbmodel.App, eventCenter eventcenter.E
 			if err = dbmodel.CommitGlobalHostsIntoDB(tx, globalHosts[daemon.Name], daemon, "config"); err != nil {
 				return err
 			}
+
+			// Extract the options and option definitions from the changed
+			// configuration. The options are informational, so a failure to
+			// extract them (e.g., when the configuration contains unexpected
+			// values) must not prevent committing the app. The savepoint
+			// allows for continuing the transaction after the failure.
+			if state == nil || state.SameConfigDaemons == nil || !state.SameConfigDaemons[daemon.Name] {
+				if _, err = tx.Exec("SAVEPOINT kea_options"); err != nil {
+					return err
+				}
+				if optionsErr := dbmodel.CommitKeaOptionsIntoDB(tx, daemon); optionsErr != nil {
+					log.Warnf("skipping DHCP options of Kea daemon %d: %+v", daemon.ID, optionsErr)
+					if _, err = tx.Exec("ROLLBACK TO SAVEPOINT kea_options"); err != nil {
+						return err
+					}
+				} else if _, err = tx.Exec("RELEASE SAVEPOINT kea_options"); err != nil {
+					return err
+				}
+			}
 		}
 
 		// Add subnet related events to the database.
@@ -249,6 +249,85 @@ func TestGetAppStateWith2Daemons(t *testing.T) {
 	require.Equal(t, "config-get", fa.RecordedCommands[1].Command)
 }
 
+// Check that GetAppState fetches the state of the Kea daemon controlled
+// directly over its control socket.
+func TestGetAppStateOverControlSocket(t *testing.T) {
+	ctx := context.Background()
+
+	keaMock := func(callNo int, cmdResponses []interface{}) {
+		if callNo == 0 {
+			// config-get sent to determine the daemon name
+			list := cmdResponses[0].(*[]keactrl.HashedResponse)
+			*list = []keactrl.HashedResponse{
+				{
+					ResponseHeader: keactrl.ResponseHeader{
+						Result: 0,
+					},
+					Arguments: &map[string]interface{}{
+						"Dhcp4": map[string]interface{}{},
+					},
+				},
+			}
+		} else if callNo == 1 {
+			mockGetConfigFromOtherDaemonsResponse(1, cmdResponses)
+		}
+	}
+	fa := agentcommtest.NewFakeAgents(keaMock, nil)
+	fec := &storktest.FakeEventCenter{}
+
+	var accessPoints []*dbmodel.AccessPoint
+	accessPoints = dbmodel.AppendAccessPoint(accessPoints, dbmodel.AccessPointControlSocket, "/tmp/kea4-ctrl-socket", "", 0, false)
+
+	dbApp := dbmodel.App{
+		AccessPoints: accessPoints,
+		Machine: &dbmodel.Machine{
+			Address:   "192.0.2.0",
+			AgentPort: 1111,
+		},
+	}
+
+	GetAppState(ctx, fa, &dbApp, fec)
+
+	require.Equal(t, "/tmp/kea4-ctrl-socket", fa.RecordedURL)
+	require.Len(t, fa.RecordedCommands, 4)
+	require.Equal(t, "config-get", fa.RecordedCommands[0].Command)
+	require.Nil(t, fa.RecordedCommands[0].Daemons)
+	require.Equal(t, "version-get", fa.RecordedCommands[1].Command)
+	require.Equal(t, "status-get", fa.RecordedCommands[2].Command)
+	require.Equal(t, "config-get", fa.RecordedCommands[3].Command)
+
+	// There is no CA, so the only daemon is the DHCPv4 server.
+	require.Len(t, dbApp.Daemons, 1)
+	require.Equal(t, "dhcp4", dbApp.Daemons[0].Name)
+	require.True(t, dbApp.Daemons[0].Active)
+}
+
+// Check that the app controlled over the control sockets is reachable
+// when any of its daemons is active, regardless of the order in which
+// the daemons are checked.
+func TestFindChangesAndRaiseEventsOverControlSocket(t *testing.T) {
+	var accessPoints []*dbmodel.AccessPoint
+	accessPoints = dbmodel.AppendAccessPoint(accessPoints, dbmodel.AccessPointControlSocket, "/tmp/kea4-ctrl-socket", "", 0, false)
+	dbApp := &dbmodel.App{
+		AccessPoints: accessPoints,
+		Machine: &dbmodel.Machine{
+			Address:   "192.0.2.0",
+			AgentPort: 1111,
+		},
+	}
+
+	// The map iteration order is random, so repeat the check.
+	for i := 0; i < 20; i++ {
+		daemonsMap := map[string]*dbmodel.Daemon{
+			"dhcp4": {Name: "dhcp4", Active: true, KeaDaemon: &dbmodel.KeaDaemon{}},
+			"dhcp6": {Name: "dhcp6", Active: false, KeaDaemon: &dbmodel.KeaDaemon{}},
+		}
+		_, overwrite, newDaemons, _, _ := findChangesAndRaiseEvents(dbApp, daemonsMap, map[string]string{})
+		require.True(t, overwrite)
+		require.Len(t, newDaemons, 2)
+	}
+}
+
 // Check GetAppState when app already exists.
 func TestGetAppStateForExistingApp(t *testing.T) {
 	ctx := context.Background()
@@ -500,3 +579,54 @@ func TestCommitAppIntoDB(t *testing.T) {
 	require.EqualValues(t, 2345, returned.AccessPoints[0].Port)
 	require.True(t, returned.AccessPoints[0].UseSecureProtocol)
 }
+
+// Tests that the app is committed into the database when the DHCP options
+// can't be extracted from the daemon's configuration.
+func TestCommitAppIntoDBInvalidOptions(t *testing.T) {
+	db, _, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	fec := &storktest.FakeEventCenter{}
+
+	machine := &dbmodel.Machine{
+		Address:   "localhost",
+		AgentPort: 8080,
+	}
+	err := dbmodel.AddMachine(db, machine)
+	require.NoError(t, err)
+
+	// The option-data must be a list. The subnets are valid.
+	daemon := dbmodel.NewKeaDaemon(dbmodel.DaemonNameDHCPv4, true)
+	err = daemon.SetConfigFromJSON(`{
+		"Dhcp4": {
+			"option-data": "invalid",
+			"subnet4": [
+				{
+					"id": 1,
+					"subnet": "192.0.2.0/24"
+				}
+			]
+		}
+	}`)
+	require.NoError(t, err)
+
+	var accessPoints []*dbmodel.AccessPoint
+	accessPoints = dbmodel.AppendAccessPoint(accessPoints, dbmodel.AccessPointControl, "", "", 1234, false)
+	app := &dbmodel.App{
+		MachineID:    machine.ID,
+		Machine:      machine,
+		Type:         dbmodel.AppTypeKea,
+		Active:       true,
+		AccessPoints: accessPoints,
+		Daemons:      []*dbmodel.Daemon{daemon},
+	}
+
+	err = CommitAppIntoDB(db, app, fec, nil)
+	require.NoError(t, err)
+
+	// The subnets should be committed despite the invalid options.
+	subnets, err := dbmodel.GetSubnetsByPrefix(db, "192.0.2.0/24")
+	require.NoError(t, err)
+	require.Len(t, subnets, 1)
+	require.Len(t, subnets[0].LocalSubnets, 1)
+}
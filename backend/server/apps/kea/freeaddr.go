@@ -0,0 +1,331 @@
+package kea
+
+import (
+	"bytes"
+	"context"
+	"net"
+
+	cidr "github.com/apparentlymart/go-cidr/cidr"
+	errors "github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+
+	keactrl "isc.org/stork/appctrl/kea"
+	keadata "isc.org/stork/appdata/kea"
+	"isc.org/stork/server/agentcomm"
+	dbops "isc.org/stork/server/database"
+	dbmodel "isc.org/stork/server/database/model"
+	"isc.org/stork/tracing"
+	storkutil "isc.org/stork/util"
+)
+
+// Default number of free addresses and free delegated prefixes of each
+// kind returned by FindFreeAddresses.
+const DefaultFreeAddressesCount = 10
+
+// Free addresses and delegated prefixes found in a subnet. The in-pool
+// addresses belong to the subnet's address pools and may be assigned
+// dynamically. The out-of-pool addresses belong to the subnet prefix but
+// lie outside of the pools, so they are suitable for static assignment.
+// The unverified apps serve the subnet but lack the lease_cmds hooks
+// library, so their leases couldn't be checked.
+type FreeAddresses struct {
+	InPool         []string
+	OutOfPool      []string
+	Prefixes       []string
+	UnverifiedApps []*dbmodel.App
+}
+
+// Set of the addresses and delegated prefixes in use, i.e. reserved or
+// leased. The addresses are stored in their canonical text form and the
+// delegated prefixes in the CIDR notation.
+type usedAddresses map[string]bool
+
+// Lower and upper bound of an address range.
+type addressRange struct {
+	lb net.IP
+	ub net.IP
+}
+
+// Returns 4 bytes long IPv4 address or 16 bytes long IPv6 address. Addresses
+// in both forms are returned by the net package, but they must have equal
+// lengths to be compared.
+func normalizeIP(ip net.IP) net.IP {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4
+	}
+	return ip.To16()
+}
+
+// Marks the address or delegated prefix from the host reservation as used.
+// The reservations are stored in the database in the CIDR notation but
+// the addresses without prefix length are also accepted.
+func (used usedAddresses) addReservation(reservation string) {
+	if ip, ipNet, err := net.ParseCIDR(reservation); err == nil {
+		if ones, bits := ipNet.Mask.Size(); ones != bits {
+			used[ipNet.String()] = true
+			return
+		}
+		used[ip.String()] = true
+		return
+	}
+	if ip := net.ParseIP(reservation); ip != nil {
+		used[ip.String()] = true
+	}
+}
+
+// Marks the leased address or delegated prefix as used.
+func (used usedAddresses) addLease(lease *dbmodel.Lease) {
+	ip := net.ParseIP(lease.IPAddress)
+	if ip == nil {
+		return
+	}
+	if lease.Type == "IA_PD" {
+		prefix := net.IPNet{
+			IP:   ip,
+			Mask: net.CIDRMask(int(lease.PrefixLength), 128),
+		}
+		used[prefix.String()] = true
+		return
+	}
+	used[ip.String()] = true
+}
+
+// Sends lease4-get-all or lease6-get-all command to the daemon to fetch
+// the leases from the subnet with the specified Kea subnet ID.
+func getSubnetLeases(ctx context.Context, agents agentcomm.ConnectedAgents, dbApp *dbmodel.App, daemonName string, localSubnetID int64) (leases []dbmodel.Lease, err error) {
+	commandName := "lease4-get-all"
+	if daemonName == dbmodel.DaemonNameDHCPv6 {
+		commandName = "lease6-get-all"
+	}
+	daemons, err := keactrl.NewDaemons(daemonName)
+	if err != nil {
+		return leases, err
+	}
+	arguments := map[string]interface{}{
+		"subnets": []int64{localSubnetID},
+	}
+	command, err := keactrl.NewCommand(commandName, daemons, &arguments)
+	if err != nil {
+		return leases, err
+	}
+	response := make([]LeaseGetMultipleResponse, 1)
+	respResult, err := agents.ForwardToKeaOverHTTP(ctx, dbApp, []*keactrl.Command{command}, &response)
+	if err != nil {
+		return leases, err
+	}
+	if respResult.Error != nil {
+		return leases, respResult.Error
+	}
+	if len(response) == 0 {
+		return leases, errors.Errorf("invalid response to %s command received", commandName)
+	}
+	if response[0].Result == keactrl.ResponseEmpty {
+		return leases, nil
+	}
+	if err = validateGetLeasesResponse(commandName, response[0].Result, response[0].Arguments); err != nil {
+		return leases, err
+	}
+	leases = response[0].Arguments.Leases
+	for i := range leases {
+		leases[i].AppID = dbApp.ID
+		leases[i].App = dbApp
+	}
+	return leases, nil
+}
+
+// Returns up to count addresses from the range which are not in use. The
+// addresses belonging to the excluded ranges are skipped. The number of
+// iterations is limited by the number of used addresses in the range,
+// so it is safe to call it for large ranges.
+func findFreeAddressesInRange(r addressRange, excluded []addressRange, used usedAddresses, count int) (addresses []string) {
+	for ip := r.lb; len(addresses) < count; ip = cidr.Inc(ip) {
+		skip := false
+		for _, e := range excluded {
+			if bytes.Compare(ip, e.lb) >= 0 && bytes.Compare(ip, e.ub) <= 0 {
+				// Jump to the end of the excluded range.
+				if bytes.Compare(e.ub, r.ub) >= 0 {
+					return addresses
+				}
+				ip = e.ub
+				skip = true
+				break
+			}
+		}
+		if !skip && !used[ip.String()] {
+			addresses = append(addresses, ip.String())
+		}
+		if ip.Equal(r.ub) {
+			break
+		}
+	}
+	return addresses
+}
+
+// Returns up to count delegated prefixes from the prefix delegation pool
+// which are not in use.
+func findFreePrefixesInPool(pool *dbmodel.PrefixPool, used usedAddresses, count int) (prefixes []string) {
+	_, network, err := net.ParseCIDR(pool.Prefix)
+	if err != nil {
+		return prefixes
+	}
+	prefixLen, _ := network.Mask.Size()
+	if pool.DelegatedLen < prefixLen {
+		return prefixes
+	}
+	prefix, err := cidr.Subnet(network, pool.DelegatedLen-prefixLen, 0)
+	if err != nil {
+		return prefixes
+	}
+	for len(prefixes) < count && network.Contains(prefix.IP) {
+		if !used[prefix.String()] {
+			prefixes = append(prefixes, prefix.String())
+		}
+		next, rollover := cidr.NextSubnet(prefix, pool.DelegatedLen)
+		if rollover {
+			break
+		}
+		prefix = next
+	}
+	return prefixes
+}
+
+// Finds up to count free addresses within the pools of the subnet with
+// the specified ID, up to count free addresses within the subnet prefix
+// but outside of the pools and up to count free delegated prefixes
+// within the subnet's prefix delegation pools. An address or prefix is
+// considered free when it is neither reserved for any host in the subnet
+// or globally nor leased by any of the Kea servers serving the subnet.
+// The leases are fetched from the servers having the lease_cmds hooks
+// library loaded. The other servers are listed as the unverified apps.
+// The apps which returned an error are returned in the second value.
+// The returned addresses may be in use by the clients of the unverified
+// and erred apps. If the subnet does not exist, nil is returned.
+func FindFreeAddresses(ctx context.Context, db *dbops.PgDB, agents agentcomm.ConnectedAgents, subnetID int64, count int) (free *FreeAddresses, erredApps []*dbmodel.App, err error) {
+	ctx, span := tracing.StartSpan(ctx, "kea.FindFreeAddresses", attribute.Int64("stork.subnet.id", subnetID))
+	defer func() {
+		span.SetAttributes(attribute.Int("stork.lease.erred_apps", len(erredApps)))
+		tracing.EndSpan(span, err)
+	}()
+
+	if count <= 0 {
+		count = DefaultFreeAddressesCount
+	}
+
+	subnet, err := dbmodel.GetSubnet(db, subnetID)
+	if err != nil {
+		err = errors.WithMessagef(err, "failed to fetch subnet with ID %d while searching for free addresses", subnetID)
+		return free, erredApps, err
+	}
+	if subnet == nil {
+		return free, erredApps, nil
+	}
+
+	lb, ub, err := storkutil.ParseIPRange(subnet.Prefix)
+	if err != nil {
+		err = errors.WithMessagef(err, "failed to parse prefix of the subnet with ID %d", subnetID)
+		return free, erredApps, err
+	}
+	subnetRange := addressRange{lb: normalizeIP(lb), ub: normalizeIP(ub)}
+
+	used := usedAddresses{}
+
+	// The first address in the subnet is the network address for IPv4 and
+	// the subnet-router anycast address for IPv6. The last IPv4 address is
+	// the broadcast address. They are not assigned to the hosts.
+	if !subnetRange.lb.Equal(subnetRange.ub) {
+		used[subnetRange.lb.String()] = true
+		if subnet.GetFamily() == 4 && !cidr.Inc(subnetRange.lb).Equal(subnetRange.ub) {
+			used[subnetRange.ub.String()] = true
+		}
+	}
+
+	// Collect the addresses and prefixes reserved in the subnet.
+	hosts, err := dbmodel.GetHostsBySubnetID(db, subnetID)
+	if err != nil {
+		err = errors.WithMessagef(err, "failed to fetch hosts while searching for free addresses in subnet with ID %d", subnetID)
+		return free, erredApps, err
+	}
+	for i := range hosts {
+		for _, r := range hosts[i].IPReservations {
+			used.addReservation(r.Address)
+		}
+	}
+
+	// Collect the addresses and prefixes reserved globally.
+	globalAddresses, err := dbmodel.GetGlobalIPReservationAddresses(db, subnet.GetFamily())
+	if err != nil {
+		err = errors.WithMessagef(err, "failed to fetch global reservations while searching for free addresses in subnet with ID %d", subnetID)
+		return free, erredApps, err
+	}
+	for _, address := range globalAddresses {
+		used.addReservation(address)
+	}
+
+	// Collect the leased addresses and prefixes from the servers.
+	var unverifiedApps []*dbmodel.App
+	for _, ls := range subnet.LocalSubnets {
+		if ls.Daemon == nil {
+			continue
+		}
+		var app *dbmodel.App
+		app, err = dbmodel.GetAppByID(db, ls.Daemon.AppID)
+		if err != nil {
+			err = errors.WithMessagef(err, "failed to fetch app while searching for free addresses in subnet with ID %d", subnetID)
+			return free, erredApps, err
+		}
+		if app == nil {
+			continue
+		}
+		if !hasLeaseCmdsHook(app, ls.Daemon.Name) {
+			unverifiedApps = append(unverifiedApps, app)
+			continue
+		}
+		leases, leasesErr := getSubnetLeases(ctx, agents, app, ls.Daemon.Name, ls.LocalSubnetID)
+		if leasesErr != nil {
+			log.Warn(leasesErr)
+			erredApps = append(erredApps, app)
+			continue
+		}
+		for i := range leases {
+			// Reclaimed leases are kept in the lease database, but their
+			// addresses may be assigned to other clients.
+			if leases[i].State == keadata.LeaseStateExpiredReclaimed {
+				continue
+			}
+			used.addLease(&leases[i])
+		}
+	}
+
+	free = &FreeAddresses{
+		UnverifiedApps: unverifiedApps,
+	}
+
+	var pools []addressRange
+	for _, pool := range subnet.AddressPools {
+		poolLB, poolUB, parseErr := storkutil.ParseIPRange(pool.LowerBound + "-" + pool.UpperBound)
+		if parseErr != nil {
+			log.Warnf("skipping invalid address pool %s-%s in subnet with ID %d", pool.LowerBound, pool.UpperBound, subnetID)
+			continue
+		}
+		pools = append(pools, addressRange{lb: normalizeIP(poolLB), ub: normalizeIP(poolUB)})
+	}
+
+	for _, pool := range pools {
+		if len(free.InPool) >= count {
+			break
+		}
+		free.InPool = append(free.InPool, findFreeAddressesInRange(pool, nil, used, count-len(free.InPool))...)
+	}
+
+	free.OutOfPool = findFreeAddressesInRange(subnetRange, pools, used, count)
+
+	for i := range subnet.PrefixPools {
+		if len(free.Prefixes) >= count {
+			break
+		}
+		free.Prefixes = append(free.Prefixes, findFreePrefixesInPool(&subnet.PrefixPools[i], used, count-len(free.Prefixes))...)
+	}
+
+	return free, erredApps, nil
+}
@@ -0,0 +1,328 @@
+package kea
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	require "github.com/stretchr/testify/require"
+
+	keactrl "isc.org/stork/appctrl/kea"
+	agentcommtest "isc.org/stork/server/agentcomm/test"
+	dbops "isc.org/stork/server/database"
+	dbmodel "isc.org/stork/server/database/model"
+	dbtest "isc.org/stork/server/database/test"
+)
+
+// Generates a success mock response to lease4-get-all command returning
+// two leases. One of them is reclaimed.
+func mockLease4GetAll(callNo int, responses []interface{}) {
+	json := []byte(`[
+        {
+            "result": 0,
+            "text": "2 IPv4 lease(s) found.",
+            "arguments": {
+                "leases": [
+                    {
+                        "hw-address": "08:08:08:08:08:08",
+                        "ip-address": "192.0.2.10",
+                        "state": 0,
+                        "subnet-id": 1,
+                        "valid-lft": 3600
+                    },
+                    {
+                        "hw-address": "08:08:08:08:08:09",
+                        "ip-address": "192.0.2.12",
+                        "state": 2,
+                        "subnet-id": 1,
+                        "valid-lft": 3600
+                    }
+                ]
+            }
+        }
+    ]`)
+	daemons, _ := keactrl.NewDaemons("dhcp4")
+	command, _ := keactrl.NewCommand("lease4-get-all", daemons, nil)
+	_ = keactrl.UnmarshalResponseList(command, json, responses[0])
+}
+
+// Generates an error response to lease4-get-all command.
+func mockLease4GetAllError(callNo int, responses []interface{}) {
+	json := []byte(`[
+        {
+            "result": 1,
+            "text": "Leases erred"
+        }
+    ]`)
+	daemons, _ := keactrl.NewDaemons("dhcp4")
+	command, _ := keactrl.NewCommand("lease4-get-all", daemons, nil)
+	_ = keactrl.UnmarshalResponseList(command, json, responses[0])
+}
+
+// Test that the free addresses are found in the range skipping the used
+// addresses and the excluded ranges.
+func TestFindFreeAddressesInRange(t *testing.T) {
+	r := addressRange{
+		lb: net.ParseIP("192.0.2.0").To4(),
+		ub: net.ParseIP("192.0.2.255").To4(),
+	}
+	excluded := []addressRange{
+		{
+			lb: net.ParseIP("192.0.2.3").To4(),
+			ub: net.ParseIP("192.0.2.250").To4(),
+		},
+	}
+	used := usedAddresses{
+		"192.0.2.0": true,
+		"192.0.2.2": true,
+	}
+
+	addresses := findFreeAddressesInRange(r, excluded, used, 3)
+	require.Equal(t, []string{"192.0.2.1", "192.0.2.251", "192.0.2.252"}, addresses)
+
+	// The range end is not exceeded.
+	addresses = findFreeAddressesInRange(r, excluded, used, 10)
+	require.Len(t, addresses, 6)
+	require.Equal(t, "192.0.2.255", addresses[5])
+
+	// Excluded range covering the end of the range.
+	excluded[0].ub = net.ParseIP("192.0.2.255").To4()
+	addresses = findFreeAddressesInRange(r, excluded, used, 10)
+	require.Equal(t, []string{"192.0.2.1"}, addresses)
+
+	// The last IPv6 address is handled without overflow.
+	r = addressRange{
+		lb: net.ParseIP("ffff:ffff:ffff:ffff:ffff:ffff:ffff:fffe"),
+		ub: net.ParseIP("ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff"),
+	}
+	addresses = findFreeAddressesInRange(r, nil, usedAddresses{}, 10)
+	require.Len(t, addresses, 2)
+}
+
+// Test that the free delegated prefixes are found in the prefix pool.
+func TestFindFreePrefixesInPool(t *testing.T) {
+	pool := &dbmodel.PrefixPool{
+		Prefix:       "2001:db8:1::/62",
+		DelegatedLen: 64,
+	}
+	used := usedAddresses{}
+	used.addReservation("2001:db8:1::/64")
+
+	lease := &dbmodel.Lease{}
+	lease.IPAddress = "2001:db8:1:2::"
+	lease.PrefixLength = 64
+	lease.Type = "IA_PD"
+	used.addLease(lease)
+
+	prefixes := findFreePrefixesInPool(pool, used, 10)
+	require.Equal(t, []string{"2001:db8:1:1::/64", "2001:db8:1:3::/64"}, prefixes)
+
+	prefixes = findFreePrefixesInPool(pool, used, 1)
+	require.Equal(t, []string{"2001:db8:1:1::/64"}, prefixes)
+}
+
+// Adds a Kea app with the DHCPv4 daemon and the subnet served by the
+// daemon. The daemon has the lease_cmds hooks library loaded if leaseCmds
+// is true.
+func addFreeAddressesTestSubnet(t *testing.T, db *dbops.PgDB, leaseCmds bool) *dbmodel.Subnet {
+	machine := &dbmodel.Machine{
+		Address:   "localhost",
+		AgentPort: 8080,
+	}
+	err := dbmodel.AddMachine(db, machine)
+	require.NoError(t, err)
+
+	hooks := []interface{}{}
+	if leaseCmds {
+		hooks = append(hooks, map[string]interface{}{
+			"library": "libdhcp_lease_cmds.so",
+		})
+	}
+
+	accessPoints := []*dbmodel.AccessPoint{}
+	accessPoints = dbmodel.AppendAccessPoint(accessPoints, dbmodel.AccessPointControl, "localhost", "", 8000, false)
+	app := &dbmodel.App{
+		MachineID:    machine.ID,
+		Type:         dbmodel.AppTypeKea,
+		AccessPoints: accessPoints,
+		Daemons: []*dbmodel.Daemon{
+			{
+				Name: dbmodel.DaemonNameDHCPv4,
+				KeaDaemon: &dbmodel.KeaDaemon{
+					Config: dbmodel.NewKeaConfig(&map[string]interface{}{
+						"Dhcp4": map[string]interface{}{
+							"hooks-libraries": hooks,
+							"subnet4": []interface{}{
+								map[string]interface{}{
+									"id":     1,
+									"subnet": "192.0.2.0/24",
+								},
+							},
+						},
+					}),
+				},
+			},
+		},
+	}
+	_, err = dbmodel.AddApp(db, app)
+	require.NoError(t, err)
+
+	subnet := &dbmodel.Subnet{
+		Prefix: "192.0.2.0/24",
+		AddressPools: []dbmodel.AddressPool{
+			{
+				LowerBound: "192.0.2.10",
+				UpperBound: "192.0.2.20",
+			},
+		},
+	}
+	err = dbmodel.AddSubnet(db, subnet)
+	require.NoError(t, err)
+	err = dbmodel.AddDaemonToSubnet(db, subnet, app.Daemons[0])
+	require.NoError(t, err)
+
+	host := &dbmodel.Host{
+		SubnetID: subnet.ID,
+		HostIdentifiers: []dbmodel.HostIdentifier{
+			{
+				Type:  "hw-address",
+				Value: []byte{1, 2, 3, 4, 5, 6},
+			},
+		},
+		IPReservations: []dbmodel.IPReservation{
+			{
+				Address: "192.0.2.11",
+			},
+			{
+				Address: "192.0.2.2",
+			},
+		},
+	}
+	err = dbmodel.AddHost(db, host)
+	require.NoError(t, err)
+
+	return subnet
+}
+
+// Test that the free addresses are found in the subnet taking into account
+// the reservations and the leases.
+func TestFindFreeAddresses(t *testing.T) {
+	db, _, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	subnet := addFreeAddressesTestSubnet(t, db, true)
+
+	agents := agentcommtest.NewFakeAgents(mockLease4GetAll, nil)
+
+	free, erredApps, err := FindFreeAddresses(context.Background(), db, agents, subnet.ID, 3)
+	require.NoError(t, err)
+	require.Empty(t, erredApps)
+	require.NotNil(t, free)
+	require.Empty(t, free.UnverifiedApps)
+
+	// 192.0.2.10 is leased, 192.0.2.11 is reserved and 192.0.2.12 lease
+	// is reclaimed.
+	require.Equal(t, []string{"192.0.2.12", "192.0.2.13", "192.0.2.14"}, free.InPool)
+	// 192.0.2.0 is the network address and 192.0.2.2 is reserved.
+	require.Equal(t, []string{"192.0.2.1", "192.0.2.3", "192.0.2.4"}, free.OutOfPool)
+	require.Empty(t, free.Prefixes)
+
+	// Make sure that the leases were fetched for the right subnet.
+	require.Len(t, agents.RecordedCommands, 1)
+	command := agents.RecordedCommands[0]
+	require.Equal(t, "lease4-get-all", command.Command)
+	require.Equal(t, map[string]interface{}{"subnets": []int64{1}}, *command.Arguments)
+
+	// The out-of-pool addresses lie after the pool too.
+	free, _, err = FindFreeAddresses(context.Background(), db, agents, subnet.ID, 250)
+	require.NoError(t, err)
+	require.Len(t, free.InPool, 9)
+	require.Len(t, free.OutOfPool, 242)
+	require.Equal(t, "192.0.2.21", free.OutOfPool[8])
+	require.Equal(t, "192.0.2.254", free.OutOfPool[241])
+
+	// Non-existing subnet.
+	free, erredApps, err = FindFreeAddresses(context.Background(), db, agents, subnet.ID+1, 3)
+	require.NoError(t, err)
+	require.Nil(t, free)
+	require.Empty(t, erredApps)
+}
+
+// Test that the addresses reserved globally are not returned as free.
+func TestFindFreeAddressesGlobalReservations(t *testing.T) {
+	db, _, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	subnet := addFreeAddressesTestSubnet(t, db, true)
+
+	for _, address := range []string{"192.0.2.13", "192.0.2.3", "2001:db8::1"} {
+		host := &dbmodel.Host{
+			HostIdentifiers: []dbmodel.HostIdentifier{
+				{
+					Type:  "hw-address",
+					Value: []byte(address),
+				},
+			},
+			IPReservations: []dbmodel.IPReservation{
+				{
+					Address: address,
+				},
+			},
+		}
+		err := dbmodel.AddHost(db, host)
+		require.NoError(t, err)
+	}
+
+	agents := agentcommtest.NewFakeAgents(mockLease4GetAll, nil)
+
+	free, _, err := FindFreeAddresses(context.Background(), db, agents, subnet.ID, 3)
+	require.NoError(t, err)
+	require.NotNil(t, free)
+	require.Equal(t, []string{"192.0.2.12", "192.0.2.14", "192.0.2.15"}, free.InPool)
+	require.Equal(t, []string{"192.0.2.1", "192.0.2.4", "192.0.2.5"}, free.OutOfPool)
+}
+
+// Test that the app returning an error when fetching the leases is
+// reported and the addresses are still returned.
+func TestFindFreeAddressesErredApp(t *testing.T) {
+	db, _, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	subnet := addFreeAddressesTestSubnet(t, db, true)
+
+	agents := agentcommtest.NewFakeAgents(mockLease4GetAllError, nil)
+
+	free, erredApps, err := FindFreeAddresses(context.Background(), db, agents, subnet.ID, 0)
+	require.NoError(t, err)
+	require.Len(t, erredApps, 1)
+	require.NotNil(t, free)
+	require.Len(t, free.InPool, DefaultFreeAddressesCount)
+	require.Equal(t, "192.0.2.10", free.InPool[0])
+}
+
+// Test that the app lacking the lease_cmds hooks library is reported as
+// unverified and the addresses are still returned.
+func TestFindFreeAddressesUnverifiedApp(t *testing.T) {
+	db, _, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	subnet := addFreeAddressesTestSubnet(t, db, false)
+
+	agents := agentcommtest.NewFakeAgents(mockLease4GetAll, nil)
+
+	free, erredApps, err := FindFreeAddresses(context.Background(), db, agents, subnet.ID, 0)
+	require.NoError(t, err)
+	require.Empty(t, erredApps)
+	require.NotNil(t, free)
+	apps, err := dbmodel.GetAppsByType(db, dbmodel.AppTypeKea)
+	require.NoError(t, err)
+	require.Len(t, apps, 1)
+	require.Len(t, free.UnverifiedApps, 1)
+	require.Equal(t, apps[0].ID, free.UnverifiedApps[0].ID)
+
+	// The leases were not fetched, so only the reservations are taken
+	// into account.
+	require.Empty(t, agents.RecordedCommands)
+	require.Len(t, free.InPool, DefaultFreeAddressesCount)
+	require.Equal(t, "192.0.2.10", free.InPool[0])
+}
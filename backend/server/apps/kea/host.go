@@ -3,6 +3,7 @@ package kea
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/go-pg/pg/v10"
 	errors "github.com/pkg/errors"
@@ -19,6 +20,13 @@ import (
 const (
 	// A limit for returned hosts number in the the reservation-get-page command.
 	defaultHostCmdsPageLimit int64 = 1000
+	// A limit for the number of unchanged hosts held in memory while pulling
+	// the hosts of a subnet. If the subnet has more hosts and a change is
+	// found, the hosts are fetched from Kea again.
+	defaultMaxHeldHosts = 10000
+	// A number of pages pulled from a daemon after which the progress is
+	// logged.
+	hostsPullProgressPages = 100
 )
 
 // A structure reflecting "next" map of the Kea response to the
@@ -48,6 +56,8 @@ type HostsPuller struct {
 	*agentcomm.PeriodicPuller
 	ReviewDispatcher configreview.Dispatcher
 	traces           map[int64]*hostIteratorTrace
+	pageLimit        int64
+	maxHeldHosts     int
 }
 
 // Create an instance of the puller that periodically fetches host reservations
@@ -55,6 +65,8 @@ type HostsPuller struct {
 func NewHostsPuller(db *dbops.PgDB, agents agentcomm.ConnectedAgents, reviewDispatcher configreview.Dispatcher) (*HostsPuller, error) {
 	hostsPuller := &HostsPuller{
 		ReviewDispatcher: reviewDispatcher,
+		pageLimit:        defaultHostCmdsPageLimit,
+		maxHeldHosts:     defaultMaxHeldHosts,
 	}
 	periodicPuller, err := agentcomm.NewPeriodicPuller(db, agents, "Kea Hosts puller", "kea_hosts_puller_interval",
 		hostsPuller.pull)
@@ -74,11 +86,15 @@ func (puller *HostsPuller) Shutdown() {
 // Pulls host reservations from the monitored Kea apps and updates them in
 // the Stork database.
 func (puller *HostsPuller) pull() error {
-	// Get all Kea apps from the database.
+	// Get the Kea apps from the database which are due for pulling the hosts.
 	apps, err := dbmodel.GetAppsByType(puller.DB, dbmodel.AppTypeKea)
 	if err != nil {
 		return err
 	}
+	apps, err = puller.SelectAppsToPull(apps)
+	if err != nil {
+		return err
+	}
 
 	var (
 		successCount int
@@ -139,23 +155,17 @@ func (puller *HostsPuller) pullFromApp(app *dbmodel.App) (successCount, skippedC
 // attempted to pull the reservations (i.e., host_cmds hook library is used by
 // the daemon and the daemon is active). The function uses the iterator mechanism
 // to pull the hosts. It can result in sending multiple reservation-get-page
-// commands to each Kea instance.
+// commands to each Kea instance. The reservations are compared with those
+// pulled previously subnet by subnet and only the subnets with changed
+// reservations are updated in the database.
 func (puller *HostsPuller) pullFromDaemon(app *dbmodel.App, daemon *dbmodel.Daemon) (bool, error) {
-	// Hosts update is performed in a transaction but we don't begin the
-	// transaction until we detect that there were some changes in the
-	// host reservations.
-	var (
-		tx  *pg.Tx
-		err error
-	)
-
 	if !daemon.Active {
 		log.Infof("skip pulling host reservations for inactive daemon %d", daemon.ID)
 		return false, nil
 	}
 
 	if daemon.KeaDaemon.Config == nil {
-		err = errors.Errorf("daemon %d lacks configuration", daemon.ID)
+		err := errors.Errorf("daemon %d lacks configuration", daemon.ID)
 		return false, err
 	}
 
@@ -167,139 +177,433 @@ func (puller *HostsPuller) pullFromDaemon(app *dbmodel.App, daemon *dbmodel.Daem
 		return false, nil
 	}
 
-	// Fetch the hosts as long as they are returned by Kea.
-	it := newHostIterator(puller.DB, app, daemon, puller.Agents, defaultHostCmdsPageLimit)
-	var done bool
-	for !done {
-		// Send reservation-get-page to Kea.
+	started := time.Now()
+	pull := newHostsDaemonPull(puller, app, daemon)
+	err := pull.run()
+	duration := time.Since(started)
+
+	// Some subnets may have been updated even if the pull failed.
+	if pull.modified {
+		_ = puller.ReviewDispatcher.BeginReview(daemon, configreview.DBHostsModified, nil)
+	}
+
+	if err != nil {
+		// Forget the trace to update all subnets during the next pull.
+		delete(puller.traces, daemon.ID)
+		return true, err
+	}
+
+	log.WithFields(log.Fields{
+		"daemon_id":         daemon.ID,
+		"pages":             pull.pageCount,
+		"hosts":             pull.hostCount,
+		"updated_subnets":   pull.updatedSubnetCount,
+		"unchanged_subnets": pull.unchangedSubnetCount,
+		"duration":          duration,
+	}).Info("completed pulling host reservations from Kea daemon")
+
+	// Remember the current trace.
+	puller.traces[daemon.ID] = pull.it.trace
+
+	return true, nil
+}
+
+// State of pulling the host reservations from a single Kea daemon. The
+// hosts are fetched page by page and the hash of each page is compared
+// with the hash of the page at the same position in the same subnet
+// pulled previously. The pages are held in memory as long as they are
+// unchanged, up to the limit of the held hosts. When a changed page is
+// found, a transaction updating the hosts of the subnet is started and
+// the held pages are applied. If the held pages have been dropped due
+// to the limit, they are fetched again. The subsequent pages of the
+// subnet are applied as they are received. The transaction is committed
+// when all pages of the subnet are received.
+type hostsDaemonPull struct {
+	puller   *HostsPuller
+	app      *dbmodel.App
+	daemon   *dbmodel.Daemon
+	it       *hostIterator
+	previous *hostIteratorTrace
+	// Indicates that there is no trace and the daemon had no hosts in
+	// the database before the pull.
+	hadNoHosts bool
+	// Position of the current subnet in the iterator.
+	subnetIndex int
+	// Number of pages of the current subnet received so far.
+	subnetPageCount int
+	// Unchanged pages of the current subnet held in memory.
+	heldPages     [][]keaconfig.Reservation
+	heldHostCount int
+	// Indicates that the held pages have been dropped due to the limit.
+	heldPagesDropped bool
+	// Transaction updating the hosts of the current subnet. It is nil
+	// if no change has been found in the subnet so far.
+	tx *pg.Tx
+	// IDs of the subnets for which the pages fetched again differ from
+	// the pages fetched at first. They are removed from the trace, so they
+	// are updated during the next pull.
+	inconsistentSubnets []int64
+	// Statistics of the pull.
+	pageCount            int
+	hostCount            int
+	updatedSubnetCount   int
+	unchangedSubnetCount int
+	// Indicates if any hosts in the database have been modified.
+	modified bool
+}
+
+// Creates the state of pulling the host reservations from the daemon.
+func newHostsDaemonPull(puller *HostsPuller, app *dbmodel.App, daemon *dbmodel.Daemon) *hostsDaemonPull {
+	return &hostsDaemonPull{
+		puller:   puller,
+		app:      app,
+		daemon:   daemon,
+		it:       newHostIterator(puller.DB, app, daemon, puller.Agents, puller.pageLimit),
+		previous: puller.traces[daemon.ID],
+	}
+}
+
+// Fetches all host reservations from the daemon and updates the subnets
+// in which the reservations have changed. The subnets without the
+// reservations are updated at the end because the iterator skips them.
+// The daemon is dissociated from the hosts of these subnets in a single
+// statement.
+func (pull *hostsDaemonPull) run() (err error) {
+	defer func() {
+		if pull.tx != nil {
+			_ = pull.tx.Rollback()
+			pull.tx = nil
+		}
+	}()
+
+	// Without the trace, it is unknown which subnets had reservations.
+	// Check if there is anything to dissociate the daemon from.
+	if pull.previous == nil {
+		var hasHosts bool
+		if hasHosts, err = dbmodel.HasDaemonHosts(pull.puller.DB, pull.daemon.ID, "api"); err != nil {
+			return err
+		}
+		pull.hadNoHosts = !hasHosts
+	}
+
+	visited := make(map[int]bool)
+	for done := false; !done; {
 		var reservations []keaconfig.Reservation
-		if reservations, done, err = it.getPageFromHostCmds(); err != nil {
-			return true, err
+		if reservations, done, err = pull.it.getPageFromHostCmds(); err != nil {
+			return err
 		}
 		// We're probably done getting reservations.
 		if len(reservations) == 0 {
 			continue
 		}
-		// The puller holds traces from the previous attempts to fetch the host
-		// reservations. The traces don't exist when this is the first time
-		// we pull the reservations.
-		if trace, ok := puller.traces[daemon.ID]; ok {
-			// Compare the hash created from the received reservations with the
-			// corresponding hash in the saved trace. If they are equal it means
-			// that there was no change in the host reservations since the last
-			// pull.
-			if it.trace.hasEqualHashes(trace) {
-				continue
+		if !visited[pull.it.subnetIndex] {
+			if len(visited) > 0 {
+				if err = pull.finishSubnet(); err != nil {
+					return err
+				}
 			}
+			visited[pull.it.subnetIndex] = true
+			pull.startSubnet(pull.it.subnetIndex)
 		}
-		// If we have detected host changes right now, there is no transaction yet.
-		// Begin new transaction.
-		if tx == nil {
-			if tx, err = puller.DB.Begin(); err != nil {
-				err = errors.Wrapf(err, "problem with starting transaction for adding hosts from host_cmds hooks library for daemon %d", daemon.ID)
-				return true, err
-			}
-			defer dbops.RollbackOnError(tx, &err)
+		if err = pull.addPage(reservations); err != nil {
+			return err
+		}
+	}
+	if len(visited) > 0 {
+		if err = pull.finishSubnet(); err != nil {
+			return err
+		}
+	}
 
-			// Remove associations between existing host reservations and the
-			// daemon. Some associations will be re-created and some possibly
-			// not. The orphaned hosts will be later removed.
-			if _, err = dbmodel.DeleteDaemonFromHosts(tx, daemon.ID, "api"); err != nil {
-				return true, err
-			}
+	// The hosts can't be pulled from this daemon, so we don't know if
+	// there are any hosts in the subnets.
+	if pull.it.unsupported {
+		return nil
+	}
 
-			// We possibly cached some responses for which the hashes haven't
-			// changed. Since we created new transaction and had to remove the
-			// associations with the daemon we now have to process these cached
-			// responses to add the associations with the hosts that haven't
-			// changed.
-			for _, traceResponse := range it.trace.responses {
-				if err = convertAndUpdateHosts(tx, daemon, it.getSubnet(traceResponse.subnetIndex), traceResponse.hosts); err != nil {
-					return true, err
-				}
+	// Subnets without reservations, including the global reservations
+	// at the position of -1. The subnets which had no reservations
+	// during the previous pull are unchanged.
+	var emptySubnetIDs []int64
+	for i := -1; i < len(pull.it.subnets); i++ {
+		if visited[i] {
+			continue
+		}
+		pull.startSubnet(i)
+		subnetID := pull.getSubnetID()
+		if previousCount, ok := pull.previous.getPageCount(subnetID); ok && previousCount == 0 {
+			pull.unchangedSubnetCount++
+			continue
+		}
+		emptySubnetIDs = append(emptySubnetIDs, subnetID)
+	}
+
+	// Subnets which no longer belong to the daemon.
+	var removedSubnetIDs []int64
+	if pull.previous != nil {
+		for subnetID := range pull.previous.subnets {
+			if _, ok := pull.it.trace.subnets[subnetID]; !ok {
+				removedSubnetIDs = append(removedSubnetIDs, subnetID)
 			}
 		}
-		// Add the host reservations from the current response.
-		if err = convertAndUpdateHosts(tx, daemon, it.getCurrentSubnet(), reservations); err != nil {
-			return true, err
+	}
+
+	// Dissociate the daemon from the hosts of the empty and the removed
+	// subnets at once. It is unnecessary if the daemon had no hosts
+	// before the first pull.
+	if pull.hadNoHosts {
+		pull.unchangedSubnetCount += len(emptySubnetIDs)
+	} else {
+		pull.updatedSubnetCount += len(emptySubnetIDs)
+		var deletedCount int64
+		deletedCount, err = dbmodel.DeleteDaemonFromSubnetHosts(pull.puller.DB, pull.daemon.ID,
+			append(emptySubnetIDs, removedSubnetIDs...), "api")
+		if err != nil {
+			return err
+		}
+		if deletedCount > 0 {
+			pull.modified = true
+		}
+	}
+
+	for _, subnetID := range pull.inconsistentSubnets {
+		pull.it.trace.removeSubnet(subnetID)
+	}
+	return nil
+}
+
+// Returns the ID of the current subnet or 0 for the global hosts.
+func (pull *hostsDaemonPull) getSubnetID() int64 {
+	if subnet := pull.it.getSubnet(pull.subnetIndex); subnet != nil {
+		return subnet.ID
+	}
+	return 0
+}
+
+// Resets the state before receiving the pages of the subnet at the
+// specified position in the iterator.
+func (pull *hostsDaemonPull) startSubnet(subnetIndex int) {
+	pull.subnetIndex = subnetIndex
+	pull.subnetPageCount = 0
+	pull.heldPages = nil
+	pull.heldHostCount = 0
+	pull.heldPagesDropped = false
+	pull.it.trace.addSubnet(pull.getSubnetID())
+}
+
+// Processes the page of the current subnet received from the iterator.
+// The page is held in memory if it is equal to the page pulled previously
+// and no change has been found in the subnet so far. Otherwise, it is
+// applied to the database.
+func (pull *hostsDaemonPull) addPage(reservations []keaconfig.Reservation) error {
+	pageIndex := pull.subnetPageCount
+	pull.subnetPageCount++
+	pull.pageCount++
+	pull.hostCount += len(reservations)
+	if pull.pageCount%hostsPullProgressPages == 0 {
+		log.WithFields(log.Fields{
+			"daemon_id": pull.daemon.ID,
+			"pages":     pull.pageCount,
+			"hosts":     pull.hostCount,
+			"subnet":    pull.subnetIndex + 2,
+			"subnets":   len(pull.it.subnets) + 1,
+		}).Info("pulling host reservations from Kea daemon in progress")
+	}
+
+	subnetID := pull.getSubnetID()
+	if pull.tx == nil {
+		hash, _ := pull.it.trace.getPageHash(subnetID, pageIndex)
+		if previousHash, ok := pull.previous.getPageHash(subnetID, pageIndex); ok && previousHash == hash {
+			pull.holdPage(reservations)
+			return nil
+		}
+		if err := pull.beginSubnetUpdate(pageIndex); err != nil {
+			return err
 		}
 	}
+	pull.modified = true
+	return convertAndUpdateHosts(pull.tx, pull.daemon, pull.it.getSubnet(pull.subnetIndex), reservations)
+}
 
-	// Only commit if we have started transaction. It means that we
-	// detected host changes.
-	if tx != nil {
-		if err = tx.Commit(); err != nil {
-			err = errors.Wrapf(err, "problem with committing transaction adding new hosts from host_cmds hooks library for daemon %d", daemon.ID)
-			return true, err
+// Holds the unchanged page in memory unless the limit of the held hosts
+// is exceeded. In this case, all held pages are dropped.
+func (pull *hostsDaemonPull) holdPage(reservations []keaconfig.Reservation) {
+	if pull.heldPagesDropped {
+		return
+	}
+	if pull.heldHostCount+len(reservations) > pull.puller.maxHeldHosts {
+		pull.heldPages = nil
+		pull.heldHostCount = 0
+		pull.heldPagesDropped = true
+		return
+	}
+	pull.heldPages = append(pull.heldPages, reservations)
+	pull.heldHostCount += len(reservations)
+}
+
+// Begins the transaction updating the hosts of the current subnet. It
+// dissociates the daemon from the hosts of the subnet and applies the
+// specified number of the first pages of the subnet. They are the held
+// pages or the pages fetched again if the held pages have been dropped.
+func (pull *hostsDaemonPull) beginSubnetUpdate(pageCount int) (err error) {
+	subnetID := pull.getSubnetID()
+	if pull.tx, err = pull.puller.DB.Begin(); err != nil {
+		err = errors.Wrapf(err, "problem with starting transaction for adding hosts from host_cmds hooks library for daemon %d", pull.daemon.ID)
+		return err
+	}
+
+	// Remove associations between existing host reservations in the
+	// subnet and the daemon. Some associations will be re-created and
+	// some possibly not. The orphaned hosts will be later removed.
+	deletedCount, err := dbmodel.DeleteDaemonFromSubnetHosts(pull.tx, pull.daemon.ID, []int64{subnetID}, "api")
+	if err != nil {
+		return err
+	}
+	if deletedCount > 0 || pageCount > 0 {
+		pull.modified = true
+	}
+
+	if !pull.heldPagesDropped {
+		for _, reservations := range pull.heldPages {
+			if err = convertAndUpdateHosts(pull.tx, pull.daemon, pull.it.getSubnet(pull.subnetIndex), reservations); err != nil {
+				return err
+			}
 		}
-		_ = puller.ReviewDispatcher.BeginReview(daemon, configreview.DBHostsModified, nil)
+		pull.heldPages = nil
+		pull.heldHostCount = 0
+		return nil
 	}
 
-	// We no longer need the hosts.
-	for i := range it.trace.responses {
-		it.trace.responses[i].hosts = nil
+	// Fetch the dropped pages again using a new iterator starting from
+	// the current subnet.
+	it := newHostIterator(pull.puller.DB, pull.app, pull.daemon, pull.puller.Agents, pull.it.limit)
+	it.subnets = pull.it.subnets
+	it.subnetIndex = pull.subnetIndex
+	for i := 0; i < pageCount; i++ {
+		var (
+			reservations []keaconfig.Reservation
+			done         bool
+		)
+		if reservations, done, err = it.getPageFromHostCmds(); err != nil {
+			return err
+		}
+		if len(reservations) == 0 || it.subnetIndex != pull.subnetIndex {
+			pull.inconsistentSubnets = append(pull.inconsistentSubnets, subnetID)
+			break
+		}
+		hash, _ := it.trace.getPageHash(subnetID, i)
+		if expectedHash, _ := pull.it.trace.getPageHash(subnetID, i); hash != expectedHash {
+			pull.inconsistentSubnets = append(pull.inconsistentSubnets, subnetID)
+		}
+		if err = convertAndUpdateHosts(pull.tx, pull.daemon, pull.it.getSubnet(pull.subnetIndex), reservations); err != nil {
+			return err
+		}
+		if done {
+			break
+		}
 	}
+	return nil
+}
 
-	// Remember the current trace.
-	puller.traces[daemon.ID] = it.trace
+// Completes processing the current subnet. If all pages of the subnet
+// are equal to the pages pulled previously but the number of pages
+// differs, the subnet is updated. It commits the transaction updating
+// the subnet.
+func (pull *hostsDaemonPull) finishSubnet() (err error) {
+	if pull.tx == nil {
+		previousCount, ok := pull.previous.getPageCount(pull.getSubnetID())
+		if ok && previousCount == pull.subnetPageCount {
+			pull.unchangedSubnetCount++
+			pull.heldPages = nil
+			pull.heldHostCount = 0
+			return nil
+		}
+		if err = pull.beginSubnetUpdate(pull.subnetPageCount); err != nil {
+			return err
+		}
+	}
 
-	return true, nil
+	err = pull.tx.Commit()
+	pull.tx = nil
+	if err != nil {
+		err = errors.Wrapf(err, "problem with committing transaction adding new hosts from host_cmds hooks library for daemon %d", pull.daemon.ID)
+		return err
+	}
+	pull.updatedSubnetCount++
+	return nil
 }
 
-// A structure used by the host iterator to remember host reservations
-// and hash values created from the host reservations for each
-// reservation-get-page command. The hashes can be later used to check
-// that Kea returns the same (or different) responses when Stork next
+// A structure used by the host iterator to remember hash values created
+// from the host reservations for each reservation-get-page command,
+// grouped by subnet. The hashes can be later used to check that Kea
+// returns the same (or different) responses for a subnet when Stork next
 // attempts to fetch hosts, without making a detailed comparison of the
 // responses. If the current hashes match the hashes from the previous
-// iteration, there is no need to update the hosts in the database,
-// perform config reviews etc.
+// iteration, there is no need to update the hosts of this subnet in the
+// database, perform config reviews etc.
 type hostIteratorTrace struct {
-	responses []hostIteratorTraceResponse
-}
-
-// A structure representing a single hosts page returned by Kea. It
-// includes a hash created from the returned hosts, an index of the
-// subnet (within the iterator) for which the hosts have been returned
-// and the returned reservations.
-type hostIteratorTraceResponse struct {
-	hash        string
-	subnetIndex int
-	hosts       []keaconfig.Reservation
+	// Hashes of the responses by subnet ID. The subnet ID of 0 designates
+	// the global hosts.
+	subnets       map[int64][]string
+	responseCount int
 }
 
 // Creates new trace instance.
 func newHostIteratorTrace() *hostIteratorTrace {
-	return &hostIteratorTrace{}
+	return &hostIteratorTrace{
+		subnets: make(map[int64][]string),
+	}
+}
+
+// Records the subnet without adding any responses. It allows for
+// distinguishing the subnet without the hosts from the subnet which
+// hasn't been traced.
+func (trace *hostIteratorTrace) addSubnet(subnetID int64) {
+	if _, ok := trace.subnets[subnetID]; !ok {
+		trace.subnets[subnetID] = []string{}
+	}
 }
 
-// Appends the specified response increasing the response count.
-func (trace *hostIteratorTrace) addResponse(hash string, subnetIndex int, hosts []keaconfig.Reservation) {
-	trace.responses = append(trace.responses, hostIteratorTraceResponse{hash, subnetIndex, hosts})
+// Appends the hash of the response for the specified subnet increasing
+// the response count.
+func (trace *hostIteratorTrace) addResponse(hash string, subnetID int64) {
+	trace.subnets[subnetID] = append(trace.subnets[subnetID], hash)
+	trace.responseCount++
+}
+
+// Removes the hashes of the responses for the specified subnet.
+func (trace *hostIteratorTrace) removeSubnet(subnetID int64) {
+	trace.responseCount -= len(trace.subnets[subnetID])
+	delete(trace.subnets, subnetID)
 }
 
 // Returns a number of responses currently held.
 func (trace *hostIteratorTrace) getResponseCount() int {
-	return len(trace.responses)
+	return trace.responseCount
 }
 
-// Checks if all hashes in the trace are equal to the hashes at the
-// same positions in the other trace.
-func (trace *hostIteratorTrace) hasEqualHashes(other *hostIteratorTrace) bool {
-	// If there are more hashes in this trace than in the other
-	// trace those additional hashes are unequal.
-	if len(trace.responses) > len(other.responses) {
-		return false
+// Returns the hash of the response at the specified position for the
+// subnet. The second returned value is false if there is no such response
+// or the trace is nil.
+func (trace *hostIteratorTrace) getPageHash(subnetID int64, index int) (string, bool) {
+	if trace == nil {
+		return "", false
 	}
-	// Iterate from the newest to the oldest response. It should
-	// marginally improve performance because in a typical case
-	// we have checked that the older hashes are equal.
-	for i := len(trace.responses) - 1; i >= 0; i-- {
-		if trace.responses[i].hash != other.responses[i].hash {
-			return false
-		}
+	hashes := trace.subnets[subnetID]
+	if index < 0 || index >= len(hashes) {
+		return "", false
 	}
-	return true
+	return hashes[index], true
+}
+
+// Returns the number of responses for the subnet. The second returned
+// value is false if the subnet hasn't been traced or the trace is nil.
+func (trace *hostIteratorTrace) getPageCount(subnetID int64) (int, bool) {
+	if trace == nil {
+		return 0, false
+	}
+	hashes, ok := trace.subnets[subnetID]
+	return len(hashes), ok
 }
 
 // Structure reflecting a state of fetching host reservations from Kea
@@ -329,6 +633,8 @@ type hostIterator struct {
 	subnets     []dbmodel.Subnet
 	subnetIndex int
 	trace       *hostIteratorTrace
+	// Indicates that the reservation-get-page command is not supported.
+	unsupported bool
 }
 
 // Creates new iterator instance.
@@ -485,6 +791,7 @@ func (iterator *hostIterator) getPageFromHostCmds() (hosts []keaconfig.Reservati
 
 		// If the command is not supported for this daemon there is nothing more to do.
 		if result == keactrl.ResponseCommandUnsupported {
+			iterator.unsupported = true
 			return hosts, true, nil
 		}
 
@@ -499,7 +806,11 @@ func (iterator *hostIterator) getPageFromHostCmds() (hosts []keaconfig.Reservati
 
 		// Hash the returned hosts and remember the hash in the iterator.
 		hash := storkutil.Fnv128(fmt.Sprintf("%+v", hosts))
-		iterator.trace.addResponse(hash, iterator.subnetIndex, hosts)
+		subnetID := int64(0)
+		if subnet := iterator.getCurrentSubnet(); subnet != nil {
+			subnetID = subnet.ID
+		}
+		iterator.trace.addResponse(hash, subnetID)
 
 		// We return one chunk of hosts for one subnet. So let's get out
 		// of this loop.
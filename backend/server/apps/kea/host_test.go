@@ -4,6 +4,7 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"net"
 	"strings"
 	"testing"
 
@@ -12,6 +13,7 @@ import (
 	keactrl "isc.org/stork/appctrl/kea"
 	agentcommtest "isc.org/stork/server/agentcomm/test"
 	"isc.org/stork/server/configreview"
+	dbops "isc.org/stork/server/database"
 	dbmodel "isc.org/stork/server/database/model"
 	dbtest "isc.org/stork/server/database/test"
 	storktest "isc.org/stork/server/test"
@@ -1645,40 +1647,271 @@ func TestSkipPullingHostsIntoDB(t *testing.T) {
 
 // Test that host hash tracing works fine.
 func TestHostIteratorTrace(t *testing.T) {
-	// Create new traces.
-	trace0 := newHostIteratorTrace()
-	require.NotNil(t, trace0)
-	trace1 := newHostIteratorTrace()
-	require.NotNil(t, trace1)
-	trace2 := newHostIteratorTrace()
-	require.NotNil(t, trace1)
+	// Create new trace.
+	trace := newHostIteratorTrace()
+	require.NotNil(t, trace)
+	require.Zero(t, trace.getResponseCount())
+
+	// The subnet hasn't been traced.
+	_, ok := trace.getPageCount(0)
+	require.False(t, ok)
+	_, ok = trace.getPageHash(0, 0)
+	require.False(t, ok)
+
+	// The subnet without hosts.
+	trace.addSubnet(0)
+	count, ok := trace.getPageCount(0)
+	require.True(t, ok)
+	require.Zero(t, count)
 
-	hosts := []keaconfig.Reservation{}
+	// Add the hashes for two subnets.
+	trace.addResponse("1234", 1)
+	trace.addResponse("2345", 1)
+	trace.addResponse("3456", 2)
+	require.EqualValues(t, 3, trace.getResponseCount())
+
+	count, ok = trace.getPageCount(1)
+	require.True(t, ok)
+	require.EqualValues(t, 2, count)
+
+	hash, ok := trace.getPageHash(1, 1)
+	require.True(t, ok)
+	require.Equal(t, "2345", hash)
+	_, ok = trace.getPageHash(1, 2)
+	require.False(t, ok)
+
+	hash, ok = trace.getPageHash(2, 0)
+	require.True(t, ok)
+	require.Equal(t, "3456", hash)
+
+	// Remove the subnet.
+	trace.removeSubnet(1)
+	require.EqualValues(t, 1, trace.getResponseCount())
+	_, ok = trace.getPageCount(1)
+	require.False(t, ok)
+
+	// The nil trace contains no hashes.
+	var nilTrace *hostIteratorTrace
+	_, ok = nilTrace.getPageCount(2)
+	require.False(t, ok)
+	_, ok = nilTrace.getPageHash(2, 0)
+	require.False(t, ok)
+}
+
+// Returns the function mocking the responses to the consecutive
+// reservation-get-page commands sent to the DHCPv4 server. Each
+// response contains the hosts with the specified IPv4 addresses.
+func newMockReservationGetPageSequence(responses [][]string) func(int, []interface{}) {
+	return func(callNo int, cmdResponses []interface{}) {
+		hosts := []keaconfig.Reservation{}
+		if callNo < len(responses) {
+			for _, address := range responses[callNo] {
+				ip := net.ParseIP(address).To4()
+				hosts = append(hosts, keaconfig.Reservation{
+					HWAddress: fmt.Sprintf("01:02:03:04:%02x:%02x", ip[2], ip[3]),
+					IPAddress: address,
+				})
+			}
+		}
+		hostsAsJSON, _ := json.Marshal(hosts)
+		json := []byte(fmt.Sprintf(`[
+            {
+                "result": 0,
+                "text": "Hosts found",
+                "arguments": {
+                    "count": %d,
+                    "hosts": %s,
+                    "next": {
+                        "from": %d,
+                        "source-index": 1
+                    }
+                }
+            }
+        ]`, len(hosts), string(hostsAsJSON), len(hosts)))
+
+		daemons, _ := keactrl.NewDaemons("dhcp4")
+		command, _ := keactrl.NewCommand("reservation-get-page", daemons, nil)
+
+		_ = keactrl.UnmarshalResponseList(command, json, cmdResponses[0])
+	}
+}
+
+// Adds the app with the DHCPv4 daemon having four subnets and the
+// host_cmds hooks library.
+func addTestHostCmdsApp(t *testing.T, db *dbops.PgDB) *dbmodel.App {
+	m := &dbmodel.Machine{
+		Address:   "localhost",
+		AgentPort: 8080,
+	}
+	err := dbmodel.AddMachine(db, m)
+	require.NoError(t, err)
+
+	accessPoints := []*dbmodel.AccessPoint{}
+	accessPoints = dbmodel.AppendAccessPoint(accessPoints, dbmodel.AccessPointControl, "localhost", "", 8000, false)
+	app := &dbmodel.App{
+		MachineID:    m.ID,
+		Type:         dbmodel.AppTypeKea,
+		AccessPoints: accessPoints,
+		Daemons: []*dbmodel.Daemon{
+			{
+				Name:   "dhcp4",
+				Active: true,
+				KeaDaemon: &dbmodel.KeaDaemon{
+					KeaDHCPDaemon: &dbmodel.KeaDHCPDaemon{},
+					Config:        getTestConfigWithIPv4Subnets(t, true),
+				},
+			},
+		},
+	}
+	_, err = dbmodel.AddApp(db, app)
+	require.NoError(t, err)
+	app.Machine = m
 
-	trace0.addResponse("1234", 0, hosts)
-	require.EqualValues(t, 1, trace0.getResponseCount())
-	trace0.addResponse("2345", 0, hosts)
-	require.EqualValues(t, 2, trace0.getResponseCount())
-
-	// trace1 has no hashes. Comparing them with trace1 hashes should
-	// always pass.
-	require.True(t, trace1.hasEqualHashes(trace0))
-	// Comparing the other way around should fail because hashes from
-	// trace0 are missing in trace1.
-	require.False(t, trace0.hasEqualHashes(trace1))
-
-	// Add a matching hash.
-	trace1.addResponse("1234", 0, hosts)
-	require.True(t, trace1.hasEqualHashes(trace0))
-	// Add non matching hash.
-	trace1.addResponse("5678", 0, hosts)
-	require.False(t, trace1.hasEqualHashes(trace0))
-
-	// First add matching hashes, followed by a non-matching one.
-	trace2.addResponse("1234", 0, hosts)
-	require.True(t, trace2.hasEqualHashes(trace0))
-	trace2.addResponse("2345", 0, hosts)
-	require.True(t, trace2.hasEqualHashes(trace0))
-	trace2.addResponse("3456", 0, hosts)
-	require.False(t, trace2.hasEqualHashes(trace0))
+	err = CommitAppIntoDB(db, app, &storktest.FakeEventCenter{}, nil)
+	require.NoError(t, err)
+	return app
+}
+
+// Returns the reserved addresses of the hosts associated with the daemon.
+func getDaemonHostAddresses(t *testing.T, db *dbops.PgDB, daemonID int64) []string {
+	hosts, err := dbmodel.GetAllHosts(db, 4)
+	require.NoError(t, err)
+	addresses := []string{}
+	for _, host := range hosts {
+		for _, localHost := range host.LocalHosts {
+			if localHost.DaemonID == daemonID {
+				addresses = append(addresses, host.IPReservations[0].Address)
+				break
+			}
+		}
+	}
+	return addresses
+}
+
+// Test that only the subnets with changed host reservations are updated
+// in the database.
+func TestPullHostsUpdatesChangedSubnets(t *testing.T) {
+	db, _, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	app := addTestHostCmdsApp(t, db)
+	daemon := app.Daemons[0]
+
+	// The iterator fetches the global hosts followed by the hosts of
+	// the four subnets. An empty response terminates the hosts list of
+	// each subnet.
+	fa := agentcommtest.NewFakeAgents(newMockReservationGetPageSequence([][]string{
+		// First pull.
+		{}, {"192.0.3.10"}, {}, {"192.0.4.10"}, {}, {}, {},
+		// Second pull, the host in the second subnet is replaced.
+		{}, {"192.0.3.10"}, {}, {"192.0.4.11"}, {}, {}, {},
+		// Third pull, the host in the first subnet is removed.
+		{}, {}, {"192.0.4.11"}, {}, {}, {},
+	}), nil)
+
+	err := dbmodel.InitializeSettings(db)
+	require.NoError(t, err)
+	fd := &storktest.FakeDispatcher{}
+	puller, err := NewHostsPuller(db, fa, fd)
+	require.NoError(t, err)
+	defer puller.Shutdown()
+
+	// The subnets with the hosts are updated during the first pull
+	// because there is no trace. The empty subnets are unchanged because
+	// the daemon had no hosts.
+	pull := newHostsDaemonPull(puller, app, daemon)
+	require.NoError(t, pull.run())
+	require.True(t, pull.modified)
+	require.True(t, pull.hadNoHosts)
+	require.EqualValues(t, 2, pull.updatedSubnetCount)
+	require.EqualValues(t, 3, pull.unchangedSubnetCount)
+	require.EqualValues(t, 2, pull.pageCount)
+	require.EqualValues(t, 2, pull.hostCount)
+	puller.traces[daemon.ID] = pull.it.trace
+	require.ElementsMatch(t, []string{"192.0.3.10/32", "192.0.4.10/32"}, getDaemonHostAddresses(t, db, daemon.ID))
+
+	// Only the second subnet is updated.
+	pull = newHostsDaemonPull(puller, app, daemon)
+	require.NoError(t, pull.run())
+	require.True(t, pull.modified)
+	require.EqualValues(t, 1, pull.updatedSubnetCount)
+	require.EqualValues(t, 4, pull.unchangedSubnetCount)
+	puller.traces[daemon.ID] = pull.it.trace
+	require.ElementsMatch(t, []string{"192.0.3.10/32", "192.0.4.11/32"}, getDaemonHostAddresses(t, db, daemon.ID))
+
+	// The first subnet has no pages, so it is updated.
+	pull = newHostsDaemonPull(puller, app, daemon)
+	require.NoError(t, pull.run())
+	require.True(t, pull.modified)
+	require.EqualValues(t, 1, pull.updatedSubnetCount)
+	require.EqualValues(t, 4, pull.unchangedSubnetCount)
+	puller.traces[daemon.ID] = pull.it.trace
+	require.ElementsMatch(t, []string{"192.0.4.11/32"}, getDaemonHostAddresses(t, db, daemon.ID))
+
+	// Nothing has changed.
+	fa.CallNo = 14
+	pull = newHostsDaemonPull(puller, app, daemon)
+	require.NoError(t, pull.run())
+	require.False(t, pull.modified)
+	require.Zero(t, pull.updatedSubnetCount)
+	require.EqualValues(t, 5, pull.unchangedSubnetCount)
+	puller.traces[daemon.ID] = pull.it.trace
+
+	// Without the trace, all subnets are updated because the daemon has
+	// the hosts in the database.
+	delete(puller.traces, daemon.ID)
+	fa.CallNo = 14
+	pull = newHostsDaemonPull(puller, app, daemon)
+	require.NoError(t, pull.run())
+	require.False(t, pull.hadNoHosts)
+	require.EqualValues(t, 5, pull.updatedSubnetCount)
+	require.Zero(t, pull.unchangedSubnetCount)
+	require.ElementsMatch(t, []string{"192.0.4.11/32"}, getDaemonHostAddresses(t, db, daemon.ID))
+}
+
+// Test that the pages dropped due to the limit of the hosts held in memory
+// are fetched again when a change is found later in the subnet.
+func TestPullHostsFetchesDroppedPagesAgain(t *testing.T) {
+	db, _, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	app := addTestHostCmdsApp(t, db)
+	daemon := app.Daemons[0]
+
+	fa := agentcommtest.NewFakeAgents(newMockReservationGetPageSequence([][]string{
+		// First pull.
+		{}, {"192.0.3.10", "192.0.3.11"}, {"192.0.3.12"}, {}, {}, {}, {},
+		// Second pull, the host on the second page of the first subnet
+		// is replaced.
+		{}, {"192.0.3.10", "192.0.3.11"}, {"192.0.3.13"},
+		// The first page is fetched again.
+		{"192.0.3.10", "192.0.3.11"},
+		// The remaining pages.
+		{}, {}, {}, {},
+	}), nil)
+
+	err := dbmodel.InitializeSettings(db)
+	require.NoError(t, err)
+	fd := &storktest.FakeDispatcher{}
+	puller, err := NewHostsPuller(db, fa, fd)
+	require.NoError(t, err)
+	defer puller.Shutdown()
+	// The first page doesn't fit in the memory.
+	puller.maxHeldHosts = 1
+
+	err = puller.pull()
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"192.0.3.10/32", "192.0.3.11/32", "192.0.3.12/32"},
+		getDaemonHostAddresses(t, db, daemon.ID))
+	require.EqualValues(t, 7, fa.CallNo)
+
+	err = puller.pull()
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"192.0.3.10/32", "192.0.3.11/32", "192.0.3.13/32"},
+		getDaemonHostAddresses(t, db, daemon.ID))
+	require.EqualValues(t, 15, fa.CallNo)
+
+	// The hosts have been updated during both pulls.
+	require.Len(t, fd.CallLog, 2)
+	require.EqualValues(t, 2, puller.traces[daemon.ID].getResponseCount())
 }
@@ -7,12 +7,14 @@ import (
 
 	errors "github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
 
 	keactrl "isc.org/stork/appctrl/kea"
 	keadata "isc.org/stork/appdata/kea"
 	"isc.org/stork/server/agentcomm"
 	dbops "isc.org/stork/server/database"
 	dbmodel "isc.org/stork/server/database/model"
+	"isc.org/stork/tracing"
 	storkutil "isc.org/stork/util"
 )
 
@@ -65,7 +67,7 @@ func validateGetLeasesResponse(commandName string, result int, arguments interfa
 // Sends a lease4-get command with ip-address argument specifying a searched lease.
 // If the lease is found, the pointer to it is returned. If the lease does not
 // exist, a nil pointer and nil error are returned.
-func GetLease4ByIPAddress(agents agentcomm.ConnectedAgents, dbApp *dbmodel.App, ipaddress string) (lease *dbmodel.Lease, err error) {
+func GetLease4ByIPAddress(ctx context.Context, agents agentcomm.ConnectedAgents, dbApp *dbmodel.App, ipaddress string) (lease *dbmodel.Lease, err error) {
 	daemons, err := keactrl.NewDaemons("dhcp4")
 	if err != nil {
 		return lease, err
@@ -78,7 +80,6 @@ func GetLease4ByIPAddress(agents agentcomm.ConnectedAgents, dbApp *dbmodel.App,
 		return lease, err
 	}
 	response := make([]Lease4GetResponse, 1)
-	ctx := context.Background()
 	respResult, err := agents.ForwardToKeaOverHTTP(ctx, dbApp, []*keactrl.Command{command}, &response)
 	if err != nil {
 		return lease, err
@@ -105,7 +106,7 @@ func GetLease4ByIPAddress(agents agentcomm.ConnectedAgents, dbApp *dbmodel.App,
 // searched lease type and IP address. If the lease is found, the pointer to
 // it is returned. If the lease does not exist, a nil pointer and nil error
 // are returned.
-func GetLease6ByIPAddress(agents agentcomm.ConnectedAgents, dbApp *dbmodel.App, leaseType, ipaddress string) (lease *dbmodel.Lease, err error) {
+func GetLease6ByIPAddress(ctx context.Context, agents agentcomm.ConnectedAgents, dbApp *dbmodel.App, leaseType, ipaddress string) (lease *dbmodel.Lease, err error) {
 	daemons, err := keactrl.NewDaemons("dhcp6")
 	if err != nil {
 		return lease, err
@@ -119,7 +120,6 @@ func GetLease6ByIPAddress(agents agentcomm.ConnectedAgents, dbApp *dbmodel.App,
 		return lease, err
 	}
 	response := make([]Lease6GetResponse, 1)
-	ctx := context.Background()
 	respResult, err := agents.ForwardToKeaOverHTTP(ctx, dbApp, []*keactrl.Command{command}, &response)
 	if err != nil {
 		return lease, err
@@ -151,7 +151,7 @@ func GetLease6ByIPAddress(agents agentcomm.ConnectedAgents, dbApp *dbmodel.App,
 // lease4-get-by-hw-address, lease4-get-by-client-id and lease6-get-by-duid
 // commands. The specified commands are combined in a single gRPC transaction
 // to minimize the number of roundtrips between the Stork Server and an agent.
-func getLeasesByProperties(agents agentcomm.ConnectedAgents, dbApp *dbmodel.App, propertyValue string, commandNames ...string) (leases []dbmodel.Lease, warns bool, err error) {
+func getLeasesByProperties(ctx context.Context, agents agentcomm.ConnectedAgents, dbApp *dbmodel.App, propertyValue string, commandNames ...string) (leases []dbmodel.Lease, warns bool, err error) {
 	var commands []*keactrl.Command
 	for _, commandName := range commandNames {
 		var daemons *keactrl.Daemons
@@ -228,8 +228,6 @@ func getLeasesByProperties(agents agentcomm.ConnectedAgents, dbApp *dbmodel.App,
 		responses = append(responses, &response)
 	}
 
-	ctx := context.Background()
-
 	// Send all commands to Kea via Stork Agent.
 	respResult, err := agents.ForwardToKeaOverHTTP(ctx, dbApp, commands, responses...)
 	if err != nil {
@@ -270,32 +268,32 @@ func getLeasesByProperties(agents agentcomm.ConnectedAgents, dbApp *dbmodel.App,
 }
 
 // Sends lease4-get-by-hw-address command to Kea.
-func GetLeases4ByHWAddress(agents agentcomm.ConnectedAgents, dbApp *dbmodel.App, hwaddress string) (leases []dbmodel.Lease, err error) {
-	leases, _, err = getLeasesByProperties(agents, dbApp, hwaddress, "lease4-get-by-hw-address")
+func GetLeases4ByHWAddress(ctx context.Context, agents agentcomm.ConnectedAgents, dbApp *dbmodel.App, hwaddress string) (leases []dbmodel.Lease, err error) {
+	leases, _, err = getLeasesByProperties(ctx, agents, dbApp, hwaddress, "lease4-get-by-hw-address")
 	return leases, err
 }
 
 // Sends lease4-get-by-client-id command to Kea.
-func GetLeases4ByClientID(agents agentcomm.ConnectedAgents, dbApp *dbmodel.App, clientID string) (leases []dbmodel.Lease, err error) {
-	leases, _, err = getLeasesByProperties(agents, dbApp, clientID, "lease4-get-by-client-id")
+func GetLeases4ByClientID(ctx context.Context, agents agentcomm.ConnectedAgents, dbApp *dbmodel.App, clientID string) (leases []dbmodel.Lease, err error) {
+	leases, _, err = getLeasesByProperties(ctx, agents, dbApp, clientID, "lease4-get-by-client-id")
 	return leases, err
 }
 
 // Sends lease4-get-by-hostname command to Kea.
-func GetLeases4ByHostname(agents agentcomm.ConnectedAgents, dbApp *dbmodel.App, hostname string) (leases []dbmodel.Lease, err error) {
-	leases, _, err = getLeasesByProperties(agents, dbApp, hostname, "lease4-get-by-hostname")
+func GetLeases4ByHostname(ctx context.Context, agents agentcomm.ConnectedAgents, dbApp *dbmodel.App, hostname string) (leases []dbmodel.Lease, err error) {
+	leases, _, err = getLeasesByProperties(ctx, agents, dbApp, hostname, "lease4-get-by-hostname")
 	return leases, err
 }
 
 // Sends lease6-get-by-duid command to Kea.
-func GetLeases6ByDUID(agents agentcomm.ConnectedAgents, dbApp *dbmodel.App, duid string) (leases []dbmodel.Lease, err error) {
-	leases, _, err = getLeasesByProperties(agents, dbApp, duid, "lease6-get-by-duid")
+func GetLeases6ByDUID(ctx context.Context, agents agentcomm.ConnectedAgents, dbApp *dbmodel.App, duid string) (leases []dbmodel.Lease, err error) {
+	leases, _, err = getLeasesByProperties(ctx, agents, dbApp, duid, "lease6-get-by-duid")
 	return leases, err
 }
 
 // Sends lease6-get-by-hostname command to Kea.
-func GetLeases6ByHostname(agents agentcomm.ConnectedAgents, dbApp *dbmodel.App, hostname string) (leases []dbmodel.Lease, err error) {
-	leases, _, err = getLeasesByProperties(agents, dbApp, hostname, "lease6-get-by-hostname")
+func GetLeases6ByHostname(ctx context.Context, agents agentcomm.ConnectedAgents, dbApp *dbmodel.App, hostname string) (leases []dbmodel.Lease, err error) {
+	leases, _, err = getLeasesByProperties(ctx, agents, dbApp, hostname, "lease6-get-by-hostname")
 	return leases, err
 }
 
@@ -323,7 +321,13 @@ func hasLeaseCmdsHook(app *dbmodel.App, daemonName string) bool {
 // that some leases may not be included due to the communication
 // errors with some servers. The third returned value indicates
 // a general error, e.g. issues with Stork database communication.
-func FindLeases(db *dbops.PgDB, agents agentcomm.ConnectedAgents, text string) (leases []dbmodel.Lease, erredApps []*dbmodel.App, err error) {
+func FindLeases(ctx context.Context, db *dbops.PgDB, agents agentcomm.ConnectedAgents, text string) (leases []dbmodel.Lease, erredApps []*dbmodel.App, err error) {
+	ctx, span := tracing.StartSpan(ctx, "kea.FindLeases", attribute.String("stork.lease.query", text))
+	defer func() {
+		span.SetAttributes(attribute.Int("stork.lease.count", len(leases)), attribute.Int("stork.lease.erred_apps", len(erredApps)))
+		tracing.EndSpan(span, err)
+	}()
+
 	// Recognize if the text comprises an IP address or some identifier,
 	// e.g. MAC address or client identifier.
 	const (
@@ -363,7 +367,7 @@ func FindLeases(db *dbops.PgDB, agents agentcomm.ConnectedAgents, text string) (
 		case ipv4:
 			if hasLeaseCmdsHook(&apps[i], dbmodel.DaemonNameDHCPv4) {
 				// This is an IPv4 address, so send the command to the DHCPv4 server.
-				lease, err := GetLease4ByIPAddress(agents, &apps[i], text)
+				lease, err := GetLease4ByIPAddress(ctx, agents, &apps[i], text)
 				if err != nil {
 					appError = true
 					log.Warn(err)
@@ -376,7 +380,7 @@ func FindLeases(db *dbops.PgDB, agents agentcomm.ConnectedAgents, text string) (
 				// This is an IPv6 address (or prefix), so send the command to the
 				// DHCPv6 server instead.
 				for _, leaseType := range []string{"IA_NA", "IA_PD"} {
-					lease, err := GetLease6ByIPAddress(agents, &apps[i], leaseType, text)
+					lease, err := GetLease6ByIPAddress(ctx, agents, &apps[i], leaseType, text)
 					if err != nil {
 						appError = true
 						log.Warn(err)
@@ -410,7 +414,7 @@ func FindLeases(db *dbops.PgDB, agents agentcomm.ConnectedAgents, text string) (
 				}
 			}
 			// Search for leases by identifier or hostname.
-			leasesByProperties, warns, err := getLeasesByProperties(agents, &apps[i], text, commands...)
+			leasesByProperties, warns, err := getLeasesByProperties(ctx, agents, &apps[i], text, commands...)
 			appError = warns
 			if err != nil {
 				appError = true
@@ -437,7 +441,7 @@ func FindLeases(db *dbops.PgDB, agents agentcomm.ConnectedAgents, text string) (
 // aware that some leases may not be included due to the communication
 // errors with some servers. The third returned value indicates a general
 // error, e.g. issues with Stork database communication.
-func FindDeclinedLeases(db *dbops.PgDB, agents agentcomm.ConnectedAgents) (leases []dbmodel.Lease, erredApps []*dbmodel.App, err error) {
+func FindDeclinedLeases(ctx context.Context, db *dbops.PgDB, agents agentcomm.ConnectedAgents) (leases []dbmodel.Lease, erredApps []*dbmodel.App, err error) {
 	// Get all Kea apps.
 	apps, err := dbmodel.GetAppsByType(db, dbmodel.AppTypeKea)
 	if err != nil {
@@ -458,7 +462,7 @@ func FindDeclinedLeases(db *dbops.PgDB, agents agentcomm.ConnectedAgents) (lease
 		}
 
 		// Send these commands with empty hw-address and empty duid.
-		leasesByProperties, warns, err := getLeasesByProperties(agents, &apps[i], "", commands...)
+		leasesByProperties, warns, err := getLeasesByProperties(ctx, agents, &apps[i], "", commands...)
 		appError = warns
 		if err != nil {
 			appError = true
@@ -534,7 +538,7 @@ func findHostLeaseConflicts(host *dbmodel.Host, leases []dbmodel.Lease) (conflic
 // monitored Kea servers querying for leases assigned to the given host.
 // If there is a communication problem with any of the Kea servers, the details
 // of the server are recorded in the erredApps slice.
-func FindLeasesByHostID(db *dbops.PgDB, agents agentcomm.ConnectedAgents, hostID int64) (leases []dbmodel.Lease, conflicts []int64, erredApps []*dbmodel.App, err error) {
+func FindLeasesByHostID(ctx context.Context, db *dbops.PgDB, agents agentcomm.ConnectedAgents, hostID int64) (leases []dbmodel.Lease, conflicts []int64, erredApps []*dbmodel.App, err error) {
 	host, err := dbmodel.GetHost(db, hostID)
 	if err != nil {
 		err = errors.WithMessagef(err, "failed to fetch host with ID %d while searching for its leases", hostID)
@@ -571,7 +575,7 @@ func FindLeasesByHostID(db *dbops.PgDB, agents agentcomm.ConnectedAgents, hostID
 			switch parsedIP.Protocol {
 			case storkutil.IPv4:
 				if !dhcp4Error && hasLeaseCmdsHook(&apps[i], dbmodel.DaemonNameDHCPv4) {
-					lease, err := GetLease4ByIPAddress(agents, &apps[i], parsedIP.NetworkPrefix)
+					lease, err := GetLease4ByIPAddress(ctx, agents, &apps[i], parsedIP.NetworkPrefix)
 					if err != nil {
 						dhcp4Error = true
 						log.Warn(err)
@@ -589,7 +593,7 @@ func FindLeasesByHostID(db *dbops.PgDB, agents agentcomm.ConnectedAgents, hostID
 					if parsedIP.Prefix {
 						leaseType = "IA_PD"
 					}
-					lease, err := GetLease6ByIPAddress(agents, &apps[i], leaseType, parsedIP.NetworkPrefix)
+					lease, err := GetLease6ByIPAddress(ctx, agents, &apps[i], leaseType, parsedIP.NetworkPrefix)
 					if err != nil {
 						dhcp6Error = true
 						log.Warn(err)
@@ -1,6 +1,7 @@
 package kea
 
 import (
+	"context"
 	"testing"
 
 	require "github.com/stretchr/testify/require"
@@ -430,7 +431,7 @@ func TestGetLease4ByIPAddress(t *testing.T) {
 		AccessPoints: accessPoints,
 	}
 
-	lease, err := GetLease4ByIPAddress(agents, app, "192.0.2.3")
+	lease, err := GetLease4ByIPAddress(context.Background(), agents, app, "192.0.2.3")
 	require.NoError(t, err)
 	require.NotNil(t, lease)
 
@@ -460,7 +461,7 @@ func TestGetLease6ByIPAddress(t *testing.T) {
 		AccessPoints: accessPoints,
 	}
 
-	lease, err := GetLease6ByIPAddress(agents, app, "IA_NA", "2001:db8:2::1")
+	lease, err := GetLease6ByIPAddress(context.Background(), agents, app, "IA_NA", "2001:db8:2::1")
 	require.NoError(t, err)
 	require.NotNil(t, lease)
 
@@ -493,7 +494,7 @@ func TestGetLease6ByPrefix(t *testing.T) {
 		AccessPoints: accessPoints,
 	}
 
-	lease, err := GetLease6ByIPAddress(agents, app, "IA_PD", "2001:db8:0:0:2::")
+	lease, err := GetLease6ByIPAddress(context.Background(), agents, app, "IA_PD", "2001:db8:0:0:2::")
 	require.NoError(t, err)
 	require.NotNil(t, lease)
 
@@ -526,7 +527,7 @@ func TestGetLease4ByIPAddressEmpty(t *testing.T) {
 		AccessPoints: accessPoints,
 	}
 
-	lease, err := GetLease4ByIPAddress(agents, app, "192.0.2.3")
+	lease, err := GetLease4ByIPAddress(context.Background(), agents, app, "192.0.2.3")
 	require.NoError(t, err)
 	require.Nil(t, lease)
 }
@@ -542,7 +543,7 @@ func TestGetLease6ByIPAddressEmpty(t *testing.T) {
 		AccessPoints: accessPoints,
 	}
 
-	lease, err := GetLease6ByIPAddress(agents, app, "IA_NA", "2001:db8:1::2")
+	lease, err := GetLease6ByIPAddress(context.Background(), agents, app, "IA_NA", "2001:db8:1::2")
 	require.NoError(t, err)
 	require.Nil(t, lease)
 }
@@ -561,7 +562,7 @@ func TestGetLeases4(t *testing.T) {
 
 	tests := []struct {
 		name          string
-		function      func(agentcomm.ConnectedAgents, *dbmodel.App, string) ([]dbmodel.Lease, error)
+		function      func(context.Context, agentcomm.ConnectedAgents, *dbmodel.App, string) ([]dbmodel.Lease, error)
 		propertyValue string
 	}{
 		{
@@ -585,7 +586,7 @@ func TestGetLeases4(t *testing.T) {
 		testedFunc := tests[i].function
 		propertyValue := tests[i].propertyValue
 		t.Run(tests[i].name, func(t *testing.T) {
-			leases, err := testedFunc(agents, app, propertyValue)
+			leases, err := testedFunc(context.Background(), agents, app, propertyValue)
 			require.NoError(t, err)
 			require.Len(t, leases, 1)
 
@@ -620,7 +621,7 @@ func TestGetLeases6(t *testing.T) {
 
 	tests := []struct {
 		name          string
-		function      func(agentcomm.ConnectedAgents, *dbmodel.App, string) ([]dbmodel.Lease, error)
+		function      func(context.Context, agentcomm.ConnectedAgents, *dbmodel.App, string) ([]dbmodel.Lease, error)
 		propertyValue string
 	}{
 		{
@@ -639,7 +640,7 @@ func TestGetLeases6(t *testing.T) {
 		testedFunc := tests[i].function
 		propertyValue := tests[i].propertyValue
 		t.Run(tests[i].name, func(t *testing.T) {
-			leases, err := testedFunc(agents, app, propertyValue)
+			leases, err := testedFunc(context.Background(), agents, app, propertyValue)
 			require.NoError(t, err)
 			require.Len(t, leases, 2)
 
@@ -692,7 +693,7 @@ func TestGetLeases4Empty(t *testing.T) {
 		AccessPoints: accessPoints,
 	}
 
-	leases, err := GetLeases4ByHWAddress(agents, app, "000000000000")
+	leases, err := GetLeases4ByHWAddress(context.Background(), agents, app, "000000000000")
 	require.NoError(t, err)
 	require.Empty(t, leases)
 
@@ -714,7 +715,7 @@ func TestGetLeases6Empty(t *testing.T) {
 		AccessPoints: accessPoints,
 	}
 
-	leases, err := GetLeases6ByHostname(agents, app, "myhost")
+	leases, err := GetLeases6ByHostname(context.Background(), agents, app, "myhost")
 	require.NoError(t, err)
 	require.Empty(t, leases)
 }
@@ -732,7 +733,7 @@ func TestGetLeasesByPropertiesSecondError(t *testing.T) {
 		AccessPoints: accessPoints,
 	}
 
-	leases, warns, err := getLeasesByProperties(agents, app, "42:42:42:42:42:42:42:42", "lease4-get-by-hw-address", "lease4-get-by-client-id")
+	leases, warns, err := getLeasesByProperties(context.Background(), agents, app, "42:42:42:42:42:42:42:42", "lease4-get-by-hw-address", "lease4-get-by-client-id")
 	require.NoError(t, err)
 	require.True(t, warns)
 	require.Len(t, leases, 1)
@@ -895,7 +896,7 @@ func TestFindLeases(t *testing.T) {
 	agents := agentcommtest.NewFakeAgents(mockLeases4GetEmpty, nil)
 
 	//  Find lease by IPv4 address.
-	_, erredApps, err := FindLeases(db, agents, "192.0.2.3")
+	_, erredApps, err := FindLeases(context.Background(), db, agents, "192.0.2.3")
 	require.NoError(t, err)
 	require.Empty(t, erredApps)
 
@@ -907,7 +908,7 @@ func TestFindLeases(t *testing.T) {
 	agents = agentcommtest.NewFakeAgents(mockLease4GetFirstCallError, nil)
 
 	// Test the case when one of the servers returns an error.
-	_, erredApps, err = FindLeases(db, agents, "192.0.2.3")
+	_, erredApps, err = FindLeases(context.Background(), db, agents, "192.0.2.3")
 	require.NoError(t, err)
 	require.Len(t, erredApps, 1)
 	require.NotNil(t, erredApps[0])
@@ -921,7 +922,7 @@ func TestFindLeases(t *testing.T) {
 	agents = agentcommtest.NewFakeAgents(mockLeases4GetEmpty, nil)
 
 	// Find lease by IPv6 address.
-	_, erredApps, err = FindLeases(db, agents, "2001:db8:1::")
+	_, erredApps, err = FindLeases(context.Background(), db, agents, "2001:db8:1::")
 	require.NoError(t, err)
 	require.Empty(t, erredApps)
 
@@ -937,7 +938,7 @@ func TestFindLeases(t *testing.T) {
 	agents = agentcommtest.NewFakeAgents(mockLeases4GetEmpty, nil)
 
 	// Find lease by identifier.
-	_, erredApps, err = FindLeases(db, agents, "010203040506")
+	_, erredApps, err = FindLeases(context.Background(), db, agents, "010203040506")
 	require.NoError(t, err)
 	require.Empty(t, erredApps)
 
@@ -962,7 +963,7 @@ func TestFindLeases(t *testing.T) {
 	agents = agentcommtest.NewFakeAgents(mockLeases4GetEmpty, nil)
 
 	// Find lease by hostname.
-	_, erredApps, err = FindLeases(db, agents, "myhost")
+	_, erredApps, err = FindLeases(context.Background(), db, agents, "myhost")
 	require.NoError(t, err)
 	require.Empty(t, erredApps)
 
@@ -1040,7 +1041,7 @@ func TestFindDeclinedLeases(t *testing.T) {
 	// in the declined state.
 	agents := agentcommtest.NewFakeAgents(mockLeasesGetDeclined, nil)
 
-	leases, erredApps, err := FindDeclinedLeases(db, agents)
+	leases, erredApps, err := FindDeclinedLeases(context.Background(), db, agents)
 	require.NoError(t, err)
 	require.Empty(t, erredApps)
 
@@ -1076,7 +1077,7 @@ func TestFindDeclinedLeases(t *testing.T) {
 	// Simulate an error in the first response. The app returning an error should
 	// be recorded, but the DHCPv6 lease should still be returned.
 	agents = agentcommtest.NewFakeAgents(mockLeasesGetDeclinedErrors, nil)
-	leases, erredApps, err = FindDeclinedLeases(db, agents)
+	leases, erredApps, err = FindDeclinedLeases(context.Background(), db, agents)
 	require.NoError(t, err)
 	require.Len(t, erredApps, 1)
 	require.Len(t, leases, 1)
@@ -1128,7 +1129,7 @@ func TestFindDeclinedLeasesNoLeaseCmds(t *testing.T) {
 
 	agents := agentcommtest.NewFakeAgents(mockLeasesGetDeclined, nil)
 
-	leases, erredApps, err := FindDeclinedLeases(db, agents)
+	leases, erredApps, err := FindDeclinedLeases(context.Background(), db, agents)
 	require.NoError(t, err)
 	require.Empty(t, erredApps)
 	require.Empty(t, leases)
@@ -1269,7 +1270,7 @@ func TestFindLeasesByHostID(t *testing.T) {
 	// - lease6-get (by prefix) to app2 - returning empty response
 	agents := agentcommtest.NewKeaFakeAgents(mockLeases6GetEmpty, mockLease6GetByPrefix, mockLease4Get, mockLeases6GetEmpty)
 
-	leases, conflicts, erredApps, err := FindLeasesByHostID(db, agents, host.ID)
+	leases, conflicts, erredApps, err := FindLeasesByHostID(context.Background(), db, agents, host.ID)
 	require.NoError(t, err)
 	require.Empty(t, conflicts)
 	require.Empty(t, erredApps)
@@ -1288,7 +1289,7 @@ func TestFindLeasesByHostID(t *testing.T) {
 	// - lease6-get (by prefix) to app2 - returning empty response
 	agents = agentcommtest.NewKeaFakeAgents(mockLease6GetByIPAddress, mockLeases6GetEmpty, mockLeases4GetEmpty, mockLeases6GetEmpty)
 
-	leases, conflicts, erredApps, err = FindLeasesByHostID(db, agents, host.ID)
+	leases, conflicts, erredApps, err = FindLeasesByHostID(context.Background(), db, agents, host.ID)
 	require.NoError(t, err)
 	require.Empty(t, conflicts)
 	require.Empty(t, erredApps)
@@ -1304,7 +1305,7 @@ func TestFindLeasesByHostID(t *testing.T) {
 	// - lease6-get (by prefix) to app2 - returning the lease 2001:db8:0:0:2::
 	agents = agentcommtest.NewKeaFakeAgents(mockLease6GetError, mockLease4Get, mockLease6GetByIPAddress, mockLease6GetByPrefix)
 
-	leases, conflicts, erredApps, err = FindLeasesByHostID(db, agents, host.ID)
+	leases, conflicts, erredApps, err = FindLeasesByHostID(context.Background(), db, agents, host.ID)
 	require.NoError(t, err)
 	require.Empty(t, conflicts)
 	require.Len(t, erredApps, 1)
@@ -1317,7 +1318,7 @@ func TestFindLeasesByHostID(t *testing.T) {
 	// - lease6-get (by address) to app2 - returning an error
 	agents = agentcommtest.NewKeaFakeAgents(mockLease6GetError)
 
-	leases, conflicts, erredApps, err = FindLeasesByHostID(db, agents, host.ID)
+	leases, conflicts, erredApps, err = FindLeasesByHostID(context.Background(), db, agents, host.ID)
 	require.NoError(t, err)
 	require.Empty(t, conflicts)
 	require.Len(t, erredApps, 2)
@@ -47,11 +47,15 @@ func (statsPuller *StatsPuller) Shutdown() {
 // Pull stats periodically for all Kea apps which Stork is monitoring. The function returns
 // last encountered error.
 func (statsPuller *StatsPuller) pullStats() error {
-	// get list of all kea apps from database
+	// get list of kea apps from database which are due for pulling stats
 	dbApps, err := dbmodel.GetAppsByType(statsPuller.DB, dbmodel.AppTypeKea)
 	if err != nil {
 		return err
 	}
+	dbApps, err = statsPuller.SelectAppsToPull(dbApps)
+	if err != nil {
+		return err
+	}
 
 	// get lease stats from each kea app
 	var lastErr error
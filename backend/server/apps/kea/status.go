@@ -241,11 +241,15 @@ func (puller *HAStatusPuller) commitHAServicesStatus(appID int64, services []dbm
 // The High Availability status is stored in the database for those apps which
 // have the HA enabled.
 func (puller *HAStatusPuller) pullData() error {
-	// Get the list of all Kea apps from the database.
+	// Get the Kea apps from the database which are due for pulling the status.
 	apps, err := dbmodel.GetAppsByType(puller.DB, dbmodel.AppTypeKea)
 	if err != nil {
 		return err
 	}
+	apps, err = puller.SelectAppsToPull(apps)
+	if err != nil {
+		return err
+	}
 
 	var lastErr error
 	appsOkCnt := 0
@@ -3,6 +3,7 @@ package apps
 import (
 	"isc.org/stork/server/apps/bind9"
 	"isc.org/stork/server/apps/kea"
+	storkutil "isc.org/stork/util"
 )
 
 // Collection of pullers used by the server.
@@ -13,3 +14,15 @@ type Pullers struct {
 	KeaHostsPuller   *kea.HostsPuller
 	HAStatusPuller   *kea.HAStatusPuller
 }
+
+// Returns the periodic executors of all pullers, e.g. to collect the
+// metrics of their executions.
+func (pullers *Pullers) GetExecutors() []*storkutil.PeriodicExecutor {
+	return []*storkutil.PeriodicExecutor{
+		pullers.AppsStatePuller.PeriodicExecutor,
+		pullers.Bind9StatsPuller.PeriodicExecutor,
+		pullers.KeaStatsPuller.PeriodicExecutor,
+		pullers.KeaHostsPuller.PeriodicExecutor,
+		pullers.HAStatusPuller.PeriodicExecutor,
+	}
+}
@@ -2,6 +2,7 @@ package apps
 
 import (
 	"context"
+	"fmt"
 	"time"
 
 	"github.com/pkg/errors"
@@ -90,6 +91,9 @@ func updateMachineFields(db *dbops.PgDB, dbMachine *dbmodel.Machine, m *agentcom
 	dbMachine.State.VirtualizationSystem = m.VirtualizationSystem
 	dbMachine.State.VirtualizationRole = m.VirtualizationRole
 	dbMachine.State.HostID = m.HostID
+	if !m.AgentCertNotAfter.IsZero() {
+		dbMachine.State.AgentCertExpiresAt = m.AgentCertNotAfter
+	}
 	dbMachine.LastVisitedAt = m.LastVisitedAt
 	dbMachine.Error = m.Error
 	err := dbmodel.UpdateMachine(db, dbMachine)
@@ -100,8 +104,9 @@ func updateMachineFields(db *dbops.PgDB, dbMachine *dbmodel.Machine, m *agentcom
 }
 
 // appCompare compares two apps for equality.  Two apps are considered equal if
-// their type matches and if they have the same control port.  Return true if
-// equal, false otherwise.
+// their type matches and if they have the same control port. The Kea daemons
+// controlled over the control sockets are considered equal if they use the
+// same control socket. Return true if equal, false otherwise.
 func appCompare(dbApp *dbmodel.App, app *agentcomm.App) bool {
 	if dbApp.Type != app.Type {
 		return false
@@ -109,15 +114,20 @@ func appCompare(dbApp *dbmodel.App, app *agentcomm.App) bool {
 
 	var controlPortEqual bool
 	for _, pt1 := range dbApp.AccessPoints {
-		if pt1.Type != dbmodel.AccessPointControl {
+		if pt1.Type != dbmodel.AccessPointControl && pt1.Type != dbmodel.AccessPointControlSocket {
 			continue
 		}
 		for _, pt2 := range app.AccessPoints {
-			if pt2.Type != dbmodel.AccessPointControl {
+			if pt2.Type != pt1.Type {
 				continue
 			}
 
-			if pt1.Port == pt2.Port {
+			if pt1.Type == dbmodel.AccessPointControlSocket && pt1.Address == pt2.Address {
+				controlPortEqual = true
+				break
+			}
+
+			if pt1.Type == dbmodel.AccessPointControl && pt1.Port == pt2.Port {
 				controlPortEqual = true
 				break
 			}
@@ -237,8 +247,16 @@ func GetMachineAndAppsState(ctx context.Context, db *dbops.PgDB, dbMachine *dbmo
 	ctx2, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
+	// The certs may have been revoked by another server instance, so the
+	// list sent to the agent must be up to date.
+	if err := agentcomm.ReloadRevokedCerts(db, agents); err != nil {
+		log.Warnf("problem with reloading revoked certs: %+v", err)
+	}
+
 	// get state of machine from agent
-	state, err := agents.GetState(ctx2, dbMachine.Address, dbMachine.AgentPort)
+	// the agent certificate must be re-issued during the CA rotation
+	renewCert := dbMachine.CertRotationStatus == dbmodel.CertRotationPending
+	state, err := agents.GetState(ctx2, dbMachine.Address, dbMachine.AgentPort, renewCert)
 	if err != nil {
 		log.Warn(err)
 		dbMachine.Error = "cannot get state of machine"
@@ -251,12 +269,35 @@ func GetMachineAndAppsState(ctx context.Context, db *dbops.PgDB, dbMachine *dbmo
 	}
 
 	// store machine's state in db
+	certWasExpiring := dbMachine.IsAgentCertExpiring(time.Now())
 	err = updateMachineFields(db, dbMachine, state)
 	if err != nil {
 		log.Error(err)
 		return "cannot update machine in db"
 	}
 
+	// renew the agent certificate if the agent requested it and retire
+	// the old CA after the CA rotation
+	if len(state.AgentCertRenewalCSR) > 0 {
+		err = renewAgentCert(ctx2, db, dbMachine, agents, eventCenter, state.AgentCertRenewalCSR)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"machine": dbMachine.Address,
+			}).Warnf("cannot renew agent certificate: %+v", err)
+		}
+	} else if dbMachine.CertRotationStatus == dbmodel.CertRotationRetiring {
+		err = retireAgentOldCA(ctx2, db, dbMachine, agents, eventCenter)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"machine": dbMachine.Address,
+			}).Warnf("cannot retire old CA on agent: %+v", err)
+		}
+	}
+	if !certWasExpiring && dbMachine.IsAgentCertExpiring(time.Now()) {
+		eventCenter.AddWarningEvent(fmt.Sprintf("certificate of {machine} expires on %s",
+			dbMachine.State.AgentCertExpiresAt.Format(time.RFC3339)), dbMachine)
+	}
+
 	// take old apps from db and new apps fetched from the machine
 	// and match them and prepare a list of all apps
 	allApps, errStr := mergeNewAndOldApps(db, dbMachine, state.Apps)
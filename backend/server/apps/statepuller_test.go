@@ -161,6 +161,29 @@ func TestAppCompare(t *testing.T) {
 	require.False(t, appCompare(dbApp, app))
 }
 
+// Check appCompare for the Kea daemons controlled over the control sockets.
+func TestAppCompareControlSocket(t *testing.T) {
+	var ap []*dbmodel.AccessPoint
+	dbApp := &dbmodel.App{
+		Type:         dbmodel.AppTypeKea,
+		AccessPoints: dbmodel.AppendAccessPoint(ap, dbmodel.AccessPointControlSocket, "/tmp/kea4-ctrl-socket", "", 0, false),
+	}
+	app := &agentcomm.App{
+		Type:         dbmodel.AppTypeKea,
+		AccessPoints: agentcomm.MakeAccessPoint(dbmodel.AccessPointControlSocket, "/tmp/kea4-ctrl-socket", "", 0),
+	}
+	// the same sockets so equal
+	require.True(t, appCompare(dbApp, app))
+
+	// different sockets so not equal although the ports are the same
+	app.AccessPoints[0].Address = "/tmp/kea6-ctrl-socket"
+	require.False(t, appCompare(dbApp, app))
+
+	// control access point with the same port is not equal to the socket
+	app.AccessPoints = agentcomm.MakeAccessPoint(dbmodel.AccessPointControl, "/tmp/kea4-ctrl-socket", "", 0)
+	require.False(t, appCompare(dbApp, app))
+}
+
 // Test that new configuration review is scheduled when a daemon's
 // configuration has changed or when review dispatcher's checkers
 // have changed.
@@ -101,6 +101,43 @@ func setupRootKeyAndCert(db *pg.DB) (*ecdsa.PrivateKey, *x509.Certificate, []byt
 	return rootKey, rootCert, rootCertPEM, nil
 }
 
+// Generate a server key and a server cert signed by the provided root CA
+// key and cert. The cert is issued for all IP addresses of this host and
+// their DNS names. The key and cert are returned in PEM format.
+func genServerKeyAndCert(db *pg.DB, rootKey *ecdsa.PrivateKey, rootCert *x509.Certificate) ([]byte, []byte, error) {
+	// get list of all host IP addresses that will be put to server cert
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "cannot get interface addresses")
+	}
+	var srvIPs []net.IP
+	var srvNames []string
+	for _, addr := range addrs {
+		ipAddr, _, err := net.ParseCIDR(addr.String())
+		if err != nil {
+			continue
+		}
+		srvIPs = append(srvIPs, ipAddr)
+		names, err := net.LookupAddr(ipAddr.String())
+		if err == nil {
+			srvNames = append(srvNames, names...)
+		}
+	}
+	if len(srvIPs) == 0 || len(srvNames) == 0 {
+		return nil, nil, errors.Errorf("cannot find IP addresses on this host")
+	}
+
+	certSerialNumber, err := dbmodel.GetNewCertSerialNumber(db)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "cannot get new cert S/N")
+	}
+	serverCertPEM, serverKeyPEM, err := pki.GenKeyCert("server", srvNames, srvIPs, certSerialNumber, rootCert, rootKey)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "cannot generate key and cert for server")
+	}
+	return serverKeyPEM, serverCertPEM, nil
+}
+
 // Check if a server key and a server cert are present in db. If not generate them
 // and store in database.
 func setupServerKeyAndCert(db *pg.DB, rootKey *ecdsa.PrivateKey, rootCert *x509.Certificate) ([]byte, []byte, error) {
@@ -116,35 +153,9 @@ func setupServerKeyAndCert(db *pg.DB, rootKey *ecdsa.PrivateKey, rootCert *x509.
 
 	// no server key or no server cert so generate
 	if serverKeyPEM == nil || serverCertPEM == nil {
-		// get list of all host IP addresses that will be put to server cert
-		addrs, err := net.InterfaceAddrs()
-		if err != nil {
-			return nil, nil, errors.Wrapf(err, "cannot get interface addresses")
-		}
-		var srvIPs []net.IP
-		var srvNames []string
-		for _, addr := range addrs {
-			ipAddr, _, err := net.ParseCIDR(addr.String())
-			if err != nil {
-				continue
-			}
-			srvIPs = append(srvIPs, ipAddr)
-			names, err := net.LookupAddr(ipAddr.String())
-			if err == nil {
-				srvNames = append(srvNames, names...)
-			}
-		}
-		if len(srvIPs) == 0 || len(srvNames) == 0 {
-			return nil, nil, errors.Errorf("cannot find IP addresses on this host")
-		}
-
-		certSerialNumber, err := dbmodel.GetNewCertSerialNumber(db)
+		serverKeyPEM, serverCertPEM, err = genServerKeyAndCert(db, rootKey, rootCert)
 		if err != nil {
-			return nil, nil, errors.Wrapf(err, "cannot get new cert S/N")
-		}
-		serverCertPEM, serverKeyPEM, err = pki.GenKeyCert("server", srvNames, srvIPs, certSerialNumber, rootCert, rootKey)
-		if err != nil {
-			return nil, nil, errors.Wrapf(err, "cannot generate key and cert for server")
+			return nil, nil, err
 		}
 		err = dbmodel.SetSecret(db, dbmodel.SecretServerKey, serverKeyPEM)
 		if err != nil {
@@ -177,7 +188,8 @@ func setupServerKeyAndCert(db *pg.DB, rootKey *ecdsa.PrivateKey, rootCert *x509.
 // Check if there are root CA and server keys and certs, and server
 // token in the database.  If they are missing then create them and
 // store in the database. In the end return root CA cert, server key
-// and cert, all in PEM format.
+// and cert, all in PEM format. While the CA rotation is in progress the
+// returned root CA cert is a bundle of the current and the new CA certs.
 func SetupServerCerts(db *pg.DB) ([]byte, []byte, []byte, error) {
 	log.Printf("preparing certs, it may take up to several minutes")
 
@@ -205,7 +217,17 @@ func SetupServerCerts(db *pg.DB) ([]byte, []byte, []byte, error) {
 		}
 	}
 
-	return rootCertPEM, serverCertPEM, serverKeyPEM, nil
+	// trust the new CA alongside the current one while the CA rotation
+	// is in progress
+	trustedCACertsPEM, err := GetTrustedCACerts(db)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if trustedCACertsPEM == nil {
+		trustedCACertsPEM = rootCertPEM
+	}
+
+	return trustedCACertsPEM, serverCertPEM, serverKeyPEM, nil
 }
 
 // Export a secret e.g. certificate or server token to stdout or to indicated file.
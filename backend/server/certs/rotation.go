@@ -0,0 +1,236 @@
+package certs
+
+import (
+	"context"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"isc.org/stork/pki"
+	dbmodel "isc.org/stork/server/database/model"
+)
+
+// The root CA is rotated in two phases. When the rotation is started,
+// the new root CA key and cert and the new server key and cert signed
+// by the new CA are generated and stored alongside the current ones.
+// The server trusts both CAs after the restart and the agent certs are
+// re-issued using the new CA. The agents are told to trust both CAs.
+// When the rotation is finished, the new CA and server key and cert
+// replace the current ones. After the restart the server presents the
+// new server cert and the agents are told to trust the new CA only.
+
+// Status of the CA rotation and the statuses of the machines.
+type CARotationStatus struct {
+	InProgress bool
+	Machines   []dbmodel.Machine
+}
+
+// Checks if the CA rotation has been started and not finished yet.
+func IsCARotationInProgress(db *pg.DB) (bool, error) {
+	nextCACertPEM, err := dbmodel.GetSecret(db, dbmodel.SecretCACertNext)
+	if err != nil {
+		return false, err
+	}
+	return nextCACertPEM != nil, nil
+}
+
+// Returns the CA certs trusted by the server and the agents in PEM
+// format. It is the current CA cert or, while the CA rotation is in
+// progress, the bundle of the current and the new CA certs. It returns
+// nil if the CA cert has not been generated yet.
+func GetTrustedCACerts(db *pg.DB) ([]byte, error) {
+	rootCertPEM, err := dbmodel.GetSecret(db, dbmodel.SecretCACert)
+	if err != nil {
+		return nil, err
+	}
+	nextCACertPEM, err := dbmodel.GetSecret(db, dbmodel.SecretCACertNext)
+	if err != nil {
+		return nil, err
+	}
+	if rootCertPEM == nil || nextCACertPEM == nil {
+		return rootCertPEM, nil
+	}
+	bundle := append([]byte{}, rootCertPEM...)
+	if len(bundle) > 0 && bundle[len(bundle)-1] != '\n' {
+		bundle = append(bundle, '\n')
+	}
+	return append(bundle, nextCACertPEM...), nil
+}
+
+// Returns the CA key and cert which should be used to sign the agent
+// certs. It is the new CA while the rotation is in progress and the
+// current CA otherwise. The returned flag indicates if the rotation
+// is in progress.
+func GetSigningCA(db *pg.DB) ([]byte, []byte, bool, error) {
+	keyPEM, err := dbmodel.GetSecret(db, dbmodel.SecretCAKeyNext)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	certPEM, err := dbmodel.GetSecret(db, dbmodel.SecretCACertNext)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	if keyPEM != nil && certPEM != nil {
+		return keyPEM, certPEM, true, nil
+	}
+	keyPEM, err = dbmodel.GetSecret(db, dbmodel.SecretCAKey)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	certPEM, err = dbmodel.GetSecret(db, dbmodel.SecretCACert)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	return keyPEM, certPEM, false, nil
+}
+
+// Starts the CA rotation. It generates the new root CA key and cert and
+// the new server key and cert signed by the new CA. The agent certs of
+// all machines are marked to be re-issued. The server must be restarted
+// to trust the new CA before the agent certs are re-issued.
+func StartCARotation(db *pg.DB) error {
+	inProgress, err := IsCARotationInProgress(db)
+	if err != nil {
+		return err
+	}
+	if inProgress {
+		return errors.New("CA rotation is already in progress")
+	}
+	rootCertPEM, err := dbmodel.GetSecret(db, dbmodel.SecretCACert)
+	if err != nil {
+		return err
+	}
+	if rootCertPEM == nil {
+		return errors.New("CA cert not found; start the server to generate it first")
+	}
+
+	certSerialNumber, err := dbmodel.GetNewCertSerialNumber(db)
+	if err != nil {
+		return errors.Wrapf(err, "cannot get new cert S/N")
+	}
+	rootKey, rootKeyPEM, rootCert, nextCACertPEM, err := pki.GenCAKeyCert(certSerialNumber)
+	if err != nil {
+		return errors.Wrapf(err, "cannot generate new root CA cert")
+	}
+	serverKeyPEM, serverCertPEM, err := genServerKeyAndCert(db, rootKey, rootCert)
+	if err != nil {
+		return err
+	}
+
+	err = db.RunInTransaction(context.Background(), func(tx *pg.Tx) error {
+		secrets := []struct {
+			name    string
+			content []byte
+		}{
+			{dbmodel.SecretCAKeyNext, rootKeyPEM},
+			{dbmodel.SecretServerKeyNext, serverKeyPEM},
+			{dbmodel.SecretServerCertNext, serverCertPEM},
+			// The new CA cert is stored last because its presence
+			// indicates that the rotation is in progress.
+			{dbmodel.SecretCACertNext, nextCACertPEM},
+		}
+		for _, secret := range secrets {
+			if err := dbmodel.SetSecret(tx, secret.name, secret.content); err != nil {
+				return errors.Wrapf(err, "cannot store new %s in database", secret.name)
+			}
+		}
+		return dbmodel.UpdateMachinesCertRotationStatus(tx, dbmodel.CertRotationPending)
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Info("started CA rotation; restart the server to trust the new CA and re-issue the agent certs")
+	return nil
+}
+
+// Finishes the CA rotation. The new root CA key and cert and the new
+// server key and cert replace the current ones. The rotation is not
+// finished if the agent certs of some authorized machines have not been
+// re-issued yet, unless the force flag is set. Such machines lose the
+// connectivity and must be registered again. The server must be restarted
+// to use the new server cert. Then the agents are told to stop trusting
+// the old CA.
+func FinishCARotation(db *pg.DB, force bool) error {
+	inProgress, err := IsCARotationInProgress(db)
+	if err != nil {
+		return err
+	}
+	if !inProgress {
+		return errors.New("CA rotation is not in progress")
+	}
+
+	authorized := true
+	machines, err := dbmodel.GetAllMachines(db, &authorized)
+	if err != nil {
+		return err
+	}
+	pending := 0
+	for _, m := range machines {
+		if m.CertRotationStatus != dbmodel.CertRotationReissued {
+			pending++
+			log.WithFields(log.Fields{
+				"machine": m.Address,
+				"port":    m.AgentPort,
+			}).Warn("agent cert has not been re-issued using new CA")
+		}
+	}
+	if pending > 0 && !force {
+		return errors.Errorf("agent certs of %d machines have not been re-issued using new CA", pending)
+	}
+
+	renames := []struct {
+		from string
+		to   string
+	}{
+		{dbmodel.SecretCAKeyNext, dbmodel.SecretCAKey},
+		{dbmodel.SecretCACertNext, dbmodel.SecretCACert},
+		{dbmodel.SecretServerKeyNext, dbmodel.SecretServerKey},
+		{dbmodel.SecretServerCertNext, dbmodel.SecretServerCert},
+	}
+	err = db.RunInTransaction(context.Background(), func(tx *pg.Tx) error {
+		for _, rename := range renames {
+			content, err := dbmodel.GetSecret(tx, rename.from)
+			if err != nil {
+				return err
+			}
+			if content == nil {
+				return errors.Errorf("new %s not found in database", rename.from)
+			}
+			if err = dbmodel.SetSecret(tx, rename.to, content); err != nil {
+				return errors.Wrapf(err, "cannot store %s in database", rename.to)
+			}
+			if err = dbmodel.DeleteSecret(tx, rename.from); err != nil {
+				return err
+			}
+		}
+		err := dbmodel.UpdateMachinesCertRotationStatus(tx, dbmodel.CertRotationRetiring, dbmodel.CertRotationReissued)
+		if err != nil {
+			return err
+		}
+		return dbmodel.UpdateMachinesCertRotationStatus(tx, dbmodel.CertRotationFailed, dbmodel.CertRotationPending)
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Info("finished CA rotation; restart the server to use the new server cert and retire the old CA")
+	return nil
+}
+
+// Returns the status of the CA rotation and the statuses of all machines.
+func GetCARotationStatus(db *pg.DB) (*CARotationStatus, error) {
+	inProgress, err := IsCARotationInProgress(db)
+	if err != nil {
+		return nil, err
+	}
+	machines, err := dbmodel.GetAllMachines(db, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &CARotationStatus{
+		InProgress: inProgress,
+		Machines:   machines,
+	}, nil
+}
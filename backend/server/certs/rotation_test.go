@@ -0,0 +1,122 @@
+package certs
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/stretchr/testify/require"
+
+	"isc.org/stork/pki"
+	dbmodel "isc.org/stork/server/database/model"
+	dbtest "isc.org/stork/server/database/test"
+)
+
+// Adds an authorized machine with the given address.
+func addRotationTestMachine(t *testing.T, db *pg.DB, address string) *dbmodel.Machine {
+	m := &dbmodel.Machine{
+		Address:    address,
+		AgentPort:  8080,
+		Authorized: true,
+	}
+	require.NoError(t, dbmodel.AddMachine(db, m))
+	return m
+}
+
+// Test the complete CA rotation.
+func TestCARotation(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode.")
+	}
+
+	db, _, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	// The rotation cannot be started before the CA is generated.
+	require.Error(t, StartCARotation(db))
+
+	rootCertPEM, serverCertPEM, _, err := SetupServerCerts(db)
+	require.NoError(t, err)
+	m := addRotationTestMachine(t, db, "192.0.2.1")
+
+	// Start the rotation.
+	require.NoError(t, StartCARotation(db))
+	require.Error(t, StartCARotation(db))
+
+	inProgress, err := IsCARotationInProgress(db)
+	require.NoError(t, err)
+	require.True(t, inProgress)
+
+	// The server trusts both CAs but still uses the current server cert.
+	trustedCACertsPEM, serverCertPEM2, _, err := SetupServerCerts(db)
+	require.NoError(t, err)
+	require.True(t, bytes.HasPrefix(trustedCACertsPEM, rootCertPEM))
+	require.Greater(t, len(trustedCACertsPEM), len(rootCertPEM))
+	require.Equal(t, serverCertPEM, serverCertPEM2)
+
+	// The agent certs are signed by the new CA.
+	_, nextCACertPEM, rotating, err := GetSigningCA(db)
+	require.NoError(t, err)
+	require.True(t, rotating)
+	require.NotEqual(t, rootCertPEM, nextCACertPEM)
+	require.True(t, bytes.HasSuffix(trustedCACertsPEM, nextCACertPEM))
+
+	status, err := GetCARotationStatus(db)
+	require.NoError(t, err)
+	require.True(t, status.InProgress)
+	require.Len(t, status.Machines, 1)
+	require.Equal(t, dbmodel.CertRotationPending, status.Machines[0].CertRotationStatus)
+
+	// The rotation cannot be finished until the agent cert is re-issued.
+	require.Error(t, FinishCARotation(db, false))
+
+	m.CertRotationStatus = dbmodel.CertRotationReissued
+	require.NoError(t, dbmodel.UpdateMachine(db, m))
+	require.NoError(t, FinishCARotation(db, false))
+	require.Error(t, FinishCARotation(db, false))
+
+	// The new CA and server cert replace the current ones.
+	rootCertPEM3, serverCertPEM3, _, err := SetupServerCerts(db)
+	require.NoError(t, err)
+	require.Equal(t, nextCACertPEM, rootCertPEM3)
+	serverCert, err := pki.ParseCert(serverCertPEM3)
+	require.NoError(t, err)
+	rootCert, err := pki.ParseCert(rootCertPEM3)
+	require.NoError(t, err)
+	require.NoError(t, serverCert.CheckSignatureFrom(rootCert))
+
+	_, signingCACertPEM, rotating, err := GetSigningCA(db)
+	require.NoError(t, err)
+	require.False(t, rotating)
+	require.Equal(t, nextCACertPEM, signingCACertPEM)
+
+	status, err = GetCARotationStatus(db)
+	require.NoError(t, err)
+	require.False(t, status.InProgress)
+	require.Equal(t, dbmodel.CertRotationRetiring, status.Machines[0].CertRotationStatus)
+}
+
+// Test that the CA rotation can be forcibly finished when the agent certs
+// have not been re-issued and that such machines are marked as failed.
+func TestCARotationForce(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode.")
+	}
+
+	db, _, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	_, _, _, err := SetupServerCerts(db)
+	require.NoError(t, err)
+	addRotationTestMachine(t, db, "192.0.2.1")
+
+	require.Error(t, FinishCARotation(db, true))
+	require.NoError(t, StartCARotation(db))
+	require.NoError(t, FinishCARotation(db, true))
+
+	status, err := GetCARotationStatus(db)
+	require.NoError(t, err)
+	require.False(t, status.InProgress)
+	require.Len(t, status.Machines, 1)
+	require.Equal(t, dbmodel.CertRotationFailed, status.Machines[0].CertRotationStatus)
+}
@@ -49,14 +49,24 @@ const (
 	// Config review is triggered as a result of the hosts modifications
 	// in the host database.
 	DBHostsModified Trigger = "host reservations change"
+	// Config review is triggered periodically according to the
+	// config_review_interval setting.
+	PeriodicRun Trigger = "periodic"
 )
 
+// Maximum number of the configuration review runs held in the review
+// history for a daemon. The oldest runs are deleted when new runs are
+// added. Note that it does not affect the config reports which are
+// held as long as the daemon exists.
+const maxReviewRunsPerDaemon = 100
+
 // Returns default config review triggers. They are by default used by
 // all configuration checkers:
 // - ManualRun
-// - ConfigModified.
+// - ConfigModified
+// - PeriodicRun.
 func GetDefaultTriggers() Triggers {
-	return Triggers{ManualRun, ConfigModified}
+	return Triggers{ManualRun, ConfigModified, PeriodicRun}
 }
 
 // Convenience function returning a combination of default triggers and
@@ -204,9 +214,12 @@ func (g *dispatchGroup) hasCheckersForTrigger(trigger Trigger) bool {
 // list when no issues are found. A caller schedules a review by calling
 // the BeginReview function. It schedules the review and returns
 // immediately. The dispatcher runs the configuration through the
-// registered checkers in the background. The dispatcher inserts the
-// reports into the database when the review finishes, replacing any
-// existing reports for the daemon. More advanced checkers can look
+// registered checkers in the background. The dispatcher merges the
+// reports with the reports already stored in the database when the
+// review finishes. The reports are matched by their fingerprints.
+// The stored reports which are no longer generated are marked
+// resolved. Each review is also recorded as a run in the review
+// history of the daemon. More advanced checkers can look
 // into more than one daemon's configuration (e.g., to verify the
 // consistency of the HA configuration between two partners).
 type dispatcherImpl struct {
@@ -495,27 +508,19 @@ func (d *dispatcherImpl) populateReports(ctx *ReviewContext) (err error) {
 		}
 	}
 
-	if ctx.trigger != internalRun {
-		// Delete configuration reports for all daemons involved in our review.
-		// It includes the reports for daemons only referenced in the review
-		// (e.g., a HA peer's configuration). For those daemons, we will have to
-		// run configuration reviews internally (at the end of this function) to
-		// ensure they have up-to-date reports. Note that we delete the reports
-		// for the referenced daemons because configuration change of the subject
-		// daemon can also affect reviews for the referenced daemons.
-		for i, daemon := range daemons {
-			// A subject daemon can appear twice in this slice. Let's ensure
-			// we delete the config reports for this daemon only once.
-			if i == 0 || daemon.ID != ctx.subjectDaemon.ID {
-				err = dbmodel.DeleteConfigReportsByDaemonID(tx, daemon.ID)
-				if err != nil {
-					return
-				}
-			}
-		}
-	}
-
-	// Add configuration reports.
+	// All reports and the review run share the same timestamp. It allows
+	// for finding the reports generated during the particular run.
+	reviewedAt := time.Now().UTC()
+
+	// Merge the new configuration reports with the reports stored in the
+	// database. The reports which are no longer generated are marked
+	// resolved. The reports for the daemons only referenced in the review
+	// (e.g., a HA peer's configuration) are not modified here. A
+	// configuration change of the subject daemon can also affect reviews
+	// for the referenced daemons. Therefore, we run configuration reviews
+	// internally (at the end of this function) for the referenced daemons
+	// to ensure they have up-to-date reports.
+	var configReports []*dbmodel.ConfigReport
 	for _, r := range ctx.reports {
 		var assoc []*dbmodel.Daemon
 		for _, id := range r.report.refDaemonIDs {
@@ -523,16 +528,17 @@ func (d *dispatcherImpl) populateReports(ctx *ReviewContext) (err error) {
 				ID: id,
 			})
 		}
-		cr := &dbmodel.ConfigReport{
+		configReports = append(configReports, &dbmodel.ConfigReport{
 			CheckerName: r.checkerName,
 			Content:     r.report.content,
+			Fingerprint: r.report.getFingerprint(r.checkerName),
 			DaemonID:    r.report.daemonID,
 			RefDaemons:  assoc,
-		}
-		err = dbmodel.AddConfigReport(tx, cr)
-		if err != nil {
-			return
-		}
+		})
+	}
+	reportsCount, err := dbmodel.MergeConfigReports(tx, ctx.subjectDaemon.ID, reviewedAt, configReports)
+	if err != nil {
+		return
 	}
 
 	// Add configuration review summary.
@@ -548,6 +554,24 @@ func (d *dispatcherImpl) populateReports(ctx *ReviewContext) (err error) {
 		if err != nil {
 			return
 		}
+
+		// Record the review in the history.
+		run := &dbmodel.ConfigReviewRun{
+			CreatedAt:    reviewedAt,
+			Trigger:      string(ctx.trigger),
+			ConfigHash:   configReview.ConfigHash,
+			Signature:    configReview.Signature,
+			ReportsCount: reportsCount,
+			DaemonID:     ctx.subjectDaemon.ID,
+		}
+		err = dbmodel.AddConfigReviewRun(tx, run)
+		if err != nil {
+			return
+		}
+		_, err = dbmodel.DeleteOldConfigReviewRuns(tx, ctx.subjectDaemon.ID, maxReviewRunsPerDaemon)
+		if err != nil {
+			return
+		}
 	}
 
 	err = tx.Commit()
@@ -556,9 +580,8 @@ func (d *dispatcherImpl) populateReports(ctx *ReviewContext) (err error) {
 	}
 
 	if ctx.trigger != internalRun {
-		// If the review was scheduled externally, and we deleted configuration
-		// reports for referenced daemons, we have to rebuild the reports for
-		// those daemons. Let's schedule internal reviews for each of them.
+		// If the review was scheduled externally, we have to rebuild the
+		// reports for the referenced daemons. Let's schedule internal reviews for each of them.
 		for i := range ctx.refDaemons {
 			// Do not schedule the review for the subject daemon because we're
 			// now doing its review.
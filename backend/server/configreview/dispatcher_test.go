@@ -228,6 +228,15 @@ func TestPopulateKeaReports(t *testing.T) {
 	require.NotEmpty(t, review.ConfigHash)
 	require.NotEmpty(t, review.Signature)
 
+	// The review should be recorded in the history.
+	runs, total, err := dbmodel.GetConfigReviewRunsByDaemonID(db, 0, 0, daemons[0].ID)
+	require.NoError(t, err)
+	require.EqualValues(t, 1, total)
+	require.Len(t, runs, 1)
+	require.Equal(t, string(ConfigModified), runs[0].Trigger)
+	require.Equal(t, "1234", runs[0].ConfigHash)
+	require.EqualValues(t, 1, runs[0].ReportsCount)
+
 	// Ensure that the reports for the second daemon have not been inserted.
 	reports, total, err = dbmodel.GetConfigReportsByDaemonID(db, 0, 0, daemons[1].ID)
 	require.NoError(t, err)
@@ -239,6 +248,144 @@ func TestPopulateKeaReports(t *testing.T) {
 	require.Nil(t, review)
 }
 
+// Tests that the reports generated during the subsequent reviews are
+// matched by fingerprints, and the reports that are no longer generated
+// are marked resolved.
+func TestPopulateReportsHistory(t *testing.T) {
+	db, _, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	// Add a machine.
+	machine := &dbmodel.Machine{
+		Address:   "localhost",
+		AgentPort: 8080,
+	}
+	err := dbmodel.AddMachine(db, machine)
+	require.NoError(t, err)
+
+	config, err := dbmodel.NewKeaConfigFromJSON(`{"Dhcp4": { }}`)
+	require.NoError(t, err)
+
+	// Add an app with one daemon into the database.
+	app := &dbmodel.App{
+		Type:      dbmodel.AppTypeKea,
+		MachineID: machine.ID,
+		Daemons: []*dbmodel.Daemon{
+			{
+				Name:   "dhcp4",
+				Active: true,
+				KeaDaemon: &dbmodel.KeaDaemon{
+					Config:     config,
+					ConfigHash: "1234",
+				},
+			},
+		},
+	}
+	daemons, err := dbmodel.AddApp(db, app)
+	require.NoError(t, err)
+	require.Len(t, daemons, 1)
+
+	dispatcher := NewDispatcher(db)
+	require.NotNil(t, dispatcher)
+
+	// The checker produces the report only when this flag is set.
+	issuePresent := true
+	dispatcher.RegisterChecker(KeaDHCPv4Daemon, "dhcp4_test_checker", GetDefaultTriggers(), func(ctx *ReviewContext) (*Report, error) {
+		if !issuePresent {
+			return nil, nil
+		}
+		return NewReport(ctx, "DHCPv4 test output").create()
+	})
+
+	dispatcher.Start()
+	defer dispatcher.Shutdown()
+
+	// Runs the review and waits for its completion.
+	review := func(trigger Trigger) {
+		wg := &sync.WaitGroup{}
+		wg.Add(1)
+		ok := dispatcher.BeginReview(daemons[0], trigger, func(daemonID int64, err error) {
+			defer wg.Done()
+			require.NoError(t, err)
+		})
+		require.True(t, ok)
+		wg.Wait()
+	}
+
+	// The first two reviews find the same issue.
+	review(ConfigModified)
+	review(PeriodicRun)
+
+	reports, total, err := dbmodel.GetConfigReportsByDaemonID(db, 0, 0, daemons[0].ID)
+	require.NoError(t, err)
+	require.EqualValues(t, 1, total)
+	require.Len(t, reports, 1)
+	require.NotEmpty(t, reports[0].Fingerprint)
+	require.True(t, reports[0].LastSeenAt.After(reports[0].CreatedAt))
+	fingerprint := reports[0].Fingerprint
+
+	// The issue is gone.
+	issuePresent = false
+	review(PeriodicRun)
+
+	reports, total, err = dbmodel.GetConfigReportsByDaemonID(db, 0, 0, daemons[0].ID)
+	require.NoError(t, err)
+	require.Zero(t, total)
+	require.Empty(t, reports)
+
+	reports, total, err = dbmodel.GetConfigReportHistoryByDaemonID(db, 0, 0, daemons[0].ID)
+	require.NoError(t, err)
+	require.EqualValues(t, 1, total)
+	require.Len(t, reports, 1)
+	require.Equal(t, fingerprint, reports[0].Fingerprint)
+	require.False(t, reports[0].ResolvedAt.IsZero())
+
+	// All reviews should be recorded in the history.
+	runs, total, err := dbmodel.GetConfigReviewRunsByDaemonID(db, 0, 0, daemons[0].ID)
+	require.NoError(t, err)
+	require.EqualValues(t, 3, total)
+	require.Len(t, runs, 3)
+	require.Equal(t, string(PeriodicRun), runs[0].Trigger)
+	require.Zero(t, runs[0].ReportsCount)
+	require.Equal(t, string(PeriodicRun), runs[1].Trigger)
+	require.EqualValues(t, 1, runs[1].ReportsCount)
+	require.Equal(t, string(ConfigModified), runs[2].Trigger)
+	require.EqualValues(t, 1, runs[2].ReportsCount)
+
+	// The report should be associated with the first two runs.
+	reports, err = dbmodel.GetConfigReportsByReviewRun(db, &runs[2])
+	require.NoError(t, err)
+	require.Len(t, reports, 1)
+	reports, err = dbmodel.GetConfigReportsByReviewRun(db, &runs[1])
+	require.NoError(t, err)
+	require.Len(t, reports, 1)
+	reports, err = dbmodel.GetConfigReportsByReviewRun(db, &runs[0])
+	require.NoError(t, err)
+	require.Empty(t, reports)
+}
+
+// Tests that the fingerprint of the report depends on the checker name,
+// the report contents and the referenced daemons.
+func TestReportFingerprint(t *testing.T) {
+	report := &Report{
+		content:      "issue",
+		daemonID:     1,
+		refDaemonIDs: []int64{1, 2},
+	}
+	fingerprint := report.getFingerprint("checker")
+	require.NotEmpty(t, fingerprint)
+	require.Equal(t, fingerprint, report.getFingerprint("checker"))
+	require.NotEqual(t, fingerprint, report.getFingerprint("other_checker"))
+
+	otherReport := *report
+	otherReport.content = "other issue"
+	require.NotEqual(t, fingerprint, otherReport.getFingerprint("checker"))
+
+	otherReport = *report
+	otherReport.refDaemonIDs = []int64{2, 1}
+	require.NotEqual(t, fingerprint, otherReport.getFingerprint("checker"))
+}
+
 // Tests that the configuration reviews for the BIND9 daemon are populated
 // into the database.
 func TestPopulateBind9Reports(t *testing.T) {
@@ -659,9 +806,11 @@ func TestRegisterDefaultCheckers(t *testing.T) {
 	require.Contains(t, dispatcher.groups[KeaDHCPDaemon].triggerRefCounts, ManualRun)
 	require.Contains(t, dispatcher.groups[KeaDHCPDaemon].triggerRefCounts, ConfigModified)
 	require.Contains(t, dispatcher.groups[KeaDHCPDaemon].triggerRefCounts, DBHostsModified)
+	require.Contains(t, dispatcher.groups[KeaDHCPDaemon].triggerRefCounts, PeriodicRun)
 
 	require.EqualValues(t, 5, dispatcher.groups[KeaDHCPDaemon].triggerRefCounts[ManualRun])
 	require.EqualValues(t, 5, dispatcher.groups[KeaDHCPDaemon].triggerRefCounts[ConfigModified])
+	require.EqualValues(t, 5, dispatcher.groups[KeaDHCPDaemon].triggerRefCounts[PeriodicRun])
 	require.EqualValues(t, 2, dispatcher.groups[KeaDHCPDaemon].triggerRefCounts[DBHostsModified])
 }
 
@@ -0,0 +1,79 @@
+package configreview
+
+import (
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	dbops "isc.org/stork/server/database"
+	dbmodel "isc.org/stork/server/database/model"
+	storkutil "isc.org/stork/util"
+)
+
+// Name of the setting holding the interval between the periodic
+// configuration reviews.
+const periodicReviewIntervalSettingName = "config_review_interval"
+
+// Periodic reviewer schedules configuration reviews for all Kea
+// daemons at the interval specified in the config_review_interval
+// setting. The periodic reviews are useful to detect the issues
+// caused by the changes in the data used by the checkers that are
+// not signalled to the dispatcher by other triggers. They also
+// populate the review history of the daemons with confirmations
+// that the reported issues are still present. The periodic reviews
+// are disabled when the interval is set to 0.
+type PeriodicReviewer struct {
+	*storkutil.PeriodicExecutor
+	db         *dbops.PgDB
+	dispatcher Dispatcher
+}
+
+// Creates an instance of the periodic reviewer and starts scheduling
+// the configuration reviews.
+func NewPeriodicReviewer(db *dbops.PgDB, dispatcher Dispatcher) (*PeriodicReviewer, error) {
+	reviewer := &PeriodicReviewer{
+		db:         db,
+		dispatcher: dispatcher,
+	}
+	executor, err := storkutil.NewPeriodicExecutor("periodic config reviewer",
+		reviewer.beginReviews,
+		func() (int64, error) {
+			interval, err := dbmodel.GetSettingInt(db, periodicReviewIntervalSettingName)
+			return interval, errors.WithMessagef(err, "problem with getting interval setting %s from db",
+				periodicReviewIntervalSettingName)
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	reviewer.PeriodicExecutor = executor
+	return reviewer, nil
+}
+
+// Stops scheduling the periodic reviews.
+func (reviewer *PeriodicReviewer) Shutdown() {
+	reviewer.PeriodicExecutor.Shutdown()
+}
+
+// Schedules the configuration reviews for all Kea daemons having
+// configurations. The reviews are performed in the background by
+// the dispatcher.
+func (reviewer *PeriodicReviewer) beginReviews() error {
+	apps, err := dbmodel.GetAppsByType(reviewer.db, dbmodel.AppTypeKea)
+	if err != nil {
+		return err
+	}
+	scheduledCount := 0
+	for i := range apps {
+		for _, daemon := range apps[i].Daemons {
+			if daemon.KeaDaemon == nil || daemon.KeaDaemon.Config == nil {
+				continue
+			}
+			if reviewer.dispatcher.BeginReview(daemon, PeriodicRun, nil) {
+				scheduledCount++
+			}
+		}
+	}
+	log.WithFields(log.Fields{
+		"scheduled_count": scheduledCount,
+	}).Info("scheduled periodic configuration reviews")
+	return nil
+}
@@ -0,0 +1,81 @@
+package configreview
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	dbmodel "isc.org/stork/server/database/model"
+	dbtest "isc.org/stork/server/database/test"
+	storkutil "isc.org/stork/util"
+)
+
+// Fake dispatcher recording the daemons for which the reviews were
+// scheduled.
+type fakePeriodicDispatcher struct {
+	Dispatcher
+	daemonIDs []int64
+	triggers  []Trigger
+}
+
+// Records the review request.
+func (d *fakePeriodicDispatcher) BeginReview(daemon *dbmodel.Daemon, trigger Trigger, callback CallbackFunc) bool {
+	d.daemonIDs = append(d.daemonIDs, daemon.ID)
+	d.triggers = append(d.triggers, trigger)
+	return true
+}
+
+// Test that the periodic reviewer schedules the reviews for the
+// Kea daemons having configurations.
+func TestPeriodicReviewerBeginReviews(t *testing.T) {
+	db, _, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	err := dbmodel.InitializeSettings(db)
+	require.NoError(t, err)
+
+	machine := &dbmodel.Machine{
+		Address:   "localhost",
+		AgentPort: 8080,
+	}
+	err = dbmodel.AddMachine(db, machine)
+	require.NoError(t, err)
+
+	config, err := dbmodel.NewKeaConfigFromJSON(`{"Dhcp4": { }}`)
+	require.NoError(t, err)
+
+	// The second daemon lacks the configuration, so it should not be
+	// reviewed.
+	app := &dbmodel.App{
+		Type:      dbmodel.AppTypeKea,
+		MachineID: machine.ID,
+		Daemons: []*dbmodel.Daemon{
+			{
+				Name:   "dhcp4",
+				Active: true,
+				KeaDaemon: &dbmodel.KeaDaemon{
+					Config:     config,
+					ConfigHash: "1234",
+				},
+			},
+			dbmodel.NewKeaDaemon("dhcp6", false),
+		},
+	}
+	daemons, err := dbmodel.AddApp(db, app)
+	require.NoError(t, err)
+	require.Len(t, daemons, 2)
+
+	dispatcher := &fakePeriodicDispatcher{}
+	reviewer, err := NewPeriodicReviewer(db, dispatcher)
+	require.NoError(t, err)
+	require.NotNil(t, reviewer)
+	defer reviewer.Shutdown()
+
+	// The periodic reviews are disabled by default.
+	require.EqualValues(t, storkutil.InactiveInterval, reviewer.GetInterval())
+
+	err = reviewer.beginReviews()
+	require.NoError(t, err)
+	require.Len(t, dispatcher.daemonIDs, 1)
+	require.Equal(t, daemons[0].ID, dispatcher.daemonIDs[0])
+	require.Equal(t, PeriodicRun, dispatcher.triggers[0])
+}
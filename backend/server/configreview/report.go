@@ -1,10 +1,12 @@
 package configreview
 
 import (
+	"fmt"
 	"strings"
 
 	pkgerrors "github.com/pkg/errors"
 	dbmodel "isc.org/stork/server/database/model"
+	storkutil "isc.org/stork/util"
 )
 
 // Represents a single config review report. It contains a description
@@ -89,3 +91,12 @@ func (r *IntermediateReport) create() (*Report, error) {
 	}
 	return rc, nil
 }
+
+// Returns the report fingerprint. The fingerprint is a hash of the
+// checker name, the report contents and the referenced daemons. It is
+// stable across the reviews, i.e. the same issue found during different
+// reviews has the same fingerprint. It is used to match the reports
+// generated during the subsequent reviews of the daemon's configuration.
+func (r *Report) getFingerprint(checkerName string) string {
+	return storkutil.Fnv128(fmt.Sprintf("%s:%d:%s:%v", checkerName, r.daemonID, r.content, r.refDaemonIDs))
+}
@@ -0,0 +1,63 @@
+package dbmigs
+
+import (
+	"github.com/go-pg/migrations/v8"
+)
+
+func init() {
+	migrations.MustRegisterTx(func(db migrations.DB) error {
+		_, err := db.Exec(`
+            -- Config reports are no longer deleted when a new review is
+            -- performed. They are marked resolved instead. The fingerprint
+            -- is used to match the reports generated during different
+            -- reviews and pointing to the same issue.
+            ALTER TABLE config_report
+                ADD COLUMN fingerprint TEXT NOT NULL DEFAULT '',
+                ADD COLUMN last_seen_at TIMESTAMP WITHOUT TIME ZONE NOT NULL DEFAULT timezone('utc'::text, now()),
+                ADD COLUMN resolved_at TIMESTAMP WITHOUT TIME ZONE;
+
+            -- The existing reports lack the fingerprints, so they can't be
+            -- matched with the reports from the subsequent reviews. Mark
+            -- them resolved. The next review creates them again if the
+            -- issues still exist.
+            UPDATE config_report
+                SET last_seen_at = created_at,
+                    resolved_at = timezone('utc'::text, now());
+
+            -- There can be at most one unresolved report with a given
+            -- fingerprint for a daemon.
+            CREATE UNIQUE INDEX config_report_daemon_id_fingerprint_unresolved_idx
+                ON config_report (daemon_id, fingerprint)
+                WHERE resolved_at IS NULL AND fingerprint <> '';
+
+            -- Create a table holding the history of the configuration reviews.
+            CREATE TABLE IF NOT EXISTS config_review_run (
+                id BIGSERIAL PRIMARY KEY,
+                daemon_id BIGINT NOT NULL,
+                created_at TIMESTAMP WITHOUT TIME ZONE NOT NULL DEFAULT timezone('utc'::text, now()),
+                trigger TEXT NOT NULL,
+                config_hash TEXT NOT NULL,
+                signature TEXT NOT NULL,
+                reports_count BIGINT NOT NULL DEFAULT 0,
+                CONSTRAINT config_review_run_daemon_id FOREIGN KEY (daemon_id)
+                    REFERENCES daemon (id)
+                    ON UPDATE CASCADE
+                    ON DELETE CASCADE
+            );
+
+            CREATE INDEX config_review_run_daemon_id_idx ON config_review_run (daemon_id);
+        `)
+		return err
+	}, func(db migrations.DB) error {
+		_, err := db.Exec(`
+            DROP TABLE IF EXISTS config_review_run;
+            DELETE FROM config_report WHERE resolved_at IS NOT NULL;
+            DROP INDEX IF EXISTS config_report_daemon_id_fingerprint_unresolved_idx;
+            ALTER TABLE config_report
+                DROP COLUMN IF EXISTS fingerprint,
+                DROP COLUMN IF EXISTS last_seen_at,
+                DROP COLUMN IF EXISTS resolved_at;
+        `)
+		return err
+	})
+}
@@ -0,0 +1,40 @@
+package dbmigs
+
+import (
+	"github.com/go-pg/migrations/v8"
+)
+
+func init() {
+	migrations.MustRegisterTx(func(db migrations.DB) error {
+		_, err := db.Exec(`
+            -- Create a table holding the subsequent versions of the Kea
+            -- daemons' configurations. A new version is added whenever
+            -- a configuration change is detected.
+            CREATE TABLE IF NOT EXISTS kea_config_version (
+                id BIGSERIAL PRIMARY KEY,
+                daemon_id BIGINT NOT NULL,
+                created_at TIMESTAMP WITHOUT TIME ZONE NOT NULL DEFAULT timezone('utc'::text, now()),
+                config_hash TEXT NOT NULL,
+                config JSONB NOT NULL,
+                CONSTRAINT kea_config_version_daemon_id FOREIGN KEY (daemon_id)
+                    REFERENCES daemon (id)
+                    ON UPDATE CASCADE
+                    ON DELETE CASCADE
+            );
+
+            CREATE INDEX kea_config_version_daemon_id_idx ON kea_config_version (daemon_id);
+
+            -- The current configurations are the first versions.
+            INSERT INTO kea_config_version (daemon_id, config_hash, config)
+                SELECT daemon_id, config_hash, config FROM kea_daemon
+                    WHERE config IS NOT NULL AND config <> 'null'::jsonb
+                        AND config_hash IS NOT NULL AND config_hash <> '';
+        `)
+		return err
+	}, func(db migrations.DB) error {
+		_, err := db.Exec(`
+            DROP TABLE IF EXISTS kea_config_version;
+        `)
+		return err
+	})
+}
@@ -0,0 +1,72 @@
+package dbmigs
+
+import (
+	"github.com/go-pg/migrations/v8"
+)
+
+func init() {
+	migrations.MustRegisterTx(func(db migrations.DB) error {
+		_, err := db.Exec(`
+            -- Create a table holding the DHCP options extracted from the
+            -- Kea daemons' configurations. The scope designates the
+            -- configuration level at which the option is specified.
+            CREATE TABLE IF NOT EXISTS kea_option_data (
+                id BIGSERIAL PRIMARY KEY,
+                daemon_id BIGINT NOT NULL,
+                scope TEXT NOT NULL,
+                client_class TEXT,
+                shared_network TEXT,
+                subnet_prefix TEXT,
+                local_subnet_id BIGINT,
+                pool TEXT,
+                host_identifier TEXT,
+                space TEXT NOT NULL,
+                code INTEGER,
+                name TEXT,
+                data TEXT,
+                csv_format BOOLEAN,
+                always_send BOOLEAN NOT NULL DEFAULT FALSE,
+                CONSTRAINT kea_option_data_daemon_id FOREIGN KEY (daemon_id)
+                    REFERENCES daemon (id)
+                    ON UPDATE CASCADE
+                    ON DELETE CASCADE
+            );
+
+            CREATE INDEX kea_option_data_daemon_id_idx ON kea_option_data (daemon_id);
+            CREATE INDEX kea_option_data_code_idx ON kea_option_data (code);
+
+            -- Create a table holding the option definitions extracted
+            -- from the Kea daemons' configurations.
+            CREATE TABLE IF NOT EXISTS kea_option_def (
+                id BIGSERIAL PRIMARY KEY,
+                daemon_id BIGINT NOT NULL,
+                scope TEXT NOT NULL,
+                client_class TEXT,
+                space TEXT NOT NULL,
+                code INTEGER NOT NULL,
+                name TEXT NOT NULL,
+                type TEXT NOT NULL,
+                is_array BOOLEAN NOT NULL DEFAULT FALSE,
+                record_types TEXT,
+                encapsulate TEXT,
+                CONSTRAINT kea_option_def_daemon_id FOREIGN KEY (daemon_id)
+                    REFERENCES daemon (id)
+                    ON UPDATE CASCADE
+                    ON DELETE CASCADE
+            );
+
+            CREATE INDEX kea_option_def_daemon_id_idx ON kea_option_def (daemon_id);
+
+            -- Force fetching the configurations from the Kea daemons so
+            -- the options are extracted from them.
+            UPDATE kea_daemon SET config_hash = NULL;
+        `)
+		return err
+	}, func(db migrations.DB) error {
+		_, err := db.Exec(`
+            DROP TABLE IF EXISTS kea_option_def;
+            DROP TABLE IF EXISTS kea_option_data;
+        `)
+		return err
+	})
+}
@@ -0,0 +1,53 @@
+package dbmigs
+
+import (
+	"github.com/go-pg/migrations/v8"
+)
+
+// This migration is not run in a transaction because new enum
+// values cannot be added within a transaction block in older
+// PostgreSQL versions. For the same reason, the new enum value
+// is added in a separate statement.
+func init() {
+	migrations.MustRegister(func(db migrations.DB) error {
+		_, err := db.Exec(`
+            -- Kea daemons can be controlled directly over their UNIX
+            -- domain control sockets rather than via the Kea Control Agent.
+            ALTER TYPE ACCESSPOINTTYPE ADD VALUE IF NOT EXISTS 'control-socket';
+        `)
+		if err != nil {
+			return err
+		}
+		_, err = db.Exec(`
+            -- The control socket access points have no port. The address
+            -- holds the path to the socket, so it is used to tell these
+            -- access points apart. The other access points must still
+            -- have unique ports on the machine.
+            ALTER TABLE access_point DROP CONSTRAINT IF EXISTS access_point_unique_idx;
+            CREATE UNIQUE INDEX access_point_unique_idx ON access_point (machine_id, port)
+                WHERE type != 'control-socket';
+            CREATE UNIQUE INDEX access_point_control_socket_unique_idx ON access_point (machine_id, address)
+                WHERE type = 'control-socket';
+        `)
+		return err
+	}, func(db migrations.DB) error {
+		_, err := db.Exec(`
+            -- Remove the apps controlled over the control sockets. Their
+            -- access points are removed by cascade.
+            DELETE FROM app WHERE id IN (
+                SELECT app_id FROM access_point WHERE type = 'control-socket'
+            );
+
+            DROP INDEX IF EXISTS access_point_control_socket_unique_idx;
+            DROP INDEX IF EXISTS access_point_unique_idx;
+            ALTER TABLE access_point ADD CONSTRAINT access_point_unique_idx UNIQUE (machine_id, port);
+
+            -- Enum values cannot be removed, so the type is recreated.
+            ALTER TABLE access_point ALTER COLUMN type TYPE TEXT;
+            DROP TYPE IF EXISTS ACCESSPOINTTYPE;
+            CREATE TYPE ACCESSPOINTTYPE AS ENUM ('control', 'statistics');
+            ALTER TABLE access_point ALTER COLUMN type TYPE ACCESSPOINTTYPE USING type::ACCESSPOINTTYPE;
+        `)
+		return err
+	})
+}
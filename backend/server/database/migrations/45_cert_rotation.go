@@ -0,0 +1,21 @@
+package dbmigs
+
+import (
+	"github.com/go-pg/migrations/v8"
+)
+
+func init() {
+	migrations.MustRegisterTx(func(db migrations.DB) error {
+		_, err := db.Exec(`
+            -- Status of the agent certificate during the CA rotation.
+            ALTER TABLE machine
+                ADD COLUMN cert_rotation_status TEXT NOT NULL DEFAULT '';
+        `)
+		return err
+	}, func(db migrations.DB) error {
+		_, err := db.Exec(`
+            ALTER TABLE machine DROP COLUMN IF EXISTS cert_rotation_status;
+        `)
+		return err
+	})
+}
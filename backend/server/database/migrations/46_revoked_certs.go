@@ -0,0 +1,34 @@
+package dbmigs
+
+import (
+	"github.com/go-pg/migrations/v8"
+)
+
+func init() {
+	migrations.MustRegisterTx(func(db migrations.DB) error {
+		_, err := db.Exec(`
+            -- Serial number of the agent certificate. It is 0 for the
+            -- certificates issued before the serial numbers were stored.
+            ALTER TABLE machine
+                ADD COLUMN cert_serial_number BIGINT NOT NULL DEFAULT 0;
+
+            -- Agent certificates revoked by the server, e.g. because
+            -- the machines have been deleted.
+            CREATE TABLE IF NOT EXISTS revoked_cert (
+                id BIGSERIAL PRIMARY KEY,
+                serial_number BIGINT NOT NULL DEFAULT 0,
+                fingerprint BYTEA,
+                address TEXT NOT NULL,
+                agent_port BIGINT NOT NULL,
+                revoked_at TIMESTAMP WITHOUT TIME ZONE NOT NULL DEFAULT (now() AT TIME ZONE 'utc')
+            );
+        `)
+		return err
+	}, func(db migrations.DB) error {
+		_, err := db.Exec(`
+            DROP TABLE IF EXISTS revoked_cert;
+            ALTER TABLE machine DROP COLUMN IF EXISTS cert_serial_number;
+        `)
+		return err
+	})
+}
@@ -0,0 +1,36 @@
+package dbmigs
+
+import (
+	"github.com/go-pg/migrations/v8"
+)
+
+func init() {
+	migrations.MustRegisterTx(func(db migrations.DB) error {
+		_, err := db.Exec(`
+            -- Rules authorizing the registering machines automatically.
+            CREATE TABLE IF NOT EXISTS machine_authorization_rule (
+                id BIGSERIAL PRIMARY KEY,
+                created_at TIMESTAMP WITHOUT TIME ZONE NOT NULL DEFAULT (now() AT TIME ZONE 'utc'),
+                name TEXT NOT NULL,
+                enabled BOOLEAN NOT NULL DEFAULT TRUE,
+                source_cidr TEXT,
+                hostname_pattern TEXT,
+                agent_token_pattern TEXT,
+                csr_fingerprint TEXT,
+                CONSTRAINT machine_authorization_rule_name_unique UNIQUE (name),
+                CONSTRAINT machine_authorization_rule_criteria_check CHECK (
+                    source_cidr IS NOT NULL OR
+                    hostname_pattern IS NOT NULL OR
+                    agent_token_pattern IS NOT NULL OR
+                    csr_fingerprint IS NOT NULL
+                )
+            );
+        `)
+		return err
+	}, func(db migrations.DB) error {
+		_, err := db.Exec(`
+            DROP TABLE IF EXISTS machine_authorization_rule;
+        `)
+		return err
+	})
+}
@@ -0,0 +1,40 @@
+package dbmigs
+
+import (
+	"github.com/go-pg/migrations/v8"
+)
+
+func init() {
+	migrations.MustRegisterTx(func(db migrations.DB) error {
+		_, err := db.Exec(`
+            -- Per-machine and per-app overrides of the global puller intervals.
+            CREATE TABLE IF NOT EXISTS puller_interval (
+                id BIGSERIAL PRIMARY KEY,
+                puller TEXT NOT NULL,
+                machine_id BIGINT,
+                app_id BIGINT,
+                interval BIGINT NOT NULL,
+                CONSTRAINT puller_interval_machine_id_fkey FOREIGN KEY (machine_id)
+                    REFERENCES machine (id) MATCH SIMPLE
+                    ON UPDATE CASCADE
+                    ON DELETE CASCADE,
+                CONSTRAINT puller_interval_app_id_fkey FOREIGN KEY (app_id)
+                    REFERENCES app (id) MATCH SIMPLE
+                    ON UPDATE CASCADE
+                    ON DELETE CASCADE,
+                CONSTRAINT puller_interval_machine_id_unique UNIQUE (puller, machine_id),
+                CONSTRAINT puller_interval_app_id_unique UNIQUE (puller, app_id),
+                CONSTRAINT puller_interval_target_check CHECK (
+                    (machine_id IS NULL) <> (app_id IS NULL)
+                ),
+                CONSTRAINT puller_interval_interval_check CHECK (interval >= 0)
+            );
+        `)
+		return err
+	}, func(db migrations.DB) error {
+		_, err := db.Exec(`
+            DROP TABLE IF EXISTS puller_interval;
+        `)
+		return err
+	})
+}
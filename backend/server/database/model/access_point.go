@@ -11,9 +11,13 @@ type AccessPoint struct {
 	UseSecureProtocol bool `pg:",use_zero"`
 }
 
+// The control socket access point is used to communicate with a Kea
+// daemon directly over its UNIX domain control socket. Its address is
+// the path to the socket.
 const (
-	AccessPointControl    = "control"
-	AccessPointStatistics = "statistics"
+	AccessPointControl       = "control"
+	AccessPointControlSocket = "control-socket"
+	AccessPointStatistics    = "statistics"
 )
 
 // AppendAccessPoint is an utility function that appends an access point to a
@@ -133,6 +133,11 @@ func updateAppDaemons(tx *pg.Tx, app *App) ([]*Daemon, []*Daemon, error) {
 					app.ID, daemon.KeaDaemon)
 			}
 
+			// Store the configuration as a new version if it has changed.
+			if err = addKeaConfigVersionIfChanged(tx, daemon); err != nil {
+				return nil, nil, err
+			}
+
 			if daemon.KeaDaemon.KeaDHCPDaemon != nil {
 				// Make sure that the kea_dhcp_daemon references the kea_daemon.
 				daemon.KeaDaemon.KeaDHCPDaemon.KeaDaemonID = daemon.KeaDaemon.ID
@@ -491,3 +496,29 @@ func (app *App) GetAccessPoint(accessPointType string) (ap *AccessPoint, err err
 	}
 	return nil, pkgerrors.Errorf("no access point of type %s found for app id %d", accessPointType, app.ID)
 }
+
+// GetControlAccessPoint returns the access point used to control the app.
+// It is the control access point if the app has one. Otherwise, it is the
+// control socket access point of the Kea daemon controlled directly over
+// its control socket.
+func (app *App) GetControlAccessPoint() (ap *AccessPoint, err error) {
+	ap, err = app.GetAccessPoint(AccessPointControl)
+	if err == nil {
+		return ap, nil
+	}
+	if ap, socketErr := app.GetAccessPoint(AccessPointControlSocket); socketErr == nil {
+		return ap, nil
+	}
+	return nil, err
+}
+
+// Checks if the app is a Kea daemon controlled directly over its control
+// socket rather than via the Kea Control Agent.
+func (app *App) IsControlledOverSocket() bool {
+	_, err := app.GetAccessPoint(AccessPointControl)
+	if err == nil {
+		return false
+	}
+	_, err = app.GetAccessPoint(AccessPointControlSocket)
+	return err == nil
+}
@@ -152,6 +152,82 @@ func TestAddApp(t *testing.T) {
 	require.Len(t, addedDaemons, 0)
 }
 
+// Test that the control socket access points are unique by their paths
+// on the machine while the other access points remain unique by their
+// ports.
+func TestAddAppControlSocketUnique(t *testing.T) {
+	db, _, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	m := &Machine{
+		ID:        0,
+		Address:   "localhost",
+		AgentPort: 8080,
+	}
+	err := AddMachine(db, m)
+	require.NoError(t, err)
+
+	// Two daemons controlled over different control sockets.
+	var accessPoints []*AccessPoint
+	accessPoints = AppendAccessPoint(accessPoints, AccessPointControlSocket, "/tmp/kea4-ctrl-socket", "", 0, false)
+	a1 := &App{
+		MachineID:    m.ID,
+		Type:         AppTypeKea,
+		Active:       true,
+		AccessPoints: accessPoints,
+	}
+	_, err = AddApp(db, a1)
+	require.NoError(t, err)
+
+	accessPoints = []*AccessPoint{}
+	accessPoints = AppendAccessPoint(accessPoints, AccessPointControlSocket, "/tmp/kea6-ctrl-socket", "", 0, false)
+	a2 := &App{
+		MachineID:    m.ID,
+		Type:         AppTypeKea,
+		Active:       true,
+		AccessPoints: accessPoints,
+	}
+	_, err = AddApp(db, a2)
+	require.NoError(t, err)
+
+	// The same control socket - error should be raised.
+	accessPoints = []*AccessPoint{}
+	accessPoints = AppendAccessPoint(accessPoints, AccessPointControlSocket, "/tmp/kea4-ctrl-socket", "", 0, false)
+	a3 := &App{
+		MachineID:    m.ID,
+		Type:         AppTypeKea,
+		Active:       true,
+		AccessPoints: accessPoints,
+	}
+	_, err = AddApp(db, a3)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "duplicate")
+
+	// The same control port with different addresses - error should be raised.
+	accessPoints = []*AccessPoint{}
+	accessPoints = AppendAccessPoint(accessPoints, AccessPointControl, "192.0.2.1", "", 8000, false)
+	a4 := &App{
+		MachineID:    m.ID,
+		Type:         AppTypeKea,
+		Active:       true,
+		AccessPoints: accessPoints,
+	}
+	_, err = AddApp(db, a4)
+	require.NoError(t, err)
+
+	accessPoints = []*AccessPoint{}
+	accessPoints = AppendAccessPoint(accessPoints, AccessPointControl, "192.0.2.2", "", 8000, false)
+	a5 := &App{
+		MachineID:    m.ID,
+		Type:         AppTypeKea,
+		Active:       true,
+		AccessPoints: accessPoints,
+	}
+	_, err = AddApp(db, a5)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "duplicate")
+}
+
 // Test that the app can be updated in the database.
 func TestUpdateApp(t *testing.T) {
 	db, _, teardown := dbtest.SetupDatabaseTestCase(t)
@@ -19,12 +19,22 @@ func init() {
 }
 
 // Structure representing a single config report generated during
-// the daemons configuration review.
+// the daemons configuration review. The reports are not deleted when
+// the issues they describe are no longer found by the subsequent
+// reviews. They are marked resolved instead. The CreatedAt timestamp
+// is the time of the first review which found the issue. LastSeenAt
+// is the time of the last review which found the issue. ResolvedAt is
+// the time of the first review which no longer found the issue. It is
+// zero (NULL) for the reports describing outstanding issues. The
+// Fingerprint identifies the issue across the reviews.
 type ConfigReport struct {
 	ID          int64
 	CreatedAt   time.Time
+	LastSeenAt  time.Time
+	ResolvedAt  time.Time
 	CheckerName string
 	Content     string
+	Fingerprint string
 
 	DaemonID int64
 
@@ -95,8 +105,8 @@ func AddConfigReport(dbi dbops.DBI, configReport *ConfigReport) error {
 	return addConfigReport(dbi.(*pg.Tx), configReport)
 }
 
-// Select all or a range of the config reports for the specified daemon.
-// The offset of 0 causes the function to return reports beginning from
+// Select all or a range of the unresolved config reports for the specified
+// daemon. The offset of 0 causes the function to return reports beginning from
 // the first one for the daemon. The limit of 0 causes the function to
 // return all reports beginning from the offset. A non-zero limit value
 // limits the number of returned reports. Specify an offset and limit of
@@ -107,6 +117,7 @@ func GetConfigReportsByDaemonID(db *pg.DB, offset, limit int64, daemonID int64)
 	var configReports []ConfigReport
 	q := db.Model(&configReports).
 		Where("config_report.daemon_id = ?", daemonID).
+		Where("config_report.resolved_at IS NULL").
 		Order("config_report.id ASC").
 		Relation("RefDaemons", func(q *orm.Query) (*orm.Query, error) {
 			return q.Order("daemon_to_config_report.order_index ASC"), nil
@@ -127,6 +138,153 @@ func GetConfigReportsByDaemonID(db *pg.DB, offset, limit int64, daemonID int64)
 	return configReports, int64(total), nil
 }
 
+// Select all or a range of the config reports for the specified daemon,
+// including the resolved ones. The reports are ordered from the most
+// recently created. The offset and limit have the same meaning as
+// in GetConfigReportsByDaemonID.
+func GetConfigReportHistoryByDaemonID(db *pg.DB, offset, limit int64, daemonID int64) ([]ConfigReport, int64, error) {
+	var configReports []ConfigReport
+	q := db.Model(&configReports).
+		Where("config_report.daemon_id = ?", daemonID).
+		OrderExpr("config_report.created_at DESC").
+		OrderExpr("config_report.id DESC").
+		Relation("RefDaemons", func(q *orm.Query) (*orm.Query, error) {
+			return q.Order("daemon_to_config_report.order_index ASC"), nil
+		}).
+		Relation("RefDaemons.App").
+		Offset(int(offset))
+
+	if limit != 0 {
+		q = q.Limit(int(limit))
+	}
+
+	total, err := q.SelectAndCount()
+
+	if err != nil && !errors.Is(err, pg.ErrNoRows) {
+		err = pkgerrors.Wrapf(err, "problem with selecting config reports history for daemon %d", daemonID)
+		return configReports, 0, err
+	}
+	return configReports, int64(total), nil
+}
+
+// Select the config reports produced by the specified configuration
+// review run. They are the reports which were first seen before or
+// during the run, and which were last seen during or after the run.
+func GetConfigReportsByReviewRun(db *pg.DB, run *ConfigReviewRun) ([]ConfigReport, error) {
+	var configReports []ConfigReport
+	err := db.Model(&configReports).
+		Where("config_report.daemon_id = ?", run.DaemonID).
+		Where("config_report.created_at <= ?", run.CreatedAt).
+		Where("config_report.last_seen_at >= ?", run.CreatedAt).
+		Order("config_report.id ASC").
+		Relation("RefDaemons", func(q *orm.Query) (*orm.Query, error) {
+			return q.Order("daemon_to_config_report.order_index ASC"), nil
+		}).
+		Relation("RefDaemons.App").
+		Select()
+
+	if err != nil && !errors.Is(err, pg.ErrNoRows) {
+		err = pkgerrors.Wrapf(err, "problem with selecting config reports for config review run %d", run.ID)
+		return configReports, err
+	}
+	return configReports, nil
+}
+
+// Merges the config reports generated during the most recent review
+// of a daemon with the unresolved reports stored in the database. The
+// reports are matched by fingerprints. The matching reports stored in
+// the database are marked as last seen at the review time. The new
+// reports (i.e., having no match in the database) are inserted. The
+// stored reports having no match among the specified reports are
+// marked resolved at the review time. The specified reports must
+// belong to the specified daemon. The stored reports lacking the
+// fingerprint (i.e., created before the fingerprints were introduced)
+// can't be matched, so they are always marked resolved. It returns the
+// number of distinct reports found during the review.
+func mergeConfigReports(tx *pg.Tx, daemonID int64, reviewedAt time.Time, configReports []*ConfigReport) (int64, error) {
+	// Get the fingerprints of the outstanding issues.
+	var existingReports []ConfigReport
+	err := tx.Model(&existingReports).
+		Column("id", "fingerprint").
+		Where("daemon_id = ?", daemonID).
+		Where("resolved_at IS NULL").
+		Select()
+	if err != nil && !errors.Is(err, pg.ErrNoRows) {
+		return 0, pkgerrors.Wrapf(err, "problem with selecting unresolved config reports for daemon %d", daemonID)
+	}
+	unresolved := make(map[string]int64)
+	var resolvedIDs []int64
+	for _, r := range existingReports {
+		if len(r.Fingerprint) == 0 {
+			resolvedIDs = append(resolvedIDs, r.ID)
+			continue
+		}
+		unresolved[r.Fingerprint] = r.ID
+	}
+
+	var seenIDs []int64
+	presentFingerprints := make(map[string]bool)
+	for _, r := range configReports {
+		// The same issue may be reported twice. Ignore the duplicates.
+		if presentFingerprints[r.Fingerprint] {
+			continue
+		}
+		presentFingerprints[r.Fingerprint] = true
+
+		if id, ok := unresolved[r.Fingerprint]; ok {
+			// The issue has been already reported.
+			seenIDs = append(seenIDs, id)
+			delete(unresolved, r.Fingerprint)
+			continue
+		}
+		// This is a new issue.
+		r.CreatedAt = reviewedAt
+		r.LastSeenAt = reviewedAt
+		if err = addConfigReport(tx, r); err != nil {
+			return 0, err
+		}
+	}
+
+	if len(seenIDs) > 0 {
+		_, err = tx.Model((*ConfigReport)(nil)).
+			Set("last_seen_at = ?", reviewedAt).
+			Where("id IN (?)", pg.In(seenIDs)).
+			Update()
+		if err != nil {
+			return 0, pkgerrors.Wrapf(err, "problem with updating config reports for daemon %d", daemonID)
+		}
+	}
+
+	// The remaining issues were not found during this review.
+	for _, id := range unresolved {
+		resolvedIDs = append(resolvedIDs, id)
+	}
+	if len(resolvedIDs) > 0 {
+		_, err = tx.Model((*ConfigReport)(nil)).
+			Set("resolved_at = ?", reviewedAt).
+			Where("id IN (?)", pg.In(resolvedIDs)).
+			Update()
+		if err != nil {
+			return 0, pkgerrors.Wrapf(err, "problem with resolving config reports for daemon %d", daemonID)
+		}
+	}
+	return int64(len(presentFingerprints)), nil
+}
+
+// Merges the config reports generated during the most recent review
+// of a daemon with the reports stored in the database. See the
+// mergeConfigReports for details.
+func MergeConfigReports(dbi dbops.DBI, daemonID int64, reviewedAt time.Time, configReports []*ConfigReport) (count int64, err error) {
+	if db, ok := dbi.(*pg.DB); ok {
+		err = db.RunInTransaction(context.Background(), func(tx *pg.Tx) error {
+			count, err = mergeConfigReports(tx, daemonID, reviewedAt, configReports)
+			return err
+		})
+		return count, err
+	}
+	return mergeConfigReports(dbi.(*pg.Tx), daemonID, reviewedAt, configReports)
+}
+
 // Delete all config reports for the specified daemon.
 func DeleteConfigReportsByDaemonID(dbi dbops.DBI, daemonID int64) error {
 	_, err := dbi.Model((*ConfigReport)(nil)).
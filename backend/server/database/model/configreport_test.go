@@ -3,6 +3,7 @@ package dbmodel
 import (
 	"fmt"
 	"testing"
+	"time"
 
 	require "github.com/stretchr/testify/require"
 	dbtest "isc.org/stork/server/database/test"
@@ -353,3 +354,211 @@ func TestDeleteAppWithConfigReview(t *testing.T) {
 	err = DeleteApp(db, app)
 	require.NoError(t, err)
 }
+
+// Test that the config reports from the subsequent reviews are merged
+// with the reports stored in the database, and that the reports which
+// are no longer generated are marked resolved.
+func TestMergeConfigReports(t *testing.T) {
+	db, _, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	// Add a machine.
+	machine := &Machine{
+		Address:   "localhost",
+		AgentPort: 8080,
+	}
+	err := AddMachine(db, machine)
+	require.NoError(t, err)
+
+	// Add an app with one daemon.
+	app := &App{
+		Type:      AppTypeKea,
+		MachineID: machine.ID,
+		Daemons: []*Daemon{
+			NewKeaDaemon("dhcp4", true),
+		},
+	}
+	daemons, err := AddApp(db, app)
+	require.NoError(t, err)
+	require.Len(t, daemons, 1)
+
+	// The first review finds two issues.
+	firstReviewAt := time.Date(2022, 2, 10, 10, 0, 0, 0, time.UTC)
+	_, err = MergeConfigReports(db, daemons[0].ID, firstReviewAt, []*ConfigReport{
+		{
+			CheckerName: "checker1",
+			Content:     "first issue",
+			Fingerprint: "1111",
+			DaemonID:    daemons[0].ID,
+		},
+		{
+			CheckerName: "checker2",
+			Content:     "second issue",
+			Fingerprint: "2222",
+			DaemonID:    daemons[0].ID,
+		},
+	})
+	require.NoError(t, err)
+
+	configReports, total, err := GetConfigReportsByDaemonID(db, 0, 0, daemons[0].ID)
+	require.NoError(t, err)
+	require.EqualValues(t, 2, total)
+	require.Len(t, configReports, 2)
+	for _, r := range configReports {
+		require.Equal(t, firstReviewAt, r.CreatedAt)
+		require.Equal(t, firstReviewAt, r.LastSeenAt)
+		require.Zero(t, r.ResolvedAt)
+	}
+
+	// The second review finds the second issue and a new issue. The
+	// first issue has been fixed. The second issue is reported twice
+	// which should be ignored.
+	secondReviewAt := firstReviewAt.Add(time.Hour)
+	count, err := MergeConfigReports(db, daemons[0].ID, secondReviewAt, []*ConfigReport{
+		{
+			CheckerName: "checker2",
+			Content:     "second issue",
+			Fingerprint: "2222",
+			DaemonID:    daemons[0].ID,
+		},
+		{
+			CheckerName: "checker2",
+			Content:     "second issue",
+			Fingerprint: "2222",
+			DaemonID:    daemons[0].ID,
+		},
+		{
+			CheckerName: "checker3",
+			Content:     "third issue",
+			Fingerprint: "3333",
+			DaemonID:    daemons[0].ID,
+		},
+	})
+	require.NoError(t, err)
+
+	// The duplicate is not counted.
+	require.EqualValues(t, 2, count)
+
+	// Only the outstanding issues should be returned.
+	configReports, total, err = GetConfigReportsByDaemonID(db, 0, 0, daemons[0].ID)
+	require.NoError(t, err)
+	require.EqualValues(t, 2, total)
+	require.Len(t, configReports, 2)
+
+	require.Equal(t, "2222", configReports[0].Fingerprint)
+	require.Equal(t, firstReviewAt, configReports[0].CreatedAt)
+	require.Equal(t, secondReviewAt, configReports[0].LastSeenAt)
+	require.Zero(t, configReports[0].ResolvedAt)
+
+	require.Equal(t, "3333", configReports[1].Fingerprint)
+	require.Equal(t, secondReviewAt, configReports[1].CreatedAt)
+	require.Equal(t, secondReviewAt, configReports[1].LastSeenAt)
+	require.Zero(t, configReports[1].ResolvedAt)
+
+	// The history should also include the resolved issue.
+	configReports, total, err = GetConfigReportHistoryByDaemonID(db, 0, 0, daemons[0].ID)
+	require.NoError(t, err)
+	require.EqualValues(t, 3, total)
+	require.Len(t, configReports, 3)
+	require.Equal(t, "3333", configReports[0].Fingerprint)
+	require.Equal(t, "2222", configReports[1].Fingerprint)
+	require.Equal(t, "1111", configReports[2].Fingerprint)
+	require.Equal(t, firstReviewAt, configReports[2].LastSeenAt)
+	require.Equal(t, secondReviewAt, configReports[2].ResolvedAt)
+
+	// The first issue appears again. It should be reported as a new issue.
+	thirdReviewAt := secondReviewAt.Add(time.Hour)
+	_, err = MergeConfigReports(db, daemons[0].ID, thirdReviewAt, []*ConfigReport{
+		{
+			CheckerName: "checker1",
+			Content:     "first issue",
+			Fingerprint: "1111",
+			DaemonID:    daemons[0].ID,
+		},
+	})
+	require.NoError(t, err)
+
+	configReports, total, err = GetConfigReportsByDaemonID(db, 0, 0, daemons[0].ID)
+	require.NoError(t, err)
+	require.EqualValues(t, 1, total)
+	require.Len(t, configReports, 1)
+	require.Equal(t, "1111", configReports[0].Fingerprint)
+	require.Equal(t, thirdReviewAt, configReports[0].CreatedAt)
+
+	configReports, total, err = GetConfigReportHistoryByDaemonID(db, 0, 0, daemons[0].ID)
+	require.NoError(t, err)
+	require.EqualValues(t, 4, total)
+	require.Len(t, configReports, 4)
+
+	// Get the reports found during the second review.
+	configReports, err = GetConfigReportsByReviewRun(db, &ConfigReviewRun{
+		CreatedAt: secondReviewAt,
+		DaemonID:  daemons[0].ID,
+	})
+	require.NoError(t, err)
+	require.Len(t, configReports, 2)
+	require.Equal(t, "2222", configReports[0].Fingerprint)
+	require.Equal(t, "3333", configReports[1].Fingerprint)
+}
+
+// Test that the stored config reports lacking the fingerprints are marked
+// resolved during the merge.
+func TestMergeConfigReportsWithoutFingerprints(t *testing.T) {
+	db, _, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	machine := &Machine{
+		Address:   "localhost",
+		AgentPort: 8080,
+	}
+	err := AddMachine(db, machine)
+	require.NoError(t, err)
+
+	app := &App{
+		Type:      AppTypeKea,
+		MachineID: machine.ID,
+		Daemons: []*Daemon{
+			NewKeaDaemon("dhcp4", true),
+		},
+	}
+	daemons, err := AddApp(db, app)
+	require.NoError(t, err)
+
+	// Add the reports created before the fingerprints were introduced.
+	for _, content := range []string{"first issue", "second issue"} {
+		err = AddConfigReport(db, &ConfigReport{
+			CheckerName: "checker1",
+			Content:     content,
+			DaemonID:    daemons[0].ID,
+		})
+		require.NoError(t, err)
+	}
+
+	reviewedAt := time.Date(2022, 2, 10, 10, 0, 0, 0, time.UTC)
+	count, err := MergeConfigReports(db, daemons[0].ID, reviewedAt, []*ConfigReport{
+		{
+			CheckerName: "checker1",
+			Content:     "first issue",
+			Fingerprint: "1111",
+			DaemonID:    daemons[0].ID,
+		},
+	})
+	require.NoError(t, err)
+	require.EqualValues(t, 1, count)
+
+	// Both legacy reports have been resolved.
+	configReports, total, err := GetConfigReportsByDaemonID(db, 0, 0, daemons[0].ID)
+	require.NoError(t, err)
+	require.EqualValues(t, 1, total)
+	require.Len(t, configReports, 1)
+	require.Equal(t, "1111", configReports[0].Fingerprint)
+
+	configReports, total, err = GetConfigReportHistoryByDaemonID(db, 0, 0, daemons[0].ID)
+	require.NoError(t, err)
+	require.EqualValues(t, 3, total)
+	for _, r := range configReports {
+		if len(r.Fingerprint) == 0 {
+			require.Equal(t, reviewedAt, r.ResolvedAt)
+		}
+	}
+}
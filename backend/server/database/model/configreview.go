@@ -62,3 +62,95 @@ func GetConfigReviewByDaemonID(dbi dbops.DBI, daemonID int64) (*ConfigReview, er
 	}
 	return configReview, nil
 }
+
+// Holds the data related to a single configuration review pass for
+// a daemon. Contrary to the ConfigReview, which is replaced by each
+// subsequent review, a new run entry is added for each review. The
+// runs comprise the review history of the daemon. The reports found
+// during the run can be fetched with the GetConfigReportsByReviewRun.
+type ConfigReviewRun struct {
+	ID           int64
+	CreatedAt    time.Time
+	Trigger      string
+	ConfigHash   string
+	Signature    string
+	ReportsCount int64 `pg:",use_zero"`
+
+	DaemonID int64
+}
+
+// Inserts the configuration review run entry for a daemon.
+func AddConfigReviewRun(dbi dbops.DBI, run *ConfigReviewRun) error {
+	_, err := dbi.Model(run).Insert()
+	if err != nil {
+		err = pkgerrors.Wrapf(err, "problem with inserting the configuration review run entry for daemon %d",
+			run.DaemonID)
+	}
+	return err
+}
+
+// Fetches the configuration review run by id. It returns nil if the
+// run does not exist.
+func GetConfigReviewRunByID(dbi dbops.DBI, id int64) (*ConfigReviewRun, error) {
+	run := &ConfigReviewRun{}
+	err := dbi.Model(run).
+		Where("config_review_run.id = ?", id).
+		Select()
+	if err != nil {
+		if errors.Is(err, pg.ErrNoRows) {
+			return nil, nil
+		}
+		err = pkgerrors.Wrapf(err, "problem with selecting the config review run %d", id)
+		return nil, err
+	}
+	return run, nil
+}
+
+// Select all or a range of the configuration review runs for the
+// specified daemon, beginning from the most recent one. The offset
+// of 0 causes the function to return the runs beginning from the
+// most recent one. The limit of 0 causes the function to return all
+// runs beginning from the offset. Besides returning the runs, this
+// function returns their total number for the daemon.
+func GetConfigReviewRunsByDaemonID(dbi dbops.DBI, offset, limit int64, daemonID int64) ([]ConfigReviewRun, int64, error) {
+	var runs []ConfigReviewRun
+	q := dbi.Model(&runs).
+		Where("config_review_run.daemon_id = ?", daemonID).
+		OrderExpr("config_review_run.created_at DESC").
+		OrderExpr("config_review_run.id DESC").
+		Offset(int(offset))
+
+	if limit != 0 {
+		q = q.Limit(int(limit))
+	}
+
+	total, err := q.SelectAndCount()
+	if err != nil && !errors.Is(err, pg.ErrNoRows) {
+		err = pkgerrors.Wrapf(err, "problem with selecting config review runs for daemon %d", daemonID)
+		return runs, 0, err
+	}
+	return runs, int64(total), nil
+}
+
+// Deletes the oldest configuration review runs for the daemon, leaving
+// at most the specified number of the most recent runs. It returns the
+// number of deleted runs.
+func DeleteOldConfigReviewRuns(dbi dbops.DBI, daemonID int64, retained int64) (int64, error) {
+	subquery := dbi.Model((*ConfigReviewRun)(nil)).
+		Column("id").
+		Where("daemon_id = ?", daemonID).
+		OrderExpr("created_at DESC").
+		OrderExpr("id DESC").
+		Offset(int(retained))
+
+	result, err := dbi.Model((*ConfigReviewRun)(nil)).
+		Where("id IN (?)", subquery).
+		Delete()
+	if err != nil {
+		if errors.Is(err, pg.ErrNoRows) {
+			return 0, nil
+		}
+		return 0, pkgerrors.Wrapf(err, "problem with deleting old config review runs for daemon %d", daemonID)
+	}
+	return int64(result.RowsAffected()), nil
+}
@@ -90,3 +90,102 @@ func TestConfigReport(t *testing.T) {
 	require.NoError(t, err)
 	require.Nil(t, returnedConfigReview)
 }
+
+// Test inserting, selecting and deleting the config review runs.
+func TestConfigReviewRuns(t *testing.T) {
+	db, _, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	// Add a machine.
+	machine := &Machine{
+		Address:   "localhost",
+		AgentPort: 8080,
+	}
+	err := AddMachine(db, machine)
+	require.NoError(t, err)
+
+	// Add an app with two daemons.
+	app := &App{
+		Type:      AppTypeKea,
+		MachineID: machine.ID,
+		Daemons: []*Daemon{
+			NewKeaDaemon("dhcp4", true),
+			NewKeaDaemon("dhcp6", true),
+		},
+	}
+	daemons, err := AddApp(db, app)
+	require.NoError(t, err)
+	require.Len(t, daemons, 2)
+
+	// Add several runs for the first daemon and one run for the
+	// second daemon.
+	for i := 0; i < 5; i++ {
+		run := &ConfigReviewRun{
+			CreatedAt:    time.Date(2022, 2, 10, 10, i, 0, 0, time.UTC),
+			Trigger:      "periodic",
+			ConfigHash:   "1234",
+			Signature:    "2345",
+			ReportsCount: int64(i),
+			DaemonID:     daemons[0].ID,
+		}
+		err = AddConfigReviewRun(db, run)
+		require.NoError(t, err)
+		require.NotZero(t, run.ID)
+	}
+	run := &ConfigReviewRun{
+		Trigger:    "manual",
+		ConfigHash: "3456",
+		Signature:  "2345",
+		DaemonID:   daemons[1].ID,
+	}
+	err = AddConfigReviewRun(db, run)
+	require.NoError(t, err)
+
+	// Get the runs for the first daemon. The most recent run goes first.
+	runs, total, err := GetConfigReviewRunsByDaemonID(db, 0, 0, daemons[0].ID)
+	require.NoError(t, err)
+	require.EqualValues(t, 5, total)
+	require.Len(t, runs, 5)
+	require.EqualValues(t, 4, runs[0].ReportsCount)
+	require.EqualValues(t, 0, runs[4].ReportsCount)
+	require.Equal(t, "periodic", runs[0].Trigger)
+
+	// Get a page.
+	runs, total, err = GetConfigReviewRunsByDaemonID(db, 1, 2, daemons[0].ID)
+	require.NoError(t, err)
+	require.EqualValues(t, 5, total)
+	require.Len(t, runs, 2)
+	require.EqualValues(t, 3, runs[0].ReportsCount)
+	require.EqualValues(t, 2, runs[1].ReportsCount)
+
+	// Get the run by ID.
+	returnedRun, err := GetConfigReviewRunByID(db, run.ID)
+	require.NoError(t, err)
+	require.NotNil(t, returnedRun)
+	require.Equal(t, "manual", returnedRun.Trigger)
+	require.Equal(t, daemons[1].ID, returnedRun.DaemonID)
+	require.WithinDuration(t, time.Now(), returnedRun.CreatedAt, 5*time.Second)
+
+	// Non-existing run.
+	returnedRun, err = GetConfigReviewRunByID(db, run.ID+1000)
+	require.NoError(t, err)
+	require.Nil(t, returnedRun)
+
+	// Retain only the two most recent runs for the first daemon.
+	count, err := DeleteOldConfigReviewRuns(db, daemons[0].ID, 2)
+	require.NoError(t, err)
+	require.EqualValues(t, 3, count)
+
+	runs, total, err = GetConfigReviewRunsByDaemonID(db, 0, 0, daemons[0].ID)
+	require.NoError(t, err)
+	require.EqualValues(t, 2, total)
+	require.Len(t, runs, 2)
+	require.EqualValues(t, 4, runs[0].ReportsCount)
+	require.EqualValues(t, 3, runs[1].ReportsCount)
+
+	// The run for the other daemon should be intact.
+	runs, total, err = GetConfigReviewRunsByDaemonID(db, 0, 0, daemons[1].ID)
+	require.NoError(t, err)
+	require.EqualValues(t, 1, total)
+	require.Len(t, runs, 1)
+}
@@ -0,0 +1,127 @@
+package dbmodel
+
+import (
+	"errors"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+	pkgerrors "github.com/pkg/errors"
+	dbops "isc.org/stork/server/database"
+)
+
+// Maximum number of the configuration versions stored for a daemon.
+// The oldest versions are deleted when a new version is stored.
+const MaxKeaConfigVersionsPerDaemon = 50
+
+// Holds a single version of the Kea daemon configuration. A new
+// version is stored whenever the configuration hash of a daemon
+// differs from the hash of its most recent version. The versions
+// comprise the configuration history of the daemon and can be
+// compared to find out what has changed in the configuration.
+type KeaConfigVersion struct {
+	ID         int64
+	CreatedAt  time.Time
+	ConfigHash string
+	Config     *KeaConfig
+
+	DaemonID int64
+}
+
+// Stores the current configuration of the Kea daemon as a new version
+// if the configuration differs from the most recent version. The
+// configurations having no hash are not stored because they cannot
+// be matched with the stored versions. The oldest versions exceeding
+// the MaxKeaConfigVersionsPerDaemon limit are deleted.
+func addKeaConfigVersionIfChanged(tx *pg.Tx, daemon *Daemon) error {
+	if daemon.KeaDaemon == nil || daemon.KeaDaemon.Config == nil || daemon.KeaDaemon.ConfigHash == "" {
+		return nil
+	}
+	var latest []KeaConfigVersion
+	err := tx.Model(&latest).
+		Column("id", "config_hash").
+		Where("daemon_id = ?", daemon.ID).
+		OrderExpr("id DESC").
+		Limit(1).
+		Select()
+	if err != nil && !errors.Is(err, pg.ErrNoRows) {
+		return pkgerrors.Wrapf(err, "problem with selecting the latest config version for daemon %d", daemon.ID)
+	}
+	if len(latest) > 0 && latest[0].ConfigHash == daemon.KeaDaemon.ConfigHash {
+		return nil
+	}
+	version := &KeaConfigVersion{
+		CreatedAt:  time.Now().UTC(),
+		ConfigHash: daemon.KeaDaemon.ConfigHash,
+		Config:     daemon.KeaDaemon.Config,
+		DaemonID:   daemon.ID,
+	}
+	if _, err = tx.Model(version).Insert(); err != nil {
+		return pkgerrors.Wrapf(err, "problem with inserting the config version for daemon %d", daemon.ID)
+	}
+	_, err = DeleteOldKeaConfigVersions(tx, daemon.ID, MaxKeaConfigVersionsPerDaemon)
+	return err
+}
+
+// Deletes the oldest configuration versions of the daemon leaving at
+// most the specified number of the most recent versions. It returns
+// the number of deleted versions.
+func DeleteOldKeaConfigVersions(dbi dbops.DBI, daemonID int64, retained int64) (int64, error) {
+	subquery := dbi.Model((*KeaConfigVersion)(nil)).
+		Column("id").
+		Where("daemon_id = ?", daemonID).
+		OrderExpr("id DESC").
+		Offset(int(retained))
+
+	result, err := dbi.Model((*KeaConfigVersion)(nil)).
+		Where("id IN (?)", subquery).
+		Delete()
+	if err != nil {
+		if errors.Is(err, pg.ErrNoRows) {
+			return 0, nil
+		}
+		return 0, pkgerrors.Wrapf(err, "problem with deleting old config versions for daemon %d", daemonID)
+	}
+	return int64(result.RowsAffected()), nil
+}
+
+// Selects all or a range of the configuration versions for the specified
+// daemon. The versions are ordered from the most recent one. The
+// configurations are not fetched, use GetKeaConfigVersionByID to fetch
+// the configuration of a selected version. The offset and limit have the
+// same meaning as in GetConfigReportsByDaemonID. Besides the versions,
+// it returns the total number of versions for the daemon.
+func GetKeaConfigVersionsByDaemonID(dbi dbops.DBI, offset, limit int64, daemonID int64) ([]KeaConfigVersion, int64, error) {
+	var versions []KeaConfigVersion
+	q := dbi.Model(&versions).
+		ExcludeColumn("config").
+		Where("kea_config_version.daemon_id = ?", daemonID).
+		OrderExpr("kea_config_version.id DESC").
+		Offset(int(offset))
+
+	if limit != 0 {
+		q = q.Limit(int(limit))
+	}
+
+	total, err := q.SelectAndCount()
+	if err != nil && !errors.Is(err, pg.ErrNoRows) {
+		err = pkgerrors.Wrapf(err, "problem with selecting config versions for daemon %d", daemonID)
+		return versions, 0, err
+	}
+	return versions, int64(total), nil
+}
+
+// Fetches the configuration version by id, including the configuration.
+// It returns nil if the version does not exist.
+func GetKeaConfigVersionByID(dbi dbops.DBI, id int64) (*KeaConfigVersion, error) {
+	version := &KeaConfigVersion{}
+	err := dbi.Model(version).
+		Where("kea_config_version.id = ?", id).
+		Select()
+	if err != nil {
+		if errors.Is(err, pg.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, pkgerrors.Wrapf(err, "problem with selecting the config version %d", id)
+	}
+	return version, nil
+}
@@ -0,0 +1,181 @@
+package dbmodel
+
+import (
+	"fmt"
+	"testing"
+
+	require "github.com/stretchr/testify/require"
+	dbtest "isc.org/stork/server/database/test"
+)
+
+// Test that a new configuration version is stored whenever the daemon
+// configuration changes.
+func TestKeaConfigVersions(t *testing.T) {
+	db, _, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	// Add a machine.
+	machine := &Machine{
+		Address:   "localhost",
+		AgentPort: 8080,
+	}
+	err := AddMachine(db, machine)
+	require.NoError(t, err)
+
+	// Add an app with a daemon having a configuration.
+	daemon := NewKeaDaemon("dhcp4", true)
+	err = daemon.SetConfigFromJSON(`{"Dhcp4": {"valid-lifetime": 1000}}`)
+	require.NoError(t, err)
+	app := &App{
+		Type:      AppTypeKea,
+		MachineID: machine.ID,
+		Daemons:   []*Daemon{daemon},
+	}
+	daemons, err := AddApp(db, app)
+	require.NoError(t, err)
+	require.Len(t, daemons, 1)
+
+	// The initial configuration should be stored as the first version.
+	versions, total, err := GetKeaConfigVersionsByDaemonID(db, 0, 0, daemon.ID)
+	require.NoError(t, err)
+	require.EqualValues(t, 1, total)
+	require.Len(t, versions, 1)
+	require.Equal(t, daemon.KeaDaemon.ConfigHash, versions[0].ConfigHash)
+	require.Nil(t, versions[0].Config)
+
+	// Updating the app without changing the configuration should not
+	// add a new version.
+	_, _, err = UpdateApp(db, app)
+	require.NoError(t, err)
+	_, total, err = GetKeaConfigVersionsByDaemonID(db, 0, 0, daemon.ID)
+	require.NoError(t, err)
+	require.EqualValues(t, 1, total)
+
+	// Change the configuration and update the daemon.
+	err = daemon.SetConfigFromJSON(`{"Dhcp4": {"valid-lifetime": 2000}}`)
+	require.NoError(t, err)
+	err = UpdateDaemon(db, daemon)
+	require.NoError(t, err)
+
+	// Revert to the first configuration and update the app. It should
+	// be stored as a new version.
+	err = daemon.SetConfigFromJSON(`{"Dhcp4": {"valid-lifetime": 1000}}`)
+	require.NoError(t, err)
+	_, _, err = UpdateApp(db, app)
+	require.NoError(t, err)
+
+	versions, total, err = GetKeaConfigVersionsByDaemonID(db, 0, 0, daemon.ID)
+	require.NoError(t, err)
+	require.EqualValues(t, 3, total)
+	require.Len(t, versions, 3)
+	require.Equal(t, versions[0].ConfigHash, versions[2].ConfigHash)
+	require.NotEqual(t, versions[0].ConfigHash, versions[1].ConfigHash)
+	require.Greater(t, versions[0].ID, versions[1].ID)
+	require.Greater(t, versions[1].ID, versions[2].ID)
+
+	// Test paging.
+	versions, total, err = GetKeaConfigVersionsByDaemonID(db, 1, 1, daemon.ID)
+	require.NoError(t, err)
+	require.EqualValues(t, 3, total)
+	require.Len(t, versions, 1)
+
+	// Fetch the version with the configuration.
+	version, err := GetKeaConfigVersionByID(db, versions[0].ID)
+	require.NoError(t, err)
+	require.NotNil(t, version)
+	require.Equal(t, daemon.ID, version.DaemonID)
+	require.NotNil(t, version.Config)
+	root, ok := (*version.Config)["Dhcp4"].(map[string]interface{})
+	require.True(t, ok)
+	require.EqualValues(t, 2000, root["valid-lifetime"])
+
+	// Non-existing version.
+	version, err = GetKeaConfigVersionByID(db, versions[0].ID+100)
+	require.NoError(t, err)
+	require.Nil(t, version)
+}
+
+// Test that the configurations without hash are not stored as versions.
+func TestKeaConfigVersionsNoHash(t *testing.T) {
+	db, _, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	machine := &Machine{
+		Address:   "localhost",
+		AgentPort: 8080,
+	}
+	err := AddMachine(db, machine)
+	require.NoError(t, err)
+
+	daemon := NewKeaDaemon("dhcp4", true)
+	config, err := NewKeaConfigFromJSON(`{"Dhcp4": {"valid-lifetime": 1000}}`)
+	require.NoError(t, err)
+	err = daemon.SetConfig(config)
+	require.NoError(t, err)
+	app := &App{
+		Type:      AppTypeKea,
+		MachineID: machine.ID,
+		Daemons:   []*Daemon{daemon},
+	}
+	_, err = AddApp(db, app)
+	require.NoError(t, err)
+
+	versions, total, err := GetKeaConfigVersionsByDaemonID(db, 0, 0, daemon.ID)
+	require.NoError(t, err)
+	require.Zero(t, total)
+	require.Empty(t, versions)
+}
+
+// Test that the number of the stored configuration versions is limited
+// and the oldest versions are deleted.
+func TestDeleteOldKeaConfigVersions(t *testing.T) {
+	db, _, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	machine := &Machine{
+		Address:   "localhost",
+		AgentPort: 8080,
+	}
+	err := AddMachine(db, machine)
+	require.NoError(t, err)
+
+	daemon := NewKeaDaemon("dhcp4", true)
+	err = daemon.SetConfigFromJSON(`{"Dhcp4": {"valid-lifetime": 0}}`)
+	require.NoError(t, err)
+	app := &App{
+		Type:      AppTypeKea,
+		MachineID: machine.ID,
+		Daemons:   []*Daemon{daemon},
+	}
+	_, err = AddApp(db, app)
+	require.NoError(t, err)
+
+	// Store more versions than the limit.
+	for i := 1; i <= MaxKeaConfigVersionsPerDaemon+5; i++ {
+		err = daemon.SetConfigFromJSON(fmt.Sprintf(`{"Dhcp4": {"valid-lifetime": %d}}`, i))
+		require.NoError(t, err)
+		err = UpdateDaemon(db, daemon)
+		require.NoError(t, err)
+	}
+
+	versions, total, err := GetKeaConfigVersionsByDaemonID(db, 0, 0, daemon.ID)
+	require.NoError(t, err)
+	require.EqualValues(t, MaxKeaConfigVersionsPerDaemon, total)
+	require.Equal(t, daemon.KeaDaemon.ConfigHash, versions[0].ConfigHash)
+
+	// Delete all but two most recent versions.
+	deleted, err := DeleteOldKeaConfigVersions(db, daemon.ID, 2)
+	require.NoError(t, err)
+	require.EqualValues(t, MaxKeaConfigVersionsPerDaemon-2, deleted)
+
+	remaining, total, err := GetKeaConfigVersionsByDaemonID(db, 0, 0, daemon.ID)
+	require.NoError(t, err)
+	require.EqualValues(t, 2, total)
+	require.Equal(t, versions[0].ID, remaining[0].ID)
+	require.Equal(t, versions[1].ID, remaining[1].ID)
+
+	// Nothing to delete.
+	deleted, err = DeleteOldKeaConfigVersions(db, daemon.ID, 2)
+	require.NoError(t, err)
+	require.Zero(t, deleted)
+}
@@ -398,6 +398,11 @@ func updateDaemon(tx *pg.Tx, daemon *Daemon) error {
 			return pkgerrors.Wrapf(ErrNotExists, "Kea daemon with id %d does not exist", daemon.KeaDaemon.ID)
 		}
 
+		// Store the configuration as a new version if it has changed.
+		if err = addKeaConfigVersionIfChanged(tx, daemon); err != nil {
+			return err
+		}
+
 		// If this is Kea DHCP daemon, there is one more table to update.
 		if daemon.KeaDaemon.KeaDHCPDaemon != nil && daemon.KeaDaemon.KeaDHCPDaemon.ID != 0 {
 			daemon.KeaDaemon.KeaDHCPDaemon.KeaDaemonID = daemon.KeaDaemon.ID
@@ -317,6 +317,28 @@ func GetHostsBySubnetID(dbi dbops.DBI, subnetID int64) ([]Host, error) {
 	return hosts, err
 }
 
+// Fetches the addresses and prefixes reserved in the global hosts for the
+// specified family. Unlike GetHostsBySubnetID, it doesn't fetch the hosts
+// and their relations.
+func GetGlobalIPReservationAddresses(dbi dbops.DBI, family int) ([]string, error) {
+	var addresses []string
+	err := dbi.Model((*IPReservation)(nil)).
+		Column("ip_reservation.address").
+		Join("INNER JOIN host ON ip_reservation.host_id = host.id").
+		Where("host.subnet_id IS NULL").
+		Where("family(ip_reservation.address) = ?", family).
+		OrderExpr("ip_reservation.id ASC").
+		Select(&addresses)
+	if err != nil {
+		if errors.Is(err, pg.ErrNoRows) {
+			return nil, nil
+		}
+		err = pkgerrors.Wrapf(err, "problem with getting global IPv%d reservations", family)
+		return nil, err
+	}
+	return addresses, nil
+}
+
 // Fetches a collection of hosts by daemon ID and optionally filters by a
 // data source.
 func GetHostsByDaemonID(dbi dbops.DBI, daemonID int64, dataSource string) ([]Host, int64, error) {
@@ -521,6 +543,70 @@ func DeleteDaemonFromHosts(dbi dbops.DBI, daemonID int64, dataSource string) (in
 	return int64(result.RowsAffected()), nil
 }
 
+// Dissociates a daemon from the hosts belonging to the specified subnets
+// in a single statement. The subnetID of 0 designates the global hosts.
+// The dataSource has the same meaning as in DeleteDaemonFromHosts. The
+// first returned value indicates if any row was removed from the
+// local_host table.
+func DeleteDaemonFromSubnetHosts(dbi dbops.DBI, daemonID int64, subnetIDs []int64, dataSource string) (int64, error) {
+	if len(subnetIDs) == 0 {
+		return 0, nil
+	}
+	global := false
+	var ids []int64
+	for _, subnetID := range subnetIDs {
+		if subnetID == 0 {
+			global = true
+		} else {
+			ids = append(ids, subnetID)
+		}
+	}
+
+	subquery := dbi.Model((*Host)(nil)).
+		Column("host.id").
+		WhereGroup(func(q *orm.Query) (*orm.Query, error) {
+			if global {
+				q = q.WhereOr("host.subnet_id IS NULL")
+			}
+			if len(ids) > 0 {
+				q = q.WhereOr("host.subnet_id IN (?)", pg.In(ids))
+			}
+			return q, nil
+		})
+
+	q := dbi.Model((*LocalHost)(nil)).
+		Where("daemon_id = ?", daemonID).
+		Where("host_id IN (?)", subquery)
+
+	if len(dataSource) > 0 {
+		q = q.Where("data_source = ?", dataSource)
+	}
+
+	result, err := q.Delete()
+	if err != nil && !errors.Is(err, pg.ErrNoRows) {
+		err = pkgerrors.Wrapf(err, "problem with deleting a daemon %d from hosts in %d subnets", daemonID, len(subnetIDs))
+		return 0, err
+	}
+	return int64(result.RowsAffected()), nil
+}
+
+// Checks if the daemon is associated with any hosts. The dataSource has
+// the same meaning as in DeleteDaemonFromHosts.
+func HasDaemonHosts(dbi dbops.DBI, daemonID int64, dataSource string) (bool, error) {
+	q := dbi.Model((*LocalHost)(nil)).
+		Where("daemon_id = ?", daemonID)
+
+	if len(dataSource) > 0 {
+		q = q.Where("data_source = ?", dataSource)
+	}
+
+	exists, err := q.Exists()
+	if err != nil {
+		return false, pkgerrors.Wrapf(err, "problem with checking if daemon %d has hosts", daemonID)
+	}
+	return exists, nil
+}
+
 // Deletes hosts which are not associated with any apps. Returns deleted host
 // count and an error.
 func DeleteOrphanedHosts(dbi dbops.DBI) (int64, error) {
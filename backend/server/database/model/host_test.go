@@ -390,6 +390,23 @@ func TestGetHostsBySubnetID(t *testing.T) {
 	require.Contains(t, returned, hosts[2])
 }
 
+// Test that the addresses reserved in the global hosts are fetched
+// for the specified family.
+func TestGetGlobalIPReservationAddresses(t *testing.T) {
+	db, _, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	addTestHosts(t, db)
+
+	addresses, err := GetGlobalIPReservationAddresses(db, 4)
+	require.NoError(t, err)
+	require.Equal(t, []string{"192.0.2.6/32", "192.0.2.7/32"}, addresses)
+
+	addresses, err = GetGlobalIPReservationAddresses(db, 6)
+	require.NoError(t, err)
+	require.Equal(t, []string{"2001:db8:1::2/128"}, addresses)
+}
+
 // Test that page of the hosts can be fetched without filtering.
 func TestGetHostsByPageNoFiltering(t *testing.T) {
 	db, _, teardown := dbtest.SetupDatabaseTestCase(t)
@@ -820,6 +837,74 @@ func TestDeleteDaemonFromHosts(t *testing.T) {
 	require.Len(t, returned, 1)
 }
 
+// Test that the daemon can be dissociated from the hosts belonging to
+// a subnet or from the global hosts.
+func TestDeleteDaemonFromSubnetHosts(t *testing.T) {
+	db, _, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	// Insert apps and hosts into the database.
+	apps := addTestSubnetApps(t, db)
+	hosts := addTestHosts(t, db)
+
+	// Associate the daemon with all hosts. The first and third hosts
+	// belong to the subnets. The other hosts are global.
+	for i := range hosts {
+		err := AddDaemonToHost(db, &hosts[i], apps[0].Daemons[0].ID, "api")
+		require.NoError(t, err)
+	}
+	daemonID := apps[0].Daemons[0].ID
+
+	// Removing associations with non-matching data source should
+	// affect no hosts.
+	count, err := DeleteDaemonFromSubnetHosts(db, daemonID, []int64{hosts[0].SubnetID}, "config")
+	require.NoError(t, err)
+	require.Zero(t, count)
+
+	// No subnets specified.
+	count, err = DeleteDaemonFromSubnetHosts(db, daemonID, nil, "api")
+	require.NoError(t, err)
+	require.Zero(t, count)
+
+	// Remove associations with the hosts in the first subnet and with
+	// the global hosts at once.
+	count, err = DeleteDaemonFromSubnetHosts(db, daemonID, []int64{hosts[0].SubnetID, 0}, "api")
+	require.NoError(t, err)
+	require.EqualValues(t, 3, count)
+
+	// Only the host in the second subnet remains associated.
+	returned, count, err := GetHostsByPage(db, 0, 1000, apps[0].ID, nil, nil, nil, "", SortDirAny)
+	require.NoError(t, err)
+	require.EqualValues(t, 1, count)
+	require.Len(t, returned, 1)
+	require.Equal(t, hosts[2].ID, returned[0].ID)
+}
+
+// Test checking if the daemon is associated with any hosts.
+func TestHasDaemonHosts(t *testing.T) {
+	db, _, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	apps := addTestSubnetApps(t, db)
+	hosts := addTestHosts(t, db)
+	daemonID := apps[0].Daemons[0].ID
+
+	has, err := HasDaemonHosts(db, daemonID, "api")
+	require.NoError(t, err)
+	require.False(t, has)
+
+	err = AddDaemonToHost(db, &hosts[0], daemonID, "config")
+	require.NoError(t, err)
+
+	has, err = HasDaemonHosts(db, daemonID, "api")
+	require.NoError(t, err)
+	require.False(t, has)
+
+	has, err = HasDaemonHosts(db, daemonID, "")
+	require.NoError(t, err)
+	require.True(t, has)
+}
+
 // Test deleting hosts not assigned to any apps.
 func TestDeleteOrphanedHosts(t *testing.T) {
 	db, _, teardown := dbtest.SetupDatabaseTestCase(t)
@@ -34,3 +34,21 @@ func GetLogTargetByID(db *pg.DB, id int64) (*LogTarget, error) {
 	}
 	return &logTarget, nil
 }
+
+// Retrieves log targets of the specified daemons from the database. If the
+// list of daemon IDs is empty, the log targets of all daemons are returned.
+// The targets are ordered by ID.
+func GetLogTargetsByDaemonIDs(db *pg.DB, daemonIDs []int64) ([]*LogTarget, error) {
+	logTargets := []*LogTarget{}
+	q := db.Model(&logTargets).
+		Relation("Daemon.App.Machine").
+		OrderExpr("log_target.id ASC")
+	if len(daemonIDs) > 0 {
+		q = q.Where("log_target.daemon_id IN (?)", pg.In(daemonIDs))
+	}
+	err := q.Select()
+	if err != nil && !errors.Is(err, pg.ErrNoRows) {
+		return nil, pkgerrors.Wrapf(err, "problem with getting log targets of daemons %v", daemonIDs)
+	}
+	return logTargets, nil
+}
@@ -76,3 +76,70 @@ func TestGetLogTargetByID(t *testing.T) {
 	require.NoError(t, err)
 	require.Nil(t, logTarget)
 }
+
+// Test that the log targets of the selected daemons can be fetched.
+func TestGetLogTargetsByDaemonIDs(t *testing.T) {
+	db, _, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	m := &Machine{
+		ID:        0,
+		Address:   "localhost",
+		AgentPort: 8080,
+	}
+	err := AddMachine(db, m)
+	require.NoError(t, err)
+
+	a := &App{
+		ID:        0,
+		MachineID: m.ID,
+		Type:      AppTypeKea,
+		Active:    true,
+		Daemons: []*Daemon{
+			{
+				Name:   "dhcp4",
+				Active: true,
+				LogTargets: []*LogTarget{
+					{
+						Output: "/tmp/kea-dhcp4.log",
+					},
+				},
+			},
+			{
+				Name:   "dhcp6",
+				Active: true,
+				LogTargets: []*LogTarget{
+					{
+						Output: "/tmp/kea-dhcp6.log",
+					},
+					{
+						Output: "stdout",
+					},
+				},
+			},
+		},
+	}
+	_, err = AddApp(db, a)
+	require.NoError(t, err)
+	require.Len(t, a.Daemons, 2)
+
+	// Get the log targets of the second daemon.
+	logTargets, err := GetLogTargetsByDaemonIDs(db, []int64{a.Daemons[1].ID})
+	require.NoError(t, err)
+	require.Len(t, logTargets, 2)
+	require.Equal(t, "/tmp/kea-dhcp6.log", logTargets[0].Output)
+	require.Equal(t, "stdout", logTargets[1].Output)
+	require.NotNil(t, logTargets[0].Daemon)
+	require.NotNil(t, logTargets[0].Daemon.App)
+	require.NotNil(t, logTargets[0].Daemon.App.Machine)
+
+	// No daemons specified, so all log targets are returned.
+	logTargets, err = GetLogTargetsByDaemonIDs(db, nil)
+	require.NoError(t, err)
+	require.Len(t, logTargets, 3)
+
+	// Non-existing daemon.
+	logTargets, err = GetLogTargetsByDaemonIDs(db, []int64{a.Daemons[1].ID + 1000})
+	require.NoError(t, err)
+	require.Empty(t, logTargets)
+}
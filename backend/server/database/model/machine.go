@@ -1,12 +1,14 @@
 package dbmodel
 
 import (
+	"context"
 	"errors"
 	"time"
 
 	"github.com/go-pg/pg/v10"
 	"github.com/go-pg/pg/v10/orm"
 	pkgerrors "github.com/pkg/errors"
+	dbops "isc.org/stork/server/database"
 )
 
 // Part of machine table in database that describes state of machine. In DB it is stored as JSONB.
@@ -27,8 +29,13 @@ type MachineState struct {
 	VirtualizationSystem string
 	VirtualizationRole   string
 	HostID               string
+	AgentCertExpiresAt   time.Time
 }
 
+// Period before the expiration of the agent certificate when the
+// certificate is considered to be expiring.
+const AgentCertExpiringPeriod = 30 * 24 * time.Hour
+
 // Represents a machine held in machine table in the database.
 type Machine struct {
 	ID              int64
@@ -42,6 +49,38 @@ type Machine struct {
 	AgentToken      string
 	CertFingerprint [32]byte
 	Authorized      bool `pg:",use_zero"`
+	// Serial number of the agent certificate. It is 0 for the certificates
+	// issued before the serial numbers were stored.
+	CertSerialNumber int64 `pg:",use_zero"`
+	// Status of the agent certificate during the CA rotation.
+	CertRotationStatus CertRotationStatus `pg:",use_zero"`
+}
+
+// Status of the agent certificate during the CA rotation.
+type CertRotationStatus string
+
+// The agent certificate is pending when it must be re-issued using the
+// new CA and the agent must trust the new CA alongside the old one. It
+// is re-issued when it is done. The old CA is retiring when the rotation
+// has been finished but the agent still trusts the old CA. The rotation
+// failed when it has been finished before the certificate was re-issued.
+// Such an agent must be registered again. The status is empty when the
+// rotation is not in progress or the agent is up to date.
+const (
+	CertRotationNone     CertRotationStatus = ""
+	CertRotationPending  CertRotationStatus = "pending"
+	CertRotationReissued CertRotationStatus = "reissued"
+	CertRotationRetiring CertRotationStatus = "retiring"
+	CertRotationFailed   CertRotationStatus = "failed"
+)
+
+// Checks if the agent certificate expires within the AgentCertExpiringPeriod.
+// It returns false if the expiration time of the certificate is unknown.
+func (m *Machine) IsAgentCertExpiring(now time.Time) bool {
+	if m.State.AgentCertExpiresAt.IsZero() {
+		return false
+	}
+	return !now.Add(AgentCertExpiringPeriod).Before(m.State.AgentCertExpiresAt)
 }
 
 type MachineRelation string
@@ -234,13 +273,37 @@ func GetAllMachines(db *pg.DB, authorized *bool) ([]Machine, error) {
 	return machines, nil
 }
 
-// Delete a machine from database.
-func DeleteMachine(db *pg.DB, machine *Machine) error {
-	result, err := db.Model(machine).WherePK().Delete()
+// Sets the CA rotation status of the machines having one of the specified
+// statuses. If no statuses are specified, the status of all machines
+// is set.
+func UpdateMachinesCertRotationStatus(dbi dbops.DBI, status CertRotationStatus, fromStatuses ...CertRotationStatus) error {
+	q := dbi.Model((*Machine)(nil)).Set("cert_rotation_status = ?", status)
+	if len(fromStatuses) > 0 {
+		q = q.Where("cert_rotation_status IN (?)", pg.In(fromStatuses))
+	} else {
+		q = q.Where("TRUE")
+	}
+	_, err := q.Update()
 	if err != nil {
-		return pkgerrors.Wrapf(err, "problem with deleting machine %v", machine.ID)
-	} else if result.RowsAffected() <= 0 {
-		return pkgerrors.Wrapf(ErrNotExists, "machine with id %d does not exist", machine.ID)
+		return pkgerrors.Wrapf(err, "problem with updating CA rotation status of machines")
 	}
 	return nil
 }
+
+// Delete a machine from database. The agent certificate of the machine
+// is revoked in the same transaction, so the agent can't connect to the
+// server anymore.
+func DeleteMachine(db *pg.DB, machine *Machine) error {
+	return db.RunInTransaction(context.Background(), func(tx *pg.Tx) error {
+		result, err := tx.Model(machine).WherePK().Delete()
+		if err != nil {
+			return pkgerrors.Wrapf(err, "problem with deleting machine %v", machine.ID)
+		} else if result.RowsAffected() <= 0 {
+			return pkgerrors.Wrapf(ErrNotExists, "machine with id %d does not exist", machine.ID)
+		}
+		if revokedCert := NewRevokedCert(machine); revokedCert != nil {
+			return AddRevokedCert(tx, revokedCert)
+		}
+		return nil
+	})
+}
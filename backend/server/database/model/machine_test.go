@@ -3,6 +3,7 @@ package dbmodel
 import (
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 	dbtest "isc.org/stork/server/database/test"
@@ -668,3 +669,56 @@ func TestGetAllMachines(t *testing.T) {
 	require.Len(t, machines, 10)
 	require.EqualValues(t, 20, total)
 }
+
+// Check if the machine with the agent certificate expiring soon is detected.
+func TestIsAgentCertExpiring(t *testing.T) {
+	now := time.Now()
+	m := &Machine{}
+	require.False(t, m.IsAgentCertExpiring(now))
+
+	m.State.AgentCertExpiresAt = now.Add(AgentCertExpiringPeriod + time.Hour)
+	require.False(t, m.IsAgentCertExpiring(now))
+
+	m.State.AgentCertExpiresAt = now.Add(time.Hour)
+	require.True(t, m.IsAgentCertExpiring(now))
+
+	m.State.AgentCertExpiresAt = now.Add(-time.Hour)
+	require.True(t, m.IsAgentCertExpiring(now))
+}
+
+// Check if the CA rotation status of the machines is updated.
+func TestUpdateMachinesCertRotationStatus(t *testing.T) {
+	db, _, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	for i := 1; i <= 2; i++ {
+		m := &Machine{
+			Address:   fmt.Sprintf("192.0.2.%d", i),
+			AgentPort: 8080,
+		}
+		err := AddMachine(db, m)
+		require.NoError(t, err)
+	}
+
+	// Update all machines.
+	err := UpdateMachinesCertRotationStatus(db, CertRotationPending)
+	require.NoError(t, err)
+
+	m, err := GetMachineByAddressAndAgentPort(db, "192.0.2.1", 8080)
+	require.NoError(t, err)
+	require.Equal(t, CertRotationPending, m.CertRotationStatus)
+	m.CertRotationStatus = CertRotationReissued
+	err = UpdateMachine(db, m)
+	require.NoError(t, err)
+
+	// Update only the machines with the specified status.
+	err = UpdateMachinesCertRotationStatus(db, CertRotationRetiring, CertRotationReissued)
+	require.NoError(t, err)
+
+	m, err = GetMachineByAddressAndAgentPort(db, "192.0.2.1", 8080)
+	require.NoError(t, err)
+	require.Equal(t, CertRotationRetiring, m.CertRotationStatus)
+	m, err = GetMachineByAddressAndAgentPort(db, "192.0.2.2", 8080)
+	require.NoError(t, err)
+	require.Equal(t, CertRotationPending, m.CertRotationStatus)
+}
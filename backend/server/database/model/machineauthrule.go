@@ -0,0 +1,194 @@
+package dbmodel
+
+import (
+	"errors"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+	pkgerrors "github.com/pkg/errors"
+	dbops "isc.org/stork/server/database"
+)
+
+// Rule authorizing the machines registering without the server token.
+// The machine is authorized when it matches all criteria specified in
+// any enabled rule. The hostname and the agent token are sent by the
+// agent, so they don't authorize the machine on their own. The rule must
+// specify the source network or the CSR fingerprint.
+type MachineAuthorizationRule struct {
+	ID        int64
+	CreatedAt time.Time
+	Name      string
+	Enabled   bool `pg:",use_zero"`
+	// Network from which the registration request must be sent. It is
+	// matched against the address of the peer sending the request to the
+	// server, i.e. the address of the reverse proxy if the server is
+	// behind the proxy.
+	SourceCIDR string `pg:"source_cidr"`
+	// Regular expression matching the address of the machine, which is
+	// typically its hostname.
+	HostnamePattern string
+	// Regular expression matching the agent token.
+	AgentTokenPattern string
+	// SHA-256 fingerprint of the public key in the agent CSR in the
+	// hexadecimal form. The key is generated by the agent before the
+	// registration and doesn't change when the CSR is regenerated, so
+	// it can be registered in advance.
+	CSRFingerprint string `pg:"csr_fingerprint"`
+}
+
+// Information about the registering machine matched against the rules.
+type MachineRegistration struct {
+	// Address from which the registration request has been sent. It is
+	// taken from the RemoteAddr of the HTTP request, so it is the address
+	// of the reverse proxy if the server is behind the proxy.
+	SourceAddress string
+	// Address of the machine.
+	Address string
+	// Agent token sent by the agent.
+	AgentToken string
+	// SHA-256 fingerprint of the public key in the agent CSR.
+	CSRFingerprint string
+}
+
+// Checks if the rule has the required criteria and all criteria are valid.
+func (rule *MachineAuthorizationRule) Validate() error {
+	if rule.Name == "" {
+		return errors.New("authorization rule name must not be empty")
+	}
+	if !rule.hasRequiredCriteria() {
+		return errors.New("authorization rule must specify the source CIDR or the CSR fingerprint")
+	}
+	if rule.SourceCIDR != "" {
+		if _, _, err := net.ParseCIDR(rule.SourceCIDR); err != nil {
+			return pkgerrors.Wrapf(err, "invalid source CIDR %s", rule.SourceCIDR)
+		}
+	}
+	if rule.HostnamePattern != "" {
+		if _, err := regexp.Compile(rule.HostnamePattern); err != nil {
+			return pkgerrors.Wrapf(err, "invalid hostname pattern %s", rule.HostnamePattern)
+		}
+	}
+	if rule.AgentTokenPattern != "" {
+		if _, err := regexp.Compile(rule.AgentTokenPattern); err != nil {
+			return pkgerrors.Wrapf(err, "invalid agent token pattern %s", rule.AgentTokenPattern)
+		}
+	}
+	return nil
+}
+
+// Checks if the rule specifies the source CIDR or the CSR fingerprint.
+// These criteria can't be chosen by the registering agent.
+func (rule *MachineAuthorizationRule) hasRequiredCriteria() bool {
+	return rule.SourceCIDR != "" || rule.CSRFingerprint != ""
+}
+
+// Checks if the pattern matches the whole value. The invalid pattern
+// matches no value.
+func matchesWholeValue(pattern, value string) bool {
+	re, err := regexp.Compile("^(?:" + pattern + ")$")
+	if err != nil {
+		return false
+	}
+	return re.MatchString(value)
+}
+
+// Checks if the registering machine matches all criteria of the rule.
+// The disabled or invalid rule matches no machine. The patterns must
+// match the whole hostname or agent token.
+func (rule *MachineAuthorizationRule) Matches(registration *MachineRegistration) bool {
+	if !rule.Enabled || !rule.hasRequiredCriteria() {
+		return false
+	}
+	if rule.SourceCIDR != "" {
+		_, network, err := net.ParseCIDR(rule.SourceCIDR)
+		if err != nil {
+			return false
+		}
+		ip := net.ParseIP(registration.SourceAddress)
+		if ip == nil || !network.Contains(ip) {
+			return false
+		}
+	}
+	if rule.HostnamePattern != "" && !matchesWholeValue(rule.HostnamePattern, registration.Address) {
+		return false
+	}
+	if rule.AgentTokenPattern != "" && !matchesWholeValue(rule.AgentTokenPattern, registration.AgentToken) {
+		return false
+	}
+	if rule.CSRFingerprint != "" && !strings.EqualFold(rule.CSRFingerprint, registration.CSRFingerprint) {
+		return false
+	}
+	return true
+}
+
+// Add new authorization rule to the database.
+func AddMachineAuthorizationRule(db *pg.DB, rule *MachineAuthorizationRule) error {
+	_, err := db.Model(rule).Insert()
+	if err != nil {
+		return pkgerrors.Wrapf(err, "problem with inserting authorization rule %s", rule.Name)
+	}
+	return nil
+}
+
+// Update authorization rule in the database.
+func UpdateMachineAuthorizationRule(db *pg.DB, rule *MachineAuthorizationRule) error {
+	result, err := db.Model(rule).WherePK().ExcludeColumn("created_at").Update()
+	if err != nil {
+		return pkgerrors.Wrapf(err, "problem with updating authorization rule %d", rule.ID)
+	} else if result.RowsAffected() <= 0 {
+		return pkgerrors.Wrapf(ErrNotExists, "authorization rule with id %d does not exist", rule.ID)
+	}
+	return nil
+}
+
+// Get authorization rule by ID. It returns nil if the rule doesn't exist.
+func GetMachineAuthorizationRuleByID(db *pg.DB, id int64) (*MachineAuthorizationRule, error) {
+	rule := &MachineAuthorizationRule{}
+	err := db.Model(rule).Where("id = ?", id).Select()
+	if errors.Is(err, pg.ErrNoRows) {
+		return nil, nil
+	} else if err != nil {
+		return nil, pkgerrors.Wrapf(err, "problem with getting authorization rule %d", id)
+	}
+	return rule, nil
+}
+
+// Get all authorization rules ordered by ID.
+func GetMachineAuthorizationRules(dbi dbops.DBI) ([]MachineAuthorizationRule, error) {
+	rules := []MachineAuthorizationRule{}
+	err := dbi.Model(&rules).OrderExpr("id ASC").Select()
+	if err != nil {
+		return nil, pkgerrors.Wrapf(err, "problem with getting authorization rules")
+	}
+	return rules, nil
+}
+
+// Delete authorization rule from the database.
+func DeleteMachineAuthorizationRule(db *pg.DB, id int64) error {
+	rule := &MachineAuthorizationRule{ID: id}
+	result, err := db.Model(rule).WherePK().Delete()
+	if err != nil {
+		return pkgerrors.Wrapf(err, "problem with deleting authorization rule %d", id)
+	} else if result.RowsAffected() <= 0 {
+		return pkgerrors.Wrapf(ErrNotExists, "authorization rule with id %d does not exist", id)
+	}
+	return nil
+}
+
+// Returns the first enabled rule matching the registering machine or nil
+// if there is no such rule.
+func FindMatchingMachineAuthorizationRule(dbi dbops.DBI, registration *MachineRegistration) (*MachineAuthorizationRule, error) {
+	rules, err := GetMachineAuthorizationRules(dbi)
+	if err != nil {
+		return nil, err
+	}
+	for i := range rules {
+		if rules[i].Matches(registration) {
+			return &rules[i], nil
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,172 @@
+package dbmodel
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	dbtest "isc.org/stork/server/database/test"
+)
+
+// Check that the rule without criteria or with invalid criteria is rejected.
+func TestMachineAuthorizationRuleValidate(t *testing.T) {
+	rule := &MachineAuthorizationRule{}
+	require.Error(t, rule.Validate())
+
+	rule.Name = "rule"
+	require.Error(t, rule.Validate())
+
+	// The criteria specified by the agent are not sufficient.
+	rule.HostnamePattern = "agent-[0-9]+"
+	rule.AgentTokenPattern = "[A-F0-9]+"
+	require.Error(t, rule.Validate())
+	rule.HostnamePattern = ""
+	rule.AgentTokenPattern = ""
+
+	rule.CSRFingerprint = "0102ab"
+	require.NoError(t, rule.Validate())
+	rule.CSRFingerprint = ""
+
+	rule.SourceCIDR = "192.0.2.0/33"
+	require.Error(t, rule.Validate())
+	rule.SourceCIDR = "192.0.2.0/24"
+	require.NoError(t, rule.Validate())
+
+	rule.HostnamePattern = "agent-[0-9"
+	require.Error(t, rule.Validate())
+	rule.HostnamePattern = "agent-[0-9]+"
+	require.NoError(t, rule.Validate())
+
+	rule.AgentTokenPattern = "("
+	require.Error(t, rule.Validate())
+	rule.AgentTokenPattern = "[A-F0-9]+"
+	require.NoError(t, rule.Validate())
+}
+
+// Check that the registering machine must match all criteria of the rule.
+func TestMachineAuthorizationRuleMatches(t *testing.T) {
+	registration := &MachineRegistration{
+		SourceAddress:  "192.0.2.10",
+		Address:        "agent-1.example.org",
+		AgentToken:     "ABCDEF",
+		CSRFingerprint: "0102AB",
+	}
+
+	rule := &MachineAuthorizationRule{
+		Name:       "rule",
+		SourceCIDR: "192.0.2.0/24",
+	}
+	// Disabled rule matches nothing.
+	require.False(t, rule.Matches(registration))
+	rule.Enabled = true
+	require.True(t, rule.Matches(registration))
+
+	rule.SourceCIDR = "198.51.100.0/24"
+	require.False(t, rule.Matches(registration))
+	rule.SourceCIDR = "192.0.2.0/24"
+
+	// The pattern must match the whole hostname.
+	rule.HostnamePattern = "agent-[0-9]+"
+	require.False(t, rule.Matches(registration))
+	rule.HostnamePattern = `agent-[0-9]+\.example\.org`
+	require.True(t, rule.Matches(registration))
+
+	rule.AgentTokenPattern = "[0-9]+"
+	require.False(t, rule.Matches(registration))
+	rule.AgentTokenPattern = "[A-F]+"
+	require.True(t, rule.Matches(registration))
+
+	// The fingerprint comparison is case insensitive.
+	rule.CSRFingerprint = "0102ac"
+	require.False(t, rule.Matches(registration))
+	rule.CSRFingerprint = "0102ab"
+	require.True(t, rule.Matches(registration))
+
+	// Invalid pattern matches nothing.
+	rule.AgentTokenPattern = "("
+	require.False(t, rule.Matches(registration))
+	rule.AgentTokenPattern = "[A-F]+"
+
+	// Rule without the source CIDR and the CSR fingerprint matches nothing.
+	rule.SourceCIDR = ""
+	rule.CSRFingerprint = ""
+	require.False(t, rule.Matches(registration))
+	rule.SourceCIDR = "192.0.2.0/24"
+
+	// Invalid source address never matches the CIDR.
+	registration.SourceAddress = ""
+	require.False(t, rule.Matches(registration))
+}
+
+// Check adding, updating, getting and deleting the authorization rules.
+func TestMachineAuthorizationRules(t *testing.T) {
+	db, _, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	rules, err := GetMachineAuthorizationRules(db)
+	require.NoError(t, err)
+	require.Empty(t, rules)
+
+	rule1 := &MachineAuthorizationRule{
+		Name:       "lab",
+		Enabled:    true,
+		SourceCIDR: "192.0.2.0/24",
+	}
+	require.NoError(t, AddMachineAuthorizationRule(db, rule1))
+	require.NotZero(t, rule1.ID)
+
+	rule2 := &MachineAuthorizationRule{
+		Name:            "hosts",
+		Enabled:         false,
+		HostnamePattern: "agent-.*",
+		CSRFingerprint:  "0102ab",
+	}
+	require.NoError(t, AddMachineAuthorizationRule(db, rule2))
+
+	// The name must be unique.
+	require.Error(t, AddMachineAuthorizationRule(db, &MachineAuthorizationRule{
+		Name:       "lab",
+		SourceCIDR: "198.51.100.0/24",
+	}))
+
+	rules, err = GetMachineAuthorizationRules(db)
+	require.NoError(t, err)
+	require.Len(t, rules, 2)
+	require.Equal(t, "lab", rules[0].Name)
+	require.Equal(t, "hosts", rules[1].Name)
+	require.False(t, rules[1].Enabled)
+	require.False(t, rules[0].CreatedAt.IsZero())
+
+	// Only the enabled rule can match.
+	registration := &MachineRegistration{
+		SourceAddress:  "198.51.100.1",
+		Address:        "agent-1",
+		CSRFingerprint: "0102AB",
+	}
+	rule, err := FindMatchingMachineAuthorizationRule(db, registration)
+	require.NoError(t, err)
+	require.Nil(t, rule)
+
+	rule2.Enabled = true
+	require.NoError(t, UpdateMachineAuthorizationRule(db, rule2))
+	rule, err = FindMatchingMachineAuthorizationRule(db, registration)
+	require.NoError(t, err)
+	require.NotNil(t, rule)
+	require.Equal(t, rule2.ID, rule.ID)
+	require.False(t, rule.CreatedAt.IsZero())
+
+	rule, err = GetMachineAuthorizationRuleByID(db, rule1.ID)
+	require.NoError(t, err)
+	require.NotNil(t, rule)
+	require.Equal(t, "192.0.2.0/24", rule.SourceCIDR)
+
+	require.NoError(t, DeleteMachineAuthorizationRule(db, rule1.ID))
+	err = DeleteMachineAuthorizationRule(db, rule1.ID)
+	require.True(t, errors.Is(err, ErrNotExists))
+	err = UpdateMachineAuthorizationRule(db, rule1)
+	require.True(t, errors.Is(err, ErrNotExists))
+
+	rule, err = GetMachineAuthorizationRuleByID(db, rule1.ID)
+	require.NoError(t, err)
+	require.Nil(t, rule)
+}
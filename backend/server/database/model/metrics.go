@@ -15,6 +15,32 @@ type CalculatedNetworkMetrics struct {
 	PdUtilization int16
 }
 
+// Metric values of the High Availability service.
+type CalculatedHAServiceMetrics struct {
+	// Service name.
+	Name                     string
+	PrimaryLastState         string
+	SecondaryLastState       string
+	PrimaryReachable         bool
+	SecondaryReachable       bool
+	PrimaryCommInterrupted   *bool
+	SecondaryCommInterrupted *bool
+	PrimaryUnackedClients    int64
+	SecondaryUnackedClients  int64
+}
+
+// Number of the unresolved config reports created by the checker.
+type CalculatedConfigReportMetrics struct {
+	CheckerName string
+	Count       int64
+}
+
+// Number of the events with the level.
+type CalculatedEventMetrics struct {
+	Level int
+	Count int64
+}
+
 // Metric values calculated from the database.
 type CalculatedMetrics struct {
 	AuthorizedMachines   int64
@@ -22,6 +48,9 @@ type CalculatedMetrics struct {
 	UnreachableMachines  int64
 	SubnetMetrics        []CalculatedNetworkMetrics
 	SharedNetworkMetrics []CalculatedNetworkMetrics
+	HAServiceMetrics     []CalculatedHAServiceMetrics
+	ConfigReportMetrics  []CalculatedConfigReportMetrics
+	EventMetrics         []CalculatedEventMetrics
 }
 
 // Calculates various metrics using several SELECT queries.
@@ -57,5 +86,43 @@ func GetCalculatedMetrics(db *pg.DB) (*CalculatedMetrics, error) {
 		return nil, errors.Wrap(err, "cannot calculate shared network metrics")
 	}
 
+	err = db.Model().
+		Table("ha_service").
+		Join("INNER JOIN service ON service.id = ha_service.service_id").
+		ColumnExpr("service.name AS \"name\"").
+		Column("primary_last_state", "secondary_last_state",
+			"primary_reachable", "secondary_reachable",
+			"primary_comm_interrupted", "secondary_comm_interrupted",
+			"primary_unacked_clients", "secondary_unacked_clients").
+		OrderExpr("service.id ASC").
+		Select(&metrics.HAServiceMetrics)
+
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot calculate HA service metrics")
+	}
+
+	err = db.Model().
+		Table("config_report").
+		Column("checker_name").
+		ColumnExpr("COUNT(*) AS \"count\"").
+		Where("resolved_at IS NULL").
+		Group("checker_name").
+		Select(&metrics.ConfigReportMetrics)
+
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot calculate config report metrics")
+	}
+
+	err = db.Model().
+		Table("event").
+		Column("level").
+		ColumnExpr("COUNT(*) AS \"count\"").
+		Group("level").
+		Select(&metrics.EventMetrics)
+
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot calculate event metrics")
+	}
+
 	return &metrics, nil
 }
@@ -137,3 +137,61 @@ func TestFilledSharedNetworksDatabaseMetrics(t *testing.T) {
 	require.Zero(t, metrics.SharedNetworkMetrics[2].AddrUtilization)
 	require.Zero(t, metrics.SharedNetworkMetrics[2].PdUtilization)
 }
+
+// Metrics of the HA services, config reports and events should be
+// properly calculated.
+func TestFilledHAServicesReportsEventsDatabaseMetrics(t *testing.T) {
+	// Arrange
+	db, _, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	services := addTestServices(t, db)
+	daemon := services[1].Daemons[0]
+
+	for i := 0; i < 3; i++ {
+		err := AddConfigReport(db, &ConfigReport{
+			CheckerName: "stat_cmds_presence",
+			Content:     "test report",
+			DaemonID:    daemon.ID,
+		})
+		require.NoError(t, err)
+	}
+	err := AddConfigReport(db, &ConfigReport{
+		CheckerName: "host_cmds_presence",
+		Content:     "test report",
+		DaemonID:    daemon.ID,
+	})
+	require.NoError(t, err)
+
+	_ = AddEvent(db, &Event{Text: "info", Level: EvInfo})
+	_ = AddEvent(db, &Event{Text: "error", Level: EvError})
+	_ = AddEvent(db, &Event{Text: "error", Level: EvError})
+
+	// Act
+	metrics, err := GetCalculatedMetrics(db)
+
+	// Assert
+	require.NoError(t, err)
+
+	// Only the second service is the HA service.
+	require.Len(t, metrics.HAServiceMetrics, 1)
+	haMetrics := metrics.HAServiceMetrics[0]
+	require.Equal(t, "service2", haMetrics.Name)
+	require.Equal(t, "load-balancing", haMetrics.PrimaryLastState)
+	require.Equal(t, "syncing", haMetrics.SecondaryLastState)
+	require.NotNil(t, haMetrics.PrimaryCommInterrupted)
+	require.True(t, *haMetrics.PrimaryCommInterrupted)
+	require.NotNil(t, haMetrics.SecondaryCommInterrupted)
+	require.False(t, *haMetrics.SecondaryCommInterrupted)
+	require.EqualValues(t, 2, haMetrics.PrimaryUnackedClients)
+
+	require.ElementsMatch(t, []CalculatedConfigReportMetrics{
+		{CheckerName: "stat_cmds_presence", Count: 3},
+		{CheckerName: "host_cmds_presence", Count: 1},
+	}, metrics.ConfigReportMetrics)
+
+	require.ElementsMatch(t, []CalculatedEventMetrics{
+		{Level: EvInfo, Count: 1},
+		{Level: EvError, Count: 2},
+	}, metrics.EventMetrics)
+}
@@ -0,0 +1,226 @@
+package dbmodel
+
+import (
+	"context"
+	"errors"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/go-pg/pg/v10/orm"
+	pkgerrors "github.com/pkg/errors"
+	keaconfig "isc.org/stork/appcfg/kea"
+	dbops "isc.org/stork/server/database"
+)
+
+// Represents a DHCP option extracted from the Kea daemon configuration.
+// The scope designates the configuration level at which the option is
+// specified. The client class, shared network, subnet prefix, local
+// subnet id, pool and host identifier are set depending on the scope.
+// If the option code is not specified in the configuration, it is
+// found by name among the option definitions and the standard options.
+type KeaOptionData struct {
+	ID             int64
+	DaemonID       int64
+	Daemon         *Daemon `pg:"rel:has-one"`
+	Scope          string
+	ClientClass    string
+	SharedNetwork  string
+	SubnetPrefix   string
+	LocalSubnetID  int64
+	Pool           string
+	HostIdentifier string
+	Space          string
+	Code           *int64
+	Name           string
+	Data           string
+	CSVFormat      *bool `pg:"csv_format"`
+	AlwaysSend     bool  `pg:",use_zero"`
+}
+
+// Represents a DHCP option definition extracted from the Kea daemon
+// configuration. The definitions are specified globally or in the
+// client classes.
+type KeaOptionDef struct {
+	ID          int64
+	DaemonID    int64
+	Scope       string
+	ClientClass string
+	Space       string
+	Code        int64 `pg:",use_zero"`
+	Name        string
+	Type        string
+	IsArray     bool `pg:",use_zero"`
+	RecordTypes string
+	Encapsulate string
+}
+
+// Returns the string value or empty string if the pointer is nil.
+func stringOrEmpty(value *string) string {
+	if value == nil {
+		return ""
+	}
+	return *value
+}
+
+// Extracts the options and option definitions from the Kea daemon
+// configuration and stores them in the database. The options and
+// option definitions previously stored for the daemon are removed.
+// The configurations of the daemons other than DHCP servers are
+// ignored.
+func commitKeaOptionsIntoDB(tx *pg.Tx, daemon *Daemon) error {
+	_, err := tx.Model((*KeaOptionData)(nil)).Where("daemon_id = ?", daemon.ID).Delete()
+	if err != nil {
+		return pkgerrors.Wrapf(err, "problem with deleting options for daemon %d", daemon.ID)
+	}
+	_, err = tx.Model((*KeaOptionDef)(nil)).Where("daemon_id = ?", daemon.ID).Delete()
+	if err != nil {
+		return pkgerrors.Wrapf(err, "problem with deleting option definitions for daemon %d", daemon.ID)
+	}
+
+	if daemon.KeaDaemon == nil || daemon.KeaDaemon.Config == nil {
+		return nil
+	}
+	rootName, ok := daemon.KeaDaemon.Config.GetRootName()
+	if !ok || (rootName != keaconfig.RootNameDHCPv4 && rootName != keaconfig.RootNameDHCPv6) {
+		return nil
+	}
+	config, err := daemon.KeaDaemon.Config.DecodeDHCPConfig()
+	if err != nil {
+		return pkgerrors.WithMessagef(err, "problem with parsing the configuration of daemon %d", daemon.ID)
+	}
+
+	var options []KeaOptionData
+	for _, scoped := range config.GetAllOptionData() {
+		space, code := config.GetOptionSpaceAndCode(rootName, scoped.Option)
+		option := KeaOptionData{
+			DaemonID:       daemon.ID,
+			Scope:          string(scoped.Scope),
+			ClientClass:    scoped.ClientClass,
+			SharedNetwork:  scoped.SharedNetwork,
+			SubnetPrefix:   scoped.Subnet,
+			LocalSubnetID:  scoped.SubnetID,
+			Pool:           scoped.Pool,
+			HostIdentifier: scoped.HostIdentifier,
+			Space:          space,
+			Code:           code,
+			Name:           stringOrEmpty(scoped.Option.Name),
+			Data:           stringOrEmpty(scoped.Option.Data),
+			CSVFormat:      scoped.Option.CSVFormat,
+			AlwaysSend:     scoped.Option.AlwaysSend != nil && *scoped.Option.AlwaysSend,
+		}
+		options = append(options, option)
+	}
+	if len(options) > 0 {
+		if _, err = tx.Model(&options).Insert(); err != nil {
+			return pkgerrors.Wrapf(err, "problem with inserting options for daemon %d", daemon.ID)
+		}
+	}
+
+	var defs []KeaOptionDef
+	for _, scoped := range config.GetAllOptionDefs() {
+		space := "dhcp4"
+		if rootName == keaconfig.RootNameDHCPv6 {
+			space = "dhcp6"
+		}
+		if scoped.Definition.Space != nil && *scoped.Definition.Space != "" {
+			space = *scoped.Definition.Space
+		}
+		def := KeaOptionDef{
+			DaemonID:    daemon.ID,
+			Scope:       string(scoped.Scope),
+			ClientClass: scoped.ClientClass,
+			Space:       space,
+			Code:        scoped.Definition.Code,
+			Name:        scoped.Definition.Name,
+			Type:        scoped.Definition.Type,
+			IsArray:     scoped.Definition.Array != nil && *scoped.Definition.Array,
+			RecordTypes: stringOrEmpty(scoped.Definition.RecordTypes),
+			Encapsulate: stringOrEmpty(scoped.Definition.Encapsulate),
+		}
+		defs = append(defs, def)
+	}
+	if len(defs) > 0 {
+		if _, err = tx.Model(&defs).Insert(); err != nil {
+			return pkgerrors.Wrapf(err, "problem with inserting option definitions for daemon %d", daemon.ID)
+		}
+	}
+	return nil
+}
+
+// Extracts the options and option definitions from the Kea daemon
+// configuration and stores them in the database, replacing the ones
+// previously stored for the daemon.
+func CommitKeaOptionsIntoDB(dbi dbops.DBI, daemon *Daemon) error {
+	if db, ok := dbi.(*pg.DB); ok {
+		return db.RunInTransaction(context.Background(), func(tx *pg.Tx) error {
+			return commitKeaOptionsIntoDB(tx, daemon)
+		})
+	}
+	return commitKeaOptionsIntoDB(dbi.(*pg.Tx), daemon)
+}
+
+// Fetches a collection of the options from the database. The offset and
+// limit specify the beginning of the page and the maximum size of the
+// page. The daemonID, code, space and scope are optional filters. The
+// filterText is matched with the option name, data, client class, shared
+// network, subnet prefix, pool and host identifier. The sortField and
+// sortDir specify the sorting. Besides the options, it returns the total
+// number of options matching the filters.
+func GetKeaOptionDataByPage(dbi dbops.DBI, offset, limit, daemonID int64, code *int64, space, scope, filterText *string, sortField string, sortDir SortDirEnum) ([]KeaOptionData, int64, error) {
+	options := []KeaOptionData{}
+	q := dbi.Model(&options).
+		Relation("Daemon.App.Machine")
+
+	if daemonID != 0 {
+		q = q.Where("kea_option_data.daemon_id = ?", daemonID)
+	}
+	if code != nil {
+		q = q.Where("kea_option_data.code = ?", *code)
+	}
+	if space != nil {
+		q = q.Where("kea_option_data.space = ?", *space)
+	}
+	if scope != nil {
+		q = q.Where("kea_option_data.scope = ?", *scope)
+	}
+	if filterText != nil {
+		text := "%" + *filterText + "%"
+		q = q.WhereGroup(func(q *orm.Query) (*orm.Query, error) {
+			q = q.WhereOr("kea_option_data.name ILIKE ?", text).
+				WhereOr("kea_option_data.data ILIKE ?", text).
+				WhereOr("kea_option_data.client_class ILIKE ?", text).
+				WhereOr("kea_option_data.shared_network ILIKE ?", text).
+				WhereOr("kea_option_data.subnet_prefix ILIKE ?", text).
+				WhereOr("kea_option_data.pool ILIKE ?", text).
+				WhereOr("kea_option_data.host_identifier ILIKE ?", text)
+			return q, nil
+		})
+	}
+
+	q = q.OrderExpr(prepareOrderExpr("kea_option_data", sortField, sortDir)).
+		Offset(int(offset))
+	if limit != 0 {
+		q = q.Limit(int(limit))
+	}
+
+	total, err := q.SelectAndCount()
+	if err != nil {
+		if errors.Is(err, pg.ErrNoRows) {
+			return options, 0, nil
+		}
+		return nil, 0, pkgerrors.Wrapf(err, "problem with getting options by page")
+	}
+	return options, int64(total), nil
+}
+
+// Fetches all option definitions stored for the specified daemon.
+func GetKeaOptionDefsByDaemonID(dbi dbops.DBI, daemonID int64) ([]KeaOptionDef, error) {
+	defs := []KeaOptionDef{}
+	err := dbi.Model(&defs).
+		Where("daemon_id = ?", daemonID).
+		OrderExpr("id ASC").
+		Select()
+	if err != nil && !errors.Is(err, pg.ErrNoRows) {
+		return nil, pkgerrors.Wrapf(err, "problem with getting option definitions for daemon %d", daemonID)
+	}
+	return defs, nil
+}
@@ -0,0 +1,180 @@
+package dbmodel
+
+import (
+	"testing"
+
+	require "github.com/stretchr/testify/require"
+	dbtest "isc.org/stork/server/database/test"
+)
+
+// Test that the options and option definitions are extracted from the
+// Kea configuration, stored in the database and can be searched.
+func TestCommitKeaOptionsIntoDB(t *testing.T) {
+	db, _, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	machine := &Machine{
+		Address:   "localhost",
+		AgentPort: 8080,
+	}
+	err := AddMachine(db, machine)
+	require.NoError(t, err)
+
+	daemon := NewKeaDaemon("dhcp4", true)
+	err = daemon.SetConfigFromJSON(`{
+        "Dhcp4": {
+            "option-def": [
+                {
+                    "name": "foo-option",
+                    "code": 222,
+                    "type": "string",
+                    "array": true
+                }
+            ],
+            "option-data": [
+                {
+                    "name": "domain-name-servers",
+                    "data": "192.0.2.1",
+                    "always-send": true
+                },
+                {
+                    "name": "foo-option",
+                    "data": "foo"
+                }
+            ],
+            "subnet4": [
+                {
+                    "id": 1,
+                    "subnet": "192.0.2.0/24",
+                    "pools": [
+                        {
+                            "pool": "192.0.2.10-192.0.2.20",
+                            "option-data": [
+                                {
+                                    "code": 3,
+                                    "data": "192.0.2.254",
+                                    "csv-format": false
+                                }
+                            ]
+                        }
+                    ]
+                }
+            ]
+        }
+    }`)
+	require.NoError(t, err)
+	ca := NewKeaDaemon("ca", true)
+	err = ca.SetConfigFromJSON(`{"Control-agent": {}}`)
+	require.NoError(t, err)
+	app := &App{
+		Type:      AppTypeKea,
+		MachineID: machine.ID,
+		Daemons:   []*Daemon{daemon, ca},
+	}
+	_, err = AddApp(db, app)
+	require.NoError(t, err)
+
+	err = CommitKeaOptionsIntoDB(db, daemon)
+	require.NoError(t, err)
+	err = CommitKeaOptionsIntoDB(db, ca)
+	require.NoError(t, err)
+
+	// Get all options.
+	options, total, err := GetKeaOptionDataByPage(db, 0, 10, 0, nil, nil, nil, nil, "", SortDirAny)
+	require.NoError(t, err)
+	require.EqualValues(t, 3, total)
+	require.Len(t, options, 3)
+
+	require.Equal(t, "global", options[0].Scope)
+	require.Equal(t, "dhcp4", options[0].Space)
+	require.NotNil(t, options[0].Code)
+	require.EqualValues(t, 6, *options[0].Code)
+	require.Equal(t, "domain-name-servers", options[0].Name)
+	require.True(t, options[0].AlwaysSend)
+	require.NotNil(t, options[0].Daemon)
+	require.NotNil(t, options[0].Daemon.App)
+	require.NotNil(t, options[0].Daemon.App.Machine)
+
+	// The code is found in the option definitions.
+	require.NotNil(t, options[1].Code)
+	require.EqualValues(t, 222, *options[1].Code)
+
+	require.Equal(t, "pool", options[2].Scope)
+	require.Equal(t, "192.0.2.0/24", options[2].SubnetPrefix)
+	require.EqualValues(t, 1, options[2].LocalSubnetID)
+	require.Equal(t, "192.0.2.10-192.0.2.20", options[2].Pool)
+	require.NotNil(t, options[2].CSVFormat)
+	require.False(t, *options[2].CSVFormat)
+
+	// Filter by code.
+	code := int64(3)
+	options, total, err = GetKeaOptionDataByPage(db, 0, 10, daemon.ID, &code, nil, nil, nil, "", SortDirAny)
+	require.NoError(t, err)
+	require.EqualValues(t, 1, total)
+	require.Len(t, options, 1)
+	require.Equal(t, "192.0.2.254", options[0].Data)
+
+	// Filter by scope.
+	scope := "global"
+	_, total, err = GetKeaOptionDataByPage(db, 0, 10, 0, nil, nil, &scope, nil, "", SortDirAny)
+	require.NoError(t, err)
+	require.EqualValues(t, 2, total)
+
+	// Filter by text.
+	text := "2.254"
+	options, total, err = GetKeaOptionDataByPage(db, 0, 10, 0, nil, nil, nil, &text, "", SortDirAny)
+	require.NoError(t, err)
+	require.EqualValues(t, 1, total)
+	require.EqualValues(t, 3, *options[0].Code)
+
+	// Paging.
+	options, total, err = GetKeaOptionDataByPage(db, 1, 1, 0, nil, nil, nil, nil, "", SortDirAny)
+	require.NoError(t, err)
+	require.EqualValues(t, 3, total)
+	require.Len(t, options, 1)
+	require.EqualValues(t, 222, *options[0].Code)
+
+	// Option definitions.
+	defs, err := GetKeaOptionDefsByDaemonID(db, daemon.ID)
+	require.NoError(t, err)
+	require.Len(t, defs, 1)
+	require.Equal(t, "foo-option", defs[0].Name)
+	require.EqualValues(t, 222, defs[0].Code)
+	require.Equal(t, "dhcp4", defs[0].Space)
+	require.True(t, defs[0].IsArray)
+
+	defs, err = GetKeaOptionDefsByDaemonID(db, ca.ID)
+	require.NoError(t, err)
+	require.Empty(t, defs)
+
+	// Committing the options again replaces the existing ones.
+	err = daemon.SetConfigFromJSON(`{
+        "Dhcp4": {
+            "option-data": [
+                {
+                    "code": 15,
+                    "data": "example.org"
+                }
+            ]
+        }
+    }`)
+	require.NoError(t, err)
+	err = CommitKeaOptionsIntoDB(db, daemon)
+	require.NoError(t, err)
+
+	options, total, err = GetKeaOptionDataByPage(db, 0, 10, daemon.ID, nil, nil, nil, nil, "", SortDirAny)
+	require.NoError(t, err)
+	require.EqualValues(t, 1, total)
+	require.EqualValues(t, 15, *options[0].Code)
+
+	defs, err = GetKeaOptionDefsByDaemonID(db, daemon.ID)
+	require.NoError(t, err)
+	require.Empty(t, defs)
+
+	// Deleting the daemon deletes the options.
+	err = DeleteApp(db, app)
+	require.NoError(t, err)
+	_, total, err = GetKeaOptionDataByPage(db, 0, 10, 0, nil, nil, nil, nil, "", SortDirAny)
+	require.NoError(t, err)
+	require.Zero(t, total)
+}
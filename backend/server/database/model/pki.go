@@ -5,6 +5,7 @@ import (
 
 	"github.com/go-pg/pg/v10"
 	pkgerrors "github.com/pkg/errors"
+	dbops "isc.org/stork/server/database"
 )
 
 // Secret types.
@@ -16,6 +17,17 @@ const (
 	SecretServerToken = "srvtkn"
 )
 
+// Secrets holding the new root CA key and cert, and the server key and
+// cert signed by the new CA. They are present while the CA rotation is
+// in progress. The new CA is trusted alongside the current one until
+// the rotation is finished.
+const (
+	SecretCAKeyNext      = "cakeynext"
+	SecretCACertNext     = "cacertnext"
+	SecretServerKeyNext  = "srvkeynext"
+	SecretServerCertNext = "srvcertnext"
+)
+
 // Structure holding named secret.
 type Secret struct {
 	Name    string
@@ -30,9 +42,9 @@ func GetNewCertSerialNumber(db *pg.DB) (int64, error) {
 }
 
 // Get named secret from database.
-func GetSecret(db *pg.DB, name string) ([]byte, error) {
+func GetSecret(dbi dbops.DBI, name string) ([]byte, error) {
 	secret := Secret{}
-	q := db.Model(&secret)
+	q := dbi.Model(&secret)
 	q = q.Where("secret.name = ?", name)
 	err := q.Select()
 	if errors.Is(err, pg.ErrNoRows) {
@@ -44,14 +56,27 @@ func GetSecret(db *pg.DB, name string) ([]byte, error) {
 }
 
 // Set secret in database under given name.
-func SetSecret(db *pg.DB, name string, content []byte) error {
+func SetSecret(dbi dbops.DBI, name string, content []byte) error {
 	secret := &Secret{
 		Name:    name,
 		Content: string(content),
 	}
-	q := db.Model(secret)
+	q := dbi.Model(secret)
 	q = q.OnConflict("(name) DO UPDATE")
 	q = q.Set("content = EXCLUDED.content")
 	_, err := q.Insert()
 	return err
 }
+
+// Delete named secret from database. It is not an error if the secret
+// does not exist.
+func DeleteSecret(dbi dbops.DBI, name string) error {
+	secret := &Secret{
+		Name: name,
+	}
+	_, err := dbi.Model(secret).Where("name = ?name").Delete()
+	if err != nil {
+		return pkgerrors.Wrapf(err, "problem with deleting secret: %s", name)
+	}
+	return nil
+}
@@ -49,3 +49,23 @@ func TestGetSetSecrets(t *testing.T) {
 		require.EqualValues(t, "content", string(val))
 	}
 }
+
+// Check if the secret can be deleted.
+func TestDeleteSecret(t *testing.T) {
+	db, _, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	err := SetSecret(db, SecretCACertNext, []byte("content"))
+	require.NoError(t, err)
+
+	err = DeleteSecret(db, SecretCACertNext)
+	require.NoError(t, err)
+
+	val, err := GetSecret(db, SecretCACertNext)
+	require.NoError(t, err)
+	require.Nil(t, val)
+
+	// Deleting non-existing secret is not an error.
+	err = DeleteSecret(db, SecretCACertNext)
+	require.NoError(t, err)
+}
@@ -0,0 +1,131 @@
+package dbmodel
+
+import (
+	"errors"
+
+	"github.com/go-pg/pg/v10"
+	pkgerrors "github.com/pkg/errors"
+	dbops "isc.org/stork/server/database"
+)
+
+// Names of the global puller interval settings which can be overridden
+// for the particular machines and apps.
+var PullerIntervalSettingNames = []string{ // nolint:gochecknoglobals
+	"bind9_stats_puller_interval",
+	"kea_hosts_puller_interval",
+	"kea_stats_puller_interval",
+	"kea_status_puller_interval",
+}
+
+// Override of the global puller interval for a machine or an app. The
+// puller is identified by the name of its global interval setting, e.g.
+// kea_hosts_puller_interval. The override specified for the app takes
+// precedence over the override specified for the machine running the
+// app. The interval of 0 excludes the machine or the app from pulling.
+type PullerInterval struct {
+	ID     int64
+	Puller string
+	// Either the machine ID or the app ID is set.
+	MachineID int64
+	AppID     int64
+	// Interval in seconds.
+	Interval int64 `pg:",use_zero"`
+}
+
+// Checks if the override refers to a known puller and to exactly one
+// machine or app.
+func (pi *PullerInterval) Validate() error {
+	known := false
+	for _, name := range PullerIntervalSettingNames {
+		if pi.Puller == name {
+			known = true
+			break
+		}
+	}
+	if !known {
+		return pkgerrors.Errorf("unknown puller %s", pi.Puller)
+	}
+	if (pi.MachineID == 0) == (pi.AppID == 0) {
+		return errors.New("puller interval must be specified for either a machine or an app")
+	}
+	if pi.Interval < 0 {
+		return pkgerrors.Errorf("puller interval %d must not be negative", pi.Interval)
+	}
+	return nil
+}
+
+// Adds the puller interval override to the database or updates the
+// existing override for the same puller and machine or app. The ID of
+// the stored override is set in the structure.
+func SetPullerInterval(db *pg.DB, pi *PullerInterval) error {
+	existing := &PullerInterval{}
+	q := db.Model(existing).Where("puller = ?", pi.Puller)
+	if pi.AppID != 0 {
+		q = q.Where("app_id = ?", pi.AppID)
+	} else {
+		q = q.Where("machine_id = ?", pi.MachineID)
+	}
+	err := q.Select()
+	switch {
+	case errors.Is(err, pg.ErrNoRows):
+		pi.ID = 0
+		_, err = db.Model(pi).Insert()
+		if err != nil {
+			return pkgerrors.Wrapf(err, "problem with inserting interval for puller %s", pi.Puller)
+		}
+	case err != nil:
+		return pkgerrors.Wrapf(err, "problem with getting interval for puller %s", pi.Puller)
+	default:
+		pi.ID = existing.ID
+		_, err = db.Model(pi).WherePK().Update()
+		if err != nil {
+			return pkgerrors.Wrapf(err, "problem with updating interval %d for puller %s", pi.ID, pi.Puller)
+		}
+	}
+	return nil
+}
+
+// Get puller interval override by ID. It returns nil if the override
+// doesn't exist.
+func GetPullerIntervalByID(db *pg.DB, id int64) (*PullerInterval, error) {
+	pi := &PullerInterval{}
+	err := db.Model(pi).Where("id = ?", id).Select()
+	if errors.Is(err, pg.ErrNoRows) {
+		return nil, nil
+	} else if err != nil {
+		return nil, pkgerrors.Wrapf(err, "problem with getting puller interval %d", id)
+	}
+	return pi, nil
+}
+
+// Get all puller interval overrides ordered by ID.
+func GetPullerIntervals(dbi dbops.DBI) ([]PullerInterval, error) {
+	intervals := []PullerInterval{}
+	err := dbi.Model(&intervals).OrderExpr("id ASC").Select()
+	if err != nil {
+		return nil, pkgerrors.Wrapf(err, "problem with getting puller intervals")
+	}
+	return intervals, nil
+}
+
+// Get the interval overrides of the specified puller ordered by ID.
+func GetPullerIntervalsByPuller(dbi dbops.DBI, puller string) ([]PullerInterval, error) {
+	intervals := []PullerInterval{}
+	err := dbi.Model(&intervals).Where("puller = ?", puller).OrderExpr("id ASC").Select()
+	if err != nil {
+		return nil, pkgerrors.Wrapf(err, "problem with getting intervals for puller %s", puller)
+	}
+	return intervals, nil
+}
+
+// Delete puller interval override from the database.
+func DeletePullerInterval(db *pg.DB, id int64) error {
+	pi := &PullerInterval{ID: id}
+	result, err := db.Model(pi).WherePK().Delete()
+	if err != nil {
+		return pkgerrors.Wrapf(err, "problem with deleting puller interval %d", id)
+	} else if result.RowsAffected() <= 0 {
+		return pkgerrors.Wrapf(ErrNotExists, "puller interval with id %d does not exist", id)
+	}
+	return nil
+}
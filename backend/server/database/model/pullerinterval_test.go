@@ -0,0 +1,122 @@
+package dbmodel
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	dbtest "isc.org/stork/server/database/test"
+)
+
+// Check that the override must refer to a known puller and to exactly
+// one machine or app.
+func TestPullerIntervalValidate(t *testing.T) {
+	pi := &PullerInterval{Puller: "foo_puller_interval", AppID: 1}
+	require.Error(t, pi.Validate())
+
+	pi.Puller = "kea_hosts_puller_interval"
+	require.NoError(t, pi.Validate())
+
+	pi.MachineID = 1
+	require.Error(t, pi.Validate())
+
+	pi.AppID = 0
+	require.NoError(t, pi.Validate())
+
+	pi.MachineID = 0
+	require.Error(t, pi.Validate())
+
+	pi.MachineID = 1
+	pi.Interval = -1
+	require.Error(t, pi.Validate())
+}
+
+// Check that the puller interval overrides can be added, updated, fetched
+// and deleted.
+func TestPullerIntervals(t *testing.T) {
+	db, _, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	m := &Machine{
+		Address:   "localhost",
+		AgentPort: 8080,
+	}
+	require.NoError(t, AddMachine(db, m))
+
+	var accessPoints []*AccessPoint
+	accessPoints = AppendAccessPoint(accessPoints, AccessPointControl, "localhost", "", 8000, false)
+	a := &App{
+		MachineID:    m.ID,
+		Type:         AppTypeKea,
+		AccessPoints: accessPoints,
+	}
+	_, err := AddApp(db, a)
+	require.NoError(t, err)
+
+	machineInterval := &PullerInterval{
+		Puller:    "kea_hosts_puller_interval",
+		MachineID: m.ID,
+		Interval:  600,
+	}
+	require.NoError(t, SetPullerInterval(db, machineInterval))
+	require.NotZero(t, machineInterval.ID)
+
+	// The app is excluded from pulling.
+	appInterval := &PullerInterval{
+		Puller: "kea_hosts_puller_interval",
+		AppID:  a.ID,
+	}
+	require.NoError(t, SetPullerInterval(db, appInterval))
+	require.NotZero(t, appInterval.ID)
+
+	statsInterval := &PullerInterval{
+		Puller:   "kea_stats_puller_interval",
+		AppID:    a.ID,
+		Interval: 30,
+	}
+	require.NoError(t, SetPullerInterval(db, statsInterval))
+
+	// Setting the interval for the same puller and machine updates the
+	// existing override.
+	update := &PullerInterval{
+		Puller:    "kea_hosts_puller_interval",
+		MachineID: m.ID,
+		Interval:  1200,
+	}
+	require.NoError(t, SetPullerInterval(db, update))
+	require.Equal(t, machineInterval.ID, update.ID)
+
+	intervals, err := GetPullerIntervals(db)
+	require.NoError(t, err)
+	require.Len(t, intervals, 3)
+	require.EqualValues(t, 1200, intervals[0].Interval)
+	require.EqualValues(t, m.ID, intervals[0].MachineID)
+	require.Zero(t, intervals[0].AppID)
+	require.Zero(t, intervals[1].Interval)
+	require.EqualValues(t, a.ID, intervals[1].AppID)
+
+	intervals, err = GetPullerIntervalsByPuller(db, "kea_stats_puller_interval")
+	require.NoError(t, err)
+	require.Len(t, intervals, 1)
+	require.EqualValues(t, 30, intervals[0].Interval)
+
+	returned, err := GetPullerIntervalByID(db, appInterval.ID)
+	require.NoError(t, err)
+	require.NotNil(t, returned)
+	require.Equal(t, "kea_hosts_puller_interval", returned.Puller)
+
+	returned, err = GetPullerIntervalByID(db, appInterval.ID+100)
+	require.NoError(t, err)
+	require.Nil(t, returned)
+
+	require.NoError(t, DeletePullerInterval(db, appInterval.ID))
+	err = DeletePullerInterval(db, appInterval.ID)
+	require.True(t, errors.Is(err, ErrNotExists))
+
+	// Deleting the app deletes its overrides.
+	require.NoError(t, DeleteApp(db, a))
+	intervals, err = GetPullerIntervals(db)
+	require.NoError(t, err)
+	require.Len(t, intervals, 1)
+	require.EqualValues(t, m.ID, intervals[0].MachineID)
+}
@@ -0,0 +1,58 @@
+package dbmodel
+
+import (
+	"crypto/sha256"
+	"time"
+
+	pkgerrors "github.com/pkg/errors"
+	dbops "isc.org/stork/server/database"
+)
+
+// Represents the agent certificate revoked by the server. The server
+// refuses the connections with the agents presenting such certificates.
+// The certificate is identified by its serial number. The fingerprint
+// identifies the certificates issued before the serial numbers were
+// stored in the machines.
+type RevokedCert struct {
+	ID           int64
+	SerialNumber int64 `pg:",use_zero"`
+	Fingerprint  [sha256.Size]byte
+	Address      string
+	AgentPort    int64
+	RevokedAt    time.Time
+}
+
+// Returns the revoked certificate of the machine. It returns nil if the
+// machine has no certificate, e.g. because it has never been registered
+// successfully.
+func NewRevokedCert(machine *Machine) *RevokedCert {
+	if machine.CertSerialNumber == 0 && machine.CertFingerprint == [sha256.Size]byte{} {
+		return nil
+	}
+	return &RevokedCert{
+		SerialNumber: machine.CertSerialNumber,
+		Fingerprint:  machine.CertFingerprint,
+		Address:      machine.Address,
+		AgentPort:    machine.AgentPort,
+		RevokedAt:    time.Now().UTC(),
+	}
+}
+
+// Add revoked certificate to the database.
+func AddRevokedCert(dbi dbops.DBI, revokedCert *RevokedCert) error {
+	_, err := dbi.Model(revokedCert).Insert()
+	if err != nil {
+		return pkgerrors.Wrapf(err, "problem with inserting revoked cert of %s:%d", revokedCert.Address, revokedCert.AgentPort)
+	}
+	return nil
+}
+
+// Get all revoked certificates ordered by the revocation time.
+func GetRevokedCerts(dbi dbops.DBI) ([]RevokedCert, error) {
+	revokedCerts := []RevokedCert{}
+	err := dbi.Model(&revokedCerts).OrderExpr("id ASC").Select()
+	if err != nil {
+		return nil, pkgerrors.Wrapf(err, "problem with getting revoked certs")
+	}
+	return revokedCerts, nil
+}
@@ -0,0 +1,77 @@
+package dbmodel
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	dbtest "isc.org/stork/server/database/test"
+)
+
+// Check that the revoked cert is created only for the machine having a cert.
+func TestNewRevokedCert(t *testing.T) {
+	m := &Machine{
+		Address:   "192.0.2.1",
+		AgentPort: 8080,
+	}
+	require.Nil(t, NewRevokedCert(m))
+
+	// The certs issued before the serial numbers were stored are
+	// identified by the fingerprints.
+	m.CertFingerprint = [32]byte{1, 2, 3}
+	revokedCert := NewRevokedCert(m)
+	require.NotNil(t, revokedCert)
+	require.Zero(t, revokedCert.SerialNumber)
+	require.Equal(t, m.CertFingerprint, revokedCert.Fingerprint)
+	require.Equal(t, "192.0.2.1", revokedCert.Address)
+	require.EqualValues(t, 8080, revokedCert.AgentPort)
+
+	m.CertSerialNumber = 5
+	revokedCert = NewRevokedCert(m)
+	require.NotNil(t, revokedCert)
+	require.EqualValues(t, 5, revokedCert.SerialNumber)
+}
+
+// Check that the agent cert is revoked when the machine is deleted.
+func TestDeleteMachineRevokesCert(t *testing.T) {
+	db, _, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	revokedCerts, err := GetRevokedCerts(db)
+	require.NoError(t, err)
+	require.Empty(t, revokedCerts)
+
+	m := &Machine{
+		Address:          "192.0.2.1",
+		AgentPort:        8080,
+		CertSerialNumber: 7,
+		CertFingerprint:  [32]byte{1, 2, 3},
+	}
+	err = AddMachine(db, m)
+	require.NoError(t, err)
+
+	err = DeleteMachine(db, m)
+	require.NoError(t, err)
+
+	revokedCerts, err = GetRevokedCerts(db)
+	require.NoError(t, err)
+	require.Len(t, revokedCerts, 1)
+	require.EqualValues(t, 7, revokedCerts[0].SerialNumber)
+	require.Equal(t, m.CertFingerprint, revokedCerts[0].Fingerprint)
+	require.Equal(t, "192.0.2.1", revokedCerts[0].Address)
+	require.EqualValues(t, 8080, revokedCerts[0].AgentPort)
+	require.False(t, revokedCerts[0].RevokedAt.IsZero())
+
+	// The machine without the cert is deleted without revoking anything.
+	m = &Machine{
+		Address:   "192.0.2.2",
+		AgentPort: 8080,
+	}
+	err = AddMachine(db, m)
+	require.NoError(t, err)
+	err = DeleteMachine(db, m)
+	require.NoError(t, err)
+
+	revokedCerts, err = GetRevokedCerts(db)
+	require.NoError(t, err)
+	require.Len(t, revokedCerts, 1)
+}
@@ -77,6 +77,11 @@ func InitializeSettings(db *pg.DB) error {
 			ValType: SettingValTypeInt,
 			Value:   "10", // in seconds
 		},
+		{
+			Name:    "config_review_interval", // in seconds
+			ValType: SettingValTypeInt,
+			Value:   "0", // periodic reviews disabled
+		},
 	}
 
 	// Check if there are new settings vs existing ones. Add new ones to DB.
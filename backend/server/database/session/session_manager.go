@@ -87,6 +87,22 @@ func (s *SessionMgr) SessionMiddleware(handler http.Handler) http.Handler {
 	return s.scsSessionMgr.LoadAndSave(handler)
 }
 
+// Loads the session data for the request without buffering the response as
+// the SessionMiddleware does. It is used by the handlers streaming the response,
+// e.g. server-sent events. The returned request holds the session data in its
+// context. The session data must not be modified because it is not saved.
+func (s *SessionMgr) LoadFromRequest(req *http.Request) (*http.Request, error) {
+	token := ""
+	if cookie, err := req.Cookie(s.scsSessionMgr.Cookie.Name); err == nil {
+		token = cookie.Value
+	}
+	ctx, err := s.scsSessionMgr.Load(req.Context(), token)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error loading the session data")
+	}
+	return req.WithContext(ctx), nil
+}
+
 // Checks if the given session token exists in the database. This is typically used
 // in unit testing to validate that the session data is persisted in the database.
 func (s *SessionMgr) HasToken(token string) bool {
@@ -104,3 +104,46 @@ func TestLoad(t *testing.T) {
 	_, err = mgr.Load(ctx, "")
 	require.NoError(t, err)
 }
+
+// Test that the session data is loaded for the request carrying the
+// session cookie.
+func TestLoadFromRequest(t *testing.T) {
+	// Reset database schema.
+	_, dbSettings, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	mgr, err := NewSessionMgr(&dbSettings.BaseDatabaseSettings)
+	require.NoError(t, err)
+
+	// No session cookie, so the user is not logged.
+	req := httptest.NewRequest("GET", "http://example.com/foo", nil)
+	req, err = mgr.LoadFromRequest(req)
+	require.NoError(t, err)
+	logged, _ := mgr.Logged(req.Context())
+	require.False(t, logged)
+
+	// Log the user in using the middleware to get the session cookie.
+	user := &dbmodel.SystemUser{
+		ID:    1,
+		Login: "johnw",
+	}
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		err := mgr.LoginHandler(r.Context(), user)
+		require.NoError(t, err)
+	}
+	w := httptest.NewRecorder()
+	mgr.SessionMiddleware(http.HandlerFunc(handler)).ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/foo", nil))
+	resp := w.Result()
+	defer resp.Body.Close()
+	hasCookie, value := getCookie(resp, "session")
+	require.True(t, hasCookie)
+
+	// The session cookie should be recognized.
+	req = httptest.NewRequest("GET", "http://example.com/foo", nil)
+	req.AddCookie(&http.Cookie{Name: "session", Value: value})
+	req, err = mgr.LoadFromRequest(req)
+	require.NoError(t, err)
+	logged, userSession := mgr.Logged(req.Context())
+	require.True(t, logged)
+	require.Equal(t, "johnw", userSession.Login)
+}
@@ -0,0 +1,204 @@
+package leader
+
+import (
+	"sync"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	dbops "isc.org/stork/server/database"
+)
+
+// Identifier of the PostgreSQL advisory lock held by the leader. It is
+// the same for all Stork Server instances connected to the database.
+// The value fits in 32 bits, so it is visible as objid in pg_locks.
+const LockID int64 = 0x5354524b
+
+// Leader elector. The Stork Server instances connected to the same
+// database elect a leader which runs the background tasks, e.g. the
+// pullers, while all instances serve the REST API. The leader is the
+// instance holding the session-level PostgreSQL advisory lock. The lock
+// is held on a dedicated database connection, i.e. outside of the
+// connection pool, so it is released by the database when the leader
+// closes or loses this connection. The other instances periodically try
+// to take the lock over.
+type Elector struct {
+	db       *dbops.PgDB
+	interval time.Duration
+	// Dedicated connection holding the lock. It is nil when this instance
+	// is not the leader.
+	conn   *pg.DB
+	leader bool
+	mutex  *sync.Mutex
+	// Functions called when this instance becomes the leader and when
+	// it stops being the leader.
+	onElected func()
+	onDeposed func()
+	done      chan struct{}
+	wg        *sync.WaitGroup
+}
+
+// Creates the leader elector checking the leadership at the specified
+// interval. The onElected function is called when this instance becomes
+// the leader and the onDeposed function is called when it stops being
+// the leader, including the shutdown of the elector. The functions are
+// called from the elector's goroutine.
+func NewElector(db *dbops.PgDB, interval time.Duration, onElected, onDeposed func()) *Elector {
+	return &Elector{
+		db:        db,
+		interval:  interval,
+		mutex:     &sync.Mutex{},
+		onElected: onElected,
+		onDeposed: onDeposed,
+		done:      make(chan struct{}),
+		wg:        &sync.WaitGroup{},
+	}
+}
+
+// Starts the election. The first attempt to become the leader is made
+// before this function returns, so a single instance becomes the leader
+// immediately. The next attempts are made in the background.
+func (e *Elector) Start() {
+	e.check()
+	e.wg.Add(1)
+	go e.run()
+}
+
+// Stops the election. The leader releases the lock, so another instance
+// can take the leadership over without waiting for the database to
+// notice that the connection is closed.
+func (e *Elector) Shutdown() {
+	close(e.done)
+	e.wg.Wait()
+
+	if !e.IsLeader() {
+		return
+	}
+	var released bool
+	if _, err := e.conn.QueryOne(pg.Scan(&released), "SELECT pg_advisory_unlock(?)", LockID); err != nil {
+		log.Warnf("problem with releasing the leader lock: %+v", err)
+	}
+	e.depose()
+}
+
+// Checks if this instance is the leader.
+func (e *Elector) IsLeader() bool {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.leader
+}
+
+// Periodically checks the leadership until the elector is stopped.
+func (e *Elector) run() {
+	defer e.wg.Done()
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			e.check()
+		case <-e.done:
+			return
+		}
+	}
+}
+
+// Checks if the leader still holds the lock or tries to acquire the lock
+// if this instance is not the leader.
+func (e *Elector) check() {
+	if e.IsLeader() {
+		// The session-level lock is held as long as the connection is
+		// alive. The lock is looked up because the broken connection is
+		// transparently re-established without the lock.
+		held, err := e.isLockHeld()
+		switch {
+		case err != nil:
+			log.Errorf("lost the connection holding the leader lock: %+v", err)
+			e.depose()
+		case !held:
+			log.Error("lost the leader lock")
+			e.depose()
+		}
+		return
+	}
+
+	acquired, err := e.tryLock()
+	if err != nil {
+		log.Errorf("problem with acquiring the leader lock: %+v", err)
+		return
+	}
+	if acquired {
+		log.Info("this Stork Server instance is the leader")
+		if e.onElected != nil {
+			e.onElected()
+		}
+	}
+}
+
+// Opens a dedicated connection to the database. The connection is not
+// taken from the pool of the database handle used by the rest of the
+// server, so closing it terminates the database session and releases
+// the session-level locks.
+func (e *Elector) connect() *pg.DB {
+	options := *e.db.Options()
+	options.PoolSize = 1
+	options.MinIdleConns = 0
+	// The connection must not be replaced while it holds the lock.
+	options.MaxConnAge = 0
+	options.IdleTimeout = -1
+	return pg.Connect(&options)
+}
+
+// Tries to acquire the lock on a dedicated connection. The connection is
+// kept open if the lock is acquired.
+func (e *Elector) tryLock() (bool, error) {
+	conn := e.connect()
+	var acquired bool
+	if _, err := conn.QueryOne(pg.Scan(&acquired), "SELECT pg_try_advisory_lock(?)", LockID); err != nil {
+		_ = conn.Close()
+		return false, errors.Wrapf(err, "problem with acquiring the advisory lock %d", LockID)
+	}
+	if !acquired {
+		_ = conn.Close()
+		return false, nil
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.conn = conn
+	e.leader = true
+	return true, nil
+}
+
+// Checks if the lock is held by the session of the dedicated connection.
+func (e *Elector) isLockHeld() (bool, error) {
+	var held bool
+	_, err := e.conn.QueryOne(pg.Scan(&held), `SELECT EXISTS (
+		SELECT 1 FROM pg_locks
+		WHERE locktype = 'advisory' AND objid = ? AND pid = pg_backend_pid() AND granted
+	)`, LockID)
+	if err != nil {
+		return false, errors.Wrapf(err, "problem with checking the advisory lock %d", LockID)
+	}
+	return held, nil
+}
+
+// Gives up the leadership and closes the connection holding the lock.
+// Closing the connection releases the lock if it is still held.
+func (e *Elector) depose() {
+	e.mutex.Lock()
+	conn := e.conn
+	e.conn = nil
+	e.leader = false
+	e.mutex.Unlock()
+
+	if conn != nil {
+		_ = conn.Close()
+	}
+	log.Info("this Stork Server instance is no longer the leader")
+	if e.onDeposed != nil {
+		e.onDeposed()
+	}
+}
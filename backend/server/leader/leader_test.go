@@ -0,0 +1,132 @@
+package leader
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/stretchr/testify/require"
+
+	dbops "isc.org/stork/server/database"
+	dbtest "isc.org/stork/server/database/test"
+)
+
+// Test elector counting the leadership changes.
+type testElector struct {
+	*Elector
+	elected int32
+	deposed int32
+}
+
+// Creates the test elector checking the leadership frequently.
+func newTestElector(db *dbops.PgDB) *testElector {
+	e := &testElector{}
+	e.Elector = NewElector(db, 100*time.Millisecond,
+		func() { atomic.AddInt32(&e.elected, 1) },
+		func() { atomic.AddInt32(&e.deposed, 1) },
+	)
+	return e
+}
+
+// Test that a single instance becomes the leader immediately and gives
+// up the leadership on shutdown.
+func TestSingleElector(t *testing.T) {
+	db, _, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	e := newTestElector(db)
+	e.Start()
+	require.True(t, e.IsLeader())
+	require.EqualValues(t, 1, atomic.LoadInt32(&e.elected))
+
+	e.Shutdown()
+	require.False(t, e.IsLeader())
+	require.EqualValues(t, 1, atomic.LoadInt32(&e.deposed))
+}
+
+// Test that only one instance is the leader and another instance takes
+// the leadership over when the leader stops.
+func TestTakeoverOnShutdown(t *testing.T) {
+	db, _, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	first := newTestElector(db)
+	first.Start()
+	second := newTestElector(db)
+	second.Start()
+	defer second.Shutdown()
+
+	require.True(t, first.IsLeader())
+	require.False(t, second.IsLeader())
+	// The follower must not become the leader while the leader runs.
+	time.Sleep(300 * time.Millisecond)
+	require.False(t, second.IsLeader())
+	require.Zero(t, atomic.LoadInt32(&second.elected))
+
+	first.Shutdown()
+	require.Eventually(t, second.IsLeader, 5*time.Second, 50*time.Millisecond)
+	require.EqualValues(t, 1, atomic.LoadInt32(&second.elected))
+}
+
+// Test that the leader which lost the connection holding the lock stops
+// being the leader and another instance takes the leadership over.
+func TestTakeoverOnLostConnection(t *testing.T) {
+	db, _, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	first := newTestElector(db)
+	first.Start()
+	defer first.Shutdown()
+	second := newTestElector(db)
+	second.Start()
+	defer second.Shutdown()
+	require.True(t, first.IsLeader())
+
+	// Terminate the session holding the lock.
+	_, err := db.Exec(`SELECT pg_terminate_backend(pid) FROM pg_locks
+		WHERE locktype = 'advisory' AND objid = ? AND granted`, LockID)
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		return !first.IsLeader() && second.IsLeader()
+	}, 5*time.Second, 50*time.Millisecond)
+	require.EqualValues(t, 1, atomic.LoadInt32(&first.deposed))
+
+	// The former leader remains the follower.
+	time.Sleep(300 * time.Millisecond)
+	require.False(t, first.IsLeader())
+}
+
+// Test that the lock is released when the leader is deposed, so another
+// instance can be elected. The lock must not remain held by a connection
+// returned to the pool.
+func TestTakeoverAfterDepose(t *testing.T) {
+	db, _, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	first := newTestElector(db)
+	// Don't let the first instance take the lock back during the test.
+	first.interval = time.Hour
+	first.Start()
+	defer first.Shutdown()
+	require.True(t, first.IsLeader())
+
+	first.depose()
+	require.False(t, first.IsLeader())
+	require.EqualValues(t, 1, atomic.LoadInt32(&first.deposed))
+
+	// No session holds the lock.
+	var count int
+	_, err := db.QueryOne(pg.Scan(&count), `SELECT COUNT(*) FROM pg_locks
+		WHERE locktype = 'advisory' AND objid = ? AND granted`, LockID)
+	require.NoError(t, err)
+	require.Zero(t, count)
+
+	second := newTestElector(db)
+	second.Start()
+	defer second.Shutdown()
+	require.True(t, second.IsLeader())
+	require.EqualValues(t, 1, atomic.LoadInt32(&second.elected))
+	require.False(t, first.IsLeader())
+}
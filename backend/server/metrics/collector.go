@@ -6,6 +6,8 @@ import (
 	"github.com/go-pg/pg/v10"
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	log "github.com/sirupsen/logrus"
+	"isc.org/stork/server/agentcomm"
 	dbmodel "isc.org/stork/server/database/model"
 	storkutil "isc.org/stork/util"
 )
@@ -21,6 +23,12 @@ type Collector interface {
 	GetHTTPHandler(next http.Handler) http.Handler
 	// Shutdown metrics collecting.
 	Shutdown()
+	// Suspends collecting the metrics, e.g. when the server is not
+	// the leader. The HTTP handler responds with the Service Unavailable
+	// status while the collector is suspended.
+	Suspend()
+	// Resumes collecting the metrics.
+	Resume()
 }
 
 // Metrics collector created on top of
@@ -28,13 +36,18 @@ type Collector interface {
 type prometheusCollector struct {
 	metrics *metrics
 	puller  *storkutil.PeriodicExecutor
+	// Pullers whose executions are recorded in the metrics.
+	observedPullers []*storkutil.PeriodicExecutor
 }
 
 // Creates an instance of the metrics collector and starts
 // collecting the metrics according to the interval
-// specified in the database.
-func NewCollector(db *pg.DB) (Collector, error) {
-	metrics := newMetrics(db)
+// specified in the database. The agents are used to collect the
+// statistics of the communication with the agents. They may be nil.
+// The durations and results of the executions of the specified pullers
+// are recorded in the metrics.
+func NewCollector(db *pg.DB, agents agentcomm.ConnectedAgents, pullers ...*storkutil.PeriodicExecutor) (Collector, error) {
+	metrics := newMetrics(db, agents)
 	intervalSettingName := "metrics_collector_interval"
 
 	// Initialize the metrics
@@ -56,20 +69,52 @@ func NewCollector(db *pg.DB) (Collector, error) {
 		return nil, err
 	}
 
+	for _, puller := range pullers {
+		puller.SetObserver(metrics.observePullerExecution)
+	}
+
 	return &prometheusCollector{
-		metrics: metrics,
-		puller:  metricPuller,
+		metrics:         metrics,
+		puller:          metricPuller,
+		observedPullers: pullers,
 	}, nil
 }
 
-// Creates standard Prometheus HTTP handler.
+// Creates standard Prometheus HTTP handler. The handler doesn't return
+// the metrics while the collector is suspended because they are not
+// up to date.
 func (c *prometheusCollector) GetHTTPHandler(next http.Handler) http.Handler {
-	return promhttp.HandlerFor(c.metrics.Registry, promhttp.HandlerOpts{})
+	handler := promhttp.HandlerFor(c.metrics.Registry, promhttp.HandlerOpts{})
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if c.puller.Suspended() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = w.Write([]byte("The metrics are collected by another Stork Server instance which is the leader."))
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// Suspends collecting the metrics.
+func (c *prometheusCollector) Suspend() {
+	c.puller.Suspend()
+}
+
+// Resumes collecting the metrics. The metrics are updated immediately,
+// so they are up to date before the next interval elapses.
+func (c *prometheusCollector) Resume() {
+	if err := c.metrics.Update(); err != nil {
+		log.Errorf("problem with updating the metrics: %+v", err)
+	}
+	c.puller.Resume()
 }
 
 // Stops periodically collecting the metrics and unregisters
 // all metrics.
 func (c *prometheusCollector) Shutdown() {
+	for _, puller := range c.observedPullers {
+		puller.SetObserver(nil)
+	}
 	c.puller.Shutdown()
 	c.metrics.UnregisterAll()
 }
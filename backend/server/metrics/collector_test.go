@@ -50,7 +50,7 @@ func TestConstructController(t *testing.T) {
 	_ = dbmodel.InitializeSettings(db)
 
 	// Act
-	collector, err := NewCollector(db)
+	collector, err := NewCollector(db, nil)
 	defer collector.Shutdown()
 
 	// Assert
@@ -66,7 +66,7 @@ func TestConstructControllerWhenIntervalSettingIsMissing(t *testing.T) {
 	defer teardown()
 
 	// Act
-	collector, err := NewCollector(db)
+	collector, err := NewCollector(db, nil)
 
 	// Assert
 	require.Nil(t, collector)
@@ -79,7 +79,7 @@ func TestCreateHttpHandler(t *testing.T) {
 	db, _, teardown := dbtest.SetupDatabaseTestCase(t)
 	defer teardown()
 	_ = dbmodel.InitializeSettings(db)
-	collector, _ := NewCollector(db)
+	collector, _ := NewCollector(db, nil)
 	defer collector.Shutdown()
 	nextHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
 
@@ -96,7 +96,7 @@ func TestHandlerResponse(t *testing.T) {
 	db, _, teardown := dbtest.SetupDatabaseTestCase(t)
 	defer teardown()
 	_ = dbmodel.InitializeSettings(db)
-	collector, _ := NewCollector(db)
+	collector, _ := NewCollector(db, nil)
 	defer collector.Shutdown()
 	nextHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
 	handler := collector.GetHTTPHandler(nextHandler)
@@ -115,6 +115,35 @@ func TestHandlerResponse(t *testing.T) {
 	require.Zero(t, authorizedCount)
 }
 
+// Test that the handler doesn't return the metrics while the collector
+// is suspended.
+func TestHandlerResponseSuspended(t *testing.T) {
+	// Arrange
+	db, _, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+	_ = dbmodel.InitializeSettings(db)
+	collector, _ := NewCollector(db, nil)
+	defer collector.Shutdown()
+	nextHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	handler := collector.GetHTTPHandler(nextHandler)
+
+	// Act
+	collector.Suspend()
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "http://localhost/metrics", nil))
+
+	// Assert
+	require.EqualValues(t, http.StatusServiceUnavailable, w.Code)
+
+	// Act
+	collector.Resume()
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "http://localhost/metrics", nil))
+
+	// Assert
+	require.EqualValues(t, http.StatusOK, w.Code)
+}
+
 // Test that the metrics are updated periodically.
 func TestPeriodicMetricsUpdate(t *testing.T) {
 	// Arrange
@@ -123,7 +152,7 @@ func TestPeriodicMetricsUpdate(t *testing.T) {
 	_ = dbmodel.InitializeSettings(db)
 	_ = dbmodel.SetSettingInt(db, "metrics_collector_interval", 1)
 
-	collector, _ := NewCollector(db)
+	collector, _ := NewCollector(db, nil)
 	defer collector.Shutdown()
 
 	nextHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
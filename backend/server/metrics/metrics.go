@@ -10,10 +10,13 @@ package metrics
 
 import (
 	"reflect"
+	"strconv"
+	"time"
 
 	"github.com/go-pg/pg/v10"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
+	"isc.org/stork/server/agentcomm"
 	dbmodel "isc.org/stork/server/database/model"
 )
 
@@ -21,6 +24,7 @@ import (
 type metrics struct {
 	Registry *prometheus.Registry
 	db       *pg.DB
+	agents   agentcomm.ConnectedAgents
 
 	AuthorizedMachineTotal          prometheus.Gauge
 	UnauthorizedMachineTotal        prometheus.Gauge
@@ -29,11 +33,23 @@ type metrics struct {
 	SubnetPdUtilization             *prometheus.GaugeVec
 	SharedNetworkAddressUtilization *prometheus.GaugeVec
 	SharedNetworkPdUtilization      *prometheus.GaugeVec
+	AgentQueuedRequests             *prometheus.GaugeVec
+	AgentInFlightRequests           *prometheus.GaugeVec
+	AgentCommunicationErrors        *prometheus.GaugeVec
+	HAServerState                   *prometheus.GaugeVec
+	HAServerReachable               *prometheus.GaugeVec
+	HAServerCommInterrupted         *prometheus.GaugeVec
+	HAServerUnackedClients          *prometheus.GaugeVec
+	PullerLastSuccess               *prometheus.GaugeVec
+	PullerDuration                  *prometheus.HistogramVec
+	PullerErrors                    *prometheus.CounterVec
+	ConfigReports                   *prometheus.GaugeVec
+	Events                          *prometheus.GaugeVec
 }
 
 // Constructor of the metrics. They are automatically
 // registered in the Prometheus.
-func newMetrics(db *pg.DB) *metrics {
+func newMetrics(db *pg.DB, agents agentcomm.ConnectedAgents) *metrics {
 	registry := prometheus.NewRegistry()
 	factory := promauto.With(registry)
 
@@ -42,6 +58,7 @@ func newMetrics(db *pg.DB) *metrics {
 	metrics := metrics{
 		Registry: registry,
 		db:       db,
+		agents:   agents,
 
 		AuthorizedMachineTotal: factory.NewGauge(prometheus.GaugeOpts{
 			Namespace: namespace,
@@ -85,6 +102,78 @@ func newMetrics(db *pg.DB) *metrics {
 			Subsystem: "shared_network",
 			Help:      "Shared network delegated prefix utilization",
 		}, []string{"name"}),
+		AgentQueuedRequests: factory.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "queued_requests",
+			Subsystem: "agent",
+			Help:      "Requests waiting to be sent to the agent",
+		}, []string{"agent"}),
+		AgentInFlightRequests: factory.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "in_flight_requests",
+			Subsystem: "agent",
+			Help:      "Requests being sent to the agent",
+		}, []string{"agent"}),
+		AgentCommunicationErrors: factory.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "communication_errors",
+			Subsystem: "agent",
+			Help:      "Consecutive errors in communication with the agent or the app behind the agent",
+		}, []string{"agent", "app", "channel"}),
+		HAServerState: factory.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "server_state",
+			Subsystem: "ha",
+			Help:      "Last known HA state of the server in the HA service; the value is always 1",
+		}, []string{"service", "server", "state"}),
+		HAServerReachable: factory.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "server_reachable",
+			Subsystem: "ha",
+			Help:      "Indicates if the server in the HA service is reachable",
+		}, []string{"service", "server"}),
+		HAServerCommInterrupted: factory.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "server_communication_interrupted",
+			Subsystem: "ha",
+			Help:      "Indicates if the server in the HA service cannot communicate with its partner",
+		}, []string{"service", "server"}),
+		HAServerUnackedClients: factory.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "server_unacked_clients",
+			Subsystem: "ha",
+			Help:      "Clients not acknowledged by the server in the HA service during the communication interruption",
+		}, []string{"service", "server"}),
+		PullerLastSuccess: factory.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "last_success_timestamp_seconds",
+			Subsystem: "puller",
+			Help:      "Time of the last successful pull",
+		}, []string{"puller"}),
+		PullerDuration: factory.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "duration_seconds",
+			Subsystem: "puller",
+			Help:      "Duration of the pulls",
+			Buckets:   []float64{0.1, 0.5, 1, 2.5, 5, 10, 30, 60, 120},
+		}, []string{"puller"}),
+		PullerErrors: factory.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "errors_total",
+			Subsystem: "puller",
+			Help:      "Pulls which returned an error",
+		}, []string{"puller"}),
+		ConfigReports: factory.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "reports",
+			Subsystem: "config_review",
+			Help:      "Unresolved config reports by the checker",
+		}, []string{"checker"}),
+		Events: factory.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "events",
+			Help:      "Events stored in the database by the level",
+		}, []string{"level"}),
 	}
 
 	return &metrics
@@ -119,9 +208,124 @@ func (m *metrics) Update() error {
 			Set(float64(networkMetrics.PdUtilization) / 1000.)
 	}
 
+	m.updateHAServiceMetrics(calculatedMetrics.HAServiceMetrics)
+
+	m.ConfigReports.Reset()
+	for _, reportMetrics := range calculatedMetrics.ConfigReportMetrics {
+		m.ConfigReports.
+			With(prometheus.Labels{"checker": reportMetrics.CheckerName}).
+			Set(float64(reportMetrics.Count))
+	}
+
+	m.Events.Reset()
+	for _, eventMetrics := range calculatedMetrics.EventMetrics {
+		m.Events.
+			With(prometheus.Labels{"level": eventLevelToString(eventMetrics.Level)}).
+			Set(float64(eventMetrics.Count))
+	}
+
+	m.updateAgentMetrics()
+
 	return nil
 }
 
+// Returns the name of the event level used as the metric label.
+func eventLevelToString(level int) string {
+	switch level {
+	case dbmodel.EvInfo:
+		return "info"
+	case dbmodel.EvWarning:
+		return "warning"
+	case dbmodel.EvError:
+		return "error"
+	default:
+		return strconv.Itoa(level)
+	}
+}
+
+// Converts boolean to the metric value.
+func boolToFloat64(value bool) float64 {
+	if value {
+		return 1
+	}
+	return 0
+}
+
+// Sets the metrics of the servers in the HA services. The metrics are
+// reset first because the services and their states change over time.
+func (m *metrics) updateHAServiceMetrics(haMetrics []dbmodel.CalculatedHAServiceMetrics) {
+	m.HAServerState.Reset()
+	m.HAServerReachable.Reset()
+	m.HAServerCommInterrupted.Reset()
+	m.HAServerUnackedClients.Reset()
+
+	for _, service := range haMetrics {
+		servers := []struct {
+			name            string
+			state           string
+			reachable       bool
+			commInterrupted *bool
+			unackedClients  int64
+		}{
+			{"primary", service.PrimaryLastState, service.PrimaryReachable, service.PrimaryCommInterrupted, service.PrimaryUnackedClients},
+			{"secondary", service.SecondaryLastState, service.SecondaryReachable, service.SecondaryCommInterrupted, service.SecondaryUnackedClients},
+		}
+		for _, server := range servers {
+			labels := prometheus.Labels{"service": service.Name, "server": server.name}
+			state := server.state
+			if state == "" {
+				state = "unavailable"
+			}
+			m.HAServerState.
+				With(prometheus.Labels{"service": service.Name, "server": server.name, "state": state}).
+				Set(1)
+			m.HAServerReachable.With(labels).Set(boolToFloat64(server.reachable))
+			// The communication state is unknown until the server reports it.
+			if server.commInterrupted != nil {
+				m.HAServerCommInterrupted.With(labels).Set(boolToFloat64(*server.commInterrupted))
+			}
+			m.HAServerUnackedClients.With(labels).Set(float64(server.unackedClients))
+		}
+	}
+}
+
+// Records the execution of the puller. It is called by the puller
+// after each pull.
+func (m *metrics) observePullerExecution(name string, duration time.Duration, err error) {
+	labels := prometheus.Labels{"puller": name}
+	m.PullerDuration.With(labels).Observe(duration.Seconds())
+	if err != nil {
+		m.PullerErrors.With(labels).Inc()
+		return
+	}
+	m.PullerLastSuccess.With(labels).Set(float64(time.Now().Unix()))
+}
+
+// Calculate current values of the metrics related to the communication
+// with the agents.
+func (m *metrics) updateAgentMetrics() {
+	if m.agents == nil {
+		return
+	}
+	for agentAddr, queueStats := range m.agents.GetQueueStats() {
+		m.AgentQueuedRequests.
+			With(prometheus.Labels{"agent": agentAddr}).
+			Set(float64(queueStats.Queued))
+		m.AgentInFlightRequests.
+			With(prometheus.Labels{"agent": agentAddr}).
+			Set(float64(queueStats.InFlight))
+	}
+
+	m.AgentCommunicationErrors.Reset()
+	for agentAddr, errorStats := range m.agents.GetCommErrorStats() {
+		for _, stats := range errorStats {
+			m.AgentCommunicationErrors.
+				With(prometheus.Labels{"agent": agentAddr, "app": stats.App, "channel": stats.Channel}).
+				Set(float64(stats.Errors))
+		}
+	}
+}
+
 // Unregister all metrics from the Prometheus registry.
 func (m *metrics) UnregisterAll() {
 	v := reflect.ValueOf(*m)
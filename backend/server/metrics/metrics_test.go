@@ -1,15 +1,23 @@
 package metrics
 
 import (
+	"errors"
 	"testing"
+	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/require"
+
+	"isc.org/stork/server/agentcomm"
+	agentcommtest "isc.org/stork/server/agentcomm/test"
+	dbmodel "isc.org/stork/server/database/model"
 )
 
 // All metrics should be properly constructed.
 func TestNewMetrics(t *testing.T) {
 	// Act
-	metrics := newMetrics(nil)
+	metrics := newMetrics(nil, nil)
 	mfs, _ := metrics.Registry.Gather()
 
 	// Arrange
@@ -27,7 +35,7 @@ func TestNewMetrics(t *testing.T) {
 // All metrics should be unregistered.
 func TestUnregisterAllMetrics(t *testing.T) {
 	// Arrange
-	metrics := newMetrics(nil)
+	metrics := newMetrics(nil, nil)
 
 	// Act
 	metrics.UnregisterAll()
@@ -36,3 +44,115 @@ func TestUnregisterAllMetrics(t *testing.T) {
 	// Arrange
 	require.Empty(t, mfs)
 }
+
+// The metrics of the agent request queues should be set from the
+// statistics returned by the agents.
+func TestUpdateAgentMetrics(t *testing.T) {
+	// Arrange
+	agents := &agentcommtest.FakeAgents{
+		QueueStats: map[string]agentcomm.AgentQueueStats{
+			"192.0.2.1:8080": {Queued: 3, InFlight: 4},
+		},
+		CommErrorStats: map[string][]agentcomm.AgentCommErrorStats{
+			"192.0.2.1:8080": {
+				{Channel: "agent", Errors: 1},
+				{App: "192.0.2.1:8000", Channel: "dhcp4", Errors: 5},
+			},
+		},
+	}
+	metrics := newMetrics(nil, agents)
+	defer metrics.UnregisterAll()
+
+	// Act
+	metrics.updateAgentMetrics()
+
+	// Assert
+	labels := prometheus.Labels{"agent": "192.0.2.1:8080"}
+	require.EqualValues(t, 3, testutil.ToFloat64(metrics.AgentQueuedRequests.With(labels)))
+	require.EqualValues(t, 4, testutil.ToFloat64(metrics.AgentInFlightRequests.With(labels)))
+
+	labels = prometheus.Labels{"agent": "192.0.2.1:8080", "app": "", "channel": "agent"}
+	require.EqualValues(t, 1, testutil.ToFloat64(metrics.AgentCommunicationErrors.With(labels)))
+	labels = prometheus.Labels{"agent": "192.0.2.1:8080", "app": "192.0.2.1:8000", "channel": "dhcp4"}
+	require.EqualValues(t, 5, testutil.ToFloat64(metrics.AgentCommunicationErrors.With(labels)))
+}
+
+// The metrics of the HA services should be set from the values calculated
+// from the database and the metrics of the removed services should be
+// removed.
+func TestUpdateHAServiceMetrics(t *testing.T) {
+	// Arrange
+	metrics := newMetrics(nil, nil)
+	defer metrics.UnregisterAll()
+	commInterrupted := true
+
+	// Act
+	metrics.updateHAServiceMetrics([]dbmodel.CalculatedHAServiceMetrics{
+		{
+			Name:                   "ha1",
+			PrimaryLastState:       "partner-down",
+			PrimaryReachable:       true,
+			PrimaryCommInterrupted: &commInterrupted,
+			PrimaryUnackedClients:  7,
+		},
+		{
+			Name: "ha2",
+		},
+	})
+
+	// Assert
+	primary := prometheus.Labels{"service": "ha1", "server": "primary"}
+	secondary := prometheus.Labels{"service": "ha1", "server": "secondary"}
+	require.EqualValues(t, 1, testutil.ToFloat64(metrics.HAServerState.With(prometheus.Labels{
+		"service": "ha1", "server": "primary", "state": "partner-down",
+	})))
+	require.EqualValues(t, 1, testutil.ToFloat64(metrics.HAServerState.With(prometheus.Labels{
+		"service": "ha1", "server": "secondary", "state": "unavailable",
+	})))
+	require.EqualValues(t, 1, testutil.ToFloat64(metrics.HAServerReachable.With(primary)))
+	require.EqualValues(t, 0, testutil.ToFloat64(metrics.HAServerReachable.With(secondary)))
+	require.EqualValues(t, 1, testutil.ToFloat64(metrics.HAServerCommInterrupted.With(primary)))
+	require.EqualValues(t, 7, testutil.ToFloat64(metrics.HAServerUnackedClients.With(primary)))
+	// The communication state of the secondary server is unknown.
+	require.Equal(t, 1, testutil.CollectAndCount(metrics.HAServerCommInterrupted))
+	require.Equal(t, 4, testutil.CollectAndCount(metrics.HAServerState))
+
+	// Act
+	metrics.updateHAServiceMetrics([]dbmodel.CalculatedHAServiceMetrics{{Name: "ha2"}})
+
+	// Assert
+	require.Equal(t, 2, testutil.CollectAndCount(metrics.HAServerState))
+}
+
+// The duration of each pull should be recorded and the time of the last
+// successful pull should be set.
+func TestObservePullerExecution(t *testing.T) {
+	// Arrange
+	metrics := newMetrics(nil, nil)
+	defer metrics.UnregisterAll()
+	labels := prometheus.Labels{"puller": "Kea Stats puller"}
+
+	// Act
+	metrics.observePullerExecution("Kea Stats puller", 2*time.Second, errors.New("pull failed"))
+
+	// Assert
+	require.EqualValues(t, 1, testutil.ToFloat64(metrics.PullerErrors.With(labels)))
+	require.Zero(t, testutil.CollectAndCount(metrics.PullerLastSuccess))
+	require.Equal(t, 1, testutil.CollectAndCount(metrics.PullerDuration))
+
+	// Act
+	metrics.observePullerExecution("Kea Stats puller", time.Second, nil)
+
+	// Assert
+	require.EqualValues(t, 1, testutil.ToFloat64(metrics.PullerErrors.With(labels)))
+	lastSuccess := testutil.ToFloat64(metrics.PullerLastSuccess.With(labels))
+	require.InDelta(t, time.Now().Unix(), lastSuccess, 5)
+}
+
+// Event levels should be converted to the label values.
+func TestEventLevelToString(t *testing.T) {
+	require.Equal(t, "info", eventLevelToString(dbmodel.EvInfo))
+	require.Equal(t, "warning", eventLevelToString(dbmodel.EvWarning))
+	require.Equal(t, "error", eventLevelToString(dbmodel.EvError))
+	require.Equal(t, "7", eventLevelToString(7))
+}
@@ -9,6 +9,7 @@ import (
 	"github.com/go-openapi/strfmt"
 	log "github.com/sirupsen/logrus"
 
+	keaconfig "isc.org/stork/appcfg/kea"
 	"isc.org/stork/server/configreview"
 	dbmodel "isc.org/stork/server/database/model"
 	"isc.org/stork/server/gen/models"
@@ -124,20 +125,159 @@ func (r *RestAPI) GetDaemonConfigReports(ctx context.Context, params services.Ge
 		Total: total,
 	}
 
-	for _, dbReport := range dbReports {
-		report := &models.ConfigReport{
-			ID:        dbReport.ID,
-			CreatedAt: strfmt.DateTime(dbReport.CreatedAt),
-			Checker:   dbReport.CheckerName,
-			Content:   dbReport.Content,
-		}
-		configReports.Items = append(configReports.Items, report)
+	for i := range dbReports {
+		configReports.Items = append(configReports.Items, newRestConfigReport(&dbReports[i]))
 	}
 
 	rsp := services.NewGetDaemonConfigReportsOK().WithPayload(configReports)
 	return rsp
 }
 
+// Converts the config report from the database to the format returned
+// over the REST API.
+func newRestConfigReport(dbReport *dbmodel.ConfigReport) *models.ConfigReport {
+	report := &models.ConfigReport{
+		ID:          dbReport.ID,
+		CreatedAt:   strfmt.DateTime(dbReport.CreatedAt),
+		LastSeenAt:  strfmt.DateTime(dbReport.LastSeenAt),
+		Checker:     dbReport.CheckerName,
+		Content:     dbReport.Content,
+		Fingerprint: dbReport.Fingerprint,
+	}
+	if !dbReport.ResolvedAt.IsZero() {
+		report.ResolvedAt = strfmt.DateTime(dbReport.ResolvedAt)
+	}
+	return report
+}
+
+// Converts the config review run from the database to the format
+// returned over the REST API.
+func newRestConfigReviewRun(dbRun *dbmodel.ConfigReviewRun) *models.ConfigReviewRun {
+	return &models.ConfigReviewRun{
+		ID:           dbRun.ID,
+		DaemonID:     dbRun.DaemonID,
+		CreatedAt:    strfmt.DateTime(dbRun.CreatedAt),
+		Trigger:      dbRun.Trigger,
+		ConfigHash:   dbRun.ConfigHash,
+		Signature:    dbRun.Signature,
+		ReportsCount: dbRun.ReportsCount,
+	}
+}
+
+// Get the history of the configuration review reports for a specified
+// daemon. It includes the reports that were resolved, i.e. the issues
+// that are no longer found by the config reviews. The start and limit
+// values are optional. They are used to retrieve paged reports.
+func (r *RestAPI) GetDaemonConfigReportsHistory(ctx context.Context, params services.GetDaemonConfigReportsHistoryParams) middleware.Responder {
+	start := int64(0)
+	if params.Start != nil {
+		start = *params.Start
+	}
+
+	limit := int64(0)
+	if params.Limit != nil {
+		limit = *params.Limit
+	}
+
+	dbReports, total, err := dbmodel.GetConfigReportHistoryByDaemonID(r.DB, start, limit, params.ID)
+	if err != nil {
+		log.Error(err)
+		msg := fmt.Sprintf("cannot get configuration review reports history for daemon with id %d from db", params.ID)
+		rsp := services.NewGetDaemonConfigReportsHistoryDefault(http.StatusInternalServerError).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+
+	configReports := &models.ConfigReports{
+		Total: total,
+	}
+	for i := range dbReports {
+		configReports.Items = append(configReports.Items, newRestConfigReport(&dbReports[i]))
+	}
+
+	rsp := services.NewGetDaemonConfigReportsHistoryOK().WithPayload(configReports)
+	return rsp
+}
+
+// Get the configuration review runs for a specified daemon, beginning
+// from the most recent one. The start and limit values are optional.
+// They are used to retrieve paged runs.
+func (r *RestAPI) GetDaemonConfigReviewRuns(ctx context.Context, params services.GetDaemonConfigReviewRunsParams) middleware.Responder {
+	start := int64(0)
+	if params.Start != nil {
+		start = *params.Start
+	}
+
+	limit := int64(0)
+	if params.Limit != nil {
+		limit = *params.Limit
+	}
+
+	dbRuns, total, err := dbmodel.GetConfigReviewRunsByDaemonID(r.DB, start, limit, params.ID)
+	if err != nil {
+		log.Error(err)
+		msg := fmt.Sprintf("cannot get configuration review runs for daemon with id %d from db", params.ID)
+		rsp := services.NewGetDaemonConfigReviewRunsDefault(http.StatusInternalServerError).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+
+	runs := &models.ConfigReviewRuns{
+		Total: total,
+	}
+	for i := range dbRuns {
+		runs.Items = append(runs.Items, newRestConfigReviewRun(&dbRuns[i]))
+	}
+
+	rsp := services.NewGetDaemonConfigReviewRunsOK().WithPayload(runs)
+	return rsp
+}
+
+// Get the configuration review run and the reports found during this
+// run. It returns HTTP Not Found status code when the run does not
+// exist or it does not belong to the specified daemon.
+func (r *RestAPI) GetDaemonConfigReviewRun(ctx context.Context, params services.GetDaemonConfigReviewRunParams) middleware.Responder {
+	dbRun, err := dbmodel.GetConfigReviewRunByID(r.DB, params.RunID)
+	if err != nil {
+		log.Error(err)
+		msg := fmt.Sprintf("cannot get configuration review run with id %d from db", params.RunID)
+		rsp := services.NewGetDaemonConfigReviewRunDefault(http.StatusInternalServerError).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+	if dbRun == nil || dbRun.DaemonID != params.ID {
+		msg := fmt.Sprintf("cannot find configuration review run with id %d for daemon with id %d", params.RunID, params.ID)
+		rsp := services.NewGetDaemonConfigReviewRunDefault(http.StatusNotFound).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+
+	dbReports, err := dbmodel.GetConfigReportsByReviewRun(r.DB, dbRun)
+	if err != nil {
+		log.Error(err)
+		msg := fmt.Sprintf("cannot get configuration review reports for run with id %d from db", params.RunID)
+		rsp := services.NewGetDaemonConfigReviewRunDefault(http.StatusInternalServerError).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+
+	runReports := &models.ConfigReviewRunReports{
+		Run:   newRestConfigReviewRun(dbRun),
+		Total: int64(len(dbReports)),
+	}
+	for i := range dbReports {
+		runReports.Items = append(runReports.Items, newRestConfigReport(&dbReports[i]))
+	}
+
+	rsp := services.NewGetDaemonConfigReviewRunOK().WithPayload(runReports)
+	return rsp
+}
+
 // Begins daemon configuration review on demand.
 func (r *RestAPI) PutDaemonConfigReview(ctx context.Context, params services.PutDaemonConfigReviewParams) middleware.Responder {
 	// Try to get the daemon information from the database.
@@ -183,3 +323,261 @@ func (r *RestAPI) PutDaemonConfigReview(ctx context.Context, params services.Put
 	rsp := services.NewPutDaemonConfigReviewAccepted()
 	return rsp
 }
+
+// Converts the config version from the database to the format returned
+// over the REST API. The configuration is included if it has been
+// fetched from the database.
+func newRestConfigVersion(dbVersion *dbmodel.KeaConfigVersion) *models.ConfigVersion {
+	version := &models.ConfigVersion{
+		ID:         dbVersion.ID,
+		DaemonID:   dbVersion.DaemonID,
+		CreatedAt:  strfmt.DateTime(dbVersion.CreatedAt),
+		ConfigHash: dbVersion.ConfigHash,
+	}
+	if dbVersion.Config != nil {
+		version.Config = dbVersion.Config
+	}
+	return version
+}
+
+// Converts the list of parameter changes to the format returned over
+// the REST API.
+func newRestConfigParameterChanges(changes []keaconfig.ParameterChange) (restChanges []*models.ConfigParameterChange) {
+	for _, change := range changes {
+		restChanges = append(restChanges, &models.ConfigParameterChange{
+			Name: change.Name,
+			Kind: string(change.Kind),
+			From: change.From,
+			To:   change.To,
+		})
+	}
+	return restChanges
+}
+
+// Converts the list of option changes to the format returned over
+// the REST API.
+func newRestConfigOptionChanges(changes []keaconfig.OptionChange) (restChanges []*models.ConfigOptionChange) {
+	for _, change := range changes {
+		restChanges = append(restChanges, &models.ConfigOptionChange{
+			Space: change.Space,
+			Code:  change.Code,
+			Name:  change.Name,
+			Kind:  string(change.Kind),
+			From:  change.From,
+			To:    change.To,
+		})
+	}
+	return restChanges
+}
+
+// Converts the list of configuration item changes to the format returned
+// over the REST API.
+func newRestConfigItemChanges(changes []keaconfig.ItemChange) (restChanges []*models.ConfigItemChange) {
+	for _, change := range changes {
+		restChanges = append(restChanges, &models.ConfigItemChange{
+			Key:  change.Key,
+			Kind: string(change.Kind),
+			From: change.From,
+			To:   change.To,
+		})
+	}
+	return restChanges
+}
+
+// Converts the structural difference between two configurations to the
+// format returned over the REST API.
+func newRestConfigDiff(diff *keaconfig.ConfigDiff) *models.ConfigDiff {
+	restDiff := &models.ConfigDiff{
+		Globals:        newRestConfigParameterChanges(diff.Globals),
+		Options:        newRestConfigOptionChanges(diff.Options),
+		Hooks:          newRestConfigItemChanges(diff.Hooks),
+		ClientClasses:  newRestConfigItemChanges(diff.ClientClasses),
+		Reservations:   newRestConfigItemChanges(diff.Reservations),
+		SubnetsAdded:   diff.SubnetsAdded,
+		SubnetsRemoved: diff.SubnetsRemoved,
+	}
+	for _, change := range diff.SubnetsModified {
+		restDiff.SubnetsModified = append(restDiff.SubnetsModified, &models.ConfigSubnetChange{
+			Prefix:       change.Prefix,
+			Parameters:   newRestConfigParameterChanges(change.Parameters),
+			Options:      newRestConfigOptionChanges(change.Options),
+			Reservations: newRestConfigItemChanges(change.Reservations),
+		})
+	}
+	return restDiff
+}
+
+// Get the configuration versions for a specified daemon, beginning
+// from the most recent one. The configurations are not returned. The
+// start and limit values are optional. They are used to retrieve paged
+// versions.
+func (r *RestAPI) GetDaemonConfigVersions(ctx context.Context, params services.GetDaemonConfigVersionsParams) middleware.Responder {
+	start := int64(0)
+	if params.Start != nil {
+		start = *params.Start
+	}
+
+	limit := int64(0)
+	if params.Limit != nil {
+		limit = *params.Limit
+	}
+
+	dbVersions, total, err := dbmodel.GetKeaConfigVersionsByDaemonID(r.DB, start, limit, params.ID)
+	if err != nil {
+		log.Error(err)
+		msg := fmt.Sprintf("cannot get configuration versions for daemon with id %d from db", params.ID)
+		rsp := services.NewGetDaemonConfigVersionsDefault(http.StatusInternalServerError).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+
+	versions := &models.ConfigVersions{
+		Total: total,
+	}
+	for i := range dbVersions {
+		versions.Items = append(versions.Items, newRestConfigVersion(&dbVersions[i]))
+	}
+
+	rsp := services.NewGetDaemonConfigVersionsOK().WithPayload(versions)
+	return rsp
+}
+
+// Fetches the configuration version of the daemon from the database. The
+// sensitive data are hidden from the configuration if the logged user is
+// not a super admin. It returns the HTTP status code and an error message
+// if the version can't be fetched or it doesn't belong to the daemon.
+func (r *RestAPI) getDaemonConfigVersion(ctx context.Context, daemonID, versionID int64) (*dbmodel.KeaConfigVersion, int, string) {
+	dbVersion, err := dbmodel.GetKeaConfigVersionByID(r.DB, versionID)
+	if err != nil {
+		log.Error(err)
+		return nil, http.StatusInternalServerError, fmt.Sprintf("cannot get configuration version with id %d from db", versionID)
+	}
+	if dbVersion == nil || dbVersion.DaemonID != daemonID {
+		return nil, http.StatusNotFound, fmt.Sprintf("cannot find configuration version with id %d for daemon with id %d", versionID, daemonID)
+	}
+	if dbVersion.Config != nil {
+		_, dbUser := r.SessionManager.Logged(ctx)
+		if !dbUser.InGroup(&dbmodel.SystemGroup{ID: dbmodel.SuperAdminGroupID}) {
+			storkutil.HideSensitiveData((*map[string]interface{})(dbVersion.Config))
+		}
+	}
+	return dbVersion, http.StatusOK, ""
+}
+
+// Get the configuration version for a specified daemon, including the
+// configuration. It returns HTTP Not Found status code when the version
+// does not exist or it does not belong to the specified daemon.
+func (r *RestAPI) GetDaemonConfigVersion(ctx context.Context, params services.GetDaemonConfigVersionParams) middleware.Responder {
+	dbVersion, status, msg := r.getDaemonConfigVersion(ctx, params.ID, params.VersionID)
+	if dbVersion == nil {
+		rsp := services.NewGetDaemonConfigVersionDefault(status).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+
+	rsp := services.NewGetDaemonConfigVersionOK().WithPayload(newRestConfigVersion(dbVersion))
+	return rsp
+}
+
+// Get the structural difference between two configuration versions of
+// a specified daemon. If the target version is not specified, the
+// source version is compared with the most recent version.
+func (r *RestAPI) GetDaemonConfigDiff(ctx context.Context, params services.GetDaemonConfigDiffParams) middleware.Responder {
+	fromVersion, status, msg := r.getDaemonConfigVersion(ctx, params.ID, params.From)
+	if fromVersion == nil {
+		rsp := services.NewGetDaemonConfigDiffDefault(status).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+
+	var toVersion *dbmodel.KeaConfigVersion
+	if params.To != nil {
+		toVersion, status, msg = r.getDaemonConfigVersion(ctx, params.ID, *params.To)
+	} else {
+		dbVersions, _, err := dbmodel.GetKeaConfigVersionsByDaemonID(r.DB, 0, 1, params.ID)
+		switch {
+		case err != nil:
+			log.Error(err)
+			status = http.StatusInternalServerError
+			msg = fmt.Sprintf("cannot get configuration versions for daemon with id %d from db", params.ID)
+		case len(dbVersions) == 0:
+			status = http.StatusNotFound
+			msg = fmt.Sprintf("cannot find configuration versions for daemon with id %d", params.ID)
+		default:
+			toVersion, status, msg = r.getDaemonConfigVersion(ctx, params.ID, dbVersions[0].ID)
+		}
+	}
+	if toVersion == nil {
+		rsp := services.NewGetDaemonConfigDiffDefault(status).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+
+	diff := newRestConfigDiff(keaconfig.Diff(fromVersion.Config, toVersion.Config))
+
+	// The configurations are not returned along with the diff.
+	fromVersion.Config = nil
+	toVersion.Config = nil
+	diff.FromVersion = newRestConfigVersion(fromVersion)
+	diff.ToVersion = newRestConfigVersion(toVersion)
+
+	rsp := services.NewGetDaemonConfigDiffOK().WithPayload(diff)
+	return rsp
+}
+
+// Fetches the Kea daemon having a configuration from the database. The
+// sensitive data are hidden from the configuration if the logged user is
+// not a super admin. It returns the HTTP status code and an error message
+// if the daemon can't be fetched, it is not a Kea daemon or it has no
+// configuration.
+func (r *RestAPI) getKeaDaemonWithConfig(ctx context.Context, daemonID int64) (*dbmodel.Daemon, int, string) {
+	dbDaemon, err := dbmodel.GetDaemonByID(r.DB, daemonID)
+	if err != nil {
+		log.Error(err)
+		return nil, http.StatusInternalServerError, fmt.Sprintf("cannot get daemon with id %d from db", daemonID)
+	}
+	if dbDaemon == nil {
+		return nil, http.StatusBadRequest, fmt.Sprintf("cannot find daemon with id %d", daemonID)
+	}
+	if dbDaemon.KeaDaemon == nil {
+		return nil, http.StatusBadRequest, fmt.Sprintf("daemon with id %d isn't Kea daemon", daemonID)
+	}
+	if dbDaemon.KeaDaemon.Config == nil {
+		return nil, http.StatusNotFound, fmt.Sprintf("config not assigned for daemon with id %d", daemonID)
+	}
+	_, dbUser := r.SessionManager.Logged(ctx)
+	if !dbUser.InGroup(&dbmodel.SystemGroup{ID: dbmodel.SuperAdminGroupID}) {
+		storkutil.HideSensitiveData((*map[string]interface{})(dbDaemon.KeaDaemon.Config))
+	}
+	return dbDaemon, http.StatusOK, ""
+}
+
+// Get the structural difference between the current configurations of
+// two Kea daemons. The daemons may be of different types, e.g. DHCPv4
+// and DHCPv6 servers, but such comparison is rarely useful.
+func (r *RestAPI) GetDaemonsConfigDiff(ctx context.Context, params services.GetDaemonsConfigDiffParams) middleware.Responder {
+	var daemons []*dbmodel.Daemon
+	for _, id := range []int64{params.ID, params.OtherID} {
+		dbDaemon, status, msg := r.getKeaDaemonWithConfig(ctx, id)
+		if dbDaemon == nil {
+			rsp := services.NewGetDaemonsConfigDiffDefault(status).WithPayload(&models.APIError{
+				Message: &msg,
+			})
+			return rsp
+		}
+		daemons = append(daemons, dbDaemon)
+	}
+
+	diff := &models.DaemonsConfigDiff{
+		DaemonID:      params.ID,
+		OtherDaemonID: params.OtherID,
+		Diff:          newRestConfigDiff(keaconfig.Diff(daemons[0].KeaDaemon.Config, daemons[1].KeaDaemon.Config)),
+	}
+
+	rsp := services.NewGetDaemonsConfigDiffOK().WithPayload(diff)
+	return rsp
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net/http"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 	agentcommtest "isc.org/stork/server/agentcomm/test"
@@ -710,6 +711,426 @@ func TestGetDaemonConfigReportsDatabaseError(t *testing.T) {
 		*defaultRsp.Payload.Message)
 }
 
+// Test that the configuration review runs and the reports history are
+// returned for a daemon.
+func TestGetDaemonConfigReviewRuns(t *testing.T) {
+	db, dbSettings, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	m := &dbmodel.Machine{
+		Address:   "localhost",
+		AgentPort: 8080,
+	}
+	err := dbmodel.AddMachine(db, m)
+	require.NoError(t, err)
+
+	app := &dbmodel.App{
+		MachineID: m.ID,
+		Machine:   m,
+		Type:      dbmodel.AppTypeKea,
+		Daemons: []*dbmodel.Daemon{
+			dbmodel.NewKeaDaemon("dhcp4", true),
+			dbmodel.NewKeaDaemon("dhcp6", true),
+		},
+	}
+	_, err = dbmodel.AddApp(db, app)
+	require.NoError(t, err)
+
+	fa := agentcommtest.NewFakeAgents(nil, nil)
+	fd := &storktest.FakeDispatcher{}
+	rapi, err := NewRestAPI(dbSettings, db, fa, fd)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	// Simulate two reviews. The first one found two issues. The
+	// second one found one of them.
+	firstReviewAt := time.Date(2022, 2, 10, 10, 0, 0, 0, time.UTC)
+	secondReviewAt := firstReviewAt.Add(time.Hour)
+	runs := []*dbmodel.ConfigReviewRun{
+		{
+			CreatedAt:    firstReviewAt,
+			Trigger:      "config change",
+			ConfigHash:   "1234",
+			Signature:    "2345",
+			ReportsCount: 2,
+			DaemonID:     app.Daemons[0].ID,
+		},
+		{
+			CreatedAt:    secondReviewAt,
+			Trigger:      "periodic",
+			ConfigHash:   "1234",
+			Signature:    "2345",
+			ReportsCount: 1,
+			DaemonID:     app.Daemons[0].ID,
+		},
+	}
+	for _, run := range runs {
+		err = dbmodel.AddConfigReviewRun(db, run)
+		require.NoError(t, err)
+	}
+	_, err = dbmodel.MergeConfigReports(db, app.Daemons[0].ID, firstReviewAt, []*dbmodel.ConfigReport{
+		{
+			CheckerName: "name 1",
+			Content:     "first issue",
+			Fingerprint: "1111",
+			DaemonID:    app.Daemons[0].ID,
+		},
+		{
+			CheckerName: "name 2",
+			Content:     "second issue",
+			Fingerprint: "2222",
+			DaemonID:    app.Daemons[0].ID,
+		},
+	})
+	require.NoError(t, err)
+	_, err = dbmodel.MergeConfigReports(db, app.Daemons[0].ID, secondReviewAt, []*dbmodel.ConfigReport{
+		{
+			CheckerName: "name 2",
+			Content:     "second issue",
+			Fingerprint: "2222",
+			DaemonID:    app.Daemons[0].ID,
+		},
+	})
+	require.NoError(t, err)
+
+	// Get the runs.
+	params := services.GetDaemonConfigReviewRunsParams{
+		ID: app.Daemons[0].ID,
+	}
+	rsp := rapi.GetDaemonConfigReviewRuns(ctx, params)
+	require.IsType(t, &services.GetDaemonConfigReviewRunsOK{}, rsp)
+	okRsp := rsp.(*services.GetDaemonConfigReviewRunsOK)
+	require.EqualValues(t, 2, okRsp.Payload.Total)
+	require.Len(t, okRsp.Payload.Items, 2)
+	require.Equal(t, "periodic", okRsp.Payload.Items[0].Trigger)
+	require.EqualValues(t, 1, okRsp.Payload.Items[0].ReportsCount)
+	require.Equal(t, "config change", okRsp.Payload.Items[1].Trigger)
+	require.EqualValues(t, 2, okRsp.Payload.Items[1].ReportsCount)
+
+	// Get the reports found during the first run.
+	runParams := services.GetDaemonConfigReviewRunParams{
+		ID:    app.Daemons[0].ID,
+		RunID: runs[0].ID,
+	}
+	rsp = rapi.GetDaemonConfigReviewRun(ctx, runParams)
+	require.IsType(t, &services.GetDaemonConfigReviewRunOK{}, rsp)
+	okRunRsp := rsp.(*services.GetDaemonConfigReviewRunOK)
+	require.NotNil(t, okRunRsp.Payload.Run)
+	require.Equal(t, runs[0].ID, okRunRsp.Payload.Run.ID)
+	require.EqualValues(t, 2, okRunRsp.Payload.Total)
+	require.Len(t, okRunRsp.Payload.Items, 2)
+
+	// Get the reports found during the second run.
+	runParams.RunID = runs[1].ID
+	rsp = rapi.GetDaemonConfigReviewRun(ctx, runParams)
+	require.IsType(t, &services.GetDaemonConfigReviewRunOK{}, rsp)
+	okRunRsp = rsp.(*services.GetDaemonConfigReviewRunOK)
+	require.EqualValues(t, 1, okRunRsp.Payload.Total)
+	require.Len(t, okRunRsp.Payload.Items, 1)
+	require.Equal(t, "2222", okRunRsp.Payload.Items[0].Fingerprint)
+
+	// The run does not belong to the second daemon.
+	runParams.ID = app.Daemons[1].ID
+	rsp = rapi.GetDaemonConfigReviewRun(ctx, runParams)
+	require.IsType(t, &services.GetDaemonConfigReviewRunDefault{}, rsp)
+	defaultRsp := rsp.(*services.GetDaemonConfigReviewRunDefault)
+	require.Equal(t, http.StatusNotFound, getStatusCode(*defaultRsp))
+
+	// Get the reports history. It should include the resolved report.
+	historyParams := services.GetDaemonConfigReportsHistoryParams{
+		ID: app.Daemons[0].ID,
+	}
+	rsp = rapi.GetDaemonConfigReportsHistory(ctx, historyParams)
+	require.IsType(t, &services.GetDaemonConfigReportsHistoryOK{}, rsp)
+	okHistoryRsp := rsp.(*services.GetDaemonConfigReportsHistoryOK)
+	require.EqualValues(t, 2, okHistoryRsp.Payload.Total)
+	require.Len(t, okHistoryRsp.Payload.Items, 2)
+	for _, item := range okHistoryRsp.Payload.Items {
+		switch item.Fingerprint {
+		case "1111":
+			require.Equal(t, secondReviewAt, time.Time(item.ResolvedAt))
+		case "2222":
+			require.Zero(t, item.ResolvedAt)
+			require.Equal(t, secondReviewAt, time.Time(item.LastSeenAt))
+		default:
+			require.FailNow(t, "unexpected report", item.Fingerprint)
+		}
+	}
+}
+
+// Test that the configuration versions and the difference between
+// them can be fetched over the REST API.
+func TestGetDaemonConfigVersionsAndDiff(t *testing.T) {
+	db, dbSettings, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	fa := agentcommtest.NewFakeAgents(nil, nil)
+	fd := &storktest.FakeDispatcher{}
+	rapi, err := NewRestAPI(dbSettings, db, fa, fd)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	// setup a user session, it is required to check user role
+	user, err := dbmodel.GetUserByID(rapi.DB, 1)
+	require.NoError(t, err)
+	ctx, err = rapi.SessionManager.Load(ctx, "")
+	require.NoError(t, err)
+	err = rapi.SessionManager.LoginHandler(ctx, user)
+	require.NoError(t, err)
+
+	m := &dbmodel.Machine{
+		Address:   "localhost",
+		AgentPort: 8080,
+	}
+	err = dbmodel.AddMachine(db, m)
+	require.NoError(t, err)
+
+	app := &dbmodel.App{
+		MachineID: m.ID,
+		Machine:   m,
+		Type:      dbmodel.AppTypeKea,
+		Daemons: []*dbmodel.Daemon{
+			dbmodel.NewKeaDaemon("dhcp4", true),
+		},
+	}
+	daemon := app.Daemons[0]
+	err = daemon.SetConfigFromJSON(`{
+        "Dhcp4": {
+            "valid-lifetime": 1000,
+            "subnet4": [
+                {
+                    "id": 1,
+                    "subnet": "192.0.2.0/24"
+                }
+            ]
+        }
+    }`)
+	require.NoError(t, err)
+	_, err = dbmodel.AddApp(db, app)
+	require.NoError(t, err)
+
+	// Change the configuration.
+	err = daemon.SetConfigFromJSON(`{
+        "Dhcp4": {
+            "valid-lifetime": 1000,
+            "option-data": [
+                {
+                    "code": 6,
+                    "data": "192.0.2.1"
+                }
+            ],
+            "subnet4": [
+                {
+                    "id": 2,
+                    "subnet": "192.0.3.0/24"
+                }
+            ]
+        }
+    }`)
+	require.NoError(t, err)
+	err = dbmodel.UpdateDaemon(db, daemon)
+	require.NoError(t, err)
+
+	// Get the list of versions.
+	params := services.GetDaemonConfigVersionsParams{
+		ID: daemon.ID,
+	}
+	rsp := rapi.GetDaemonConfigVersions(ctx, params)
+	require.IsType(t, &services.GetDaemonConfigVersionsOK{}, rsp)
+	versions := rsp.(*services.GetDaemonConfigVersionsOK).Payload
+	require.EqualValues(t, 2, versions.Total)
+	require.Len(t, versions.Items, 2)
+	require.Equal(t, daemon.KeaDaemon.ConfigHash, versions.Items[0].ConfigHash)
+	require.Nil(t, versions.Items[0].Config)
+	fromID := versions.Items[1].ID
+	toID := versions.Items[0].ID
+
+	// Get a single version.
+	versionParams := services.GetDaemonConfigVersionParams{
+		ID:        daemon.ID,
+		VersionID: fromID,
+	}
+	rsp = rapi.GetDaemonConfigVersion(ctx, versionParams)
+	require.IsType(t, &services.GetDaemonConfigVersionOK{}, rsp)
+	version := rsp.(*services.GetDaemonConfigVersionOK).Payload
+	require.Equal(t, fromID, version.ID)
+	require.NotNil(t, version.Config)
+
+	// The version must belong to the daemon.
+	versionParams.ID = daemon.ID + 1
+	rsp = rapi.GetDaemonConfigVersion(ctx, versionParams)
+	require.IsType(t, &services.GetDaemonConfigVersionDefault{}, rsp)
+	require.Equal(t, http.StatusNotFound, getStatusCode(*rsp.(*services.GetDaemonConfigVersionDefault)))
+
+	// Get the diff between the versions.
+	diffParams := services.GetDaemonConfigDiffParams{
+		ID:   daemon.ID,
+		From: fromID,
+		To:   &toID,
+	}
+	rsp = rapi.GetDaemonConfigDiff(ctx, diffParams)
+	require.IsType(t, &services.GetDaemonConfigDiffOK{}, rsp)
+	diff := rsp.(*services.GetDaemonConfigDiffOK).Payload
+	require.NotNil(t, diff.FromVersion)
+	require.Equal(t, fromID, diff.FromVersion.ID)
+	require.Nil(t, diff.FromVersion.Config)
+	require.NotNil(t, diff.ToVersion)
+	require.Equal(t, toID, diff.ToVersion.ID)
+	require.Empty(t, diff.Globals)
+	require.Len(t, diff.Options, 1)
+	require.EqualValues(t, 6, diff.Options[0].Code)
+	require.Equal(t, "added", diff.Options[0].Kind)
+	require.Equal(t, []string{"192.0.3.0/24"}, diff.SubnetsAdded)
+	require.Equal(t, []string{"192.0.2.0/24"}, diff.SubnetsRemoved)
+	require.Empty(t, diff.SubnetsModified)
+
+	// Compare with the most recent version when the target version
+	// is not specified.
+	diffParams.To = nil
+	rsp = rapi.GetDaemonConfigDiff(ctx, diffParams)
+	require.IsType(t, &services.GetDaemonConfigDiffOK{}, rsp)
+	diff = rsp.(*services.GetDaemonConfigDiffOK).Payload
+	require.Equal(t, toID, diff.ToVersion.ID)
+	require.Len(t, diff.SubnetsAdded, 1)
+
+	// Non-existing source version.
+	diffParams.From = toID + 100
+	rsp = rapi.GetDaemonConfigDiff(ctx, diffParams)
+	require.IsType(t, &services.GetDaemonConfigDiffDefault{}, rsp)
+	require.Equal(t, http.StatusNotFound, getStatusCode(*rsp.(*services.GetDaemonConfigDiffDefault)))
+}
+
+// Test that the configurations of two daemons can be compared over
+// the REST API.
+func TestGetDaemonsConfigDiff(t *testing.T) {
+	db, dbSettings, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	fa := agentcommtest.NewFakeAgents(nil, nil)
+	fd := &storktest.FakeDispatcher{}
+	rapi, err := NewRestAPI(dbSettings, db, fa, fd)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	// setup a user session, it is required to check user role
+	user, err := dbmodel.GetUserByID(rapi.DB, 1)
+	require.NoError(t, err)
+	ctx, err = rapi.SessionManager.Load(ctx, "")
+	require.NoError(t, err)
+	err = rapi.SessionManager.LoginHandler(ctx, user)
+	require.NoError(t, err)
+
+	m := &dbmodel.Machine{
+		Address:   "localhost",
+		AgentPort: 8080,
+	}
+	err = dbmodel.AddMachine(db, m)
+	require.NoError(t, err)
+
+	// Add two apps with DHCPv4 daemons having different configurations
+	// and an app with the BIND 9 daemon.
+	configs := []string{
+		`{
+            "Dhcp4": {
+                "valid-lifetime": 1000,
+                "client-classes": [
+                    {
+                        "name": "foo"
+                    }
+                ],
+                "subnet4": [
+                    {
+                        "id": 1,
+                        "subnet": "192.0.2.0/24",
+                        "reservations": [
+                            {
+                                "hw-address": "01:02:03:04:05:06",
+                                "ip-address": "192.0.2.10"
+                            }
+                        ]
+                    }
+                ]
+            }
+        }`,
+		`{
+            "Dhcp4": {
+                "valid-lifetime": 1000,
+                "hooks-libraries": [
+                    {
+                        "library": "/usr/lib/kea/libdhcp_lease_cmds.so"
+                    }
+                ],
+                "subnet4": [
+                    {
+                        "id": 1,
+                        "subnet": "192.0.2.0/24"
+                    }
+                ]
+            }
+        }`,
+	}
+	var daemons []*dbmodel.Daemon
+	for i, config := range configs {
+		daemon := dbmodel.NewKeaDaemon("dhcp4", true)
+		err = daemon.SetConfigFromJSON(config)
+		require.NoError(t, err)
+		app := &dbmodel.App{
+			MachineID: m.ID,
+			Type:      dbmodel.AppTypeKea,
+			Name:      fmt.Sprintf("kea%d", i),
+			AccessPoints: dbmodel.AppendAccessPoint(nil, dbmodel.AccessPointControl,
+				"localhost", "", int64(8000+i), true),
+			Daemons: []*dbmodel.Daemon{daemon},
+		}
+		_, err = dbmodel.AddApp(db, app)
+		require.NoError(t, err)
+		daemons = append(daemons, daemon)
+	}
+	bind9App := &dbmodel.App{
+		MachineID: m.ID,
+		Type:      dbmodel.AppTypeBind9,
+		Daemons: []*dbmodel.Daemon{
+			dbmodel.NewBind9Daemon(true),
+		},
+	}
+	_, err = dbmodel.AddApp(db, bind9App)
+	require.NoError(t, err)
+
+	params := services.GetDaemonsConfigDiffParams{
+		ID:      daemons[0].ID,
+		OtherID: daemons[1].ID,
+	}
+	rsp := rapi.GetDaemonsConfigDiff(ctx, params)
+	require.IsType(t, &services.GetDaemonsConfigDiffOK{}, rsp)
+	payload := rsp.(*services.GetDaemonsConfigDiffOK).Payload
+	require.Equal(t, daemons[0].ID, payload.DaemonID)
+	require.Equal(t, daemons[1].ID, payload.OtherDaemonID)
+	require.NotNil(t, payload.Diff)
+	require.Empty(t, payload.Diff.Globals)
+	require.Len(t, payload.Diff.Hooks, 1)
+	require.Equal(t, "/usr/lib/kea/libdhcp_lease_cmds.so", payload.Diff.Hooks[0].Key)
+	require.Equal(t, "added", payload.Diff.Hooks[0].Kind)
+	require.Len(t, payload.Diff.ClientClasses, 1)
+	require.Equal(t, "foo", payload.Diff.ClientClasses[0].Key)
+	require.Equal(t, "removed", payload.Diff.ClientClasses[0].Kind)
+	require.Len(t, payload.Diff.SubnetsModified, 1)
+	require.Equal(t, "192.0.2.0/24", payload.Diff.SubnetsModified[0].Prefix)
+	require.Len(t, payload.Diff.SubnetsModified[0].Reservations, 1)
+	require.Equal(t, "hw-address=01:02:03:04:05:06", payload.Diff.SubnetsModified[0].Reservations[0].Key)
+
+	// Comparing with a non-Kea daemon is not supported.
+	params.OtherID = bind9App.Daemons[0].ID
+	rsp = rapi.GetDaemonsConfigDiff(ctx, params)
+	require.IsType(t, &services.GetDaemonsConfigDiffDefault{}, rsp)
+	require.Equal(t, http.StatusBadRequest, getStatusCode(*rsp.(*services.GetDaemonsConfigDiffDefault)))
+
+	// Non-existing daemon.
+	params.OtherID = bind9App.Daemons[0].ID + 100
+	rsp = rapi.GetDaemonsConfigDiff(ctx, params)
+	require.IsType(t, &services.GetDaemonsConfigDiffDefault{}, rsp)
+	require.Equal(t, http.StatusBadRequest, getStatusCode(*rsp.(*services.GetDaemonsConfigDiffDefault)))
+}
+
 // Test triggering new configuration review for a daemon.
 func TestPutDaemonConfigReview(t *testing.T) {
 	db, dbSettings, teardown := dbtest.SetupDatabaseTestCase(t)
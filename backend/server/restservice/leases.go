@@ -59,12 +59,12 @@ func (r *RestAPI) GetLeases(ctx context.Context, params dhcp.GetLeasesParams) mi
 		// Handle a special case when user specified state:declined search text
 		// to find declined leases.
 		if ok, _ := regexp.MatchString(`^state:\s*declined$`, text); ok {
-			keaLeases, erredApps, err = kea.FindDeclinedLeases(r.DB, r.Agents)
+			keaLeases, erredApps, err = kea.FindDeclinedLeases(ctx, r.DB, r.Agents)
 		} else {
-			keaLeases, erredApps, err = kea.FindLeases(r.DB, r.Agents, text)
+			keaLeases, erredApps, err = kea.FindLeases(ctx, r.DB, r.Agents, text)
 		}
 	} else {
-		keaLeases, conflicts, erredApps, err = kea.FindLeasesByHostID(r.DB, r.Agents, hostID)
+		keaLeases, conflicts, erredApps, err = kea.FindLeasesByHostID(ctx, r.DB, r.Agents, hostID)
 	}
 	if err != nil {
 		msg := "problem with searching leases on the Kea servers due to Stork database errors"
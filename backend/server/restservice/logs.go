@@ -2,48 +2,68 @@ package restservice
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/go-openapi/runtime/middleware"
+	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 
+	"isc.org/stork/server/agentcomm"
 	dbmodel "isc.org/stork/server/database/model"
 	"isc.org/stork/server/gen/models"
 	"isc.org/stork/server/gen/restapi/operations/services"
 )
 
-// Get tail of the specified log file.
-func (r *RestAPI) GetLogTail(ctx context.Context, params services.GetLogTailParams) middleware.Responder {
+// Checks if the log output is a file. Other outputs, i.e. standard output,
+// standard error and syslog, can't be viewed.
+func isLogFile(output string) bool {
+	return output != "stdout" && output != "stderr" && !strings.HasPrefix(output, "syslog")
+}
+
+// Fetches the log target with the specified ID from the database and checks
+// whether it can be viewed. If it can't, the returned HTTP status code and
+// the message describe the problem.
+func (r *RestAPI) getViewableLogTarget(id int64) (*dbmodel.LogTarget, int, string) {
 	// We have ID of the log file to display. We need to get the details
 	// of the file from the database.
-	dbLogTarget, err := dbmodel.GetLogTargetByID(r.DB, params.ID)
+	dbLogTarget, err := dbmodel.GetLogTargetByID(r.DB, id)
 	if err != nil {
-		msg := fmt.Sprintf("cannot get information about the log file with id %d from the database", params.ID)
+		msg := fmt.Sprintf("cannot get information about the log file with id %d from the database", id)
 		log.Error(msg)
-		rsp := services.NewGetLogTailDefault(http.StatusInternalServerError).WithPayload(&models.APIError{
-			Message: &msg,
-		})
-		return rsp
+		return nil, http.StatusInternalServerError, msg
 	}
 
 	// Handle the case when referencing the non-existing file.
 	if dbLogTarget == nil {
-		msg := fmt.Sprintf("log file with id %d does not exist", params.ID)
+		msg := fmt.Sprintf("log file with id %d does not exist", id)
 		log.Warn(msg)
-		rsp := services.NewGetLogTailDefault(http.StatusNotFound).WithPayload(&models.APIError{
-			Message: &msg,
-		})
-		return rsp
+		return nil, http.StatusNotFound, msg
 	}
 
 	// Currently we only support viewing log files.
-	if dbLogTarget.Output == "stdout" || dbLogTarget.Output == "stderr" ||
-		strings.HasPrefix(dbLogTarget.Output, "syslog") {
+	if !isLogFile(dbLogTarget.Output) {
 		msg := fmt.Sprintf("viewing log from %s is not supported", dbLogTarget.Output)
 		log.Warn(msg)
-		rsp := services.NewGetLogTailDefault(http.StatusBadRequest).WithPayload(&models.APIError{
+		return nil, http.StatusBadRequest, msg
+	}
+
+	return dbLogTarget, http.StatusOK, ""
+}
+
+// Get tail of the specified log file.
+func (r *RestAPI) GetLogTail(ctx context.Context, params services.GetLogTailParams) middleware.Responder {
+	dbLogTarget, status, msg := r.getViewableLogTarget(params.ID)
+	if dbLogTarget == nil {
+		rsp := services.NewGetLogTailDefault(status).WithPayload(&models.APIError{
 			Message: &msg,
 		})
 		return rsp
@@ -82,3 +102,333 @@ func (r *RestAPI) GetLogTail(ctx context.Context, params services.GetLogTailPara
 
 	return rsp
 }
+
+// Upper bound of the length of the data located at the end of the followed
+// log file which is sent first.
+const maxFollowedLogLength = 1000000
+
+// Follows the specified log file and relays the lines appended to it to
+// the browser as server-sent events (SSE). The request path ends with the
+// log target ID, e.g. /sse/logs/1. The optional maxLength query parameter
+// specifies the length of the data located at the end of the file which
+// is sent first. It is limited to maxFollowedLogLength and it must not be
+// negative. Each event holds a JSON object with the list of lines. If
+// following the file fails, the error event with the error message is
+// sent and the connection is closed. The session must be loaded into the
+// request context before calling this handler.
+func (r *RestAPI) FollowLog(w http.ResponseWriter, req *http.Request) {
+	if ok, _ := r.SessionManager.Logged(req.Context()); !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	id, err := strconv.ParseInt(path.Base(req.URL.Path), 10, 64)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid log file id in %s", req.URL.Path), http.StatusBadRequest)
+		return
+	}
+
+	dbLogTarget, status, msg := r.getViewableLogTarget(id)
+	if dbLogTarget == nil {
+		http.Error(w, msg, status)
+		return
+	}
+
+	// Set the maximum length of the data sent first. Default is 4000 bytes.
+	maxLength := int64(4000)
+	if value := req.URL.Query().Get("maxLength"); value != "" {
+		maxLength, err = strconv.ParseInt(value, 10, 64)
+		if err != nil || maxLength < 0 {
+			http.Error(w, fmt.Sprintf("invalid maxLength value %s", value), http.StatusBadRequest)
+			return
+		}
+		if maxLength > maxFollowedLogLength {
+			maxLength = maxFollowedLogLength
+		}
+	}
+
+	// prepare proper HTTP headers for SSE response
+	h := w.Header()
+	h.Set("Connection", "keep-alive")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("X-Accel-Buffering", "no")
+
+	// Not all ResponseWriter instances implement http.Flusher interface.
+	flush := func() {
+		if flusher, ok := w.(http.Flusher); ok {
+			flusher.Flush()
+		}
+	}
+	flush()
+
+	log.Printf("new log follower of %s from %s", dbLogTarget.Output, req.RemoteAddr)
+
+	err = r.Agents.FollowTextFile(req.Context(), dbLogTarget.Daemon.App.Machine.Address,
+		dbLogTarget.Daemon.App.Machine.AgentPort, dbLogTarget.Output, maxLength,
+		func(lines []string) error {
+			data, err := json.Marshal(map[string][]string{"lines": lines})
+			if err != nil {
+				return errors.Wrap(err, "problem with serializing log lines to json")
+			}
+			if _, err = fmt.Fprintf(w, "data: %s\n\n", data); err != nil {
+				return errors.Wrap(err, "problem with sending log lines")
+			}
+			flush()
+			return nil
+		})
+	if err != nil {
+		log.Warnf("problem with following log file %s: %+v", dbLogTarget.Output, err)
+		data, _ := json.Marshal(map[string]string{"message": err.Error()})
+		fmt.Fprintf(w, "event: error\ndata: %s\n\n", data)
+		flush()
+	}
+	log.Printf("log follower of %s from %s finished", dbLogTarget.Output, req.RemoteAddr)
+}
+
+// Default maximum number of matches returned for a single log file.
+const defaultLogSearchMaxMatches = 1000
+
+// Upper bound of the maximum number of matches returned for a single log
+// file. It is also used when the maxMatches parameter is 0.
+const maxLogSearchMaxMatches = 10000
+
+// Maximum number of log files searched concurrently.
+const maxLogSearchConcurrency = 8
+
+// Maximum number of context lines returned before and after each match.
+const maxLogSearchContextLines = 10
+
+// Identifies the log file in which the search was conducted.
+type logSearchFile struct {
+	LogTargetID    int64  `json:"logTargetId"`
+	MachineID      int64  `json:"machineId"`
+	MachineAddress string `json:"machineAddress"`
+	AppID          int64  `json:"appId"`
+	AppName        string `json:"appName"`
+	DaemonID       int64  `json:"daemonId"`
+	DaemonName     string `json:"daemonName"`
+	File           string `json:"file"`
+}
+
+// Line matching the search criteria sent as the match event.
+type logSearchMatch struct {
+	logSearchFile
+	LineNumber int64    `json:"lineNumber"`
+	Line       string   `json:"line"`
+	Before     []string `json:"before"`
+	After      []string `json:"after"`
+}
+
+// Search summary for a log file sent as the file event. The match count
+// is at most the maximum number of matches returned for a single log file.
+// The truncated flag indicates that the file contains more matches which
+// have not been returned.
+type logSearchFileSummary struct {
+	logSearchFile
+	MatchCount int64  `json:"matchCount"`
+	Truncated  bool   `json:"truncated,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// Search summary for all log files sent as the done event.
+type logSearchSummary struct {
+	FileCount  int64 `json:"fileCount"`
+	MatchCount int64 `json:"matchCount"`
+}
+
+// Parses the log search criteria from the query parameters of the request.
+func parseLogSearchCriteria(query url.Values) (*agentcomm.TextFileSearchCriteria, error) {
+	criteria := &agentcomm.TextFileSearchCriteria{
+		Pattern:    query.Get("pattern"),
+		MaxMatches: defaultLogSearchMaxMatches,
+	}
+	if _, err := regexp.Compile(criteria.Pattern); err != nil {
+		return nil, errors.Wrapf(err, "invalid search pattern %s", criteria.Pattern)
+	}
+	for name, value := range map[string]*time.Time{"since": &criteria.Since, "until": &criteria.Until} {
+		if text := query.Get(name); text != "" {
+			ts, err := time.Parse(time.RFC3339, text)
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid %s value %s", name, text)
+			}
+			*value = ts
+		}
+	}
+	for name, value := range map[string]*int64{"context": &criteria.ContextLines, "maxMatches": &criteria.MaxMatches} {
+		if text := query.Get(name); text != "" {
+			number, err := strconv.ParseInt(text, 10, 64)
+			if err != nil || number < 0 {
+				return nil, errors.Errorf("invalid %s value %s", name, text)
+			}
+			*value = number
+		}
+	}
+	if criteria.ContextLines > maxLogSearchContextLines {
+		criteria.ContextLines = maxLogSearchContextLines
+	}
+	if criteria.MaxMatches == 0 || criteria.MaxMatches > maxLogSearchMaxMatches {
+		criteria.MaxMatches = maxLogSearchMaxMatches
+	}
+	if criteria.Pattern == "" && criteria.Since.IsZero() && criteria.Until.IsZero() {
+		return nil, errors.New("search pattern or time range must be specified")
+	}
+	return criteria, nil
+}
+
+// Searches the log files of the selected daemons for the lines matching the
+// regular expression and/or logged within the time range. The search is
+// executed by the agents concurrently and the results are relayed to the
+// browser as server-sent events (SSE) as they are received. The request path
+// is /sse/logs/search. The query parameters are:
+//
+//   - daemonId - ID of the daemon which log files should be searched; it may be
+//     specified multiple times; if it is not specified, all daemons are searched,
+//   - pattern - regular expression matched against the log lines,
+//   - since, until - time range in RFC3339 format,
+//   - context - number of lines returned before and after each match,
+//   - maxMatches - maximum number of matches returned for a single log file;
+//     it is limited to maxLogSearchMaxMatches.
+//
+// At most maxLogSearchConcurrency log files are searched at the same time.
+// The match event is sent for each matching line. The file event holding the
+// number of matches or an error is sent when the search in a file completes.
+// The agents are asked for one match more than the maximum, so the file event
+// can indicate that the file contains more matches than returned.
+// The done event holding the total numbers of searched files and matches is
+// sent when the search completes. The session must be loaded into the request
+// context before calling this handler.
+func (r *RestAPI) SearchLogs(w http.ResponseWriter, req *http.Request) {
+	if ok, _ := r.SessionManager.Logged(req.Context()); !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	query := req.URL.Query()
+	criteria, err := parseLogSearchCriteria(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var daemonIDs []int64
+	for _, text := range query["daemonId"] {
+		id, err := strconv.ParseInt(text, 10, 64)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid daemonId value %s", text), http.StatusBadRequest)
+			return
+		}
+		daemonIDs = append(daemonIDs, id)
+	}
+
+	dbLogTargets, err := dbmodel.GetLogTargetsByDaemonIDs(r.DB, daemonIDs)
+	if err != nil {
+		log.Error(err)
+		http.Error(w, "cannot get information about the log files from the database", http.StatusInternalServerError)
+		return
+	}
+
+	// prepare proper HTTP headers for SSE response
+	h := w.Header()
+	h.Set("Connection", "keep-alive")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("X-Accel-Buffering", "no")
+
+	// The events are sent from multiple goroutines.
+	var mutex sync.Mutex
+	sendEvent := func(event string, payload interface{}) error {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return errors.Wrap(err, "problem with serializing log search results to json")
+		}
+		mutex.Lock()
+		defer mutex.Unlock()
+		if _, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data); err != nil {
+			return errors.Wrap(err, "problem with sending log search results")
+		}
+		// Not all ResponseWriter instances implement http.Flusher interface.
+		if flusher, ok := w.(http.Flusher); ok {
+			flusher.Flush()
+		}
+		return nil
+	}
+
+	// One more match is requested to find out if there are more matches
+	// than returned.
+	agentCriteria := *criteria
+	agentCriteria.MaxMatches++
+
+	summary := &logSearchSummary{}
+	var wg sync.WaitGroup
+	// Limits the number of concurrent searches.
+	semaphore := make(chan struct{}, maxLogSearchConcurrency)
+	for _, dbLogTarget := range dbLogTargets {
+		if !isLogFile(dbLogTarget.Output) || dbLogTarget.Daemon == nil ||
+			dbLogTarget.Daemon.App == nil || dbLogTarget.Daemon.App.Machine == nil {
+			continue
+		}
+		summary.FileCount++
+
+		file := logSearchFile{
+			LogTargetID:    dbLogTarget.ID,
+			MachineID:      dbLogTarget.Daemon.App.MachineID,
+			MachineAddress: dbLogTarget.Daemon.App.Machine.Address,
+			AppID:          dbLogTarget.Daemon.App.ID,
+			AppName:        dbLogTarget.Daemon.App.Name,
+			DaemonID:       dbLogTarget.DaemonID,
+			DaemonName:     dbLogTarget.Daemon.Name,
+			File:           dbLogTarget.Output,
+		}
+		machine := dbLogTarget.Daemon.App.Machine
+
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func() {
+			defer func() {
+				<-semaphore
+				wg.Done()
+			}()
+			var relayed int64
+			count, err := r.Agents.SearchTextFile(req.Context(), machine.Address, machine.AgentPort, file.File, &agentCriteria,
+				func(matches []*agentcomm.TextFileMatch) error {
+					for _, m := range matches {
+						if relayed >= criteria.MaxMatches {
+							break
+						}
+						relayed++
+						err := sendEvent("match", &logSearchMatch{
+							logSearchFile: file,
+							LineNumber:    m.LineNumber,
+							Line:          m.Line,
+							Before:        m.Before,
+							After:         m.After,
+						})
+						if err != nil {
+							return err
+						}
+					}
+					return nil
+				})
+			fileSummary := &logSearchFileSummary{
+				logSearchFile: file,
+				MatchCount:    count,
+			}
+			if count > criteria.MaxMatches {
+				fileSummary.MatchCount = criteria.MaxMatches
+				fileSummary.Truncated = true
+			}
+			if err != nil {
+				log.Warnf("problem with searching log file %s: %+v", file.File, err)
+				fileSummary.Error = err.Error()
+			}
+			mutex.Lock()
+			summary.MatchCount += fileSummary.MatchCount
+			mutex.Unlock()
+			_ = sendEvent("file", fileSummary)
+		}()
+	}
+	wg.Wait()
+
+	_ = sendEvent("done", summary)
+}
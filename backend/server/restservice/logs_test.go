@@ -4,9 +4,13 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/require"
+	"isc.org/stork/server/agentcomm"
 	agentcommtest "isc.org/stork/server/agentcomm/test"
 	dbmodel "isc.org/stork/server/database/model"
 	dbtest "isc.org/stork/server/database/test"
@@ -156,3 +160,249 @@ func TestLogTailBadParams(t *testing.T) {
 			*defaultRsp.Payload.Message)
 	}
 }
+
+// This test verifies that the followed log file is relayed as
+// server-sent events to the logged user.
+func TestFollowLog(t *testing.T) {
+	db, dbSettings, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	m := &dbmodel.Machine{
+		ID:        0,
+		Address:   "localhost",
+		AgentPort: 8080,
+	}
+	err := dbmodel.AddMachine(db, m)
+	require.NoError(t, err)
+
+	a := &dbmodel.App{
+		ID:        0,
+		MachineID: m.ID,
+		Type:      dbmodel.AppTypeKea,
+		Active:    true,
+		Daemons: []*dbmodel.Daemon{
+			{
+				Name:    "kea-dhcp4",
+				Version: "1.7.5",
+				Active:  true,
+				LogTargets: []*dbmodel.LogTarget{
+					{
+						Output: "/tmp/filename.log",
+					},
+					{
+						Output: "stdout",
+					},
+				},
+			},
+		},
+	}
+	_, err = dbmodel.AddApp(db, a)
+	require.NoError(t, err)
+	fileTargetID := a.Daemons[0].LogTargets[0].ID
+	stdoutTargetID := a.Daemons[0].LogTargets[1].ID
+
+	fa := agentcommtest.NewFakeAgents(nil, nil)
+	rapi, err := NewRestAPI(dbSettings, db, fa)
+	require.NoError(t, err)
+
+	// The user is not logged in.
+	req := httptest.NewRequest("GET", fmt.Sprintf("http://localhost/sse/logs/%d", fileTargetID), nil)
+	w := httptest.NewRecorder()
+	rapi.FollowLog(w, req)
+	require.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// Log in the user.
+	user, err := dbmodel.GetUserByID(rapi.DB, 1)
+	require.NoError(t, err)
+	ctx, err := rapi.SessionManager.Load(context.Background(), "")
+	require.NoError(t, err)
+	err = rapi.SessionManager.LoginHandler(ctx, user)
+	require.NoError(t, err)
+
+	// The lines returned by the fake agent should be relayed.
+	req = httptest.NewRequest("GET", fmt.Sprintf("http://localhost/sse/logs/%d?maxLength=100", fileTargetID), nil)
+	w = httptest.NewRecorder()
+	rapi.FollowLog(w, req.WithContext(ctx))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "text/event-stream", w.Header().Get("Content-Type"))
+	require.Equal(t, "data: {\"lines\":[\"lorem ipsum\",\"dolor sit amet\"]}\n\n", w.Body.String())
+	require.EqualValues(t, 100, fa.RecordedFollowOffset)
+
+	// The length of the data sent first is limited.
+	req = httptest.NewRequest("GET", fmt.Sprintf("http://localhost/sse/logs/%d?maxLength=1000000000", fileTargetID), nil)
+	w = httptest.NewRecorder()
+	rapi.FollowLog(w, req.WithContext(ctx))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.EqualValues(t, maxFollowedLogLength, fa.RecordedFollowOffset)
+
+	// Negative length is rejected.
+	req = httptest.NewRequest("GET", fmt.Sprintf("http://localhost/sse/logs/%d?maxLength=-1", fileTargetID), nil)
+	w = httptest.NewRecorder()
+	rapi.FollowLog(w, req.WithContext(ctx))
+	require.Equal(t, http.StatusBadRequest, w.Code)
+
+	// Following the standard output is not supported.
+	req = httptest.NewRequest("GET", fmt.Sprintf("http://localhost/sse/logs/%d", stdoutTargetID), nil)
+	w = httptest.NewRecorder()
+	rapi.FollowLog(w, req.WithContext(ctx))
+	require.Equal(t, http.StatusBadRequest, w.Code)
+
+	// Non-existing log target.
+	req = httptest.NewRequest("GET", fmt.Sprintf("http://localhost/sse/logs/%d", fileTargetID+10), nil)
+	w = httptest.NewRecorder()
+	rapi.FollowLog(w, req.WithContext(ctx))
+	require.Equal(t, http.StatusNotFound, w.Code)
+
+	// Invalid log target ID.
+	req = httptest.NewRequest("GET", "http://localhost/sse/logs/abc", nil)
+	w = httptest.NewRecorder()
+	rapi.FollowLog(w, req.WithContext(ctx))
+	require.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+// This test verifies that the log files of the selected daemons can be
+// searched and that the results are sent as server-sent events.
+func TestSearchLogs(t *testing.T) {
+	db, dbSettings, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	m := &dbmodel.Machine{
+		ID:        0,
+		Address:   "localhost",
+		AgentPort: 8080,
+	}
+	err := dbmodel.AddMachine(db, m)
+	require.NoError(t, err)
+
+	a := &dbmodel.App{
+		ID:        0,
+		MachineID: m.ID,
+		Type:      dbmodel.AppTypeKea,
+		Active:    true,
+		Daemons: []*dbmodel.Daemon{
+			{
+				Name:    "kea-dhcp4",
+				Version: "1.7.5",
+				Active:  true,
+				LogTargets: []*dbmodel.LogTarget{
+					{
+						Output: "/tmp/filename.log",
+					},
+					{
+						Output: "stdout",
+					},
+				},
+			},
+		},
+	}
+	_, err = dbmodel.AddApp(db, a)
+	require.NoError(t, err)
+	daemonID := a.Daemons[0].ID
+
+	fa := agentcommtest.NewFakeAgents(nil, nil)
+	rapi, err := NewRestAPI(dbSettings, db, fa)
+	require.NoError(t, err)
+
+	// The user is not logged in.
+	req := httptest.NewRequest("GET", "http://localhost/sse/logs/search?pattern=lorem", nil)
+	w := httptest.NewRecorder()
+	rapi.SearchLogs(w, req)
+	require.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// Log in the user.
+	user, err := dbmodel.GetUserByID(rapi.DB, 1)
+	require.NoError(t, err)
+	ctx, err := rapi.SessionManager.Load(context.Background(), "")
+	require.NoError(t, err)
+	err = rapi.SessionManager.LoginHandler(ctx, user)
+	require.NoError(t, err)
+
+	// The match returned by the fake agent should be relayed. The
+	// standard output should be skipped.
+	req = httptest.NewRequest("GET", fmt.Sprintf("http://localhost/sse/logs/search?daemonId=%d&pattern=lorem&context=1", daemonID), nil)
+	w = httptest.NewRecorder()
+	rapi.SearchLogs(w, req.WithContext(ctx))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "text/event-stream", w.Header().Get("Content-Type"))
+	body := w.Body.String()
+	require.Contains(t, body, "event: match\ndata: ")
+	require.Contains(t, body, `"file":"/tmp/filename.log","lineNumber":2,"line":"lorem ipsum","before":["dolor sit amet"]`)
+	require.Contains(t, body, `"daemonName":"kea-dhcp4"`)
+	require.Contains(t, body, "event: file\ndata: ")
+	require.Contains(t, body, `"matchCount":1}`)
+	require.True(t, strings.HasSuffix(body, "event: done\ndata: {\"fileCount\":1,\"matchCount\":1}\n\n"))
+
+	// The file contains more matches than the maximum.
+	fa.SearchMatches = []*agentcomm.TextFileMatch{
+		{LineNumber: 1, Line: "lorem ipsum"},
+		{LineNumber: 3, Line: "lorem ipsum"},
+		{LineNumber: 5, Line: "lorem ipsum"},
+	}
+	req = httptest.NewRequest("GET", fmt.Sprintf("http://localhost/sse/logs/search?daemonId=%d&pattern=lorem&maxMatches=2", daemonID), nil)
+	w = httptest.NewRecorder()
+	rapi.SearchLogs(w, req.WithContext(ctx))
+	require.Equal(t, http.StatusOK, w.Code)
+	body = w.Body.String()
+	require.Equal(t, 2, strings.Count(body, "event: match\n"))
+	require.NotContains(t, body, `"lineNumber":5`)
+	require.Contains(t, body, `"matchCount":2,"truncated":true}`)
+	require.True(t, strings.HasSuffix(body, "event: done\ndata: {\"fileCount\":1,\"matchCount\":2}\n\n"))
+
+	// The file contains exactly the maximum number of matches.
+	req = httptest.NewRequest("GET", fmt.Sprintf("http://localhost/sse/logs/search?daemonId=%d&pattern=lorem&maxMatches=3", daemonID), nil)
+	w = httptest.NewRecorder()
+	rapi.SearchLogs(w, req.WithContext(ctx))
+	require.Equal(t, http.StatusOK, w.Code)
+	body = w.Body.String()
+	require.Equal(t, 3, strings.Count(body, "event: match\n"))
+	require.Contains(t, body, `"matchCount":3}`)
+	require.NotContains(t, body, "truncated")
+
+	// Invalid pattern.
+	req = httptest.NewRequest("GET", "http://localhost/sse/logs/search?pattern=%5Babc", nil)
+	w = httptest.NewRecorder()
+	rapi.SearchLogs(w, req.WithContext(ctx))
+	require.Equal(t, http.StatusBadRequest, w.Code)
+
+	// Neither pattern nor time range specified.
+	req = httptest.NewRequest("GET", "http://localhost/sse/logs/search", nil)
+	w = httptest.NewRecorder()
+	rapi.SearchLogs(w, req.WithContext(ctx))
+	require.Equal(t, http.StatusBadRequest, w.Code)
+
+	// Invalid time.
+	req = httptest.NewRequest("GET", "http://localhost/sse/logs/search?since=yesterday", nil)
+	w = httptest.NewRecorder()
+	rapi.SearchLogs(w, req.WithContext(ctx))
+	require.Equal(t, http.StatusBadRequest, w.Code)
+
+	// Invalid daemon ID.
+	req = httptest.NewRequest("GET", "http://localhost/sse/logs/search?pattern=lorem&daemonId=abc", nil)
+	w = httptest.NewRecorder()
+	rapi.SearchLogs(w, req.WithContext(ctx))
+	require.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+// Test that the number of context lines and matches specified in the
+// log search query are limited.
+func TestParseLogSearchCriteriaLimits(t *testing.T) {
+	criteria, err := parseLogSearchCriteria(url.Values{"pattern": {"lorem"}})
+	require.NoError(t, err)
+	require.EqualValues(t, defaultLogSearchMaxMatches, criteria.MaxMatches)
+
+	criteria, err = parseLogSearchCriteria(url.Values{"pattern": {"lorem"}, "maxMatches": {"0"}, "context": {"100"}})
+	require.NoError(t, err)
+	require.EqualValues(t, maxLogSearchMaxMatches, criteria.MaxMatches)
+	require.EqualValues(t, maxLogSearchContextLines, criteria.ContextLines)
+
+	criteria, err = parseLogSearchCriteria(url.Values{"pattern": {"lorem"}, "maxMatches": {"1000000"}})
+	require.NoError(t, err)
+	require.EqualValues(t, maxLogSearchMaxMatches, criteria.MaxMatches)
+
+	criteria, err = parseLogSearchCriteria(url.Values{"pattern": {"lorem"}, "maxMatches": {"5"}})
+	require.NoError(t, err)
+	require.EqualValues(t, 5, criteria.MaxMatches)
+
+	_, err = parseLogSearchCriteria(url.Values{"pattern": {"lorem"}, "maxMatches": {"-1"}})
+	require.Error(t, err)
+}
@@ -0,0 +1,220 @@
+package restservice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/go-openapi/runtime/middleware"
+	"github.com/go-openapi/strfmt"
+	log "github.com/sirupsen/logrus"
+
+	dbmodel "isc.org/stork/server/database/model"
+	"isc.org/stork/server/gen/models"
+	"isc.org/stork/server/gen/restapi/operations/services"
+)
+
+// Convert authorization rule from the database to the REST API format.
+func machineAuthorizationRuleToRestAPI(dbRule *dbmodel.MachineAuthorizationRule) *models.MachineAuthorizationRule {
+	name := dbRule.Name
+	return &models.MachineAuthorizationRule{
+		ID:                dbRule.ID,
+		Name:              &name,
+		Enabled:           dbRule.Enabled,
+		SourceCidr:        dbRule.SourceCIDR,
+		HostnamePattern:   dbRule.HostnamePattern,
+		AgentTokenPattern: dbRule.AgentTokenPattern,
+		CsrFingerprint:    dbRule.CSRFingerprint,
+		CreatedAt:         strfmt.DateTime(dbRule.CreatedAt),
+	}
+}
+
+// Convert authorization rule from the REST API format to the database
+// format and validate it.
+func machineAuthorizationRuleFromRestAPI(rule *models.MachineAuthorizationRule) (*dbmodel.MachineAuthorizationRule, error) {
+	if rule == nil || rule.Name == nil {
+		return nil, errors.New("missing authorization rule name")
+	}
+	dbRule := &dbmodel.MachineAuthorizationRule{
+		Name:              *rule.Name,
+		Enabled:           rule.Enabled,
+		SourceCIDR:        rule.SourceCidr,
+		HostnamePattern:   rule.HostnamePattern,
+		AgentTokenPattern: rule.AgentTokenPattern,
+		CSRFingerprint:    rule.CsrFingerprint,
+	}
+	if err := dbRule.Validate(); err != nil {
+		return nil, err
+	}
+	return dbRule, nil
+}
+
+// Checks if the logged user can manage the authorization rules. Only
+// super-admin can do it because the rules allow for authorizing the
+// machines without the server token.
+func (r *RestAPI) canManageMachineAuthorizationRules(ctx context.Context) bool {
+	_, dbUser := r.SessionManager.Logged(ctx)
+	return dbUser.InGroup(&dbmodel.SystemGroup{ID: dbmodel.SuperAdminGroupID})
+}
+
+// Get the rules authorizing the registering machines.
+func (r *RestAPI) GetMachineAuthorizationRules(ctx context.Context, params services.GetMachineAuthorizationRulesParams) middleware.Responder {
+	if !r.canManageMachineAuthorizationRules(ctx) {
+		msg := "user is forbidden to get machine authorization rules"
+		rsp := services.NewGetMachineAuthorizationRulesDefault(http.StatusForbidden).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+
+	dbRules, err := dbmodel.GetMachineAuthorizationRules(r.DB)
+	if err != nil {
+		log.Error(err)
+		msg := "cannot get machine authorization rules from db"
+		rsp := services.NewGetMachineAuthorizationRulesDefault(http.StatusInternalServerError).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+
+	rules := &models.MachineAuthorizationRules{
+		Total: int64(len(dbRules)),
+	}
+	for i := range dbRules {
+		rules.Items = append(rules.Items, machineAuthorizationRuleToRestAPI(&dbRules[i]))
+	}
+	rsp := services.NewGetMachineAuthorizationRulesOK().WithPayload(rules)
+	return rsp
+}
+
+// Add new rule authorizing the registering machines.
+func (r *RestAPI) CreateMachineAuthorizationRule(ctx context.Context, params services.CreateMachineAuthorizationRuleParams) middleware.Responder {
+	if !r.canManageMachineAuthorizationRules(ctx) {
+		msg := "user is forbidden to add machine authorization rules"
+		rsp := services.NewCreateMachineAuthorizationRuleDefault(http.StatusForbidden).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+
+	dbRule, err := machineAuthorizationRuleFromRestAPI(params.Rule)
+	if err != nil {
+		msg := fmt.Sprintf("invalid machine authorization rule: %s", err)
+		rsp := services.NewCreateMachineAuthorizationRuleDefault(http.StatusBadRequest).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+
+	err = dbmodel.AddMachineAuthorizationRule(r.DB, dbRule)
+	if err != nil {
+		log.Error(err)
+		msg := fmt.Sprintf("cannot store machine authorization rule %s", dbRule.Name)
+		rsp := services.NewCreateMachineAuthorizationRuleDefault(http.StatusInternalServerError).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+
+	_, dbUser := r.SessionManager.Logged(ctx)
+	r.EventCenter.AddInfoEvent(fmt.Sprintf("{user} added machine authorization rule %s", dbRule.Name), dbUser)
+
+	rsp := services.NewCreateMachineAuthorizationRuleOK().WithPayload(machineAuthorizationRuleToRestAPI(dbRule))
+	return rsp
+}
+
+// Update the rule authorizing the registering machines.
+func (r *RestAPI) UpdateMachineAuthorizationRule(ctx context.Context, params services.UpdateMachineAuthorizationRuleParams) middleware.Responder {
+	if !r.canManageMachineAuthorizationRules(ctx) {
+		msg := "user is forbidden to update machine authorization rules"
+		rsp := services.NewUpdateMachineAuthorizationRuleDefault(http.StatusForbidden).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+
+	dbRule, err := machineAuthorizationRuleFromRestAPI(params.Rule)
+	if err != nil {
+		msg := fmt.Sprintf("invalid machine authorization rule: %s", err)
+		rsp := services.NewUpdateMachineAuthorizationRuleDefault(http.StatusBadRequest).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+	dbRule.ID = params.ID
+
+	err = dbmodel.UpdateMachineAuthorizationRule(r.DB, dbRule)
+	if errors.Is(err, dbmodel.ErrNotExists) {
+		msg := fmt.Sprintf("cannot find machine authorization rule with id %d", params.ID)
+		rsp := services.NewUpdateMachineAuthorizationRuleDefault(http.StatusNotFound).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	} else if err != nil {
+		log.Error(err)
+		msg := fmt.Sprintf("cannot update machine authorization rule with id %d", params.ID)
+		rsp := services.NewUpdateMachineAuthorizationRuleDefault(http.StatusInternalServerError).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+
+	// return the rule with the creation time
+	dbRule, err = dbmodel.GetMachineAuthorizationRuleByID(r.DB, params.ID)
+	if err != nil || dbRule == nil {
+		log.Error(err)
+		msg := fmt.Sprintf("cannot get machine authorization rule with id %d from db", params.ID)
+		rsp := services.NewUpdateMachineAuthorizationRuleDefault(http.StatusInternalServerError).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+
+	_, dbUser := r.SessionManager.Logged(ctx)
+	r.EventCenter.AddInfoEvent(fmt.Sprintf("{user} updated machine authorization rule %s", dbRule.Name), dbUser)
+
+	rsp := services.NewUpdateMachineAuthorizationRuleOK().WithPayload(machineAuthorizationRuleToRestAPI(dbRule))
+	return rsp
+}
+
+// Delete the rule authorizing the registering machines.
+func (r *RestAPI) DeleteMachineAuthorizationRule(ctx context.Context, params services.DeleteMachineAuthorizationRuleParams) middleware.Responder {
+	if !r.canManageMachineAuthorizationRules(ctx) {
+		msg := "user is forbidden to delete machine authorization rules"
+		rsp := services.NewDeleteMachineAuthorizationRuleDefault(http.StatusForbidden).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+
+	dbRule, err := dbmodel.GetMachineAuthorizationRuleByID(r.DB, params.ID)
+	if err != nil {
+		log.Error(err)
+		msg := fmt.Sprintf("cannot delete machine authorization rule with id %d", params.ID)
+		rsp := services.NewDeleteMachineAuthorizationRuleDefault(http.StatusInternalServerError).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+	if dbRule == nil {
+		rsp := services.NewDeleteMachineAuthorizationRuleOK()
+		return rsp
+	}
+
+	err = dbmodel.DeleteMachineAuthorizationRule(r.DB, params.ID)
+	if err != nil && !errors.Is(err, dbmodel.ErrNotExists) {
+		log.Error(err)
+		msg := fmt.Sprintf("cannot delete machine authorization rule with id %d", params.ID)
+		rsp := services.NewDeleteMachineAuthorizationRuleDefault(http.StatusInternalServerError).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+
+	_, dbUser := r.SessionManager.Logged(ctx)
+	r.EventCenter.AddInfoEvent(fmt.Sprintf("{user} deleted machine authorization rule %s", dbRule.Name), dbUser)
+
+	rsp := services.NewDeleteMachineAuthorizationRuleOK()
+	return rsp
+}
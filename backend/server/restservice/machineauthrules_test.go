@@ -0,0 +1,258 @@
+package restservice
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"isc.org/stork/pki"
+	agentcommtest "isc.org/stork/server/agentcomm/test"
+	"isc.org/stork/server/certs"
+	dbmodel "isc.org/stork/server/database/model"
+	dbtest "isc.org/stork/server/database/test"
+	"isc.org/stork/server/gen/models"
+	"isc.org/stork/server/gen/restapi/operations/services"
+	storktest "isc.org/stork/server/test"
+	storkutil "isc.org/stork/util"
+)
+
+// Check that the super admin can add, update, get and delete the machine
+// authorization rules.
+func TestMachineAuthorizationRulesSuperAdmin(t *testing.T) {
+	db, dbSettings, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	settings := RestAPISettings{}
+	fa := agentcommtest.NewFakeAgents(nil, nil)
+	fec := &storktest.FakeEventCenter{}
+	fd := &storktest.FakeDispatcher{}
+	rapi, err := NewRestAPI(&settings, dbSettings, db, fa, fec, fd)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	// setup a user session, it is required to check user role
+	user, err := dbmodel.GetUserByID(rapi.DB, 1)
+	require.NoError(t, err)
+	ctx, err = rapi.SessionManager.Load(ctx, "")
+	require.NoError(t, err)
+	err = rapi.SessionManager.LoginHandler(ctx, user)
+	require.NoError(t, err)
+
+	// rule without criteria is rejected
+	name := "lab"
+	createParams := services.CreateMachineAuthorizationRuleParams{
+		Rule: &models.MachineAuthorizationRule{
+			Name:    &name,
+			Enabled: true,
+		},
+	}
+	rsp := rapi.CreateMachineAuthorizationRule(ctx, createParams)
+	require.IsType(t, &services.CreateMachineAuthorizationRuleDefault{}, rsp)
+	defaultRsp := rsp.(*services.CreateMachineAuthorizationRuleDefault)
+	require.Equal(t, http.StatusBadRequest, getStatusCode(*defaultRsp))
+
+	// valid rule
+	createParams.Rule.SourceCidr = "192.0.2.0/24"
+	rsp = rapi.CreateMachineAuthorizationRule(ctx, createParams)
+	require.IsType(t, &services.CreateMachineAuthorizationRuleOK{}, rsp)
+	createRsp := rsp.(*services.CreateMachineAuthorizationRuleOK)
+	require.NotZero(t, createRsp.Payload.ID)
+	ruleID := createRsp.Payload.ID
+
+	rsp = rapi.GetMachineAuthorizationRules(ctx, services.GetMachineAuthorizationRulesParams{})
+	require.IsType(t, &services.GetMachineAuthorizationRulesOK{}, rsp)
+	rules := rsp.(*services.GetMachineAuthorizationRulesOK).Payload
+	require.EqualValues(t, 1, rules.Total)
+	require.Len(t, rules.Items, 1)
+	require.Equal(t, "lab", *rules.Items[0].Name)
+	require.Equal(t, "192.0.2.0/24", rules.Items[0].SourceCidr)
+	require.True(t, rules.Items[0].Enabled)
+
+	// update the rule
+	updateParams := services.UpdateMachineAuthorizationRuleParams{
+		ID: ruleID,
+		Rule: &models.MachineAuthorizationRule{
+			Name:            &name,
+			Enabled:         false,
+			HostnamePattern: "agent-.*",
+		},
+	}
+	// the hostname alone is not sufficient to authorize the machine
+	rsp = rapi.UpdateMachineAuthorizationRule(ctx, updateParams)
+	require.IsType(t, &services.UpdateMachineAuthorizationRuleDefault{}, rsp)
+	defaultUpdateRsp := rsp.(*services.UpdateMachineAuthorizationRuleDefault)
+	require.Equal(t, http.StatusBadRequest, getStatusCode(*defaultUpdateRsp))
+
+	updateParams.Rule.CsrFingerprint = "0102ab"
+	rsp = rapi.UpdateMachineAuthorizationRule(ctx, updateParams)
+	require.IsType(t, &services.UpdateMachineAuthorizationRuleOK{}, rsp)
+	updateRsp := rsp.(*services.UpdateMachineAuthorizationRuleOK)
+	require.False(t, updateRsp.Payload.Enabled)
+	require.Empty(t, updateRsp.Payload.SourceCidr)
+	require.Equal(t, "agent-.*", updateRsp.Payload.HostnamePattern)
+	require.Equal(t, "0102ab", updateRsp.Payload.CsrFingerprint)
+
+	// updating non-existing rule
+	updateParams.ID = ruleID + 1
+	rsp = rapi.UpdateMachineAuthorizationRule(ctx, updateParams)
+	require.IsType(t, &services.UpdateMachineAuthorizationRuleDefault{}, rsp)
+	defaultUpdateRsp = rsp.(*services.UpdateMachineAuthorizationRuleDefault)
+	require.Equal(t, http.StatusNotFound, getStatusCode(*defaultUpdateRsp))
+
+	// delete the rule
+	rsp = rapi.DeleteMachineAuthorizationRule(ctx, services.DeleteMachineAuthorizationRuleParams{ID: ruleID})
+	require.IsType(t, &services.DeleteMachineAuthorizationRuleOK{}, rsp)
+
+	dbRules, err := dbmodel.GetMachineAuthorizationRules(db)
+	require.NoError(t, err)
+	require.Empty(t, dbRules)
+
+	// added, updated and deleted
+	require.Len(t, fec.Events, 3)
+}
+
+// Check that the user who isn't a super admin cannot manage the machine
+// authorization rules.
+func TestMachineAuthorizationRulesForbidden(t *testing.T) {
+	db, dbSettings, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	settings := RestAPISettings{}
+	fa := agentcommtest.NewFakeAgents(nil, nil)
+	fec := &storktest.FakeEventCenter{}
+	fd := &storktest.FakeDispatcher{}
+	rapi, err := NewRestAPI(&settings, dbSettings, db, fa, fec, fd)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	// Create "standard" user (without any special group)
+	user := &dbmodel.SystemUser{
+		Email:    "john@example.org",
+		Lastname: "Smith",
+		Name:     "John",
+		Password: "pass",
+	}
+	conflict, err := dbmodel.CreateUser(rapi.DB, user)
+	require.False(t, conflict)
+	require.NoError(t, err)
+
+	// Log-in the user
+	ctx, err = rapi.SessionManager.Load(ctx, "")
+	require.NoError(t, err)
+	err = rapi.SessionManager.LoginHandler(ctx, user)
+	require.NoError(t, err)
+
+	rsp := rapi.GetMachineAuthorizationRules(ctx, services.GetMachineAuthorizationRulesParams{})
+	require.IsType(t, &services.GetMachineAuthorizationRulesDefault{}, rsp)
+	getRsp := rsp.(*services.GetMachineAuthorizationRulesDefault)
+	require.Equal(t, http.StatusForbidden, getStatusCode(*getRsp))
+
+	name := "lab"
+	rule := &models.MachineAuthorizationRule{
+		Name:       &name,
+		Enabled:    true,
+		SourceCidr: "192.0.2.0/24",
+	}
+	rsp = rapi.CreateMachineAuthorizationRule(ctx, services.CreateMachineAuthorizationRuleParams{Rule: rule})
+	require.IsType(t, &services.CreateMachineAuthorizationRuleDefault{}, rsp)
+	createRsp := rsp.(*services.CreateMachineAuthorizationRuleDefault)
+	require.Equal(t, http.StatusForbidden, getStatusCode(*createRsp))
+
+	rsp = rapi.UpdateMachineAuthorizationRule(ctx, services.UpdateMachineAuthorizationRuleParams{ID: 1, Rule: rule})
+	require.IsType(t, &services.UpdateMachineAuthorizationRuleDefault{}, rsp)
+	updateRsp := rsp.(*services.UpdateMachineAuthorizationRuleDefault)
+	require.Equal(t, http.StatusForbidden, getStatusCode(*updateRsp))
+
+	rsp = rapi.DeleteMachineAuthorizationRule(ctx, services.DeleteMachineAuthorizationRuleParams{ID: 1})
+	require.IsType(t, &services.DeleteMachineAuthorizationRuleDefault{}, rsp)
+	deleteRsp := rsp.(*services.DeleteMachineAuthorizationRuleDefault)
+	require.Equal(t, http.StatusForbidden, getStatusCode(*deleteRsp))
+
+	require.Empty(t, fec.Events)
+}
+
+// Check that the machine registering without the server token is
+// authorized automatically when it matches an authorization rule.
+func TestCreateMachineAuthorizedByRule(t *testing.T) {
+	db, dbSettings, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	settings := RestAPISettings{}
+	fa := agentcommtest.NewFakeAgents(nil, nil)
+	fec := &storktest.FakeEventCenter{}
+	fd := &storktest.FakeDispatcher{}
+	rapi, err := NewRestAPI(&settings, dbSettings, db, fa, fec, fd)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	_, _, _, err = certs.SetupServerCerts(db)
+	require.NoError(t, err)
+
+	_, csrPEM, _, err := pki.GenKeyAndCSR("agent", []string{"name"}, []net.IP{net.ParseIP("192.0.2.1")})
+	require.NoError(t, err)
+	agentCSR := string(csrPEM)
+	fingerprint, err := pki.GetCSRKeyFingerprint(csrPEM)
+	require.NoError(t, err)
+
+	// the rule requires the pre-registered CSR key fingerprint and the
+	// request sent from the lab network
+	err = dbmodel.AddMachineAuthorizationRule(db, &dbmodel.MachineAuthorizationRule{
+		Name:           "lab",
+		Enabled:        true,
+		SourceCIDR:     "192.0.2.0/24",
+		CSRFingerprint: storkutil.BytesToHex(fingerprint[:]),
+	})
+	require.NoError(t, err)
+
+	// the request from other network waits for the authorization
+	addr := "192.0.2.1"
+	agentToken := "agentToken1"
+	params := services.CreateMachineParams{
+		HTTPRequest: &http.Request{RemoteAddr: "198.51.100.1:12345"},
+		Machine: &models.NewMachineReq{
+			Address:    &addr,
+			AgentPort:  8080,
+			AgentCSR:   &agentCSR,
+			AgentToken: &agentToken,
+		},
+	}
+	rsp := rapi.CreateMachine(ctx, params)
+	require.IsType(t, &services.CreateMachineOK{}, rsp)
+	okRsp := rsp.(*services.CreateMachineOK)
+	require.False(t, okRsp.Payload.Authorized)
+
+	machine, err := dbmodel.GetMachineByID(db, okRsp.Payload.ID)
+	require.NoError(t, err)
+	require.False(t, machine.Authorized)
+	require.Len(t, fec.Events, 2)
+	require.Equal(t, dbmodel.EvWarning, fec.Events[1].Level)
+	require.Contains(t, fec.Events[1].Text, "is waiting for authorization")
+
+	// the request from the lab network is authorized
+	agentToken = "agentToken2"
+	params.HTTPRequest = &http.Request{RemoteAddr: "192.0.2.10:12345"}
+	rsp = rapi.CreateMachine(ctx, params)
+	require.IsType(t, &services.CreateMachineOK{}, rsp)
+	okRsp = rsp.(*services.CreateMachineOK)
+	require.True(t, okRsp.Payload.Authorized)
+
+	machine, err = dbmodel.GetMachineByID(db, okRsp.Payload.ID)
+	require.NoError(t, err)
+	require.True(t, machine.Authorized)
+	require.Len(t, fec.Events, 4)
+	require.Equal(t, dbmodel.EvInfo, fec.Events[3].Level)
+	require.Contains(t, fec.Events[3].Text, "authorized automatically by rule lab")
+
+	// the CSR generated using other key doesn't match the rule
+	_, csrPEM, _, err = pki.GenKeyAndCSR("agent", []string{"name"}, []net.IP{net.ParseIP("192.0.2.1")})
+	require.NoError(t, err)
+	agentCSR = string(csrPEM)
+	agentToken = "agentToken3"
+	rsp = rapi.CreateMachine(ctx, params)
+	require.IsType(t, &services.CreateMachineOK{}, rsp)
+	okRsp = rsp.(*services.CreateMachineOK)
+	require.False(t, okRsp.Payload.Authorized)
+}
@@ -1,8 +1,10 @@
 package restservice
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"net"
 	"net/http"
 	"strings"
 	"time"
@@ -74,6 +76,11 @@ func (r *RestAPI) machineToRestAPI(dbMachine dbmodel.Machine) *models.Machine {
 		LastVisitedAt:        strfmt.DateTime(dbMachine.LastVisitedAt),
 		Error:                dbMachine.Error,
 		Apps:                 apps,
+		AgentCertExpiring:    dbMachine.IsAgentCertExpiring(time.Now()),
+		CertRotationStatus:   string(dbMachine.CertRotationStatus),
+	}
+	if !dbMachine.State.AgentCertExpiresAt.IsZero() {
+		m.AgentCertExpiresAt = strfmt.DateTime(dbMachine.State.AgentCertExpiresAt)
 	}
 	return &m
 }
@@ -236,6 +243,25 @@ func (r *RestAPI) checkServerToken(serverToken string, allowEmpty bool) (bool, i
 	return true, 0, ""
 }
 
+// Returns the enabled authorization rule matching the registering machine
+// or nil if there is no such rule.
+func (r *RestAPI) findMachineAuthorizationRule(params services.CreateMachineParams, agentCSR []byte) (*dbmodel.MachineAuthorizationRule, error) {
+	registration := &dbmodel.MachineRegistration{
+		Address:    *params.Machine.Address,
+		AgentToken: *params.Machine.AgentToken,
+	}
+	if params.HTTPRequest != nil {
+		if host, _, err := net.SplitHostPort(params.HTTPRequest.RemoteAddr); err == nil {
+			registration.SourceAddress = host
+		}
+	}
+	// the CSR is verified when it is signed
+	if fingerprint, err := pki.GetCSRKeyFingerprint(agentCSR); err == nil {
+		registration.CSRFingerprint = storkutil.BytesToHex(fingerprint[:])
+	}
+	return dbmodel.FindMatchingMachineAuthorizationRule(r.DB, registration)
+}
+
 // Get one machine by ID where Stork Agent is running.
 func (r *RestAPI) GetMachine(ctx context.Context, params services.GetMachineParams) middleware.Responder {
 	dbMachine, err := dbmodel.GetMachineByID(r.DB, params.ID)
@@ -332,6 +358,22 @@ func (r *RestAPI) CreateMachine(ctx context.Context, params services.CreateMachi
 
 	// sign agent cert
 	agentCSR := []byte(*params.Machine.AgentCSR)
+
+	// the machine registering without the server token may be authorized
+	// automatically by the authorization rules
+	var authorizationRule *dbmodel.MachineAuthorizationRule
+	if !machineAuthorized {
+		authorizationRule, err = r.findMachineAuthorizationRule(params, agentCSR)
+		if err != nil {
+			log.Error(err)
+			msg := "problem with checking machine authorization rules"
+			rsp := services.NewCreateMachineDefault(http.StatusInternalServerError).WithPayload(&models.APIError{
+				Message: &msg,
+			})
+			return rsp
+		}
+		machineAuthorized = authorizationRule != nil
+	}
 	certSerialNumber, err := dbmodel.GetNewCertSerialNumber(r.DB)
 	if err != nil {
 		log.Error(err)
@@ -361,6 +403,21 @@ func (r *RestAPI) CreateMachine(ctx context.Context, params services.CreateMachi
 		})
 		return rsp
 	}
+	// During the CA rotation the agent must trust both the current and the
+	// new CA. Its cert is signed by the current CA and re-issued later.
+	trustedCACertsPEM, err := certs.GetTrustedCACerts(r.DB)
+	if err != nil {
+		log.Error(err)
+		msg := "problem with loading server CA certs"
+		rsp := services.NewCreateMachineDefault(http.StatusInternalServerError).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+	certRotationStatus := dbmodel.CertRotationNone
+	if !bytes.Equal(trustedCACertsPEM, rootCertPEM) {
+		certRotationStatus = dbmodel.CertRotationPending
+	}
 	agentCertPEM, agentCertFingerprint, paramsErr, innerErr := pki.SignCert(agentCSR, certSerialNumber, rootCertPEM, rootKeyPEM)
 	if paramsErr != nil {
 		log.Error(paramsErr)
@@ -389,11 +446,13 @@ func (r *RestAPI) CreateMachine(ctx context.Context, params services.CreateMachi
 
 	if dbMachine == nil {
 		dbMachine = &dbmodel.Machine{
-			Address:         addr,
-			AgentPort:       params.Machine.AgentPort,
-			AgentToken:      *params.Machine.AgentToken,
-			CertFingerprint: agentCertFingerprint,
-			Authorized:      machineAuthorized,
+			Address:            addr,
+			AgentPort:          params.Machine.AgentPort,
+			AgentToken:         *params.Machine.AgentToken,
+			CertFingerprint:    agentCertFingerprint,
+			CertSerialNumber:   certSerialNumber,
+			CertRotationStatus: certRotationStatus,
+			Authorized:         machineAuthorized,
 		}
 		err = dbmodel.AddMachine(r.DB, dbMachine)
 		if err != nil {
@@ -408,6 +467,8 @@ func (r *RestAPI) CreateMachine(ctx context.Context, params services.CreateMachi
 	} else {
 		dbMachine.AgentToken = *params.Machine.AgentToken
 		dbMachine.CertFingerprint = agentCertFingerprint
+		dbMachine.CertSerialNumber = certSerialNumber
+		dbMachine.CertRotationStatus = certRotationStatus
 		dbMachine.Authorized = machineAuthorized
 		err = dbmodel.UpdateMachine(r.DB, dbMachine)
 		if err != nil {
@@ -421,10 +482,18 @@ func (r *RestAPI) CreateMachine(ctx context.Context, params services.CreateMachi
 		r.EventCenter.AddInfoEvent("re-registered {machine}", dbMachine)
 	}
 
+	switch {
+	case authorizationRule != nil:
+		r.EventCenter.AddInfoEvent(fmt.Sprintf("{machine} authorized automatically by rule %s", authorizationRule.Name), dbMachine)
+	case !machineAuthorized:
+		r.EventCenter.AddWarningEvent("{machine} is waiting for authorization", dbMachine)
+	}
+
 	m := &models.NewMachineResp{
 		ID:           dbMachine.ID,
-		ServerCACert: string(rootCertPEM),
+		ServerCACert: string(trustedCACertsPEM),
 		AgentCert:    string(agentCertPEM),
+		Authorized:   machineAuthorized,
 	}
 	rsp := services.NewCreateMachineOK().WithPayload(m)
 
@@ -691,6 +760,12 @@ func (r *RestAPI) DeleteMachine(ctx context.Context, params services.DeleteMachi
 		return rsp
 	}
 
+	// The agent cert has been revoked along with the machine. Refuse
+	// the connections from the agent presenting this cert.
+	if revokedCert := dbmodel.NewRevokedCert(dbMachine); revokedCert != nil && r.Agents != nil {
+		r.Agents.RevokeCerts(*revokedCert)
+	}
+
 	r.EventCenter.AddInfoEvent("removed {machine}", dbMachine)
 
 	rsp := services.NewDeleteMachineOK()
@@ -837,13 +912,15 @@ func (r *RestAPI) appToRestAPI(dbApp *dbmodel.App) *models.App {
 	isBind9App := dbApp.Type == dbmodel.AppTypeBind9
 
 	agentErrors := int64(0)
+	agentCommState := string(agentcomm.CircuitClosed)
 	var agentStats *agentcomm.AgentStats
 	var accessPoint *dbmodel.AccessPoint
 	if dbApp.Machine != nil {
 		agentStats = r.Agents.GetConnectedAgentStats(dbApp.Machine.Address, dbApp.Machine.AgentPort)
 		if agentStats != nil {
 			agentErrors = agentStats.CurrentErrors
-			accessPoint, _ = dbApp.GetAccessPoint(dbmodel.AccessPointControl)
+			agentCommState = string(agentStats.CircuitBreaker.GetState())
+			accessPoint, _ = dbApp.GetControlAccessPoint()
 		}
 	}
 
@@ -869,10 +946,15 @@ func (r *RestAPI) appToRestAPI(dbApp *dbmodel.App) *models.App {
 				ReloadedAt:      strfmt.DateTime(d.ReloadedAt),
 				Hooks:           []string{},
 				AgentCommErrors: agentErrors,
+				AgentCommState:  agentCommState,
+				CaCommState:     string(agentcomm.CircuitClosed),
+				DaemonCommState: string(agentcomm.CircuitClosed),
 			}
 			if keaStats != nil {
 				dmn.CaCommErrors = keaStats.CurrentErrorsCA
 				dmn.DaemonCommErrors = keaStats.CurrentErrorsDaemons[d.Name]
+				dmn.CaCommState = string(keaStats.CircuitBreakerCA.GetState())
+				dmn.DaemonCommState = string(keaStats.CircuitBreakersDaemons[d.Name].GetState())
 			}
 
 			hooksByDaemon := kea.GetDaemonHooks(dbApp)
@@ -942,6 +1024,9 @@ func (r *RestAPI) appToRestAPI(dbApp *dbmodel.App) *models.App {
 			QueryMisses:     queryMisses,
 			QueryHitRatio:   queryHitRatio,
 			AgentCommErrors: agentErrors,
+			AgentCommState:  agentCommState,
+			RndcCommState:   string(agentcomm.CircuitClosed),
+			StatsCommState:  string(agentcomm.CircuitClosed),
 		}
 		var bind9Stats *agentcomm.AgentBind9CommStats
 		if agentStats != nil && accessPoint != nil {
@@ -951,6 +1036,8 @@ func (r *RestAPI) appToRestAPI(dbApp *dbmodel.App) *models.App {
 			}].(*agentcomm.AgentBind9CommStats); bind9Stats != nil {
 				bind9Daemon.RndcCommErrors = bind9Stats.CurrentErrorsRNDC
 				bind9Daemon.StatsCommErrors = bind9Stats.CurrentErrorsStats
+				bind9Daemon.RndcCommState = string(bind9Stats.CircuitBreakerRNDC.GetState())
+				bind9Daemon.StatsCommState = string(bind9Stats.CircuitBreakerStats.GetState())
 			}
 		}
 		app.Details = struct {
@@ -1396,10 +1483,14 @@ func (r *RestAPI) GetDhcpOverview(ctx context.Context, params dhcp.GetDhcpOvervi
 			agentErrors := int64(0)
 			caErrors := int64(0)
 			daemonErrors := int64(0)
+			agentCommState := agentcomm.CircuitClosed
+			caCommState := agentcomm.CircuitClosed
+			daemonCommState := agentcomm.CircuitClosed
 			agentStats := r.Agents.GetConnectedAgentStats(dbApp.Machine.Address, dbApp.Machine.AgentPort)
 			if agentStats != nil {
 				agentErrors = agentStats.CurrentErrors
-				accessPoint, _ := dbApp.GetAccessPoint(dbmodel.AccessPointControl)
+				agentCommState = agentStats.CircuitBreaker.GetState()
+				accessPoint, _ := dbApp.GetControlAccessPoint()
 				if accessPoint != nil {
 					if keaStats, ok := agentStats.AppCommStats[agentcomm.AppCommStatsKey{
 						Address: accessPoint.Address,
@@ -1407,6 +1498,8 @@ func (r *RestAPI) GetDhcpOverview(ctx context.Context, params dhcp.GetDhcpOvervi
 					}].(*agentcomm.AgentKeaCommStats); ok {
 						caErrors = keaStats.CurrentErrorsCA
 						daemonErrors = keaStats.CurrentErrorsDaemons[dbDaemon.Name]
+						caCommState = keaStats.CircuitBreakerCA.GetState()
+						daemonCommState = keaStats.CircuitBreakersDaemons[dbDaemon.Name].GetState()
 					}
 				}
 			}
@@ -1429,6 +1522,9 @@ func (r *RestAPI) GetDhcpOverview(ctx context.Context, params dhcp.GetDhcpOvervi
 				AgentCommErrors:  agentErrors,
 				CaCommErrors:     caErrors,
 				DaemonCommErrors: daemonErrors,
+				AgentCommState:   string(agentCommState),
+				CaCommState:      string(caCommState),
+				DaemonCommState:  string(daemonCommState),
 			}
 			dhcpDaemons = append(dhcpDaemons, daemon)
 		}
@@ -624,6 +624,18 @@ func TestGetMachine(t *testing.T) {
 	require.IsType(t, &services.GetMachineOK{}, rsp)
 	okRsp := rsp.(*services.GetMachineOK)
 	require.Equal(t, m.ID, okRsp.Payload.ID)
+	require.Zero(t, okRsp.Payload.AgentCertExpiresAt)
+	require.False(t, okRsp.Payload.AgentCertExpiring)
+
+	// the agent certificate expires soon
+	m.State.AgentCertExpiresAt = time.Now().Add(24 * time.Hour).UTC()
+	err = dbmodel.UpdateMachine(db, m)
+	require.NoError(t, err)
+	rsp = rapi.GetMachine(ctx, params)
+	require.IsType(t, &services.GetMachineOK{}, rsp)
+	okRsp = rsp.(*services.GetMachineOK)
+	require.WithinDuration(t, m.State.AgentCertExpiresAt, time.Time(okRsp.Payload.AgentCertExpiresAt), time.Second)
+	require.True(t, okRsp.Payload.AgentCertExpiring)
 
 	// add machine 2
 	m2 := &dbmodel.Machine{
@@ -843,6 +855,50 @@ func TestDeleteMachine(t *testing.T) {
 	require.IsType(t, &services.GetMachineDefault{}, rsp)
 	defaultRsp := rsp.(*services.GetMachineDefault)
 	require.Equal(t, http.StatusNotFound, getStatusCode(*defaultRsp))
+
+	// the machine had no cert, so there is nothing to revoke
+	require.Empty(t, fa.RecordedRevokedCerts)
+}
+
+// Check that the agent cert is revoked when the machine is deleted.
+func TestDeleteMachineRevokeCert(t *testing.T) {
+	db, dbSettings, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	settings := RestAPISettings{}
+	fa := agentcommtest.NewFakeAgents(nil, nil)
+	fec := &storktest.FakeEventCenter{}
+	fd := &storktest.FakeDispatcher{}
+	rapi, err := NewRestAPI(&settings, dbSettings, db, fa, fec, fd)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	m := &dbmodel.Machine{
+		Address:          "192.0.2.1",
+		AgentPort:        8080,
+		CertSerialNumber: 42,
+		CertFingerprint:  [32]byte{1},
+	}
+	err = dbmodel.AddMachine(db, m)
+	require.NoError(t, err)
+
+	params := services.DeleteMachineParams{
+		ID: m.ID,
+	}
+	rsp := rapi.DeleteMachine(ctx, params)
+	require.IsType(t, &services.DeleteMachineOK{}, rsp)
+
+	// the connections from the agent are refused
+	require.Len(t, fa.RecordedRevokedCerts, 1)
+	require.EqualValues(t, 42, fa.RecordedRevokedCerts[0].SerialNumber)
+	require.Equal(t, "192.0.2.1", fa.RecordedRevokedCerts[0].Address)
+	require.EqualValues(t, 8080, fa.RecordedRevokedCerts[0].AgentPort)
+
+	// the revoked cert is stored in the database
+	revokedCerts, err := dbmodel.GetRevokedCerts(db)
+	require.NoError(t, err)
+	require.Len(t, revokedCerts, 1)
+	require.EqualValues(t, 42, revokedCerts[0].SerialNumber)
 }
 
 func TestGetApp(t *testing.T) {
@@ -1076,6 +1132,9 @@ func TestRestGetApps(t *testing.T) {
 			require.EqualValues(t, 1, daemon.AgentCommErrors)
 			require.EqualValues(t, 2, daemon.CaCommErrors)
 			require.EqualValues(t, 5, daemon.DaemonCommErrors)
+			require.Equal(t, "closed", daemon.AgentCommState)
+			require.Equal(t, "closed", daemon.CaCommState)
+			require.Equal(t, "open", daemon.DaemonCommState)
 			require.Len(t, daemon.LogTargets, 1)
 			require.Equal(t, "kea-dhcp4", daemon.LogTargets[0].Name)
 			require.Equal(t, "debug", daemon.LogTargets[0].Severity)
@@ -1087,6 +1146,8 @@ func TestRestGetApps(t *testing.T) {
 			require.EqualValues(t, 1, daemon.AgentCommErrors)
 			require.EqualValues(t, 2, daemon.RndcCommErrors)
 			require.EqualValues(t, 3, daemon.StatsCommErrors)
+			require.Equal(t, "closed", daemon.RndcCommState)
+			require.Equal(t, "half-open", daemon.StatsCommState)
 		}
 	}
 }
@@ -1600,6 +1661,7 @@ func TestGetDhcpOverview(t *testing.T) {
 	require.EqualValues(t, 1, okRsp.Payload.DhcpDaemons[0].AgentCommErrors)
 	require.EqualValues(t, 2, okRsp.Payload.DhcpDaemons[0].CaCommErrors)
 	require.EqualValues(t, 5, okRsp.Payload.DhcpDaemons[0].DaemonCommErrors)
+	require.Equal(t, "open", okRsp.Payload.DhcpDaemons[0].DaemonCommState)
 
 	// HA is not enabled.
 	require.False(t, okRsp.Payload.DhcpDaemons[0].HaEnabled)
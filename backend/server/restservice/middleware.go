@@ -11,6 +11,7 @@ import (
 
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 
 	"isc.org/stork/server/auth"
 	"isc.org/stork/server/eventcenter"
@@ -54,6 +55,14 @@ func (r *loggingResponseWriter) Header() http.Header {
 	return r.rw.Header()
 }
 
+// http.Flusher implementation wrapper that sends the buffered data
+// to the client. It is required by the server-sent events.
+func (r *loggingResponseWriter) Flush() {
+	if flusher, ok := r.rw.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
 // Install a middleware that traces ReST calls using logrus.
 func loggingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -126,6 +135,33 @@ func sseMiddleware(next http.Handler, eventCenter eventcenter.EventCenter) http.
 	})
 }
 
+// Install a middleware that is relaying the followed log files and the
+// log search results to the browser as `server-sent events` (SSE). The
+// session is loaded before the request is handled, so only logged users
+// can view the logs. The session middleware buffers the whole response,
+// so it can't be used for streaming and the session is loaded directly
+// instead.
+func logFollowMiddleware(next http.Handler, r *RestAPI) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if strings.HasPrefix(req.URL.Path, "/sse/logs/") {
+			sessionReq, err := r.SessionManager.LoadFromRequest(req)
+			if err != nil {
+				log.Errorf("failed to load the session for the log viewer: %+v", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			if req.URL.Path == "/sse/logs/search" {
+				r.SearchLogs(w, sessionReq)
+			} else {
+				r.FollowLog(w, sessionReq)
+			}
+		} else {
+			// pass request to another handler
+			next.ServeHTTP(w, req)
+		}
+	})
+}
+
 // Install a middleware that is serving Agent installer.
 func agentInstallerMiddleware(next http.Handler, staticFilesDir string) http.Handler {
 	// Agent installer as Bash script.
@@ -234,6 +270,20 @@ func metricsMiddleware(next http.Handler, collector metrics.Collector) http.Hand
 	})
 }
 
+// Tracing middleware that starts the span for each REST API request. It
+// continues the trace if the request carries the trace context. The other
+// requests, e.g. for the static files, are not traced.
+func tracingMiddleware(next http.Handler) http.Handler {
+	return otelhttp.NewHandler(next, "REST API",
+		otelhttp.WithFilter(func(r *http.Request) bool {
+			return strings.HasPrefix(r.URL.Path, "/api/")
+		}),
+		otelhttp.WithSpanNameFormatter(func(operation string, r *http.Request) string {
+			return fmt.Sprintf("%s %s", r.Method, r.URL.Path)
+		}),
+	)
+}
+
 // Global middleware function provides a common place to setup middlewares for
 // the server. It is invoked before everything.
 func (r *RestAPI) GlobalMiddleware(handler http.Handler, staticFilesDir string, eventCenter eventcenter.EventCenter) http.Handler {
@@ -241,8 +291,10 @@ func (r *RestAPI) GlobalMiddleware(handler http.Handler, staticFilesDir string,
 	handler = fileServerMiddleware(handler, staticFilesDir)
 	handler = agentInstallerMiddleware(handler, staticFilesDir)
 	handler = sseMiddleware(handler, eventCenter)
+	handler = logFollowMiddleware(handler, r)
 	handler = metricsMiddleware(handler, r.MetricsCollector)
 	handler = loggingMiddleware(handler)
+	handler = tracingMiddleware(handler)
 	return handler
 }
 
@@ -9,9 +9,13 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 	dbsession "isc.org/stork/server/database/session"
 	dbtest "isc.org/stork/server/database/test"
 	storktest "isc.org/stork/server/test"
+	"isc.org/stork/tracing"
 )
 
 // Check if fileServerMiddleware works and handles requests correctly.
@@ -144,6 +148,35 @@ func TestMetricsMiddleware(t *testing.T) {
 	require.EqualValues(t, 1, metrics.RequestCount)
 }
 
+// Check if tracingMiddleware traces the REST API requests and continues
+// the trace started by the client.
+func TestTracingMiddleware(t *testing.T) {
+	// Arrange
+	_, err := tracing.Setup("stork-test", tracing.Settings{})
+	require.NoError(t, err)
+	exporter := tracetest.NewInMemoryExporter()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	defer otel.SetTracerProvider(sdktrace.NewTracerProvider())
+
+	nextHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	handler := tracingMiddleware(nextHandler)
+
+	// Act
+	req := httptest.NewRequest("GET", "http://localhost/api/leases", nil)
+	req.Header.Set("traceparent", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	req = httptest.NewRequest("GET", "http://localhost/index.html", nil)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	// Assert
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 1)
+	require.Equal(t, "GET /api/leases", spans[0].Name)
+	require.Equal(t, "0af7651916cd43dd8448eb211c80319c", spans[0].SpanContext.TraceID().String())
+	require.Equal(t, "b7ad6b7169203331", spans[0].Parent.SpanID().String())
+}
+
 // Check if metricsMiddelware returns placeholder when the endpoint is disabled.
 func TestMetricsMiddlewarePlaceholder(t *testing.T) {
 	// Arrange
@@ -198,3 +231,22 @@ func TestLoggingMiddlewareHelpers(t *testing.T) {
 	hdr := lrw.Header()
 	require.Empty(t, hdr)
 }
+
+// Check that the logging response writer passes the flush to the
+// original writer, so the server-sent events are not buffered.
+func TestLoggingMiddlewareFlush(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	lrw := &loggingResponseWriter{
+		rw:           recorder,
+		responseData: &responseData{},
+	}
+	lrw.Flush()
+	require.True(t, recorder.Flushed)
+
+	// The writer not implementing the flusher should be ignored.
+	lrw = &loggingResponseWriter{
+		rw:           &dumbRespWritter{},
+		responseData: &responseData{},
+	}
+	require.NotPanics(t, lrw.Flush)
+}
@@ -0,0 +1,227 @@
+package restservice
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/go-openapi/runtime/middleware"
+	log "github.com/sirupsen/logrus"
+	keaconfig "isc.org/stork/appcfg/kea"
+	dbmodel "isc.org/stork/server/database/model"
+
+	"isc.org/stork/server/gen/models"
+	dhcp "isc.org/stork/server/gen/restapi/operations/d_h_c_p"
+)
+
+// Converts the option fetched from the database to the format used
+// in the REST API.
+func optionToRestAPI(dbOption *dbmodel.KeaOptionData) *models.DhcpOption {
+	option := &models.DhcpOption{
+		ID:             dbOption.ID,
+		DaemonID:       dbOption.DaemonID,
+		Scope:          dbOption.Scope,
+		ClientClass:    dbOption.ClientClass,
+		SharedNetwork:  dbOption.SharedNetwork,
+		Subnet:         dbOption.SubnetPrefix,
+		LocalSubnetID:  dbOption.LocalSubnetID,
+		Pool:           dbOption.Pool,
+		HostIdentifier: dbOption.HostIdentifier,
+		Space:          dbOption.Space,
+		Code:           dbOption.Code,
+		Name:           dbOption.Name,
+		Data:           dbOption.Data,
+		CsvFormat:      dbOption.CSVFormat,
+		AlwaysSend:     dbOption.AlwaysSend,
+	}
+	if dbOption.Daemon != nil && dbOption.Daemon.App != nil {
+		option.AppID = dbOption.Daemon.App.ID
+		option.AppName = dbOption.Daemon.App.Name
+		if dbOption.Daemon.App.Machine != nil {
+			option.MachineAddress = dbOption.Daemon.App.Machine.Address
+		}
+	}
+	return option
+}
+
+// Converts the option resolved from the daemon configuration to the
+// format used in the REST API.
+func effectiveOptionToRestAPI(daemonID int64, rootName string, config *keaconfig.DHCPConfig, scoped keaconfig.ScopedOptionData) *models.DhcpOption {
+	space, code := config.GetOptionSpaceAndCode(rootName, scoped.Option)
+	option := &models.DhcpOption{
+		DaemonID:       daemonID,
+		Scope:          string(scoped.Scope),
+		ClientClass:    scoped.ClientClass,
+		SharedNetwork:  scoped.SharedNetwork,
+		Subnet:         scoped.Subnet,
+		LocalSubnetID:  scoped.SubnetID,
+		Pool:           scoped.Pool,
+		HostIdentifier: scoped.HostIdentifier,
+		Space:          space,
+		Code:           code,
+		CsvFormat:      scoped.Option.CSVFormat,
+		AlwaysSend:     scoped.Option.AlwaysSend != nil && *scoped.Option.AlwaysSend,
+	}
+	if scoped.Option.Name != nil {
+		option.Name = *scoped.Option.Name
+	}
+	if scoped.Option.Data != nil {
+		option.Data = *scoped.Option.Data
+	}
+	return option
+}
+
+// Get the list of DHCP options specified in the Kea daemons' configurations.
+func (r *RestAPI) GetDhcpOptions(ctx context.Context, params dhcp.GetDhcpOptionsParams) middleware.Responder {
+	var start int64 = 0
+	if params.Start != nil {
+		start = *params.Start
+	}
+
+	var limit int64 = 10
+	if params.Limit != nil {
+		limit = *params.Limit
+	}
+
+	var daemonID int64 = 0
+	if params.DaemonID != nil {
+		daemonID = *params.DaemonID
+	}
+
+	dbOptions, total, err := dbmodel.GetKeaOptionDataByPage(r.DB, start, limit, daemonID, params.Code, params.Space, params.Scope, params.Text, "", dbmodel.SortDirAny)
+	if err != nil {
+		msg := "cannot get DHCP options from db"
+		log.Error(err)
+		rsp := dhcp.NewGetDhcpOptionsDefault(http.StatusInternalServerError).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+
+	options := &models.DhcpOptions{
+		Total: total,
+	}
+	for i := range dbOptions {
+		options.Items = append(options.Items, optionToRestAPI(&dbOptions[i]))
+	}
+
+	rsp := dhcp.NewGetDhcpOptionsOK().WithPayload(options)
+	return rsp
+}
+
+// Get the option definitions specified in the Kea daemon's configuration.
+func (r *RestAPI) GetDaemonOptionDefs(ctx context.Context, params dhcp.GetDaemonOptionDefsParams) middleware.Responder {
+	dbDaemon, err := dbmodel.GetDaemonByID(r.DB, params.ID)
+	if err != nil {
+		msg := fmt.Sprintf("cannot get daemon with id %d from db", params.ID)
+		log.Error(err)
+		rsp := dhcp.NewGetDaemonOptionDefsDefault(http.StatusInternalServerError).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+	if dbDaemon == nil || dbDaemon.KeaDaemon == nil {
+		msg := fmt.Sprintf("cannot find Kea daemon with id %d", params.ID)
+		rsp := dhcp.NewGetDaemonOptionDefsDefault(http.StatusBadRequest).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+
+	dbDefs, err := dbmodel.GetKeaOptionDefsByDaemonID(r.DB, params.ID)
+	if err != nil {
+		msg := fmt.Sprintf("cannot get option definitions for daemon with id %d from db", params.ID)
+		log.Error(err)
+		rsp := dhcp.NewGetDaemonOptionDefsDefault(http.StatusInternalServerError).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+
+	defs := &models.DhcpOptionDefs{
+		Total: int64(len(dbDefs)),
+	}
+	for _, dbDef := range dbDefs {
+		defs.Items = append(defs.Items, &models.DhcpOptionDef{
+			ID:          dbDef.ID,
+			Scope:       dbDef.Scope,
+			ClientClass: dbDef.ClientClass,
+			Space:       dbDef.Space,
+			Code:        dbDef.Code,
+			Name:        dbDef.Name,
+			Type:        dbDef.Type,
+			Array:       dbDef.IsArray,
+			RecordTypes: dbDef.RecordTypes,
+			Encapsulate: dbDef.Encapsulate,
+		})
+	}
+
+	rsp := dhcp.NewGetDaemonOptionDefsOK().WithPayload(defs)
+	return rsp
+}
+
+// Get the options the Kea daemon would send to a client in the specified
+// subnet, pool, client classes and having the specified host reservation.
+func (r *RestAPI) GetDaemonEffectiveOptions(ctx context.Context, params dhcp.GetDaemonEffectiveOptionsParams) middleware.Responder {
+	if (params.Subnet == nil || *params.Subnet == "") && params.SubnetID == nil {
+		msg := "subnet or subnet id must be specified"
+		rsp := dhcp.NewGetDaemonEffectiveOptionsDefault(http.StatusBadRequest).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+
+	dbDaemon, status, msg := r.getKeaDaemonWithConfig(ctx, params.ID)
+	if dbDaemon == nil {
+		rsp := dhcp.NewGetDaemonEffectiveOptionsDefault(status).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+
+	rootName, _ := dbDaemon.KeaDaemon.Config.GetRootName()
+	config, err := dbDaemon.KeaDaemon.Config.DecodeDHCPConfig()
+	if err != nil {
+		msg := fmt.Sprintf("cannot parse the DHCP configuration of daemon with id %d", params.ID)
+		log.Error(err)
+		rsp := dhcp.NewGetDaemonEffectiveOptionsDefault(http.StatusBadRequest).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+
+	query := keaconfig.EffectiveOptionsQuery{
+		ClientClasses: params.Classes,
+	}
+	if params.Subnet != nil {
+		query.Subnet = *params.Subnet
+	}
+	if params.SubnetID != nil {
+		query.SubnetID = *params.SubnetID
+	}
+	if params.Address != nil {
+		query.Address = *params.Address
+	}
+	if params.HostIdentifier != nil {
+		query.HostIdentifier = *params.HostIdentifier
+	}
+
+	effective, err := config.ResolveEffectiveOptions(query)
+	if err != nil {
+		msg := fmt.Sprintf("cannot resolve the options for daemon with id %d: %s", params.ID, err)
+		rsp := dhcp.NewGetDaemonEffectiveOptionsDefault(http.StatusBadRequest).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+
+	options := &models.DhcpOptions{
+		Total: int64(len(effective)),
+	}
+	for _, scoped := range effective {
+		options.Items = append(options.Items, effectiveOptionToRestAPI(params.ID, rootName, config, scoped))
+	}
+
+	rsp := dhcp.NewGetDaemonEffectiveOptionsOK().WithPayload(options)
+	return rsp
+}
@@ -0,0 +1,243 @@
+package restservice
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	agentcommtest "isc.org/stork/server/agentcomm/test"
+	dbops "isc.org/stork/server/database"
+	dbmodel "isc.org/stork/server/database/model"
+	dbtest "isc.org/stork/server/database/test"
+	dhcp "isc.org/stork/server/gen/restapi/operations/d_h_c_p"
+	storktest "isc.org/stork/server/test"
+)
+
+// Adds a Kea app with a DHCPv4 daemon having options specified at various
+// configuration levels. The options are extracted into the database.
+func addTestDaemonWithOptions(t *testing.T, db *dbops.PgDB) *dbmodel.Daemon {
+	m := &dbmodel.Machine{
+		Address:   "localhost",
+		AgentPort: 8080,
+	}
+	err := dbmodel.AddMachine(db, m)
+	require.NoError(t, err)
+
+	daemon := dbmodel.NewKeaDaemon("dhcp4", true)
+	err = daemon.SetConfigFromJSON(`{
+        "Dhcp4": {
+            "option-def": [
+                {
+                    "name": "foo-option",
+                    "code": 222,
+                    "type": "string"
+                }
+            ],
+            "option-data": [
+                {
+                    "name": "domain-name-servers",
+                    "data": "192.0.2.1"
+                },
+                {
+                    "code": 3,
+                    "data": "192.0.2.254"
+                }
+            ],
+            "client-classes": [
+                {
+                    "name": "foo",
+                    "option-data": [
+                        {
+                            "name": "foo-option",
+                            "data": "foo"
+                        }
+                    ]
+                }
+            ],
+            "subnet4": [
+                {
+                    "id": 1,
+                    "subnet": "192.0.2.0/24",
+                    "option-data": [
+                        {
+                            "code": 6,
+                            "data": "192.0.2.2"
+                        }
+                    ],
+                    "pools": [
+                        {
+                            "pool": "192.0.2.10-192.0.2.20",
+                            "option-data": [
+                                {
+                                    "code": 3,
+                                    "data": "192.0.2.1"
+                                }
+                            ]
+                        }
+                    ]
+                }
+            ]
+        }
+    }`)
+	require.NoError(t, err)
+	app := &dbmodel.App{
+		MachineID: m.ID,
+		Type:      dbmodel.AppTypeKea,
+		Name:      "kea",
+		AccessPoints: dbmodel.AppendAccessPoint(nil, dbmodel.AccessPointControl,
+			"localhost", "", 8000, true),
+		Daemons: []*dbmodel.Daemon{daemon},
+	}
+	_, err = dbmodel.AddApp(db, app)
+	require.NoError(t, err)
+
+	err = dbmodel.CommitKeaOptionsIntoDB(db, daemon)
+	require.NoError(t, err)
+	return daemon
+}
+
+// Test getting the list of DHCP options via the REST API.
+func TestGetDhcpOptions(t *testing.T) {
+	db, dbSettings, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	fa := agentcommtest.NewFakeAgents(nil, nil)
+	fd := &storktest.FakeDispatcher{}
+	rapi, err := NewRestAPI(dbSettings, db, fa, fd)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	daemon := addTestDaemonWithOptions(t, db)
+
+	// All options.
+	params := dhcp.GetDhcpOptionsParams{}
+	rsp := rapi.GetDhcpOptions(ctx, params)
+	require.IsType(t, &dhcp.GetDhcpOptionsOK{}, rsp)
+	okRsp := rsp.(*dhcp.GetDhcpOptionsOK)
+	require.EqualValues(t, 5, okRsp.Payload.Total)
+	require.Len(t, okRsp.Payload.Items, 5)
+	option := okRsp.Payload.Items[0]
+	require.Equal(t, daemon.ID, option.DaemonID)
+	require.Equal(t, "kea", option.AppName)
+	require.Equal(t, "localhost", option.MachineAddress)
+	require.Equal(t, "global", option.Scope)
+	require.Equal(t, "dhcp4", option.Space)
+	require.NotNil(t, option.Code)
+	require.EqualValues(t, 6, *option.Code)
+
+	// Filter by code and scope.
+	code := int64(3)
+	scope := "pool"
+	params = dhcp.GetDhcpOptionsParams{
+		DaemonID: &daemon.ID,
+		Code:     &code,
+		Scope:    &scope,
+	}
+	rsp = rapi.GetDhcpOptions(ctx, params)
+	require.IsType(t, &dhcp.GetDhcpOptionsOK{}, rsp)
+	okRsp = rsp.(*dhcp.GetDhcpOptionsOK)
+	require.EqualValues(t, 1, okRsp.Payload.Total)
+	require.Equal(t, "192.0.2.10-192.0.2.20", okRsp.Payload.Items[0].Pool)
+	require.Equal(t, "192.0.2.0/24", okRsp.Payload.Items[0].Subnet)
+	require.EqualValues(t, 1, okRsp.Payload.Items[0].LocalSubnetID)
+}
+
+// Test getting the option definitions via the REST API.
+func TestGetDaemonOptionDefs(t *testing.T) {
+	db, dbSettings, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	fa := agentcommtest.NewFakeAgents(nil, nil)
+	fd := &storktest.FakeDispatcher{}
+	rapi, err := NewRestAPI(dbSettings, db, fa, fd)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	daemon := addTestDaemonWithOptions(t, db)
+
+	params := dhcp.GetDaemonOptionDefsParams{
+		ID: daemon.ID,
+	}
+	rsp := rapi.GetDaemonOptionDefs(ctx, params)
+	require.IsType(t, &dhcp.GetDaemonOptionDefsOK{}, rsp)
+	okRsp := rsp.(*dhcp.GetDaemonOptionDefsOK)
+	require.EqualValues(t, 1, okRsp.Payload.Total)
+	require.Equal(t, "foo-option", okRsp.Payload.Items[0].Name)
+	require.EqualValues(t, 222, okRsp.Payload.Items[0].Code)
+	require.Equal(t, "string", okRsp.Payload.Items[0].Type)
+
+	// Non-existing daemon.
+	params = dhcp.GetDaemonOptionDefsParams{
+		ID: daemon.ID + 100,
+	}
+	rsp = rapi.GetDaemonOptionDefs(ctx, params)
+	require.IsType(t, &dhcp.GetDaemonOptionDefsDefault{}, rsp)
+	defaultRsp := rsp.(*dhcp.GetDaemonOptionDefsDefault)
+	require.Equal(t, http.StatusBadRequest, getStatusCode(*defaultRsp))
+}
+
+// Test resolving the options a client would receive via the REST API.
+func TestGetDaemonEffectiveOptions(t *testing.T) {
+	db, dbSettings, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	fa := agentcommtest.NewFakeAgents(nil, nil)
+	fd := &storktest.FakeDispatcher{}
+	rapi, err := NewRestAPI(dbSettings, db, fa, fd)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	// setup a user session, it is required to check user role
+	user, err := dbmodel.GetUserByID(rapi.DB, 1)
+	require.NoError(t, err)
+	ctx, err = rapi.SessionManager.Load(ctx, "")
+	require.NoError(t, err)
+	err = rapi.SessionManager.LoginHandler(ctx, user)
+	require.NoError(t, err)
+
+	daemon := addTestDaemonWithOptions(t, db)
+
+	// Client in the pool and the client class.
+	subnet := "192.0.2.0/24"
+	address := "192.0.2.15"
+	params := dhcp.GetDaemonEffectiveOptionsParams{
+		ID:      daemon.ID,
+		Subnet:  &subnet,
+		Address: &address,
+		Classes: []string{"foo"},
+	}
+	rsp := rapi.GetDaemonEffectiveOptions(ctx, params)
+	require.IsType(t, &dhcp.GetDaemonEffectiveOptionsOK{}, rsp)
+	okRsp := rsp.(*dhcp.GetDaemonEffectiveOptionsOK)
+	require.EqualValues(t, 3, okRsp.Payload.Total)
+	items := okRsp.Payload.Items
+	require.Equal(t, "pool", items[0].Scope)
+	require.EqualValues(t, 3, *items[0].Code)
+	require.Equal(t, "192.0.2.1", items[0].Data)
+	require.Equal(t, "subnet", items[1].Scope)
+	require.EqualValues(t, 6, *items[1].Code)
+	require.Equal(t, "client-class", items[2].Scope)
+	require.Equal(t, "foo", items[2].ClientClass)
+	require.EqualValues(t, 222, *items[2].Code)
+
+	// Subnet not specified.
+	params = dhcp.GetDaemonEffectiveOptionsParams{
+		ID: daemon.ID,
+	}
+	rsp = rapi.GetDaemonEffectiveOptions(ctx, params)
+	require.IsType(t, &dhcp.GetDaemonEffectiveOptionsDefault{}, rsp)
+	defaultRsp := rsp.(*dhcp.GetDaemonEffectiveOptionsDefault)
+	require.Equal(t, http.StatusBadRequest, getStatusCode(*defaultRsp))
+
+	// Subnet not found.
+	subnetID := int64(5)
+	params = dhcp.GetDaemonEffectiveOptionsParams{
+		ID:       daemon.ID,
+		SubnetID: &subnetID,
+	}
+	rsp = rapi.GetDaemonEffectiveOptions(ctx, params)
+	require.IsType(t, &dhcp.GetDaemonEffectiveOptionsDefault{}, rsp)
+	defaultRsp = rsp.(*dhcp.GetDaemonEffectiveOptionsDefault)
+	require.Equal(t, http.StatusBadRequest, getStatusCode(*defaultRsp))
+}
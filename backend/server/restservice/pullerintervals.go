@@ -0,0 +1,138 @@
+package restservice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/go-openapi/runtime/middleware"
+	log "github.com/sirupsen/logrus"
+
+	dbmodel "isc.org/stork/server/database/model"
+	"isc.org/stork/server/gen/models"
+	"isc.org/stork/server/gen/restapi/operations/settings"
+)
+
+// Convert puller interval override from the database to the REST API format.
+func pullerIntervalToRestAPI(dbInterval *dbmodel.PullerInterval) *models.PullerInterval {
+	puller := dbInterval.Puller
+	interval := dbInterval.Interval
+	return &models.PullerInterval{
+		ID:        dbInterval.ID,
+		Puller:    &puller,
+		MachineID: dbInterval.MachineID,
+		AppID:     dbInterval.AppID,
+		Interval:  &interval,
+	}
+}
+
+// Convert puller interval override from the REST API format to the database
+// format and validate it.
+func pullerIntervalFromRestAPI(pullerInterval *models.PullerInterval) (*dbmodel.PullerInterval, error) {
+	if pullerInterval == nil || pullerInterval.Puller == nil || pullerInterval.Interval == nil {
+		return nil, errors.New("missing puller name or interval")
+	}
+	dbInterval := &dbmodel.PullerInterval{
+		Puller:    *pullerInterval.Puller,
+		MachineID: pullerInterval.MachineID,
+		AppID:     pullerInterval.AppID,
+		Interval:  *pullerInterval.Interval,
+	}
+	if err := dbInterval.Validate(); err != nil {
+		return nil, err
+	}
+	return dbInterval, nil
+}
+
+// Get the overrides of the puller intervals for the machines and apps.
+func (r *RestAPI) GetPullerIntervals(ctx context.Context, params settings.GetPullerIntervalsParams) middleware.Responder {
+	dbIntervals, err := dbmodel.GetPullerIntervals(r.DB)
+	if err != nil {
+		log.Error(err)
+		msg := "cannot get puller intervals from db"
+		rsp := settings.NewGetPullerIntervalsDefault(http.StatusInternalServerError).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+
+	intervals := &models.PullerIntervals{
+		Total: int64(len(dbIntervals)),
+	}
+	for i := range dbIntervals {
+		intervals.Items = append(intervals.Items, pullerIntervalToRestAPI(&dbIntervals[i]))
+	}
+	rsp := settings.NewGetPullerIntervalsOK().WithPayload(intervals)
+	return rsp
+}
+
+// Set the puller interval for a machine or an app. It updates the existing
+// override for the same puller and machine or app.
+func (r *RestAPI) SetPullerInterval(ctx context.Context, params settings.SetPullerIntervalParams) middleware.Responder {
+	dbInterval, err := pullerIntervalFromRestAPI(params.PullerInterval)
+	if err != nil {
+		msg := fmt.Sprintf("invalid puller interval: %s", err)
+		rsp := settings.NewSetPullerIntervalDefault(http.StatusBadRequest).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+
+	// Check if the machine or the app exists to return a meaningful error.
+	exists := false
+	if dbInterval.AppID != 0 {
+		var app *dbmodel.App
+		app, err = dbmodel.GetAppByID(r.DB, dbInterval.AppID)
+		exists = app != nil
+	} else {
+		var machine *dbmodel.Machine
+		machine, err = dbmodel.GetMachineByID(r.DB, dbInterval.MachineID)
+		exists = machine != nil
+	}
+	if err != nil {
+		log.Error(err)
+		msg := "cannot get machine or app for puller interval from db"
+		rsp := settings.NewSetPullerIntervalDefault(http.StatusInternalServerError).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+	if !exists {
+		msg := "cannot find machine or app for puller interval"
+		rsp := settings.NewSetPullerIntervalDefault(http.StatusNotFound).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+
+	err = dbmodel.SetPullerInterval(r.DB, dbInterval)
+	if err != nil {
+		log.Error(err)
+		msg := fmt.Sprintf("cannot store interval for puller %s", dbInterval.Puller)
+		rsp := settings.NewSetPullerIntervalDefault(http.StatusInternalServerError).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+
+	rsp := settings.NewSetPullerIntervalOK().WithPayload(pullerIntervalToRestAPI(dbInterval))
+	return rsp
+}
+
+// Delete the puller interval override. The machine or the app uses the
+// global interval or the interval of its machine afterwards.
+func (r *RestAPI) DeletePullerInterval(ctx context.Context, params settings.DeletePullerIntervalParams) middleware.Responder {
+	err := dbmodel.DeletePullerInterval(r.DB, params.ID)
+	if err != nil && !errors.Is(err, dbmodel.ErrNotExists) {
+		log.Error(err)
+		msg := fmt.Sprintf("cannot delete puller interval with id %d", params.ID)
+		rsp := settings.NewDeletePullerIntervalDefault(http.StatusInternalServerError).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+
+	rsp := settings.NewDeletePullerIntervalOK()
+	return rsp
+}
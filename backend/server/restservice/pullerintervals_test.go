@@ -0,0 +1,102 @@
+package restservice
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	agentcommtest "isc.org/stork/server/agentcomm/test"
+	dbmodel "isc.org/stork/server/database/model"
+	dbtest "isc.org/stork/server/database/test"
+	"isc.org/stork/server/gen/models"
+	"isc.org/stork/server/gen/restapi/operations/settings"
+	storktest "isc.org/stork/server/test"
+)
+
+// Check that the puller interval overrides can be set, get and deleted
+// via the REST API.
+func TestPullerIntervals(t *testing.T) {
+	db, dbSettings, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	rSettings := RestAPISettings{}
+	fa := agentcommtest.NewFakeAgents(nil, nil)
+	fec := &storktest.FakeEventCenter{}
+	fd := &storktest.FakeDispatcher{}
+	rapi, err := NewRestAPI(&rSettings, dbSettings, db, fa, fec, fd)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	machine := &dbmodel.Machine{
+		Address:   "localhost",
+		AgentPort: 8080,
+	}
+	require.NoError(t, dbmodel.AddMachine(db, machine))
+
+	// unknown puller is rejected
+	puller := "foo_puller_interval"
+	interval := int64(600)
+	setParams := settings.SetPullerIntervalParams{
+		PullerInterval: &models.PullerInterval{
+			Puller:    &puller,
+			MachineID: machine.ID,
+			Interval:  &interval,
+		},
+	}
+	rsp := rapi.SetPullerInterval(ctx, setParams)
+	require.IsType(t, &settings.SetPullerIntervalDefault{}, rsp)
+	defaultRsp := rsp.(*settings.SetPullerIntervalDefault)
+	require.Equal(t, http.StatusBadRequest, getStatusCode(*defaultRsp))
+
+	// non-existing machine
+	puller = "kea_hosts_puller_interval"
+	setParams.PullerInterval.MachineID = machine.ID + 1
+	rsp = rapi.SetPullerInterval(ctx, setParams)
+	require.IsType(t, &settings.SetPullerIntervalDefault{}, rsp)
+	defaultRsp = rsp.(*settings.SetPullerIntervalDefault)
+	require.Equal(t, http.StatusNotFound, getStatusCode(*defaultRsp))
+
+	// valid override
+	setParams.PullerInterval.MachineID = machine.ID
+	rsp = rapi.SetPullerInterval(ctx, setParams)
+	require.IsType(t, &settings.SetPullerIntervalOK{}, rsp)
+	setRsp := rsp.(*settings.SetPullerIntervalOK)
+	require.NotZero(t, setRsp.Payload.ID)
+	overrideID := setRsp.Payload.ID
+
+	// setting the interval again updates the override
+	interval = 0
+	rsp = rapi.SetPullerInterval(ctx, setParams)
+	require.IsType(t, &settings.SetPullerIntervalOK{}, rsp)
+	setRsp = rsp.(*settings.SetPullerIntervalOK)
+	require.Equal(t, overrideID, setRsp.Payload.ID)
+
+	rsp = rapi.GetPullerIntervals(ctx, settings.GetPullerIntervalsParams{})
+	require.IsType(t, &settings.GetPullerIntervalsOK{}, rsp)
+	intervals := rsp.(*settings.GetPullerIntervalsOK).Payload
+	require.EqualValues(t, 1, intervals.Total)
+	require.Len(t, intervals.Items, 1)
+	require.Equal(t, "kea_hosts_puller_interval", *intervals.Items[0].Puller)
+	require.Equal(t, machine.ID, intervals.Items[0].MachineID)
+	require.Zero(t, intervals.Items[0].AppID)
+	require.Zero(t, *intervals.Items[0].Interval)
+
+	// delete the override
+	deleteParams := settings.DeletePullerIntervalParams{
+		ID: overrideID,
+	}
+	rsp = rapi.DeletePullerInterval(ctx, deleteParams)
+	require.IsType(t, &settings.DeletePullerIntervalOK{}, rsp)
+
+	// deleting non-existing override is not an error
+	rsp = rapi.DeletePullerInterval(ctx, deleteParams)
+	require.IsType(t, &settings.DeletePullerIntervalOK{}, rsp)
+
+	rsp = rapi.GetPullerIntervals(ctx, settings.GetPullerIntervalsParams{})
+	require.IsType(t, &settings.GetPullerIntervalsOK{}, rsp)
+	intervals = rsp.(*settings.GetPullerIntervalsOK).Payload
+	require.Zero(t, intervals.Total)
+	require.Empty(t, intervals.Items)
+}
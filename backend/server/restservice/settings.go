@@ -33,6 +33,7 @@ func (r *RestAPI) GetSettings(ctx context.Context, params settings.GetSettingsPa
 		AppsStatePullerInterval:  dbSettingsMap["apps_state_puller_interval"].(int64),
 		PrometheusURL:            dbSettingsMap["prometheus_url"].(string),
 		MetricsCollectorInterval: dbSettingsMap["metrics_collector_interval"].(int64),
+		ConfigReviewInterval:     dbSettingsMap["config_review_interval"].(int64),
 	}
 	rsp := settings.NewGetSettingsOK().WithPayload(s)
 
@@ -96,6 +97,11 @@ func (r *RestAPI) UpdateSettings(ctx context.Context, params settings.UpdateSett
 		log.Error(err)
 		return errRsp
 	}
+	err = dbmodel.SetSettingInt(r.DB, "config_review_interval", s.ConfigReviewInterval)
+	if err != nil {
+		log.Error(err)
+		return errRsp
+	}
 
 	rsp := settings.NewUpdateSettingsOK()
 	return rsp
@@ -38,12 +38,14 @@ func TestSettings(t *testing.T) {
 	okRsp := rsp.(*settings.GetSettingsOK)
 	require.EqualValues(t, 60, okRsp.Payload.Bind9StatsPullerInterval)
 	require.Empty(t, okRsp.Payload.GrafanaURL)
+	require.Zero(t, okRsp.Payload.ConfigReviewInterval)
 
 	// update settings
 	paramsUS := settings.UpdateSettingsParams{
 		Settings: &models.Settings{
 			Bind9StatsPullerInterval: 10,
 			GrafanaURL:               "http://localhost:3000",
+			ConfigReviewInterval:     3600,
 		},
 	}
 	rsp = rapi.UpdateSettings(ctx, paramsUS)
@@ -56,4 +58,5 @@ func TestSettings(t *testing.T) {
 	okRsp = rsp.(*settings.GetSettingsOK)
 	require.EqualValues(t, 10, okRsp.Payload.Bind9StatsPullerInterval)
 	require.EqualValues(t, "http://localhost:3000", okRsp.Payload.GrafanaURL)
+	require.EqualValues(t, 3600, okRsp.Payload.ConfigReviewInterval)
 }
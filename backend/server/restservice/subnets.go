@@ -2,11 +2,13 @@ package restservice
 
 import (
 	"context"
+	"fmt"
 	"net/http"
 
 	"github.com/go-openapi/runtime/middleware"
 	"github.com/go-openapi/strfmt"
 	log "github.com/sirupsen/logrus"
+	"isc.org/stork/server/apps/kea"
 	dbmodel "isc.org/stork/server/database/model"
 
 	"isc.org/stork/server/gen/models"
@@ -103,6 +105,58 @@ func (r *RestAPI) GetSubnets(ctx context.Context, params dhcp.GetSubnetsParams)
 	return rsp
 }
 
+// Get free addresses and delegated prefixes in the DHCP subnet. The
+// addresses are neither reserved nor leased by the Kea servers.
+func (r *RestAPI) GetSubnetFreeAddresses(ctx context.Context, params dhcp.GetSubnetFreeAddressesParams) middleware.Responder {
+	count := kea.DefaultFreeAddressesCount
+	if params.Count != nil {
+		count = int(*params.Count)
+	}
+
+	free, erredApps, err := kea.FindFreeAddresses(ctx, r.DB, r.Agents, params.ID, count)
+	if err != nil {
+		msg := fmt.Sprintf("cannot find free addresses in subnet with ID %d", params.ID)
+		log.Error(err)
+		rsp := dhcp.NewGetSubnetFreeAddressesDefault(http.StatusInternalServerError).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+	if free == nil {
+		msg := fmt.Sprintf("cannot find subnet with ID %d", params.ID)
+		rsp := dhcp.NewGetSubnetFreeAddressesDefault(http.StatusNotFound).WithPayload(&models.APIError{
+			Message: &msg,
+		})
+		return rsp
+	}
+
+	addresses := &models.FreeAddresses{
+		InPool:    free.InPool,
+		OutOfPool: free.OutOfPool,
+		Prefixes:  free.Prefixes,
+	}
+
+	// Record apps for which there was an error communicating with the Kea servers.
+	for i := range erredApps {
+		addresses.ErredApps = append(addresses.ErredApps, &models.LeasesSearchErredApp{
+			ID:   &erredApps[i].ID,
+			Name: &erredApps[i].Name,
+		})
+	}
+
+	// Record apps which lack the lease_cmds hooks library, so their leases
+	// were not checked.
+	for i := range free.UnverifiedApps {
+		addresses.UnverifiedApps = append(addresses.UnverifiedApps, &models.LeasesSearchErredApp{
+			ID:   &free.UnverifiedApps[i].ID,
+			Name: &free.UnverifiedApps[i].Name,
+		})
+	}
+
+	rsp := dhcp.NewGetSubnetFreeAddressesOK().WithPayload(addresses)
+	return rsp
+}
+
 func (r *RestAPI) getSharedNetworks(offset, limit, appID, family int64, filterText *string, sortField string, sortDir dbmodel.SortDirEnum) (*models.SharedNetworks, error) {
 	// get shared networks from db
 	dbSharedNetworks, total, err := dbmodel.GetSharedNetworksByPage(r.DB, offset, limit, appID, family, filterText, sortField, sortDir)
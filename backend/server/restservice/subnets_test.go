@@ -2,6 +2,7 @@ package restservice
 
 import (
 	"context"
+	"net/http"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -520,3 +521,89 @@ func TestGetSharedNetworks(t *testing.T) {
 	require.Nil(t, okRsp.Payload.Items[1].Subnets[0].LocalSubnets[0].Stats)
 	require.ElementsMatch(t, []string{"mouse", "frog"}, []string{okRsp.Payload.Items[0].Name, okRsp.Payload.Items[1].Name})
 }
+
+// Check getting free addresses in the subnet via rest api functions.
+func TestGetSubnetFreeAddresses(t *testing.T) {
+	db, dbSettings, teardown := dbtest.SetupDatabaseTestCase(t)
+	defer teardown()
+
+	settings := RestAPISettings{}
+	fa := agentcommtest.NewFakeAgents(nil, nil)
+	fec := &storktest.FakeEventCenter{}
+	fd := &storktest.FakeDispatcher{}
+	rapi, err := NewRestAPI(&settings, dbSettings, db, fa, fec, nil, fd, nil)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	subnet := &dbmodel.Subnet{
+		Prefix: "2001:db8:1::/64",
+		AddressPools: []dbmodel.AddressPool{
+			{
+				LowerBound: "2001:db8:1::10",
+				UpperBound: "2001:db8:1::20",
+			},
+		},
+		PrefixPools: []dbmodel.PrefixPool{
+			{
+				Prefix:       "3000::/62",
+				DelegatedLen: 64,
+			},
+		},
+	}
+	err = dbmodel.AddSubnet(db, subnet)
+	require.NoError(t, err)
+
+	host := &dbmodel.Host{
+		SubnetID: subnet.ID,
+		HostIdentifiers: []dbmodel.HostIdentifier{
+			{
+				Type:  "duid",
+				Value: []byte{1, 2, 3, 4},
+			},
+		},
+		IPReservations: []dbmodel.IPReservation{
+			{
+				Address: "2001:db8:1::10",
+			},
+			{
+				Address: "2001:db8:1::1",
+			},
+			{
+				Address: "3000::/64",
+			},
+		},
+	}
+	err = dbmodel.AddHost(db, host)
+	require.NoError(t, err)
+
+	// get free addresses in non-existing subnet
+	count := int64(2)
+	params := dhcp.GetSubnetFreeAddressesParams{
+		ID:    subnet.ID + 1,
+		Count: &count,
+	}
+	rsp := rapi.GetSubnetFreeAddresses(ctx, params)
+	require.IsType(t, &dhcp.GetSubnetFreeAddressesDefault{}, rsp)
+	defaultRsp := rsp.(*dhcp.GetSubnetFreeAddressesDefault)
+	require.Equal(t, http.StatusNotFound, getStatusCode(*defaultRsp))
+
+	// get free addresses in the subnet
+	params.ID = subnet.ID
+	rsp = rapi.GetSubnetFreeAddresses(ctx, params)
+	require.IsType(t, &dhcp.GetSubnetFreeAddressesOK{}, rsp)
+	okRsp := rsp.(*dhcp.GetSubnetFreeAddressesOK)
+	require.Equal(t, []string{"2001:db8:1::11", "2001:db8:1::12"}, okRsp.Payload.InPool)
+	require.Equal(t, []string{"2001:db8:1::2", "2001:db8:1::3"}, okRsp.Payload.OutOfPool)
+	require.Equal(t, []string{"3000:0:0:1::/64", "3000:0:0:2::/64"}, okRsp.Payload.Prefixes)
+	require.Empty(t, okRsp.Payload.ErredApps)
+	require.Empty(t, okRsp.Payload.UnverifiedApps)
+
+	// the default count is used when it is not specified
+	params.Count = nil
+	rsp = rapi.GetSubnetFreeAddresses(ctx, params)
+	require.IsType(t, &dhcp.GetSubnetFreeAddressesOK{}, rsp)
+	okRsp = rsp.(*dhcp.GetSubnetFreeAddressesOK)
+	require.Len(t, okRsp.Payload.InPool, 10)
+	require.Len(t, okRsp.Payload.OutOfPool, 10)
+	require.Len(t, okRsp.Payload.Prefixes, 3)
+}
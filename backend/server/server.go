@@ -1,9 +1,11 @@
 package server
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"os"
+	"time"
 
 	flags "github.com/jessevdk/go-flags"
 	log "github.com/sirupsen/logrus"
@@ -18,8 +20,11 @@ import (
 	dbops "isc.org/stork/server/database"
 	dbmodel "isc.org/stork/server/database/model"
 	"isc.org/stork/server/eventcenter"
+	"isc.org/stork/server/leader"
 	"isc.org/stork/server/metrics"
 	"isc.org/stork/server/restservice"
+	"isc.org/stork/tracing"
+	storkutil "isc.org/stork/util"
 )
 
 // Global Stork Server state.
@@ -29,6 +34,7 @@ type StorkServer struct {
 
 	AgentsSettings agentcomm.AgentsSettings
 	Agents         agentcomm.ConnectedAgents
+	AgentTunnels   *agentcomm.TunnelServer
 
 	RestAPISettings restservice.RestAPISettings
 	RestAPI         *restservice.RestAPI
@@ -41,6 +47,13 @@ type StorkServer struct {
 	EventCenter eventcenter.EventCenter
 
 	ReviewDispatcher configreview.Dispatcher
+	PeriodicReviewer *configreview.PeriodicReviewer
+
+	TracingSettings TracingSettings
+	shutdownTracing func(context.Context) error
+
+	LeaderElectionSettings LeaderElectionSettings
+	LeaderElector          *leader.Elector
 }
 
 // Global server settings (called application settings in go-flags nomenclature).
@@ -49,6 +62,19 @@ type Settings struct {
 	EnableMetricsEndpoint bool `short:"m" long:"metrics" description:"enable Prometheus /metrics endpoint (no auth)" env:"STORK_SERVER_ENABLE_METRICS"`
 }
 
+// Settings of the OpenTelemetry tracing.
+type TracingSettings struct {
+	OTLPEndpoint string  `long:"tracing-otlp-endpoint" description:"the address of the OTLP collector receiving the traces over gRPC, e.g. localhost:4317; the traces are not exported if it is empty" default:"" env:"STORK_SERVER_TRACING_OTLP_ENDPOINT"`
+	OTLPInsecure bool    `long:"tracing-otlp-insecure" description:"disable TLS in the connection to the OTLP collector" env:"STORK_SERVER_TRACING_OTLP_INSECURE"`
+	SampleRatio  float64 `long:"tracing-sample-ratio" description:"the fraction of the traces started by the server which are sampled, from 0 to 1" default:"1" env:"STORK_SERVER_TRACING_SAMPLE_RATIO"`
+}
+
+// Settings of the leader election among the server instances connected
+// to the same database.
+type LeaderElectionSettings struct {
+	CheckInterval time.Duration `long:"leader-check-interval" description:"the interval at which the server instances connected to the same database check which of them is the leader; only the leader runs the pullers, the periodic configuration reviews and the metrics collector" default:"10s" env:"STORK_SERVER_LEADER_CHECK_INTERVAL"`
+}
+
 func (ss *StorkServer) ParseArgs() {
 	// Process command line flags.
 	var serverSettings Settings
@@ -74,6 +100,18 @@ func (ss *StorkServer) ParseArgs() {
 		log.Fatalf("FATAL error: %+v", err)
 	}
 
+	// Process leader election specific args.
+	_, err = parser.AddGroup("Leader Election Flags", "", &ss.LeaderElectionSettings)
+	if err != nil {
+		log.Fatalf("FATAL error: %+v", err)
+	}
+
+	// Process tracing specific args.
+	_, err = parser.AddGroup("Tracing Flags", "", &ss.TracingSettings)
+	if err != nil {
+		log.Fatalf("FATAL error: %+v", err)
+	}
+
 	// Do args parsing.
 	if _, err := parser.Parse(); err != nil {
 		code := 1
@@ -100,6 +138,19 @@ func NewStorkServer() (ss *StorkServer, err error) {
 	ss = &StorkServer{}
 	ss.ParseArgs()
 
+	// setup exporting the traces
+	ss.shutdownTracing, err = tracing.Setup("stork-server", tracing.Settings{
+		Endpoint:    ss.TracingSettings.OTLPEndpoint,
+		Insecure:    ss.TracingSettings.OTLPInsecure,
+		SampleRatio: ss.TracingSettings.SampleRatio,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if ss.TracingSettings.OTLPEndpoint != "" {
+		log.Infof("the traces are exported to the OTLP collector %s", ss.TracingSettings.OTLPEndpoint)
+	}
+
 	// setup database connection
 	ss.DB, err = dbops.NewPgDB(&ss.DBSettings)
 	if err != nil {
@@ -124,6 +175,12 @@ func NewStorkServer() (ss *StorkServer, err error) {
 
 	// setup connected agents
 	ss.Agents = agentcomm.NewConnectedAgents(&ss.AgentsSettings, ss.EventCenter, caCertPEM, serverCertPEM, serverKeyPEM)
+
+	// refuse the agents presenting the certs revoked by the server
+	err = agentcomm.ReloadRevokedCerts(ss.DB, ss.Agents)
+	if err != nil {
+		return nil, err
+	}
 	// TODO: if any operation below fails then this Shutdown here causes segfault.
 	// I do not know why and do not how to fix this. Commenting out for now.
 	// defer func() {
@@ -132,11 +189,26 @@ func NewStorkServer() (ss *StorkServer, err error) {
 	// 	}
 	// }()
 
+	// Accept the tunnels from the agents which cannot be reached by
+	// the server, e.g. because they are behind NAT.
+	if ss.AgentsSettings.TunnelPort != 0 {
+		ss.AgentTunnels, err = agentcomm.NewTunnelServer(&ss.AgentsSettings, ss.Agents, caCertPEM, serverCertPEM, serverKeyPEM)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	// Setup configuration review dispatcher.
 	ss.ReviewDispatcher = configreview.NewDispatcher(ss.DB)
 	configreview.RegisterDefaultCheckers(ss.ReviewDispatcher)
 	ss.ReviewDispatcher.Start()
 
+	// Setup periodic configuration reviews.
+	ss.PeriodicReviewer, err = configreview.NewPeriodicReviewer(ss.DB, ss.ReviewDispatcher)
+	if err != nil {
+		return nil, err
+	}
+
 	// initialize stork statistics
 	err = dbmodel.InitializeStats(ss.DB)
 	if err != nil {
@@ -176,7 +248,7 @@ func NewStorkServer() (ss *StorkServer, err error) {
 	}
 
 	if ss.EnableMetricsEndpoint {
-		ss.MetricsCollector, err = metrics.NewCollector(ss.DB)
+		ss.MetricsCollector, err = metrics.NewCollector(ss.DB, ss.Agents, ss.Pullers.GetExecutors()...)
 		if err != nil {
 			return nil, err
 		}
@@ -195,6 +267,7 @@ func NewStorkServer() (ss *StorkServer, err error) {
 		ss.Pullers.KeaStatsPuller.Shutdown()
 		ss.Pullers.Bind9StatsPuller.Shutdown()
 		ss.Pullers.AppsStatePuller.Shutdown()
+		ss.PeriodicReviewer.Shutdown()
 		if ss.MetricsCollector != nil {
 			ss.MetricsCollector.Shutdown()
 		}
@@ -206,11 +279,74 @@ func NewStorkServer() (ss *StorkServer, err error) {
 
 	ss.EventCenter.AddInfoEvent("started Stork Server", "version: "+stork.Version+"\nbuild date: "+stork.BuildDate)
 
+	// The background tasks are run only by the leader. They are resumed
+	// when this instance is elected. A single instance is elected before
+	// the election starts in the background.
+	ss.suspendLeaderTasks()
+	ss.LeaderElector = leader.NewElector(ss.DB, ss.LeaderElectionSettings.CheckInterval, ss.onElected, ss.onDeposed)
+	ss.LeaderElector.Start()
+
 	return ss, nil
 }
 
+// Returns the periodic executors run only by the leader.
+func (ss *StorkServer) getLeaderExecutors() []*storkutil.PeriodicExecutor {
+	return append(ss.Pullers.GetExecutors(), ss.PeriodicReviewer.PeriodicExecutor)
+}
+
+// Suspends the background tasks run only by the leader. The configuration
+// review dispatcher is not suspended because it also performs the reviews
+// requested over the REST API of this instance.
+func (ss *StorkServer) suspendLeaderTasks() {
+	for _, executor := range ss.getLeaderExecutors() {
+		executor.Suspend()
+	}
+	if ss.MetricsCollector != nil {
+		ss.MetricsCollector.Suspend()
+	}
+}
+
+// Resumes the background tasks run only by the leader.
+func (ss *StorkServer) resumeLeaderTasks() {
+	for _, executor := range ss.getLeaderExecutors() {
+		executor.Resume()
+	}
+	if ss.MetricsCollector != nil {
+		ss.MetricsCollector.Resume()
+	}
+}
+
+// Reloads the in-memory state which could have been changed by the
+// other instances sharing the database.
+func (ss *StorkServer) reloadSharedState() {
+	if err := agentcomm.ReloadRevokedCerts(ss.DB, ss.Agents); err != nil {
+		log.Warnf("problem with reloading revoked certs: %+v", err)
+	}
+}
+
+// Called when this instance becomes the leader.
+func (ss *StorkServer) onElected() {
+	ss.reloadSharedState()
+	ss.resumeLeaderTasks()
+	hostname, _ := os.Hostname()
+	ss.EventCenter.AddInfoEvent("Stork Server became the leader", "host: "+hostname)
+}
+
+// Called when this instance stops being the leader.
+func (ss *StorkServer) onDeposed() {
+	ss.suspendLeaderTasks()
+	ss.reloadSharedState()
+	hostname, _ := os.Hostname()
+	ss.EventCenter.AddWarningEvent("Stork Server is no longer the leader", "host: "+hostname)
+}
+
 // Run Stork Server.
 func (ss *StorkServer) Serve() {
+	// Start accepting the tunnels opened by the agents.
+	if ss.AgentTunnels != nil {
+		ss.AgentTunnels.Serve()
+	}
+
 	// Start listening for requests from ReST API.
 	err := ss.RestAPI.Serve()
 	if err != nil {
@@ -223,17 +359,25 @@ func (ss *StorkServer) Shutdown() {
 	ss.EventCenter.AddInfoEvent("shutting down Stork Server")
 	log.Println("Shutting down Stork Server")
 	ss.RestAPI.Shutdown()
+	ss.LeaderElector.Shutdown()
 	ss.Pullers.HAStatusPuller.Shutdown()
 	ss.Pullers.KeaHostsPuller.Shutdown()
 	ss.Pullers.KeaStatsPuller.Shutdown()
 	ss.Pullers.Bind9StatsPuller.Shutdown()
 	ss.Pullers.AppsStatePuller.Shutdown()
+	if ss.AgentTunnels != nil {
+		ss.AgentTunnels.Shutdown()
+	}
 	ss.Agents.Shutdown()
 	ss.EventCenter.Shutdown()
+	ss.PeriodicReviewer.Shutdown()
 	ss.ReviewDispatcher.Shutdown()
 	if ss.MetricsCollector != nil {
 		ss.MetricsCollector.Shutdown()
 	}
 	ss.DB.Close()
+	if err := ss.shutdownTracing(context.Background()); err != nil {
+		log.Warnf("problem with flushing the traces: %+v", err)
+	}
 	log.Println("Stork Server shut down")
 }
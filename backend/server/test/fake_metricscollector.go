@@ -25,3 +25,11 @@ func (c *FakeMetricsCollector) GetHTTPHandler(next http.Handler) http.Handler {
 func (c *FakeMetricsCollector) Shutdown() {
 	c.IsRunning = false
 }
+
+func (c *FakeMetricsCollector) Suspend() {
+	c.IsRunning = false
+}
+
+func (c *FakeMetricsCollector) Resume() {
+	c.IsRunning = true
+}
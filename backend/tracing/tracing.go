@@ -0,0 +1,125 @@
+package tracing
+
+// Functions to set up the OpenTelemetry tracing shared by the server and
+// the agent. The spans are exported to the OTLP collector over gRPC. The
+// trace context is propagated between the processes using the W3C Trace
+// Context headers, i.e. in the gRPC metadata and in the HTTP headers.
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.10.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"isc.org/stork"
+)
+
+// Name of the tracer creating the Stork spans.
+const tracerName = "isc.org/stork"
+
+// Settings of the trace exporting.
+type Settings struct {
+	// Address of the OTLP collector receiving the spans over gRPC,
+	// e.g. localhost:4317. The spans are not exported if it is empty.
+	Endpoint string
+	// Disables TLS in the connection to the collector.
+	Insecure bool
+	// Fraction of the traces that are sampled, from 0 to 1. The traces
+	// started by the other process are sampled according to the decision
+	// made by this process.
+	SampleRatio float64
+}
+
+// Sets up the global tracer provider exporting the spans to the OTLP
+// collector specified in the settings and the global propagator of the
+// trace context. The trace context is propagated even if the spans are
+// not exported, so the traces are not broken by the process which
+// doesn't export them. The returned function flushes the remaining spans
+// and stops exporting them. It should be called on shutdown.
+func Setup(serviceName string, settings Settings) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{}, propagation.Baggage{},
+	))
+
+	if settings.Endpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	options := []otlptracegrpc.Option{
+		otlptracegrpc.WithEndpoint(settings.Endpoint),
+	}
+	if settings.Insecure {
+		options = append(options, otlptracegrpc.WithInsecure())
+	}
+	// The exporter connects to the collector in the background, so it
+	// doesn't fail when the collector is not yet available.
+	exporter, err := otlptracegrpc.New(context.Background(), options...)
+	if err != nil {
+		return nil, errors.Wrapf(err, "problem with creating OTLP trace exporter for %s", settings.Endpoint)
+	}
+
+	provider := newTracerProvider(serviceName, settings.SampleRatio, sdktrace.WithBatcher(exporter))
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
+
+// Creates the tracer provider describing the service and sampling the
+// traces with the specified ratio.
+func newTracerProvider(serviceName string, sampleRatio float64, options ...sdktrace.TracerProviderOption) *sdktrace.TracerProvider {
+	res := resource.NewWithAttributes(semconv.SchemaURL,
+		semconv.ServiceNameKey.String(serviceName),
+		semconv.ServiceVersionKey.String(stork.Version),
+	)
+	options = append(options,
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(sampleRatio))),
+	)
+	return sdktrace.NewTracerProvider(options...)
+}
+
+// Starts a new span with the specified name and attributes. The span is
+// a child of the span in the context, if any. The returned context holds
+// the new span. The span must be ended with EndSpan.
+func StartSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// Ends the span. If the error is not nil, it is recorded in the span
+// and the span status is set to error.
+func EndSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Puts the trace context from the context into the HTTP headers.
+func InjectHTTPHeaders(ctx context.Context, header http.Header) {
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(header))
+}
+
+// Returns the trace context from the context as a map. It is used to
+// propagate the trace context over the channels which don't support
+// the gRPC metadata.
+func InjectMap(ctx context.Context) map[string]string {
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	return carrier
+}
+
+// Returns the context with the trace context taken from the map created
+// by InjectMap.
+func ExtractMap(ctx context.Context, values map[string]string) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, propagation.MapCarrier(values))
+}
@@ -0,0 +1,138 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	collectortrace "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/grpc"
+)
+
+// In-process OTLP collector recording the received spans.
+type testCollector struct {
+	collectortrace.UnimplementedTraceServiceServer
+	mutex sync.Mutex
+	spans []*tracepb.Span
+}
+
+// Records the exported spans.
+func (c *testCollector) Export(ctx context.Context, req *collectortrace.ExportTraceServiceRequest) (*collectortrace.ExportTraceServiceResponse, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, resourceSpans := range req.ResourceSpans {
+		for _, scopeSpans := range resourceSpans.ScopeSpans {
+			c.spans = append(c.spans, scopeSpans.Spans...)
+		}
+	}
+	return &collectortrace.ExportTraceServiceResponse{}, nil
+}
+
+// Returns the names of the received spans.
+func (c *testCollector) getSpanNames() []string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	var names []string
+	for _, span := range c.spans {
+		names = append(names, span.Name)
+	}
+	return names
+}
+
+// Starts the in-process collector listening on a random port.
+func startTestCollector(t *testing.T) (*testCollector, string, func()) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	collector := &testCollector{}
+	server := grpc.NewServer()
+	collectortrace.RegisterTraceServiceServer(server, collector)
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	return collector, listener.Addr().String(), server.Stop
+}
+
+// Test that the spans are exported to the OTLP collector.
+func TestSetupExportsSpans(t *testing.T) {
+	collector, endpoint, stop := startTestCollector(t)
+	defer stop()
+
+	shutdown, err := Setup("stork-test", Settings{
+		Endpoint:    endpoint,
+		Insecure:    true,
+		SampleRatio: 1,
+	})
+	require.NoError(t, err)
+
+	ctx, parent := StartSpan(context.Background(), "parent")
+	_, child := StartSpan(ctx, "child", attribute.String("key", "value"))
+	EndSpan(child, errors.New("child failed"))
+	EndSpan(parent, nil)
+
+	// Shutdown flushes the spans.
+	require.NoError(t, shutdown(context.Background()))
+	otel.SetTracerProvider(sdktrace.NewTracerProvider())
+
+	require.Eventually(t, func() bool {
+		return len(collector.getSpanNames()) == 2
+	}, 5*time.Second, 100*time.Millisecond)
+	require.ElementsMatch(t, []string{"parent", "child"}, collector.getSpanNames())
+}
+
+// Test that the trace context is propagated even if the spans are not
+// exported.
+func TestSetupWithoutEndpoint(t *testing.T) {
+	shutdown, err := Setup("stork-test", Settings{})
+	require.NoError(t, err)
+	require.NoError(t, shutdown(context.Background()))
+
+	exporter := tracetest.NewInMemoryExporter()
+	provider := newTracerProvider("stork-test", 1, sdktrace.WithSyncer(exporter))
+	otel.SetTracerProvider(provider)
+	defer otel.SetTracerProvider(sdktrace.NewTracerProvider())
+
+	ctx, span := StartSpan(context.Background(), "caller")
+	defer span.End()
+
+	// Propagation in the HTTP headers.
+	header := http.Header{}
+	InjectHTTPHeaders(ctx, header)
+	require.NotEmpty(t, header.Get("traceparent"))
+
+	// Propagation in the map.
+	values := InjectMap(ctx)
+	require.Contains(t, values, "traceparent")
+	ctx = ExtractMap(context.Background(), values)
+	_, remote := StartSpan(ctx, "callee")
+	EndSpan(remote, nil)
+
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 1)
+	require.Equal(t, "callee", spans[0].Name)
+	require.Equal(t, span.SpanContext().TraceID(), spans[0].SpanContext.TraceID())
+	require.Equal(t, span.SpanContext().SpanID(), spans[0].Parent.SpanID())
+	require.Equal(t, codes.Unset, spans[0].Status.Code)
+}
+
+// Test that the traces are sampled according to the ratio.
+func TestSampleRatio(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := newTracerProvider("stork-test", 0, sdktrace.WithSyncer(exporter))
+	otel.SetTracerProvider(provider)
+	defer otel.SetTracerProvider(sdktrace.NewTracerProvider())
+
+	_, span := StartSpan(context.Background(), "not sampled")
+	EndSpan(span, nil)
+	require.Empty(t, exporter.GetSpans())
+}
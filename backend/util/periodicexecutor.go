@@ -16,13 +16,20 @@ type PeriodicExecutor struct {
 	interval        int64
 	ticker          *time.Ticker
 	active          bool
+	suspended       bool
 	pauseCount      uint16
 	done            chan bool
 	wg              *sync.WaitGroup
 	mutex           *sync.Mutex
 	getIntervalFunc func() (int64, error)
+	observer        PeriodicExecutorObserver
 }
 
+// Function called after each execution of the executor function. It
+// receives the executor name, the execution duration and the error
+// returned by the executor function.
+type PeriodicExecutorObserver func(name string, duration time.Duration, err error)
+
 const InactiveInterval int64 = 60
 
 // Creates an instance of a new periodic executor. The periodic executor offers a mechanism
@@ -134,6 +141,52 @@ func (executor *PeriodicExecutor) Unpause(interval ...int64) {
 	executor.unpause(true, interval...)
 }
 
+// Suspends the executor until Resume is called. The suspended executor
+// doesn't execute the function but, unlike the paused executor, it keeps
+// tracking the interval changes. It is not affected by Pause, Unpause and
+// Reset. It is used to stop the executors of the server which is not the
+// leader.
+func (executor *PeriodicExecutor) Suspend() {
+	executor.mutex.Lock()
+	defer executor.mutex.Unlock()
+	executor.suspended = true
+}
+
+// Resumes the executor suspended with Suspend.
+func (executor *PeriodicExecutor) Resume() {
+	executor.mutex.Lock()
+	defer executor.mutex.Unlock()
+	executor.suspended = false
+}
+
+// Checks if the executor is currently suspended.
+func (executor *PeriodicExecutor) Suspended() bool {
+	executor.mutex.Lock()
+	defer executor.mutex.Unlock()
+	return executor.suspended
+}
+
+// Returns the executor name.
+func (executor *PeriodicExecutor) GetName() string {
+	return executor.name
+}
+
+// Sets the function called after each execution of the executor function.
+// It is typically used to collect the metrics of the executions. Setting
+// nil removes the observer.
+func (executor *PeriodicExecutor) SetObserver(observer PeriodicExecutorObserver) {
+	executor.mutex.Lock()
+	defer executor.mutex.Unlock()
+	executor.observer = observer
+}
+
+// Returns the observer under the mutex.
+func (executor *PeriodicExecutor) getObserver() PeriodicExecutorObserver {
+	executor.mutex.Lock()
+	defer executor.mutex.Unlock()
+	return executor.observer
+}
+
 // Return the current interval in seconds.
 func (executor *PeriodicExecutor) GetInterval() int64 {
 	executor.mutex.Lock()
@@ -159,15 +212,20 @@ func (executor *PeriodicExecutor) executorLoop() {
 		select {
 		// every N seconds execute user defined function
 		case <-executor.ticker.C:
-			if executor.active {
+			if executor.active && !executor.Suspended() {
 				// Temporarily stop the executor while running the external action.
 				// It will be resumed when the action ends.
 				executor.Pause()
+				started := time.Now()
 				err := executor.executorFunc()
+				duration := time.Since(started)
 				executor.Unpause()
 				if err != nil {
 					log.Errorf("errors were encountered while pulling data from apps: %+v", err)
 				}
+				if observer := executor.getObserver(); observer != nil {
+					observer(executor.name, duration, err)
+				}
 			}
 		// wait for done signal from shutdown function
 		case <-executor.done:
@@ -177,10 +235,12 @@ func (executor *PeriodicExecutor) executorLoop() {
 		}
 
 		// Check if the interval has changed. If so, recreate the ticker.
+		// In case of an error, keep the previous interval and try again
+		// after the next tick.
 		interval, err := executor.getIntervalFunc()
 		if err != nil {
 			log.Errorf("problem with getting interval: %+v", err)
-			return
+			continue
 		}
 
 		executor.mutex.Lock()
@@ -1,6 +1,7 @@
 package storkutil
 
 import (
+	"errors"
 	"sync"
 	"sync/atomic"
 	"testing"
@@ -159,3 +160,100 @@ func TestGetInterval(t *testing.T) {
 	}, 5*time.Second, time.Second,
 		"test executor did not update the interval")
 }
+
+// Test that the executor keeps running with the previous interval when
+// getting the interval fails.
+func TestGetIntervalError(t *testing.T) {
+	// Arrange
+	var failing int32
+	getIntervalFunc := func() (int64, error) {
+		if atomic.LoadInt32(&failing) == 1 {
+			return 0, errors.New("test error")
+		}
+		return 1, nil
+	}
+	var calls int32
+	executor, _ := NewPeriodicExecutor("", func() error {
+		atomic.AddInt32(&calls, 1)
+		return nil
+	}, getIntervalFunc)
+	defer executor.Shutdown()
+
+	// Act
+	atomic.StoreInt32(&failing, 1)
+
+	// Assert
+	require.Eventually(t, func() bool {
+		return atomic.LoadInt32(&calls) >= 3
+	}, 10*time.Second, 100*time.Millisecond,
+		"test executor stopped after the interval error")
+	require.EqualValues(t, 1, executor.GetInterval())
+}
+
+// Test that the observer is called after each execution of the executor
+// function.
+func TestObserver(t *testing.T) {
+	// Arrange
+	getIntervalFunc := func() (int64, error) { return 1, nil }
+	executor, _ := NewPeriodicExecutor("observed executor", func() error {
+		return errors.New("test error")
+	}, getIntervalFunc)
+	defer executor.Shutdown()
+
+	type observation struct {
+		name string
+		err  error
+	}
+	observations := make(chan observation, 10)
+
+	// Act
+	executor.SetObserver(func(name string, duration time.Duration, err error) {
+		observations <- observation{name, err}
+	})
+
+	// Assert
+	require.Equal(t, "observed executor", executor.GetName())
+	select {
+	case o := <-observations:
+		require.Equal(t, "observed executor", o.name)
+		require.EqualError(t, o.err, "test error")
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "observer was not called")
+	}
+}
+
+// Test that the suspended executor doesn't execute the function until
+// it is resumed.
+func TestSuspendAndResume(t *testing.T) {
+	// Arrange
+	getIntervalFunc := func() (int64, error) { return 1, nil }
+	var callCount int64
+	executor, _ := NewPeriodicExecutor("suspended executor", func() error {
+		atomic.AddInt64(&callCount, 1)
+		return nil
+	}, getIntervalFunc)
+	defer executor.Shutdown()
+
+	// Act
+	executor.Suspend()
+	atomic.StoreInt64(&callCount, 0)
+
+	// Assert
+	require.True(t, executor.Suspended())
+	// Neither unpausing nor resetting the executor resumes it.
+	executor.Pause()
+	executor.Unpause()
+	executor.Reset(1)
+	time.Sleep(2500 * time.Millisecond)
+	require.Zero(t, atomic.LoadInt64(&callCount))
+	require.True(t, executor.Suspended())
+
+	// Act
+	executor.Resume()
+
+	// Assert
+	require.False(t, executor.Suspended())
+	require.Eventually(t, func() bool {
+		return atomic.LoadInt64(&callCount) > 0
+	}, 5*time.Second, 100*time.Millisecond)
+}
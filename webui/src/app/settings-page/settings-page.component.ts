@@ -22,6 +22,7 @@ export class SettingsPageComponent implements OnInit {
             kea_hosts_puller_interval: ['', [Validators.required, Validators.min(0)]],
             kea_stats_puller_interval: ['', [Validators.required, Validators.min(0)]],
             kea_status_puller_interval: ['', [Validators.required, Validators.min(0)]],
+            config_review_interval: ['', [Validators.required, Validators.min(0)]],
             prometheus_url: [''],
         })
     }
@@ -34,6 +35,7 @@ export class SettingsPageComponent implements OnInit {
                     'kea_hosts_puller_interval',
                     'kea_stats_puller_interval',
                     'kea_status_puller_interval',
+                    'config_review_interval',
                 ]
                 const stringSettings = ['grafana_url', 'prometheus_url']
 
@@ -227,10 +227,31 @@ export function daemonStatusErred(daemon) {
         (daemon.caCommErrors && daemon.caCommErrors > 0) ||
         (daemon.daemonCommErrors && daemon.daemonCommErrors > 0) ||
         (daemon.rndcCommErrors && daemon.rndcCommErrors > 0) ||
-        (daemon.statsCommErrors && daemon.statsCommErrors > 0)
+        (daemon.statsCommErrors && daemon.statsCommErrors > 0) ||
+        daemonCommSuspended(daemon)
     )
 }
 
+/**
+ * Checks if the communication with the daemon has been suspended by the
+ * server after repeated failures.
+ *
+ * @param daemon data structure holding the information about the daemon.
+ *
+ * @return true if the communication with the agent, the Kea Control Agent
+ *         or the daemon is suspended, false otherwise.
+ */
+export function daemonCommSuspended(daemon) {
+    const states = [
+        daemon.agentCommState,
+        daemon.caCommState,
+        daemon.daemonCommState,
+        daemon.rndcCommState,
+        daemon.statsCommState,
+    ]
+    return states.some((state) => state === 'open' || state === 'half-open')
+}
+
 /**
  * Returns the name of the icon to be used to indicate daemon status
  *
@@ -283,6 +304,25 @@ export function daemonStatusIconColor(daemon) {
  *          hint whether the communication is with the agent or daemon.
  */
 export function daemonStatusIconTooltip(daemon) {
+    const tooltip = daemonCommTooltip(daemon)
+    if (daemon.monitored && daemonCommSuspended(daemon)) {
+        return (
+            tooltip +
+            ' The communication has been suspended after repeated failures ' +
+            'and it is periodically retried.'
+        )
+    }
+    return tooltip
+}
+
+/**
+ * Returns the tooltip describing the communication issues with the daemon.
+ *
+ * @param daemon data structure holding the information about the daemon.
+ *
+ * @returns The tooltip text.
+ */
+function daemonCommTooltip(daemon) {
     if (!daemon.monitored) {
         return 'Monitoring of this daemon has been disabled. You can enable it on the daemon tab on the Kea app page.'
     }